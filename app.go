@@ -3,15 +3,17 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sitemvp/downloader"
 	proccesor "sitemvp/processor"
+	"sitemvp/server"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,13 +23,31 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// serverReadyTimeout bounds how long LaunchSite waits for the server to answer
+const serverReadyTimeout = 3 * time.Second
+
 // App struct
 type App struct {
-	ctx         context.Context
-	server      *http.Server
-	activeJobs  sync.Map // Map for tracking active adaptation jobs
-	mu          sync.Mutex
-	servingPath string // Path of the site currently being served
+	ctx        context.Context
+	server     *server.ManagedServer
+	library    *libraryCache
+	activeJobs sync.Map // Map for tracking active adaptation jobs
+	downloads  *downloader.Manager
+	mu         sync.Mutex
+
+	control      *http.Server // Local control API, nil when off
+	controlMu    sync.Mutex
+	bookmarklets *bookmarkletTokens // Tokens of copied bookmarklets, see control.go
+
+	launched   map[string]launchParams // Normalized root URL → how it was last started
+	launchedMu sync.Mutex
+}
+
+// launchParams are the output folder and form options a site was started
+// with; RecrawlURLs reuses them
+type launchParams struct {
+	outputDir string
+	opts      DownloadOptions
 }
 
 // SiteMeta represents a downloaded site
@@ -37,34 +57,47 @@ type SiteMeta struct {
 	Icon      string `json:"icon"`      // Base64 icon data
 	Domain    string `json:"domain"`    // Reconstructed visual path
 	EntryPath string `json:"entryPath"` // Relative path to index.html
+	Scanning  bool   `json:"scanning"`  // Icon/entry path still being computed
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	a := &App{server: server.New(), downloads: downloader.NewManager(downloader.DefaultMaxRunningJobs), bookmarklets: newBookmarkletTokens()}
+	a.library = newLibraryCache(configPath(libraryCacheFile), loadSettings().ScanConcurrency, func(path string) siteDetails {
+		return siteDetails{Icon: a.getSiteIcon(path), EntryPath: a.getEntryPath(path)}
+	})
+	return a
 }
 
 // startup is called when the app starts. The context is saved
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
-}
-
-// DownloadSite starts the download process
-func (a *App) DownloadSite(urlStr string, outputDir string) string {
-	if urlStr == "" {
-		return "Error: URL is empty"
+	a.server.OnError = func(err error, prev server.Status) {
+		runtime.EventsEmit(a.ctx, "server:error", err.Error())
+		runtime.EventsEmit(a.ctx, "server:stopped", "ERROR")
 	}
-	if outputDir == "" {
-		outputDir = "downloads"
+	a.library.onUpdate = func(path string, d siteDetails) {
+		runtime.EventsEmit(a.ctx, "library:item-updated", map[string]string{
+			"path":      path,
+			"icon":      d.Icon,
+			"entryPath": d.EntryPath,
+		})
 	}
 
-	normalizedURL, _ := downloader.NormalizeURL(urlStr)
-	if _, busy := a.activeJobs.LoadOrStore("dl:"+normalizedURL, true); busy {
-		return "Download already in progress"
+	settingsMu.Lock()
+	s := loadSettings()
+	settingsMu.Unlock()
+	if err := a.applyControlAPI(s); err != nil {
+		log.Printf("[System] %v", err)
 	}
+}
 
-	cfg := downloader.Config{
+// crawlConfig is the downloader configuration used by the app. Network
+// probes during processing are built from it too, so they send the same
+// headers as the crawl.
+func crawlConfig(outputDir string) downloader.Config {
+	return downloader.Config{
 		OutputDir:   outputDir,
 		Workers:     10,
 		Retries:     5,
@@ -72,74 +105,445 @@ func (a *App) DownloadSite(urlStr string, outputDir string) string {
 		Delay:       200 * time.Millisecond,
 		MaxFileSize: downloader.DefaultMaxFileSize,
 		UserAgent:   downloader.DefaultUserAgent,
+		// Same asset under several URLs is stored once
+		DedupeContent: true,
+	}
+}
+
+// appLogger writes the crawl log to the default downloader.log and also
+// forwards warnings and errors (HTTP failures, state write errors) to the
+// GUI log pane, which otherwise only sees per-file job messages.
+type appLogger struct {
+	downloader.Logger
+	ctx context.Context
+}
+
+func newAppLogger(ctx context.Context, cfg downloader.Config) *appLogger {
+	return &appLogger{Logger: downloader.DefaultLogger(cfg), ctx: ctx}
+}
+
+func (l *appLogger) Warn(format string, args ...any) {
+	l.Logger.Warn(format, args...)
+	runtime.EventsEmit(l.ctx, "download:log", "[Warn] "+fmt.Sprintf(format, args...))
+}
+
+func (l *appLogger) Error(format string, args ...any) {
+	l.Logger.Error(format, args...)
+	runtime.EventsEmit(l.ctx, "download:log", "[Error] "+fmt.Sprintf(format, args...))
+}
+
+// errDownloadBusy is returned when the URL is already being downloaded
+var errDownloadBusy = errors.New("download already in progress")
+
+// DownloadOptions are the optional crawl settings of the download form
+type DownloadOptions struct {
+	// Sent with every request (e.g. Authorization); override the default
+	// Accept-Language etc.
+	Headers map[string]string `json:"headers"`
+	// URL patterns: globs over the path or "re:" regexps, see downloader
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Never download URLs containing one of these substrings
+	Block []string `json:"block"`
+	// Mirror CSS/JS/fonts from CDNs; ExtraDomains limits it to those hosts
+	ExternalAssets bool     `json:"externalAssets"`
+	ExtraDomains   []string `json:"extraDomains"`
+	// Crawl blog.example.com etc. too; www and the bare domain always match
+	IncludeSubdomains bool `json:"includeSubdomains"`
+	// Queue URLs found in JS/JSON string literals
+	ParseJavaScript bool `json:"parseJavaScript"`
+	// Save just this page with its assets; links to other pages stay absolute
+	SinglePage bool `json:"singlePage"`
+	// Browser to present as (see downloader.UAPresets); empty = default UA
+	UAPreset string `json:"uaPreset"`
+
+	// pool is the worker budget shared by the sites of one DownloadSite batch
+	pool *downloader.WorkerPool
+}
+
+// DownloadSite starts downloading one or several sites. Each URL is a job
+// of its own with its own state file; the jobs of a batch share one worker
+// budget, and jobs of the same site run one after another.
+func (a *App) DownloadSite(urls []string, outputDir string, opts DownloadOptions) string {
+	var roots []string
+	for _, u := range urls {
+		if u = strings.TrimSpace(u); u != "" {
+			roots = append(roots, u)
+		}
+	}
+	if len(roots) == 0 {
+		return "Error: URL is empty"
+	}
+	headers, err := cleanHeaders(opts.Headers)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	opts.Headers = headers
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		if err := downloader.ValidatePatterns(patterns); err != nil {
+			return "Error: " + err.Error()
+		}
+	}
+	if len(roots) > 1 {
+		opts.pool = downloader.NewWorkerPool(crawlConfig(outputDir).Workers)
+	}
+
+	started := 0
+	var firstErr string
+	for _, u := range roots {
+		_, err := a.launchDownload(u, outputDir, nil, opts)
+		switch {
+		case err == nil:
+			started++
+		case firstErr != "":
+		case errors.Is(err, errDownloadBusy):
+			firstErr = "Download already in progress"
+		default:
+			firstErr = "Error: " + err.Error()
+		}
+	}
+	switch {
+	case started == 0:
+		return firstErr
+	case len(roots) > 1:
+		return fmt.Sprintf("Download started: %d of %d sites", started, len(roots))
+	}
+	return "Download started"
+}
+
+// UserAgentPresets lists the browser profiles the download form offers
+func (a *App) UserAgentPresets() []string {
+	return downloader.UAPresets()
+}
+
+// RecrawlURLs downloads only the given pages of an already crawled site,
+// e.g. the missed pages of its sitemap coverage report. Links on them are
+// not followed; the site's manifest and coverage report are updated.
+func (a *App) RecrawlURLs(urlStr string, urls []string) string {
+	if urlStr == "" || len(urls) == 0 {
+		return "Error: nothing to download"
+	}
+	params, err := a.recrawlParams(urlStr)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if _, err := a.launchDownload(urlStr, params.outputDir, urls, params.opts); err != nil {
+		if errors.Is(err, errDownloadBusy) {
+			return "Download already in progress"
+		}
+		return "Error: " + err.Error()
+	}
+	return "Download started"
+}
+
+// recrawlParams returns the output folder and options the site was crawled
+// with: from this session if it was started here, otherwise from its state
+// file in the default downloads folder. Headers are not stored there.
+func (a *App) recrawlParams(urlStr string) (launchParams, error) {
+	key, _ := downloader.NormalizeURL(urlStr)
+	a.launchedMu.Lock()
+	params, ok := a.launched[key]
+	a.launchedMu.Unlock()
+	if ok {
+		return params, nil
 	}
+	return storedLaunch("downloads", urlStr)
+}
 
-	// The new go func block replaces the existing two go func blocks
-	go func() {
-		// Defensive cleanup
-		defer func() {
-			a.activeJobs.Delete("dl:" + normalizedURL)
-			runtime.EventsEmit(a.ctx, "download:done", normalizedURL)
-			runtime.EventsEmit(a.ctx, "library:refresh", "DONE") // Added this from original defer
-			log.Printf("[System] Job for %s cleaned up", normalizedURL)
-		}()
+// storedLaunch reads the launch parameters of urlStr's job from its state file in dir
+func storedLaunch(dir, urlStr string) (launchParams, error) {
+	stateFile, err := downloader.FindJobState(dir, urlStr)
+	if err != nil {
+		return launchParams{}, err
+	}
+	state, err := downloader.LoadJobState(stateFile)
+	if err != nil {
+		return launchParams{}, err
+	}
+	return launchParams{outputDir: state.Config.OutputDir, opts: optionsFromConfig(state.Config)}, nil
+}
 
-		runtime.EventsEmit(a.ctx, "download:start", normalizedURL)
+// optionsFromConfig is the form options a stored job was started with
+func optionsFromConfig(cfg downloader.Config) DownloadOptions {
+	return DownloadOptions{
+		Headers:           cfg.Headers,
+		Include:           cfg.IncludePatterns,
+		Exclude:           cfg.ExcludePatterns,
+		Block:             cfg.BlockedURLSubstrings,
+		ExternalAssets:    cfg.DownloadExternalAssets,
+		ExtraDomains:      cfg.ExtraDomains,
+		IncludeSubdomains: cfg.IncludeSubdomains,
+		ParseJavaScript:   cfg.ParseJavaScript,
+		SinglePage:        cfg.SinglePage,
+		UAPreset:          cfg.UAPreset,
+	}
+}
+
+// startDownload hands the crawl to the job manager; it runs in the background
+// once a slot is free
+func (a *App) startDownload(urlStr string, outputDir string) (downloader.JobInfo, error) {
+	return a.launchDownload(urlStr, outputDir, nil, DownloadOptions{})
+}
 
-		job, err := downloader.NewJob(urlStr, cfg)
+// cleanHeaders validates header names from the frontend and canonicalizes them
+func cleanHeaders(in map[string]string) (map[string]string, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		name, value, err := downloader.ParseHeader(k + ": " + v)
 		if err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// launchDownload is startDownload with an optional list of target URLs
+// that replaces crawling from urlStr and the form's crawl options
+func (a *App) launchDownload(urlStr string, outputDir string, targets []string, opts DownloadOptions) (downloader.JobInfo, error) {
+	if outputDir == "" {
+		outputDir = "downloads"
+	}
+
+	cfg := crawlConfig(outputDir)
+	settingsMu.Lock()
+	cfg.SharedCache = loadSettings().SharedCache
+	settingsMu.Unlock()
+	cfg.Headers = opts.Headers
+	cfg.IncludePatterns = opts.Include
+	cfg.ExcludePatterns = opts.Exclude
+	cfg.BlockedURLSubstrings = opts.Block
+	cfg.DownloadExternalAssets = opts.ExternalAssets
+	cfg.ExtraDomains = opts.ExtraDomains
+	cfg.IncludeSubdomains = opts.IncludeSubdomains
+	cfg.ParseJavaScript = opts.ParseJavaScript
+	cfg.SinglePage = opts.SinglePage
+	cfg.UAPreset = opts.UAPreset
+	cfg.WorkerPool = opts.pool
+	if opts.pool != nil {
+		// Lines of the batch's jobs interleave in the shared log
+		if u, err := url.Parse(urlStr); err == nil {
+			cfg.LogPrefix = u.Host
+		}
+	}
+	cfg.Logger = newAppLogger(a.ctx, cfg)
+
+	normalizedURL, _ := downloader.NormalizeURL(urlStr)
+	info, err := a.downloads.Start(a.ctx, downloader.RunOptions{
+		URL:     urlStr,
+		Config:  cfg,
+		Targets: targets,
+		OnStart: func(job *downloader.Job) {
+			runtime.EventsEmit(a.ctx, "download:start", normalizedURL)
+			// Логи и прогресс передаем в GUI
+			job.Subscribe(newDownloadListener(a.ctx, job.LogFile()))
+		},
+	}, func(_ downloader.Summary, err error) {
+		if err != nil && !errors.Is(err, context.Canceled) {
 			runtime.EventsEmit(a.ctx, "download:log", "[Error] "+err.Error())
-			return
 		}
+		runtime.EventsEmit(a.ctx, "download:done", normalizedURL)
+		runtime.EventsEmit(a.ctx, "library:refresh", "DONE")
+		log.Printf("[System] Job for %s cleaned up", normalizedURL)
+	})
+	if errors.Is(err, downloader.ErrJobActive) {
+		return info, errDownloadBusy
+	}
+	if err == nil && targets == nil {
+		opts.pool = nil
+		a.launchedMu.Lock()
+		if a.launched == nil {
+			a.launched = make(map[string]launchParams)
+		}
+		a.launched[normalizedURL] = launchParams{outputDir: outputDir, opts: opts}
+		a.launchedMu.Unlock()
+	}
+	return info, err
+}
 
-		    // Передаем логи в GUI
-		    go func() {
-		        for msg := range job.Events {
-		            runtime.EventsEmit(a.ctx, "download:log", msg)
-		        }
-		    }()
-
-		    // Передаем прогресс в GUI
-		    go func() {
-		        ticker := time.NewTicker(500 * time.Millisecond)
-		        defer ticker.Stop()
-
-		        for {
-		            select {
-		            case <-a.ctx.Done():
-		                return
-		            case <-ticker.C:
-		                stats := job.GetStats()
-		                runtime.EventsEmit(a.ctx, "download:progress", map[string]interface{}{
-		                    "current": stats.TotalFiles,
-		                    "total":   stats.TotalFiles,
-		                })
-		            }
-		        }
-		    }()
-
-		    job.Run()
-		    runtime.EventsEmit(a.ctx, "download:log", "[System] Download phase complete.")
-	}()
+// activeDownloads lists queued and running crawls in start order
+func (a *App) activeDownloads() []downloader.JobInfo {
+	return a.downloads.List()
+}
 
-	return "Download started"
+// stopDownload cancels a crawl by job ID; its state is kept for resume
+func (a *App) stopDownload(id string) bool {
+	return a.downloads.Stop(id)
+}
+
+// ListJobs returns the queued and running downloads for the jobs panel
+func (a *App) ListJobs() []downloader.JobInfo {
+	return a.downloads.List()
+}
+
+// StopJob cancels a download by job ID, queued or running
+func (a *App) StopJob(id string) string {
+	if !a.downloads.Stop(id) {
+		return "Error: no active download"
+	}
+	return "Stopping"
+}
+
+// StopDownload cancels the crawl for urlStr. In-flight requests finish, the
+// queue is saved for resume and download:done follows once the job has exited.
+func (a *App) StopDownload(urlStr string) string {
+	info, ok := a.downloads.Lookup(urlStr)
+	if !ok {
+		return "Error: no active download"
+	}
+	return a.StopJob(info.ID)
+}
+
+// downloadLogBatch caps the log lines sent to the frontend per batch;
+// the rest is counted as skipped and stays in the job's log file
+const downloadLogBatch = 500
+
+// downloadListener forwards job events to the frontend. Log lines go out
+// in batches a few times per second instead of one event per line.
+type downloadListener struct {
+	ctx  context.Context
+	logs *downloader.LogBatcher
+}
+
+func newDownloadListener(ctx context.Context, logFile string) *downloadListener {
+	flush := func(lines []string, dropped int) {
+		if dropped > 0 {
+			skipped := fmt.Sprintf("[System] %d log lines skipped, full log: %s", dropped, logFile)
+			lines = append([]string{skipped}, lines...)
+		}
+		runtime.EventsEmit(ctx, "download:logs", lines)
+	}
+	return &downloadListener{
+		ctx:  ctx,
+		logs: downloader.NewLogBatcher(downloader.DefaultLogFlushInterval, downloadLogBatch, flush),
+	}
+}
+
+func (l *downloadListener) OnLog(msg string) {
+	l.logs.Add(msg)
+}
+
+func (l *downloadListener) OnProgress(s downloader.Snapshot) {
+	runtime.EventsEmit(l.ctx, "download:progress", map[string]interface{}{
+		"current":       s.Completed,
+		"total":         s.Discovered,
+		"queued":        s.Queued,
+		"overflow":      s.Overflow,
+		"phase":         s.Phase,
+		"eta":           int64(s.ETA.Seconds()),
+		"speed":         s.Speed,
+		"discoveryRate": s.DiscoveryRate,
+		"downloadRate":  s.DownloadRate,
+	})
+}
+
+func (l *downloadListener) OnPhase(phase downloader.JobPhase) {
+	runtime.EventsEmit(l.ctx, "download:phase", phase)
+}
+
+// OnBackoff tells the frontend the server asked us to slow down (429/503)
+func (l *downloadListener) OnBackoff(b downloader.BackoffEvent) {
+	runtime.EventsEmit(l.ctx, "download:backoff", map[string]interface{}{
+		"host":       b.Host,
+		"status":     b.Status,
+		"wait":       b.Wait.Milliseconds(),
+		"retryAfter": b.RetryAfter,
+	})
+}
+
+// OnBudgetReached tells the frontend the crawl stopped at its page or byte budget
+func (l *downloadListener) OnBudgetReached(b downloader.BudgetEvent) {
+	runtime.EventsEmit(l.ctx, "download:budget", map[string]interface{}{
+		"limit":    b.Limit,
+		"pages":    b.Pages,
+		"bytes":    b.Bytes,
+		"maxPages": b.MaxPages,
+		"maxBytes": b.MaxBytes,
+	})
+}
+
+func (l *downloadListener) OnFileDone(downloader.FileResult) {}
+
+func (l *downloadListener) OnError(downloader.ErrorEvent) {}
+
+func (l *downloadListener) OnComplete(sum downloader.Summary) {
+	if !sum.Canceled {
+		l.logs.Add("[System] Download phase complete.")
+	}
+	l.logs.Flush()
+	if sum.Coverage != nil {
+		runtime.EventsEmit(l.ctx, "download:coverage", sum.Coverage)
+	}
+}
+
+// GetWorkerStatus returns the live worker table of an active download
+func (a *App) GetWorkerStatus(urlStr string) []downloader.WorkerState {
+	job := a.downloadJob(urlStr)
+	if job == nil {
+		return nil
+	}
+	return job.WorkerStatus()
+}
+
+// PauseDownload stops the crawl for urlStr from taking new URLs; it returns
+// once in-flight downloads are saved and the queue is checkpointed
+func (a *App) PauseDownload(urlStr string) string {
+	job := a.downloadJob(urlStr)
+	if job == nil {
+		return "Error: no active download"
+	}
+	if err := job.Pause(); err != nil {
+		return "Error: " + err.Error()
+	}
+	return "Paused"
+}
+
+// ResumeDownload continues a paused crawl
+func (a *App) ResumeDownload(urlStr string) string {
+	job := a.downloadJob(urlStr)
+	if job == nil {
+		return "Error: no active download"
+	}
+	if err := job.Resume(); err != nil {
+		return "Error: " + err.Error()
+	}
+	return "Resumed"
+}
+
+// downloadJob returns the running job for urlStr, nil if none has started
+func (a *App) downloadJob(urlStr string) *downloader.Job {
+	info, ok := a.downloads.Lookup(urlStr)
+	if !ok {
+		return nil
+	}
+	return a.downloads.Get(info.ID)
 }
 
-// AnalyzeScripts returns a list of script sources from the site
-func (a *App) AnalyzeScripts(path string) []string {
+// AnalyzeScripts lists the site's scripts with size, usage and tracker info;
+// each ID can be passed to AdaptPaths as a removal pattern
+func (a *App) AnalyzeScripts(path string) []proccesor.ScriptInfo {
 	host := a.extractHostFromPath(path)
 	sourceDir := strings.TrimSuffix(path, "_processed")
 
 	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
-		return []string{}
+		return []proccesor.ScriptInfo{}
 	}
 
 	p := proccesor.NewProcessor(host)
 	return p.AnalyzeScripts(sourceDir)
 }
 
-// AdaptPaths runs the post-processor with optional script removal
-func (a *App) AdaptPaths(path string, scriptsToRemove []string) string {
+// AdaptPaths runs the post-processor with optional script removal,
+// using the named processing preset plus per-run overrides
+func (a *App) AdaptPaths(path string, scriptsToRemove []string, presetName string, overrides proccesor.PresetOverrides) string {
+    preset, ok := a.resolvePreset(presetName, overrides)
+    if !ok {
+        return "Error: unknown preset " + presetName
+    }
+
     normalized := filepath.ToSlash(path)
     if _, busy := a.activeJobs.LoadOrStore(normalized, true); busy {
         return "Job already in progress"
@@ -153,7 +557,6 @@ func (a *App) AdaptPaths(path string, scriptsToRemove []string) string {
         runtime.EventsEmit(a.ctx, "download:log", fmt.Sprintf("[System] Starting path adaptation for %s...", host))
 
         sourceDir := strings.TrimSuffix(path, "_processed")
-        processedDir := sourceDir + "_processed"
 
         // 1. Получаем абсолютный путь к папке (важно для корректных Rel путей)
         absSourceDir, _ := filepath.Abs(sourceDir)
@@ -164,11 +567,10 @@ func (a *App) AdaptPaths(path string, scriptsToRemove []string) string {
             return
         }
 
-        // Удаляем старую папку _processed если она была
-        os.RemoveAll(processedDir)
-
         // 2. СНАЧАЛА создаем процессор
         p := proccesor.NewProcessor(host)
+        p.ApplyPreset(preset)
+        p.SetProber(downloader.NewProber(crawlConfig(""), 0))
 
         // 3. Настраиваем логирование
         p.OnLog = func(msg string) {
@@ -190,10 +592,13 @@ func (a *App) AdaptPaths(path string, scriptsToRemove []string) string {
             }
         }
 
-        // 4. ТЕПЕРЬ запускаем процесс (передаем абсолютный путь)
-        p.Process(absSourceDir, scriptsToRemove)
-
-        runtime.EventsEmit(a.ctx, "download:log", "[System] Adaptation sequence finished.")
+        // 4. ТЕПЕРЬ запускаем процесс (передаем абсолютный путь).
+        // Результат пишется во временную папку и заменяет старый только при успехе.
+        if err := p.ProcessContext(a.ctx, absSourceDir, scriptsToRemove); err != nil {
+            runtime.EventsEmit(a.ctx, "download:log", "[Error] Adaptation failed, previous output kept: "+err.Error())
+        } else {
+            runtime.EventsEmit(a.ctx, "download:log", "[System] Adaptation sequence finished.")
+        }
         runtime.EventsEmit(a.ctx, "adapting:done", normalized)
         runtime.EventsEmit(a.ctx, "library:refresh", "DONE")
     }()
@@ -201,6 +606,24 @@ func (a *App) AdaptPaths(path string, scriptsToRemove []string) string {
     return "Adaptation started"
 }
 
+// GetOutboundLinks returns the external links report written by the last adaptation
+func (a *App) GetOutboundLinks(path string) []proccesor.OutboundLink {
+	processedDir := strings.TrimSuffix(path, "_processed") + "_processed"
+	links, err := proccesor.ReadOutboundLinks(processedDir)
+	if err != nil {
+		return []proccesor.OutboundLink{}
+	}
+	return links
+}
+
+// VerifySite checks the links of a downloaded or processed site on disk and
+// reports the ones pointing to missing files or outside the site folder. A
+// folder that cannot be read is an error, not an empty report: the UI must
+// not show it as a site without broken links.
+func (a *App) VerifySite(path string) (downloader.VerifyReport, error) {
+	return downloader.VerifySite(path)
+}
+
 func stripAnsi(msg string) string {
 	msg = strings.ReplaceAll(msg, "\033[31m", "")
 	msg = strings.ReplaceAll(msg, "\033[32m", "")
@@ -216,7 +639,9 @@ func (a *App) extractHostFromPath(path string) string {
 	return folder
 }
 
-// GetDownloads scans the downloads directory and returns a list of sites
+// GetDownloads lists downloaded sites. Icons and entry paths come from the
+// library cache; folders not scanned yet are returned with Scanning set and
+// reported later via library:item-updated.
 func (a *App) GetDownloads() []SiteMeta {
 	outputDir := "downloads"
 	var sites []SiteMeta
@@ -232,12 +657,24 @@ func (a *App) GetDownloads() []SiteMeta {
 			continue
 		}
 		name := f.Name()
+		// Hidden folders (the shared .cache), scratch dirs and exports are not sites
+		if strings.HasPrefix(name, ".") || proccesor.IsScratchDir(name) || name == exportsDirName {
+			continue
+		}
+		// CDN assets mirrored next to the sites they belong to
+		if downloader.IsAssetMirror(filepath.Join(outputDir, name)) {
+			continue
+		}
 		isProcessed := strings.HasSuffix(name, "_processed")
 		baseName := strings.TrimSuffix(name, "_processed")
 		path := filepath.Join(outputDir, name)
 
-		icon := a.getSiteIcon(path)
-		entryPath := a.getEntryPath(path)
+		var mtime time.Time
+		if info, err := f.Info(); err == nil {
+			mtime = info.ModTime()
+		}
+		details, fresh := a.library.get(path, mtime)
+		icon, entryPath := details.Icon, details.EntryPath
 
 		// If entryPath is in a sub-folder (like /ru/), the domain name should reflect that
 		domain := strings.ReplaceAll(baseName, "_", "/")
@@ -250,14 +687,14 @@ func (a *App) GetDownloads() []SiteMeta {
 
 		if prev, exists := sitesMap[baseName]; exists {
 			if isProcessed {
-				sitesMap[baseName] = SiteMeta{Name: baseName, Path: path, Icon: icon, Domain: domain, EntryPath: entryPath}
+				sitesMap[baseName] = SiteMeta{Name: baseName, Path: path, Icon: icon, Domain: domain, EntryPath: entryPath, Scanning: !fresh}
 			} else if prev.Icon == "" && icon != "" {
 				p := sitesMap[baseName]
 				p.Icon = icon
 				sitesMap[baseName] = p
 			}
 		} else {
-			sitesMap[baseName] = SiteMeta{Name: baseName, Path: path, Icon: icon, Domain: domain, EntryPath: entryPath}
+			sitesMap[baseName] = SiteMeta{Name: baseName, Path: path, Icon: icon, Domain: domain, EntryPath: entryPath, Scanning: !fresh}
 		}
 	}
 
@@ -273,6 +710,10 @@ func (a *App) getEntryPath(dir string) string {
 	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
 		return "index.html"
 	}
+	// Start page of a crawl that began below the root, as recorded in the manifest
+	if entry, ok := downloader.EntryPath(dir); ok {
+		return entry
+	}
 
 	var bestEntry string
 	minDepth := 999
@@ -385,22 +826,102 @@ func (a *App) DeleteSite(path string) string {
 
 	basePath := strings.TrimSuffix(path, "_processed")
 	processedPath := basePath + "_processed"
+
+	// Не удаляем сайт из-под идущей загрузки или обработки
+	lock, err := downloader.LockSite(basePath, "delete")
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	defer lock.Unlock()
+
 	os.RemoveAll(basePath)
 	os.RemoveAll(processedPath)
+	a.library.forget(filepath.Clean(basePath))
+	a.library.forget(filepath.Clean(processedPath))
+
+	// Drop shared cache entries no remaining site links to
+	if removed, freed, err := downloader.GCSharedCache(outputDir); err == nil && removed > 0 {
+		log.Printf("[System] Shared cache: removed %d entries, freed %d bytes", removed, freed)
+	}
 
 	return "Deleted"
 }
 
-// findFreePort returns a free port starting from the given port
-func (a *App) findFreePort(startPort int) int {
-	for port := startPort; port < startPort+10; port++ {
-		ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
-		if err == nil {
-			ln.Close()
-			return port
-		}
+// exportsDirName is the folder for archives and bundles inside the output dir
+const exportsDirName = "exports"
+
+// exportsDir is where exports of the site at basePath go: next to the site
+// in its output dir, wherever the app was started from
+func exportsDir(basePath string) string {
+	return filepath.Join(filepath.Dir(basePath), exportsDirName)
+}
+
+// ExportDockerBundle writes the processed site to outDir together with an
+// nginx.conf mirroring the built-in server rules, a Dockerfile and a
+// docker-compose.yml
+func (a *App) ExportDockerBundle(path string, outDir string) string {
+	basePath := strings.TrimSuffix(path, "_processed")
+	processedPath := basePath + "_processed"
+	if _, err := os.Stat(processedPath); err != nil {
+		return "Error: site is not processed yet"
+	}
+	if outDir == "" {
+		outDir = filepath.Join(exportsDir(basePath), filepath.Base(basePath)+"-docker")
+	}
+
+	// Не экспортируем сайт, который сейчас перезаписывает обработка
+	lock, err := downloader.LockSite(basePath, "export")
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	defer lock.Unlock()
+
+	if err := server.ExportDockerBundle(processedPath, outDir, server.ExportOptions{Compose: true}); err != nil {
+		return "Error: " + err.Error()
+	}
+	abs, _ := filepath.Abs(outDir)
+	return "Exported to " + abs
+}
+
+// ExportSite packs the processed site into <output dir>/exports/<site>.zip
+// and returns the archive path. Progress is emitted as "export:progress"
+// events.
+func (a *App) ExportSite(sitePath string) string {
+	basePath := strings.TrimSuffix(sitePath, "_processed")
+	processedPath := basePath + "_processed"
+	if _, err := os.Stat(processedPath); err != nil {
+		return "Error: site is not processed yet"
+	}
+	name := filepath.Base(basePath)
+	dest, _ := filepath.Abs(filepath.Join(exportsDir(basePath), name+".zip"))
+
+	lock, err := downloader.LockSite(basePath, "export")
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	defer lock.Unlock()
+
+	err = server.ExportZip(processedPath, dest, server.ZipOptions{
+		Root: name,
+		OnProgress: func(done, total int, file string) {
+			runtime.EventsEmit(a.ctx, "export:progress", map[string]interface{}{
+				"path":    sitePath,
+				"current": done,
+				"total":   total,
+				"file":    file,
+			})
+		},
+	})
+	if err != nil {
+		return "Error: " + err.Error()
 	}
-	return 0
+	return dest
+}
+
+// RefreshLibrary drops cached icons and entry paths and rescans every site
+func (a *App) RefreshLibrary() []SiteMeta {
+	a.library.invalidate()
+	return a.GetDownloads()
 }
 
 // StartServer starts a static file server with dynamic port fallback
@@ -408,55 +929,31 @@ func (a *App) StartServer(dir string, portStr string) string {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.server != nil {
+	if a.server.Status().Running {
 		// Stop the existing server before starting a new one
 		a.stopServerNoLock()
 	}
 
-	port := 8080
+	port := server.DefaultPort
 	if portStr != "" {
 		if p, err := strconv.Atoi(portStr); err == nil {
 			port = p
 		}
 	}
 
-	// Dynamic port selection
-	actualPort := a.findFreePort(port)
-	if actualPort == 0 {
-		runtime.EventsEmit(a.ctx, "server:error", "No free ports available")
-		return "Error"
-	}
-
-	portStr = strconv.Itoa(actualPort)
-
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		runtime.EventsEmit(a.ctx, "server:error", "Missing: "+dir)
+	status, err := a.server.Start(server.StartOptions{Dir: dir, Port: port})
+	if err != nil {
+		runtime.EventsEmit(a.ctx, "server:error", err.Error())
 		return "Error"
 	}
 
-	a.server = &http.Server{
-		Addr:    ":" + portStr,
-		Handler: http.FileServer(http.Dir(dir)),
-	}
-	a.servingPath = filepath.ToSlash(dir)
-
-	go func() {
-		runtime.EventsEmit(a.ctx, "server:status", fmt.Sprintf("http://localhost:%s", portStr))
-		runtime.EventsEmit(a.ctx, "server:started", map[string]string{
-			"url":  fmt.Sprintf("http://localhost:%s", portStr),
-			"path": a.servingPath,
-		})
-		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			runtime.EventsEmit(a.ctx, "server:error", err.Error())
-			a.mu.Lock()
-			a.server = nil
-			a.servingPath = ""
-			a.mu.Unlock()
-			runtime.EventsEmit(a.ctx, "server:stopped", "ERROR")
-		}
-	}()
+	runtime.EventsEmit(a.ctx, "server:status", status.URL)
+	runtime.EventsEmit(a.ctx, "server:started", map[string]string{
+		"url":  status.URL,
+		"path": status.Dir,
+	})
 
-	return fmt.Sprintf("http://localhost:%s", portStr)
+	return status.URL
 }
 
 // StopServer stops the running server
@@ -467,25 +964,18 @@ func (a *App) StopServer() string {
 }
 
 func (a *App) stopServerNoLock() string {
-	if a.server != nil {
-		s := a.server
-		a.server = nil
-		serving := a.servingPath
-		a.servingPath = ""
-
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		if err := s.Shutdown(ctx); err != nil {
-			s.Close()
-			runtime.EventsEmit(a.ctx, "server:status", "Forced stop")
-			runtime.EventsEmit(a.ctx, "server:stopped", serving)
-			return "Forced stop"
-		}
-		runtime.EventsEmit(a.ctx, "server:status", "Stopped")
-		runtime.EventsEmit(a.ctx, "server:stopped", serving)
-		return "Stopped"
+	prev, err := a.server.Stop()
+	if err == server.ErrNotRunning {
+		return "Not running"
+	}
+	if err != nil {
+		runtime.EventsEmit(a.ctx, "server:status", "Forced stop")
+		runtime.EventsEmit(a.ctx, "server:stopped", prev.Dir)
+		return "Forced stop"
 	}
-	return "Not running"
+	runtime.EventsEmit(a.ctx, "server:status", "Stopped")
+	runtime.EventsEmit(a.ctx, "server:stopped", prev.Dir)
+	return "Stopped"
 }
 
 // LaunchSite starts server and opens browser
@@ -511,8 +1001,7 @@ func (a *App) LaunchSite(path string) string {
 				fullRelEntry, _ := filepath.Rel(hostDir, filepath.Join(absPath, entryPath))
 
 				finalUrl := strings.TrimSuffix(serverUrl, "/") + "/" + strings.TrimPrefix(filepath.ToSlash(fullRelEntry), "/")
-				runtime.BrowserOpenURL(a.ctx, finalUrl)
-				return "Launched " + finalUrl
+				return a.openWhenReady(finalUrl)
 			}
 		}
 	}
@@ -524,11 +1013,29 @@ func (a *App) LaunchSite(path string) string {
 		if entryPath != "" {
 			urlStr = strings.TrimSuffix(urlStr, "/") + "/" + strings.TrimPrefix(entryPath, "/")
 		}
-		runtime.BrowserOpenURL(a.ctx, urlStr)
+		return a.openWhenReady(urlStr)
 	}
 	return "Launched " + urlStr
 }
 
+// openWhenReady waits for the server to answer before opening the browser,
+// so the first tab doesn't hit a not-yet-listening port
+func (a *App) openWhenReady(urlStr string) string {
+	if err := a.server.WaitReady(serverReadyTimeout); err != nil {
+		runtime.EventsEmit(a.ctx, "server:error", err.Error())
+		return "Error: " + err.Error()
+	}
+	runtime.BrowserOpenURL(a.ctx, urlStr)
+	return "Launched " + urlStr
+}
+
+// GetServerStatus returns the same data as the /__sitecloner/status endpoint;
+// zero value when the server is stopped
+func (a *App) GetServerStatus() server.Health {
+	h, _ := a.server.Health()
+	return h
+}
+
 // OpenFolder opens the system file explorer
 func (a *App) OpenFolder(path string) {
 	absPath, _ := filepath.Abs(path)
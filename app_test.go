@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sitemvp/downloader"
+)
+
+func TestRecrawlUsesStoredJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>page</body></html>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cfg := crawlConfig(dir)
+	cfg.Workers, cfg.Retries, cfg.Delay, cfg.LogStderr = 1, 1, 0, false
+	cfg.IncludePatterns = []string{"/docs/**"}
+	cfg.DownloadExternalAssets = true
+	cfg.UAPreset = downloader.UAFirefox
+	if _, err := downloader.Run(context.Background(), downloader.RunOptions{URL: srv.URL + "/", Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart the app knows the job only by its state file
+	params, err := storedLaunch(dir, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.outputDir != dir || !params.opts.ExternalAssets || params.opts.UAPreset != downloader.UAFirefox ||
+		len(params.opts.Include) != 1 || params.opts.Include[0] != "/docs/**" {
+		t.Errorf("Recrawl must reuse the stored folder and options, got %+v", params)
+	}
+	if _, err := storedLaunch(t.TempDir(), srv.URL); err == nil {
+		t.Error("Expected an error for a site that was never crawled")
+	}
+}
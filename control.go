@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sitemvp/downloader"
+)
+
+const (
+	// defaultControlPort is where the control API listens unless settings say otherwise
+	defaultControlPort = 17321
+
+	// maxControlBody bounds POST bodies; a request carries a single URL
+	maxControlBody = 16 << 10
+
+	// bookmarkletTokenTTL is how long a copied bookmarklet keeps working
+	bookmarkletTokenTTL = 24 * time.Hour
+)
+
+// controlBackend is the part of App the control API drives
+type controlBackend interface {
+	startDownload(urlStr string, outputDir string) (downloader.JobInfo, error)
+	activeDownloads() []downloader.JobInfo
+	stopDownload(id string) bool
+}
+
+// controlJob is a queued or running download as reported by GET /api/jobs
+type controlJob struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"startedAt"`
+	Files     int64     `json:"files"`
+	Bytes     int64     `json:"bytes"`
+	Failed    int64     `json:"failed"`
+	Running   bool      `json:"running"` // False while the job waits for a free slot
+	Status    string    `json:"status"`  // queued, running or stopping
+}
+
+// controlAPI serves the local API used by the bookmarklet and browser
+// extensions: only loopback hosts, only with the token, never with CORS.
+type controlAPI struct {
+	backend      controlBackend
+	token        string
+	bookmarklets *bookmarkletTokens
+	mux          *http.ServeMux
+}
+
+func newControlAPI(b controlBackend, token string, bookmarklets *bookmarkletTokens) *controlAPI {
+	c := &controlAPI{backend: b, token: token, bookmarklets: bookmarklets, mux: http.NewServeMux()}
+	c.mux.HandleFunc("POST /api/download", c.download)
+	c.mux.HandleFunc("GET /api/jobs", c.jobs)
+	c.mux.HandleFunc("POST /api/jobs/{id}/stop", c.stop)
+	return c
+}
+
+func (c *controlAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &controlRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		// Путь без query: токен может прийти параметром и в лог попасть не должен
+		log.Printf("[ControlAPI] %s %s %s -> %d", r.RemoteAddr, r.Method, r.URL.Path, rec.status)
+	}()
+
+	h := rec.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	switch {
+	case !loopbackHost(r.Host):
+		// Чужое имя хоста — признак DNS rebinding
+		writeControlError(rec, http.StatusForbidden, "forbidden host")
+	case r.Method == http.MethodOptions:
+		// Preflight не разрешаем: страницы могут слать только простые запросы
+		writeControlError(rec, http.StatusForbidden, "cross-origin requests are not allowed")
+	case !c.authorized(r):
+		writeControlError(rec, http.StatusUnauthorized, "invalid token")
+	default:
+		c.mux.ServeHTTP(rec, r)
+	}
+}
+
+// authorized checks the API token from "Authorization: Bearer". A
+// bookmarklet cannot set headers: its short-lived token comes in the
+// "token" query parameter and only submits downloads.
+func (c *controlAPI) authorized(r *http.Request) bool {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		got := strings.TrimPrefix(auth, "Bearer ")
+		return c.token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(c.token)) == 1
+	}
+	return r.Method == http.MethodPost && r.URL.Path == "/api/download" &&
+		c.bookmarklets.valid(r.URL.Query().Get("token"))
+}
+
+func (c *controlAPI) download(w http.ResponseWriter, r *http.Request) {
+	// Content-Type не проверяем: no-cors fetch шлёт text/plain
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxControlBody)).Decode(&req); err != nil {
+		writeControlError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeControlError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	// location.href carries a fragment and any spelling of the host: the
+	// job ID and its state file follow the URL, so the same page must map
+	// to the same job as one started from the app
+	normalized, err := downloader.NormalizeURL(req.URL)
+	if err != nil {
+		writeControlError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+
+	d, err := c.backend.startDownload(normalized, "")
+	switch {
+	case errors.Is(err, errDownloadBusy):
+		resp := map[string]string{"error": err.Error()}
+		if d.ID != "" {
+			resp["id"] = d.ID
+		}
+		writeControlJSON(w, http.StatusConflict, resp)
+	case err != nil:
+		writeControlError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeControlJSON(w, http.StatusAccepted, controlJobOf(d))
+	}
+}
+
+func (c *controlAPI) jobs(w http.ResponseWriter, r *http.Request) {
+	list := []controlJob{}
+	for _, d := range c.backend.activeDownloads() {
+		list = append(list, controlJobOf(d))
+	}
+	writeControlJSON(w, http.StatusOK, list)
+}
+
+func (c *controlAPI) stop(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !c.backend.stopDownload(id) {
+		writeControlError(w, http.StatusNotFound, "no running job "+id)
+		return
+	}
+	writeControlJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "stopping"})
+}
+
+func controlJobOf(d downloader.JobInfo) controlJob {
+	return controlJob{
+		ID:        d.ID,
+		URL:       d.URL,
+		StartedAt: d.Added,
+		Files:     d.Files,
+		Bytes:     d.Bytes,
+		Failed:    d.Failed,
+		Running:   d.Status != downloader.JobQueued,
+		Status:    string(d.Status),
+	}
+}
+
+// loopbackHost accepts only loopback names in the Host header, any port
+func loopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeControlJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeControlError(w http.ResponseWriter, status int, msg string) {
+	writeControlJSON(w, status, map[string]string{"error": msg})
+}
+
+// controlRecorder remembers the response status for the request log
+type controlRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *controlRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// newControlToken returns a random 128-bit token in hex
+func newControlToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// bookmarkletTokens are the tokens put into bookmarklets instead of the
+// API token: a bookmarklet runs inside the page, and the page's scripts
+// can see what it sends. Each token only submits downloads and expires
+// after bookmarkletTokenTTL; they live in memory and die with the app.
+type bookmarkletTokens struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	current string
+	now     func() time.Time
+}
+
+func newBookmarkletTokens() *bookmarkletTokens {
+	return &bookmarkletTokens{expires: make(map[string]time.Time), now: time.Now}
+}
+
+// get returns the token for a new bookmarklet. The same token is handed
+// out until half of its lifetime is gone, so the settings screen does not
+// mint one per visit; bookmarklets copied earlier keep working until expiry.
+func (b *bookmarkletTokens) get() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for tok, exp := range b.expires {
+		if !now.Before(exp) {
+			delete(b.expires, tok)
+		}
+	}
+	if exp, ok := b.expires[b.current]; ok && exp.Sub(now) > bookmarkletTokenTTL/2 {
+		return b.current
+	}
+	b.current = newControlToken()
+	b.expires[b.current] = now.Add(bookmarkletTokenTTL)
+	return b.current
+}
+
+// valid reports whether tok was handed out and has not expired
+func (b *bookmarkletTokens) valid(tok string) bool {
+	if b == nil || tok == "" {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for t, exp := range b.expires {
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(t)) == 1 {
+			return b.now().Before(exp)
+		}
+	}
+	return false
+}
+
+// revoke invalidates every bookmarklet handed out so far
+func (b *bookmarkletTokens) revoke() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expires = make(map[string]time.Time)
+	b.current = ""
+}
+
+// ControlAPIStatus describes the control API for the settings screen
+type ControlAPIStatus struct {
+	Enabled     bool   `json:"enabled"`
+	Running     bool   `json:"running"`
+	URL         string `json:"url"`
+	Token       string `json:"token"`
+	Bookmarklet string `json:"bookmarklet"`
+	Error       string `json:"error,omitempty"`
+}
+
+// GetControlAPI returns the control API settings and state
+func (a *App) GetControlAPI() ControlAPIStatus {
+	settingsMu.Lock()
+	s := loadSettings()
+	settingsMu.Unlock()
+
+	a.controlMu.Lock()
+	running := a.control != nil
+	a.controlMu.Unlock()
+
+	st := ControlAPIStatus{Enabled: s.ControlAPI, Running: running, Token: s.ControlToken}
+	if s.ControlToken != "" {
+		st.URL = fmt.Sprintf("http://127.0.0.1:%d", controlPort(s))
+		st.Bookmarklet = controlBookmarklet(st.URL, a.bookmarklets.get())
+	}
+	return st
+}
+
+// SetControlAPI turns the control API on or off; a token is created on first use
+func (a *App) SetControlAPI(enabled bool) ControlAPIStatus {
+	settingsMu.Lock()
+	s := loadSettings()
+	s.ControlAPI = enabled
+	if s.ControlToken == "" {
+		s.ControlToken = newControlToken()
+	}
+	err := saveSettings(s)
+	settingsMu.Unlock()
+	if err == nil {
+		err = a.applyControlAPI(s)
+	}
+
+	st := a.GetControlAPI()
+	if err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+// RegenerateControlToken replaces the token; old bookmarklets stop working
+func (a *App) RegenerateControlToken() ControlAPIStatus {
+	a.bookmarklets.revoke()
+	settingsMu.Lock()
+	s := loadSettings()
+	s.ControlToken = newControlToken()
+	err := saveSettings(s)
+	settingsMu.Unlock()
+	if err == nil {
+		err = a.applyControlAPI(s)
+	}
+
+	st := a.GetControlAPI()
+	if err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+// applyControlAPI (re)starts or stops the control API server to match s
+func (a *App) applyControlAPI(s AppSettings) error {
+	a.controlMu.Lock()
+	defer a.controlMu.Unlock()
+
+	if a.control != nil {
+		a.control.Close()
+		a.control = nil
+	}
+	if !s.ControlAPI || s.ControlToken == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", controlPort(s)))
+	if err != nil {
+		return fmt.Errorf("control API: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           newControlAPI(a, s.ControlToken, a.bookmarklets),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	a.control = srv
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[ControlAPI] server stopped: %v", err)
+		}
+	}()
+	log.Printf("[ControlAPI] listening on %s", ln.Addr())
+	return nil
+}
+
+func controlPort(s AppSettings) int {
+	if s.ControlPort > 0 {
+		return s.ControlPort
+	}
+	return defaultControlPort
+}
+
+// controlBookmarklet builds a bookmarklet that submits the current page
+// with a bookmarklet token. no-cors keeps it a simple request, so the API
+// needs no CORS headers.
+func controlBookmarklet(base, token string) string {
+	return "javascript:(function(){fetch('" + base + "/api/download?token=" + token +
+		"',{method:'POST',mode:'no-cors',body:JSON.stringify({url:location.href})});})();"
+}
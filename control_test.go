@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sitemvp/downloader"
+)
+
+// fakeBackend keeps the same duplicate rule as App.startDownload without crawling
+type fakeBackend struct {
+	mu      sync.Mutex
+	jobs    map[string]downloader.JobInfo
+	stopped []string
+}
+
+func (b *fakeBackend) startDownload(urlStr string, _ string) (downloader.JobInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d, ok := b.jobs[urlStr]; ok {
+		return d, errDownloadBusy
+	}
+	d := downloader.JobInfo{ID: "job" + string(rune('a'+len(b.jobs))), URL: urlStr, Status: downloader.JobQueued}
+	b.jobs[urlStr] = d
+	return d, nil
+}
+
+func (b *fakeBackend) activeDownloads() []downloader.JobInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var list []downloader.JobInfo
+	for _, d := range b.jobs {
+		list = append(list, d)
+	}
+	return list
+}
+
+func (b *fakeBackend) stopDownload(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range b.jobs {
+		if d.ID == id {
+			b.stopped = append(b.stopped, id)
+			return true
+		}
+	}
+	return false
+}
+
+func TestControlAPIAuth(t *testing.T) {
+	b := &fakeBackend{jobs: map[string]downloader.JobInfo{}}
+	marks := newBookmarkletTokens()
+	tok := marks.get()
+	api := newControlAPI(b, "secret", marks)
+
+	cases := []struct {
+		name   string
+		method string
+		target string
+		host   string
+		auth   string
+		want   int
+	}{
+		{"no token", "GET", "/api/jobs", "127.0.0.1:17321", "", http.StatusUnauthorized},
+		{"wrong token", "GET", "/api/jobs", "127.0.0.1:17321", "Bearer nope", http.StatusUnauthorized},
+		{"wrong query token", "GET", "/api/jobs?token=nope", "127.0.0.1:17321", "", http.StatusUnauthorized},
+		{"foreign host", "GET", "/api/jobs", "evil.example:17321", "Bearer secret", http.StatusForbidden},
+		{"preflight", "OPTIONS", "/api/download", "localhost:17321", "", http.StatusForbidden},
+		{"bearer", "GET", "/api/jobs", "127.0.0.1:17321", "Bearer secret", http.StatusOK},
+		// The API token never travels in the URL; a bookmarklet token only submits
+		{"API token in query", "GET", "/api/jobs?token=secret", "[::1]:17321", "", http.StatusUnauthorized},
+		{"bookmarklet token for jobs", "GET", "/api/jobs?token=" + tok, "[::1]:17321", "", http.StatusUnauthorized},
+		{"bookmarklet token for stop", "POST", "/api/jobs/zzz/stop?token=" + tok, "[::1]:17321", "", http.StatusUnauthorized},
+		{"bookmarklet token", "POST", "/api/download?token=" + tok, "[::1]:17321", "", http.StatusBadRequest},
+		{"wrong method", "GET", "/api/download", "localhost", "Bearer secret", http.StatusMethodNotAllowed},
+		{"unknown job", "POST", "/api/jobs/zzz/stop", "localhost", "Bearer secret", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.target, nil)
+			req.Host = c.host
+			if c.auth != "" {
+				req.Header.Set("Authorization", c.auth)
+			}
+			req.Header.Set("Origin", "https://example.com")
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+
+			if rec.Code != c.want {
+				t.Errorf("Expected %d, got %d: %s", c.want, rec.Code, rec.Body)
+			}
+			if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Error("CORS headers must never be sent")
+			}
+		})
+	}
+}
+
+func TestControlAPIDuplicateSubmission(t *testing.T) {
+	b := &fakeBackend{jobs: map[string]downloader.JobInfo{}}
+	marks := newBookmarkletTokens()
+	api := newControlAPI(b, "secret", marks)
+	submit := "/api/download?token=" + marks.get()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = "127.0.0.1:17321"
+		if strings.HasPrefix(target, "/api/download") {
+			// Букмарклет шлёт no-cors запрос: text/plain и токен в query
+			req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+		} else {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := do("POST", submit, `{"url":"https://example.com/"}`)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", first.Code, first.Body)
+	}
+	var job controlJob
+	json.Unmarshal(first.Body.Bytes(), &job)
+
+	// location.href of the same page: fragment and host case differ
+	dup := do("POST", submit, `{"url":"https://Example.com/#top"}`)
+	if dup.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 for duplicate, got %d", dup.Code)
+	}
+	var resp map[string]string
+	json.Unmarshal(dup.Body.Bytes(), &resp)
+	if resp["id"] != job.ID {
+		t.Errorf("Duplicate must point to the running job %q, got %v", job.ID, resp)
+	}
+
+	if rec := do("POST", submit, `{"url":"javascript:alert(1)"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-http URL, got %d", rec.Code)
+	}
+	if rec := do("POST", submit, `not json`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad body, got %d", rec.Code)
+	}
+
+	var list []controlJob
+	json.Unmarshal(do("GET", "/api/jobs", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != job.ID {
+		t.Fatalf("Expected one job, got %+v", list)
+	}
+	if rec := do("POST", "/api/jobs/"+job.ID+"/stop", ""); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 on stop, got %d", rec.Code)
+	}
+	if len(b.stopped) != 1 || b.stopped[0] != job.ID {
+		t.Errorf("Stop not forwarded: %v", b.stopped)
+	}
+}
+
+func TestBookmarkletTokensExpire(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	marks := newBookmarkletTokens()
+	marks.now = func() time.Time { return now }
+
+	first := marks.get()
+	if !marks.valid(first) || marks.valid("") || marks.valid("nope") {
+		t.Fatal("Only handed out tokens are valid")
+	}
+	// The settings screen gets the same token while it is fresh
+	now = now.Add(bookmarkletTokenTTL / 4)
+	if marks.get() != first {
+		t.Error("A fresh token must be reused")
+	}
+	// Past half of its life a new one is handed out; the old one still works
+	now = now.Add(bookmarkletTokenTTL / 2)
+	second := marks.get()
+	if second == first || !marks.valid(first) || !marks.valid(second) {
+		t.Errorf("Expected a second token with the first still valid")
+	}
+	now = now.Add(bookmarkletTokenTTL / 2)
+	if marks.valid(first) || !marks.valid(second) {
+		t.Error("The first token must expire after its TTL")
+	}
+	marks.revoke()
+	if marks.valid(second) {
+		t.Error("Regenerating the API token must revoke bookmarklets")
+	}
+}
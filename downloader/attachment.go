@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Вложения: /download?id=123 с Content-Disposition: attachment;
+// filename="report.pdf" сохраняется как report.pdf рядом с адресом
+// (стратегия file), а не как download/index.html. Страницы, которые
+// сослались на вложение до его скачивания, уже переписаны на угаданный
+// путь, поэтому их ссылки правятся на месте (relink).
+
+// attachmentName — имя файла из Content-Disposition, если по нему стоит
+// сохранять: с расширением, не HTML, не скрытое и не занятое другим URL.
+// Адрес, который уже оканчивается на расширение ассета (/files/a.pdf),
+// сохраняется по адресу: так ссылки на него не зависят от заголовков.
+// Выбранный путь сразу занимается в реестре (savedPaths.claim).
+func (j *Job) attachmentName(urlStr string, header http.Header, contentType string) string {
+	name := dispositionFilename(header.Get("Content-Disposition"))
+	if name == "" || strings.Contains(strings.ToLower(contentType), "text/html") {
+		return ""
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil || isAssetPath(strings.ToLower(u.Path)) {
+		return ""
+	}
+	named := j.externalPrefix(urlStr) + namedDiskPath(u, contentType, name)
+	if !j.saved.claim(urlStr, named) {
+		return ""
+	}
+	return name
+}
+
+// dispositionFilename — последний сегмент filename (filename* уже
+// раскодирован mime.ParseMediaType); "" — имени нет или оно не годится
+func dispositionFilename(disposition string) string {
+	if disposition == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(disposition)
+	if err != nil {
+		return ""
+	}
+	// Путь в имени ("..\..\evil.exe", "a/b.pdf") не выводит из папки адреса
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case "", ".html", ".htm", ".xhtml", ".php":
+		return ""
+	}
+	return name
+}
+
+// namedDiskPath — DiskPath, а с именем вложения — это имя в папке адреса:
+// /files/download?id=1 -> files/report.pdf, /get/ -> get/report.pdf
+func namedDiskPath(u *url.URL, contentType, filename string) string {
+	if filename == "" {
+		return DiskPath(u, contentType)
+	}
+	dir := path.Clean("/" + u.Path)
+	if !strings.HasSuffix(u.Path, "/") {
+		dir = path.Dir(dir)
+	}
+	return SanitizePath(strings.TrimPrefix(path.Join(dir, filename), "/"))
+}
+
+// staleLink — ссылка на вложение в уже сохранённой странице
+type staleLink struct {
+	page string // URL страницы
+	path string // Её файл, от папки сайта
+	href string // Ссылка, как она записана в файле
+}
+
+// staleLinks — ссылки на urlStr в сохранённых страницах, какими их
+// записал переписчик до сохранения вложения. Страниц не больше
+// maxReferrers: остальные ссылки останутся на угаданный путь.
+func (j *Job) staleLinks(urlStr string) []staleLink {
+	h := j.newLinkRewriter()
+	var links []staleLink
+	for ref, pagePath := range j.referrerPages(urlStr) {
+		href := localHref(h.rewriteLink(urlStr, FileMetadata{URL: ref, ContentType: "text/html"}))
+		links = append(links, staleLink{page: ref, path: pagePath, href: href})
+	}
+	return links
+}
+
+// referrerPages — сохранённые страницы со ссылкой на urlStr: URL → путь
+func (j *Job) referrerPages(urlStr string) map[string]string {
+	j.mu.Lock()
+	refs := append([]string(nil), j.referrers[urlStr]...)
+	j.mu.Unlock()
+
+	pages := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if sp, ok := j.saved.lookup(ref); ok {
+			pages[ref] = sp.Path
+		}
+	}
+	return pages
+}
+
+// movedLinks — ссылки страниц pages (URL → путь), записанные на oldPath:
+// файл сменил путь уже после того, как на него сослались (см. conflictPath)
+func movedLinks(oldPath string, pages map[string]string) []staleLink {
+	var links []staleLink
+	for page, pagePath := range pages {
+		rel, err := filepath.Rel(filepath.Join("site", filepath.Dir(filepath.FromSlash(pagePath))), filepath.Join("site", filepath.FromSlash(oldPath)))
+		if err != nil {
+			continue
+		}
+		// Как в rewriteLink: ссылки на папки — без index.html
+		href := strings.TrimSuffix(filepath.ToSlash(rel), "index.html")
+		if href == "" {
+			href = "./"
+		}
+		links = append(links, staleLink{page: page, path: pagePath, href: localHref(href)})
+	}
+	return links
+}
+
+// relink переписывает ссылки stale на путь, под которым urlStr сохранён.
+// Атрибуты в файле экранированы, как их пишет html.Render.
+func (j *Job) relink(urlStr string, stale []staleLink) {
+	if len(stale) == 0 {
+		return
+	}
+	siteDir, err := hostDir(j.Config.OutputDir, j.scheme.host)
+	if err != nil {
+		return
+	}
+	h := j.newLinkRewriter()
+	for _, s := range stale {
+		href := localHref(h.rewriteLink(urlStr, FileMetadata{URL: s.page, ContentType: "text/html"}))
+		if href == s.href {
+			continue
+		}
+		full := filepath.Join(siteDir, filepath.FromSlash(s.path))
+		content, err := os.ReadFile(full)
+		if err != nil {
+			continue
+		}
+		old, repl := html.EscapeString(s.href), html.EscapeString(href)
+		fixed := content
+		for _, q := range []string{`"`, `'`} {
+			fixed = bytes.ReplaceAll(fixed, []byte(q+old+q), []byte(q+repl+q))
+			fixed = bytes.ReplaceAll(fixed, []byte(q+old+"#"), []byte(q+repl+"#"))
+		}
+		if bytes.Equal(fixed, content) {
+			continue
+		}
+		// Через rename: страницу не увидят недописанной
+		tmp := full + ".tmp"
+		if err := os.WriteFile(tmp, fixed, 0644); err != nil {
+			continue
+		}
+		if err := os.Rename(tmp, full); err != nil {
+			os.Remove(tmp)
+			continue
+		}
+		j.sendLog(fmt.Sprintf("[Info] Relinked %s in %s: %s", urlStr, s.path, href), false)
+	}
+}
@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxBackoff — потолок экспоненциальной паузы на 429/503 без Retry-After
+	MaxBackoff = 60 * time.Second
+	// MaxRetryAfter — дольше Retry-After не ждём: такой ответ — почти отказ
+	MaxRetryAfter = 5 * time.Minute
+)
+
+// BackoffEvent — сервер просит притормозить (429 Too Many Requests или
+// 503 Service Unavailable), загрузчик ждёт перед повтором. Нужен GUI, чтобы
+// объяснить, почему упала скорость.
+type BackoffEvent struct {
+	URL        string
+	Host       string
+	Status     int
+	Attempt    int
+	Wait       time.Duration
+	RetryAfter bool // Пауза из заголовка Retry-After, а не экспоненциальная
+}
+
+// BackoffListener — необязательное расширение ProgressListener: паузы на 429/503
+type BackoffListener interface {
+	OnBackoff(BackoffEvent)
+}
+
+// throttled — статус, на который отвечаем паузой, а не обычным повтором
+func throttled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter разбирает Retry-After: число секунд или HTTP-дата
+func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(h); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	at, err := http.ParseTime(h)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// backoffDelay — пауза перед попыткой attempt+1: Retry-After, если сервер
+// его прислал, иначе Delay, 2×Delay, 4×Delay… но не больше MaxBackoff
+func (d *Downloader) backoffDelay(attempt int, header http.Header) (time.Duration, bool) {
+	if wait, ok := parseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+		if wait > MaxRetryAfter {
+			wait = MaxRetryAfter
+		}
+		return wait, true
+	}
+	wait := d.delay
+	for i := 1; i < attempt && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxBackoff {
+		wait = MaxBackoff
+	}
+	return wait, false
+}
+
+// sleepCtx ждёт d или отмены контекста
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// noteBackoff — загрузчик ждёт по просьбе сервера: пишем в лог и шлём событие
+func (j *Job) noteBackoff(ev BackoffEvent) {
+	source := "backoff"
+	if ev.RetryAfter {
+		source = "Retry-After"
+	}
+	j.sendLog(fmt.Sprintf("⏳ %s ответил %d, пауза %s (%s) перед попыткой %d: %s",
+		ev.Host, ev.Status, ev.Wait.Round(time.Millisecond), source, ev.Attempt+1, ev.URL), false)
+	j.emit(&event{kind: eventBackoff, backoff: ev})
+}
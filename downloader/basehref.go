@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"net/url"
+
+	"golang.org/x/net/html"
+)
+
+// <base href> меняет базу всех относительных ссылок страницы. Парсер
+// разрешает ссылки от неё, а LinkRewriterHandlerV2 снимает href с тега:
+// локальные ссылки он пишет относительно файла страницы, и оставленная
+// база увела бы их обратно на сайт.
+
+// findBase — первый <base> с href (остальные браузер игнорирует)
+func findBase(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.Data == "base" {
+		for _, a := range n.Attr {
+			if a.Key == "href" {
+				return n
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if b := findBase(c); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// documentBase — база ссылок документа: href из <base> (он сам может быть
+// относительным) или адрес страницы
+func documentBase(doc *html.Node, pageURL string) string {
+	b := findBase(doc)
+	if b == nil {
+		return pageURL
+	}
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return pageURL
+	}
+	for _, a := range b.Attr {
+		if a.Key != "href" {
+			continue
+		}
+		href, err := url.Parse(a.Val)
+		if err != nil {
+			return pageURL
+		}
+		if res := page.ResolveReference(href); res.Scheme == "http" || res.Scheme == "https" {
+			return res.String()
+		}
+	}
+	return pageURL
+}
+
+// stripBase убирает href из <base>; тег без других атрибутов (target)
+// удаляется целиком
+func stripBase(doc *html.Node) {
+	b := findBase(doc)
+	if b == nil {
+		return
+	}
+	attrs := b.Attr[:0]
+	for _, a := range b.Attr {
+		if a.Key != "href" {
+			attrs = append(attrs, a)
+		}
+	}
+	b.Attr = attrs
+	if len(attrs) == 0 && b.Parent != nil {
+		b.Parent.RemoveChild(b)
+	}
+}
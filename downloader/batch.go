@@ -0,0 +1,194 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Пакетная загрузка: несколько корней (разделы одного сайта или разные
+// сайты) за один запуск. Каждый корень — отдельная задача со своим
+// файлом состояния, как у обычного download; задачи идут по очереди или
+// по Parallel штук сразу, а воркеры у них общие: одновременно скачивается
+// не больше Config.Workers URL на весь пакет.
+
+// WorkerPool — общий бюджет воркеров (Config.WorkerPool)
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// NewWorkerPool создаёт пул на n одновременных загрузок; n <= 0 — DefaultWorkers
+func NewWorkerPool(n int) *WorkerPool {
+	if n <= 0 {
+		n = DefaultWorkers
+	}
+	return &WorkerPool{slots: make(chan struct{}, n)}
+}
+
+// acquire занимает слот; false — ctx отменён раньше. Без пула — сразу true.
+func (p *WorkerPool) acquire(ctx context.Context) bool {
+	if p == nil {
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *WorkerPool) release() {
+	if p != nil {
+		<-p.slots
+	}
+}
+
+// ReadURLList читает список корней: один URL в строке; пустые строки и
+// строки, начинающиеся с #, пропускаются
+func ReadURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !IsJobURL(line) {
+			return nil, fmt.Errorf("line %d: not a URL: %q", n, line)
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+// BatchOptions — параметры RunBatch
+type BatchOptions struct {
+	URLs   []string
+	Config Config // Общая для всех задач; Workers — бюджет на весь пакет
+
+	// Parallel — сколько задач идёт одновременно; <= 1 — по очереди
+	Parallel int
+
+	// OnDone вызывается после каждой задачи, в том числе не запущенной
+	// (повтор корня, отмена); может вызываться из разных горутин
+	OnDone func(res BatchResult)
+}
+
+func (o BatchOptions) done(res BatchResult) {
+	if o.OnDone != nil {
+		o.OnDone(res)
+	}
+}
+
+// BatchResult — итог одной задачи пакета
+type BatchResult struct {
+	URL     string
+	Summary Summary
+	Err     error
+}
+
+// RunBatch скачивает все корни пакета и блокируется до завершения или
+// отмены ctx. Корни одного сайта пишут в одну папку и идут друг за
+// другом даже при Parallel > 1; ожидая очереди, они не занимают слот.
+// Итоги — в порядке URLs; повтор корня получает ErrJobActive.
+func RunBatch(ctx context.Context, opts BatchOptions) []BatchResult {
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	running := make(chan struct{}, parallel)
+	pool := NewWorkerPool(opts.Config.Workers)
+	var sites siteTurns
+
+	results := make([]BatchResult, len(opts.URLs))
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i, u := range opts.URLs {
+		results[i].URL = u
+		key, err := NormalizeURL(u)
+		if err != nil {
+			key = u
+		}
+		if seen[key] {
+			results[i].Err = ErrJobActive
+			opts.done(results[i])
+			continue
+		}
+		seen[key] = true
+
+		cfg := opts.Config
+		cfg.WorkerPool = pool
+		cfg.LogPrefix = siteKey(u)
+
+		wg.Add(1)
+		go func(res *BatchResult) {
+			defer wg.Done()
+			defer func() { opts.done(*res) }()
+
+			// Пока идёт задача того же сайта, слот не занимаем (как Manager)
+			leave, ok := sites.enter(ctx, siteKey(res.URL))
+			if !ok {
+				res.Err = ctx.Err()
+				return
+			}
+			defer leave()
+
+			select {
+			case running <- struct{}{}:
+				defer func() { <-running }()
+			case <-ctx.Done():
+				res.Err = ctx.Err()
+				return
+			}
+			// Слот и отмена могли прийти одновременно
+			if res.Err = ctx.Err(); res.Err != nil {
+				return
+			}
+			res.Summary, res.Err = Run(ctx, RunOptions{URL: res.URL, Config: cfg})
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// siteKey — хост URL: задачи с одним хостом пишут в одну папку сайта
+func siteKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.ToLower(u.Host)
+}
+
+// siteTurns — очередь к папкам сайтов: вторая задача того же сайта ждёт,
+// пока первая не закончит, вместо ошибки ErrSiteInUse
+type siteTurns struct {
+	mu    sync.Mutex
+	turns map[string]chan struct{}
+}
+
+// enter ждёт очереди к сайту key; false — ctx отменён раньше
+func (s *siteTurns) enter(ctx context.Context, key string) (leave func(), ok bool) {
+	s.mu.Lock()
+	if s.turns == nil {
+		s.turns = make(map[string]chan struct{})
+	}
+	turn, found := s.turns[key]
+	if !found {
+		turn = make(chan struct{}, 1)
+		s.turns[key] = turn
+	}
+	s.mu.Unlock()
+
+	select {
+	case turn <- struct{}{}:
+		return func() { <-turn }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
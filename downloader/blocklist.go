@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Блок-лист (Config.BlockedURLSubstrings): ссылки, в URL которых есть
+// одна из подстрок (кнопки доната, счётчики, чужие каналы), не
+// скачиваются нигде — ни на сайте, ни на CDN. Пустой список — ничего
+// не блокируется.
+
+// ReasonBlocked — начало FilterReason для заблокированных URL
+const ReasonBlocked = "blocked by substring"
+
+// blockedBy — подстрока из списка, найденная в u
+func blockedBy(blocked []string, u string) (string, bool) {
+	for _, s := range blocked {
+		if s != "" && strings.Contains(u, s) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// blockedReason — FilterReason для URL, заблокированного подстрокой s
+func blockedReason(s string) string {
+	return fmt.Sprintf("%s %q", ReasonBlocked, s)
+}
+
+// skipBlocked учитывает заблокированный URL — один раз на URL, как skipRobots
+func (j *Job) skipBlocked(urlStr, reason string) {
+	j.mu.Lock()
+	seen := j.visited[urlStr]
+	j.visited[urlStr] = true
+	j.mu.Unlock()
+	if !seen {
+		j.sendLog(fmt.Sprintf("[Skip] %s: %s", urlStr, reason), false)
+		atomic.AddInt64(&j.stats.Blocked, 1)
+		j.noteSkip(urlStr, reason)
+	}
+}
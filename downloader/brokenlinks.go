@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Отчёт о битых ссылках: каждый упавший URL с кодом ответа, числом
+// попыток и страницами, которые на него ссылаются. Пишется в конце
+// обхода в <id>.broken-links.json и .csv. Страницы-источники живут
+// только в памяти: у ошибок из прошлых запусков (resume) их нет, как
+// и кода ответа.
+
+const (
+	BrokenLinksExtension    = ".broken-links.json"
+	BrokenLinksCSVExtension = ".broken-links.csv"
+
+	// maxReferrers — сколько страниц-источников помнить для одного URL:
+	// ссылку из шапки сайта видят все страницы, в отчёте хватит нескольких
+	maxReferrers = 10
+)
+
+// BrokenLink — URL, который не удалось скачать
+type BrokenLink struct {
+	URL       string   `json:"url"`
+	Status    int      `json:"status,omitempty"` // Код HTTP; 0 — ответа не было (таймаут, DNS и т.п.)
+	Attempts  int      `json:"attempts,omitempty"`
+	Error     string   `json:"error"`
+	Referrers []string `json:"referrers,omitempty"` // Страницы со ссылкой на URL, по порядку находки
+}
+
+// failureInfo — подробности ошибки URL для отчёта
+type failureInfo struct {
+	status   int
+	attempts int
+}
+
+func failureOf(err error) failureInfo {
+	var de *DownloadError
+	if errors.As(err, &de) {
+		return failureInfo{status: de.Status, attempts: de.Attempts}
+	}
+	return failureInfo{}
+}
+
+// noteReferrer запоминает страницу, ссылающуюся на URL. Вызывать под j.mu.
+func (j *Job) noteReferrer(u, referrer string) {
+	if referrer == "" || referrer == u || j.completed[u] {
+		return
+	}
+	if j.referrers == nil {
+		j.referrers = make(map[string][]string)
+	}
+	refs := j.referrers[u]
+	if len(refs) >= maxReferrers {
+		return
+	}
+	for _, r := range refs {
+		if r == referrer {
+			return
+		}
+	}
+	j.referrers[u] = append(refs, referrer)
+}
+
+// FailedURLs — битые ссылки задачи по порядку URL: упавшие и так и не
+// скачанные, включая ошибки прошлых запусков. Безопасно вызывать во
+// время работы задачи.
+func (j *Job) FailedURLs() []BrokenLink {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.foldPanics()
+	links := make([]BrokenLink, 0, len(j.failed))
+	for u, e := range j.failed {
+		if j.completed[u] {
+			continue
+		}
+		info := j.failures[u]
+		links = append(links, BrokenLink{
+			URL:       u,
+			Status:    info.status,
+			Attempts:  info.attempts,
+			Error:     e,
+			Referrers: append([]string(nil), j.referrers[u]...),
+		})
+	}
+	sort.Slice(links, func(a, b int) bool { return links[a].URL < links[b].URL })
+	return links
+}
+
+// BrokenLinksFile — путь к отчёту о битых ссылках (JSON; CSV рядом)
+func (j *Job) BrokenLinksFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + BrokenLinksExtension
+}
+
+func (j *Job) brokenLinksCSVFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + BrokenLinksCSVExtension
+}
+
+// writeBrokenLinks пишет отчёт в конце обхода; без битых ссылок отчёт
+// прошлого запуска удаляется, чтобы не вводить в заблуждение
+func (j *Job) writeBrokenLinks() {
+	links := j.FailedURLs()
+	if len(links) == 0 {
+		os.Remove(j.BrokenLinksFile())
+		os.Remove(j.brokenLinksCSVFile())
+		return
+	}
+	if err := writeBrokenLinksJSON(j.BrokenLinksFile(), links); err != nil {
+		j.logger().Error("Не удалось записать отчёт о битых ссылках: %v", err)
+		return
+	}
+	if err := writeBrokenLinksCSV(j.brokenLinksCSVFile(), links); err != nil {
+		j.logger().Error("Не удалось записать отчёт о битых ссылках: %v", err)
+		return
+	}
+	j.sendLog(fmt.Sprintf("🔗 Битых ссылок: %d, отчёт: %s", len(links), j.BrokenLinksFile()), false)
+}
+
+func writeBrokenLinksJSON(path string, links []BrokenLink) error {
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// writeBrokenLinksCSV — та же таблица для Excel: страницы-источники
+// через пробел в одной колонке
+func writeBrokenLinksCSV(path string, links []BrokenLink) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"url", "status", "attempts", "error", "referrers"})
+	for _, l := range links {
+		status := ""
+		if l.Status != 0 {
+			status = strconv.Itoa(l.Status)
+		}
+		w.Write([]string{l.URL, status, strconv.Itoa(l.Attempts), l.Error, strings.Join(l.Referrers, " ")})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Бюджет обхода (Config.MaxPages / MaxTotalBytes) — защита от обхода,
+// который забивает диск. Исчерпанный бюджет не обрывает загрузки: ворота
+// паузы закрываются, текущие URL докачиваются и сохраняются, затем задача
+// останавливается как при отмене — очередь остаётся в состоянии, и её
+// можно продолжить через Resume с лимитом побольше.
+
+// Какой из лимитов исчерпан
+const (
+	BudgetPages = "pages"
+	BudgetBytes = "bytes"
+)
+
+// BudgetEvent — бюджет обхода исчерпан, задача останавливается
+type BudgetEvent struct {
+	Limit    string // BudgetPages или BudgetBytes
+	Pages    int64  // Сохранено файлов к моменту остановки
+	Bytes    int64
+	MaxPages int64
+	MaxBytes int64
+}
+
+// BudgetListener — необязательное расширение ProgressListener: остановка по бюджету
+type BudgetListener interface {
+	OnBudgetReached(BudgetEvent)
+}
+
+// savedFiles — сколько файлов сохранено за всю задачу. TotalFiles для
+// этого не годится: он начинается с оценки предварительного обхода.
+func (j *Job) savedFiles() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var n int64
+	for _, c := range j.stats.FileTypes {
+		n += c
+	}
+	return n
+}
+
+// budgetExceeded сверяет счётчики с лимитами; 0 — без лимита
+func (j *Job) budgetExceeded() (BudgetEvent, bool) {
+	if j.Config.MaxPages <= 0 && j.Config.MaxTotalBytes <= 0 {
+		return BudgetEvent{}, false
+	}
+	ev := BudgetEvent{
+		Pages:    j.savedFiles(),
+		Bytes:    atomic.LoadInt64(&j.stats.DownloadedBytes),
+		MaxPages: j.Config.MaxPages,
+		MaxBytes: j.Config.MaxTotalBytes,
+	}
+	switch {
+	case ev.MaxPages > 0 && ev.Pages >= ev.MaxPages:
+		ev.Limit = BudgetPages
+	case ev.MaxBytes > 0 && ev.Bytes >= ev.MaxBytes:
+		ev.Limit = BudgetBytes
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
+// stopForBudget останавливает задачу один раз: новые URL воркерам не
+// выдаются, а когда текущие загрузки закончатся, контекст отменяется
+func (j *Job) stopForBudget(ev BudgetEvent) {
+	j.budgetOnce.Do(func() {
+		j.mu.Lock()
+		j.budget = &ev
+		j.mu.Unlock()
+
+		if ev.Limit == BudgetPages {
+			j.sendLog(fmt.Sprintf("🛑 Достигнут лимит файлов: %d из %d, ждём текущие загрузки...", ev.Pages, ev.MaxPages), false)
+		} else {
+			j.sendLog(fmt.Sprintf("🛑 Достигнут лимит объёма: %d из %d байт, ждём текущие загрузки...", ev.Bytes, ev.MaxBytes), false)
+		}
+		j.emit(&event{kind: eventBudget, budget: ev})
+
+		// Уже на паузе — взятые URL докачиваются и без нас
+		drained, ok := j.pause.pause()
+		go func() {
+			if ok {
+				select {
+				case <-drained:
+				case <-j.ctx.Done():
+				}
+			}
+			j.cancel()
+		}()
+	})
+}
+
+// budgetReached — событие остановки по бюджету; nil, если бюджет не исчерпан
+func (j *Job) budgetReached() *BudgetEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.budget
+}
@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+
+	"golang.org/x/net/html/charset"
+)
+
+// x/net/html читает байты как UTF-8: страница в windows-1251 или
+// shift_jis после разбора и рендера превращается в кракозябры. Поэтому
+// и загрузчик, и обработчик перекодируют её в UTF-8 до разбора.
+
+// charsetPrescan — сколько байт от начала страницы смотреть в поисках
+// <meta charset>, как у браузеров
+const charsetPrescan = 1024
+
+// metaCharsetRe находит значение charset в <meta charset="..."> и в
+// <meta http-equiv="Content-Type" content="text/html; charset=...">
+var metaCharsetRe = regexp.MustCompile(`(?is)<meta\s[^>]*?charset\s*=\s*["']?\s*([^\s"'/>;]+)`)
+
+// headOpenRe — открывающие теги, после которых можно вставить <meta charset>
+var headOpenRe = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)<head(?:\s[^>]*)?>`),
+	regexp.MustCompile(`(?i)<html(?:\s[^>]*)?>`),
+	regexp.MustCompile(`(?i)<!doctype[^>]*>`),
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// HTMLToUTF8 перекодирует страницу в UTF-8, если она объявляет другую
+// кодировку: charset из Content-Type важнее <meta> в первых 1024 байтах,
+// BOM UTF-8 — важнее обоих. В результате <meta charset> указывает на
+// utf-8 (если тега не было — он добавляется в <head>). from — исходная
+// кодировка; пусто — страница уже в UTF-8, без объявления или с
+// неизвестной кодировкой и возвращается как есть.
+func HTMLToUTF8(content []byte, contentType string) (out []byte, from string) {
+	if bytes.HasPrefix(content, utf8BOM) {
+		return content, ""
+	}
+	name := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		name = params["charset"]
+	}
+	if name == "" {
+		head := content
+		if len(head) > charsetPrescan {
+			head = head[:charsetPrescan]
+		}
+		if m := metaCharsetRe.FindSubmatch(head); m != nil {
+			name = string(m[1])
+		}
+	}
+	if name == "" {
+		return content, ""
+	}
+	enc, canonical := charset.Lookup(name)
+	if enc == nil || canonical == "utf-8" {
+		return content, ""
+	}
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return content, ""
+	}
+	return declareUTF8(decoded), canonical
+}
+
+// declareUTF8 переписывает charset во всех <meta> на utf-8, а если
+// объявления нет — вставляет <meta charset="utf-8"> в начало <head>
+func declareUTF8(page []byte) []byte {
+	matches := metaCharsetRe.FindAllSubmatchIndex(page, -1)
+	if len(matches) > 0 {
+		var b bytes.Buffer
+		last := 0
+		for _, m := range matches {
+			b.Write(page[last:m[2]])
+			b.WriteString("utf-8")
+			last = m[3]
+		}
+		b.Write(page[last:])
+		return b.Bytes()
+	}
+
+	meta := []byte(`<meta charset="utf-8">`)
+	for _, re := range headOpenRe {
+		if loc := re.FindIndex(page); loc != nil {
+			return append(append(append([]byte(nil), page[:loc[1]]...), meta...), page[loc[1]:]...)
+		}
+	}
+	return append(meta, page...)
+}
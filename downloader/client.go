@@ -9,11 +9,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
-	"path"
     "path/filepath"
 	"net/url"
 	"os"
@@ -24,11 +22,14 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/net/html"
+
+	"sitemvp/server"
 )
 
 
@@ -59,10 +60,18 @@ type JobStats struct {
 	TotalFiles      int64
 	DownloadedBytes int64
 	Failed          int64
+	Permanent       int64 // Из Failed: окончательный отказ сервера (4xx, кроме 429), без повторов
 	Skipped         int64
+	Recovered       int64 // Скачаны со второй попытки после отложенного повтора
+	UnsafePaths     int64 // Отклонены: путь выходил за пределы папки сайта
+	CacheHits       int64 // Файлы, взятые ссылкой из общего кеша
+	CacheBytesSaved int64
+	Deduplicated    int64 // Повторы уже сохранённого содержимого, записаны алиасами
+	Unchanged       int64 // Инкрементальный режим: сервер ответил 304, локальная копия оставлена
+	Blocked         int64 // Ссылки, отброшенные по BlockedURLSubstrings
 	Speed           float64
 	ETA             time.Duration
-	FileTypes       map[string]int64
+	FileTypes       map[string]int64 // Сохранено по категориям: html, css, js, image, font, video, other
 	StartTime       time.Time
 }
 
@@ -73,6 +82,12 @@ type JobState struct {
 	DepthMap    map[string]int
 	Stats       JobStats
 	Config      Config
+	Redirects   map[string]string `json:",omitempty"` // Исходный URL → финальный
+
+	Version   int               `json:",omitempty"` // StateVersion; старые файлы — без него
+	Completed []string          `json:",omitempty"` // Сохранённые URL: при resume не качаются заново
+	Failed    map[string]string `json:",omitempty"` // URL → последняя ошибка (см. ResumeOptions.RetryFailed)
+	Handlers  []string          `json:",omitempty"` // Имена обработчиков (см. register.go)
 }
 
 type Config struct {
@@ -83,6 +98,172 @@ type Config struct {
 	MaxFileSize int64
 	OutputDir   string
 	UserAgent   string
+
+	// Браузер, которым представляется загрузчик (см. useragent.go):
+	// UAPreset — пресет с согласованными Accept и client hints,
+	// UserAgents — UA (или имена пресетов) для ротации по UARotation.
+	// Пусто — UserAgent, а без него DefaultUserAgent.
+	UAPreset   string
+	UserAgents []string
+	UARotation string
+
+	// Порог подряд идущих DNS/connect ошибок и время "отключения" хоста
+	HostFailureThreshold int
+	HostCooldown         time.Duration
+
+	// Как часто дописывать дельту состояния в журнал: раз в CheckpointInterval
+	// и после каждых CheckpointPages обработанных URL (0 — значения по умолчанию)
+	CheckpointInterval time.Duration
+	CheckpointPages    int
+
+	// Через сколько воркер на одном URL считается зависшим
+	StuckThreshold time.Duration
+
+	// Лимиты размера по префиксу Content-Type ("video/": 200<<20), перекрывают MaxFileSize
+	MaxFileSizeByType map[string]int64
+
+	// Ёмкость очереди в памяти; лишние URL уходят в файл переполнения
+	QueueSize int
+
+	// Порядок обхода очереди: CrawlBFS (по умолчанию), CrawlDFS или
+	// CrawlAssetsFirst (см. frontier.go)
+	Strategy string
+
+	// Дополнительные заголовки для запросов к хостам сайта (перекрывают
+	// стандартные Accept, Accept-Language, Referer); Host подменяет
+	// req.Host. CDN и внешним хостам не уходят; в состояние не
+	// сохраняются — там бывают токены
+	Headers map[string]string `json:"-"`
+
+	// Таймаут HEAD/GET проверок (Prober), обычно короче основного
+	ProbeTimeout time.Duration
+
+	// Таймаут одной попытки загрузки (см. timeouts.go): PageTimeout — для
+	// страниц и URL без расширения, AssetTimeout — для картинок, видео и
+	// других ассетов; 0 — DefaultPageTimeout и DefaultAssetTimeout
+	PageTimeout  time.Duration
+	AssetTimeout time.Duration
+
+	// Сколько раз URL с временным сбоем (таймаут, 5xx, сброс соединения)
+	// возвращается в очередь после её опустошения; < 0 — не возвращать
+	DeferredRetries    int
+	DeferredRetryDelay time.Duration
+
+	// Сколько секунд без новых URL считать обход завершённым (ETA становится точным)
+	DiscoveryQuiet time.Duration
+
+	// Общий кеш по sha256 для одинаковых файлов разных сайтов (по умолчанию выключен).
+	// Пустой SharedCacheDir — OutputDir/.cache
+	SharedCache    bool
+	SharedCacheDir string
+
+	// Не сохранять второй раз ассет с уже скачанным содержимым: URL
+	// становится алиасом первого файла (см. dedupe.go). Страницы — всегда копией.
+	DedupeContent bool
+
+	// Query у ассетов (см. queries.go): AssetQueryEncode (по умолчанию,
+	// пустая строка) убирает CacheBusters и переносит остальной query
+	// в имя файла, AssetQueryIgnore отбрасывает query целиком.
+	// Пустой CacheBusters — DefaultCacheBusters.
+	AssetQueries string
+	CacheBusters []string
+
+	// Соблюдать robots.txt хоста: Disallow/Allow и Crawl-delay
+	RespectRobots bool
+
+	// Не ходить по ссылкам rel="nofollow" и по ссылкам страниц с
+	// meta robots или X-Robots-Tag nofollow (см. nofollow.go)
+	RespectNoFollow bool
+
+	// Общий лимит трафика всех воркеров, байт в секунду; 0 — без лимита
+	MaxBytesPerSecond int64
+
+	// Качать CSS, JS, шрифты и картинки с чужих хостов (CDN) в
+	// OutputDir/<хост>/... (см. external.go). ExtraDomains — только с этих
+	// хостов и их поддоменов; пусто — с любых, по расширению ассета.
+	DownloadExternalAssets bool
+	ExtraDomains           []string
+
+	// Инкрементальный обход в ту же OutputDir (см. incremental.go): уже
+	// сохранённые файлы запрашиваются условным GET и на 304 не качаются
+	Incremental bool
+
+	// Что писать (см. warc.go): FormatTree — папку сайта (и пустая строка),
+	// FormatWARC — только архив <id>.warc.gz, FormatWARCTree — и то и другое
+	Format string
+
+	// Сохранять метаданные ответа (статус, заголовки, ETag, время снятия
+	// копии) для каждого файла в <хост>/.meta/ (см. filemeta.go)
+	SaveMetadata bool
+
+	// Обходить и поддомены корня (blog.example.com); www.example.com
+	// и example.com — один сайт и без этого. Файлы поддоменов лежат
+	// в папках своих хостов.
+	IncludeSubdomains bool
+
+	// Редиректы (см. redirects.go): не больше MaxRedirects подряд на один
+	// запрос (0 — DefaultMaxRedirects, < 0 — не следовать), длинная цепочка
+	// или петля — окончательная ошибка URL без повторов. Редирект с сайта на
+	// чужой хост не выполняется, URL пропускается с причиной "redirected
+	// off-site to …"; FollowExternalRedirects — скачивать, куда он ведёт.
+	MaxRedirects            int
+	FollowExternalRedirects bool
+
+	// Искать адреса в строках JS и JSON (эндпоинты fetch, пути картинок,
+	// см. jsparser.go). Выключено по умолчанию: обход может сильно вырасти.
+	ParseJavaScript bool
+
+	// Бюджет обхода (см. budget.go): сколько файлов сохранить и сколько
+	// байт скачать за всю задачу, включая прошлые запуски; 0 — без лимита
+	MaxPages      int64
+	MaxTotalBytes int64
+
+	// Шаблоны URL (glob по пути или "re:" + regexp, см. patterns.go):
+	// исключения важнее включений, пустой IncludePatterns — всё
+	IncludePatterns []string
+	ExcludePatterns []string
+
+	// Подстроки URL, которые не скачиваются никогда (см. blocklist.go);
+	// пусто — ничего не блокируется
+	BlockedURLSubstrings []string
+
+	// Одна страница с её ассетами, без обхода (см. singlepage.go)
+	SinglePage bool
+
+	// Пробный прогон (см. dryrun.go): обойти сайт и составить отчёт
+	// <id>.dryrun.json — что и какого размера было бы скачано, что
+	// отброшено фильтром, — ничего не сохраняя
+	DryRun bool `json:"-"`
+
+	// Cookie для сайтов за логином. Cookies — значения заголовка Cookie
+	// ("session=abc; theme=dark") для хоста задачи; в файл состояния не
+	// пишутся, при resume их передают заново. CookieFile — cookies.txt
+	// в формате Netscape (экспорт из браузера).
+	Cookies    []string `json:"-"`
+	CookieFile string
+
+	// Детерминированный режим для тестов (см. deterministic.go): джиттер
+	// из RNG с Seed, отсортированная очередь ссылок, время из Clock.
+	// Выключен — поведение не меняется.
+	Deterministic bool
+	Seed          int64
+	Clock         func() time.Time `json:"-"`
+
+	// Лог загрузчика (см. logger.go). Logger — свой приёмник; без него —
+	// OutputDir/downloader.log с ротацией и, с LogStderr, стандартный log.
+	// LogLevel — LogDebug, LogInfo (и пустая строка), LogWarn или LogError;
+	// Debug — строки по каждой ссылке. LogPrefix — метка перед каждой
+	// строкой (хост задачи в пакетной загрузке). В состояние не пишутся:
+	// это настройки запуска, а не задачи.
+	Logger    Logger `json:"-"`
+	LogLevel  string `json:"-"`
+	LogStderr bool   `json:"-"`
+	LogPrefix string `json:"-"`
+
+	// WorkerPool — бюджет воркеров, общий для нескольких задач (см.
+	// batch.go): URL скачивается, только когда в пуле есть свободный слот.
+	// nil — задача ограничена только своими Workers.
+	WorkerPool *WorkerPool `json:"-"`
 }
 
 type ContentParser interface {
@@ -96,37 +277,99 @@ type URLFilter interface {
 }
 
 type ContentHandler interface {
+	Name() string // Уникальное имя: по нему обработчик узнаётся при resume (см. register.go)
 	Priority() int
 	Handle(content []byte, meta FileMetadata) ([]byte, error)
 }
 
 // HTMLParser для извлечения СЫРЫХ ссылок (без изменений)
-type HTMLParser struct{}
+type HTMLParser struct {
+	NoFollow bool // Пропускать ссылки nofollow (Config.RespectNoFollow, см. nofollow.go)
+}
 
 func (p *HTMLParser) CanParse(ct string) bool { return strings.Contains(ct, "text/html") }
 
 func (p *HTMLParser) Parse(content []byte, baseURL string) ([]string, error) {
+	links, _, err := p.parse(content, baseURL, false)
+	return links, err
+}
+
+// parse — ссылки страницы и отдельно nofollow: гиперссылки, по которым
+// с NoFollow не ходят. pageNoFollow — вся страница nofollow (X-Robots-Tag).
+func (p *HTMLParser) parse(content []byte, baseURL string, pageNoFollow bool) (links, nofollow []string, err error) {
 	doc, err := html.Parse(bytes.NewReader(content))
 	if err != nil {
-		return nil, ErrParseFailed
+		return nil, nil, ErrParseFailed
+	}
+	pageNoFollow = p.NoFollow && (pageNoFollow || metaNoFollow(doc))
+	// follow — гиперссылка: при nofollow уходит в отдельный список
+	follow := func(n *html.Node, link string) {
+		if pageNoFollow || p.NoFollow && relNoFollow(n) {
+			nofollow = append(nofollow, link)
+		} else {
+			links = append(links, link)
+		}
 	}
-	var links []string
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
 			switch n.Data {
-			case "a", "link":
+			case "a":
+				for _, a := range n.Attr {
+					if a.Key == "href" {
+						follow(n, a.Val)
+					}
+				}
+			case "link":
 				for _, a := range n.Attr {
 					if a.Key == "href" {
 						links = append(links, a.Val)
 					}
 				}
 			case "img", "script", "source":
+				for _, a := range n.Attr {
+					switch a.Key {
+					case "src":
+						links = append(links, a.Val)
+					case "srcset":
+						// <img srcset> и <source srcset> в picture/video/audio
+						links = append(links, splitSrcset(a.Val)...)
+					}
+				}
+			case "video":
+				for _, a := range n.Attr {
+					if a.Key == "src" || a.Key == "poster" {
+						links = append(links, a.Val)
+					}
+				}
+			case "audio":
 				for _, a := range n.Attr {
 					if a.Key == "src" {
 						links = append(links, a.Val)
 					}
 				}
+			case "meta":
+				if target, ok := refreshTarget(n); ok && IsRefreshMeta(n) {
+					follow(n, target)
+				}
+			case "use", "image":
+				// Inline SVG: спрайты (/icons.svg#home) и картинки; xlink:href тоже приходит как href
+				for _, a := range n.Attr {
+					if a.Key == "href" {
+						links = append(links, a.Val)
+					}
+				}
+			}
+			// Ленивая загрузка: настоящий адрес лежит в data-атрибутах любого элемента
+			for _, a := range n.Attr {
+				if !lazyAttrs[a.Key] {
+					continue
+				}
+				if srcsetAttr(a.Key) {
+					links = append(links, splitSrcset(a.Val)...)
+				} else {
+					links = append(links, a.Val)
+				}
 			}
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
@@ -135,8 +378,9 @@ func (p *HTMLParser) Parse(content []byte, baseURL string) ([]string, error) {
 	}
 	f(doc)
 
-	// Возвращаем СЫРЫЕ ссылки (без замены .php → .html)
-	return resolveRawLinks(links, baseURL), nil
+	// Возвращаем СЫРЫЕ ссылки (без замены .php → .html) от базы документа
+	base := documentBase(doc, baseURL)
+	return resolveRawLinks(links, base), resolveRawLinks(nofollow, base), nil
 }
 
 type CSSParser struct{}
@@ -144,22 +388,13 @@ type CSSParser struct{}
 func (p *CSSParser) CanParse(ct string) bool { return strings.Contains(ct, "text/css") }
 
 func (p *CSSParser) Parse(content []byte, baseURL string) ([]string, error) {
-	re := regexp.MustCompile(`(?i)url\s*\(\s*['"]?([^'")]+)['"]?\s*\)`)
-	matches := re.FindAllSubmatch(content, -1)
-	var links []string
-	for _, m := range matches {
-		if len(m[1]) > 0 {
-			links = append(links, string(m[1]))
-		}
-	}
-	return resolveRawLinks(links, baseURL), nil
+	return resolveRawLinks(extractCSSURLs(content), baseURL), nil
 }
 
 // resolveRawLinks — разрешает ссылки БЕЗ изменений расширений
 func resolveRawLinks(links []string, baseURL string) []string {
 	var resolved []string
 	base, _ := url.Parse(baseURL)
-	bad := []string{"devnull", "410011174743222", "yoomoney", "t.me/metanitcom"}
 
 	for _, l := range links {
 		l = strings.TrimSpace(l)
@@ -175,138 +410,195 @@ func resolveRawLinks(links []string, baseURL string) []string {
 			continue
 		}
 		res := base.ResolveReference(u).String()
+		resolved = append(resolved, res)
+	}
+	return resolved
+}
 
-		skip := false
-		for _, p := range bad {
-			if strings.Contains(res, p) {
-				skip = true
-				break
-			}
+type DefaultURLFilter struct {
+	domain   string
+	basePath string
+	robots   *robotsRules // nil — robots.txt не учитывается
+	include  []urlPattern // Пусто — все страницы (см. patterns.go)
+	exclude  []urlPattern
+	external externalAssets // Ассеты с CDN (см. external.go)
+	hosts    siteHosts      // www-вариант и поддомены корня (см. sitehosts.go)
+	blocked  []string       // Config.BlockedURLSubstrings (см. blocklist.go)
+}
+
+func (f *DefaultURLFilter) ShouldDownload(u string) bool {
+    parsed, err := url.Parse(u)
+    if err != nil {
+        return false
+    }
+
+    // Блок-лист действует везде, в том числе на CDN
+    if _, blocked := blockedBy(f.blocked, u); blocked {
+        return false
+    }
+
+    // 1. Проверка домена (не скачиваем внешние сайты, кроме ассетов с CDN)
+    if !f.hosts.contains(parsed.Host) {
+        if !f.external.allows(parsed) {
+            return false
+        }
+        // robots.txt сайта к CDN не относится, а исключения действуют везде
+        _, excluded := matchPattern(f.exclude, parsed)
+        return !excluded
+    }
+
+    // robots.txt закрывает и страницы, и ассеты — правила загружены для корня
+    if parsed.Host == f.domain && !f.robots.allowed(parsed.RequestURI()) {
+        return false
+    }
+
+    // ExcludePatterns тоже действуют на всё и важнее IncludePatterns
+    if _, excluded := matchPattern(f.exclude, parsed); excluded {
+        return false
+    }
+
+    pathLower := strings.ToLower(parsed.Path)
+
+    // 2. Если это статический ассет — разрешаем скачивание из любого места на этом домене
+    if isAssetPath(pathLower) {
+        return true
+    }
+
+    // IncludePatterns отбирают страницы; пустой список — все
+    if len(f.include) > 0 {
+        if _, included := matchPattern(f.include, parsed); !included {
+            return false
+        }
+    }
+
+    // 3. Проверка для страниц (HTML, PHP или URL без расширения)
+    // Разрешаем, только если они находятся внутри базовой папки (basePath)
+    isPage := strings.HasSuffix(pathLower, ".html") ||
+              strings.HasSuffix(pathLower, ".php") ||
+              !strings.Contains(filepath.Base(pathLower), ".")
+
+    if isPage {
+        return strings.HasPrefix(parsed.Path, f.basePath)
+    }
+
+    // По умолчанию разрешаем всё остальное, что не попало в фильтр страниц,
+    // но находится на нашем домене (на всякий случай)
+    return true
+}
+
+// assetExts — расширения статических ресурсов (ассетов)
+var assetExts = []string{
+	".css", ".js", ".mjs", ".json", ".map",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".avif",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp4", ".webm", ".mp3", ".wav", ".pdf",
+}
+
+func isAssetPath(pathLower string) bool {
+	for _, ext := range assetExts {
+		if strings.HasSuffix(pathLower, ext) {
+			return true
 		}
-		if !skip {
-			resolved = append(resolved, res)
-			log.Printf("Resolved RAW link: %s", res)
+	}
+	return false
+}
+
+func (f *DefaultURLFilter) FilterReason(u string) string {
+	if s, blocked := blockedBy(f.blocked, u); blocked {
+		return blockedReason(s)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || !f.hosts.contains(parsed.Host) {
+		return "outside base path or not asset"
+	}
+	if parsed.Host == f.domain && !f.robots.allowed(parsed.RequestURI()) {
+		return ReasonRobots
+	}
+	if p, excluded := matchPattern(f.exclude, parsed); excluded {
+		return fmt.Sprintf("excluded by pattern %q", p)
+	}
+	if len(f.include) > 0 && !isAssetPath(strings.ToLower(parsed.Path)) {
+		if _, included := matchPattern(f.include, parsed); !included {
+			return "not matched by include patterns"
 		}
 	}
-	return resolved
+	return "outside base path or not asset"
+}
+
+type LinkRewriterHandlerV2 struct {
+	outputDir string
+	saved     *savedPaths  // Фактические пути уже сохранённых URL
+	redirects *redirectMap // Куда переехали URL, ответившие редиректом
+	queries   assetQueries // Политика query ассетов задачи (см. queries.go)
+	scheme    *schemeCanon // Под каким протоколом они сохранены
+	external  func(*url.URL) bool // Ссылки на чужой хост, у которых будет локальная копия
+	local     func(*url.URL, bool) bool // Будет ли локальная копия у ссылки (ресурса страницы); nil — у всех (см. singlepage.go)
+	log       Logger              // Переписанные ссылки (Debug); nil — не писать
 }
 
-func replacePhpToHtmlLinks(content []byte, baseURL string) ([]byte, error) {
+func (h *LinkRewriterHandlerV2) Priority() int { return 10 }
+
+func (h *LinkRewriterHandlerV2) Handle(content []byte, meta FileMetadata) ([]byte, error) {
+	// Пропускаем не-HTML контент
+	if !strings.Contains(meta.ContentType, "text/html") {
+		return content, nil
+	}
+
 	doc, err := html.Parse(bytes.NewReader(content))
 	if err != nil {
 		return content, nil
 	}
 
-	baseParsed, _ := url.Parse(baseURL)
+	// С <base href> ссылки разрешаем от него и делаем абсолютными, а сам
+	// href снимаем: непереписанные ссылки так и ведут, куда вели на сайте
+	var docBase *url.URL
+	if b := documentBase(doc, meta.URL); b != meta.URL {
+		docBase, _ = url.Parse(b)
+		stripBase(doc)
+	}
 
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
 			for i := range n.Attr {
 				attr := &n.Attr[i]
-				if attr.Key == "href" || attr.Key == "src" || attr.Key == "action" {
-					orig := attr.Val
-
-					// Пропускаем специальные протоколы
-					if strings.HasPrefix(orig, "data:") ||
-						strings.HasPrefix(orig, "#") ||
-						strings.HasPrefix(orig, "javascript:") ||
-						strings.HasPrefix(orig, "mailto:") ||
-						strings.HasPrefix(orig, "tel:") {
-						continue
-					}
-
-					// Разбираем URL
-					u, err := url.Parse(orig)
-					if err != nil {
-						continue
+				if attr.Key == "content" && IsRefreshMeta(n) {
+					// Меняем только адрес: задержка и "url=" остаются как были
+					if before, target, after, ok := SplitRefresh(attr.Val); ok {
+						if docBase != nil {
+							if ref, err := url.Parse(target); err == nil && ref.Scheme == "" {
+								target = docBase.ResolveReference(ref).String()
+							}
+						}
+						attr.Val = before + h.rewriteLink(target, meta) + after
 					}
-
-					// Пропускаем внешние ссылки
-					if u.Host != "" && u.Host != baseParsed.Host {
+					continue
+				}
+				if attr.Key == "href" || attr.Key == "src" || attr.Key == "action" {
+					// Пропускаем пустые ссылки
+					if attr.Val == "" {
 						continue
 					}
 
-					// Обрабатываем путь
-					path := u.Path
-
-					// Если путь пустой или корневой
-					if path == "" || path == "/" {
-						// Для корня оставляем как есть
-						u.Path = "/"
-						attr.Val = u.String()
+					// Для локальных файлов (file://) не трогаем
+					if strings.HasPrefix(attr.Val, "file://") {
 						continue
 					}
 
-					// Пропускаем ссылки на ресурсы (CSS, JS, изображения)
-					// Проверяем расширения файлов
-					lowerPath := strings.ToLower(path)
-					isResource := strings.HasSuffix(lowerPath, ".css") ||
-						strings.HasSuffix(lowerPath, ".js") ||
-						strings.HasSuffix(lowerPath, ".mjs") ||
-						strings.HasSuffix(lowerPath, ".cjs") ||
-						strings.HasSuffix(lowerPath, ".png") ||
-						strings.HasSuffix(lowerPath, ".jpg") ||
-						strings.HasSuffix(lowerPath, ".jpeg") ||
-						strings.HasSuffix(lowerPath, ".gif") ||
-						strings.HasSuffix(lowerPath, ".svg") ||
-						strings.HasSuffix(lowerPath, ".ico") ||
-						strings.HasSuffix(lowerPath, ".woff") ||
-						strings.HasSuffix(lowerPath, ".woff2") ||
-						strings.HasSuffix(lowerPath, ".ttf") ||
-						strings.HasSuffix(lowerPath, ".eot") ||
-						strings.HasSuffix(lowerPath, ".otf") ||
-						strings.HasSuffix(lowerPath, ".mp4") ||
-						strings.HasSuffix(lowerPath, ".webm") ||
-						strings.HasSuffix(lowerPath, ".mp3") ||
-						strings.HasSuffix(lowerPath, ".wav") ||
-						strings.HasSuffix(lowerPath, ".ogg")
-
-					if isResource {
-						// Для ресурсов оставляем ссылки как есть
-						continue
+					if docBase != nil {
+						if ref, err := url.Parse(attr.Val); err == nil && ref.Scheme == "" {
+							attr.Val = docBase.ResolveReference(ref).String()
+						}
 					}
 
-					// Преобразуем .php ссылки (только для HTML страниц)
-					if strings.HasSuffix(lowerPath, ".php") {
-						// Убираем .php
-						newPath := strings.TrimSuffix(path, ".php")
-
-						// Если это был "index.php", преобразуем в "/"
-						if strings.HasSuffix(strings.ToLower(newPath), "/index") {
-							newPath = strings.TrimSuffix(newPath, "/index")
-							if newPath == "" {
-								newPath = "/"
-							}
-						} else if strings.EqualFold(newPath, "index") {
-							// Если просто "index.php", тоже в "/"
-							newPath = "/"
-						}
+					// Путь цели берём из реестра сохранённых файлов, а не угадываем
+					newURL := h.rewrite(attr.Val, meta, resourceAttr(n, attr.Key))
 
-						u.Path = newPath
-						attr.Val = u.String()
-						log.Printf("🔗 Rewrote PHP link: %s → %s", orig, attr.Val)
-					} else if strings.HasSuffix(lowerPath, ".html") ||
-						strings.HasSuffix(lowerPath, ".htm") {
-						// Преобразуем .html ссылки
-						// Убираем расширение
-						newPath := strings.TrimSuffix(
-							strings.TrimSuffix(path, ".html"), ".htm")
-
-						// Если это был "index.html", преобразуем в "/"
-						if strings.HasSuffix(strings.ToLower(newPath), "/index") {
-							newPath = strings.TrimSuffix(newPath, "/index")
-							if newPath == "" {
-								newPath = "/"
-							}
-						} else if strings.EqualFold(newPath, "index") {
-							// Если просто "index.html", тоже в "/"
-							newPath = "/"
+					if newURL != attr.Val {
+						attr.Val = localHref(newURL)
+						if h.log != nil {
+							h.log.Debug("🔗 Rewrote link: %s → %s (from: %s)", attr.Val, newURL, meta.URL)
 						}
-
-						u.Path = newPath
-						attr.Val = u.String()
-						log.Printf("🔗 Rewrote HTML link: %s → %s", orig, attr.Val)
 					}
 				}
 			}
@@ -322,662 +614,610 @@ func replacePhpToHtmlLinks(content []byte, baseURL string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// FileSaveStrategy - стратегия сохранения файлов
-type FileSaveStrategy interface {
-	ShouldSaveAsDirectory(url string, contentType string) bool
-	GetSavePath(outputDir string, url string, contentType string) (string, string) // путь, имя файла
-	RewriteLink(originalURL, baseURL string) string
+// localHref — ссылка rewriteLink в том виде, в каком она пишется в
+// атрибут: у локальных относительных путей всегда "./" или "../"
+func localHref(newURL string) string {
+	if strings.Contains(newURL, "://") || strings.HasPrefix(newURL, "/") ||
+		strings.HasPrefix(newURL, "./") || strings.HasPrefix(newURL, "../") {
+		return newURL
+	}
+	return "./" + newURL
 }
 
-// DirectoryIndexStrategy - стратегия "директория с index.html"
-type DirectoryIndexStrategy struct{}
+// rewriteLink строит относительную ссылку от файла страницы к файлу цели.
+// Если цель уже сохранена — используется её фактический путь; если ещё нет —
+// путь, который saveFile выберет для неё без Content-Type (savePath).
+func (h *LinkRewriterHandlerV2) rewriteLink(originalURL string, meta FileMetadata) string {
+	return h.rewrite(originalURL, meta, false)
+}
 
-func (s *DirectoryIndexStrategy) ShouldSaveAsDirectory(urlStr string, contentType string) bool {
-	parsed, err := url.Parse(urlStr)
-	if err != nil {
-		return false
+// rewrite — rewriteLink для ссылки из атрибута; resource — атрибут
+// подключает ресурс страницы (см. resourceAttr)
+func (h *LinkRewriterHandlerV2) rewrite(originalURL string, meta FileMetadata, resource bool) string {
+	if strings.HasPrefix(originalURL, "#") ||
+		strings.HasPrefix(originalURL, "javascript:") ||
+		strings.HasPrefix(originalURL, "mailto:") ||
+		strings.HasPrefix(originalURL, "tel:") ||
+		strings.HasPrefix(originalURL, "data:") {
+		return originalURL
+	}
+
+	parsed, err1 := url.Parse(originalURL)
+	base, err2 := url.Parse(meta.URL)
+	if err1 != nil || err2 != nil {
+		return originalURL
+	}
+	target := base.ResolveReference(parsed)
+	if target.Host != base.Host && (h.external == nil || !h.external(target)) {
+		return originalURL
+	}
+	// Страницы, которые не скачиваются, — на исходный сайт, а не в пустоту
+	if h.local != nil && (target.Host != base.Host || target.Path != base.Path) && !h.local(target, resource) {
+		return target.String()
+	}
+	// /old ответил редиректом на /new/: ссылка ведёт в файл финального адреса
+	if key, err := NormalizeURL(target.String()); err == nil && h.redirects != nil {
+		if h.scheme != nil {
+			key = h.scheme.canonical(key)
+		}
+		if final, ok := h.redirects.lookup(key); ok {
+			if fu, err := url.Parse(final); err == nil {
+				target = fu
+			}
+		}
 	}
-	path := parsed.Path
-
-	// Для HTML контента сохраняем как директорию
-	if strings.Contains(contentType, "text/html") {
-		return true
+	// /404 и т.п. сохраняются в 404.html у корня хоста (см. errorpage.go)
+	if isErrorRoute(target.String()) {
+		target = &url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/" + ErrorPageName}
 	}
-
-	// Для .php файлов тоже (даже если content-type не указан)
-	if strings.HasSuffix(strings.ToLower(path), ".php") {
-		return true
+	// Без cache buster'ов, как URL в очереди; значимый query уйдёт в имя файла
+	target = h.queries.apply(target)
+	query := parsed.RawQuery
+	if queryInName(target) {
+		query = ""
 	}
 
-	// Для путей без расширения, которые не являются ресурсами
-	if !strings.Contains(path, ".") && path != "/" && path != "" {
-		// Проверяем, не является ли это API endpoint или подобным
-		if !strings.Contains(path, "/api/") &&
-			!strings.Contains(path, "/ajax/") &&
-			!strings.Contains(path, "/rest/") {
-			return true
+	// Пути — от папки корня сайта, как в реестре; те же, что выберет saveFile
+	root := base.Host
+	if h.scheme != nil && h.scheme.host != "" {
+		root = h.scheme.host
+	}
+	targetPath, ok := h.sitePath(root, target, "")
+	if !ok {
+		return originalURL
+	}
+	if key, err := NormalizeURL(target.String()); err == nil {
+		if h.scheme != nil {
+			key = h.scheme.canonical(key)
+		}
+		if sp, ok := h.saved.lookup(key); ok {
+			targetPath = sp.Path
 		}
 	}
+	sourcePath, ok := h.sitePath(root, base, meta.ContentType)
+	if !ok {
+		return originalURL
+	}
 
-	return false
-}
-
-func (s *DirectoryIndexStrategy) GetSavePath(outputDir string, urlStr string, contentType string) (string, string) {
-	parsed, err := url.Parse(urlStr)
+	// Пути считаем от папки загрузок: "../" у файлов других хостов
+	// выводит из папки корня, и Rel без общего начала не справился бы
+	rel, err := filepath.Rel(filepath.Join(root, filepath.Dir(filepath.FromSlash(sourcePath))), filepath.Join(root, filepath.FromSlash(targetPath)))
 	if err != nil {
-		log.Printf("Parse error in GetSavePath: %v", err)
-		return "", ""
+		return originalURL
 	}
-	host := parsed.Host
-	path := parsed.Path
+	relPath := filepath.ToSlash(rel)
 
-	// Нормализуем путь
-	if path == "" || path == "/" {
-		path = "/"
+	// Как и раньше, ссылки на каталоги без index.html: href="../assets/"
+	relPath = strings.TrimSuffix(relPath, "index.html")
+	if relPath == "" {
+		relPath = "./"
 	}
 
-	cleanPath := strings.TrimPrefix(path, "/")
-	if cleanPath == "" {
-		cleanPath = "index"
-	}
+	// Query и фрагмент сохраняем
+	res := &url.URL{Path: relPath, RawQuery: query, Fragment: parsed.Fragment}
+	return res.String()
+}
 
-	// Разделяем путь на части
-	var parts []string
-	if cleanPath != "" {
-		parts = strings.Split(cleanPath, "/")
+// sitePath — путь, который saveFile выберет для URL, от папки корня сайта
+// (с учётом уже созданных папок). Файлы других хостов — www-варианта,
+// поддоменов, CDN — лежат в соседних папках: ../<хост>/...
+func (h *LinkRewriterHandlerV2) sitePath(root string, u *url.URL, contentType string) (string, bool) {
+	dir, err := hostDir(h.outputDir, u.Host)
+	if err != nil {
+		return "", false
 	}
-
-	// Обрабатываем имя файла
-	if len(parts) > 0 {
-		lastPart := parts[len(parts)-1]
-
-		// Убираем расширения для HTML страниц
-		lowerLast := strings.ToLower(lastPart)
-		if strings.HasSuffix(lowerLast, ".php") ||
-			strings.HasSuffix(lowerLast, ".html") ||
-			strings.HasSuffix(lowerLast, ".htm") ||
-			strings.HasSuffix(lowerLast, ".asp") ||
-			strings.HasSuffix(lowerLast, ".aspx") ||
-			strings.HasSuffix(lowerLast, ".jsp") {
-
-			// Убираем все возможные расширения
-			ext := filepath.Ext(lastPart)
-			newName := strings.TrimSuffix(lastPart, ext)
-
-			if newName == "" || strings.EqualFold(newName, "index") {
-				parts = parts[:len(parts)-1]
-			} else {
-				parts[len(parts)-1] = newName
-			}
-		} else if strings.EqualFold(lastPart, "index") {
-			parts = parts[:len(parts)-1]
-		}
+	if h.outputDir == "" {
+		dir = ""
 	}
-
-	// Строим путь сохранения
-	basePath := filepath.Join(outputDir, host)
-
-	var saveDir string
-	if len(parts) > 0 {
-		saveDir = filepath.Join(append([]string{basePath}, parts...)...)
-	} else {
-		saveDir = basePath
+	p := savePath(dir, u, contentType, "")
+	if u.Host != root {
+		p = "../" + u.Host + "/" + p
 	}
-
-	return saveDir, "index.html"
+	return p, true
 }
 
-func (s *DirectoryIndexStrategy) RewriteLink(originalURL, baseURL string) string {
-    parsed, err1 := url.Parse(originalURL)
-    baseParsed, err2 := url.Parse(baseURL)
-
-    if err1 != nil || err2 != nil {
-        return originalURL
-    }
+func SaveFileV2(outputDir string, urlStr string, data []byte, contentType string) (string, error) {
+    relDiskPath, _, err := saveFile(outputDir, urlStr, data, contentType)
+    return relDiskPath, err
+}
 
-    // Пропускаем внешние ссылки и специальные протоколы
-    if parsed.Host != "" && parsed.Host != baseParsed.Host {
-        return originalURL
-    }
+// saveFile — SaveFileV2, который разрешает конфликт файла и папки с одним
+// именем (/about как файл и /about/ как папка) в пользу папки. movedFrom —
+// файл, перенесённый по conflictPath, чтобы освободить имя для папки.
+func saveFile(outputDir string, urlStr string, data []byte, contentType string) (relDiskPath, movedFrom string, err error) {
+    return saveFileAs(outputDir, urlStr, data, contentType, "")
+}
 
-    if strings.HasPrefix(originalURL, "#") ||
-        strings.HasPrefix(originalURL, "javascript:") ||
-        strings.HasPrefix(originalURL, "mailto:") ||
-        strings.HasPrefix(originalURL, "tel:") ||
-        strings.HasPrefix(originalURL, "data:") {
-        return originalURL
+// saveFileAs — saveFile под именем вложения filename из
+// Content-Disposition (см. attachment.go); "" — путь по адресу
+func saveFileAs(outputDir string, urlStr string, data []byte, contentType, filename string) (relDiskPath, movedFrom string, err error) {
+    parsed, err := url.Parse(urlStr)
+    if err != nil || parsed.Host == "" {
+        return "", "", fmt.Errorf("invalid URL or empty host")
     }
 
-    // ВАЖНО: calculateRelativePath теперь вызывается для ПОЛНЫХ URL
-    // Это позволит функции внутри понимать, где файлы лежат на диске.
+    // Получаем путь внутри домена
+    relDiskPath = namedDiskPath(parsed, contentType, filename)
 
-    // Формируем абсолютный URL для цели, если он относительный
-    targetURL := originalURL
-    if !strings.HasPrefix(originalURL, "http") {
-        resolved := baseParsed.ResolveReference(parsed)
-        targetURL = resolved.String()
+    // Собираем: output/wails.io/ru/index.html — и не выходим за пределы папки сайта
+    siteDir, err := hostDir(outputDir, parsed.Host)
+    if err != nil {
+        return "", "", err
     }
-
-    // 1. Вычисляем относительный путь
-    relPath, err := calculateRelativePath(baseURL, targetURL)
+    fullPath, err := ContainedPath(siteDir, relDiskPath)
     if err != nil {
-        return originalURL
+        return "", "", err
     }
 
-    // 2. Дополнительная логика для DirectoryIndex (убираем .html/index.html из ссылок)
-    // Чтобы ссылки в браузере выглядели как href="../assets/" вместо "../assets/index.html"
+    // Выбор пути, перенос мешающего файла и запись — одним шагом: иначе
+    // воркер с /x/y унесёт x, пока другой пишет /x
+    defer lockSiteDir(siteDir)()
 
-    // Убираем "index.html" из конца, если он там есть
-    if strings.HasSuffix(relPath, "index.html") {
-        relPath = strings.TrimSuffix(relPath, "index.html")
+    // Файл на месте одной из папок пути переносим внутрь неё как index.html
+    movedFrom, err = freeDirPath(siteDir, relDiskPath)
+    if err != nil {
+        return "", "", err
     }
-
-    // Если путь стал пустым (ссылка на ту же папку), ставим "./"
-    if relPath == "" {
-        relPath = "./"
+    // Папка на месте файла: пишем в неё index.html (ассет — рядом, см.
+    // conflictPath). Если страница папки уже скачана по второму варианту
+    // URL, её не трогаем — это та же страница.
+    if p := savePath(siteDir, parsed, contentType, filename); p != relDiskPath {
+        relDiskPath = p
+        fullPath = filepath.Join(siteDir, filepath.FromSlash(p))
+        if _, serr := os.Stat(fullPath); serr == nil && strategyForPath(p) == StrategyDirectory {
+            return relDiskPath, movedFrom, nil
+        }
     }
 
-    // Сохраняем только путь, сохраняя Query-параметры (?v=1.2), если они были
-    parsed.Path = relPath
-    parsed.Scheme = "" // Делаем ссылку относительной
-    parsed.Host = ""
-
-    return parsed.String()
-}
-
-// Функция для вычисления относительного пути между двумя URL
-func calculateRelativePath(sourceURL, targetURL string) (string, error) {
-    s, err := url.Parse(sourceURL)
-    t, err := url.Parse(targetURL)
-    if err != nil {
-        return targetURL, err
+    if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+        return "", "", err
     }
 
-    // Если домены разные, оставляем абсолютную ссылку
-    if s.Host != t.Host {
-        return targetURL, nil
+    // Пишем во временный файл и переименовываем — при ошибке не остаётся обрезанного файла
+    tmpPath := fullPath + ".part"
+    if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+        os.Remove(tmpPath)
+        return "", "", err
     }
-
-    // Определяем "пути" на диске для обоих файлов
-    sourcePath := getDiskPath(s)
-    targetPath := getDiskPath(t)
-
-    // Вычисляем относительный путь из папки источника к файлу цели
-    rel, err := filepath.Rel(filepath.Dir(sourcePath), targetPath)
-    if err != nil {
-        return targetURL, err
+    if err := os.Rename(tmpPath, fullPath); err != nil {
+        os.Remove(tmpPath)
+        return "", "", err
     }
 
-    // Превращаем системные разделители (\ в Windows) в URL-разделители (/)
-    return filepath.ToSlash(rel), nil
+    return relDiskPath, movedFrom, nil
 }
 
-// Вспомогательная функция, которая повторяет логику SaveFileV2
-func getDiskPath(u *url.URL) string {
-    p := u.Path
-    if p == "" || p == "/" {
-        return "index.html"
-    }
+// NormalizeURL приводит URL к каноническому написанию (см. urlkey.go).
+// Вызывается на каждую ссылку, поэтому в лог ничего не пишет.
+func NormalizeURL(u string) (string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
 
-    // Очищаем путь от двойных слэшей и лишних элементов
-    p = path.Clean(p)
-    if p == "." {
-        return "index.html"
-    }
+	pu.Fragment = ""
+	pu.ForceQuery = false // "/?" — то же, что "/"
+	canonicalHost(pu)
+
+	// Одно написание пути на все варианты кодирования (см. pathencoding.go)
+	// и без сегментов "." и ".." (см. urlkey.go)
+	escaped := cleanDotSegments(canonicalPath(pu.EscapedPath()))
+	if decoded, err := url.PathUnescape(escaped); err == nil {
+		pu.Path = decoded
+	} else {
+		escaped = ""
+	}
+	decodedLen := len(pu.Path)
+
+	path := pu.Path
+	if path == "" {
+		path = "/"
+	}
 
-    // Убираем начальный слэш, чтобы filepath.Join не считал путь абсолютным
-    p = strings.TrimPrefix(p, "/")
+	// /index.html, /docs/INDEX.HTM — та же страница, что / и /docs/
+	path = trimIndexFile(path)
 
-    // Если это папка (URL заканчивается на /) или страница без расширения
-    // проверяем наличие точки в последнем сегменте пути
-    lastSegment := path.Base(p)
-    if strings.HasSuffix(u.Path, "/") || !strings.Contains(lastSegment, ".") {
-        // Если это php, превращаем в html, иначе делаем index.html внутри папки
-        if strings.HasSuffix(strings.ToLower(p), ".php") {
-            return strings.TrimSuffix(p, ".php") + ".html"
-        }
-        return path.Join(p, "index.html")
-    }
+	pu.Path = path
+	// Отрезанный хвост (index.html) одинаков в обоих написаниях
+	pu.RawPath = ""
+	if escaped != "" && path != "/" {
+		pu.RawPath = escaped[:len(escaped)-(decodedLen-len(path))]
+	}
 
-    return p
+	return pu.String(), nil
 }
 
-// FileOnlyStrategy - стратегия "просто файл" для ресурсов
-type FileOnlyStrategy struct{}
+// htmlSniffLimit — сколько байт просматриваем в поисках HTML-тегов
+const htmlSniffLimit = 2048
 
-func (s *FileOnlyStrategy) ShouldSaveAsDirectory(urlStr string, contentType string) bool {
-	// Всегда сохраняем как файл
-	return false
-}
+var htmlTagRegex = regexp.MustCompile(`(?i)<(!doctype|html|head|body|div|p|a|script|link|meta|title|span|table|br|img)[\s/>]`)
 
-func (s *FileOnlyStrategy) GetSavePath(outputDir string, urlStr string, contentType string) (string, string) {
-	parsed, err := url.Parse(urlStr)
-	if err != nil {
-		log.Printf("Parse error in FileOnlyStrategy: %v", err)
-		return "", ""
+// verifyHTMLContentType проверяет, что ответ с text/html действительно HTML.
+// Неправильно настроенные API отдают JSON или текст с text/html —
+// такие ответы переклассифицируем, чтобы не парсить и не сохранять их как страницы.
+func verifyHTMLContentType(content []byte, contentType string) string {
+	if !strings.Contains(contentType, "text/html") {
+		return contentType
+	}
+	sample := content
+	if len(sample) > htmlSniffLimit {
+		sample = sample[:htmlSniffLimit]
+	}
+	if htmlTagRegex.Match(sample) {
+		return contentType
 	}
-	host := parsed.Host
-	path := parsed.Path
 
-	if path == "" || path == "/" {
-		path = "/index.html"
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+	if len(trimmed) == 0 {
+		return contentType
 	}
+	return "text/plain"
+}
 
-	cleanPath := strings.TrimPrefix(path, "/")
-	if cleanPath == "" {
-		cleanPath = "index.html"
+// mediaType возвращает тип без параметров: "text/html; charset=utf-8" → "text/html"
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	if ct == "" {
+		return "unknown"
 	}
+	return ct
+}
 
-	saveDir := filepath.Join(outputDir, host, filepath.Dir(cleanPath))
-	fileName := filepath.Base(cleanPath)
+func ContentHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
 
-	return saveDir, fileName
+type Downloader struct {
+	client    *http.Client
+	cfg       Config
+	retries   int
+	delay     time.Duration
+	maxSize   int64
+	hosts     *hostHealth
+	sizes     *sizeRules
+
+	// Crawl-delay из robots.txt: пауза между запросами всех воркеров
+	// к crawlHost — хосту, с которого этот robots.txt получен
+	crawlMu    sync.Mutex
+	crawlHost  string
+	crawlDelay time.Duration
+	lastReq    time.Time
+
+	bandwidth *bandwidthLimiter // MaxBytesPerSecond; nil — без лимита
+	agents    *uaPicker         // User-Agent запроса (см. useragent.go)
+	onBackoff func(BackoffEvent) // Пауза на 429/503 — для событий задачи
+	offsite   func(*url.URL) bool // Редирект уводит с сайта задачи; nil — сверка с хостом запроса
+	siteHost  func(host string) bool // Хост сайта задачи — ему уходят Config.Headers; nil — любой
+	log       Logger
+
+	// Джиттер повторов в детерминированном режиме
+	rngMu sync.Mutex
+	rng   *rand.Rand
 }
 
-func (s *FileOnlyStrategy) RewriteLink(originalURL, baseURL string) string {
-	// Для ресурсов не переписываем ссылки
-	return originalURL
+func NewDownloader(c Config) *Downloader {
+	logger := newLogger(c)
+	d := &Downloader{
+		client: &http.Client{
+			Transport: newTransport(c),
+			Jar:       newCookieJar(),
+		},
+		cfg:       c,
+		retries:   c.Retries,
+		delay:     c.Delay,
+		maxSize:   c.MaxFileSize,
+		hosts:     newHostHealth(c.HostFailureThreshold, c.HostCooldown),
+		sizes:     newSizeRules(c.MaxFileSize, c.MaxFileSizeByType),
+		bandwidth: newBandwidthLimiter(c.MaxBytesPerSecond),
+		agents:    newUAPicker(c),
+		rng:       newRetryRand(c),
+		log:       logger,
+	}
+	d.client.CheckRedirect = d.checkRedirect
+	return d
 }
 
-// StrategyAnalyzer - анализатор для выбора стратегии
-type StrategyAnalyzer struct {
-	strategies []FileSaveStrategy
+func (d *Downloader) setCrawlDelay(host string, delay time.Duration) {
+	d.crawlMu.Lock()
+	defer d.crawlMu.Unlock()
+	d.crawlHost = host
+	d.crawlDelay = delay
 }
 
-func NewStrategyAnalyzer() *StrategyAnalyzer {
-	return &StrategyAnalyzer{
-		strategies: []FileSaveStrategy{
-			&DirectoryIndexStrategy{},
-			&FileOnlyStrategy{},
-		},
+// waitCrawlDelay выдерживает Crawl-delay между запросами, как Prober.wait.
+// Чужие хосты (CDN, внешние ассеты) его robots.txt не касается.
+func (d *Downloader) waitCrawlDelay(ctx context.Context, host string) error {
+	d.crawlMu.Lock()
+	if d.crawlDelay <= 0 || host != d.crawlHost {
+		d.crawlMu.Unlock()
+		return nil
 	}
-}
+	next := d.lastReq.Add(d.crawlDelay)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	d.lastReq = next
+	d.crawlMu.Unlock()
 
-func (a *StrategyAnalyzer) Analyze(urlStr string, contentType string, content []byte) FileSaveStrategy {
-	parsed, err := url.Parse(urlStr)
-	if err != nil {
-		// Если не можем распарсить URL, используем стратегию файлов
-		return &FileOnlyStrategy{}
+	select {
+	case <-time.After(time.Until(next)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	path := parsed.Path
+// TooLargeFiles возвращает файлы, пропущенные из-за лимита размера
+func (d *Downloader) TooLargeFiles() []TooLargeFile {
+	return d.sizes.list()
+}
 
-	// Анализ 1: Проверяем расширение файла
-	lowerPath := strings.ToLower(path)
+// ShortCircuitedHosts возвращает хосты, помеченные как недоступные,
+// и количество URL, которые были отклонены без запроса.
+func (d *Downloader) ShortCircuitedHosts() []HostDownStat {
+	return d.hosts.summary()
+}
 
-	// Расширения ресурсов (сохраняем как файлы)
-	resourceExtensions := []string{
-		".css", ".js", ".mjs", ".cjs",
-		".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp",
-		".woff", ".woff2", ".ttf", ".eot", ".otf",
-		".mp4", ".webm", ".mp3", ".wav", ".ogg", ".avi", ".mov",
-		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
-		".zip", ".rar", ".7z", ".tar", ".gz",
-		".json", ".xml", ".txt", ".csv",
-	}
+func (d *Downloader) Download(ctx context.Context, u string) ([]byte, string, error) {
+	content, contentType, _, err := d.Fetch(ctx, u)
+	return content, contentType, err
+}
 
-	for _, ext := range resourceExtensions {
-		if strings.HasSuffix(lowerPath, ext) {
-			return &FileOnlyStrategy{}
-		}
-	}
+// Fetch — то же, что Download, и адрес, по которому ответ получен после
+// редиректов (resp.Request.URL); без редиректа он совпадает с u
+func (d *Downloader) Fetch(ctx context.Context, u string) ([]byte, string, string, error) {
+	r, err := d.FetchIf(ctx, u, nil)
+	return r.Content, r.ContentType, r.FinalURL, err
+}
 
-	// Анализ 2: Проверяем Content-Type
-	if contentType != "" {
-		// Ресурсные Content-Type
-		resourceContentTypes := []string{
-			"text/css",
-			"application/javascript", "application/x-javascript",
-			"image/", "font/", "audio/", "video/",
-			"application/pdf", "application/zip",
-			"application/json", "application/xml",
-		}
+// FetchResult — ответ FetchIf
+type FetchResult struct {
+	Content     []byte
+	ContentType string
+	FinalURL    string   // Адрес после редиректов
+	Redirects   []string // Адреса, ответившие редиректом, от запрошенного; пусто — без редиректа
+	ETag        string
+	Status      int
+	Header      http.Header
 
-		for _, ct := range resourceContentTypes {
-			if strings.Contains(contentType, ct) {
-				return &FileOnlyStrategy{}
-			}
-		}
+	hops []redirectHop // Сами ответы-редиректы, для WARC-архива
+}
 
-		// HTML Content-Type
-		if strings.Contains(contentType, "text/html") {
-			return &DirectoryIndexStrategy{}
-		}
+// FetchIf — Fetch с условным запросом: при cond != nil шлёт If-None-Match
+// и If-Modified-Since и на 304 возвращает ErrNotModified
+func (d *Downloader) FetchIf(ctx context.Context, u string, cond *Validators) (FetchResult, error) {
+	d.log.Debug("DOWNLOAD REQUEST: %s", u)
+
+	host := ""
+	if parsed, err := url.Parse(u); err == nil {
+		host = parsed.Host
+	}
+	ok, probe := d.hosts.allow(host)
+	if !ok {
+		return FetchResult{}, fmt.Errorf("%w: %s", ErrHostDown, host)
+	}
+	if probe {
+		defer d.hosts.endProbe(host)
 	}
 
-	// Анализ 3: Анализ содержимого (если Content-Type не указан)
-	if contentType == "" || contentType == "application/octet-stream" {
-		// Проверяем первые байты на наличие HTML тегов
-		contentStr := string(content)
-		if len(contentStr) > 100 {
-			sample := strings.ToLower(contentStr[:100])
-			if strings.Contains(sample, "<!doctype") ||
-				strings.Contains(sample, "<html") ||
-				strings.Contains(sample, "<head") ||
-				strings.Contains(sample, "<body") {
-				return &DirectoryIndexStrategy{}
+	var lastErr error
+	lastStatus := 0
+	backedOff := false // Пауза на 429/503 уже выдержана
+	timeout := d.requestTimeout(u)
+	for attempt := 1; attempt <= d.retries; attempt++ {
+		if attempt > 1 && !backedOff {
+			// Экспоненциальная пауза с полным джиттером: повторы разных
+			// воркеров не приходят на сервер одной волной
+			wait := d.retryPause(attempt - 1)
+			d.log.Info("Retrying %s in %v (attempt %d/%d)", u, wait.Round(time.Millisecond), attempt, d.retries)
+			if err := sleepCtx(ctx, wait); err != nil {
+				return FetchResult{}, err
 			}
 		}
-
-		// Проверяем расширение в URL
-		if strings.HasSuffix(lowerPath, ".php") ||
-			strings.HasSuffix(lowerPath, ".html") ||
-			strings.HasSuffix(lowerPath, ".htm") ||
-			strings.HasSuffix(lowerPath, ".asp") ||
-			strings.HasSuffix(lowerPath, ".aspx") ||
-			strings.HasSuffix(lowerPath, ".jsp") {
-			return &DirectoryIndexStrategy{}
+		backedOff = false
+		if err := d.waitCrawlDelay(ctx, host); err != nil {
+			return FetchResult{}, err
 		}
-	}
-
-	// Анализ 4: Паттерны путей
-	// Если путь содержит типичные шаблоны для статических файлов
-	staticPatterns := []string{
-		"/static/", "/assets/", "/public/", "/resources/",
-		"/css/", "/js/", "/images/", "/img/", "/fonts/",
-		"/uploads/", "/media/", "/downloads/",
-	}
-
-	for _, pattern := range staticPatterns {
-		if strings.Contains(path, pattern) {
-			return &FileOnlyStrategy{}
+		// Таймаут — на попытку (см. timeouts.go). Попыток немного, поэтому
+		// контексты отменяются при выходе из FetchIf
+		reqCtx, extend, cancel := attemptContext(ctx, timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(reqCtx, "GET", u, nil)
+		if err != nil {
+			d.log.Error("Request creation error for %s: %v", u, err)
+			return FetchResult{}, err
 		}
-	}
 
-	// Анализ 5: Пути без расширения
-	if !strings.Contains(path, ".") && path != "/" && path != "" {
-		// Это может быть либо страница (директория), либо API endpoint
-		// Проверяем типичные паттерны API
-		apiPatterns := []string{"/api/", "/ajax/", "/rest/", "/graphql", "/auth/"}
-		for _, pattern := range apiPatterns {
-			if strings.Contains(path, pattern) {
-				return &FileOnlyStrategy{}
+		setRequestHeaders(req, d.cfg, d.agents.pick(host), d.siteHost)
+		// Сжатые ответы распаковываем сами (см. encoding.go), в том числе br
+		if req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if cond != nil {
+			if cond.ETag != "" {
+				req.Header.Set("If-None-Match", cond.ETag)
+			}
+			if !cond.ModifiedSince.IsZero() {
+				req.Header.Set("If-Modified-Since", cond.ModifiedSince.UTC().Format(http.TimeFormat))
 			}
 		}
 
-		// Если не API, то считаем страницей
-		return &DirectoryIndexStrategy{}
-	}
-
-	// По умолчанию - стратегия директорий
-	return &DirectoryIndexStrategy{}
-}
-
-type DefaultURLFilter struct {
-	domain   string
-	basePath string
-}
-
-func (f *DefaultURLFilter) ShouldDownload(u string) bool {
-    parsed, err := url.Parse(u)
-    if err != nil {
-        return false
-    }
-
-    // 1. Проверка домена (не скачиваем внешние сайты)
-    if parsed.Host != f.domain {
-        return false
-    }
-
-    pathLower := strings.ToLower(parsed.Path)
-
-    // 2. Список расширений статических ресурсов (ассетов)
-    assetExts := []string{
-        ".css", ".js", ".mjs", ".json", ".map",
-        ".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".avif",
-        ".woff", ".woff2", ".ttf", ".otf", ".eot",
-        ".mp4", ".webm", ".mp3", ".wav", ".pdf",
-    }
-
-    // Если это статический ассет — разрешаем скачивание из любого места на этом домене
-    for _, ext := range assetExts {
-        if strings.HasSuffix(pathLower, ext) {
-            return true
-        }
-    }
-
-    // 3. Проверка для страниц (HTML, PHP или URL без расширения)
-    // Разрешаем, только если они находятся внутри базовой папки (basePath)
-    isPage := strings.HasSuffix(pathLower, ".html") ||
-              strings.HasSuffix(pathLower, ".php") ||
-              !strings.Contains(filepath.Base(pathLower), ".")
-
-    if isPage {
-        return strings.HasPrefix(parsed.Path, f.basePath)
-    }
-
-    // По умолчанию разрешаем всё остальное, что не попало в фильтр страниц,
-    // но находится на нашем домене (на всякий случай)
-    return true
-}
-
-func (f *DefaultURLFilter) FilterReason(u string) string {
-	return "outside base path or not asset"
-}
-
-type LinkRewriterHandlerV2 struct {
-	outputDir string
-	analyzer  *StrategyAnalyzer
-}
-
-func (h *LinkRewriterHandlerV2) Priority() int { return 10 }
-
-func (h *LinkRewriterHandlerV2) Handle(content []byte, meta FileMetadata) ([]byte, error) {
-	// Пропускаем не-HTML контент
-	if !strings.Contains(meta.ContentType, "text/html") {
-		return content, nil
-	}
-
-	doc, err := html.Parse(bytes.NewReader(content))
-	if err != nil {
-		return content, nil
-	}
-
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			for i := range n.Attr {
-				attr := &n.Attr[i]
-				if attr.Key == "href" || attr.Key == "src" || attr.Key == "action" {
-					// Пропускаем пустые ссылки
-					if attr.Val == "" {
-						continue
-					}
-
-					// Для локальных файлов (file://) не трогаем
-					if strings.HasPrefix(attr.Val, "file://") {
-						continue
-					}
-
-					// Анализируем ссылку и выбираем стратегию
-					strategy := h.analyzer.Analyze(attr.Val, "", nil)
-					// Переписываем ссылку согласно стратегии
-					newURL := strategy.RewriteLink(attr.Val, meta.URL)
-
-					if newURL != attr.Val {
-						// Дополнительная логика для относительных путей
-						if !strings.Contains(newURL, "://") && !strings.HasPrefix(newURL, "/") {
-							// Убедимся, что относительные пути правильные
-							if strings.HasPrefix(newURL, "./") || strings.HasPrefix(newURL, "../") {
-								attr.Val = newURL
-							} else {
-								// Добавляем ./ для локальных относительных ссылок
-								attr.Val = "./" + newURL
-							}
-						} else {
-							attr.Val = newURL
-						}
-						log.Printf("🔗 Rewrote link: %s → %s (from: %s)", attr.Val, newURL, meta.URL)
-					}
-				}
+		resp, err := d.client.Do(req)
+		var redirect *RedirectError
+		if errors.As(err, &redirect) {
+			// Петля или уход с сайта: повтор приведёт туда же
+			if redirect.Offsite {
+				d.log.Info("↪ %s redirected off-site to %s, not followed", u, redirect.Target)
+			} else {
+				d.log.Warn("%s: %v", u, redirect)
 			}
+			return FetchResult{FinalURL: redirect.Target}, &DownloadError{URL: u, Attempts: attempt, Err: redirect}
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+		if err != nil {
+			if ctx.Err() != nil {
+				return FetchResult{}, ctx.Err()
+			}
+			if reqCtx.Err() != nil {
+				err = &TimeoutError{After: timeout}
+			}
+			d.log.Warn("HTTP error for %s (attempt %d/%d): %v", u, attempt, d.retries, err)
+			if isHardConnError(err) && d.hosts.fail(host) {
+				d.log.Warn("⛔ Host %s marked down after repeated connection failures", host)
+				return FetchResult{}, fmt.Errorf("%w: %s", ErrHostDown, host)
+			}
+			lastErr, lastStatus = err, 0
+			continue
 		}
-	}
-	f(doc)
-
-	var buf bytes.Buffer
-	html.Render(&buf, doc)
-	return buf.Bytes(), nil
-}
-
-func SaveFileV2(outputDir string, urlStr string, data []byte, contentType string) (string, error) {
-    parsed, err := url.Parse(urlStr)
-    if err != nil || parsed.Host == "" {
-        return "", fmt.Errorf("invalid URL or empty host")
-    }
 
-    // Получаем путь внутри домена
-    relDiskPath := getDiskPath(parsed)
-
-    // Собираем: output/wails.io/ru/index.html
-    fullPath := filepath.Join(outputDir, parsed.Host, relDiskPath)
-
-    if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-        return "", err
-    }
+		d.log.Debug("RESPONSE: %s → %d %s", u, resp.StatusCode, resp.Header.Get("Content-Type"))
+		d.hosts.succeed(host)
 
-    err = os.WriteFile(fullPath, data, 0644)
-    if err != nil {
-        return "", err
-    }
+		if resp.StatusCode == http.StatusNotModified && cond != nil {
+			resp.Body.Close()
+			return FetchResult{}, ErrNotModified
+		}
 
-    return relDiskPath, nil
-}
-func NormalizeURL(u string) (string, error) {
-	pu, err := url.Parse(u)
-	if err != nil {
-		return "", err
-	}
+		if resp.StatusCode != 200 {
+			// Тело 404 — возможная страница ошибки сайта (см. errorpage.go)
+			var page FetchResult
+			if resp.StatusCode == http.StatusNotFound {
+				page = readErrorPage(resp)
+			}
+			resp.Body.Close()
+			lastErr, lastStatus = &StatusError{Code: resp.StatusCode}, resp.StatusCode
+			if permanentStatus(resp.StatusCode) {
+				// 401, 403, 404, 410…: повтор ответит тем же
+				d.log.Info("❌ %d %s: %s (permanent, not retried)", resp.StatusCode, http.StatusText(resp.StatusCode), u)
+				return page, &DownloadError{URL: u, Status: resp.StatusCode, Attempts: attempt, Err: lastErr}
+			}
+			d.log.Warn("HTTP error status %d for %s (attempt %d/%d)", resp.StatusCode, u, attempt, d.retries)
+
+			if throttled(resp.StatusCode) && attempt < d.retries {
+				// Сервер просит притормозить: ждём Retry-After или
+				// экспоненциальную паузу до следующей попытки
+				wait, fromHeader := d.backoffDelay(attempt, resp.Header)
+				if d.onBackoff != nil {
+					d.onBackoff(BackoffEvent{URL: u, Host: host, Status: resp.StatusCode,
+						Attempt: attempt, Wait: wait, RetryAfter: fromHeader})
+				}
+				if err := sleepCtx(ctx, wait); err != nil {
+					return FetchResult{}, err
+				}
+				backedOff = true
+			}
+			continue
+		}
 
-	pu.Fragment = ""
+		contentType := resp.Header.Get("Content-Type")
+		limit := d.sizes.limitFor(contentType)
+		encodings := contentEncodings(resp.Header)
 
-	path := pu.Path
-	if path == "" {
-		path = "/"
-	}
+		// Content-Length известен — не тратим трафик на заведомо большой файл.
+		// У сжатого ответа это размер на проводе: лимит — по распакованному.
+		if len(encodings) == 0 && resp.ContentLength > limit {
+			resp.Body.Close()
+			d.log.Info("File too large: %s (Content-Length %d > %d)", u, resp.ContentLength, limit)
+			d.sizes.record(TooLargeFile{URL: u, ContentType: contentType, Size: resp.ContentLength, Limit: limit})
+			return FetchResult{}, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+		}
 
-	// Normalize index.html/index.htm paths
-	lower := strings.ToLower(path)
-	if strings.HasSuffix(lower, "/index.html") || strings.HasSuffix(lower, "/index.htm") {
-		path = strings.TrimSuffix(path, "/index.html")
-		path = strings.TrimSuffix(path, "/index.htm")
-		if path == "" {
-			path = "/"
+		// Заголовки пришли вовремя: с лимитом трафика срок продлевается на
+		// чтение тела — по Content-Length, а без него по лимиту для этого типа
+		expected := resp.ContentLength
+		if expected < 0 {
+			expected = limit
 		}
-	} else if strings.HasSuffix(lower, "index.html") || strings.HasSuffix(lower, "index.htm") {
-		path = strings.TrimSuffix(path, "index.html")
-		path = strings.TrimSuffix(path, "index.htm")
-		if path == "" {
-			path = "/"
+		after := timeout
+		if allowance := throttleAllowance(d.cfg, expected); allowance > 0 && extend(timeout+allowance) {
+			after = timeout + allowance
 		}
-	}
-
-	pu.Path = path
-
-	result := pu.String()
-	log.Printf("🔗 NormalizeURL: %s → %s", u, result)
-	return result, nil
-}
-
-func ContentHash(b []byte) string {
-	h := sha256.Sum256(b)
-	return hex.EncodeToString(h[:])
-}
-
-type Downloader struct {
-	client    *http.Client
-	retries   int
-	delay     time.Duration
-	maxSize   int64
-	userAgent string
-}
 
-func NewDownloader(c Config) *Downloader {
-	return &Downloader{
-		client: &http.Client{
-			Transport: &http.Transport{
-				MaxIdleConns:    c.Workers * 2,
-				IdleConnTimeout: 30 * time.Second,
-			},
-			CheckRedirect: func(r *http.Request, v []*http.Request) error {
-				log.Printf("Redirect: %s → %s", v[len(v)-1].URL, r.URL)
-				return nil
-			},
-			Timeout: 30 * time.Second,
-		},
-		retries:   c.Retries,
-		delay:     c.Delay,
-		maxSize:   c.MaxFileSize,
-		userAgent: c.UserAgent,
-	}
-}
-
-func (d *Downloader) Download(ctx context.Context, u string) ([]byte, string, error) {
-	log.Printf("DOWNLOAD REQUEST: %s", u)
-
-	for attempt := 1; attempt <= d.retries; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		body, err := decodeBody(d.bandwidth.throttle(reqCtx, resp.Body), encodings)
 		if err != nil {
-			log.Printf("Request creation error for %s: %v", u, err)
-			return nil, "", err
+			resp.Body.Close()
+			d.log.Warn("Decode error for %s: %v", u, err)
+			return FetchResult{}, err
 		}
 
-		req.Header.Set("User-Agent", d.userAgent)
-
-		// Используем домен целевого URL в качестве Referer (более надежно)
-		parsed, _ := url.Parse(u)
-		req.Header.Set("Referer", parsed.Scheme+"://"+parsed.Host+"/")
-		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-		req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7")
+		// Для chunked и сжатых ответов читаем не больше limit+1 байт и прерываемся сразу при превышении
+		content, err := io.ReadAll(io.LimitReader(body, limit+1))
+		resp.Body.Close()
 
-		resp, err := d.client.Do(req)
 		if err != nil {
-			log.Printf("HTTP error for %s (attempt %d): %v", u, attempt, err)
-			if attempt == d.retries {
-				return nil, "", ErrDownloadFailed
-			}
-			time.Sleep(d.delay + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		log.Printf("RESPONSE: %s → %d %s", u, resp.StatusCode, resp.Header.Get("Content-Type"))
-
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			if resp.StatusCode == 404 {
-				log.Printf("❌ 404 Not Found: %s", u)
-				return nil, "", fmt.Errorf("404 Not Found: %s", u)
+			if ctx.Err() != nil {
+				return FetchResult{}, ctx.Err()
 			}
-			log.Printf("HTTP error status %d for %s (attempt %d)", resp.StatusCode, u, attempt)
-
-			if attempt == d.retries {
-				return nil, "", fmt.Errorf("status %d", resp.StatusCode)
+			if reqCtx.Err() != nil {
+				// Тело не успело дойти — такой же временный сбой, как зависший ответ
+				d.log.Warn("Read error for %s (attempt %d/%d): timed out after %s", u, attempt, d.retries, after)
+				lastErr, lastStatus = &TimeoutError{After: after}, 0
+				continue
 			}
-			time.Sleep(d.delay + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
+			d.log.Warn("Read error for %s: %v", u, err)
+			return FetchResult{}, err
 		}
 
-		content, err := io.ReadAll(io.LimitReader(resp.Body, d.maxSize+1))
-		resp.Body.Close()
-
-		if err != nil {
-			log.Printf("Read error for %s: %v", u, err)
-			return nil, "", err
+		if int64(len(content)) > limit {
+			d.log.Info("File too large: %s (more than %d bytes)", u, limit)
+			d.sizes.record(TooLargeFile{URL: u, ContentType: contentType, Size: -1, Limit: limit})
+			return FetchResult{}, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, limit)
 		}
 
-		if len(content) > int(d.maxSize) {
-			log.Printf("File too large: %s (%d bytes)", u, len(content))
-			return nil, "", errors.New("file too large")
+		if len(encodings) > 0 {
+			// Сохраняется распакованное тело: заголовки (и запись WARC) — под него
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+		} else if strings.HasPrefix(mediaType(contentType), "text/") {
+			// page.html.gz, отданный как text/html без Content-Encoding
+			if plain, ok := gunzipStray(content, limit); ok {
+				d.log.Debug("Gzipped body without Content-Encoding: %s", u)
+				content = plain
+				resp.Header.Del("Content-Length")
+			}
 		}
 
-		log.Printf("SUCCESS: Downloaded %s (%d bytes)", u, len(content))
-		return content, resp.Header.Get("Content-Type"), nil
+		d.log.Debug("SUCCESS: Downloaded %s (%d bytes)", u, len(content))
+		return FetchResult{
+			Content:     content,
+			ContentType: contentType,
+			FinalURL:    resp.Request.URL.String(),
+			Redirects:   redirectChain(resp),
+			ETag:        resp.Header.Get("ETag"),
+			Status:      resp.StatusCode,
+			Header:      resp.Header,
+			hops:        redirectHops(resp),
+		}, nil
 	}
 
-	return nil, "", ErrDownloadFailed
+	return FetchResult{}, &DownloadError{URL: u, Status: lastStatus, Attempts: d.retries, Err: lastErr}
 }
 
 type Job struct {
@@ -987,137 +1227,366 @@ type Job struct {
 	Filter     URLFilter
 	Parsers    []ContentParser
 	Handlers   []ContentHandler
+
+	// Зарегистрированные RegisterParser/RegisterHandler и имена
+	// обработчиков прошлого запуска (см. register.go)
+	customParsers  []ContentParser
+	customHandlers []ContentHandler
+	savedHandlers  []string
 	Downloader *Downloader
 	BasePath   string
 
 	mu           sync.Mutex
-	pending      chan string
+	pending      chan string // URL от диспетчера воркерам, без буфера (см. frontier.go)
 	visited      map[string]bool
-	hashes       map[string]bool
+	hashes       map[string]string // Хеш содержимого → первый URL с ним (см. dedupe.go)
 	depths       map[string]int
 	stats        JobStats
 	ctx          context.Context
 	cancel       context.CancelFunc
+	parent       context.Context // Контекст вызывающего: им отменяются и шаги после обхода
+	runErr       error           // Run не начал обход (см. openOutputs)
 	wg           sync.WaitGroup
 	activeWG     sync.WaitGroup
 	stateFile    string
-	shutdownChan chan os.Signal
-	Events       chan string
+
+	// Events — поток сообщений для GUI. Закрывается только после того,
+	// как Run остановил всех отправителей. Читать не обязательно: если
+	// никто не читает, старые строки вытесняются новыми.
+	Events <-chan string
+	// Progress — тот же прогресс числами, раз в секунду. Читать не
+	// обязательно: непрочитанное значение заменяется свежим.
+	Progress <-chan JobProgress
+	events   *eventBus
+
+	// Хуки файлов (см. hooks.go); nil — не нужны. Задавать до Run,
+	// например в RunOptions.OnStart. path — относительно OutputDir,
+	// пустой, если ответ только в WARC-архиве.
+	OnFileStart func(url string)
+	OnFileDone  func(url, path string, size int64, contentType string)
+	OnFileError func(url string, err error)
+	bgWG     sync.WaitGroup // Фоновые горутины (прогресс, чекпоинты)
+
+	newDepths []string // URL, добавленные после последнего чекпоинта
+
+	processed     int64         // Обработано URL (atomic), см. pageDone
+	checkpointDue chan struct{} // Сигнал checkpointer'у: набралось CheckpointPages URL
+	manifest  *manifestWriter
+	logFile   *jobLog
+	warc      *warcWriter // WARC-архив; nil — Format без архива
+	saved     *savedPaths
+	redirects *redirectMap // Исходный URL редиректа → финальный (см. redirects.go)
+	queries   assetQueries // Cache buster'ы и query в именах ассетов (см. queries.go)
+	workers   *workerTable
+	frontier  *frontier // Очередь обхода по Config.Strategy
+	overflow  *overflowQueue
+	queued    *pendingSet // Очередь для состояния: в памяти, на диске и у воркеров
+	deferred  *deferredQueue
+	cache     *sharedCache
+	progress  *progressTracker
+
+	pause       pauseGate
+	budgetOnce  sync.Once
+	budget      *BudgetEvent // Бюджет обхода исчерпан; nil — нет
+	scheme      schemeCanon // Единый протокол для ссылок на хост задачи
+	robots      *robotsRules // robots.txt (RespectRobots); nil — ещё не загружен
+
+	targets     map[string]bool   // Точечная докачка: ссылки с этих страниц не обходятся
+	skipReasons map[string]string // Почему URL не скачан — для отчёта о покрытии
+
+	// Исход обработки для состояния (см. outcomes.go); new* — для следующей дельты
+	completed    map[string]bool
+	newCompleted []string
+	failed       map[string]string
+	newFailed    map[string]string
+	coverage    *CoverageReport
+
+	errorPageTaken atomic.Bool // Страница 404 сайта уже сохраняется (см. errorpage.go)
+
+	referrers map[string][]string   // URL → страницы со ссылкой на него (до maxReferrers)
+	failures  map[string]failureInfo // Код ответа и число попыток упавших URL
+	panics    panicOutcomes          // Исходы паник до переноса в failed (см. workers.go)
+
+	prober       *Prober       // HEAD для размеров ассетов в пробном прогоне
+	dryRun       *dryRunLog    // Отчёт пробного прогона; nil — обычная загрузка
+	dryRunReport *DryRunReport // Готовый отчёт для Summary
 }
 
+// GetStats — то же, что Snapshot
 func (j *Job) GetStats() JobStats {
+	return j.Snapshot()
+}
+
+// Snapshot возвращает согласованную копию статистики; безопасно вызывать
+// из любой горутины во время работы задачи. Speed (сглаженная, байт
+// в секунду) и ETA обновляются раз в секунду вместе; ETA == 0 — оценки нет.
+func (j *Job) Snapshot() JobStats {
 	j.mu.Lock()
 	defer j.mu.Unlock()
-	return j.stats
+	return j.statsLocked()
+}
+
+// statsLocked — копия статистики для Snapshot и файлов состояния; mu
+// держит вызывающий. Счётчики воркеры меняют атомарно, без mu — читаем
+// так же; FileTypes копируется, чтобы не делить карту с воркерами.
+func (j *Job) statsLocked() JobStats {
+	s := JobStats{
+		TotalFiles:      atomic.LoadInt64(&j.stats.TotalFiles),
+		DownloadedBytes: atomic.LoadInt64(&j.stats.DownloadedBytes),
+		Failed:          atomic.LoadInt64(&j.stats.Failed),
+		Permanent:       atomic.LoadInt64(&j.stats.Permanent),
+		Skipped:         atomic.LoadInt64(&j.stats.Skipped),
+		Recovered:       atomic.LoadInt64(&j.stats.Recovered),
+		UnsafePaths:     atomic.LoadInt64(&j.stats.UnsafePaths),
+		CacheHits:       atomic.LoadInt64(&j.stats.CacheHits),
+		CacheBytesSaved: atomic.LoadInt64(&j.stats.CacheBytesSaved),
+		Deduplicated:    atomic.LoadInt64(&j.stats.Deduplicated),
+		Unchanged:       atomic.LoadInt64(&j.stats.Unchanged),
+		Blocked:         atomic.LoadInt64(&j.stats.Blocked),
+		Speed:           j.stats.Speed,
+		ETA:             j.stats.ETA,
+		FileTypes:       make(map[string]int64, len(j.stats.FileTypes)),
+		StartTime:       j.stats.StartTime,
+	}
+	for k, v := range j.stats.FileTypes {
+		s.FileTypes[k] = v
+	}
+	return s
 }
 
 func (j *Job) progressReporter() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	stuckThreshold := j.Config.StuckThreshold
+	if stuckThreshold <= 0 {
+		stuckThreshold = DefaultStuckThreshold
+	}
+	ticks := 0
+
 	for {
 		select {
 		case <-j.ctx.Done():
 			return
 		case <-ticker.C:
-			elapsed := time.Since(j.stats.StartTime).Seconds()
-			speed := 0.0
-			if elapsed > 0 {
-				speed = float64(j.stats.DownloadedBytes) / elapsed
+			if j.pause.isPaused() {
+				// На паузе только держим GUI в курсе: зависших нет, ETA не считаем
+				snap := j.snapshot()
+				j.progress.hold(time.Now(), &snap)
+				j.mu.Lock()
+				j.stats.Speed, j.stats.ETA = 0, 0
+				j.mu.Unlock()
+				j.emit(&event{kind: eventProgress, snap: snap})
+				continue
+			}
+			ticks++
+			for _, w := range j.workers.stuck(stuckThreshold) {
+				j.sendLog(fmt.Sprintf("[Warn] Worker %d stuck on %s (%s, %s)", w.ID, w.URL, w.Phase, w.Elapsed), false)
 			}
+			if ticks%10 == 0 {
+				j.sendLog("\n"+FormatWorkerTable(j.WorkerStatus()), true)
+			}
+
+			snap := j.snapshot()
+			if j.progress.tick(time.Now(), &snap) {
+				j.sendLog(fmt.Sprintf("🔎 Обход завершён: найдено %d URL, осталось скачать %d",
+					snap.Discovered, snap.Discovered-snap.Completed), false)
+				j.emit(&event{kind: eventPhase, phase: snap.Phase})
+			}
+			j.mu.Lock()
+			j.stats.Speed, j.stats.ETA = snap.Speed, snap.ETA
+			j.mu.Unlock()
 
-			msg := fmt.Sprintf("Файлов: %d | Скорость: %.2f KB/s | В очереди: %d",
-				j.stats.TotalFiles, speed/1024, len(j.pending))
+			msg := fmt.Sprintf("Файлов: %d | Скорость: %.2f KB/s | В очереди: %d | На диске: %d | %s",
+				snap.Files, snap.Speed/1024, snap.Queued, snap.Overflow, FormatETA(snap))
 
+			snap.Message = msg
 			j.sendLog(msg, false)
+			j.emit(&event{kind: eventProgress, snap: snap})
 		}
 	}
 }
 
+// snapshot собирает срез прогресса для подписчиков.
+// Speed и ETA заполняет progressTracker.tick.
+func (j *Job) snapshot() Snapshot {
+	queued, overflow := j.QueueDepth()
+	return Snapshot{
+		Files:    atomic.LoadInt64(&j.stats.TotalFiles),
+		Bytes:    atomic.LoadInt64(&j.stats.DownloadedBytes),
+		Failed:   atomic.LoadInt64(&j.stats.Failed),
+		Skipped:  atomic.LoadInt64(&j.stats.Skipped),
+		Queued:   queued,
+		Overflow: overflow,
+		Elapsed:  j.now().Sub(j.stats.StartTime),
+	}
+}
+
+// sendLog пишет строку в Events, лог задачи и терминал. Events закрывает
+// только Run (через eventBus); строка, пришедшая позже, туда просто не попадёт.
+// После отмены строки сворачивающихся воркеров идут только в лог: в Events
+// остаётся место для итога задачи (см. sendStatus).
 func (j *Job) sendLog(msg string, terminalOnly bool) {
-	if !terminalOnly && j.Events != nil {
+	if !terminalOnly {
+		var done <-chan struct{} // nil — Job собран без контекста
+		if j.ctx != nil {
+			done = j.ctx.Done()
+		}
 		select {
-		case j.Events <- msg:
+		case <-done:
 		default:
+			j.events.send(msg)
 		}
 	}
-	log.Println(msg)
+	j.logFile.write(msg)
+	j.logger().Info("%s", msg)
+}
+
+// sendStatus — sendLog для итоговой строки Run: в Events и после отмены
+func (j *Job) sendStatus(msg string) {
+	j.events.send(msg)
+	j.logFile.write(msg)
+	j.logger().Info("%s", msg)
 }
+
+// NewJob создаёт задачу; для встраивания удобнее Run и Resume
 func NewJob(root string, cfg Config) (*Job, error) {
+	return newJob(context.Background(), root, cfg, nil)
+}
+
+// JobID — идентификатор задачи для корневого URL; от него зависят имена
+// файлов состояния, поэтому повторный запуск того же URL получает тот же ID
+func JobID(root string) string {
+	return ContentHash([]byte(root))[:8]
+}
+
+// newJob — NewJob с родительским контекстом: его отмена останавливает задачу.
+// targets — вместо обхода от корня скачать только эти URL.
+func newJob(parent context.Context, root string, cfg Config, targets []string) (*Job, error) {
 	parsed, err := url.Parse(root)
 	if err != nil {
 		return nil, err
 	}
 
-	id := ContentHash([]byte(root))[:8]
+	if err := checkFormat(cfg.Format); err != nil {
+		return nil, err
+	}
+	if err := checkStrategy(cfg.Strategy); err != nil {
+		return nil, err
+	}
+	if err := checkUserAgents(cfg); err != nil {
+		return nil, err
+	}
+	if err := checkLogLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	if cfg.SinglePage {
+		cfg.MaxDepth = singlePageMaxDepth
+	}
+
+	id := JobID(root)
 	stateFile := filepath.Join(cfg.OutputDir, id+StateFileExtension)
 
-	filter := &DefaultURLFilter{
-		domain:   parsed.Host,
-		basePath: parsed.Path,
+	filter, err := newURLFilter(parsed, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 
 	job := &Job{
 		ID:           id,
 		RootURL:      root,
 		Config:       cfg,
 		Filter:       filter,
-		Parsers:      []ContentParser{&HTMLParser{}, &CSSParser{}},
-		Handlers:     []ContentHandler{&LinkRewriterHandlerV2{outputDir: cfg.OutputDir, analyzer: NewStrategyAnalyzer()}},
 		Downloader:   NewDownloader(cfg),
 		BasePath:     parsed.Path,
-		pending:      make(chan string, 5000),
 		visited:      make(map[string]bool),
-		hashes:       make(map[string]bool),
+		hashes:       make(map[string]string),
 		depths:       make(map[string]int),
-		stats:        JobStats{FileTypes: make(map[string]int64), StartTime: time.Now()},
+		stats:        JobStats{FileTypes: make(map[string]int64), StartTime: cfg.now()},
 		ctx:          ctx,
 		cancel:       cancel,
+		parent:       parent,
 		stateFile:    stateFile,
-		shutdownChan: make(chan os.Signal, 1),
-		Events:       make(chan string, 100),
+		saved:        newSavedPaths(),
+		redirects:    newRedirectMap(),
+		queries:      newAssetQueries(cfg),
+	}
+	job.initQueue()
+	job.setupContent()
+	job.events = newEventBus()
+	job.Events = job.events.out
+	job.Progress = job.events.progress
+
+	// Инкрементальный обход начинается заново: очередь прошлого запуска
+	// не нужна, сохранённые файлы берутся из манифеста (см. incremental.go).
+	// Прерванный запуск не сбрасывается, а доделывается
+	if cfg.Incremental && len(targets) == 0 && !cfg.DryRun && job.previousRunFinished() {
+		job.discardState()
+	}
+	// Пробный прогон всегда обходит сайт с нуля и прошлый запуск не трогает
+	if cfg.DryRun {
+		job.dryRun = newDryRunLog()
+		job.prober = NewProber(cfg, dryRunProbeBudget)
+		job.prober.siteHost = job.siteHost
 	}
 
 	// Попытка загрузки состояния
-	if err := job.loadState(); err == nil {
-		log.Printf("✅ Resumed job %s from state file", id)
+	if !cfg.DryRun && job.loadState() == nil {
+		job.logger().Info("✅ Resumed job %s from state file", id)
 	} else {
-		// Оценка общего количества файлов перед началом загрузки
-		totalFiles, err := estimateTotalFiles(root, cfg)
-		if err != nil {
-			log.Printf("⚠️ Could not estimate total files: %v", err)
-			job.stats.TotalFiles = -1 // Указывает на невозможность оценки
-		} else {
-			job.stats.TotalFiles = int64(totalFiles)
-			log.Printf("📊 Estimated %d files to download", totalFiles)
+		// http и https одного сайта обходим один раз, под одним протоколом
+		job.scheme = detectScheme(ctx, cfg, parsed)
+		if canon := job.scheme.canonical(root); canon != root {
+			job.logger().Info("🔒 %s отвечает по %s, ссылки на другой протокол приводятся к нему", parsed.Host, job.scheme.scheme)
+			root = canon
+			job.RootURL = canon
 		}
 
-		// Начинаем с корневого URL
-		normalized, _ := NormalizeURL(root)
-		job.activeWG.Add(1) // Добавляем в WaitGroup для rootURL
-		job.pending <- normalized
-		job.depths[normalized] = 0
-		job.visited[normalized] = true
-		log.Printf("🚀 New job started for %s", root)
+		// robots.txt нужен уже для оценки: она тоже обходит сайт
+		job.loadRobots()
+
+		if len(targets) == 0 {
+			// Оценка общего количества файлов перед началом загрузки;
+			// пробному прогону она не нужна — он сам и есть оценка, —
+			// а одной странице и подавно
+			if !cfg.DryRun && !cfg.SinglePage {
+				totalFiles, err := estimateTotalFiles(root, cfg, job.scheme, job.Filter)
+				if err != nil {
+					job.logger().Warn("⚠️ Could not estimate total files: %v", err)
+					job.stats.TotalFiles = -1 // Указывает на невозможность оценки
+				} else {
+					job.stats.TotalFiles = int64(totalFiles)
+					job.logger().Info("📊 Estimated %d files to download", totalFiles)
+				}
+			}
+
+			// Начинаем с корневого URL
+			normalized, _ := job.canonicalURL(root)
+			job.activeWG.Add(1) // Добавляем в WaitGroup для rootURL
+			job.enqueue(normalized, 0)
+			job.trackDepth(normalized, 0)
+			job.visited[normalized] = true
+			job.logger().Info("🚀 New job started for %s", root)
+		}
+	}
+	if len(targets) > 0 {
+		job.queueTargets(targets)
 	}
 
+	// Таблица воркеров — до запуска: WorkerStatus читают из других горутин
+	// сразу после OnStart, и Run её уже не подменяет
+	job.workers = newWorkerTable(job.Config.Workers)
 	return job, nil
 }
 
 // estimateTotalFiles выполняет предварительный обход сайта для оценки общего количества файлов
-func estimateTotalFiles(root string, cfg Config) (int, error) {
-	parsed, err := url.Parse(root)
-	if err != nil {
+func estimateTotalFiles(root string, cfg Config, scheme schemeCanon, filter URLFilter) (int, error) {
+	if _, err := url.Parse(root); err != nil {
 		return 0, err
 	}
 
-	filter := &DefaultURLFilter{
-		domain:   parsed.Host,
-		basePath: parsed.Path,
-	}
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -1130,13 +1599,16 @@ func estimateTotalFiles(root string, cfg Config) (int, error) {
 		depths:   make(map[string]int),
 		ctx:      ctx,
 		cancel:   cancel,
+		scheme:   scheme,
 	}
 
 	// Канал для сбора URL
 	urlChan := make(chan string, 1000)
+	probe := NewProber(cfg, 0)
+	probe.siteHost = tempJob.siteHost
 	go func() {
 		defer close(urlChan)
-		tempJob.preScan(root, urlChan, 0, cfg.MaxDepth)
+		tempJob.preScan(probe, root, urlChan, 0, cfg.MaxDepth)
 	}()
 
 	totalFiles := 0
@@ -1148,18 +1620,21 @@ func estimateTotalFiles(root string, cfg Config) (int, error) {
 }
 
 // preScan выполняет рекурсивный обход сайта для сбора URL
-func (j *Job) preScan(urlStr string, urlChan chan<- string, currentDepth int, maxDepth int) {
+func (j *Job) preScan(probe *Prober, urlStr string, urlChan chan<- string, currentDepth int, maxDepth int) {
 	if currentDepth > maxDepth {
 		return
 	}
 
-	normalized, err := NormalizeURL(urlStr)
-	if err != nil || !j.Filter.ShouldDownload(normalized) {
+	normalized, err := j.canonicalURL(urlStr)
+	if err != nil {
+		return
+	}
+	if !j.Filter.ShouldDownload(normalized) {
 		return
 	}
 
 	j.mu.Lock()
-	if j.visited[normalized] {
+	if j.visitedLocked(normalized) {
 		j.mu.Unlock()
 		return
 	}
@@ -1168,7 +1643,7 @@ func (j *Job) preScan(urlStr string, urlChan chan<- string, currentDepth int, ma
 
 	urlChan <- normalized
 
-	resp, err := http.Head(normalized)
+	resp, err := probe.Head(j.ctx, normalized)
 	if err != nil {
 		return
 	}
@@ -1181,20 +1656,20 @@ func (j *Job) preScan(urlStr string, urlChan chan<- string, currentDepth int, ma
 	contentType := resp.Header.Get("Content-Type")
 	if strings.Contains(contentType, "text/html") {
 		// Parse HTML to find links
-		links, err := extractLinksFromHTML(normalized)
+		links, err := extractLinksFromHTML(j.ctx, probe, normalized)
 		if err != nil {
 			return
 		}
 
 		for _, link := range links {
-			j.preScan(link, urlChan, currentDepth+1, maxDepth)
+			j.preScan(probe, link, urlChan, currentDepth+1, maxDepth)
 		}
 	}
 }
 
 // extractLinksFromHTML извлекает ссылки из HTML-страницы
-func extractLinksFromHTML(urlStr string) ([]string, error) {
-	resp, err := http.Get(urlStr)
+func extractLinksFromHTML(ctx context.Context, probe *Prober, urlStr string) ([]string, error) {
+	resp, err := probe.Get(ctx, urlStr)
 	if err != nil {
 		return nil, err
 	}
@@ -1238,42 +1713,227 @@ func forEachNode(n *html.Node, pre, post func(n *html.Node)) {
 	}
 }
 
-func (j *Job) Run() {
-    if j.Events != nil {
-        defer close(j.Events)
+// Cancel останавливает задачу: воркеры докачивают текущий URL и выходят,
+// Run сохраняет очередь для resume и закрывает Events. Повторный вызов
+// ничего не делает.
+func (j *Job) Cancel() {
+    if j.cancel != nil {
+        j.cancel()
     }
+}
 
-    signal.Notify(j.shutdownChan, os.Interrupt, syscall.SIGTERM)
+// openOutputs открывает то, что задача пишет на диск по ходу обхода.
+// Ошибка — только если без архива задача бессмысленна (FormatWARC).
+func (j *Job) openOutputs() error {
+    // Архив — первым: задача FormatWARC без него не запускается, и
+    // остальное открывать незачем
+    if j.Config.writesWARC() {
+        w, err := openWARC(j.WARCFile(), j.Config)
+        if err != nil && !j.Config.writesTree() {
+            return fmt.Errorf("не удалось открыть WARC-архив: %w", err)
+        }
+        if err != nil {
+            j.logger().Error("Не удалось открыть WARC-архив: %v", err)
+        }
+        j.warc = w
+    }
 
-    // Запуск репортера прогресса
-    go j.progressReporter()
+    // Пути, сохранённые в прошлых запусках, нужны для переписывания ссылок
+    j.loadSavedPaths()
 
-    // Запуск воркеров
-    for i := 0; i < j.Config.Workers; i++ {
-        j.wg.Add(1)
-        go j.worker()
+    // Манифест пишется по мере сохранения файлов
+    if m, err := openManifest(j.manifestFile()); err == nil {
+        j.manifest = m
+    } else {
+        j.logger().Error("Не удалось открыть манифест: %v", err)
+    }
+    // Полный лог — в файл: GUI держит только его хвост
+    if l, err := openJobLog(j.LogFile()); err == nil {
+        j.logFile = l
+    } else {
+        j.logger().Error("Не удалось открыть лог задачи: %v", err)
+    }
+    return nil
+}
+
+func (j *Job) Run() {
+    // Канал событий закрывается последним, когда все отправители остановлены
+    defer j.events.close()
+
+    // Пробный прогон ничего не пишет: ни манифеста, ни лога, ни архива
+    if !j.Config.DryRun {
+        if err := j.openOutputs(); err != nil {
+            // Задаче FormatWARC без архива писать некуда: обход не начинаем
+            j.runErr = err
+            j.logger().Error("%v", err)
+            j.emit(&event{kind: eventComplete, sum: j.summary(false)})
+            return
+        }
+    }
+
+    // Паузы на 429/503 — в лог и подписчикам
+    j.Downloader.onBackoff = j.noteBackoff
+    j.Downloader.offsite = j.offsiteRedirect
+    j.Downloader.siteHost = j.siteHost
+    j.loadCookies()
+
+    // robots.txt: у возобновлённой задачи правила ещё не загружены
+    j.loadRobots()
+    j.checkHandlers()
+    if j.robots != nil && (len(j.robots.rules) > 0 || j.robots.crawlDelay > 0) {
+        j.sendLog(fmt.Sprintf("🤖 robots.txt: правил %d, Crawl-delay %s", len(j.robots.rules), j.robots.crawlDelay), false)
+    }
+
+    // newJob и loadJob создают таблицу заранее; здесь — для Job, собранного вручную
+    if j.workers == nil {
+        j.workers = newWorkerTable(j.Config.Workers)
+    }
+    j.deferred = newDeferredQueue(j.Config.DeferredRetries, j.Config.DeferredRetryDelay)
+    j.checkpointDue = make(chan struct{}, 1)
+    j.cache = newSharedCache(j.Config)
+    queued, overflow := j.QueueDepth()
+    j.progress = newProgressTracker(j.Config.DiscoveryQuiet, int64(queued+overflow), atomic.LoadInt64(&j.stats.DownloadedBytes))
+
+    // Стратегия могла смениться при resume
+    j.frontier.order(j.Config.Strategy)
+
+    // Запуск репортера прогресса
+    j.bgWG.Add(4)
+    go func() { defer j.bgWG.Done(); j.progressReporter() }()
+    go func() { defer j.bgWG.Done(); j.checkpointer() }()
+    go func() { defer j.bgWG.Done(); j.feeder() }()
+    go func() { defer j.bgWG.Done(); j.dispatch() }()
+
+    // Запуск воркеров
+    for i := 0; i < j.Config.Workers; i++ {
+        j.wg.Add(1)
+        go j.worker(i)
     }
 
     // Запускаем горутину, которая закроет канал pending,
-    // когда ВСЕ активные задачи (включая рекурсивные) закончатся.
+    // когда ВСЕ активные задачи (включая рекурсивные и отложенные) закончатся.
     go func() {
-        j.activeWG.Wait()  // Ждем, пока счетчик станет 0
-        close(j.pending)   // Сигнализируем воркерам, что работы больше нет
+        j.drainDeferred() // Ждем, пока счетчик станет 0 и отложенных не останется
+        close(j.pending)  // Сигнализируем воркерам, что работы больше нет
+        j.pause.resume()  // Воркеры, ждущие на паузе, тоже должны увидеть конец
     }()
 
     // Ждем, пока все воркеры завершат цикл (выйдут из range j.pending)
     j.wg.Wait()
 
     // Финальные действия после завершения
-    j.sendLog("📭 Все задачи выполнены, сохранение состояния...", false)
+    interrupted := j.ctx.Err() != nil
+    budget := j.budgetReached()
+    if budget != nil {
+        j.sendLog("🛑 Бюджет обхода исчерпан, сохранение состояния...", false)
+    } else if interrupted {
+        j.sendLog("⏹ Задача остановлена, сохранение состояния...", false)
+    } else {
+        j.sendLog("📭 Все задачи выполнены, сохранение состояния...", false)
+    }
     j.cancel()
+    j.bgWG.Wait()
+
+    if tooLarge := j.Downloader.TooLargeFiles(); len(tooLarge) > 0 {
+        j.sendLog(fmt.Sprintf("📦 Пропущено из-за лимита размера: %d", len(tooLarge)), false)
+        for _, f := range tooLarge {
+            size := "?"
+            if f.Size >= 0 {
+                size = fmt.Sprintf("%d", f.Size)
+            }
+            j.sendLog(fmt.Sprintf("   %s [%s] %s > %d", f.URL, mediaType(f.ContentType), size, f.Limit), false)
+        }
+    }
+
+    if n := atomic.LoadInt64(&j.stats.Deduplicated); n > 0 {
+        j.sendLog(fmt.Sprintf("🧬 Повторов содержимого записано алиасами: %d", n), false)
+    }
+    if hits := atomic.LoadInt64(&j.stats.CacheHits); hits > 0 {
+        j.sendLog(fmt.Sprintf("🗄 Из общего кеша: %d файлов, сэкономлено %d байт", hits, atomic.LoadInt64(&j.stats.CacheBytesSaved)), false)
+    }
+    if recovered := atomic.LoadInt64(&j.stats.Recovered); recovered > 0 {
+        j.sendLog(fmt.Sprintf("🔁 Скачано после отложенного повтора: %d", recovered), false)
+    }
+    if permanent := atomic.LoadInt64(&j.stats.Permanent); permanent > 0 {
+        j.sendLog(fmt.Sprintf("🚫 Сервер окончательно отказал (4xx), без повторов: %d", permanent), false)
+    }
+    if unchanged := atomic.LoadInt64(&j.stats.Unchanged); unchanged > 0 {
+        j.sendLog(fmt.Sprintf("♻️ Не изменились с прошлого запуска (304): %d", unchanged), false)
+    }
+    if blocked := atomic.LoadInt64(&j.stats.Blocked); blocked > 0 {
+        j.sendLog(fmt.Sprintf("🚷 Ссылок отброшено по блок-листу: %d", blocked), false)
+    }
+
+    for _, h := range j.Downloader.ShortCircuitedHosts() {
+        j.sendLog(fmt.Sprintf("⛔ Хост %s недоступен, пропущено URL: %d", h.Host, h.AffectedURLs), false)
+    }
+
+    if types := FormatFileTypes(j.Snapshot().FileTypes); types != "" {
+        j.sendLog("📊 Типы файлов: "+types, false)
+    }
+
+    if budget != nil {
+        j.sendStatus("🛑 Загрузка остановлена по бюджету, продолжить можно через resume с большим лимитом")
+    } else if interrupted {
+        j.sendStatus("⏹ Загрузка прервана, продолжить можно через resume")
+    } else {
+        j.sendStatus("✅ Загрузка успешно завершена!")
+    }
+
+    // Пишем только дельту — полный снимок собирается при следующей загрузке.
+    // Очередь из файла переполнения попадает в дельту до его удаления.
+    if err := j.checkpoint(); err != nil {
+        j.logger().Error("Ошибка сохранения стейта: %v", err)
+    }
+    if interrupted {
+        j.discardPending()
+    }
+    j.overflow.close()
+    if j.manifest != nil {
+        j.manifest.Close()
+        if err := compactManifest(j.manifestFile()); err != nil {
+            j.logger().Warn("Ошибка сжатия манифеста: %v", err)
+        }
+    }
+    if !j.Config.DryRun {
+        j.writeBrokenLinks()
+    }
+    // Сверка с sitemap — только для завершённого обхода всего сайта
+    if !interrupted && !j.Config.DryRun && !j.Config.SinglePage {
+        j.checkCoverage()
+    }
+    if j.dryRun != nil {
+        j.finishDryRun()
+    }
+    if err := j.warc.Close(); err != nil {
+        j.logger().Error("Ошибка закрытия WARC-архива: %v", err)
+    }
+    j.logFile.Close()
+    j.emit(&event{kind: eventComplete, sum: j.summary(interrupted)})
+}
 
-    if j.Events != nil {
-        j.Events <- "✅ Загрузка успешно завершена!"
+// checkpointer сохраняет дельту состояния по таймеру и после каждых
+// CheckpointPages завершённых URL
+func (j *Job) checkpointer() {
+    interval := j.Config.CheckpointInterval
+    if interval <= 0 {
+        interval = DefaultCheckpointInterval
     }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
 
-    if err := j.saveState(); err != nil {
-        log.Printf("Ошибка сохранения стейта: %v", err)
+    for {
+        select {
+        case <-j.ctx.Done():
+            return
+        case <-ticker.C:
+        case <-j.checkpointDue:
+            // Набралось CheckpointPages завершённых URL — не ждём таймера
+            ticker.Reset(interval)
+        }
+        if err := j.checkpoint(); err != nil {
+            j.logger().Error("Ошибка чекпоинта: %v", err)
+        }
     }
 }
 
@@ -1286,50 +1946,81 @@ func (j *Job) discoverCommonFiles() {
     baseURL := parsed.Scheme + "://" + parsed.Host
 
     for _, p := range commonPaths {
-        targetURL := baseURL + p
+        targetURL, err := j.canonicalURL(baseURL + p)
+        if err != nil {
+            continue
+        }
         j.mu.Lock()
-        if _, exists := j.visited[targetURL]; !exists {
+        if !j.visitedLocked(targetURL) {
             j.visited[targetURL] = true
-            j.depths[targetURL] = 0
+            j.trackDepth(targetURL, 0)
             j.mu.Unlock()
 
             j.activeWG.Add(1) // Добавляем задачу
-            select {
-            case j.pending <- targetURL:
-            default:
-                // Если канал полон и мы не можем отправить,
-                // нужно откатить счетчик, иначе программа никогда не завершится
-                j.activeWG.Done()
-            }
+            j.enqueue(targetURL, 0)
         } else {
             j.mu.Unlock()
         }
     }
 }
 
-func (j *Job) worker() {
+func (j *Job) worker(id int) {
     defer j.wg.Done() // Сообщает о завершении самой горутины воркера
 
     for {
+        // После отмены не берём из очереди ни одного URL: select выбирает
+        // случайно, если готовы и pending, и ctx.Done()
+        if j.ctx.Err() != nil {
+            return
+        }
+        // На паузе воркер ждёт здесь и не берёт новых URL
+        pausing, ok := j.pause.enter(j.ctx)
+        if !ok {
+            return
+        }
+
         select {
         case urlStr, ok := <-j.pending:
             if !ok {
+                j.pause.leave()
                 return // Канал закрыт, выходим
             }
 
+            // Пакетная загрузка: ждём свободного слота общего бюджета.
+            // URL, не дождавшийся слота до отмены, останется в очереди
+            if !j.Config.WorkerPool.acquire(j.ctx) {
+                j.activeWG.Done()
+                j.pause.leave()
+                return
+            }
+
             // Обрабатываем URL
-            j.processURL(urlStr)
+            j.workers.start(id, urlStr)
+            j.processURLSafe(id, urlStr)
+            j.workers.idle(id)
+            j.Config.WorkerPool.release()
+            j.progress.complete()
+            // Прерванный на середине URL остаётся в очереди и вернётся при resume
+            if j.ctx.Err() == nil {
+                j.queued.done(urlStr)
+            }
+            j.pageDone()
 
             // КРИТИЧЕСКИ ВАЖНО: Уменьшаем счетчик активных задач
             j.activeWG.Done()
+            j.pause.leave()
+
+        case <-pausing:
+            j.pause.leave() // Встали на паузу, пока ждали URL
 
         case <-j.ctx.Done():
+            j.pause.leave()
             return // Завершение по контексту
         }
     }
 }
 
-func (j *Job) processURL(urlStr string) {
+func (j *Job) processURL(workerID int, urlStr string) {
     j.mu.Lock()
     depth := j.depths[urlStr]
     j.mu.Unlock()
@@ -1344,16 +2035,174 @@ func (j *Job) processURL(urlStr string) {
 
     if depth > j.Config.MaxDepth {
         atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, "max depth exceeded")
+        return
+    }
+    if j.robotsDisallowed(urlStr) {
+        j.sendLog(fmt.Sprintf("[Skip] %s: %s", urlStr, ReasonRobots), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, ReasonRobots)
         return
     }
 
-    content, contentType, err := j.Downloader.Download(j.ctx, urlStr)
+    // Бюджет исчерпан: URL не качаем, он вернётся в очередь при resume —
+    // лишняя постановка переживёт done воркера
+    if ev, over := j.budgetExceeded(); over {
+        j.queued.add(urlStr)
+        j.stopForBudget(ev)
+        return
+    }
+
+    if j.OnFileStart != nil {
+        j.OnFileStart(urlStr)
+    }
+
+    // Пробный прогон: ассету, в котором не ищут ссылок, хватит HEAD
+    if j.dryRun != nil && j.dryRunHeadOnly(urlStr) && j.dryRunHead(workerID, urlStr, depth) {
+        return
+    }
+
+    // Инкрементальный режим: сохранённый раньше файл запрашиваем условно
+    var cond *Validators
+    var stored fileMeta
+    if j.Config.Incremental && j.dryRun == nil {
+        if v, m, ok := j.storedCopy(urlStr); ok {
+            cond, stored = &v, m
+        }
+    }
+    res, err := j.Downloader.FetchIf(j.ctx, urlStr, cond)
+    if errors.Is(err, ErrNotModified) {
+        j.keepUnchanged(workerID, urlStr, depth, stored)
+        return
+    }
+    content, contentType, finalURL := res.Content, res.ContentType, res.FinalURL
+    if errors.Is(err, ErrTooLarge) {
+        j.sendLog(fmt.Sprintf("[Skip] Too large: %s (%v)", urlStr, err), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, err.Error())
+        j.dryRun.filter(urlStr, err.Error())
+        j.fileError(ErrorEvent{URL: urlStr, Err: err})
+        return
+    }
+    if errors.Is(err, ErrHostDown) {
+        j.sendLog(fmt.Sprintf("[Skip] Host down, not requested: %s", urlStr), false)
+        atomic.AddInt64(&j.stats.Failed, 1)
+        j.noteSkip(urlStr, err.Error())
+        j.noteFailed(urlStr, err)
+        j.fileError(ErrorEvent{URL: urlStr, Err: err})
+        return
+    }
+    if err != nil && j.ctx.Err() != nil {
+        // Задачу остановили посреди загрузки — это не ошибка URL: он
+        // остался в очереди и будет скачан при resume
+        return
+    }
+    // Редирект на чужой хост не скачивается: фильтр URL проверял исходный адрес
+    var redirect *RedirectError
+    if errors.As(err, &redirect) && redirect.Offsite {
+        j.sendLog(fmt.Sprintf("[Skip] %s: %v", urlStr, redirect), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, redirect.Error())
+        return
+    }
     if err != nil {
-        j.sendLog(fmt.Sprintf("[Error] Failed to download %s: %v", urlStr, err), false)
+        // Временный сбой — повторим после основной очереди
+        if isTransientError(err) && j.deferred.add(urlStr) {
+            j.sendLog(fmt.Sprintf("[Retry] Deferred %s: %v", urlStr, err), false)
+            j.fileError(ErrorEvent{URL: urlStr, Err: err, Retrying: true})
+            return
+        }
+        // DownloadError сам называет URL, код ответа и число попыток
+        msg := fmt.Sprintf("%s: %v", urlStr, err)
+        var de *DownloadError
+        if errors.As(err, &de) {
+            msg = de.Error()
+        }
+        j.sendLog("[Error] Failed to download "+msg, false)
         atomic.AddInt64(&j.stats.Failed, 1)
+        if IsPermanent(err) {
+            atomic.AddInt64(&j.stats.Permanent, 1)
+        }
+        j.noteSkip(urlStr, err.Error())
+        j.noteFailed(urlStr, err)
+        j.fileError(ErrorEvent{URL: urlStr, Err: err})
+        if res.Status == http.StatusNotFound {
+            j.saveErrorPage(urlStr, res, depth)
+        }
+        return
+    }
+
+    // /404 и т.п. ответили страницей: она сохраняется как 404.html в
+    // корне хоста, а адрес становится её алиасом (см. errorpage.go)
+    errorPage := isErrorRoute(urlStr) && strings.Contains(contentType, "text/html")
+    if errorPage {
+        finalURL = errorPageURL(urlStr)
+    }
+
+    // Ответ пришёл после редиректа: сохраняем по финальному адресу, а
+    // исходный становится его алиасом. Относительные ссылки страницы
+    // тоже разрешаются от финального адреса, как в браузере.
+    // Страницы со ссылкой на исходный адрес переписаны раньше, на
+    // угаданный путь: запоминаем их до того, как редирект станет известен
+    requestedURL := urlStr
+    var stale []staleLink
+    if finalURL != "" && finalURL != urlStr {
+        stale = j.staleLinks(requestedURL)
+    }
+    urlStr, save := j.followRedirect(urlStr, finalURL, res.Redirects, depth)
+    if !save {
+        j.sendLog(fmt.Sprintf("[Skip] %s redirects to already saved %s", requestedURL, urlStr), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.relink(requestedURL, stale)
+        j.archiveRedirects(res.hops)
+        return
+    }
+
+    if real := verifyHTMLContentType(content, contentType); real != contentType {
+        j.sendLog(fmt.Sprintf("[Warn] %s is labeled text/html but looks like %s", urlStr, real), false)
+        contentType = real
+    }
+
+    // С чужих хостов нужны только ассеты: страницы CDN сайту ни к чему
+    if j.foreignHost(urlStr) && fileCategory(urlStr, contentType) == FileHTML {
+        j.sendLog(fmt.Sprintf("[Skip] External page: %s", urlStr), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, "external page")
+        return
+    }
+
+    if j.Config.SinglePage && depth > 0 && fileCategory(urlStr, contentType) == FileHTML {
+        j.sendLog(fmt.Sprintf("[Skip] %s: %s", urlStr, ReasonSinglePage), false)
+        atomic.AddInt64(&j.stats.Skipped, 1)
+        j.noteSkip(urlStr, ReasonSinglePage)
+        return
+    }
+
+    if j.dryRun != nil {
+        j.dryRunOnly(workerID, requestedURL, urlStr, content, int64(len(content)), contentType, res.Header, depth)
         return
     }
 
+    // В архив — ответ как пришёл, до переписывания ссылок, и перед ним
+    // редиректы, которыми к нему пришли: архив воспроизводит и старый адрес
+    j.archiveRedirects(res.hops)
+    if err := j.warc.writeResponse(urlStr, res); err != nil {
+        j.sendLog(fmt.Sprintf("[Error] WARC write failed for %s: %v", urlStr, err), false)
+    }
+    if !j.Config.writesTree() {
+        j.archiveOnly(workerID, requestedURL, urlStr, content, contentType, res.Header, depth)
+        return
+    }
+
+    // Страница в windows-1251, shift_jis и т.п. — в UTF-8 до разбора
+    // и переписывания ссылок (см. charset.go)
+    if fileCategory(urlStr, contentType) == FileHTML {
+        if converted, from := HTMLToUTF8(content, contentType); from != "" {
+            j.sendLog(fmt.Sprintf("[Info] %s: %s → UTF-8", urlStr, from), false)
+            content, contentType = converted, mediaType(contentType)+"; charset=utf-8"
+        }
+    }
+
     // Хеши отключены, как мы и договаривались, чтобы сохранить структуру /ru/assets/
     hash := ContentHash(content)
 
@@ -1364,83 +2213,217 @@ func (j *Job) processURL(urlStr string) {
         Depth:       depth,
     }
 
+    j.workers.phase(workerID, PhaseParsing)
     modifiedContent := content
     for _, handler := range j.sortedHandlers() {
         modified, err := handler.Handle(modifiedContent, meta)
         if err != nil {
-            log.Printf("Handler error for %s: %v", urlStr, err)
+            j.logger().Warn("Handler error for %s: %v", urlStr, err)
         } else {
             modifiedContent = modified
         }
     }
 
-    // Сохраняем файл
-    _, err = SaveFileV2(j.Config.OutputDir, urlStr, modifiedContent, contentType)
+    // Сохраняем файл; вложение — под именем из Content-Disposition
+    j.workers.phase(workerID, PhaseSaving)
+    filename := j.attachmentName(urlStr, res.Header, contentType)
+    relPath, movedFrom, err := saveFileAs(j.Config.OutputDir, urlStr, modifiedContent, contentType, filename)
+    if errors.Is(err, ErrUnsafePath) {
+        j.sendLog(fmt.Sprintf("[Skip] Unsafe path rejected for %s: %v", urlStr, err), false)
+        atomic.AddInt64(&j.stats.UnsafePaths, 1)
+        j.noteSkip(urlStr, err.Error())
+        j.fileError(ErrorEvent{URL: urlStr, Err: err})
+        return
+    }
     if err != nil {
         j.sendLog(fmt.Sprintf("[Error] Save failed for %s: %v", urlStr, err), false)
         atomic.AddInt64(&j.stats.Failed, 1)
+        j.noteSkip(urlStr, err.Error())
+        j.noteFailed(requestedURL, err)
+        j.fileError(ErrorEvent{URL: urlStr, Err: err})
         return
     }
 
+    // Страницы со ссылкой на вложение переписаны раньше, на угаданный путь
+    if filename != "" && stale == nil {
+        stale = j.staleLinks(requestedURL)
+    }
+
+    // Повтор уже сохранённого ассета: файл становится ссылкой на первый
+    if j.dedupes(urlStr, contentType) {
+        if first, sp, ok := j.duplicateOf(hash); ok && first != urlStr && j.canAlias(urlStr, contentType, relPath, sp) {
+            if u, perr := url.Parse(urlStr); perr == nil {
+                j.linkDuplicate(filepath.Join(j.Config.OutputDir, u.Host, filepath.FromSlash(relPath)), sp.Path)
+            }
+            j.recordDuplicate(urlStr, first, sp, contentType, hash, int64(len(content)), depth)
+            j.relink(requestedURL, stale)
+            if j.Config.SaveMetadata {
+                j.writeFileMeta(urlStr, relPath, j.newFileMeta(requestedURL, urlStr, res, contentType, hash, depth))
+            }
+            j.noteCompleted(requestedURL, urlStr)
+            return
+        }
+    }
+
+    // Неизменённые обработчиками файлы делим с другими сайтами через общий кеш
+    if u, perr := url.Parse(urlStr); perr == nil && j.cache != nil && bytes.Equal(content, modifiedContent) {
+        full := filepath.Join(j.Config.OutputDir, u.Host, filepath.FromSlash(relPath))
+        if n := j.cache.adopt(full, hash, int64(len(modifiedContent))); n > 0 {
+            atomic.AddInt64(&j.stats.CacheHits, 1)
+            atomic.AddInt64(&j.stats.CacheBytesSaved, n)
+        }
+    }
+
+    // Файл CDN лежит в папке своего хоста: в реестре и манифесте путь от папки сайта
+    hostRel := relPath
+    layoutFixed := movedFrom != ""
+    if u, perr := url.Parse(urlStr); perr == nil && relPath != namedDiskPath(u, contentType, filename) {
+        layoutFixed = true
+    }
+    if prefix := j.externalPrefix(urlStr); prefix != "" {
+        if u, perr := url.Parse(urlStr); perr == nil {
+            markAssetMirror(j.Config.OutputDir, u.Host)
+        }
+        relPath = prefix + relPath
+        if movedFrom != "" {
+            movedFrom = prefix + movedFrom
+        }
+    }
+
+    saved := j.saved.record(urlStr, relPath)
+    if j.dedupes(urlStr, contentType) {
+        j.rememberContent(hash, urlStr)
+    }
+    if layoutFixed {
+        j.recordLayoutFix(urlStr, relPath, movedFrom)
+    }
+    if requestedURL != urlStr {
+        j.recordRedirectAlias(requestedURL, urlStr, relPath)
+    }
+    j.relink(requestedURL, stale)
+    j.appendManifest(ManifestEntry{
+        URL:         urlStr,
+        Path:        relPath,
+        Strategy:    saved.Strategy,
+        ContentType: contentType,
+        Size:        int64(len(modifiedContent)),
+        Hash:        hash,
+        Depth:       depth,
+        SavedAt:     j.now(),
+    })
+    j.noteCompleted(requestedURL, urlStr)
+
     atomic.AddInt64(&j.stats.TotalFiles, 1)
+    recovered := j.deferred.deferred(requestedURL)
+    if recovered {
+        atomic.AddInt64(&j.stats.Recovered, 1)
+    }
     atomic.AddInt64(&j.stats.DownloadedBytes, int64(len(content)))
+    j.mu.Lock()
+    j.stats.FileTypes[fileCategory(urlStr, contentType)]++
+    j.mu.Unlock()
     j.sendLog(fmt.Sprintf("[Done] Saved: %s", urlStr), false)
-
+    j.fileDone(FileResult{
+        URL:         urlStr,
+        Path:        relPath,
+        ContentType: contentType,
+        Size:        int64(len(modifiedContent)),
+        Depth:       depth,
+        Recovered:   recovered,
+    })
+
+    // Ссылки нужны и для метаданных: на 304 страница не парсится заново
+    var links []string
+    if depth < j.Config.MaxDepth || j.Config.Incremental {
+        j.workers.phase(workerID, PhaseParsing)
+        links = j.parseLinks(content, contentType, urlStr, res.Header)
+    }
+    if j.keepsMeta() {
+        m := j.newFileMeta(requestedURL, urlStr, res, contentType, hash, depth)
+        m.Links = links
+        j.writeFileMeta(urlStr, hostRel, m)
+    }
+    if errorPage {
+        links = pageResources(content, urlStr)
+    }
     if depth < j.Config.MaxDepth {
-        j.parseAndQueueLinks(content, contentType, urlStr, depth)
+        j.queueLinks(links, depth, urlStr)
     }
 }
 
-func (j *Job) parseAndQueueLinks(content []byte, contentType, baseURL string, depth int) {
+// parseLinks достаёт ссылки первым подходящим парсером
+func (j *Job) parseLinks(content []byte, contentType, baseURL string, header http.Header) []string {
+    if isSVG(baseURL, contentType) {
+        contentType = svgContentType
+    }
+    // Одна страница: с неё берутся только ресурсы, не переходы (см. singlepage.go)
+    if j.Config.SinglePage && strings.Contains(contentType, "text/html") {
+        return pageResources(content, baseURL)
+    }
     for _, parser := range j.Parsers {
         if parser.CanParse(contentType) {
-            rawLinks, err := parser.Parse(content, baseURL)
+            rawLinks, err := j.parseWith(parser, content, baseURL, header)
             if err != nil {
-                log.Printf("Parse error for %s: %v", baseURL, err)
+                j.logger().Warn("Parse error for %s: %v", baseURL, err)
                 continue
             }
+            return rawLinks // Используем только первый подходящий парсер
+        }
+    }
+    return nil
+}
+
+// queueLinks ставит в очередь ссылки страницы referrer глубины depth.
+// В лог — одна строка на страницу, а не на ссылку: на больших сайтах
+// построчный лог ссылок занимал больше времени, чем сам обход.
+func (j *Job) queueLinks(rawLinks []string, depth int, referrer string) {
+    if j.targets[referrer] {
+        return
+    }
+    added := 0
+    for _, rawLink := range j.queueOrder(rawLinks) {
+        // Один ключ на все написания URL (см. urlkey.go)
+        normalized, err := j.canonicalURL(rawLink)
+        if err != nil {
+            continue
+        }
 
-            log.Printf("Found %d raw links in %s", len(rawLinks), baseURL)
-
-            for _, rawLink := range rawLinks {
-                normalized, err := NormalizeURL(rawLink)
-                if err != nil {
-                    continue
-                }
-
-                // Проверяем фильтры
-                if !j.Filter.ShouldDownload(normalized) {
-                    // Можно раскомментировать для отладки фильтрации:
-                    // reason := j.Filter.FilterReason(normalized)
-                    // log.Printf("Filtered out: %s (%s)", normalized, reason)
-                    continue
-                }
-
-                j.mu.Lock()
-                if !j.visited[normalized] {
-                    j.visited[normalized] = true
-                    j.depths[normalized] = depth + 1
-
-                    // Увеличиваем счетчик ДО разблокировки и отправки
-                    j.activeWG.Add(1)
-                    j.mu.Unlock()
-
-                    // Отправляем в очередь. Если канал полон — ждем.
-                    select {
-                    case j.pending <- normalized:
-                        // Успешно добавлено
-                    case <-j.ctx.Done():
-                        // Если программа завершается, откатываем счетчик
-                        j.activeWG.Done()
-                        return
-                    }
-                } else {
-                    j.mu.Unlock()
-                }
+        // Проверяем фильтры
+        if !j.Filter.ShouldDownload(normalized) {
+            reason := j.Filter.FilterReason(normalized)
+            j.dryRun.filter(normalized, reason)
+            switch {
+            case reason == ReasonRobots:
+                j.skipRobots(normalized)
+            case strings.HasPrefix(reason, ReasonBlocked):
+                j.skipBlocked(normalized, reason)
             }
-            break // Используем только первый подходящий парсер
+            continue
+        }
+
+        j.mu.Lock()
+        // Откуда ссылаются — для отчёта о битых ссылках (см. brokenlinks.go)
+        j.noteReferrer(normalized, referrer)
+        if !j.visitedLocked(normalized) {
+            j.visited[normalized] = true
+            j.trackDepth(normalized, depth+1)
+            j.unskipNoFollow(normalized)
+
+            // Увеличиваем счетчик ДО разблокировки и отправки
+            j.activeWG.Add(1)
+            j.mu.Unlock()
+            added++
+
+            // Ставим в очередь. Если в памяти места нет — URL уходит
+            // в файл переполнения, воркер не блокируется.
+            j.enqueue(normalized, depth+1)
+        } else {
+            j.mu.Unlock()
         }
     }
+    if len(rawLinks) > 0 {
+        j.logger().Info("🔎 Found %d links (%d new) on %s", len(rawLinks), added, referrer)
+    }
 }
 
 func (j *Job) sortedHandlers() []ContentHandler {
@@ -1456,108 +2439,174 @@ func (j *Job) saveState() error {
     j.mu.Lock()
     defer j.mu.Unlock()
 
-    // Не пересоздаем канал! Просто собираем текущий снимок очереди.
-    // Читаем из канала всё, что там есть, и ТУТ ЖЕ возвращаем обратно.
-    var pendingURLs []string
-    currLen := len(j.pending)
-    for i := 0; i < currLen; i++ {
-        select {
-        case url := <-j.pending:
-            pendingURLs = append(pendingURLs, url)
-            j.pending <- url // Возвращаем назад для воркеров
-        default:
-            break
-        }
-    }
-
     state := JobState{
         ID:          j.ID,
         RootURL:     j.RootURL,
-        PendingURLs: pendingURLs,
+        PendingURLs: j.snapshotPending(),
         DepthMap:    j.depths, // Внимание: если карта огромная, это займет память
-        Stats:       j.stats,
+        Stats:       j.statsLocked(),
         Config:      j.Config,
+        Redirects:   j.redirects.snapshot(),
+        Version:     StateVersion,
+        Completed:   j.completedList(),
+        Failed:      j.failedCopy(),
+        Handlers:    j.handlerNames(),
+    }
+    j.newDepths = nil
+    j.newCompleted, j.newFailed = nil, nil
+
+    return writeStateFile(j.stateFile, state)
+}
+
+// snapshotPending собирает текущий снимок очереди: всё, что поставлено и не
+// обработано (канал, файл переполнения, URL у воркеров), плюс отложенные.
+// Канал не трогаем — воркеры читают его одновременно с нами.
+func (j *Job) snapshotPending() []string {
+    pendingURLs := j.queued.snapshot()
+    seen := make(map[string]bool, len(pendingURLs))
+    for _, u := range pendingURLs {
+        seen[u] = true
+    }
+    for _, u := range j.deferred.snapshot() {
+        if !seen[u] {
+            pendingURLs = append(pendingURLs, u)
+        }
     }
+    return pendingURLs
+}
 
+func writeStateFile(path string, state JobState) error {
     data, err := json.Marshal(state)
     if err != nil {
         return err
     }
 
-    // Используем временный файл для безопасной записи (чтобы не убить стейт при краше)
-    tmpFile := j.stateFile + ".tmp"
-    if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+    // Используем временный файл для безопасной записи (чтобы не убить стейт при краше).
+    // Sync до Rename: иначе после сбоя питания на месте снимка может оказаться пустой файл
+    tmpFile := path + ".tmp"
+    f, err := os.Create(tmpFile)
+    if err != nil {
         return err
     }
-    return os.Rename(tmpFile, j.stateFile)
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        os.Remove(tmpFile)
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        os.Remove(tmpFile)
+        return err
+    }
+    if err := f.Close(); err != nil {
+        os.Remove(tmpFile)
+        return err
+    }
+    return os.Rename(tmpFile, path)
 }
 
 func (j *Job) loadState() error {
-	data, err := ioutil.ReadFile(j.stateFile)
+	// Принимаем оба формата: снимок .state.json и журнал дельт .state.jsonl
+	state, hasJournal, err := readJobState(j.stateFile)
 	if err != nil {
 		return err
 	}
-
-	var state JobState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return err
+	var compactErr error
+	if hasJournal {
+		compactErr = j.compactState(state)
 	}
 
 	j.ID = state.ID
 	j.RootURL = state.RootURL
 	j.stats = state.Stats
+	// Clock, Cookies и настройки лога в состояние не сохраняются — берём у
+	// вызывающего; заголовки, переданные заново (новый токен), важнее сохранённых
+	clock, cookies, headers := j.Config.Clock, j.Config.Cookies, j.Config.Headers
+	include, exclude := j.Config.IncludePatterns, j.Config.ExcludePatterns
+	logger, logLevel, logStderr, logPrefix := j.Config.Logger, j.Config.LogLevel, j.Config.LogStderr, j.Config.LogPrefix
+	pool := j.Config.WorkerPool
 	j.Config = state.Config
+	j.Config.Clock, j.Config.Cookies, j.Config.WorkerPool = clock, cookies, pool
+	j.Config.Logger, j.Config.LogLevel, j.Config.LogStderr, j.Config.LogPrefix = logger, logLevel, logStderr, logPrefix
+	// Лог — уже с OutputDir из состояния
+	if compactErr != nil {
+		j.logger().Warn("Не удалось сжать журнал состояния: %v", compactErr)
+	}
+	if len(headers) > 0 {
+		j.Config.Headers = headers
+	}
+	if len(include) > 0 || len(exclude) > 0 {
+		j.Config.IncludePatterns, j.Config.ExcludePatterns = include, exclude
+	}
+	if j.stats.FileTypes == nil {
+		j.stats.FileTypes = make(map[string]int64)
+	}
+	if j.redirects == nil {
+		j.redirects = newRedirectMap()
+	}
+	j.redirects.load(state.Redirects)
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
+	// Протокол корня уже канонический; URL из старых состояний приводим к нему
+	parsed, _ := url.Parse(j.RootURL)
+	j.scheme = schemeCanon{host: parsed.Host, scheme: parsed.Scheme}
+
 	// Восстанавливаем глубину и посещенные URL
 	j.depths = make(map[string]int)
 	j.visited = make(map[string]bool)
-	j.hashes = make(map[string]bool)
+	j.hashes = make(map[string]string)
+
+	for u, depth := range state.DepthMap {
+		u = j.scheme.canonical(u)
+		if d, ok := j.depths[u]; ok && d < depth {
+			depth = d
+		}
+		j.depths[u] = depth
+		j.visited[u] = true
+	}
 
-	for url, depth := range state.DepthMap {
-		j.depths[url] = depth
-		j.visited[url] = true
+	// Исходы прошлых запусков
+	j.completed = make(map[string]bool, len(state.Completed))
+	for _, u := range state.Completed {
+		j.completed[j.scheme.canonical(u)] = true
+	}
+	j.failed = make(map[string]string, len(state.Failed))
+	for u, e := range state.Failed {
+		j.failed[j.scheme.canonical(u)] = e
 	}
 
-	// Восстанавливаем очередь
-	j.pending = make(chan string, 5000)
-	for _, url := range state.PendingURLs {
-		j.pending <- url
+	// Восстанавливаем очередь; не поместившееся в память уходит на диск.
+	// Уже сохранённое пропускаем: очередь могла быть записана до сохранения.
+	j.initQueue()
+	queued := make(map[string]bool, len(state.PendingURLs))
+	for _, u := range state.PendingURLs {
+		u = j.scheme.canonical(u)
+		if queued[u] || j.completed[u] {
+			continue
+		}
+		queued[u] = true
 		j.activeWG.Add(1) // Добавляем в activeWG для каждого восстановленного URL
+		j.enqueue(u, j.depths[u])
 	}
 
 	// Пересоздаем фильтр и парсеры
-	parsed, _ := url.Parse(j.RootURL)
-	j.Filter = &DefaultURLFilter{
-		domain:   parsed.Host,
-		basePath: parsed.Path,
+	filter, err := newURLFilter(parsed, j.Config)
+	if err != nil {
+		return err
 	}
+	j.Filter = filter
 	j.BasePath = parsed.Path
+	j.queries = newAssetQueries(j.Config)
 
-	// ИСПРАВЛЕНО: Используем LinkRewriterHandlerV2 вместо LinkRewriterHandler
-	j.Handlers = []ContentHandler{&LinkRewriterHandlerV2{
-		outputDir: j.Config.OutputDir,
-		analyzer:  NewStrategyAnalyzer(),
-	}}
-	j.Parsers = []ContentParser{&HTMLParser{}, &CSSParser{}}
+	// Стандартные парсеры и обработчики плюс зарегистрированные
+	j.savedHandlers = state.Handlers
+	j.setupContent()
 
 	return nil
 }
 
-func drainChannel(ch chan string) []string {
-	var urls []string
-	for {
-		select {
-		case url := <-ch:
-			urls = append(urls, url)
-		default:
-			return urls
-		}
-	}
-}
-
 // CLI команды
 var rootCmd = &cobra.Command{
 	Use:   "downloader",
@@ -1565,10 +2614,25 @@ var rootCmd = &cobra.Command{
 }
 
 var downloadCmd = &cobra.Command{
-	Use:   "download <url>",
-	Short: "Download a website",
-	Args:  cobra.ExactArgs(1),
+	Use:   "download <url>... | --from-file <urls.txt>",
+	Short: "Download a website or a batch of them",
 	Run: func(cmd *cobra.Command, args []string) {
+		urls := args
+		if fromFile, _ := cmd.Flags().GetString("from-file"); fromFile != "" {
+			f, err := os.Open(fromFile)
+			if err != nil {
+				log.Fatalf("Failed to read URL list: %v", err)
+			}
+			list, err := ReadURLList(f)
+			f.Close()
+			if err != nil {
+				log.Fatalf("Failed to read URL list %s: %v", fromFile, err)
+			}
+			urls = append(urls, list...)
+		}
+		if len(urls) == 0 {
+			log.Fatal("Pass a URL or --from-file with a list of URLs")
+		}
 		cfg := loadConfig()
 
 		// Создаем выходную директорию
@@ -1576,47 +2640,266 @@ var downloadCmd = &cobra.Command{
 			log.Fatalf("Failed to create output directory: %v", err)
 		}
 
-		job, err := NewJob(args[0], cfg)
-		if err != nil {
-			log.Fatalf("Failed to create job: %v", err)
+		// Ctrl+C останавливает задачу, состояние сохраняется для resume
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if len(urls) == 1 {
+			if _, err := Run(ctx, RunOptions{URL: urls[0], Config: cfg}); err != nil && ctx.Err() == nil {
+				log.Fatalf("Failed to create job: %v", err)
+			}
+			return
 		}
 
-		job.Run()
+		// Пакет: задачи со своими состояниями, воркеры (--workers) общие
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		results := RunBatch(ctx, BatchOptions{URLs: urls, Config: cfg, Parallel: parallel, OnDone: func(res BatchResult) {
+			switch {
+			case ctx.Err() != nil:
+				log.Printf("[%s] Stopped: %s", siteKey(res.URL), res.URL)
+			case res.Err != nil:
+				log.Printf("[%s] Failed: %s: %v", siteKey(res.URL), res.URL, res.Err)
+			default:
+				log.Printf("[%s] Done: %s, %d files", siteKey(res.URL), res.URL, res.Summary.Stats.TotalFiles)
+			}
+		}})
+		failed := 0
+		for _, res := range results {
+			if res.Err != nil {
+				failed++
+			}
+		}
+		if failed > 0 && ctx.Err() == nil {
+			log.Fatalf("%d of %d jobs failed", failed, len(urls))
+		}
 	},
 }
 
 var resumeCmd = &cobra.Command{
-	Use:   "resume <job-id>",
+	Use:   "resume <job-id|url>",
 	Short: "Resume a previous download job",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		// Флаги, переданные resume, важнее config.yaml и сохранённой конфигурации
+		for _, f := range resumeFlags {
+			if cmd.Flags().Changed(f.flag) {
+				viper.BindPFlag(f.key, cmd.Flags().Lookup(f.flag))
+			}
+		}
 		cfg := loadConfig()
 
-		job := &Job{
-			ID:        args[0],
-			Config:    cfg,
-			stateFile: filepath.Join(cfg.OutputDir, args[0]+StateFileExtension),
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		stateFile, err := FindJobState(cfg.OutputDir, args[0])
+		if errors.Is(err, ErrNoJobState) {
+			startIfMissing, _ := cmd.Flags().GetBool("start-if-missing")
+			if !startIfMissing || !IsJobURL(args[0]) {
+				log.Fatalf("%v (pass a URL with --start-if-missing to start a new download)", err)
+			}
+			log.Printf("No saved job for %s, starting a new download", args[0])
+			if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+				log.Fatalf("Failed to create output directory: %v", err)
+			}
+			if _, err := Run(ctx, RunOptions{URL: args[0], Config: cfg}); err != nil && ctx.Err() == nil {
+				log.Fatalf("Failed to create job: %v", err)
+			}
+			return
 		}
 
-		if err := job.loadState(); err != nil {
+		retryFailed, _ := cmd.Flags().GetBool("retry-failed")
+		log.Printf("Resuming job %s", strings.TrimSuffix(filepath.Base(stateFile), StateFileExtension))
+		_, err = Resume(ctx, stateFile, ResumeOptions{
+			RetryFailed: retryFailed,
+			Override: func(c *Config) {
+				// Параметры загрузчика берём из текущего конфига, остальное — из состояния
+				c.Retries = cfg.Retries
+				c.Delay = cfg.Delay
+				c.MaxFileSize = cfg.MaxFileSize
+				c.UserAgent = cfg.UserAgent
+				c.Cookies = cfg.Cookies
+				c.LogLevel, c.LogStderr = cfg.LogLevel, cfg.LogStderr
+				// Бюджет, исчерпанный в прошлый раз, поднимают при resume
+				if cfg.MaxPages > 0 {
+					c.MaxPages = cfg.MaxPages
+				}
+				if cfg.MaxTotalBytes > 0 {
+					c.MaxTotalBytes = cfg.MaxTotalBytes
+				}
+				if len(cfg.Headers) > 0 {
+					c.Headers = cfg.Headers
+				}
+				if cfg.CookieFile != "" {
+					c.CookieFile = cfg.CookieFile
+				}
+				for _, f := range resumeFlags {
+					if f.apply != nil && cmd.Flags().Changed(f.flag) {
+						f.apply(c, cfg)
+					}
+				}
+			},
+		})
+		switch {
+		case errors.Is(err, ErrCorruptJobState):
+			log.Fatalf("Cannot resume, %v", err)
+		case err != nil && ctx.Err() == nil:
 			log.Fatalf("Failed to load job state: %v", err)
 		}
+	},
+}
 
-		// Восстанавливаем контекст и каналы
-		job.ctx, job.cancel = context.WithCancel(context.Background())
-		job.shutdownChan = make(chan os.Signal, 1)
+// resumeFlags — флаги download, которые принимает и resume. apply
+// переносит значение в конфигурацию из состояния; без apply флаг и так
+// применяется при каждом resume.
+var resumeFlags = []struct {
+	key, flag string
+	apply     func(dst *Config, src Config)
+}{
+	{"output_dir", "output-dir", nil},
+	{"workers", "workers", func(d *Config, s Config) { d.Workers = s.Workers }},
+	{"max_depth", "max-depth", func(d *Config, s Config) { d.MaxDepth = s.MaxDepth }},
+	{"retries", "retries", nil},
+	{"delay", "delay", nil},
+	{"page_timeout", "page-timeout", func(d *Config, s Config) { d.PageTimeout = s.PageTimeout }},
+	{"asset_timeout", "asset-timeout", func(d *Config, s Config) { d.AssetTimeout = s.AssetTimeout }},
+	{"max_file_size", "max-file-size", nil},
+	{"user_agent", "user-agent", nil},
+	{"ua_preset", "ua-preset", func(d *Config, s Config) { d.UAPreset = s.UAPreset }},
+	{"user_agents", "rotate-user-agent", func(d *Config, s Config) { d.UserAgents = s.UserAgents }},
+	{"ua_rotation", "ua-rotation", func(d *Config, s Config) { d.UARotation = s.UARotation }},
+	{"respect_robots", "respect-robots", func(d *Config, s Config) { d.RespectRobots = s.RespectRobots }},
+	{"respect_nofollow", "respect-nofollow", func(d *Config, s Config) { d.RespectNoFollow = s.RespectNoFollow }},
+	{"max_bytes_per_second", "max-bytes-per-second", func(d *Config, s Config) { d.MaxBytesPerSecond = s.MaxBytesPerSecond }},
+	{"max_pages", "max-pages", func(d *Config, s Config) { d.MaxPages = s.MaxPages }},
+	{"max_redirects", "max-redirects", func(d *Config, s Config) { d.MaxRedirects = s.MaxRedirects }},
+	{"follow_external_redirects", "follow-external-redirects", func(d *Config, s Config) { d.FollowExternalRedirects = s.FollowExternalRedirects }},
+	{"max_total_bytes", "max-total-bytes", func(d *Config, s Config) { d.MaxTotalBytes = s.MaxTotalBytes }},
+	{"cookies", "cookie", nil},
+	{"cookie_file", "cookie-file", nil},
+	{"header", "header", nil},
+	{"checkpoint_interval", "checkpoint-interval", func(d *Config, s Config) { d.CheckpointInterval = s.CheckpointInterval }},
+	{"checkpoint_pages", "checkpoint-pages", func(d *Config, s Config) { d.CheckpointPages = s.CheckpointPages }},
+	{"save_metadata", "save-metadata", func(d *Config, s Config) { d.SaveMetadata = s.SaveMetadata }},
+	{"blocked_url_substrings", "block", func(d *Config, s Config) { d.BlockedURLSubstrings = s.BlockedURLSubstrings }},
+	{"strategy", "strategy", func(d *Config, s Config) { d.Strategy = s.Strategy }},
+	{"log_level", "log-level", nil},
+	{"log_stderr", "log-stderr", nil},
+}
 
-		// Пересоздаем загрузчик
-		job.Downloader = NewDownloader(cfg)
+var jobsCmd = &cobra.Command{
+	Use:   "jobs [output-dir]",
+	Short: "List resumable download jobs",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := loadConfig().OutputDir
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		jobs, err := ListStoredJobs(dir)
+		if err != nil {
+			log.Fatalf("Failed to list jobs: %v", err)
+		}
 
-		// ДОБАВЬТЕ: Восстанавливаем обработчики
-		job.Handlers = []ContentHandler{&LinkRewriterHandlerV2{
-			outputDir: cfg.OutputDir,
-			analyzer:  NewStrategyAnalyzer(),
-		}}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(jobs)
+			return
+		}
+		if len(jobs) == 0 {
+			fmt.Printf("No jobs in %s\n", dir)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tURL\tPAGES\tPENDING\tFAILED\tMODIFIED\tSTATUS")
+		for _, j := range jobs {
+			status := "resumable"
+			switch {
+			case j.Error != "":
+				status = "unreadable: " + j.Error
+			case j.Complete:
+				status = "complete"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", j.ID, j.RootURL, j.Pages, j.Pending, j.Failed,
+				j.Modified.Format("2006-01-02 15:04"), status)
+		}
+		tw.Flush()
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <site-dir>",
+	Short: "Export a processed site",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		docker, _ := cmd.Flags().GetBool("docker")
+		zipped, _ := cmd.Flags().GetBool("zip")
+		if docker == zipped {
+			log.Fatal("Choose an export format: --docker or --zip")
+		}
+		out, _ := cmd.Flags().GetString("out")
+		compose, _ := cmd.Flags().GetBool("compose")
+		port, _ := cmd.Flags().GetInt("port")
+		root, _ := cmd.Flags().GetString("root")
+
+		lock, err := LockSite(strings.TrimSuffix(filepath.Clean(args[0]), "_processed"), "export")
+		if err != nil {
+			log.Fatalf("Failed to lock site: %v", err)
+		}
+		defer lock.Unlock()
 
-		log.Printf("Resuming job %s for %s", job.ID, job.RootURL)
-		job.Run()
+		if zipped {
+			if out == "" {
+				out = filepath.Base(filepath.Clean(args[0])) + ".zip"
+			}
+			if err := server.ExportZip(args[0], out, server.ZipOptions{Root: root}); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			log.Printf("ZIP archive written to %s", out)
+			return
+		}
+
+		if out == "" {
+			out = filepath.Base(filepath.Clean(args[0])) + "-docker"
+		}
+		if err := server.ExportDockerBundle(args[0], out, server.ExportOptions{Compose: compose, Port: port}); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		log.Printf("Docker bundle written to %s", out)
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <site-dir>",
+	Short: "Check local links of a saved site without network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := VerifySite(args[0])
+		if err != nil {
+			log.Fatalf("Verify failed: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		} else {
+			if len(report.Pages) > 0 {
+				tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "PAGE\tREF\tTARGET\tREASON")
+				for _, p := range report.Pages {
+					for _, pr := range p.Problems {
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Page, pr.Ref, pr.Target, pr.Reason)
+					}
+				}
+				tw.Flush()
+			}
+			fmt.Printf("%d files, %d local links, %d external, %d broken on %d pages\n",
+				report.Files, report.Refs, report.External, report.Problems(), len(report.Pages))
+		}
+		if report.Problems() > 0 {
+			os.Exit(1)
+		}
 	},
 }
 
@@ -1626,9 +2909,41 @@ func loadConfig() Config {
 	viper.SetDefault("max_depth", DefaultMaxDepth)
 	viper.SetDefault("retries", DefaultRetries)
 	viper.SetDefault("delay", DefaultDelay)
+	viper.SetDefault("page_timeout", DefaultPageTimeout)
+	viper.SetDefault("asset_timeout", DefaultAssetTimeout)
 	viper.SetDefault("max_file_size", DefaultMaxFileSize)
 	viper.SetDefault("output_dir", "./downloads")
 	viper.SetDefault("user_agent", DefaultUserAgent)
+	viper.SetDefault("host_failure_threshold", DefaultHostFailureThreshold)
+	viper.SetDefault("host_cooldown", DefaultHostCooldown)
+	viper.SetDefault("queue_size", DefaultQueueSize)
+	viper.SetDefault("probe_timeout", DefaultProbeTimeout)
+	viper.SetDefault("deferred_retries", DefaultDeferredRetries)
+	viper.SetDefault("deferred_retry_delay", DefaultDeferredRetryDelay)
+	viper.SetDefault("shared_cache", false)
+	viper.SetDefault("dedupe_content", true)
+	viper.SetDefault("incremental", false)
+	viper.SetDefault("dry_run", false)
+	viper.SetDefault("single_page", false)
+	viper.SetDefault("save_metadata", false)
+	viper.SetDefault("format", FormatTree)
+	viper.SetDefault("checkpoint_interval", DefaultCheckpointInterval)
+	viper.SetDefault("checkpoint_pages", DefaultCheckpointPages)
+	viper.SetDefault("asset_queries", AssetQueryEncode)
+	viper.SetDefault("discovery_quiet", DefaultDiscoveryQuiet)
+	viper.SetDefault("respect_robots", false)
+	viper.SetDefault("respect_nofollow", false)
+	viper.SetDefault("max_bytes_per_second", 0)
+	viper.SetDefault("max_pages", 0)
+	viper.SetDefault("download_external_assets", false)
+	viper.SetDefault("include_subdomains", false)
+	viper.SetDefault("parse_javascript", false)
+	viper.SetDefault("max_total_bytes", 0)
+	viper.SetDefault("max_redirects", DefaultMaxRedirects)
+	viper.SetDefault("follow_external_redirects", false)
+	viper.SetDefault("cookies", []string{})
+	viper.SetDefault("log_level", LogInfo)
+	viper.SetDefault("log_stderr", true)
 
 	// Чтение конфигурационного файла
 	viper.SetConfigName("config")
@@ -1636,6 +2951,16 @@ func loadConfig() Config {
 	viper.AddConfigPath(".")
 	viper.ReadInConfig() // Игнорируем ошибку если файла нет
 
+	// headers из файла, --header "Name: value" поверх них
+	headers := viper.GetStringMapString("headers")
+	extra, err := ParseHeaders(viper.GetStringSlice("header"))
+	if err != nil {
+		log.Fatalf("Invalid --header: %v", err)
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+
 	return Config{
 		Workers:     viper.GetInt("workers"),
 		MaxDepth:    viper.GetInt("max_depth"),
@@ -1644,6 +2969,54 @@ func loadConfig() Config {
 		MaxFileSize: viper.GetInt64("max_file_size"),
 		OutputDir:   viper.GetString("output_dir"),
 		UserAgent:   viper.GetString("user_agent"),
+		UAPreset:    viper.GetString("ua_preset"),
+		UserAgents:  viper.GetStringSlice("user_agents"),
+		UARotation:  viper.GetString("ua_rotation"),
+
+		HostFailureThreshold: viper.GetInt("host_failure_threshold"),
+		HostCooldown:         viper.GetDuration("host_cooldown"),
+		QueueSize:            viper.GetInt("queue_size"),
+		Headers:              headers,
+		ProbeTimeout:         viper.GetDuration("probe_timeout"),
+		PageTimeout:          viper.GetDuration("page_timeout"),
+		AssetTimeout:         viper.GetDuration("asset_timeout"),
+		DeferredRetries:      viper.GetInt("deferred_retries"),
+		DeferredRetryDelay:   viper.GetDuration("deferred_retry_delay"),
+		SharedCache:          viper.GetBool("shared_cache"),
+		SharedCacheDir:       viper.GetString("shared_cache_dir"),
+		DedupeContent:        viper.GetBool("dedupe_content"),
+		Incremental:          viper.GetBool("incremental"),
+		DryRun:               viper.GetBool("dry_run"),
+		SinglePage:           viper.GetBool("single_page"),
+		SaveMetadata:         viper.GetBool("save_metadata"),
+		Format:               viper.GetString("format"),
+		Strategy:             viper.GetString("strategy"),
+		AssetQueries:         viper.GetString("asset_queries"),
+		CacheBusters:         viper.GetStringSlice("cache_busters"),
+		DiscoveryQuiet:       viper.GetDuration("discovery_quiet"),
+		CheckpointInterval:   viper.GetDuration("checkpoint_interval"),
+		CheckpointPages:      viper.GetInt("checkpoint_pages"),
+		RespectRobots:        viper.GetBool("respect_robots"),
+		RespectNoFollow:      viper.GetBool("respect_nofollow"),
+		MaxBytesPerSecond:    viper.GetInt64("max_bytes_per_second"),
+		MaxPages:             viper.GetInt64("max_pages"),
+		MaxTotalBytes:        viper.GetInt64("max_total_bytes"),
+		MaxRedirects:         viper.GetInt("max_redirects"),
+		IncludePatterns:      viper.GetStringSlice("include"),
+		ExcludePatterns:      viper.GetStringSlice("exclude"),
+		BlockedURLSubstrings: viper.GetStringSlice("blocked_url_substrings"),
+		ExtraDomains:         viper.GetStringSlice("extra_domains"),
+		Cookies:              viper.GetStringSlice("cookies"),
+		CookieFile:           viper.GetString("cookie_file"),
+
+		DownloadExternalAssets: viper.GetBool("download_external_assets"),
+		IncludeSubdomains:      viper.GetBool("include_subdomains"),
+		ParseJavaScript:        viper.GetBool("parse_javascript"),
+
+		FollowExternalRedirects: viper.GetBool("follow_external_redirects"),
+
+		LogLevel:  viper.GetString("log_level"),
+		LogStderr: viper.GetBool("log_stderr"),
 	}
 }
 
@@ -1653,15 +3026,100 @@ func init() {
 	downloadCmd.Flags().Int("max-depth", DefaultMaxDepth, "Maximum recursion depth")
 	downloadCmd.Flags().Int("retries", DefaultRetries, "Retry attempts per URL")
 	downloadCmd.Flags().Duration("delay", DefaultDelay, "Delay between requests")
+	downloadCmd.Flags().Duration("page-timeout", DefaultPageTimeout, "Timeout of one attempt to fetch a page (or a URL without an extension)")
+	downloadCmd.Flags().Duration("asset-timeout", DefaultAssetTimeout, "Timeout of one attempt to fetch an image, video, font or other asset")
 	downloadCmd.Flags().Int64("max-file-size", DefaultMaxFileSize, "Maximum file size in bytes")
 	downloadCmd.Flags().String("output-dir", "./downloads", "Output directory")
 	downloadCmd.Flags().String("user-agent", DefaultUserAgent, "HTTP User-Agent header")
+	downloadCmd.Flags().String("ua-preset", "", "Browser profile: chrome-latest, firefox, googlebot or mobile-safari (User-Agent with matching Accept and client hints)")
+	downloadCmd.Flags().StringArray("rotate-user-agent", nil, "User-Agent or preset name to rotate between (repeatable)")
+	downloadCmd.Flags().String("ua-rotation", UARoundRobin, "How to rotate --rotate-user-agent: round-robin (every request) or per-host (one per host)")
+	downloadCmd.Flags().Bool("respect-robots", false, "Obey robots.txt Disallow/Allow and Crawl-delay")
+	downloadCmd.Flags().Bool("respect-nofollow", false, "Do not follow rel=\"nofollow\" links and links of pages marked nofollow by meta robots or X-Robots-Tag")
+	downloadCmd.Flags().Int64("max-bytes-per-second", 0, "Total bandwidth cap for all workers (0 = unlimited)")
+	downloadCmd.Flags().Int64("max-pages", 0, "Stop after saving this many files; resume later with a higher limit (0 = unlimited)")
+	downloadCmd.Flags().Int64("max-total-bytes", 0, "Stop after downloading this many bytes in total (0 = unlimited)")
+	downloadCmd.Flags().Int("max-redirects", DefaultMaxRedirects, "Redirects to follow per request; a longer chain fails the URL without retries (-1 = none)")
+	downloadCmd.Flags().Bool("follow-external-redirects", false, "Download what a redirect to another host points to instead of skipping the URL")
+	downloadCmd.Flags().StringArray("cookie", nil, "Cookie header value for the site, e.g. \"session=abc\" (repeatable)")
+	downloadCmd.Flags().String("cookie-file", "", "Netscape cookies.txt exported from a browser")
+	downloadCmd.Flags().StringArray("header", nil, "Extra request header \"Name: value\" (repeatable)")
+	downloadCmd.Flags().StringArray("include", nil, "Only crawl pages matching this glob (\"/docs/**\") or re:regexp (repeatable)")
+	downloadCmd.Flags().StringArray("exclude", nil, "Skip URLs matching this glob (\"/tag/*\") or re:regexp; wins over --include (repeatable)")
+	downloadCmd.Flags().StringArray("block", nil, "Never download URLs containing this substring, e.g. \"yoomoney\" (repeatable)")
+	downloadCmd.Flags().Bool("include-subdomains", false, "Also crawl subdomains of the site (blog.example.com); www and bare domain are always one site")
+	downloadCmd.Flags().Bool("parse-js", false, "Discover URLs in JavaScript and JSON string literals (can grow the crawl a lot)")
+	downloadCmd.Flags().Bool("external-assets", false, "Also download CSS/JS/fonts/images from other hosts (CDNs) into <output-dir>/<host>")
+	downloadCmd.Flags().StringArray("extra-domain", nil, "Only take external assets from this host and its subdomains (repeatable)")
+	downloadCmd.Flags().Duration("checkpoint-interval", DefaultCheckpointInterval, "Save crawl state at least this often")
+	downloadCmd.Flags().Int("checkpoint-pages", DefaultCheckpointPages, "Also save crawl state after this many processed URLs")
+	downloadCmd.Flags().Bool("dedupe", true, "Store an asset reachable at several URLs once and alias the other URLs to it")
+	downloadCmd.Flags().String("strategy", CrawlBFS, "Crawl order: bfs (nearest to the root first), dfs (deepest first) or assets-first")
+	downloadCmd.Flags().String("format", FormatTree, "Output: tree (site folder), warc (<job-id>.warc.gz archive only) or warc+tree")
+	downloadCmd.Flags().Bool("save-metadata", false, "Write response status, headers and capture time of every saved file to <host>/.meta")
+	downloadCmd.Flags().Bool("incremental", false, "Re-crawl into the same output dir, skipping files the server reports unchanged (304)")
+	downloadCmd.Flags().Bool("single-page", false, "Download only the given page with its images, CSS, JS and fonts; links to other pages stay absolute")
+	downloadCmd.Flags().Bool("dry-run", false, "Crawl without saving anything and write a report of what would be downloaded to <job-id>.dryrun.json")
+	downloadCmd.Flags().String("asset-queries", AssetQueryEncode, "Asset query strings: encode (drop cache busters, keep the rest in the file name) or ignore")
+	downloadCmd.Flags().StringArray("cache-buster", nil, "Query parameter that does not change an asset (repeatable; default v, ver, rev, t, hash)")
+	downloadCmd.Flags().String("log-level", LogInfo, "Log level: debug (every request and rewritten link), info, warn or error")
+	downloadCmd.Flags().Bool("log-stderr", true, "Also print the log to the terminal (it is always written to <output-dir>/downloader.log)")
+	downloadCmd.Flags().String("from-file", "", "Read root URLs from a file, one per line (# starts a comment)")
+	downloadCmd.Flags().Int("parallel", 1, "With several URLs: how many jobs run at once (they share --workers)")
 
 	// Привязка флагов к viper
 	viper.BindPFlags(downloadCmd.Flags())
+	viper.BindPFlag("respect_robots", downloadCmd.Flags().Lookup("respect-robots"))
+	viper.BindPFlag("respect_nofollow", downloadCmd.Flags().Lookup("respect-nofollow"))
+	viper.BindPFlag("ua_preset", downloadCmd.Flags().Lookup("ua-preset"))
+	viper.BindPFlag("user_agents", downloadCmd.Flags().Lookup("rotate-user-agent"))
+	viper.BindPFlag("ua_rotation", downloadCmd.Flags().Lookup("ua-rotation"))
+	viper.BindPFlag("page_timeout", downloadCmd.Flags().Lookup("page-timeout"))
+	viper.BindPFlag("asset_timeout", downloadCmd.Flags().Lookup("asset-timeout"))
+	viper.BindPFlag("max_bytes_per_second", downloadCmd.Flags().Lookup("max-bytes-per-second"))
+	viper.BindPFlag("max_pages", downloadCmd.Flags().Lookup("max-pages"))
+	viper.BindPFlag("max_total_bytes", downloadCmd.Flags().Lookup("max-total-bytes"))
+	viper.BindPFlag("max_redirects", downloadCmd.Flags().Lookup("max-redirects"))
+	viper.BindPFlag("follow_external_redirects", downloadCmd.Flags().Lookup("follow-external-redirects"))
+	viper.BindPFlag("include_subdomains", downloadCmd.Flags().Lookup("include-subdomains"))
+	viper.BindPFlag("parse_javascript", downloadCmd.Flags().Lookup("parse-js"))
+	viper.BindPFlag("download_external_assets", downloadCmd.Flags().Lookup("external-assets"))
+	viper.BindPFlag("extra_domains", downloadCmd.Flags().Lookup("extra-domain"))
+	viper.BindPFlag("dedupe_content", downloadCmd.Flags().Lookup("dedupe"))
+	viper.BindPFlag("save_metadata", downloadCmd.Flags().Lookup("save-metadata"))
+	viper.BindPFlag("checkpoint_interval", downloadCmd.Flags().Lookup("checkpoint-interval"))
+	viper.BindPFlag("checkpoint_pages", downloadCmd.Flags().Lookup("checkpoint-pages"))
+	viper.BindPFlag("asset_queries", downloadCmd.Flags().Lookup("asset-queries"))
+	viper.BindPFlag("cache_busters", downloadCmd.Flags().Lookup("cache-buster"))
+	viper.BindPFlag("cookies", downloadCmd.Flags().Lookup("cookie"))
+	viper.BindPFlag("cookie_file", downloadCmd.Flags().Lookup("cookie-file"))
+	viper.BindPFlag("blocked_url_substrings", downloadCmd.Flags().Lookup("block"))
+	viper.BindPFlag("dry_run", downloadCmd.Flags().Lookup("dry-run"))
+	viper.BindPFlag("single_page", downloadCmd.Flags().Lookup("single-page"))
+	viper.BindPFlag("log_level", downloadCmd.Flags().Lookup("log-level"))
+	viper.BindPFlag("log_stderr", downloadCmd.Flags().Lookup("log-stderr"))
+
+	// Флаги для команды resume; общие с download перекрывают сохранённые в состоянии
+	resumeCmd.Flags().Bool("retry-failed", false, "Queue URLs that failed in previous runs again")
+	resumeCmd.Flags().Bool("start-if-missing", false, "Start a new download when the URL has no saved job")
+	for _, f := range resumeFlags {
+		resumeCmd.Flags().AddFlag(downloadCmd.Flags().Lookup(f.flag))
+	}
+
+	// Флаги для команды jobs
+	jobsCmd.Flags().Bool("json", false, "Print jobs as JSON")
+
+	// Флаги для команды export
+	exportCmd.Flags().Bool("docker", false, "Write a Docker/nginx bundle")
+	exportCmd.Flags().Bool("zip", false, "Write the site into a single ZIP archive")
+	exportCmd.Flags().String("out", "", "Bundle directory (default <site>-docker) or archive path (default <site>.zip)")
+	exportCmd.Flags().String("root", "", "Top-level folder inside the ZIP archive")
+	exportCmd.Flags().Bool("compose", true, "Also write docker-compose.yml")
+	exportCmd.Flags().Int("port", server.DefaultPort, "Host port in docker-compose.yml")
+	verifyCmd.Flags().Bool("json", false, "Print the report as JSON")
 
 	// Добавление команд
-	rootCmd.AddCommand(downloadCmd, resumeCmd)
+	rootCmd.AddCommand(downloadCmd, resumeCmd, jobsCmd, exportCmd, verifyCmd)
 }
 
 func main() {
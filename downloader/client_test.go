@@ -0,0 +1,6111 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+
+	"sitemvp/internal/sitetest"
+)
+
+func TestVerifyHTMLContentType(t *testing.T) {
+	testCases := []struct {
+		name        string
+		content     string
+		contentType string
+		expected    string
+	}{
+		{
+			name:        "Real HTML page",
+			content:     "<!DOCTYPE html><html><body>ok</body></html>",
+			contentType: "text/html; charset=utf-8",
+			expected:    "text/html; charset=utf-8",
+		},
+		{
+			name:        "HTML fragment without doctype",
+			content:     "<div class=\"x\">fragment</div>",
+			contentType: "text/html",
+			expected:    "text/html",
+		},
+		{
+			name:        "JSON mislabeled as text/html",
+			content:     `{"users": [{"id": 1, "name": "a<b"}]}`,
+			contentType: "text/html; charset=utf-8",
+			expected:    "application/json",
+		},
+		{
+			name:        "Plain text mislabeled as text/html",
+			content:     "OK",
+			contentType: "text/html",
+			expected:    "text/plain",
+		},
+		{
+			name:        "Non-HTML type untouched",
+			content:     "body { color: red }",
+			contentType: "text/css",
+			expected:    "text/css",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := verifyHTMLContentType([]byte(tc.content), tc.contentType)
+			if result != tc.expected {
+				t.Errorf("\nContent:  %s\nExpected: %s\nGot:      %s", tc.content, tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestMislabeledJSONSavedAsFile(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte(`[{"id": 1}]`)
+
+	ct := verifyHTMLContentType(body, "text/html")
+	rel, err := SaveFileV2(dir, "https://example.com/api/users", body, ct)
+	if err != nil {
+		t.Fatalf("SaveFileV2: %v", err)
+	}
+	if rel != "api/users" {
+		t.Errorf("Expected api/users, got %s", rel)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "api", "users", "index.html")); err == nil {
+		t.Errorf("JSON body must not be saved as index.html")
+	}
+
+	// Настоящая страница по тому же шаблону URL остаётся директорией
+	rel, err = SaveFileV2(dir, "https://example.com/about", []byte("<html></html>"), "text/html")
+	if err != nil {
+		t.Fatalf("SaveFileV2: %v", err)
+	}
+	if rel != "about/index.html" {
+		t.Errorf("Expected about/index.html, got %s", rel)
+	}
+}
+
+// newStateTestJob — минимальный Job для проверки сохранения состояния
+func newStateTestJob(dir string) *Job {
+	return &Job{
+		ID:        "test",
+		RootURL:   "https://example.com/",
+		stateFile: filepath.Join(dir, "test"+StateFileExtension),
+		pending:   make(chan string),
+		frontier:  newFrontier(CrawlBFS, DefaultQueueSize),
+		queued:    newPendingSet(),
+		visited:   make(map[string]bool),
+		depths:    make(map[string]int),
+		stats:     JobStats{FileTypes: make(map[string]int64)},
+	}
+}
+
+func TestLoadStateAcceptsSnapshotAndJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	// Старый формат: только снимок
+	j := newStateTestJob(dir)
+	j.trackDepth("https://example.com/a", 1)
+	if err := j.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// Новый формат: дельты поверх снимка
+	j.trackDepth("https://example.com/b", 2)
+	if err := j.checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	j.trackDepth("https://example.com/c", 3)
+	j.enqueue("https://example.com/c", 3)
+	if err := j.checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	restored := newStateTestJob(dir)
+	if err := restored.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	for u, want := range map[string]int{
+		"https://example.com/a": 1,
+		"https://example.com/b": 2,
+		"https://example.com/c": 3,
+	} {
+		if got, ok := restored.depths[u]; !ok || got != want {
+			t.Errorf("depth for %s: expected %d, got %d (present=%v)", u, want, got, ok)
+		}
+	}
+	if restored.frontier.Len() != 1 {
+		t.Errorf("Expected 1 pending URL, got %d", restored.frontier.Len())
+	}
+	if _, err := os.Stat(restored.journalFile()); !os.IsNotExist(err) {
+		t.Errorf("Journal must be compacted into the snapshot after load")
+	}
+}
+
+func TestCheckpointKeepsInFlightURLs(t *testing.T) {
+	dir := t.TempDir()
+	j := newStateTestJob(dir)
+	for _, p := range []string{"a", "b", "c"} {
+		j.trackDepth("https://example.com/"+p, 1)
+		j.enqueue("https://example.com/"+p, 1)
+	}
+	// Воркер взял a и упал, не закончив: a должен вернуться при resume
+	j.frontier.pop()
+	if err := j.checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	restored := newStateTestJob(dir)
+	if err := restored.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	got := strings.Join(restored.frontier.drain(), " ")
+	if got != "https://example.com/a https://example.com/b https://example.com/c" {
+		t.Errorf("Unexpected restored queue: %s", got)
+	}
+
+	// Обработанный URL из очереди уходит, повторно поставленный — нет
+	j.enqueue("https://example.com/b", 1)
+	j.queued.done("https://example.com/a")
+	j.queued.done("https://example.com/b")
+	if got := j.snapshotPending(); len(got) != 2 || got[0] != "https://example.com/b" {
+		t.Errorf("Unexpected pending after done: %v", got)
+	}
+
+	// Снимок не трогает очередь, пока воркеры её читают
+	j = newStateTestJob(t.TempDir())
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	for i := 0; i < 1000; i++ {
+		j.enqueue(fmt.Sprintf("https://example.com/%d", i), 1)
+	}
+	go j.dispatch()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			j.queued.done(<-j.pending)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		j.snapshotPending()
+	}
+	wg.Wait()
+	j.cancel()
+	if left := j.snapshotPending(); len(left) != 0 {
+		t.Errorf("Snapshot re-queued %d URLs", len(left))
+	}
+}
+
+func TestCheckpointEveryPages(t *testing.T) {
+	j := newStateTestJob(t.TempDir())
+	j.Config.CheckpointPages = 3
+	j.checkpointDue = make(chan struct{}, 1)
+	signals := 0
+	for i := 0; i < 7; i++ {
+		j.pageDone()
+		select {
+		case <-j.checkpointDue:
+			signals++
+		default:
+		}
+	}
+	if signals != 2 {
+		t.Errorf("Expected a checkpoint after pages 3 and 6, got %d", signals)
+	}
+}
+
+func TestLoadManifestDeduplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m"+ManifestExtension)
+	m, err := openManifest(path)
+	if err != nil {
+		t.Fatalf("openManifest: %v", err)
+	}
+	m.Append(ManifestEntry{URL: "https://example.com/", Size: 1})
+	m.Append(ManifestEntry{URL: "https://example.com/x", Size: 2})
+	m.Append(ManifestEntry{URL: "https://example.com/", Size: 3})
+	m.Close()
+
+	if err := compactManifest(path); err != nil {
+		t.Fatalf("compactManifest: %v", err)
+	}
+	entries, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Size != 3 {
+		t.Errorf("Unexpected entries after compaction: %+v", entries)
+	}
+}
+
+// Сравнение паузы в конце задачи на синтетическом обходе из 200k URL:
+// полный снимок против дельты (последние 1000 URL).
+//
+//	go test ./downloader -bench EndOfJob -run ^$
+func benchmarkEndOfJobState(b *testing.B, full bool) {
+	dir := b.TempDir()
+	j := newStateTestJob(dir)
+	for i := 0; i < 200000; i++ {
+		j.depths[fmt.Sprintf("https://example.com/page/%d", i)] = i % 10
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		j.newDepths = j.newDepths[:0]
+		for k := 0; k < 1000; k++ {
+			j.newDepths = append(j.newDepths, fmt.Sprintf("https://example.com/page/%d", k))
+		}
+		os.Remove(j.journalFile())
+		b.StartTimer()
+
+		var err error
+		if full {
+			err = j.saveState()
+		} else {
+			err = j.checkpoint()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEndOfJobFullSnapshot(b *testing.B) { benchmarkEndOfJobState(b, true) }
+func BenchmarkEndOfJobDelta(b *testing.B)        { benchmarkEndOfJobState(b, false) }
+
+// Обход локального сайта из 1000 страниц по 20 ссылок с логом в
+// терминал, как у CLI. Пока NormalizeURL и переписывание ссылок писали
+// строку на каждую ссылку, обход выводил ~10 MB лога (1.78 s/op); с одной
+// строкой «found N links» на страницу — ~220 KB (1.66 s/op). В терминале
+// или виджете лога GUI, где каждая строка дорогая, разница куда больше.
+//
+//	go test ./downloader -bench CrawlLogging -run ^$
+func BenchmarkCrawlLogging(b *testing.B) {
+	const pages, linksPerPage = 1000, 20
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(r.URL.Path, "/p/%d", &n)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><h1>%d</h1>", n)
+		for k := 1; k <= linksPerPage; k++ {
+			fmt.Fprintf(w, `<a href="/p/%d">%d</a>`, (n*linksPerPage+k)%pages, k)
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	defer srv.Close()
+
+	terminal, err := os.Create(filepath.Join(b.TempDir(), "stderr.log"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer terminal.Close()
+	log.SetOutput(terminal)
+	defer log.SetOutput(os.Stderr)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 8, MaxDepth: 100, Retries: 1, OutputDir: b.TempDir(), LogStderr: true},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if got := sum.Stats.FileTypes[FileHTML]; got < pages {
+			b.Fatalf("Saved %d of %d pages", got, pages)
+		}
+	}
+	b.StopTimer()
+	if info, err := terminal.Stat(); err == nil {
+		b.ReportMetric(float64(info.Size())/float64(b.N), "log-bytes/op")
+	}
+}
+
+func TestDownloadTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(make([]byte, 2048))
+		case "/video.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(make([]byte, 2048))
+		case "/stream":
+			// Без Content-Length (chunked)
+			w.Header().Set("Content-Type", "text/plain")
+			for i := 0; i < 4; i++ {
+				w.Write(make([]byte, 512))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(Config{
+		Workers:           1,
+		Retries:           1,
+		MaxFileSize:       1024,
+		MaxFileSizeByType: map[string]int64{"video/": 4096},
+	})
+
+	if _, _, err := d.Download(context.Background(), srv.URL+"/big.bin"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge by Content-Length, got %v", err)
+	}
+	if _, _, err := d.Download(context.Background(), srv.URL+"/stream"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge for chunked body, got %v", err)
+	}
+	if _, _, err := d.Download(context.Background(), srv.URL+"/video.mp4"); err != nil {
+		t.Errorf("Type rule must raise the limit for video/: %v", err)
+	}
+
+	if got := len(d.TooLargeFiles()); got != 2 {
+		t.Errorf("Expected 2 files in too-large list, got %d", got)
+	}
+}
+
+func TestEventsCloseWithLateSenders(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	j := &Job{events: newEventBus()}
+	j.Events = j.events.out
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 200; k++ {
+				j.sendLog("msg", false)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for range j.Events {
+		}
+		close(done)
+	}()
+
+	j.events.close()
+	j.events.close() // Повторное закрытие не паникует
+	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events channel was not closed")
+	}
+}
+
+func TestCancelledJobKeepsOnlyStatusInEvents(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{events: newEventBus(), ctx: ctx}
+	j.Events = j.events.out
+
+	j.sendLog("before", false)
+	cancel()
+	j.sendLog("worker", false) // Строка сворачивающегося воркера
+	j.sendStatus("stopped")
+	j.events.close()
+
+	var got []string
+	for msg := range j.Events {
+		got = append(got, msg)
+	}
+	if !reflect.DeepEqual(got, []string{"before", "stopped"}) {
+		t.Errorf("Expected only the status line after cancel, got %v", got)
+	}
+}
+
+func TestUnreadEventsDoNotBlockDelivery(t *testing.T) {
+	out := make(chan string, 2)
+	q := newListenerQueue(&channelListener{out: out})
+	done := make(chan struct{})
+	q.onDone = func() { close(done) }
+	go q.run()
+
+	// Events никто не читает — доставка не встаёт, старые строки вытесняются
+	for i := 0; i < 10; i++ {
+		q.push(&event{kind: eventLog, msg: fmt.Sprint(i)})
+	}
+	q.finish()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delivery blocked on unread Events")
+	}
+	if got := []string{<-out, <-out}; got[0] != "8" || got[1] != "9" {
+		t.Errorf("Expected the latest lines to stay, got %v", got)
+	}
+}
+
+func TestProgressChannelKeepsLatest(t *testing.T) {
+	j := &Job{events: newEventBus()}
+	j.Progress = j.events.progress
+
+	// Progress никто не читает — отправители не блокируются
+	for i := int64(1); i <= 50; i++ {
+		j.emit(&event{kind: eventProgress, snap: Snapshot{Files: i, Queued: 2, Overflow: 3, Message: "tick"}})
+	}
+	j.events.close()
+
+	var got []JobProgress
+	for p := range j.Progress {
+		got = append(got, p)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Expected only the latest progress, got %d values", len(got))
+	}
+	if p := got[0]; p.TotalFiles != 50 || p.QueueLength != 5 || p.Message != "tick" {
+		t.Errorf("Unexpected progress: %+v", p)
+	}
+}
+
+func TestRewriteLinkUsesSavedPath(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	h := &LinkRewriterHandlerV2{outputDir: dir, saved: newSavedPaths()}
+	page := FileMetadata{URL: "http://example.com/about/", ContentType: "text/html"}
+
+	// .php-страница встречается в ссылке раньше, чем скачана
+	out, err := h.Handle([]byte(`<a href="/contacts.php?x=1">c</a><a href="/api/data">d</a>`), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrefs := func(page []byte) []string {
+		var got []string
+		for _, m := range regexp.MustCompile(`href="([^"]+)"`).FindAllSubmatch(page, -1) {
+			got = append(got, string(m[1]))
+		}
+		return got
+	}
+	// Пока /api/data не скачан, путь угадывается как каталог с index.html
+	if got := hrefs(out); !reflect.DeepEqual(got, []string{"../contacts.php?x=1", "../api/data/"}) {
+		t.Errorf("Unexpected links before download: %q", got)
+	}
+
+	// Теперь она скачивается: ссылка должна вести на реально сохранённый файл
+	relPath, err := SaveFileV2(dir, "http://example.com/contacts.php", []byte("<html></html>"), "text/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.saved.record("http://example.com/contacts.php", relPath)
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "about", "..", "contacts.php")); err != nil {
+		t.Errorf("Link target does not exist on disk: %v", err)
+	}
+	out, _ = h.Handle([]byte(`<a href="/contacts.php?x=1">c</a>`), page)
+	if got := hrefs(out); !reflect.DeepEqual(got, []string{"../contacts.php?x=1"}) {
+		t.Errorf("Link must point to the saved .php page: %q", got)
+	}
+
+	// JSON по "красивому" URL сохраняется файлом — ссылка берёт путь из реестра
+	relPath, err = SaveFileV2(dir, "http://example.com/api/data", []byte("{}"), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := h.saved.record("http://example.com/api/data", relPath)
+	if sp.Strategy != StrategyFile {
+		t.Errorf("Expected file strategy, got %s", sp.Strategy)
+	}
+	out, _ = h.Handle([]byte(`<a href="/api/data">d</a>`), page)
+	if got := hrefs(out); !reflect.DeepEqual(got, []string{"../api/data"}) {
+		t.Errorf("Link must point to the saved file: %q", got)
+	}
+}
+
+func TestQueueOverflowSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	j := newStateTestJob(dir)
+	j.Config.QueueSize = 2
+	j.initQueue()
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	defer j.cancel()
+
+	for i := 0; i < 10; i++ {
+		j.enqueue(fmt.Sprintf("https://example.com/%d", i), 1)
+	}
+	if queued, overflow := j.QueueDepth(); queued != 2 || overflow != 8 {
+		t.Fatalf("Expected 2 queued and 8 on disk, got %d/%d", queued, overflow)
+	}
+
+	// Снимок состояния включает файл переполнения
+	if err := j.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	restored := newStateTestJob(dir)
+	restored.Config.QueueSize = 2
+	if err := restored.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if queued, overflow := restored.QueueDepth(); queued+overflow != 10 {
+		t.Errorf("Expected 10 restored URLs, got %d+%d", queued, overflow)
+	}
+	restored.overflow.close()
+
+	// Фидер возвращает всё с диска в очередь, диспетчер — воркерам
+	go j.feeder()
+	go j.dispatch()
+	got := make(map[string]bool)
+	for len(got) < 10 {
+		select {
+		case u := <-j.pending:
+			got[u] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Feeder stalled after %d URLs", len(got))
+		}
+	}
+	// delivered() вызывается сразу после отправки последнего URL
+	deadline := time.Now().Add(5 * time.Second)
+	for j.overflow.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, overflow := j.QueueDepth(); overflow != 0 {
+		t.Errorf("Overflow must be empty, got %d", overflow)
+	}
+	j.overflow.close()
+	if _, err := os.Stat(j.overflowFile()); !os.IsNotExist(err) {
+		t.Errorf("Overflow file must be removed on close")
+	}
+}
+
+func TestRunCancelThenResume(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan *Job, 1)
+	go func() {
+		job := <-started
+		// Ждём, пока воркер возьмёт страницу после корня
+		for {
+			if ws := job.WorkerStatus(); len(ws) > 0 && strings.HasSuffix(ws[0].URL, "/a") {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	sum, err := Run(ctx, RunOptions{
+		URL:     srv.URL + "/",
+		Config:  Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: dir},
+		OnStart: func(j *Job) { started <- j },
+	})
+	if !errors.Is(err, context.Canceled) || !sum.Canceled {
+		t.Fatalf("Expected canceled run, got %v (%+v)", err, sum)
+	}
+
+	close(release)
+	// Таблица воркеров готова к OnStart и учитывает Workers из Override
+	var workers int
+	sum, err = Resume(context.Background(), sum.StateFile, ResumeOptions{
+		Override: func(cfg *Config) { cfg.Workers = 3 },
+		OnStart:  func(j *Job) { workers = len(j.WorkerStatus()) },
+	})
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if workers != 3 {
+		t.Errorf("Worker table at OnStart has %d rows, want 3", workers)
+	}
+	for _, p := range []string{"a/index.html", "b/index.html"} {
+		host := strings.TrimPrefix(srv.URL, "http://")
+		if _, err := os.Stat(filepath.Join(dir, host, p)); err != nil {
+			t.Errorf("%s not downloaded after resume: %v", p, err)
+		}
+	}
+}
+
+func TestWorkerStatusBeforeStart(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+
+	// Таблицу опрашивают с момента создания задачи, а не только после OnStart
+	job, err := newJob(context.Background(), srv.URL+"/", Config{Workers: 2, MaxDepth: 1, Retries: 1, OutputDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(job.WorkerStatus()); n != 2 {
+		t.Fatalf("Worker table has %d rows before start, want 2", n)
+	}
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				job.WorkerStatus()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	if _, err := runJob(context.Background(), job, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	<-polled
+}
+
+func TestResumeSkipsCompletedAndRetriesFailed(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var served sync.Map // Путь → сколько раз отдан целиком
+	count := func(p string) int32 {
+		if n, ok := served.Load(p); ok {
+			return atomic.LoadInt32(n.(*int32))
+		}
+		return 0
+	}
+	var brokenFixed, slowReleased atomic.Bool
+	// Запрос /slow первого запуска дошёл до сервера и вернулся: без этого
+	// оборванный запрос мог бы прийти уже после сброса счётчиков
+	slowStarted, slowAborted := make(chan struct{}), make(chan struct{})
+	var slowOnce sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			if !brokenFixed.Load() {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		case "/slow":
+			if !slowReleased.Load() {
+				slowOnce.Do(func() {
+					close(slowStarted)
+					<-r.Context().Done() // «Убиваем» задачу, пока качается эта страница
+					close(slowAborted)
+				})
+				return
+			}
+		}
+		n, _ := served.LoadOrStore(r.URL.Path, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="/p1">1</a><a href="/p2">2</a><a href="/broken">b</a><a href="/slow">s</a></body></html>`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := Config{Workers: 1, MaxDepth: 1, Retries: 1, OutputDir: dir}
+	sum, err := Run(ctx, RunOptions{
+		URL:    srv.URL + "/",
+		Config: cfg,
+		OnStart: func(j *Job) {
+			go func() {
+				<-slowStarted
+				cancel()
+			}()
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected canceled run, got %v", err)
+	}
+	select {
+	case <-slowAborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First-run /slow request never returned")
+	}
+
+	// Состояние знает, что сохранено, а что упало
+	restored, err := loadJob(context.Background(), sum.StateFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.events.close()
+	if len(restored.completed) != 3 || restored.failed[srv.URL+"/broken"] == "" {
+		t.Fatalf("Unexpected restored outcomes: completed=%v failed=%v", restored.completed, restored.failed)
+	}
+
+	// resume докачивает только прерванное; упавшее без флага не трогаем
+	served.Range(func(k, _ any) bool { served.Delete(k); return true })
+	slowReleased.Store(true)
+	brokenFixed.Store(true)
+	sum, err = Resume(context.Background(), sum.StateFile, ResumeOptions{})
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	for p, want := range map[string]int32{"/": 0, "/p1": 0, "/p2": 0, "/slow": 1} {
+		if count(p) != want {
+			t.Errorf("%s served %d times on resume, want %d", p, count(p), want)
+		}
+	}
+	if count("/broken") != 0 {
+		t.Errorf("Failed URL retried without RetryFailed")
+	}
+
+	// С RetryFailed упавший URL качается снова, сохранённые — нет
+	sum, err = Resume(context.Background(), sum.StateFile, ResumeOptions{RetryFailed: true})
+	if err != nil {
+		t.Fatalf("Resume with RetryFailed: %v", err)
+	}
+	if count("/broken") != 1 || count("/p1") != 0 {
+		t.Errorf("Expected only /broken to be fetched, got broken=%d p1=%d", count("/broken"), count("/p1"))
+	}
+	if sum.Stats.Failed != 0 {
+		t.Errorf("Retried URL still counted as failed: %d", sum.Stats.Failed)
+	}
+}
+
+func TestLoadStateMigratesVersion1(t *testing.T) {
+	dir := t.TempDir()
+	j := newStateTestJob(dir)
+	// Файл первой версии: без Version и Completed; /a уже в манифесте
+	old := `{"ID":"test","RootURL":"https://example.com/","PendingURLs":["https://example.com/a","https://example.com/b"],` +
+		`"DepthMap":{"https://example.com/a":1,"https://example.com/b":1},"Stats":{},"Config":{}}`
+	os.WriteFile(j.stateFile, []byte(old), 0644)
+	os.WriteFile(j.manifestFile(), []byte(`{"url":"https://example.com/a","path":"a/index.html"}`+"\n"), 0644)
+
+	if err := j.loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if got := j.frontier.drain(); len(got) != 1 || got[0] != "https://example.com/b" {
+		t.Errorf("Saved URL must not be queued again, got %v", got)
+	}
+	if err := j.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	var state JobState
+	data, _ := os.ReadFile(j.stateFile)
+	if err := json.Unmarshal(data, &state); err != nil || state.Version != StateVersion || len(state.Completed) != 1 {
+		t.Errorf("State not migrated: %+v %v", state, err)
+	}
+}
+
+func TestListStoredJobs(t *testing.T) {
+	dir := t.TempDir()
+	// Прерванная задача: снимок с очередью и дописанный после него журнал
+	os.WriteFile(filepath.Join(dir, "aaaa1111"+StateFileExtension), []byte(`{"ID":"aaaa1111","RootURL":"https://a.example/",`+
+		`"PendingURLs":["https://a.example/x"],"Version":2,"Completed":["https://a.example/"],"Stats":{},"Config":{}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "aaaa1111"+StateJournalExtension), []byte(`{"id":"aaaa1111","rootUrl":"https://a.example/",`+
+		`"pending":["https://a.example/x","https://a.example/y"],"completed":["https://a.example/z"],"failed":{"https://a.example/e":"boom"}}`+"\n"), 0644)
+	// Завершённая задача только с журналом
+	os.WriteFile(filepath.Join(dir, "bbbb2222"+StateJournalExtension), []byte(`{"id":"bbbb2222","rootUrl":"https://b.example/",`+
+		`"version":2,"completed":["https://b.example/","https://b.example/old"]}`+"\n"), 0644)
+	// Редирект /old сохранён алиасом /: страница одна
+	os.WriteFile(filepath.Join(dir, "bbbb2222"+ManifestExtension), []byte(`{"url":"https://b.example/","path":"index.html"}`+"\n"+
+		`{"url":"https://b.example/old","path":"index.html","aliasOf":"https://b.example/"}`+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "cccc3333"+StateFileExtension), []byte(`{broken`), 0644)
+	os.WriteFile(filepath.Join(dir, "aaaa1111"+ManifestExtension), nil, 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "bbbb2222"+StateJournalExtension), old, old)
+
+	jobs, err := ListStoredJobs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("Expected 3 jobs, got %+v", jobs)
+	}
+	byID := map[string]StoredJob{}
+	for _, j := range jobs {
+		byID[j.ID] = j
+	}
+	a := byID["aaaa1111"]
+	if a.RootURL != "https://a.example/" || a.Pages != 2 || a.Pending != 2 || a.Failed != 1 || a.Complete {
+		t.Errorf("Interrupted job read wrong: %+v", a)
+	}
+	if b := byID["bbbb2222"]; !b.Complete || b.Pages != 1 || b.Error != "" {
+		t.Errorf("Journal-only job read wrong: %+v", b)
+	}
+	if c := byID["cccc3333"]; c.Error == "" {
+		t.Errorf("Broken state must be reported, got %+v", c)
+	}
+	if jobs[len(jobs)-1].ID != "bbbb2222" {
+		t.Errorf("Jobs must be newest first: %+v", jobs)
+	}
+
+	// Чтение ничего не меняет: журнал сворачивает только resume
+	if _, err := LoadJobState(filepath.Join(dir, "aaaa1111"+StateJournalExtension)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "aaaa1111"+StateJournalExtension)); err != nil {
+		t.Errorf("Listing must not compact the journal: %v", err)
+	}
+}
+
+func TestFindJobState(t *testing.T) {
+	dir := t.TempDir()
+	id := JobID("https://example.com/")
+	os.WriteFile(filepath.Join(dir, id+StateFileExtension), []byte(`{"ID":"`+id+`","RootURL":"https://example.com/"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "broken"+StateFileExtension), []byte(`{broken`), 0644)
+	want := filepath.Join(dir, id+StateFileExtension)
+
+	// ID, тот же URL и его варианты находят одну задачу
+	for _, arg := range []string{id, "https://example.com/", "https://example.com", "http://example.com/"} {
+		got, err := FindJobState(dir, arg)
+		if err != nil || got != want {
+			t.Errorf("FindJobState(%q) = %q, %v; want %q", arg, got, err, want)
+		}
+	}
+	for _, arg := range []string{"deadbeef", "https://other.example/"} {
+		if _, err := FindJobState(dir, arg); !errors.Is(err, ErrNoJobState) {
+			t.Errorf("FindJobState(%q): expected ErrNoJobState, got %v", arg, err)
+		}
+	}
+
+	// Отсутствующее и битое состояние различимы
+	if _, err := LoadJobState(filepath.Join(dir, "missing"+StateFileExtension)); !errors.Is(err, ErrNoJobState) {
+		t.Errorf("Expected ErrNoJobState, got %v", err)
+	}
+	_, err := Resume(context.Background(), filepath.Join(dir, "broken"+StateFileExtension), ResumeOptions{})
+	if !errors.Is(err, ErrCorruptJobState) {
+		t.Errorf("Expected ErrCorruptJobState, got %v", err)
+	}
+}
+
+func TestSVGParser(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	cases := map[string][]string{
+		"icons.svg": {
+			"https://example.com/img/pattern.png",
+			"https://example.com/img/logo.png",
+		},
+		"illustration.svg": {
+			"https://example.com/art/photo.jpg",
+		},
+	}
+	for name, want := range cases {
+		content, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &SVGParser{}
+		links, err := p.Parse(content, "https://example.com/art/"+name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprint(links) != fmt.Sprint(want) {
+			t.Errorf("%s: expected %v, got %v", name, want, links)
+		}
+	}
+
+	// Спрайт из inline SVG в HTML ставится в очередь без фрагмента
+	links, _ := (&HTMLParser{}).Parse([]byte(`<svg><use xlink:href="/icons.svg#home"></use></svg>`), "https://example.com/")
+	if len(links) != 1 || links[0] != "https://example.com/icons.svg#home" {
+		t.Errorf("Sprite reference not extracted: %v", links)
+	}
+	if !isSVG("https://example.com/icons.svg", "text/plain") {
+		t.Errorf(".svg served as text/plain must be parsed as SVG")
+	}
+}
+
+func TestHTMLParserLazyImages(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "lazy.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := (&HTMLParser{}).Parse(content, "https://example.com/gallery/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://example.com/img/small.jpg",
+		"https://example.com/img/large.jpg",
+		"https://example.com/img/lazy.jpg",
+		"https://example.com/img/lazy-1x.jpg",
+		"https://example.com/img/lazy-2x.jpg",
+		"https://example.com/gallery/photos/cat.webp",
+		"https://example.com/img/hero.avif",
+		"https://example.com/img/hero-a.webp",
+		"https://example.com/img/hero-b.webp",
+		"https://example.com/img/hero.jpg",
+		"https://example.com/media/poster.jpg",
+		"https://example.com/media/intro.mp4",
+		"https://example.com/media/intro.webm",
+		"https://example.com/media/theme.mp3",
+		"https://example.com/img/background.jpg",
+	}
+	got := map[string]bool{}
+	for _, l := range links {
+		got[l] = true
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("%s not extracted; got %v", w, links)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d links, got %v", len(want), links)
+	}
+
+	for in, want := range map[string]string{
+		"a.jpg 1x, b.jpg 2x":                  "[a.jpg b.jpg]",
+		"a.jpg, b.jpg,":                       "[a.jpg b.jpg]",
+		" img/x.png 100w,\n img/y.png 200w ":  "[img/x.png img/y.png]",
+		"pic.jpg (max-width: 1px, 2x), z.jpg": "[pic.jpg z.jpg]",
+	} {
+		if got := fmt.Sprint(splitSrcset(in)); got != want {
+			t.Errorf("splitSrcset(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestJSParser(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	content, err := os.ReadFile(filepath.Join("testdata", "bundle.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &JSParser{hosts: newSiteHosts("example.com", Config{})}
+	if !p.CanParse("application/javascript; charset=utf-8") || !p.CanParse("application/json") || p.CanParse("text/html") {
+		t.Errorf("Unexpected CanParse result")
+	}
+	links, err := p.Parse(content, "https://example.com/static/app.bundle.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://example.com/api/v1",
+		"https://example.com/api/v1/items.json",
+		"https://example.com/api/v1/me",
+		"https://example.com/api/v1/search?q=",
+		"https://example.com/data/menu.json",
+		"https://example.com/img/sprite.svg",
+		"https://example.com/img/hero@2x.webp",
+		"https://example.com/fonts/inter.woff2",
+		"https://example.com/static/chunks/412.js",
+		"https://www.example.com/assets/logo.png",
+	}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		t.Errorf("\nwant: %v\ngot:  %v", want, links)
+	}
+
+	// Скрипты с CDN не разбираются: их пути — пути CDN
+	if links, _ := p.Parse(content, "https://cdn.other.net/app.js"); len(links) != 0 {
+		t.Errorf("Foreign script must not be parsed: %v", links)
+	}
+
+	// JSParser — только по Config.ParseJavaScript
+	for _, on := range []bool{false, true} {
+		found := false
+		for _, parser := range newParsers("example.com", Config{ParseJavaScript: on}) {
+			_, ok := parser.(*JSParser)
+			found = found || ok
+		}
+		if found != on {
+			t.Errorf("ParseJavaScript=%v: JSParser enabled=%v", on, found)
+		}
+	}
+}
+
+func TestProbeHeadersMatchCrawl(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]http.Header{}
+	hosts := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.Method] = r.Header.Clone()
+		hosts[r.Method] = r.Host
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	cfg := Config{
+		Workers:   1,
+		Retries:   1,
+		UserAgent: "TestBot/1.0",
+		Headers:   map[string]string{"X-Token": "secret", "Accept-Language": "en", "Host": "origin.example"},
+	}
+	if _, _, err := NewDownloader(cfg).Download(context.Background(), srv.URL+"/a.txt"); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	probe := NewProber(cfg, 2)
+	for i := 0; i < 2; i++ {
+		resp, err := probe.Head(context.Background(), srv.URL+"/a.txt")
+		if err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := probe.Head(context.Background(), srv.URL+"/a.txt"); !errors.Is(err, ErrProbeBudget) {
+		t.Errorf("Expected ErrProbeBudget after budget is spent, got %v", err)
+	}
+
+	crawl, head := seen[http.MethodGet], seen[http.MethodHead]
+	for _, h := range []string{"User-Agent", "Referer", "Accept", "Accept-Language", "X-Token"} {
+		if crawl.Get(h) == "" || crawl.Get(h) != head.Get(h) {
+			t.Errorf("%s: crawl %q, probe %q", h, crawl.Get(h), head.Get(h))
+		}
+	}
+	if head.Get("Accept-Language") != "en" {
+		t.Errorf("Config headers must override defaults, got %q", head.Get("Accept-Language"))
+	}
+	if hosts[http.MethodGet] != "origin.example" || hosts[http.MethodHead] != "origin.example" {
+		t.Errorf("Host header must go to req.Host, got %v", hosts)
+	}
+
+	headers, err := ParseHeaders([]string{"authorization: Bearer t0k:en", "X-Api-Key:  k "})
+	if err != nil || headers["Authorization"] != "Bearer t0k:en" || headers["X-Api-Key"] != "k" {
+		t.Errorf("Unexpected parsed headers %v (%v)", headers, err)
+	}
+	for _, bad := range []string{"no colon", ": empty name", "Bad Name: x"} {
+		if _, _, err := ParseHeader(bad); err == nil {
+			t.Errorf("ParseHeader(%q) must fail", bad)
+		}
+	}
+}
+
+func TestHeadersStayOnSite(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	leaked := map[string]string{}
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tok := r.Header.Get("X-Token"); tok != "" {
+			mu.Lock()
+			leaked[r.URL.Path] = tok
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "png")
+	}))
+	defer cdn.Close()
+	var siteTokens int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") == "secret" {
+			atomic.AddInt64(&siteTokens, 1)
+		}
+		if r.URL.Path == "/logo.png" {
+			http.Redirect(w, r, cdn.URL+"/moved.png", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><img src="%s/a.png"><img src="/logo.png"></body></html>`, cdn.URL)
+	}))
+	defer srv.Close()
+
+	_, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: Config{
+		Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), DownloadExternalAssets: true,
+		Headers: map[string]string{"X-Token": "secret"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&siteTokens) == 0 {
+		t.Error("Config.Headers not sent to the site")
+	}
+	if len(leaked) > 0 {
+		t.Errorf("Config.Headers sent to the CDN (directly or after a redirect): %v", leaked)
+	}
+	// Конфигурация задачи уходит в состояние как JSON
+	if state, err := json.Marshal(Config{Headers: map[string]string{"X-Token": "secret"}}); err != nil || strings.Contains(string(state), "secret") {
+		t.Errorf("Config.Headers must not be saved to the state: %s", state)
+	}
+}
+
+func TestDeferredRetryRecoversTransientFailures(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/flaky">f</a><a href="/broken">b</a><a href="/gone">g</a></body></html>`)
+			return
+		}
+		mu.Lock()
+		if r.Method == http.MethodGet {
+			gets[r.URL.Path]++
+		}
+		n := gets[r.URL.Path]
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/gone":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky" && n > 1 && r.Method == http.MethodGet:
+			fmt.Fprint(w, `<html><body>back</body></html>`)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	sum, err := Run(context.Background(), RunOptions{
+		URL: srv.URL + "/",
+		Config: Config{
+			Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(),
+			DeferredRetries: 2, DeferredRetryDelay: 20 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if gets["/flaky"] != 2 {
+		t.Errorf("Expected /flaky to be fetched twice, got %d", gets["/flaky"])
+	}
+	if gets["/broken"] != 3 {
+		t.Errorf("Expected /broken to be fetched 1+2 times, got %d", gets["/broken"])
+	}
+	if gets["/gone"] != 1 {
+		t.Errorf("404 must not be deferred, got %d fetches", gets["/gone"])
+	}
+	if sum.Stats.Recovered != 1 {
+		t.Errorf("Expected 1 recovered URL, got %d", sum.Stats.Recovered)
+	}
+	if sum.Stats.Failed != 2 {
+		t.Errorf("Expected 2 final failures, got %d", sum.Stats.Failed)
+	}
+}
+
+func TestDownloadErrorClassification(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gets[r.URL.Path]++
+		n := gets[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(Config{Retries: 3, Delay: time.Millisecond})
+	ctx := context.Background()
+
+	// 4xx — окончательный отказ: один запрос, без повторов
+	for _, p := range []string{"/forbidden", "/gone"} {
+		_, _, err := d.Download(ctx, srv.URL+p)
+		var de *DownloadError
+		if !errors.As(err, &de) || !de.Permanent() || de.Attempts != 1 || de.URL != srv.URL+p {
+			t.Errorf("%s: expected permanent DownloadError after 1 attempt, got %#v", p, err)
+		}
+		if !errors.Is(err, ErrDownloadFailed) || isTransientError(err) {
+			t.Errorf("%s: permanent error must be ErrDownloadFailed and not transient: %v", p, err)
+		}
+	}
+
+	// 5xx повторяется с паузами и может пройти
+	if body, _, err := d.Download(ctx, srv.URL+"/flaky"); err != nil || string(body) != "ok" {
+		t.Errorf("/flaky must succeed on the third attempt: %q %v", body, err)
+	}
+	_, _, err := d.Download(ctx, srv.URL+"/broken")
+	var de *DownloadError
+	if !errors.As(err, &de) || de.Permanent() || de.Status != 500 || de.Attempts != 3 || !isTransientError(err) {
+		t.Errorf("Expected retryable DownloadError after 3 attempts, got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "HTTP 500 Internal Server Error after 3 attempts") {
+		t.Errorf("Error must name status and attempts: %v", err)
+	}
+
+	mu.Lock()
+	if gets["/forbidden"] != 1 || gets["/gone"] != 1 || gets["/flaky"] != 3 || gets["/broken"] != 3 {
+		t.Errorf("Unexpected request counts: %v", gets)
+	}
+	mu.Unlock()
+
+	// Пауза растёт вдвое с каждой попыткой до MaxBackoff, джиттер не выходит за потолок
+	if c := retryCeiling(0, 1); c != MinRetryBackoff {
+		t.Errorf("First ceiling = %v, want %v", c, MinRetryBackoff)
+	}
+	if c := retryCeiling(time.Second, 3); c != 4*time.Second {
+		t.Errorf("Third ceiling = %v, want 4s", c)
+	}
+	if c := retryCeiling(time.Second, 20); c != MaxBackoff {
+		t.Errorf("Ceiling must stop at MaxBackoff, got %v", c)
+	}
+	for n := 1; n <= 5; n++ {
+		if p := d.retryPause(n); p < 0 || p > retryCeiling(d.delay, n) {
+			t.Errorf("retryPause(%d) = %v out of range", n, p)
+		}
+	}
+
+	// В статистике задачи окончательные отказы видны отдельно
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/private">p</a></body></html>`)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer page.Close()
+	sum, err := Run(ctx, RunOptions{URL: page.URL + "/", Config: Config{Workers: 1, MaxDepth: 2, Retries: 3, OutputDir: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if sum.Stats.Failed != 1 || sum.Stats.Permanent != 1 {
+		t.Errorf("Expected 1 permanent failure, got failed=%d permanent=%d", sum.Stats.Failed, sum.Stats.Permanent)
+	}
+}
+
+func TestHostHealthHalfOpen(t *testing.T) {
+	h := newHostHealth(2, 20*time.Millisecond)
+	h.fail("a.com")
+	if !h.fail("a.com") {
+		t.Fatal("Host must be marked down at the threshold")
+	}
+	if ok, _ := h.allow("a.com"); ok {
+		t.Fatal("Host must be refused during cooldown")
+	}
+	if ok, probe := h.allow("b.com"); !ok || probe {
+		t.Fatal("Other hosts are unaffected")
+	}
+
+	// После cooldown пропускается ровно одна проба, даже из многих воркеров
+	time.Sleep(30 * time.Millisecond)
+	var wg sync.WaitGroup
+	var probes, allowed int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, probe := h.allow("a.com")
+			if ok {
+				atomic.AddInt64(&allowed, 1)
+			}
+			if probe {
+				atomic.AddInt64(&probes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if probes != 1 || allowed != 1 {
+		t.Fatalf("Expected exactly one probe, got %d probes, %d allowed", probes, allowed)
+	}
+
+	// Проба упала: хост сразу снова недоступен на cooldown
+	if !h.fail("a.com") {
+		t.Error("A failed probe must mark the host down again")
+	}
+	h.endProbe("a.com")
+	if ok, _ := h.allow("a.com"); ok {
+		t.Error("Host must be refused after a failed probe")
+	}
+
+	// Проба без ответа (отмена, таймаут) отпускает место следующей
+	time.Sleep(30 * time.Millisecond)
+	if ok, probe := h.allow("a.com"); !ok || !probe {
+		t.Fatal("Expected a probe after the second cooldown")
+	}
+	h.endProbe("a.com")
+	if ok, probe := h.allow("a.com"); !ok || !probe {
+		t.Fatal("An unanswered probe must not block the host forever")
+	}
+
+	// Удачная проба закрывает цепь: запросы идут без ограничений
+	h.succeed("a.com")
+	h.endProbe("a.com")
+	for i := 0; i < 3; i++ {
+		if ok, probe := h.allow("a.com"); !ok || probe {
+			t.Fatal("Host must be open after a successful probe")
+		}
+	}
+	if got := h.summary(); len(got) != 1 || got[0].Host != "a.com" || got[0].AffectedURLs != 11 {
+		t.Errorf("summary = %+v, want 11 refused URLs of a.com", got)
+	}
+}
+
+type recordingListener struct {
+	mu       sync.Mutex
+	block    chan struct{} // Если задан, первый OnProgress ждёт его закрытия
+	files    int
+	progress []int64
+	errors   int
+	complete int
+}
+
+func (l *recordingListener) OnFileDone(FileResult) { l.mu.Lock(); l.files++; l.mu.Unlock() }
+func (l *recordingListener) OnError(ErrorEvent)    { l.mu.Lock(); l.errors++; l.mu.Unlock() }
+func (l *recordingListener) OnComplete(Summary)    { l.mu.Lock(); l.complete++; l.mu.Unlock() }
+
+func (l *recordingListener) OnProgress(s Snapshot) {
+	l.mu.Lock()
+	first := len(l.progress) == 0
+	l.progress = append(l.progress, s.Files)
+	l.mu.Unlock()
+	if first && l.block != nil {
+		<-l.block
+	}
+}
+
+func TestSubscribeSlowListener(t *testing.T) {
+	bus := newEventBus()
+	go func() {
+		for range bus.out {
+		}
+	}()
+
+	slow := &recordingListener{block: make(chan struct{})}
+	fast := &recordingListener{}
+	bus.subscribe(slow, true)
+	bus.subscribe(fast, true)
+
+	for i := int64(1); i <= 100; i++ {
+		bus.publish(&event{kind: eventProgress, snap: Snapshot{Files: i}})
+		if i%20 == 0 {
+			bus.publish(&event{kind: eventFile})
+		}
+	}
+	bus.publish(&event{kind: eventError})
+	bus.publish(&event{kind: eventComplete})
+
+	// Медленный подписчик не тормозит быстрого
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fast.mu.Lock()
+		done := fast.complete == 1
+		fast.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Fast listener blocked by slow one")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(slow.block)
+	bus.close()
+
+	for name, l := range map[string]*recordingListener{"slow": slow, "fast": fast} {
+		if l.files != 5 || l.errors != 1 || l.complete != 1 {
+			t.Errorf("%s: files=%d errors=%d complete=%d", name, l.files, l.errors, l.complete)
+		}
+	}
+	if len(slow.progress) >= 100 {
+		t.Errorf("Slow listener must get coalesced progress, got %d updates", len(slow.progress))
+	}
+	if last := slow.progress[len(slow.progress)-1]; last != 100 {
+		t.Errorf("Slow listener must see the latest snapshot, got %d", last)
+	}
+}
+
+func TestProgressCoalescedPerListener(t *testing.T) {
+	bus := newEventBus()
+	go func() {
+		for range bus.out {
+		}
+	}()
+
+	// Оба подписчика схлопывают прогресс, каждый в своей копии события:
+	// общий *event не меняется под доставкой другому (проверяет -race)
+	a := &recordingListener{block: make(chan struct{})}
+	b := &recordingListener{block: make(chan struct{})}
+	bus.subscribe(a, true)
+	bus.subscribe(b, true)
+	for i := int64(1); i <= 200; i++ {
+		bus.publish(&event{kind: eventProgress, snap: Snapshot{Files: i}})
+		if i == 100 {
+			close(a.block)
+		}
+		if i > 100 {
+			time.Sleep(50 * time.Microsecond)
+		}
+	}
+	close(b.block)
+	bus.close()
+
+	for name, l := range map[string]*recordingListener{"a": a, "b": b} {
+		for i := 1; i < len(l.progress); i++ {
+			if l.progress[i] <= l.progress[i-1] {
+				t.Errorf("%s: progress went back: %v", name, l.progress)
+				break
+			}
+		}
+		if last := l.progress[len(l.progress)-1]; last != 200 {
+			t.Errorf("%s: expected the latest snapshot, got %d", name, last)
+		}
+	}
+}
+
+func TestSaveFileV2PathContainment(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "out")
+	site := filepath.Join(out, "example.com")
+
+	testCases := []struct {
+		url    string
+		reject bool
+	}{
+		{"http://example.com/normal/page.html", false},
+		{"http://example.com/../../../../etc/cron.d/evil", false}, // Clean от корня оставляет внутри
+		{"http://example.com/a%2F..%2F..%2F..%2Fescape.txt", false},
+		{"http://example.com/%252e%252e/%252e%252e/double.txt", false}, // Двойное кодирование — буквальное имя
+		{"http://example.com/a%5C..%5C..%5C..%5Cevil.txt", true},       // Обратные слэши
+		{`http://example.com/a\..\..\..\evil.txt`, true},
+		{"http://example.com/a%00b.txt", true},
+		{"http://../evil.txt", true},
+	}
+
+	for _, tc := range testCases {
+		rel, err := SaveFileV2(out, tc.url, []byte("x"), "text/plain")
+		if tc.reject {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("%s: expected ErrUnsafePath, got rel=%q err=%v", tc.url, rel, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.url, err)
+			continue
+		}
+		full := filepath.Join(site, rel)
+		if r, err := filepath.Rel(site, full); err != nil || strings.HasPrefix(r, "..") {
+			t.Errorf("%s: saved outside site dir: %s", tc.url, full)
+		}
+		if _, err := os.Stat(full); err != nil {
+			t.Errorf("%s: file not written: %v", tc.url, err)
+		}
+	}
+
+	// Снаружи out ничего не появилось
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 1 {
+		t.Errorf("Files escaped the output dir: %v", entries)
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 1 {
+		t.Errorf("Files escaped the site dir: %v", entries)
+	}
+}
+
+func TestSharedCacheLinksIdenticalFiles(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	lib := strings.Repeat("/* jquery */", 100)
+	newSite := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/jquery.js" {
+				w.Header().Set("Content-Type", "application/javascript")
+				fmt.Fprint(w, lib)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><body>%s<script src="/jquery.js"></script></body></html>`, name)
+		}))
+	}
+	a, b := newSite("a"), newSite("b")
+	defer a.Close()
+	defer b.Close()
+
+	out := t.TempDir()
+	cfg := Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: out, SharedCache: true}
+	var sums []Summary
+	for _, srv := range []*httptest.Server{a, b} {
+		sum, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg})
+		if err != nil {
+			t.Fatalf("Run %s: %v", srv.URL, err)
+		}
+		sums = append(sums, sum)
+	}
+	if sums[0].Stats.CacheHits != 0 || sums[1].Stats.CacheHits != 1 {
+		t.Fatalf("Expected cache hit only on second site, got %d and %d", sums[0].Stats.CacheHits, sums[1].Stats.CacheHits)
+	}
+	if sums[1].Stats.CacheBytesSaved != int64(len(lib)) {
+		t.Errorf("Expected %d bytes saved, got %d", len(lib), sums[1].Stats.CacheBytesSaved)
+	}
+
+	siteFile := func(srv *httptest.Server) string {
+		return filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"), "jquery.js")
+	}
+	fa, _ := os.Stat(siteFile(a))
+	fb, _ := os.Stat(siteFile(b))
+	if fa == nil || fb == nil || !os.SameFile(fa, fb) {
+		t.Error("Identical files must share one inode")
+	}
+
+	// Пока второй сайт на диске, запись кеша живёт
+	os.RemoveAll(filepath.Dir(siteFile(a)))
+	if removed, _, err := GCSharedCache(out); err != nil || removed != 0 {
+		t.Errorf("GC removed %d entries still in use (err %v)", removed, err)
+	}
+	os.RemoveAll(filepath.Dir(siteFile(b)))
+	removed, freed, err := GCSharedCache(out)
+	if err != nil || removed != 1 || freed != int64(len(lib)) {
+		t.Errorf("Expected GC to remove the jquery entry, got removed=%d freed=%d err=%v", removed, freed, err)
+	}
+}
+
+func TestProgressTrackerPhases(t *testing.T) {
+	tr := newProgressTracker(time.Second, 1, 0)
+	start := tr.lastTick
+
+	// Обход: находок больше, чем скачиваний — ETA нет
+	for i := 0; i < 20; i++ {
+		tr.discover()
+	}
+	tr.complete()
+	var snap Snapshot
+	if tr.tick(start.Add(time.Second), &snap) {
+		t.Fatal("Phase must not change while URLs are still discovered")
+	}
+	if snap.Phase != JobDiscovering || snap.ETA != 0 {
+		t.Fatalf("Expected discovery without ETA, got %s ETA %v", snap.Phase, snap.ETA)
+	}
+	if snap.Discovered != 21 || snap.Completed != 1 {
+		t.Fatalf("Expected 1/21, got %d/%d", snap.Completed, snap.Discovered)
+	}
+
+	// Находки кончились — докачка, ETA по остатку
+	tr.mu.Lock()
+	tr.lastDiscovery = start
+	tr.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		tr.complete()
+	}
+	if !tr.tick(start.Add(2*time.Second), &snap) {
+		t.Fatal("Expected phase change after quiet period")
+	}
+	if snap.Phase != JobDownloading || snap.ETA <= 0 {
+		t.Fatalf("Expected downloading with ETA, got %s ETA %v", snap.Phase, snap.ETA)
+	}
+	if tr.tick(start.Add(3*time.Second), &snap) {
+		t.Error("Phase change must be reported once")
+	}
+	if !strings.Contains(FormatETA(snap), "11/21") {
+		t.Errorf("Unexpected progress line %q", FormatETA(snap))
+	}
+
+	// Скорость — по приросту байт за тик, а не среднее с начала задачи
+	snap = Snapshot{Bytes: 4096}
+	tr.tick(start.Add(4*time.Second), &snap)
+	if snap.Speed != 4096 {
+		t.Errorf("Expected 4096 B/s, got %v", snap.Speed)
+	}
+}
+
+func TestSiteLock(t *testing.T) {
+	out := t.TempDir()
+	dir := filepath.Join(out, "example.com")
+	lock, err := LockSite(dir, "job first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Живой владелец: вторая задача получает понятную ошибку
+	_, err = LockSite(dir, "job second")
+	var inUse *SiteInUseError
+	if !errors.Is(err, ErrSiteInUse) || !errors.As(err, &inUse) || inUse.Owner != "job first" {
+		t.Fatalf("Expected site in use by job first, got %v", err)
+	}
+	_, err = Run(context.Background(), RunOptions{
+		URL:    "http://example.com/",
+		Config: Config{OutputDir: out, Workers: 1},
+	})
+	if !errors.Is(err, ErrSiteInUse) {
+		t.Fatalf("Expected Run to refuse a locked site, got %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, SiteLockFileName)); !os.IsNotExist(err) {
+		t.Error("Unlock must remove the lock file")
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Errorf("Second Unlock must be a no-op, got %v", err)
+	}
+}
+
+func TestSiteLockStaleRecovery(t *testing.T) {
+	// PID завершившегося процесса — как после падения задачи
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot start helper process: %v", err)
+	}
+	deadPID := cmd.Process.Pid
+
+	writeLock := func(dir string, info siteLockInfo) {
+		data, _ := json.Marshal(info)
+		if err := os.WriteFile(filepath.Join(dir, SiteLockFileName), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		info siteLockInfo
+	}{
+		{"dead process", siteLockInfo{PID: deadPID, Owner: "job crashed", Started: time.Now(), Updated: time.Now()}},
+		{"expired timestamp", siteLockInfo{PID: os.Getpid(), Owner: "job hung", Started: time.Now().Add(-time.Hour), Updated: time.Now().Add(-time.Hour)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeLock(dir, c.info)
+
+			lock, err := LockSite(dir, "job recovered")
+			if err != nil {
+				t.Fatalf("Expected stale lock to be taken over, got %v", err)
+			}
+			defer lock.Unlock()
+			held, err := readSiteLock(filepath.Join(dir, SiteLockFileName))
+			if err != nil || held.Owner != "job recovered" || held.PID != os.Getpid() {
+				t.Errorf("Lock file not rewritten: %+v (err %v)", held, err)
+			}
+		})
+	}
+
+	// Испорченный файл свежий — владелец мог не дописать его; старый — брошен
+	dir := t.TempDir()
+	path := filepath.Join(dir, SiteLockFileName)
+	os.WriteFile(path, []byte("{"), 0644)
+	if _, err := LockSite(dir, "job"); !errors.Is(err, ErrSiteInUse) {
+		t.Fatalf("Expected fresh corrupt lock to be respected, got %v", err)
+	}
+	old := time.Now().Add(-2 * SiteLockStaleAfter)
+	os.Chtimes(path, old, old)
+	lock, err := LockSite(dir, "job")
+	if err != nil {
+		t.Fatalf("Expected old corrupt lock to be taken over, got %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestSaveFileDirectoryConflict(t *testing.T) {
+	// Сначала файл /about, потом страница внутри about/
+	out := t.TempDir()
+	rel, moved, err := saveFile(out, "https://example.com/about", []byte("about"), "text/plain")
+	if err != nil || rel != "about" || moved != "" {
+		t.Fatalf("Unexpected first save: %q %q %v", rel, moved, err)
+	}
+	rel, moved, err = saveFile(out, "https://example.com/about/team/", []byte("<html>team</html>"), "text/html")
+	if err != nil || rel != "about/team/index.html" || moved != "about" {
+		t.Fatalf("Expected about to be moved aside, got %q %q %v", rel, moved, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "example.com", "about", "index.html")); string(data) != "about" {
+		t.Errorf("Moved file content lost: %q", data)
+	}
+
+	// Сначала папка, потом файл с тем же именем: пишем в папку, готовую страницу не трогаем
+	out = t.TempDir()
+	if _, _, err := saveFile(out, "https://example.com/about/", []byte("<html>dir</html>"), "text/html"); err != nil {
+		t.Fatal(err)
+	}
+	rel, _, err = saveFile(out, "https://example.com/about", []byte("about"), "text/plain")
+	if err != nil || rel != "about/index.html" {
+		t.Fatalf("Expected directory form, got %q %v", rel, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "example.com", "about", "index.html")); string(data) != "<html>dir</html>" {
+		t.Errorf("Existing page overwritten: %q", data)
+	}
+	rel, _, err = saveFile(out, "https://example.com/api", []byte("{}"), "application/json")
+	if err != nil || rel != "api" {
+		t.Errorf("Unrelated file must stay a file, got %q %v", rel, err)
+	}
+
+	// Ассет уступает имя папке, но остаётся ассетом: сначала ассет, потом страница
+	out = t.TempDir()
+	if _, _, err := saveFile(out, "https://example.com/data.json", []byte(`{"a":1}`), "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	rel, moved, err = saveFile(out, "https://example.com/data.json/view/", []byte("<html>view</html>"), "text/html")
+	if err != nil || rel != "data.json/view/index.html" || moved != "data.json" {
+		t.Fatalf("Expected data.json to be moved aside, got %q %q %v", rel, moved, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "example.com", "data~file.json")); string(data) != `{"a":1}` {
+		t.Errorf("Moved asset content lost: %q", data)
+	}
+
+	// Сначала страница, потом ассет: папку не трогаем, ассет — рядом
+	out = t.TempDir()
+	if _, _, err := saveFile(out, "https://example.com/data.json/view/", []byte("<html>view</html>"), "text/html"); err != nil {
+		t.Fatal(err)
+	}
+	rel, moved, err = saveFile(out, "https://example.com/data.json", []byte(`{"a":1}`), "application/json")
+	if err != nil || rel != "data~file.json" || moved != "" {
+		t.Fatalf("Expected suffixed asset, got %q %q %v", rel, moved, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "example.com", "data~file.json")); string(data) != `{"a":1}` {
+		t.Errorf("Asset not written: %q", data)
+	}
+
+	// /x уже файл, а /x/y и /x/z сохраняются одновременно: x переносится
+	// в папку один раз, и ни один файл не теряется
+	urls := []string{"https://example.com/x/y", "https://example.com/x/z", "https://example.com/x/y/w"}
+	for i := 0; i < 100; i++ {
+		out = t.TempDir()
+		if _, _, err := saveFile(out, "https://example.com/x", []byte("https://example.com/x"), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for _, u := range urls {
+			wg.Add(1)
+			go func(u string) {
+				defer wg.Done()
+				<-start
+				if _, _, err := saveFile(out, u, []byte(u), "text/plain"); err != nil {
+					t.Errorf("saveFile(%s): %v", u, err)
+				}
+			}(u)
+		}
+		close(start)
+		wg.Wait()
+		for u, rel := range map[string]string{"https://example.com/x": "x/index.html", urls[0]: "x/y/index.html", urls[1]: "x/z", urls[2]: "x/y/w"} {
+			if data, _ := os.ReadFile(filepath.Join(out, "example.com", filepath.FromSlash(rel))); string(data) != u {
+				t.Fatalf("Concurrent saves lost %s: %s has %q", u, rel, data)
+			}
+		}
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	long := strings.Repeat("я", 150) + ".css"
+	cases := []struct{ in, want string }{
+		{"docs/a:b.txt", "docs/a%3Ab.txt"},
+		{`q/what?<x>|"y"*.html`, "q/what%3F%3Cx%3E%7C%22y%22%2A.html"},
+		{"dir./file. ", "dir%2E/file%2E%20"},
+		{"con/aux.txt/NUL.tar.gz", "con_/aux_.txt/NUL_.tar.gz"},
+		{"com1/lpt9/console/nullable", "com1_/lpt9_/console/nullable"},
+		{"tab\there", "tab%09here"},
+		{"/lead/trail/", "/lead/trail/"},
+		{"plain/index.html", "plain/index.html"},
+	}
+	for _, c := range cases {
+		if got := SanitizePath(c.in); got != c.want {
+			t.Errorf("SanitizePath(%q) = %q, want %q", c.in, got, c.want)
+		}
+		// Повторное применение ничего не меняет — на этом держится поиск в процессоре
+		if again := SanitizePath(c.want); again != c.want {
+			t.Errorf("SanitizePath not idempotent on %q: %q", c.want, again)
+		}
+	}
+
+	got := SanitizePath(long)
+	if len(got) > maxSegmentBytes || !strings.HasSuffix(got, ".css") || !utf8.ValidString(got) {
+		t.Errorf("Long segment not truncated: %d bytes %q", len(got), got)
+	}
+	if other := SanitizePath(strings.Repeat("я", 151) + ".css"); other == got {
+		t.Errorf("Truncated names with a common prefix collide")
+	}
+}
+
+func TestSaveSanitizedNames(t *testing.T) {
+	out := t.TempDir()
+	h := &LinkRewriterHandlerV2{outputDir: out, saved: newSavedPaths()}
+	for raw, want := range map[string]string{
+		"https://example.com/files/a:b.css":      "files/a%3Ab.css",
+		"https://example.com/con/":               "con_/index.html",
+		"https://example.com/notes./":            "notes%2E/index.html",
+		"https://example.com/report%3F2024.json": "report%3F2024.json",
+	} {
+		ct := "text/css"
+		if strings.HasSuffix(raw, "/") {
+			ct = "text/html"
+		}
+		rel, err := SaveFileV2(out, raw, []byte("x"), ct)
+		if err != nil || rel != want {
+			t.Errorf("%s: saved as %q (%v), want %q", raw, rel, err, want)
+			continue
+		}
+		// Переписанная ссылка со стартовой страницы ведёт ровно в сохранённый файл
+		key, _ := NormalizeURL(raw)
+		h.saved.record(key, rel)
+		link := h.rewriteLink(raw, FileMetadata{URL: "https://example.com/", ContentType: "text/html"})
+		lu, err := url.Parse(link)
+		if err != nil {
+			t.Errorf("%s: bad link %q", raw, link)
+			continue
+		}
+		got := path.Clean(lu.Path)
+		if strings.HasSuffix(lu.Path, "/") {
+			got = path.Join(got, "index.html")
+		}
+		if got != want {
+			t.Errorf("%s: link %q resolves to %q, want %q", raw, link, got, want)
+		}
+	}
+}
+
+func TestEncodedPathsRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Одни и те же страницы по-разному закодированными ссылками
+	var hits sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := hits.LoadOrStore(r.URL.Path, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `<html><body>
+<a href="/статьи/обзор">1</a>
+<a href="/%D1%81%D1%82%D0%B0%D1%82%D1%8C%D0%B8/%D0%BE%D0%B1%D0%B7%D0%BE%D1%80">2</a>
+<a href="/%d1%81%d1%82%d0%b0%d1%82%d1%8c%d0%b8/%d0%be%d0%b1%d0%b7%d0%be%d1%80">3</a>
+<a href="/my%20page/">4</a><a href="/my page/">5</a>
+<link rel="stylesheet" href="/c%2B%2B/a+b.css"><link rel="stylesheet" href="/c%2b%2b/a%2Bb.css">
+<img src="/%7Euser/photo.png"><img src="/~user/photo.png">
+</body></html>`)
+		case "/статьи/обзор", "/my page/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>ok</body></html>`)
+		case "/c++/a+b.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, "body{}")
+		case "/~user/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Каждое написание пути — один URL и одна загрузка
+	for _, p := range []string{"/статьи/обзор", "/my page/", "/~user/photo.png"} {
+		if n, ok := hits.Load(p); !ok || atomic.LoadInt32(n.(*int32)) != 1 {
+			t.Errorf("%s fetched %v times", p, n)
+		}
+	}
+
+	// На диске — раскодированные имена, ссылки страницы ведут в них
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	for _, rel := range []string{"статьи/обзор/index.html", "my page/index.html", "c++/a+b.css", "~user/photo.png"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("%s not saved: %v", rel, err)
+		}
+	}
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	links := regexp.MustCompile(`(?:href|src)="([^"]+)"`).FindAllStringSubmatch(string(page), -1)
+	if len(links) != 9 {
+		t.Fatalf("Expected 9 links, got %d:\n%s", len(links), page)
+	}
+	for _, m := range links {
+		lu, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil || lu.IsAbs() {
+			t.Errorf("Link %q not rewritten to a local path", m[1])
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(lu.Path))
+		if strings.HasSuffix(lu.Path, "/") {
+			target = filepath.Join(target, "index.html")
+		}
+		if fi, err := os.Stat(target); err != nil || fi.IsDir() {
+			t.Errorf("Link %q does not resolve to a saved file", m[1])
+		}
+	}
+}
+
+func TestDirectoryConflictLinks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Обе очерёдности: ассет раньше страницы в его «папке» и наоборот
+	for _, assetFirst := range []bool{true, false} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/":
+				w.Header().Set("Content-Type", "text/html")
+				if assetFirst {
+					fmt.Fprint(w, `<html><body><script src="/lib.js"></script><a href="/lib.js/docs/">docs</a></body></html>`)
+				} else {
+					fmt.Fprint(w, `<html><body><a href="/lib.js/docs/">docs</a></body></html>`)
+				}
+			case "/lib.js/docs/":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body><a href="/usage/">usage</a></body></html>`)
+			case "/usage/":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body><script src="/lib.js"></script></body></html>`)
+			case "/lib.js":
+				w.Header().Set("Content-Type", "application/javascript")
+				fmt.Fprint(w, "var lib = 1;")
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		out := t.TempDir()
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: out},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum.Stats.Failed != 0 {
+			t.Errorf("assetFirst=%v: %d failed", assetFirst, sum.Stats.Failed)
+		}
+		root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+		if data, err := os.ReadFile(filepath.Join(root, "lib~file.js")); err != nil || string(data) != "var lib = 1;" {
+			t.Errorf("assetFirst=%v: asset not kept beside the folder: %q %v", assetFirst, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "lib.js", "docs", "index.html")); err != nil {
+			t.Errorf("assetFirst=%v: page missing: %v", assetFirst, err)
+		}
+		// Страница, переписанная после конфликта, ведёт на перенесённый ассет
+		page, _ := os.ReadFile(filepath.Join(root, "usage", "index.html"))
+		if !strings.Contains(string(page), `src="../lib~file.js"`) {
+			t.Errorf("assetFirst=%v: link not remapped:\n%s", assetFirst, page)
+		}
+		// Корень записан до конфликта: его ссылка переписана после переноса
+		if assetFirst {
+			page, _ = os.ReadFile(filepath.Join(root, "index.html"))
+			if !strings.Contains(string(page), `src="./lib~file.js"`) {
+				t.Errorf("Root page not relinked to the moved asset:\n%s", page)
+			}
+		}
+	}
+}
+
+func TestSlashVariantAliasInManifest(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/about":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "About us")
+		case "/about/", "/about/team/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>About us</body></html>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/about">a</a><a href="/about/">b</a><a href="/about/team/">c</a></body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.Failed != 0 {
+		t.Fatalf("Saves must never fail on file/folder conflicts, %d failed", sum.Stats.Failed)
+	}
+
+	entries, err := LoadManifest(strings.TrimSuffix(sum.StateFile, StateFileExtension) + ManifestExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	for _, e := range entries {
+		paths[strings.TrimPrefix(e.URL, srv.URL)] = e.Path
+	}
+	for _, u := range []string{"/about", "/about/"} {
+		if paths[u] != "about/index.html" {
+			t.Errorf("Expected %s at about/index.html, got %q", u, paths[u])
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"), "about")); err != nil || !fi.IsDir() {
+		t.Error("about must be a directory")
+	}
+}
+
+// TestRewriteLinkAgreesWithSavePath: для множества сгенерированных URL ссылка,
+// переписанная LinkRewriterHandlerV2, ведёт ровно в тот файл, куда его сохранил
+// saveFile — и по реестру, и по предсказанию до скачивания.
+func TestRewriteLinkAgreesWithSavePath(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	contentType := func(u *url.URL) string {
+		switch path.Ext(u.Path) {
+		case ".css":
+			return "text/css"
+		case ".js":
+			return "application/javascript"
+		case ".png":
+			return "image/png"
+		case ".xml":
+			return "application/xml"
+		}
+		if strings.HasSuffix(u.Path, "/api/data") {
+			return "application/json"
+		}
+		return "text/html"
+	}
+
+	dirs := []string{"x", "y", "z.d", "a%20b", "2024", "docs"}
+	leaves := []string{"", "page", "page/", "page.html", "index.html", "index.htm", "style.css",
+		"app.js?v=3", "logo.png", "feed.xml", "contact.php", "contact.php?id=2#top",
+		"about", "about/", "api/data", "api/data/", "api/data/more/"}
+	rnd := rand.New(rand.NewSource(1))
+	seen := map[string]bool{}
+	var urls []string
+	add := func(segs []string, leaf string) {
+		p := "/"
+		if len(segs) > 0 {
+			p += strings.Join(segs, "/") + "/"
+		}
+		u := "http://example.com" + p + leaf
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, leaf := range leaves {
+		add(nil, leaf)
+		add([]string{"docs"}, leaf)
+	}
+	for i := 0; i < 400; i++ {
+		segs := make([]string, rnd.Intn(4))
+		for k := range segs {
+			segs[k] = dirs[rnd.Intn(len(dirs))]
+		}
+		add(segs, leaves[rnd.Intn(len(leaves))])
+	}
+	rnd.Shuffle(len(urls), func(a, b int) { urls[a], urls[b] = urls[b], urls[a] })
+
+	// resolve — файл, в который ведёт относительная ссылка со страницы source
+	resolve := func(source, link string) string {
+		lu, err := url.Parse(link)
+		if err != nil {
+			return "!" + link
+		}
+		p := path.Join(path.Dir(source), lu.Path)
+		if strings.HasSuffix(lu.Path, "/") {
+			p = path.Join(p, "index.html")
+		}
+		return p
+	}
+
+	// 1. Предсказание до скачивания совпадает с тем, что сохранит SaveFileV2,
+	// для всего, кроме не-HTML по URL без расширения (его путь даёт реестр)
+	for _, raw := range urls {
+		u, _ := url.Parse(raw)
+		ct := contentType(u)
+		if ct != "text/html" && path.Ext(u.Path) == "" {
+			continue
+		}
+		h := &LinkRewriterHandlerV2{outputDir: t.TempDir(), saved: newSavedPaths()}
+		link := h.rewriteLink(raw, FileMetadata{URL: "http://example.com/docs/guide/", ContentType: "text/html"})
+		saved, err := SaveFileV2(t.TempDir(), raw, []byte("x"), ct)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		if got := resolve("docs/guide/index.html", link); got != saved {
+			t.Errorf("%s: link %q resolves to %q, SaveFileV2 wrote %q", raw, link, got, saved)
+		}
+	}
+
+	// 2. Весь сайт сохранён (с конфликтами файл/папка): каждая ссылка с каждой
+	// страницы ведёт в фактически записанный файл цели
+	out := t.TempDir()
+	j := &Job{saved: newSavedPaths()}
+	h := &LinkRewriterHandlerV2{outputDir: out, saved: j.saved}
+	var pages []string
+	for _, raw := range urls {
+		key, _ := NormalizeURL(raw)
+		if _, ok := j.saved.lookup(key); ok {
+			continue
+		}
+		u, _ := url.Parse(key)
+		ct := contentType(u)
+		rel, moved, err := saveFile(out, key, []byte(key), ct)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		j.saved.record(key, rel)
+		if moved != "" || rel != DiskPath(u, ct) {
+			j.recordLayoutFix(key, rel, moved)
+		}
+		if ct == "text/html" {
+			pages = append(pages, key)
+		}
+	}
+	siteDir := filepath.Join(out, "example.com")
+	for _, page := range pages[:40] {
+		source, _ := j.saved.lookup(page)
+		for _, raw := range urls {
+			key, _ := NormalizeURL(raw)
+			target, _ := j.saved.lookup(key)
+			link := h.rewriteLink(raw, FileMetadata{URL: page, ContentType: "text/html"})
+			if got := resolve(source.Path, link); got != target.Path {
+				t.Errorf("From %s: %s -> %q resolves to %q, saved at %q", page, raw, link, got, target.Path)
+				continue
+			}
+			if fi, err := os.Stat(filepath.Join(siteDir, filepath.FromSlash(target.Path))); err != nil || fi.IsDir() {
+				t.Errorf("%s: saved path %q is not a file", raw, target.Path)
+			}
+		}
+	}
+}
+
+func TestEntryPathFromManifest(t *testing.T) {
+	out := t.TempDir()
+	site := filepath.Join(out, "example.com")
+	os.MkdirAll(filepath.Join(site, "docs", "start"), 0755)
+	os.WriteFile(filepath.Join(site, "docs", "start", "index.html"), []byte("<html></html>"), 0644)
+
+	m, err := openManifest(filepath.Join(out, "abc"+ManifestExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Append(ManifestEntry{URL: "https://example.com/docs/start", Path: "docs/start/index.html", AliasOf: "https://example.com/docs/start/"})
+	m.Append(ManifestEntry{URL: "https://example.com/docs/start/", Path: "docs/start/index.html"})
+	m.Append(ManifestEntry{URL: "https://other.org/", Path: "index.html"})
+	m.Close()
+
+	if got, ok := EntryPath(site); !ok || got != "docs/start/index.html" {
+		t.Errorf("Expected docs/start/index.html, got %q %v", got, ok)
+	}
+	if _, ok := EntryPath(filepath.Join(out, "other.org")); ok {
+		t.Error("Entry of a site without files must not be found")
+	}
+}
+
+func TestLogBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	var skipped int
+	b := NewLogBatcher(50*time.Millisecond, 100, func(lines []string, dropped int) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, lines)
+		skipped += dropped
+	})
+
+	for i := 0; i < 1000; i++ {
+		b.Add(fmt.Sprintf("line %d", i))
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	mu.Lock()
+	if len(batches) != 1 {
+		t.Fatalf("Expected one batch per interval, got %d", len(batches))
+	}
+	if last := batches[0]; len(last) != 100 || last[99] != "line 999" || last[0] != "line 900" || skipped != 900 {
+		t.Errorf("Expected the last 100 lines and 900 skipped, got %d lines (%q..%q), %d skipped", len(last), last[0], last[len(last)-1], skipped)
+	}
+	mu.Unlock()
+
+	b.Add("tail")
+	b.Flush()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || batches[1][0] != "tail" {
+		t.Errorf("Flush must deliver pending lines immediately: %v", batches)
+	}
+}
+
+func TestJobLogFile(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>hi</body></html>`)
+	}))
+	defer srv.Close()
+
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 1, Retries: 1, OutputDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(sum.LogFile)
+	if err != nil {
+		t.Fatalf("Job log not written: %v", err)
+	}
+	if !strings.Contains(string(data), "[Done] Saved: "+srv.URL) {
+		t.Errorf("Job log misses saved files:\n%s", data)
+	}
+}
+
+func TestPauseLetsInFlightFinish(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	var gets sync.Map // путь -> *int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Предварительный обход не должен ходить по страницам
+			w.Header().Set("Content-Type", "text/plain")
+			return
+		}
+		n, _ := gets.LoadOrStore(r.URL.Path, new(int64))
+		atomic.AddInt64(n.(*int64), 1)
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+		case "/a":
+			<-release
+			fmt.Fprint(w, `<html><body>a</body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+	getCount := func(p string) int64 {
+		if n, ok := gets.Load(p); ok {
+			return atomic.LoadInt64(n.(*int64))
+		}
+		return 0
+	}
+
+	dir := t.TempDir()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	started := make(chan *Job, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(context.Background(), RunOptions{
+			URL:     srv.URL + "/",
+			Config:  Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: dir},
+			OnStart: func(j *Job) { started <- j },
+		})
+		done <- err
+	}()
+	job := <-started
+	for getCount("/a") == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Пауза ждёт загрузку /a, а не обрывает её
+	paused := make(chan error, 1)
+	go func() { paused <- job.Pause() }()
+	select {
+	case err := <-paused:
+		t.Fatalf("Pause returned before in-flight download finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-paused; err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if !job.Paused() {
+		t.Fatalf("Job must report paused")
+	}
+	if _, err := os.Stat(filepath.Join(dir, host, "a", "index.html")); err != nil {
+		t.Errorf("In-flight page must be saved before pause: %v", err)
+	}
+	if err := job.Pause(); !errors.Is(err, ErrAlreadyPaused) {
+		t.Errorf("Expected ErrAlreadyPaused, got %v", err)
+	}
+
+	// На паузе новые URL не берутся, а очередь уже в журнале
+	time.Sleep(100 * time.Millisecond)
+	if n := getCount("/b"); n != 0 {
+		t.Fatalf("/b fetched %d times while paused", n)
+	}
+	journal, _ := os.ReadFile(job.journalFile())
+	if !strings.Contains(string(journal), srv.URL+"/b") {
+		t.Errorf("Pending queue not checkpointed on pause:\n%s", journal)
+	}
+
+	if err := job.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, host, "b", "index.html")); err != nil {
+		t.Errorf("/b not downloaded after resume: %v", err)
+	}
+	if n := getCount("/a"); n != 1 {
+		t.Errorf("/a downloaded %d times, want 1", n)
+	}
+	if err := job.Resume(); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Resume after completion must fail, got %v", err)
+	}
+}
+
+func TestCancelDiscardsQueueWithoutLeaks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body>`)
+			for i := 0; i < 20; i++ {
+				fmt.Fprintf(w, `<a href="/p%d">p</a>`, i)
+			}
+			fmt.Fprint(w, `</body></html>`)
+			return
+		}
+		if r.Method == http.MethodGet {
+			<-r.Context().Done() // Страницы висят до отмены
+		}
+	}))
+	defer srv.Close()
+
+	var job *Job
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(context.Background(), RunOptions{
+			URL:     srv.URL + "/",
+			Config:  Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), QueueSize: 4},
+			OnStart: func(j *Job) { job = j; close(started) },
+		})
+		done <- err
+	}()
+	<-started
+	for {
+		if ws := job.WorkerStatus(); len(ws) > 0 && strings.Contains(ws[0].URL, "/p") {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	job.Cancel()
+	job.Cancel() // Повторный вызов безопасен
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Cancel")
+	}
+
+	// Очередь сохранена для resume, а горутина закрытия pending не зависла
+	journal, _ := os.ReadFile(job.journalFile())
+	if !strings.Contains(string(journal), srv.URL+"/p19") {
+		t.Errorf("Pending URLs not saved on cancel:\n%s", journal)
+	}
+	select {
+	case _, ok := <-job.pending:
+		if ok {
+			t.Error("Queue must be drained after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("pending never closed: activeWG still counts discarded URLs")
+	}
+	if _, ok := <-job.Events; ok {
+		t.Error("Events must be closed after Run")
+	}
+	job.Cancel()
+}
+
+func TestMixedSchemeLinksDownloadOnce(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		host := strings.TrimPrefix(srv.URL, "http://")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<html><body><a href="https://%s/docs/">a</a><a href="http://%s/docs/">b</a></body></html>`, host, host)
+		default:
+			fmt.Fprint(w, `<html><body>Docs</body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// https на тестовом сервере не отвечает — канонический протокол http,
+	// и https-ссылка не должна уходить в отдельный (неудачный) запрос
+	if sum.Stats.Failed != 0 || sum.Stats.FileTypes[FileHTML] != 2 {
+		t.Errorf("Expected 2 files and no failures, got %+v", sum.Stats)
+	}
+
+	entries, err := LoadManifest(strings.TrimSuffix(sum.StateFile, StateFileExtension) + ManifestExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.URL, "http://") || e.Scheme != "http" {
+			t.Errorf("Entry must use the canonical scheme: %+v", e)
+		}
+	}
+
+	index, _ := os.ReadFile(filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"), "index.html"))
+	if strings.Contains(string(index), "://") {
+		t.Errorf("Both scheme variants must point to the local copy:\n%s", index)
+	}
+}
+
+func TestCSSImports(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	sheets := map[string]string{
+		"/css/main.css":  `@import "theme.css"; @import url(reset.css); body { background: url('/img/bg.png') }`,
+		"/css/theme.css": `@import '/css/colors.css';`,
+		// Цикл: colors.css импортирует main.css
+		"/css/colors.css": `@IMPORT "main.css" screen; a { color: red }`,
+		"/css/reset.css":  `* { margin: 0 }`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if css, ok := sheets[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, css)
+			return
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/css/main.css"></head></html>`)
+		case "/img/bg.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 5, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.Failed != 0 || sum.Stats.FileTypes[FileCSS] != 4 {
+		t.Errorf("Expected 4 stylesheets and no failures, got %+v", sum.Stats)
+	}
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	for name := range sheets {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not downloaded: %v", name, err)
+		}
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/articles/post.html">post</a></body></html>`)
+		case "/articles/post.html":
+			// Ссылки страницы разрешаются только от базы /blog/
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><base href="/blog/" target="_blank"></head><body>`+
+				`<a href="about.html">about</a><img src="img/photo.png"><a href="#top">top</a></body></html>`)
+		case "/blog/about.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>About</body></html>`)
+		case "/blog/img/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.Failed != 0 {
+		t.Errorf("Links resolved against the page URL instead of <base>: %+v", sum.Stats)
+	}
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	for _, p := range []string{"blog/about.html", "blog/img/photo.png"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("%s not downloaded: %v", p, err)
+		}
+	}
+
+	page, err := os.ReadFile(filepath.Join(root, "articles", "post.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<base target="_blank"/>`, `href="../blog/about.html"`, `src="../blog/img/photo.png"`, `href="../blog/#top"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("Saved page lacks %s:\n%s", want, page)
+		}
+	}
+}
+
+func TestMetaRefresh(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for content, want := range map[string]string{
+		"0;url=/new-page/":       "/new-page/",
+		"5; URL='/x/?a=1' ":      "/x/?a=1",
+		`0 ; Url = "page.html"`:  "page.html",
+		"3,https://example.com/": "https://example.com/",
+		"0; urlaub.html":         "urlaub.html",
+		"30":                     "",
+		"0;url=":                 "",
+	} {
+		before, target, after, ok := SplitRefresh(content)
+		if target != want || ok != (want != "") {
+			t.Errorf("SplitRefresh(%q) = %q, %v; want %q", content, target, ok, want)
+		}
+		if ok && before+target+after != content {
+			t.Errorf("SplitRefresh(%q) parts do not add up: %q %q %q", content, before, target, after)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><head><META HTTP-EQUIV="Refresh" CONTENT="0; URL='/new-page/'"></head></html>`)
+		case "/new-page/":
+			fmt.Fprint(w, `<html><body>Moved here</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.FileTypes[FileHTML] != 2 {
+		t.Errorf("Refresh target not crawled: %+v", sum.Stats)
+	}
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	index, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	if !strings.Contains(string(index), `content="0; URL=&#39;new-page/&#39;"`) {
+		t.Errorf("Refresh target not rewritten to the local copy:\n%s", index)
+	}
+}
+
+func TestRedirectSavedUnderFinalURL(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new/", http.StatusMovedPermanently)
+			return
+		case "/moved":
+			http.Redirect(w, r, "/new/", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/old">old</a><a href="/other/">other</a></body></html>`)
+		case "/new/":
+			// Относительная ссылка — от финального адреса /new/
+			fmt.Fprint(w, `<html><body><a href="about.html">about</a></body></html>`)
+		case "/other/":
+			fmt.Fprint(w, `<html><body><a href="/old">old</a><a href="/moved">moved</a></body></html>`)
+		case "/new/about.html":
+			fmt.Fprint(w, `<html><body>About</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.Failed != 0 || sum.Stats.FileTypes[FileHTML] != 4 {
+		t.Errorf("Expected 4 pages saved once each, got %+v", sum.Stats)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	root := filepath.Join(out, host)
+	for _, p := range []string{"new/index.html", "new/about.html"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("%s not saved: %v", p, err)
+		}
+	}
+	for _, p := range []string{"old", "old.html", "moved", "moved.html"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err == nil {
+			t.Errorf("Redirect source saved as duplicate %s", p)
+		}
+	}
+
+	// Страница, обработанная после редиректа, ссылается на файл /new/
+	other, _ := os.ReadFile(filepath.Join(root, "other", "index.html"))
+	if !strings.Contains(string(other), `href="../new/">old`) {
+		t.Errorf("Links to redirected URLs must point at the final file:\n%s", other)
+	}
+
+	entries, err := LoadManifest(strings.TrimSuffix(sum.StateFile, StateFileExtension) + ManifestExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := 0
+	for _, e := range entries {
+		if strings.HasSuffix(e.URL, "/old") || strings.HasSuffix(e.URL, "/moved") {
+			aliases++
+			if e.Path != "new/index.html" || !strings.HasSuffix(e.AliasOf, "/new/") {
+				t.Errorf("Redirect source must be an alias of the final URL: %+v", e)
+			}
+		}
+	}
+	if aliases != 2 {
+		t.Errorf("Expected 2 redirect aliases in manifest, got %d", aliases)
+	}
+
+	// Карта редиректов переживает продолжение задачи
+	job, err := loadJob(context.Background(), sum.StateFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.events.close()
+	if final, ok := job.redirects.lookup(srv.URL + "/old"); !ok || final != srv.URL+"/new/" {
+		t.Errorf("Redirect map not restored from state: %q %v", final, ok)
+	}
+}
+
+func TestRedirectLimits(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var partnerHits int64
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&partnerHits, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>partner</body></html>`)
+	}))
+	defer partner.Close()
+
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/c":
+			http.Redirect(w, r, "/final/", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, r, "/loop2", http.StatusFound)
+		case "/loop2":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/go/partner":
+			http.Redirect(w, r, partner.URL+"/page", http.StatusFound)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/loop">loop</a><a href="/go/partner">partner</a></body></html>`)
+		case "/final/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>final</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	run := func(cfg Config) (Summary, []string) {
+		t.Helper()
+		var events []string
+		cfg.Workers, cfg.MaxDepth, cfg.Retries, cfg.DeferredRetries, cfg.OutputDir = 1, 3, 3, -1, t.TempDir()
+		sum, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg,
+			OnEvent: func(msg string) { events = append(events, msg) }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum, events
+	}
+	contains := func(events []string, s string) bool {
+		for _, e := range events {
+			if strings.Contains(e, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	sum, events := run(Config{MaxRedirects: 3})
+
+	// Цепочка из трёх 301 — в пределах лимита: каждый шаг записан
+	if !contains(events, "Redirect: "+srv.URL+"/a → "+srv.URL+"/b → "+srv.URL+"/c → "+srv.URL+"/final/") {
+		t.Errorf("Redirect chain with every hop not logged:\n%s", strings.Join(events, "\n"))
+	}
+	job, err := loadJob(context.Background(), sum.StateFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.events.close()
+	for _, hop := range []string{"/a", "/b", "/c"} {
+		if final, ok := job.redirects.lookup(srv.URL + hop); !ok || final != srv.URL+"/final/" {
+			t.Errorf("Hop %s not recorded: %q %v", hop, final, ok)
+		}
+	}
+
+	// Петля обрывается на лимите и не повторяется: 1 запрос + 3 редиректа
+	mu.Lock()
+	loopHits := hits["/loop"] + hits["/loop2"]
+	mu.Unlock()
+	if loopHits != 4 {
+		t.Errorf("Redirect loop made %d requests, want 4 (no retries)", loopHits)
+	}
+	var loop *BrokenLink
+	for i := range sum.BrokenLinks {
+		if sum.BrokenLinks[i].URL == srv.URL+"/loop" {
+			loop = &sum.BrokenLinks[i]
+		}
+	}
+	if loop == nil || !strings.Contains(loop.Error, "too many redirects (4)") || sum.Stats.Permanent != 1 {
+		t.Errorf("Loop must fail permanently with too many redirects: %+v, %d permanent", loop, sum.Stats.Permanent)
+	}
+
+	// Чужой хост по умолчанию не качается
+	if n := atomic.LoadInt64(&partnerHits); n != 0 {
+		t.Errorf("Off-site redirect target requested %d times", n)
+	}
+	if !contains(events, srv.URL+"/go/partner: redirected off-site to "+partner.URL+"/page") {
+		t.Errorf("Off-site redirect not recorded:\n%s", strings.Join(events, "\n"))
+	}
+
+	// FollowExternalRedirects — как раньше: ответ чужого хоста сохраняется
+	// под исходным URL
+	sum, _ = run(Config{MaxRedirects: 3, FollowExternalRedirects: true})
+	if n := atomic.LoadInt64(&partnerHits); n != 1 {
+		t.Errorf("Off-site redirect target requested %d times, want 1", n)
+	}
+	if data, err := os.ReadFile(filepath.Join(filepath.Dir(sum.StateFile), strings.TrimPrefix(srv.URL, "http://"), "go", "partner", "index.html")); err != nil || !strings.Contains(string(data), "partner") {
+		t.Errorf("Followed off-site page not saved under the source URL: %v", err)
+	}
+
+	// Без задачи с сайта уводит редирект на другой хост
+	d := NewDownloader(Config{Retries: 3, MaxRedirects: 1})
+	_, _, _, err = d.Fetch(context.Background(), srv.URL+"/go/partner")
+	var redirect *RedirectError
+	if !errors.As(err, &redirect) || !redirect.Offsite || !IsPermanent(err) {
+		t.Errorf("Expected a permanent off-site RedirectError, got %v", err)
+	}
+	_, _, _, err = d.Fetch(context.Background(), srv.URL+"/a")
+	if !errors.As(err, &redirect) || redirect.Offsite || len(redirect.Hops) != 2 || redirect.Target != srv.URL+"/c" {
+		t.Errorf("Expected the chain to stop after 1 redirect, got %v", err)
+	}
+}
+
+func TestContentDispositionFilename(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/download?id=123">Report</a><a href="/files/get/#top">Manual</a><a href="/export">Export</a><a href="/files/a.pdf">A</a></body></html>`)
+		case "/download":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+			fmt.Fprint(w, "%PDF-1.4 report")
+		case "/files/get/":
+			// Путь в имени не выводит из папки адреса
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="..\..\manual.pdf"`)
+			fmt.Fprint(w, "%PDF-1.4 manual")
+		case "/export":
+			// Имя HTML не меняет стратегию страницы
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Disposition", `inline; filename="export.html"`)
+			fmt.Fprint(w, `<html><body>export</body></html>`)
+		case "/files/a.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="other.pdf"`)
+			fmt.Fprint(w, "%PDF-1.4 a")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{URL: srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, DeferredRetries: -1, OutputDir: out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL)
+	site := filepath.Join(out, u.Host)
+
+	for rel, want := range map[string]string{
+		"report.pdf":           "%PDF-1.4 report",
+		"files/get/manual.pdf": "%PDF-1.4 manual",
+		"files/a.pdf":          "%PDF-1.4 a",
+		"export/index.html":    "export",
+	} {
+		data, err := os.ReadFile(filepath.Join(site, filepath.FromSlash(rel)))
+		if err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("%s: got %q, %v; want %q", rel, data, err, want)
+		}
+	}
+	for _, rel := range []string{"download/index.html", "download", "files/get/index.html", "files/other.pdf", "export.html"} {
+		if _, err := os.Stat(filepath.Join(site, filepath.FromSlash(rel))); err == nil {
+			t.Errorf("%s saved, want the attachment name instead", rel)
+		}
+	}
+
+	// Главная переписана до скачивания вложений — ссылки поправлены на месте
+	index, err := os.ReadFile(filepath.Join(site, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, href := range []string{`href="./report.pdf?id=123"`, `href="./files/get/manual.pdf#top"`, `href="./export/"`, `href="./files/a.pdf"`} {
+		if !strings.Contains(string(index), href) {
+			t.Errorf("index.html has no %s:\n%s", href, index)
+		}
+	}
+	if report, err := VerifySite(site); err != nil || report.Problems() != 0 {
+		t.Errorf("VerifySite = %+v, %v; want no broken links", report.Pages, err)
+	}
+
+	entries, err := LoadManifest(strings.TrimSuffix(sum.StateFile, StateFileExtension) + ManifestExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range entries {
+		if e.URL == srv.URL+"/download?id=123" {
+			found = true
+			if e.Path != "report.pdf" || e.Strategy != StrategyFile {
+				t.Errorf("manifest: %s at %s (%s), want report.pdf (%s)", e.URL, e.Path, e.Strategy, StrategyFile)
+			}
+		}
+	}
+	if !found {
+		t.Error("manifest has no /download?id=123")
+	}
+}
+
+func TestSavedPathsClaim(t *testing.T) {
+	s := newSavedPaths()
+
+	// Одно имя вложения у нескольких воркеров сразу — путь достаётся одному
+	var wg sync.WaitGroup
+	var won int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if s.claim(fmt.Sprintf("https://example.com/download?id=%d", i), "report.pdf") {
+				atomic.AddInt32(&won, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("%d workers claimed report.pdf, want 1", won)
+	}
+	owner, _ := s.owner("report.pdf")
+	if !s.claim(owner, "report.pdf") {
+		t.Error("Owner must keep its claim")
+	}
+
+	// Индекс путей следует за record и repath
+	s.record("https://example.com/a", "a")
+	s.record("https://example.com/a/", "a")
+	if moved := s.repath("a", "a~file"); len(moved) != 2 {
+		t.Errorf("repath moved %v, want both URLs", moved)
+	}
+	if u, ok := s.owner("a~file"); !ok || u != "https://example.com/a" {
+		t.Errorf("owner(a~file) = %q, %v", u, ok)
+	}
+	s.record("https://example.com/a", "a/index.html")
+	if u, _ := s.owner("a~file"); u != "https://example.com/a/" {
+		t.Errorf("Re-recorded URL must leave its old path, owner = %q", u)
+	}
+	if _, ok := s.owner("a"); ok {
+		t.Error("Moved path must have no owner")
+	}
+}
+
+func TestDedupeContent(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><img src="/logo.png"><a href="/copy/">copy</a></body></html>`)
+		case "/copy/", "/later/":
+			// Одинаковые страницы по разным URL — копии остаются
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><img src="/img/logo.png?v=2"><a href="/later/">later</a></body></html>`)
+		case "/logo.png", "/img/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("same png bytes"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, dedupe := range []bool{false, true} {
+		out := t.TempDir()
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: out, DedupeContent: dedupe},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDup, wantImages := int64(0), int64(2)
+		if dedupe {
+			wantDup, wantImages = 1, 1
+		}
+		if sum.Stats.Deduplicated != wantDup || sum.Stats.FileTypes[FileImage] != wantImages || sum.Stats.FileTypes[FileHTML] != 3 {
+			t.Errorf("dedupe=%v: unexpected stats %+v", dedupe, sum.Stats)
+		}
+
+		// Страница /copy/ переписана до повтора и ссылается на img/logo.png:
+		// там жёсткая ссылка на первый файл, а не вторая копия
+		root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+		first, err1 := os.Stat(filepath.Join(root, "logo.png"))
+		second, err2 := os.Stat(filepath.Join(root, "img", "logo.png"))
+		if err1 != nil || err2 != nil {
+			t.Fatalf("dedupe=%v: files missing: %v %v", dedupe, err1, err2)
+		}
+		if os.SameFile(first, second) != dedupe {
+			t.Errorf("dedupe=%v: second URL shares the first file=%v", dedupe, !dedupe)
+		}
+		if !dedupe {
+			continue
+		}
+		// Страница после повтора ссылается прямо на первый файл
+		page, _ := os.ReadFile(filepath.Join(root, "later", "index.html"))
+		if !strings.Contains(string(page), `src="../logo.png?v=2"`) {
+			t.Errorf("Duplicate URL must point at the first file:\n%s", page)
+		}
+	}
+}
+
+func TestDedupeStylesheetsPerDirectory(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/a/s.css"><link rel="stylesheet" href="/a/copy.css"><link rel="stylesheet" href="/b/s.css"></head></html>`)
+		case "/a/s.css", "/a/copy.css", "/b/s.css":
+			// Одинаковые байты, но url() считается от папки файла
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body{background:url(bg.png)}`)
+		case "/a/bg.png", "/b/bg.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: out, DedupeContent: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.Deduplicated != 1 {
+		t.Errorf("Only the copy in the same folder may be deduplicated, got %d", sum.Stats.Deduplicated)
+	}
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	a, err1 := os.Stat(filepath.Join(root, "a", "s.css"))
+	b, err2 := os.Stat(filepath.Join(root, "b", "s.css"))
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Stylesheets missing: %v %v", err1, err2)
+	}
+	if os.SameFile(a, b) {
+		t.Error("Stylesheet in another folder must keep its own copy")
+	}
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	if !strings.Contains(string(page), `href="./b/s.css"`) || !strings.Contains(string(page), `href="./a/s.css"`) {
+		t.Errorf("Each stylesheet must keep its own link:\n%s", page)
+	}
+}
+
+func TestAssetQueries(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	long := strings.Repeat("x", 100)
+	var hits sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head>
+<link rel="stylesheet" href="/font.css?lang=en">
+<link rel="stylesheet" href="/font.css?lang=ru">
+<script src="/app.js?v=1"></script><script src="/app.js?v=2"></script>
+<script src="/big.js?q=`+long+`"></script>
+</head><body></body></html>`)
+		case "/font.css", "/app.js", "/big.js":
+			n, _ := hits.LoadOrStore(r.URL.Path, new(int32))
+			atomic.AddInt32(n.(*int32), 1)
+			if r.URL.Path == "/font.css" {
+				w.Header().Set("Content-Type", "text/css")
+				fmt.Fprint(w, "/* "+r.URL.Query().Get("lang")+" */")
+				return
+			}
+			w.Header().Set("Content-Type", "application/javascript")
+			fmt.Fprint(w, "var x = 1;")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, mode := range []string{"", AssetQueryIgnore} {
+		hits.Range(func(k, _ any) bool { hits.Delete(k); return true })
+		out := t.TempDir()
+		_, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: out, AssetQueries: mode},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+		page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+		count := func(p string) int32 {
+			if n, ok := hits.Load(p); ok {
+				return atomic.LoadInt32(n.(*int32))
+			}
+			return 0
+		}
+		// Cache buster'ы не порождают повторных загрузок ни в одном режиме
+		if count("/app.js") != 1 {
+			t.Errorf("mode %q: app.js fetched %d times", mode, count("/app.js"))
+		}
+
+		if mode == AssetQueryIgnore {
+			if count("/font.css") != 1 {
+				t.Errorf("ignore: font.css fetched %d times", count("/font.css"))
+			}
+			if _, err := os.Stat(filepath.Join(root, "font.css")); err != nil {
+				t.Errorf("ignore: font.css missing: %v", err)
+			}
+			continue
+		}
+
+		// Значимый query — отдельные файлы, ссылки ведут на каждый
+		for _, lang := range []string{"en", "ru"} {
+			name := "font.css@lang=" + lang + ".css"
+			data, err := os.ReadFile(filepath.Join(root, name))
+			if err != nil || !strings.Contains(string(data), lang) {
+				t.Errorf("%s: %q, %v", name, data, err)
+			}
+			if !strings.Contains(string(page), `href="./`+name+`"`) {
+				t.Errorf("Link to %s not rewritten:\n%s", name, page)
+			}
+		}
+		// Длинный query заменяется хешем фиксированной длины
+		matches, _ := filepath.Glob(filepath.Join(root, "big.js@*.js"))
+		if len(matches) != 1 || len(filepath.Base(matches[0])) != len("big.js@")+16+len(".js") {
+			t.Errorf("Long query not hashed: %v", matches)
+		}
+	}
+}
+
+func TestRobotsRules(t *testing.T) {
+	rules := parseRobots([]byte(`
+User-agent: Googlebot
+Disallow: /
+
+User-agent: *
+Disallow: /private/
+Allow: /private/ok
+Disallow: /*.pdf$
+Crawl-delay: 0.5
+`), DefaultUserAgent)
+
+	for path, want := range map[string]bool{
+		"/":               true,
+		"/private/x":      false,
+		"/private/ok.htm": true,
+		"/docs/a.pdf":     false,
+		"/docs/a.pdf?v=1": true,
+	} {
+		if got := rules.allowed(path); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", path, got, want)
+		}
+	}
+	if rules.crawlDelay != 500*time.Millisecond {
+		t.Errorf("Crawl-delay = %s", rules.crawlDelay)
+	}
+}
+
+func TestRobotsServerErrorAndCrawlDelayHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// 5xx — сайт закрыт целиком, а не открыт
+	root, _ := url.Parse(srv.URL + "/")
+	rules, err := fetchRobots(context.Background(), Config{Retries: 1}, root)
+	if err == nil || rules.allowed("/") || rules.allowed("/page") {
+		t.Errorf("5xx robots.txt must disallow everything (err=%v)", err)
+	}
+
+	// Crawl-delay действует только на хост сайта: CDN не ждёт
+	d := NewDownloader(Config{Retries: 1})
+	d.setCrawlDelay("site.example", time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err := d.waitCrawlDelay(ctx, "cdn.example"); err != nil {
+			t.Fatalf("Crawl-delay applied to another host: %v", err)
+		}
+	}
+	d.waitCrawlDelay(ctx, "site.example")
+	if err := d.waitCrawlDelay(ctx, "site.example"); err == nil {
+		t.Error("Crawl-delay must apply to the site host")
+	}
+}
+
+func TestRespectRobots(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, hasRobots := range []bool{true, false} {
+		var hits sync.Map
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Store(r.URL.Path, true)
+			switch r.URL.Path {
+			case "/robots.txt":
+				if !hasRobots {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+			case "/":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body><a href="/private/secret">s</a><a href="/public">p</a></body></html>`)
+			default:
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body>page</body></html>`)
+			}
+		}))
+
+		var logged atomic.Bool
+		_, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), RespectRobots: true},
+			OnEvent: func(msg string) {
+				if strings.Contains(msg, "/private/secret: "+ReasonRobots) {
+					logged.Store(true)
+				}
+			},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, requested := hits.Load("/private/secret")
+		if _, ok := hits.Load("/public"); !ok {
+			t.Errorf("robots=%v: /public must be downloaded", hasRobots)
+		}
+		if hasRobots && (requested || !logged.Load()) {
+			t.Errorf("Disallowed URL must be skipped and logged (requested=%v, logged=%v)", requested, logged.Load())
+		}
+		if !hasRobots && !requested {
+			t.Error("Without robots.txt the crawl must behave as before")
+		}
+	}
+}
+
+func TestSitemapCoverageAndTargetedRecrawl(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%[1]s/</loc></url><url><loc>%[1]s/about</loc></url><url><loc>%[1]s/orphan</loc></url><url><loc>%[1]s/gone</loc></url>
+</urlset>`, srv.URL)
+		case "/gone", "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/about/">a</a><a href="/gone">g</a><a href="/extra">e</a></body></html>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	cfg := Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out, DeferredRetries: -1}
+	sum, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cov := sum.Coverage
+	if cov == nil {
+		t.Fatal("Coverage report missing")
+	}
+	if cov.Listed != 4 || cov.Downloaded != 2 || cov.Percent != 50 {
+		t.Errorf("Unexpected coverage: %+v", cov)
+	}
+	reasons := map[string]string{}
+	for _, m := range cov.Missed {
+		reasons[strings.TrimPrefix(m.URL, srv.URL)] = m.Reason
+	}
+	if reasons["/orphan"] != ReasonNotLinked || !strings.Contains(reasons["/gone"], "404") {
+		t.Errorf("Unexpected missed pages: %+v", cov.Missed)
+	}
+	if len(cov.Unlisted) != 1 || cov.Unlisted[0] != srv.URL+"/extra" {
+		t.Errorf("Unexpected unlisted pages: %v", cov.Unlisted)
+	}
+	onDisk, err := LoadCoverage(strings.TrimSuffix(sum.StateFile, StateFileExtension) + CoverageExtension)
+	if err != nil || onDisk.Listed != cov.Listed {
+		t.Fatalf("coverage.json not written: %v", err)
+	}
+
+	// «Скачать и эти»: докачиваются только пропущенные страницы
+	var missed []string
+	for _, m := range cov.Missed {
+		missed = append(missed, m.URL)
+	}
+	sum, err = Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg, Targets: missed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Coverage == nil || sum.Coverage.Downloaded != 3 || len(sum.Coverage.Missed) != 1 {
+		t.Errorf("Targeted re-crawl must fetch the orphan page: %+v", sum.Coverage)
+	}
+
+	// Докачка к прерванному обходу: очередь прошлого запуска обходится как
+	// обычно, не обходятся только ссылки самих целей
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	hits := map[string]int{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+		case "/a":
+			cancel()
+			fmt.Fprint(w, `<html><body>a</body></html>`)
+		case "/b":
+			fmt.Fprint(w, `<html><body><a href="/c">c</a></body></html>`)
+		case "/orphan":
+			fmt.Fprint(w, `<html><body><a href="/from-target">x</a></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer site.Close()
+	cfg.OutputDir = t.TempDir()
+	cfg.Workers = 1
+	if _, err := Run(ctx, RunOptions{URL: site.URL + "/", Config: cfg}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the first run to be canceled, got %v", err)
+	}
+	if _, err := Run(context.Background(), RunOptions{URL: site.URL + "/", Config: cfg, Targets: []string{site.URL + "/orphan"}}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/orphan"] != 1 || hits["/c"] != 1 || hits["/from-target"] != 0 {
+		t.Errorf("Interrupted queue must be crawled with links, targets without: %v", hits)
+	}
+}
+
+func TestDeterministicRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	a, b := NewDownloader(Config{Deterministic: true, Seed: 7}), NewDownloader(Config{Deterministic: true, Seed: 7})
+	for i := 0; i < 5; i++ {
+		if pa, pb := a.retryPause(i+1), b.retryPause(i+1); pa != pb {
+			t.Fatalf("Retry jitter must repeat for the same seed: %v != %v", pa, pb)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/c">c</a><a href="/a">a</a><a href="/b">b</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	crawl := func() []byte {
+		sum, err := Run(context.Background(), RunOptions{
+			URL: srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), DeferredRetries: -1,
+				Deterministic: true, Clock: func() time.Time { return clock }},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.Stats.StartTime.Equal(clock) {
+			t.Errorf("StartTime must come from the clock: %v", sum.Stats.StartTime)
+		}
+		data, err := os.ReadFile(strings.TrimSuffix(sum.StateFile, StateFileExtension) + ManifestExtension)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	first := crawl()
+	if second := crawl(); !bytes.Equal(first, second) {
+		t.Errorf("Manifests differ between runs:\n%s\n---\n%s", first, second)
+	}
+	var order []string
+	for _, line := range strings.Split(strings.TrimSpace(string(first)), "\n") {
+		var e ManifestEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if !e.SavedAt.Equal(clock) {
+			t.Errorf("SavedAt must come from the clock: %+v", e)
+		}
+		order = append(order, strings.TrimPrefix(e.URL, srv.URL))
+	}
+	if strings.Join(order, " ") != "/ /a /b /c" {
+		t.Errorf("Links must be queued in sorted order, got %v", order)
+	}
+}
+
+func TestFileCategory(t *testing.T) {
+	cases := []struct{ url, ct, want string }{
+		{"https://a.com/", "text/html; charset=utf-8", FileHTML},
+		{"https://a.com/s.css", "text/css", FileCSS},
+		{"https://a.com/app.js", "application/javascript", FileJS},
+		{"https://a.com/logo.svg", "image/svg+xml", FileImage},
+		{"https://a.com/f.woff2", "font/woff2", FileFont},
+		{"https://a.com/f.ttf", "application/octet-stream", FileFont},
+		{"https://a.com/clip.mp4", "", FileVideo},
+		{"https://a.com/data.bin", "application/octet-stream", FileOther},
+	}
+	for _, c := range cases {
+		if got := fileCategory(c.url, c.ct); got != c.want {
+			t.Errorf("fileCategory(%s, %q) = %s, want %s", c.url, c.ct, got, c.want)
+		}
+	}
+
+	line := FormatFileTypes(map[string]int64{FileCSS: 14, FileHTML: 120, FileImage: 540})
+	if line != "HTML: 120, images: 540, CSS: 14" {
+		t.Errorf("Unexpected breakdown %q", line)
+	}
+}
+
+func TestBandwidthLimitSharedAcrossWorkers(t *testing.T) {
+	const rate = 64 << 10
+	d := NewDownloader(Config{Retries: 1, MaxFileSize: 1 << 20, MaxBytesPerSecond: rate})
+	if newBandwidthLimiter(0) != nil {
+		t.Fatal("Zero limit must mean unlimited")
+	}
+
+	// Запас к таймауту — по размеру ответа: 1 MiB на 64 KiB/s — 16 с
+	// одному воркеру, а четырём, делящим лимит, — вчетверо больше.
+	// Большой лимит для видео страницам запаса не добавляет.
+	cfg := Config{MaxFileSize: 1 << 20, MaxBytesPerSecond: rate,
+		MaxFileSizeByType: map[string]int64{"video": 200 << 20}}
+	if got := throttleAllowance(cfg, 1<<20); got != 16*time.Second {
+		t.Errorf("Allowance for one worker = %v, want 16s", got)
+	}
+	cfg.Workers = 4
+	if got := throttleAllowance(cfg, 1<<20); got != 64*time.Second {
+		t.Errorf("Allowance for 4 workers = %v, want 64s", got)
+	}
+	if got := throttleAllowance(cfg, 16<<10); got != time.Second {
+		t.Errorf("Allowance for a 16 KiB page = %v, want 1s", got)
+	}
+
+	// Две «загрузки» по 48 KiB через общий лимит: сверх запаса в 64 KiB
+	// остаётся 32 KiB — полсекунды ожидания на всех, а не на каждую
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := d.bandwidth.throttle(context.Background(), bytes.NewReader(make([]byte, 48<<10)))
+			if n, err := io.Copy(io.Discard, r); err != nil || n != 48<<10 {
+				t.Errorf("Read %d bytes: %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Aggregate throughput not capped: 96 KiB at %d B/s took %v", rate, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := d.bandwidth.throttle(ctx, bytes.NewReader(make([]byte, 256<<10)))
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.Canceled) {
+		t.Errorf("Throttled read must stop on cancel, got %v", err)
+	}
+}
+
+type backoffRecorder struct {
+	recordingListener
+	mu     sync.Mutex
+	events []BackoffEvent
+}
+
+func (b *backoffRecorder) OnBackoff(e BackoffEvent) {
+	b.mu.Lock()
+	b.events = append(b.events, e)
+	b.mu.Unlock()
+}
+
+func TestBackoffOnThrottling(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d, ok := parseRetryAfter("7", now); !ok || d != 7*time.Second {
+		t.Errorf("Seconds form: %v %v", d, ok)
+	}
+	if d, ok := parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now); !ok || d != 90*time.Second {
+		t.Errorf("HTTP-date form: %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Error("Garbage Retry-After must be ignored")
+	}
+
+	d := NewDownloader(Config{Retries: 10, Delay: 10 * time.Second})
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 5: MaxBackoff} {
+		if got, _ := d.backoffDelay(attempt, http.Header{}); got != want {
+			t.Errorf("Attempt %d: backoff %v, want %v", attempt, got, want)
+		}
+	}
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<html><body><a href="/slow">slow</a></body></html>`)
+		case r.URL.Path == "/slow" && r.Method == http.MethodGet && atomic.AddInt32(&hits, 1) == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, `<html><body>ok</body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	rec := &backoffRecorder{}
+	start := time.Now()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:     srv.URL + "/",
+		Config:  Config{Workers: 1, MaxDepth: 2, Retries: 2, OutputDir: t.TempDir(), DeferredRetries: -1},
+		OnStart: func(j *Job) { j.Subscribe(rec) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("Retry-After was not honored")
+	}
+	if sum.Stats.FileTypes[FileHTML] != 2 {
+		t.Errorf("Page must be saved after the pause: %+v", sum.Stats)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.events) != 1 || rec.events[0].Status != http.StatusTooManyRequests || !rec.events[0].RetryAfter || rec.events[0].Wait != time.Second {
+		t.Errorf("Unexpected backoff events: %+v", rec.events)
+	}
+}
+
+func TestCookieSession(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	now := time.Unix(1700000000, 0)
+	txt := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tTRUE\t1800000000\tsid\tabc\n" +
+		"#HttpOnly_example.com\tFALSE\t/app\tFALSE\t0\ttoken\txyz\n" +
+		"broken line without tabs\n" +
+		"example.com\tFALSE\t/\tFALSE\t1600000000\told\tgone\n" +
+		"example.com\tFALSE\t/\tFALSE\tnever\tbad\texp\n"
+	cookies, warnings := parseCookiesTxt(txt, now)
+	if len(cookies) != 2 || len(warnings) != 2 {
+		t.Fatalf("Expected 2 cookies and 2 warnings, got %d and %v", len(cookies), warnings)
+	}
+	if c := cookies[0]; c.host != "example.com" || c.cookie.Domain != ".example.com" || !c.cookie.Secure {
+		t.Errorf("Unexpected domain cookie: %+v %+v", c, c.cookie)
+	}
+	if c := cookies[1]; c.cookie.Domain != "" || !c.cookie.HttpOnly || c.cookie.Path != "/app" {
+		t.Errorf("Unexpected host-only cookie: %+v", c.cookie)
+	}
+
+	// Страницы отдаются только с сессией; cookie, выданная первым ответом,
+	// должна доходить до остальных воркеров
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			http.Error(w, "login required", http.StatusForbidden)
+			return
+		}
+		if c, err := r.Cookie("remember"); err != nil || c.Value != "me" {
+			http.Error(w, "no cookie from file", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1", Path: "/"})
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+			return
+		}
+		if _, err := r.Cookie("seen"); err != nil {
+			http.Error(w, "session cookie lost", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+
+	host := strings.Split(strings.TrimPrefix(srv.URL, "http://"), ":")[0]
+	cookieFile := filepath.Join(t.TempDir(), "cookies.txt")
+	os.WriteFile(cookieFile, []byte(host+"\tFALSE\t/\tFALSE\t0\tremember\tme\nnot a cookie\n"), 0644)
+
+	sum, err := Run(context.Background(), RunOptions{
+		URL: srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), DeferredRetries: -1,
+			Cookies: []string{"session=abc"}, CookieFile: cookieFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.FileTypes[FileHTML] != 3 {
+		t.Errorf("Expected 3 pages behind the login, got %+v", sum.Stats)
+	}
+}
+
+func TestURLPatterns(t *testing.T) {
+	root, _ := url.Parse("https://example.com/")
+	f, err := newURLFilter(root, Config{
+		IncludePatterns: []string{"/docs/**", "/blog/*"},
+		ExcludePatterns: []string{"/docs/old/**", `re:[?&]page=\d+`, "*.zip", "/tag/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		url    string
+		want   bool
+		reason string
+	}{
+		{"https://example.com/docs/", true, ""},
+		{"https://example.com/docs/a/b/c", true, ""},
+		{"https://example.com/blog/post", true, ""},
+		{"https://example.com/blog/post/", true, ""},
+		{"https://example.com/blog/2024/post", false, "not matched by include patterns"},
+		{"https://example.com/blog/2024/post/", false, "not matched by include patterns"},
+		{"https://example.com/about", false, "not matched by include patterns"},
+		{"https://example.com/tag/go", false, `excluded by pattern "/tag/*"`},
+		{"https://example.com/tag/go/", false, `excluded by pattern "/tag/*"`},
+		{"https://example.com/static/site.css", true, ""}, // Ассеты — вне включений
+		{"https://example.com/docs/old/v1", false, `excluded by pattern "/docs/old/**"`},
+		{"https://example.com/docs/list?page=2", false, `excluded by pattern "re:[?&]page=\\d+"`},
+		{"https://example.com/docs/files/dump.zip", false, `excluded by pattern "*.zip"`},
+	}
+	for _, c := range cases {
+		if got := f.ShouldDownload(c.url); got != c.want {
+			t.Errorf("ShouldDownload(%s) = %v, want %v", c.url, got, c.want)
+		}
+		if !c.want {
+			if r := f.FilterReason(c.url); r != c.reason {
+				t.Errorf("FilterReason(%s) = %q, want %q", c.url, r, c.reason)
+			}
+		}
+	}
+
+	if _, err := newURLFilter(root, Config{ExcludePatterns: []string{"re:("}}); err == nil {
+		t.Error("Invalid regexp must be reported")
+	}
+	if !regexp.MustCompile(globRegexp("/docs/**/index.html")).MatchString("/docs/index.html") {
+		t.Error("**/ must also match zero directories")
+	}
+}
+
+type budgetRecorder struct {
+	recordingListener
+	mu     sync.Mutex
+	events []BudgetEvent
+}
+
+func (b *budgetRecorder) OnBudgetReached(e BudgetEvent) {
+	b.mu.Lock()
+	b.events = append(b.events, e)
+	b.mu.Unlock()
+}
+
+func TestCrawlBudget(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/p1">1</a><a href="/p2">2</a><a href="/p3">3</a><a href="/p4">4</a><a href="/p5">5</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	dir := t.TempDir()
+	rec := &budgetRecorder{}
+	sum, err := Run(context.Background(), RunOptions{
+		URL:     srv.URL + "/",
+		Config:  Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: dir, MaxPages: 3},
+		OnStart: func(j *Job) { j.Subscribe(rec) },
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if sum.Budget == nil || sum.Budget.Limit != BudgetPages || !sum.Canceled {
+		t.Fatalf("Expected stop by page budget, got %+v", sum)
+	}
+	// Загрузки, начатые до срабатывания лимита, докачиваются: перебор не больше числа воркеров
+	if n := sum.Budget.Pages; n < 3 || n > 4 {
+		t.Errorf("Expected 3-4 files within budget, got %d", n)
+	}
+	rec.mu.Lock()
+	if len(rec.events) != 1 {
+		t.Errorf("Expected one budget event, got %d", len(rec.events))
+	}
+	rec.mu.Unlock()
+
+	// Лимит побольше — задача докачивает остальное из сохранённой очереди
+	sum, err = Resume(context.Background(), sum.StateFile, ResumeOptions{
+		Override: func(c *Config) { c.MaxPages = 100 },
+	})
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if sum.Budget != nil || sum.Stats.FileTypes[FileHTML] != 6 {
+		t.Errorf("Expected full crawl after resume, got budget %v, %d files", sum.Budget, sum.Stats.FileTypes[FileHTML])
+	}
+	for _, p := range []string{"p1", "p2", "p3", "p4", "p5"} {
+		if _, err := os.Stat(filepath.Join(dir, host, p, "index.html")); err != nil {
+			t.Errorf("%s not downloaded after resume: %v", p, err)
+		}
+	}
+}
+
+func TestExternalAssets(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/css/app.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { font-family: F; } @font-face { src: url(../fonts/f.woff2); }`)
+		case "/fonts/f.woff2":
+			w.Header().Set("Content-Type", "font/woff2")
+			fmt.Fprint(w, "wOF2")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>cdn page</body></html>`)
+		}
+	}))
+	defer cdn.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><link rel="stylesheet" href="%[1]s/css/app.css"></head>`+
+			`<body><a href="%[1]s/about">cdn</a></body></html>`, cdn.URL)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cdnHost := strings.TrimPrefix(cdn.URL, "http://")
+
+	dir := t.TempDir()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir, DownloadExternalAssets: true},
+	}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	for _, p := range []string{"css/app.css", "fonts/f.woff2"} {
+		if _, err := os.Stat(filepath.Join(dir, cdnHost, p)); err != nil {
+			t.Errorf("CDN asset %s not saved next to the site: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, cdnHost, "about", "index.html")); err == nil {
+		t.Error("External HTML page must not be saved")
+	}
+	page, _ := os.ReadFile(filepath.Join(dir, host, "index.html"))
+	if !strings.Contains(string(page), `href="../`+cdnHost+`/css/app.css"`) {
+		t.Errorf("CDN stylesheet not rewritten to the local copy:\n%s", page)
+	}
+	if !strings.Contains(string(page), `href="`+cdn.URL+`/about"`) {
+		t.Errorf("Link to an external page must stay absolute:\n%s", page)
+	}
+
+	// Выключено — с CDN ничего не качается
+	dir = t.TempDir()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir},
+	}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cdnHost)); err == nil {
+		t.Error("CDN assets downloaded without DownloadExternalAssets")
+	}
+
+	// Белый список: с хостов не из ExtraDomains ничего не берём
+	f, err := newURLFilter(&url.URL{Scheme: "https", Host: "example.com", Path: "/"},
+		Config{DownloadExternalAssets: true, ExtraDomains: []string{"cdn.example.net"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for u, want := range map[string]bool{
+		"https://cdn.example.net/app.css":           true,
+		"https://static.cdn.example.net/app.js":     true,
+		"https://fonts.example.org/font.woff2":      false,
+		"https://cdn.example.net/css?family=Roboto": true,
+		"https://example.com/page":                  true,
+	} {
+		if got := f.ShouldDownload(u); got != want {
+			t.Errorf("ShouldDownload(%s) = %v, want %v", u, got, want)
+		}
+	}
+}
+
+func TestSubdomainVariants(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		host, _, _ := net.SplitHostPort(r.Host)
+		switch host + r.URL.Path {
+		case "example.test/":
+			fmt.Fprintf(w, `<html><body><a href="http://www.%s/about">about</a><a href="http://blog.%[1]s/">blog</a></body></html>`, r.Host)
+		case "www.example.test/about":
+			fmt.Fprintf(w, `<html><body><a href="http://%s/">home</a><img src="/logo.png"></body></html>`, strings.TrimPrefix(r.Host, "www."))
+		case "www.example.test/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		default:
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	// Все хосты *.example.test ведут на тестовый сервер
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	run := func(subdomains bool) string {
+		dir := t.TempDir()
+		_, err := Run(context.Background(), RunOptions{
+			URL:    "http://example.test:" + port + "/",
+			Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir, IncludeSubdomains: subdomains},
+			OnStart: func(j *Job) {
+				j.Downloader.client.Transport.(*http.Transport).DialContext = dial
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return dir
+	}
+
+	dir := run(false)
+	root, www := "example.test:"+port, "www.example.test:"+port
+	for _, p := range []string{www + "/about/index.html", www + "/logo.png"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+			t.Errorf("www variant not downloaded into its own folder: %s: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blog.example.test:"+port)); err == nil {
+		t.Error("Subdomain downloaded without IncludeSubdomains")
+	}
+	index, _ := os.ReadFile(filepath.Join(dir, root, "index.html"))
+	if !strings.Contains(string(index), `href="../`+www+`/about/"`) {
+		t.Errorf("Link to the www variant not rewritten:\n%s", index)
+	}
+	about, _ := os.ReadFile(filepath.Join(dir, www, "about", "index.html"))
+	if !strings.Contains(string(about), `href="../../`+root+`/"`) || !strings.Contains(string(about), `src="../logo.png"`) {
+		t.Errorf("Links from the www page not mapped back to the right folders:\n%s", about)
+	}
+
+	dir = run(true)
+	if _, err := os.Stat(filepath.Join(dir, "blog.example.test:"+port, "index.html")); err != nil {
+		t.Errorf("Subdomain not downloaded with IncludeSubdomains: %v", err)
+	}
+
+	hosts := newSiteHosts("example.com", Config{})
+	for host, want := range map[string]bool{
+		"example.com": true, "www.example.com": true, "WWW.Example.com": true,
+		"blog.example.com": false, "example.com:8080": false, "notexample.com": false,
+	} {
+		if got := hosts.contains(host); got != want {
+			t.Errorf("contains(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestManagerLimitsRunningJobs(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/slow">slow</a></body></html>`)
+			return
+		}
+		<-release
+		fmt.Fprint(w, `<html><body>slow</body></html>`)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body>fast</body></html>`)
+	}))
+	defer fast.Close()
+
+	dir := t.TempDir()
+	cfg := Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: dir}
+	m := NewManager(1)
+	done := make(chan string, 3)
+	onDone := func(name string) func(Summary, error) {
+		return func(Summary, error) { done <- name }
+	}
+
+	first, err := m.Start(context.Background(), RunOptions{URL: slow.URL + "/", Config: cfg}, onDone("slow"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for m.Get(first.ID) == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	second, err := m.Start(context.Background(), RunOptions{URL: fast.URL + "/", Config: cfg}, onDone("fast"))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, err := m.Start(context.Background(), RunOptions{URL: slow.URL, Config: cfg}, nil); !errors.Is(err, ErrJobActive) {
+		t.Errorf("Same URL must be rejected while active, got %v", err)
+	}
+
+	// Слот один: вторая задача ждёт, пока идёт первая
+	time.Sleep(100 * time.Millisecond)
+	list := m.List()
+	if len(list) != 2 || list[0].ID != first.ID || list[1].Status != JobQueued {
+		t.Fatalf("Expected running slow job and queued fast job, got %+v", list)
+	}
+	if p := m.Progress(); p.Running != 1 || p.Queued != 1 {
+		t.Errorf("Progress = %+v, want 1 running and 1 queued", p)
+	}
+
+	// Снятая из очереди задача не запускается
+	if !m.Stop(second.ID) {
+		t.Fatalf("Stop of queued job failed")
+	}
+	if got := <-done; got != "fast" {
+		t.Fatalf("Queued job must finish first after Stop, got %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, strings.TrimPrefix(fast.URL, "http://"))); !os.IsNotExist(err) {
+		t.Errorf("Stopped queued job must not download: %v", err)
+	}
+
+	close(release)
+	if got := <-done; got != "slow" {
+		t.Fatalf("Expected slow job to finish, got %s", got)
+	}
+	if list := m.List(); len(list) != 0 {
+		t.Errorf("Finished jobs must leave the list: %+v", list)
+	}
+	if m.Stop(first.ID) {
+		t.Errorf("Stop of a finished job must report false")
+	}
+}
+
+func TestIncrementalResumesInterruptedRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.Method == http.MethodGet {
+			hits[r.URL.Path]++
+		}
+		mu.Unlock()
+		if r.URL.Path == "/page/" && ctx.Err() == nil {
+			// Первый запуск обрывается на второй странице
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="/page/">page</a></body></html>`)
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	cfg := Config{Workers: 1, MaxDepth: 2, Retries: 1, DeferredRetries: -1, OutputDir: out, Incremental: true}
+	Run(ctx, RunOptions{URL: srv.URL + "/", Config: cfg})
+	mu.Lock()
+	rootHits := hits["/"]
+	mu.Unlock()
+
+	// Прерванный запуск доделывается: корень уже сохранён, качается только /page/
+	if _, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if hits["/"] != rootHits || hits["/page/"] != 2 {
+		t.Errorf("Interrupted run must be resumed, got hits %v", hits)
+	}
+	mu.Unlock()
+
+	// Законченный запуск — следующий обходит сайт заново
+	if _, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/"] == rootHits {
+		t.Errorf("Finished run must be recrawled, got hits %v", hits)
+	}
+}
+
+func TestIncrementalRecrawl(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	page := `<html><body><img src="/logo.png"></body></html>`
+	notModified := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var body, ct string
+		switch r.URL.Path {
+		case "/":
+			body, ct = `<html><body><a href="/page/">page</a><link rel="stylesheet" href="/style.css"></body></html>`, "text/html"
+		case "/page/":
+			body, ct = page, "text/html"
+		case "/style.css":
+			body, ct = `body{background:url(/bg.png)}`, "text/css"
+		case "/logo.png", "/bg.png", "/new.png":
+			body, ct = "png "+r.URL.Path, "image/png"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"` + ContentHash([]byte(body))[:16] + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified[r.URL.Path]++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", ct)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	saved := func(s JobStats) (n int64) {
+		for _, c := range s.FileTypes {
+			n += c
+		}
+		return n
+	}
+	out := t.TempDir()
+	run := func() Summary {
+		t.Helper()
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: out, Incremental: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	if sum := run(); saved(sum.Stats) != 5 || sum.Stats.Unchanged != 0 {
+		t.Fatalf("First run: unexpected stats %+v", sum.Stats)
+	}
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	if _, err := os.Stat(filepath.Join(root, MetaDirName, "page", "index.html.json")); err != nil {
+		t.Fatalf("Metadata sidecar missing: %v", err)
+	}
+
+	// Ничего не изменилось: всё отвечает 304, а ссылки идут из метаданных —
+	// иначе до /bg.png и /logo.png обход бы не дошёл
+	sum := run()
+	if saved(sum.Stats) != 0 || sum.Stats.Unchanged != 5 {
+		t.Fatalf("Second run must keep every file: %+v", sum.Stats)
+	}
+	for _, p := range []string{"/", "/page/", "/style.css", "/logo.png", "/bg.png"} {
+		if notModified[p] != 1 {
+			t.Errorf("%s: %d conditional hits, want 1", p, notModified[p])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "bg.png")); err != nil {
+		t.Errorf("Unchanged file must stay: %v", err)
+	}
+
+	// Изменённая страница качается заново, и её новые ссылки обходятся
+	mu.Lock()
+	page = `<html><body><img src="/logo.png"><img src="/new.png"></body></html>`
+	mu.Unlock()
+	sum = run()
+	if saved(sum.Stats) != 2 || sum.Stats.Unchanged != 4 {
+		t.Fatalf("Third run: want /page/ and /new.png saved, got %+v", sum.Stats)
+	}
+	body, _ := os.ReadFile(filepath.Join(root, "page", "index.html"))
+	if !strings.Contains(string(body), "new.png") {
+		t.Errorf("Changed page must be saved again:\n%s", body)
+	}
+}
+
+func TestSaveMetadata(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/old">old</a></body></html>`)
+		case "/old":
+			http.Redirect(w, r, "/new/", http.StatusMovedPermanently)
+		case "/new/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			w.Header().Set("X-Origin", "archive")
+			fmt.Fprint(w, `<html><body>new</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: out, SaveMetadata: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"))
+	data, err := os.ReadFile(filepath.Join(root, MetaDirName, "new", "index.html.json"))
+	if err != nil {
+		t.Fatalf("Metadata sidecar missing: %v", err)
+	}
+	var m fileMeta
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	body := `<html><body>new</body></html>`
+	if m.URL != srv.URL+"/new/" || m.RequestedURL != srv.URL+"/old" || m.Status != 200 ||
+		m.ETag != `"v1"` || m.LastModified != "Mon, 02 Jan 2006 15:04:05 GMT" || m.Headers["X-Origin"] != "archive" ||
+		m.Size != int64(len(body)) || m.Hash != ContentHash([]byte(body)) || m.Depth != 1 || m.SavedAt.IsZero() {
+		t.Errorf("Unexpected metadata: %+v", m)
+	}
+
+	// Метаданные есть у каждого сохранённого файла, не только у редиректа
+	if _, err := os.Stat(filepath.Join(root, MetaDirName, "index.html.json")); err != nil {
+		t.Errorf("Root page metadata missing: %v", err)
+	}
+}
+
+func TestWARCRecordFixture(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "response.warc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "job"+WARCExtension)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &warcWriter{
+		f:   f,
+		zw:  gzip.NewWriter(io.Discard),
+		ids: bytes.NewReader(make([]byte, 16)),
+		now: func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) },
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/html")
+	header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if err := w.writeResponse("http://example.com/", FetchResult{Content: []byte("<html>hi</html>"), Status: 200, Header: header}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	data, _ := os.ReadFile(path)
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("WARC record mismatch:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestWARCOutput(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/about">about</a><a href="/old">old</a><img src="/logo.png"></body></html>`)
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/about", "/new":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>about</body></html>`)
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png bytes"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := Run(context.Background(), RunOptions{URL: srv.URL + "/", Config: Config{OutputDir: t.TempDir(), Format: "zip"}}); err == nil {
+		t.Errorf("Unknown format must be rejected")
+	}
+
+	// Архив не открылся: задача только с архивом падает, с папкой — идёт дальше
+	for _, format := range []string{FormatWARC, FormatWARCTree} {
+		out := t.TempDir()
+		if err := os.Mkdir(filepath.Join(out, JobID(srv.URL+"/")+WARCExtension), 0755); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: out, Format: format},
+		})
+		if format == FormatWARC && (err == nil || len(sum.Stats.FileTypes) > 0) {
+			t.Errorf("%s: unwritable archive must fail the job, got %v, %v", format, err, sum.Stats.FileTypes)
+		}
+		if format == FormatWARCTree && (err != nil || sum.Stats.FileTypes[FileHTML] == 0) {
+			t.Errorf("%s: the tree must still be written, got %v, %v", format, err, sum.Stats.FileTypes)
+		}
+	}
+
+	for _, format := range []string{FormatWARC, FormatWARCTree} {
+		out := t.TempDir()
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: out, Format: format},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Каждая запись — отдельный gzip-member: читаем их по одному
+		data, err := os.ReadFile(filepath.Join(out, sum.JobID+WARCExtension))
+		if err != nil {
+			t.Fatalf("%s: archive missing: %v", format, err)
+		}
+		r := bytes.NewReader(data)
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		responses := map[string]string{}
+		for {
+			zr.Multistream(false)
+			record, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head, block, _ := strings.Cut(string(record), "\r\n\r\n")
+			if !strings.HasPrefix(head, "WARC/1.1\r\n") || !strings.HasSuffix(block, "\r\n\r\n") {
+				t.Fatalf("%s: malformed record %q", format, record)
+			}
+			block = strings.TrimSuffix(block, "\r\n\r\n")
+			fields := map[string]string{}
+			for _, line := range strings.Split(head, "\r\n")[1:] {
+				name, value, _ := strings.Cut(line, ": ")
+				fields[name] = value
+			}
+			if n, _ := strconv.Atoi(fields["Content-Length"]); n != len(block) {
+				t.Errorf("%s: Content-Length %d, block %d bytes", format, n, len(block))
+			}
+			if fields["WARC-Record-ID"] == "" || fields["WARC-Date"] == "" {
+				t.Errorf("%s: record without ID or date: %q", format, head)
+			}
+			types = append(types, fields["WARC-Type"])
+			if fields["WARC-Type"] == "response" {
+				responses[fields["WARC-Target-URI"]] = block
+			}
+			if err := zr.Reset(r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(types) != 6 || types[0] != "warcinfo" || len(responses) != 5 {
+			t.Fatalf("%s: want warcinfo and 5 responses, got %v", format, types)
+		}
+		// Редирект — своей записью: архив открывает и старый адрес
+		if old := responses[srv.URL+"/old"]; !strings.HasPrefix(old, "HTTP/1.1 301 Moved Permanently\r\n") ||
+			!strings.Contains(old, "Location: /new\r\n") || responses[srv.URL+"/new"] == "" {
+			t.Errorf("%s: unexpected redirect record %q", format, old)
+		}
+		// Страница в архиве — как её отдал сервер, ссылки не переписаны
+		page := responses[srv.URL+"/"]
+		if !strings.HasPrefix(page, "HTTP/1.1 200 OK\r\n") || !strings.Contains(page, "Content-Type: text/html\r\n") ||
+			!strings.HasSuffix(page, `<a href="/about">about</a><a href="/old">old</a><img src="/logo.png"></body></html>`) {
+			t.Errorf("%s: unexpected page record %q", format, page)
+		}
+		if !strings.HasSuffix(responses[srv.URL+"/logo.png"], "\r\n\r\npng bytes") {
+			t.Errorf("%s: unexpected image record %q", format, responses[srv.URL+"/logo.png"])
+		}
+
+		_, err = os.Stat(filepath.Join(out, strings.TrimPrefix(srv.URL, "http://"), "index.html"))
+		if tree := err == nil; tree != (format == FormatWARCTree) {
+			t.Errorf("%s: site folder written=%v", format, tree)
+		}
+		if sum.Stats.FileTypes[FileHTML] != 3 || sum.Stats.FileTypes[FileImage] != 1 {
+			t.Errorf("%s: unexpected stats %+v", format, sum.Stats)
+		}
+	}
+}
+
+func TestCharsetConversion(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	cp1251, err := os.ReadFile(filepath.Join("testdata", "cp1251.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sjis, err := os.ReadFile(filepath.Join("testdata", "shift_jis.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			// Кодировка только в <meta http-equiv>
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(cp1251)
+		case "/новости/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><body><a href="/ja">ja</a></body></html>`)
+		case "/ja":
+			// Кодировка только в заголовке, <meta charset> в странице нет
+			w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+			w.Write(sjis)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	out := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ссылка на /новости/ в windows-1251 разобрана после перекодировки
+	if sum.Stats.FileTypes[FileHTML] != 3 {
+		t.Fatalf("Expected 3 pages, got %+v", sum.Stats)
+	}
+
+	pages := map[string]string{}
+	filepath.WalkDir(out, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(p, ".html") {
+			data, _ := os.ReadFile(p)
+			pages[p] = string(data)
+		}
+		return nil
+	})
+	var ru, ja string
+	for _, page := range pages {
+		switch {
+		case strings.Contains(page, "Скачанная страница"):
+			ru = page
+		case strings.Contains(page, "日本語のページ"):
+			ja = page
+		}
+	}
+	if !strings.Contains(ru, "<title>Привет, мир</title>") || !strings.Contains(ru, `content="text/html; charset=utf-8"`) ||
+		strings.Contains(ru, "windows-1251") {
+		t.Errorf("windows-1251 page not converted:\n%s", ru)
+	}
+	if !strings.Contains(ja, "<title>こんにちは</title>") || !strings.Contains(ja, `<head><meta charset="utf-8"/>`) {
+		t.Errorf("shift_jis page not converted:\n%s", ja)
+	}
+
+	// UTF-8 и страницы без объявления не трогаем
+	for _, tc := range []struct{ page, ct string }{
+		{"<html><head><meta charset=utf-8></head>Привет</html>", "text/html"},
+		{"<html>plain</html>", "text/html"},
+		{"\xef\xbb\xbf<html>Привет</html>", "text/html; charset=windows-1251"},
+		{"<html>x</html>", "text/html; charset=x-unknown"},
+	} {
+		if out, from := HTMLToUTF8([]byte(tc.page), tc.ct); from != "" || string(out) != tc.page {
+			t.Errorf("%q (%s) must stay as is, converted from %q", tc.page, tc.ct, from)
+		}
+	}
+}
+
+func TestCompressedResponses(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("сжатая страница ", 200) + "</body></html>"
+	compress := func(enc string, data []byte) []byte {
+		var b bytes.Buffer
+		var w io.WriteCloser
+		switch enc {
+		case "gzip":
+			w = gzip.NewWriter(&b)
+		case "zlib":
+			w = zlib.NewWriter(&b)
+		case "deflate":
+			w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&b)
+		}
+		w.Write(data)
+		w.Close()
+		return b.Bytes()
+	}
+
+	var acceptEnc atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEnc.Store(r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compress("gzip", []byte(page)))
+		case "/zlib":
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(compress("zlib", []byte(page)))
+		case "/deflate":
+			// Голый deflate без zlib-заголовка
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(compress("deflate", []byte(page)))
+		case "/br":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write(compress("br", []byte(page)))
+		case "/stray.html":
+			// page.html.gz без Content-Encoding
+			w.Write(compress("gzip", []byte(page)))
+		case "/zstd":
+			w.Header().Set("Content-Encoding", "zstd")
+			w.Write([]byte{0x28, 0xb5, 0x2f, 0xfd})
+		case "/bomb":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compress("gzip", make([]byte, 64<<10)))
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(Config{Workers: 1, Retries: 1, MaxFileSize: 16 << 10})
+	for _, p := range []string{"/gzip", "/zlib", "/deflate", "/br", "/stray.html"} {
+		res, err := d.FetchIf(context.Background(), srv.URL+p, nil)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+			continue
+		}
+		if string(res.Content) != page {
+			t.Errorf("%s: body not decoded (%d bytes)", p, len(res.Content))
+		}
+		if res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: Content-Encoding must be dropped with decoded body", p)
+		}
+		// Иначе запись WARC объявила бы длину сжатого тела
+		if cl := res.Header.Get("Content-Length"); cl != "" && cl != strconv.Itoa(len(res.Content)) {
+			t.Errorf("%s: Content-Length %s does not match the decoded body", p, cl)
+		}
+	}
+	if got, _ := acceptEnc.Load().(string); !strings.Contains(got, "br") || !strings.Contains(got, "gzip") {
+		t.Errorf("Accept-Encoding = %q", got)
+	}
+
+	if _, err := d.FetchIf(context.Background(), srv.URL+"/zstd", nil); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("Expected ErrUnsupportedEncoding for zstd, got %v", err)
+	}
+	// Лимит считается по распакованным байтам, а не по размеру на проводе
+	if _, err := d.FetchIf(context.Background(), srv.URL+"/bomb", nil); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge for decoded size, got %v", err)
+	}
+}
+
+func TestBlockedURLSubstrings(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, blocked := range [][]string{nil, {"yoomoney"}} {
+		var hits sync.Map
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Store(r.URL.Path, true)
+			w.Header().Set("Content-Type", "text/html")
+			if r.URL.Path == "/" {
+				fmt.Fprint(w, `<html><body><a href="/donate/yoomoney">d</a><a href="/devnull">n</a><a href="/about">a</a></body></html>`)
+				return
+			}
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}))
+
+		var logged atomic.Bool
+		sum, err := Run(context.Background(), RunOptions{
+			URL: srv.URL + "/",
+			Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(),
+				BlockedURLSubstrings: blocked},
+			OnEvent: func(msg string) {
+				if strings.Contains(msg, `/donate/yoomoney: blocked by substring "yoomoney"`) {
+					logged.Store(true)
+				}
+			},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, requested := hits.Load("/donate/yoomoney")
+		if _, ok := hits.Load("/devnull"); !ok {
+			t.Errorf("blocked=%v: only configured substrings are blocked", blocked)
+		}
+		if blocked == nil && (!requested || sum.Stats.Blocked != 0) {
+			t.Errorf("Nothing must be blocked by default (requested=%v, stats=%+v)", requested, sum.Stats)
+		}
+		if blocked != nil && (requested || !logged.Load() || sum.Stats.Blocked != 1) {
+			t.Errorf("Blocked URL must be skipped, logged and counted (requested=%v, logged=%v, blocked=%d)",
+				requested, logged.Load(), sum.Stats.Blocked)
+		}
+	}
+
+	root, _ := url.Parse("https://example.com/")
+	f, _ := newURLFilter(root, Config{DownloadExternalAssets: true, BlockedURLSubstrings: []string{"counter"}})
+	if f.ShouldDownload("https://cdn.example.net/counter.js") {
+		t.Error("Blocklist must apply to external assets too")
+	}
+	if r := f.FilterReason("https://example.com/counter.js"); r != `blocked by substring "counter"` {
+		t.Errorf("FilterReason = %q", r)
+	}
+}
+
+func TestCrawlStrategy(t *testing.T) {
+	items := []struct {
+		url   string
+		depth int
+	}{
+		{"https://example.com/deep/page", 3},
+		{"https://example.com/a", 1},
+		{"https://example.com/style.css", 2},
+		{"https://example.com/b", 1},
+		{"https://example.com/logo.png", 1},
+	}
+	for strategy, want := range map[string]string{
+		CrawlBFS:         "/a /b /logo.png /style.css /deep/page",
+		CrawlDFS:         "/deep/page /style.css /logo.png /b /a",
+		CrawlAssetsFirst: "/logo.png /style.css /a /b /deep/page",
+	} {
+		f := newFrontier(strategy, DefaultQueueSize)
+		for _, it := range items {
+			f.push(it.url, it.depth)
+		}
+		if got := strings.ReplaceAll(strings.Join(f.drain(), " "), "https://example.com", ""); got != want {
+			t.Errorf("%s: got %s, want %s", strategy, got, want)
+		}
+	}
+	// Стратегию можно сменить при resume — очередь перестраивается
+	f := newFrontier(CrawlBFS, DefaultQueueSize)
+	for _, it := range items {
+		f.push(it.url, it.depth)
+	}
+	f.order(CrawlDFS)
+	if it, _ := f.pop(); it.url != "https://example.com/deep/page" {
+		t.Errorf("Reordered queue starts with %s", it.url)
+	}
+
+	// Обход: с assets-first ассеты страницы скачиваются раньше её ссылок
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	var mu sync.Mutex
+	var hits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/p1">1</a><a href="/p2">2</a><link rel="stylesheet" href="/a.css"><script src="/b.js"></script></body></html>`)
+		case "/a.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body{}`)
+		case "/b.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			fmt.Fprint(w, `1`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), Strategy: CrawlAssetsFirst},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pos := map[string]int{}
+	for i, p := range hits {
+		if _, ok := pos[p]; !ok {
+			pos[p] = i
+		}
+	}
+	for _, asset := range []string{"/a.css", "/b.js"} {
+		for _, page := range []string{"/p1", "/p2"} {
+			if pos[asset] > pos[page] {
+				t.Errorf("%s requested after %s: %v", asset, page, hits)
+			}
+		}
+	}
+
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 1, OutputDir: t.TempDir(), Strategy: "random"},
+	}); err == nil {
+		t.Error("Unknown strategy must be rejected")
+	}
+}
+
+func TestHugeLinkPageDoesNotBlockWorkers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const links = 3000
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+			return
+		}
+		var b strings.Builder
+		b.WriteString("<html><body>")
+		for i := 0; i < links; i++ {
+			fmt.Fprintf(&b, `<a href="/p%d">%d</a>`, i, i)
+		}
+		b.WriteString("</body></html>")
+		fmt.Fprint(w, b.String())
+	}))
+	defer srv.Close()
+
+	// Очередь в памяти на 2 URL: всё остальное — на диск, воркеры не ждут друг друга
+	done := make(chan Summary, 1)
+	go func() {
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 4, MaxDepth: 1, Retries: 1, OutputDir: t.TempDir(), QueueSize: 2},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- sum
+	}()
+	select {
+	case sum := <-done:
+		if sum.Stats.FileTypes[FileHTML] < links {
+			t.Errorf("Expected %d+ pages, got %+v", links, sum.Stats)
+		}
+	case <-time.After(60 * time.Second):
+		t.Fatal("Crawl hung with a tiny queue")
+	}
+
+	// Без файла переполнения очередь в памяти растёт, а не блокирует
+	j := newStateTestJob(t.TempDir())
+	j.frontier = newFrontier(CrawlBFS, 2)
+	queued := make(chan struct{})
+	go func() {
+		for i := 0; i < links; i++ {
+			j.enqueue(fmt.Sprintf("https://example.com/p%d", i), 1)
+		}
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueue blocked without an overflow file")
+	}
+	if n := j.frontier.Len(); n != links {
+		t.Errorf("Expected %d queued URLs, got %d", links, n)
+	}
+}
+
+// Запуск и немедленная отмена под -race: Events закрывается один раз,
+// поздние отправки после закрытия не паникуют
+func TestStartCancelRace(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><a href="/a%s">a</a><a href="/b%s">b</a></body></html>`, r.URL.Path, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	runs := 300
+	if testing.Short() {
+		runs = 30
+	}
+	out := t.TempDir()
+	for i := 0; i < runs; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i%3 == 0 {
+			cancel() // Отменена ещё до старта
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			Run(ctx, RunOptions{
+				URL:     srv.URL + "/",
+				Config:  Config{Workers: 4, MaxDepth: 5, Retries: 1, OutputDir: out},
+				OnEvent: func(string) {},
+				OnStart: func(j *Job) {
+					if i%3 == 1 {
+						j.Cancel()
+					}
+				},
+			})
+		}()
+		if i%3 == 2 {
+			cancel()
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Run %d did not return after cancel", i)
+		}
+		cancel()
+	}
+}
+
+// Под -race: счётчики читают репортер, чекпоинты и подписчики, пока воркеры их меняют
+func TestStatsAccessRaceFree(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		var b strings.Builder
+		for i := 0; i < 5 && len(r.URL.Path) < 12; i++ {
+			fmt.Fprintf(&b, `<a href="%s%d/">x</a>`, r.URL.Path, i)
+		}
+		fmt.Fprintf(w, "<html><body>%s</body></html>", b.String())
+	}))
+	defer srv.Close()
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 8, MaxDepth: 3, Retries: 1, OutputDir: t.TempDir(), CheckpointPages: 1},
+		OnStart: func(j *Job) {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					s := j.Snapshot()
+					_ = s.FileTypes[FileHTML]
+					j.snapshot()
+				}
+			}()
+		},
+	})
+	close(stop)
+	readers.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Stats.FileTypes[FileHTML] != 156 {
+		t.Errorf("Expected 156 pages, got %+v", sum.Stats)
+	}
+}
+
+// panicHandler падает на одном URL, как сломанный ContentHandler
+type panicHandler struct{ url string }
+
+func (h panicHandler) Name() string  { return "panic" }
+func (h panicHandler) Priority() int { return 0 }
+func (h panicHandler) Handle(content []byte, meta FileMetadata) ([]byte, error) {
+	if strings.HasSuffix(meta.URL, h.url) {
+		var m map[string]int
+		m["boom"]++ // nil map
+	}
+	return content, nil
+}
+
+func TestWorkerPanicRecovered(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/boom">b</a><a href="/a">a</a><a href="/c">c</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body>page</body></html>`)
+	}))
+	defer srv.Close()
+
+	var panicked atomic.Bool
+	var job *Job
+	done := make(chan Summary, 1)
+	go func() {
+		sum, _ := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 2, Retries: 1, OutputDir: t.TempDir(), DeferredRetries: -1},
+			OnStart: func(j *Job) {
+				job = j
+				j.RegisterHandler(panicHandler{url: "/boom"})
+			},
+			OnEvent: func(msg string) {
+				if strings.Contains(msg, "[Panic]") && strings.Contains(msg, "/boom") {
+					panicked.Store(true)
+				}
+			},
+		})
+		done <- sum
+	}()
+
+	select {
+	case sum := <-done:
+		if sum.Stats.Failed != 1 || sum.Stats.FileTypes[FileHTML] != 3 {
+			t.Errorf("Expected 1 failed URL and 3 saved pages, got %+v", sum.Stats)
+		}
+		if !panicked.Load() {
+			t.Error("Panic must be reported in Events")
+		}
+		// Исход паники попадает в битые ссылки, хотя восстановление j.mu не брало
+		broken := job.FailedURLs()
+		if len(broken) != 1 || broken[0].URL != srv.URL+"/boom" || !strings.Contains(broken[0].Error, ErrWorkerPanic.Error()) {
+			t.Errorf("Panicked URL must be reported as broken, got %+v", broken)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Job hung after a worker panic")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var gets sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Store(r.URL.Path, true)
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/style.css"></head><body>`+
+				`<img src="/logo.png"><a href="/about">a</a><a href="/tag/go">t</a><a href="/ads/banner">b</a></body></html>`)
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { background: url(/bg.jpg) }`)
+		case "/logo.png", "/bg.jpg":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", "1234")
+			if r.Method == http.MethodGet {
+				w.Write(make([]byte, 1234))
+			}
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL: srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir, DryRun: true,
+			ExcludePatterns: []string{"/tag/*"}, BlockedURLSubstrings: []string{"/ads/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sum.DryRun
+	if r == nil {
+		t.Fatal("Summary.DryRun must hold the report")
+	}
+
+	// На диске — только отчёт
+	var written []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			written = append(written, filepath.Base(p))
+		}
+		return nil
+	})
+	if len(written) != 1 || !strings.HasSuffix(written[0], DryRunExtension) {
+		t.Errorf("Dry run must write only the report, got %v", written)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, sum.JobID+DryRunExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved DryRunReport
+	if err := json.Unmarshal(data, &saved); err != nil || saved.TotalFiles != r.TotalFiles {
+		t.Errorf("Report file does not match the summary: %v", err)
+	}
+
+	var files []string
+	for _, f := range r.Files {
+		files = append(files, strings.TrimPrefix(f.URL, srv.URL))
+		if strings.HasSuffix(f.URL, ".png") || strings.HasSuffix(f.URL, ".jpg") {
+			if f.Size != 1234 || f.Type != FileImage {
+				t.Errorf("%s: size %d, type %s; want 1234 from HEAD, image", f.URL, f.Size, f.Type)
+			}
+		}
+	}
+	if got := strings.Join(files, " "); got != "/ /about /bg.jpg /logo.png /style.css" {
+		t.Errorf("Files = %s", got)
+	}
+	for _, p := range []string{"/logo.png", "/bg.jpg"} {
+		if _, ok := gets.Load(p); ok {
+			t.Errorf("%s: a HEAD request is enough for an asset", p)
+		}
+	}
+	if img := r.ByType[FileImage]; img.Files != 2 || img.Bytes != 2468 {
+		t.Errorf("ByType[image] = %+v", img)
+	}
+
+	reasons := map[string]string{}
+	for _, f := range r.Filtered {
+		reasons[strings.TrimPrefix(f.URL, srv.URL)] = f.Reason
+	}
+	if reasons["/ads/banner"] != `blocked by substring "/ads/"` || reasons["/tag/go"] == "" || len(reasons) != 2 {
+		t.Errorf("Filtered = %v", reasons)
+	}
+}
+
+func TestBrokenLinksReport(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/missing">m</a><a href="/about">a</a></body></html>`)
+		case "/about":
+			fmt.Fprint(w, `<html><body><a href="/missing">m</a><a href="/gone">g</a><a href="/">home</a></body></html>`)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []BrokenLink{
+		{URL: srv.URL + "/gone", Status: 410, Referrers: []string{srv.URL + "/about"}},
+		{URL: srv.URL + "/missing", Status: 404, Referrers: []string{srv.URL + "/", srv.URL + "/about"}},
+	}
+	got := sum.BrokenLinks
+	if len(got) != len(want) {
+		t.Fatalf("BrokenLinks = %+v", got)
+	}
+	for i, w := range want {
+		sort.Strings(got[i].Referrers)
+		if got[i].URL != w.URL || got[i].Status != w.Status || got[i].Attempts != 1 || got[i].Error == "" ||
+			strings.Join(got[i].Referrers, " ") != strings.Join(w.Referrers, " ") {
+			t.Errorf("BrokenLinks[%d] = %+v, want %+v", i, got[i], w)
+		}
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(sum.StateFile, StateFileExtension) + BrokenLinksExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []BrokenLink
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 2 {
+		t.Errorf("JSON report: %v, %d links", err, len(saved))
+	}
+	f, err := os.Open(strings.TrimSuffix(sum.StateFile, StateFileExtension) + BrokenLinksCSVExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][0] != "url" || rows[2][1] != "404" {
+		t.Errorf("CSV report: %v, %q", err, rows)
+	}
+}
+
+func TestReferrersDroppedOnCompletion(t *testing.T) {
+	j := newStateTestJob(t.TempDir())
+	page, asset := "https://example.com/", "https://example.com/logo.png"
+
+	j.mu.Lock()
+	j.noteReferrer(asset, page)
+	j.mu.Unlock()
+	j.noteCompleted(asset)
+
+	// Сохранённому URL ссылающиеся страницы больше не нужны
+	j.mu.Lock()
+	j.noteReferrer(asset, "https://example.com/about")
+	n := len(j.referrers)
+	j.mu.Unlock()
+	if n != 0 {
+		t.Errorf("Completed URL must not keep referrers, got %v", j.referrers)
+	}
+}
+
+func TestSinglePage(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var hits sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Store(r.URL.Path, true)
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/style.css"><link rel="next" href="/page/2"></head><body>`+
+				`<img src="/logo.png"><img src="/image?id=5"><a href="/other">o</a><a href="/about.html">a</a>`+
+				`<a href="/docs/manual.pdf">m</a></body></html>`)
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `@font-face { src: url(/font) } body { background: url(/bg.jpg) }`)
+		case "/font":
+			w.Header().Set("Content-Type", "font/woff2")
+			fmt.Fprint(w, "wOF2")
+		case "/logo.png", "/bg.jpg", "/image":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		case "/docs/manual.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			fmt.Fprint(w, "%PDF")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><img src="/never.png"></body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 0, Retries: 1, OutputDir: dir, SinglePage: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Что качать, решает тег: картинка без расширения — ресурс, а PDF по
+	// гиперссылке — переход на другую страницу
+	for _, p := range []string{"/", "/style.css", "/logo.png", "/image", "/font", "/bg.jpg"} {
+		if _, ok := hits.Load(p); !ok {
+			t.Errorf("%s: assets of the page must be downloaded regardless of MaxDepth", p)
+		}
+	}
+	for _, p := range []string{"/other", "/about.html", "/never.png", "/docs/manual.pdf", "/page/2"} {
+		if _, ok := hits.Load(p); ok {
+			t.Errorf("%s: other pages must not be crawled", p)
+		}
+	}
+	if sum.Stats.Skipped != 0 {
+		t.Errorf("Skipped = %d: pages must not even be queued", sum.Stats.Skipped)
+	}
+
+	var page []byte
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == "index.html" {
+			page, _ = os.ReadFile(p)
+		}
+		return nil
+	})
+	for _, want := range []string{
+		`href="` + srv.URL + `/other"`,
+		`href="` + srv.URL + `/about.html"`,
+		`href="` + srv.URL + `/docs/manual.pdf"`,
+		`src="./logo.png"`,
+		`href="./style.css"`,
+	} {
+		if !bytes.Contains(page, []byte(want)) {
+			t.Errorf("Saved page lacks %s:\n%s", want, page)
+		}
+	}
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var pageHits, stallHits int64
+	wait := func(r *http.Request, d time.Duration) bool {
+		select {
+		case <-time.After(d):
+			return true
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			atomic.AddInt64(&pageHits, 1)
+			if !wait(r, 500*time.Millisecond) {
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		case "/stall.html":
+			atomic.AddInt64(&stallHits, 1)
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html>")
+			w.(http.Flusher).Flush()
+			wait(r, 500*time.Millisecond)
+		case "/movie.mp4":
+			if !wait(r, 300*time.Millisecond) {
+				return
+			}
+			w.Header().Set("Content-Type", "video/mp4")
+			fmt.Fprint(w, "mp4")
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(bytes.Repeat([]byte("x"), 24<<10))
+		case "/stall-sized.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "4096")
+			fmt.Fprint(w, "<html>")
+			w.(http.Flusher).Flush()
+			wait(r, 5*time.Second)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(Config{Retries: 2, Delay: time.Millisecond,
+		PageTimeout: 100 * time.Millisecond, AssetTimeout: 2 * time.Second})
+
+	for _, tc := range []struct {
+		path string
+		hits *int64
+	}{{"/slow", &pageHits}, {"/stall.html", &stallHits}} {
+		_, _, err := d.Download(context.Background(), srv.URL+tc.path)
+		var timeout *TimeoutError
+		if !errors.As(err, &timeout) || timeout.After != 100*time.Millisecond {
+			t.Fatalf("%s: want TimeoutError after 100ms, got %v", tc.path, err)
+		}
+		if !strings.Contains(err.Error(), "timed out after 100ms (2 attempts)") {
+			t.Errorf("%s: unclear message %q", tc.path, err)
+		}
+		if !isTransientError(err) || atomic.LoadInt64(tc.hits) != 2 {
+			t.Errorf("%s: timeout must be retried (transient=%v, hits=%d)", tc.path, isTransientError(err), atomic.LoadInt64(tc.hits))
+		}
+	}
+
+	// Ассету — свой, более длинный таймаут
+	if content, _, err := d.Download(context.Background(), srv.URL+"/movie.mp4"); err != nil || string(content) != "mp4" {
+		t.Errorf("Asset must fit in AssetTimeout: %q, %v", content, err)
+	}
+
+	// С лимитом трафика зависшая страница обрывается так же быстро: запас
+	// даётся только на чтение тела, когда заголовки уже пришли
+	d = NewDownloader(Config{Retries: 1, MaxFileSize: 1 << 20, MaxBytesPerSecond: 16 << 10,
+		PageTimeout: 100 * time.Millisecond, AssetTimeout: 2 * time.Second})
+	start := time.Now()
+	_, _, err := d.Download(context.Background(), srv.URL+"/slow")
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.After != 100*time.Millisecond || time.Since(start) > 400*time.Millisecond {
+		t.Errorf("Hung page must time out after PageTimeout under a bandwidth cap: %v in %s", err, time.Since(start))
+	}
+	// Запас на тело — по его Content-Length (4 KiB — 0,25 с), а не по MaxFileSize
+	_, _, err = d.Download(context.Background(), srv.URL+"/stall-sized.html")
+	if !errors.As(err, &timeout) || timeout.After != 350*time.Millisecond {
+		t.Errorf("Stalled body must time out after PageTimeout plus its own allowance: %v", err)
+	}
+	// 24 KiB при 16 KiB/s читаются дольше PageTimeout и не обрываются
+	if content, _, err := d.Download(context.Background(), srv.URL+"/big"); err != nil || len(content) != 24<<10 {
+		t.Errorf("Throttled body must get the read allowance: %d bytes, %v", len(content), err)
+	}
+}
+
+func TestUserAgentPresets(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	var seen []http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		fmt.Fprint(w, "ok")
+	})
+	a, b := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer a.Close()
+	defer b.Close()
+	fetch := func(cfg Config, urls ...string) []http.Header {
+		mu.Lock()
+		seen = nil
+		mu.Unlock()
+		cfg.Retries = 1
+		d := NewDownloader(cfg)
+		for _, u := range urls {
+			if _, _, err := d.Download(context.Background(), u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return seen
+	}
+	chrome, _ := UAPreset(UAChrome)
+	firefox, _ := UAPreset(UAFirefox)
+
+	if h := fetch(Config{}, a.URL)[0]; h.Get("User-Agent") != DefaultUserAgent || h.Get("Sec-Ch-Ua") != "" {
+		t.Errorf("Default UA must stay the fallback: %v", h)
+	}
+	if h := fetch(Config{UAPreset: UAChrome, UserAgent: "custom"}, a.URL)[0]; h.Get("User-Agent") != chrome.UserAgent ||
+		!strings.Contains(h.Get("Sec-Ch-Ua"), `"Google Chrome";v="131"`) || h.Get("Sec-Ch-Ua-Platform") != `"Windows"` ||
+		!strings.Contains(h.Get("Accept"), "image/avif") {
+		t.Errorf("Chrome preset must send its client hints: %v", h)
+	}
+	if h := fetch(Config{UAPreset: UAFirefox}, a.URL)[0]; h.Get("User-Agent") != firefox.UserAgent ||
+		h.Get("Sec-Ch-Ua") != "" || h.Get("Accept") != firefox.Headers["Accept"] {
+		t.Errorf("Firefox must not send Chrome client hints: %v", h)
+	}
+	if h := fetch(Config{UAPreset: UAGooglebot, Headers: map[string]string{"Accept": "*/*"}}, a.URL)[0]; h.Get("Accept") != "*/*" {
+		t.Errorf("Config.Headers must override the preset: %v", h)
+	}
+
+	var uas []string
+	for _, h := range fetch(Config{UserAgents: []string{"bot/1", UAChrome}}, a.URL, a.URL, a.URL) {
+		uas = append(uas, h.Get("User-Agent"))
+		if h.Get("User-Agent") == "bot/1" && h.Get("Sec-Ch-Ua") != "" {
+			t.Errorf("Custom UA must not get client hints: %v", h)
+		}
+	}
+	if want := "bot/1|" + chrome.UserAgent + "|bot/1"; strings.Join(uas, "|") != want {
+		t.Errorf("Round-robin: %v", uas)
+	}
+
+	uas = nil
+	for _, h := range fetch(Config{UserAgents: []string{"x/1", "y/1"}, UARotation: UAPerHost}, a.URL, b.URL, a.URL, b.URL) {
+		uas = append(uas, h.Get("User-Agent"))
+	}
+	if strings.Join(uas, " ") != "x/1 y/1 x/1 y/1" {
+		t.Errorf("Per-host rotation must stick to one UA per host: %v", uas)
+	}
+
+	if err := checkUserAgents(Config{UAPreset: "netscape"}); err == nil {
+		t.Error("Unknown preset must be rejected")
+	}
+	if err := checkUserAgents(Config{UARotation: "random"}); err == nil {
+		t.Error("Unknown rotation must be rejected")
+	}
+}
+
+func TestRespectNoFollow(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, respect := range []bool{true, false} {
+		var hits sync.Map
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Store(r.URL.Path, true)
+			w.Header().Set("Content-Type", "text/html")
+			switch r.URL.Path {
+			case "/":
+				fmt.Fprint(w, `<html><body><a rel="nofollow noopener" href="/private">p</a>`+
+					`<a href="/meta">m</a><a href="/header">h</a><a href="/about">a</a></body></html>`)
+			case "/meta":
+				fmt.Fprint(w, `<html><head><meta name="ROBOTS" content="noindex, nofollow"></head>`+
+					`<body><img src="/pic.png"><a href="/from-meta">f</a><a href="/later">l</a></body></html>`)
+			case "/header":
+				w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+				fmt.Fprint(w, `<html><body><a href="/from-header">f</a></body></html>`)
+			case "/pic.png":
+				w.Header().Set("Content-Type", "image/png")
+				fmt.Fprint(w, "png")
+			case "/about":
+				fmt.Fprint(w, `<html><body><a href="/deep">d</a></body></html>`)
+			case "/deep":
+				// Обычная ссылка на URL, уже пропущенный как nofollow
+				fmt.Fprint(w, `<html><body><a href="/later">l</a></body></html>`)
+			default:
+				fmt.Fprint(w, `<html><body>page</body></html>`)
+			}
+		}))
+
+		var logged atomic.Bool
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: t.TempDir(), RespectNoFollow: respect},
+			OnEvent: func(msg string) {
+				if strings.Contains(msg, "/private: "+ReasonNoFollow) {
+					logged.Store(true)
+				}
+			},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range []string{"/meta", "/header", "/pic.png", "/later"} {
+			if _, ok := hits.Load(p); !ok {
+				t.Errorf("nofollow=%v: %s must be downloaded", respect, p)
+			}
+		}
+		for _, p := range []string{"/private", "/from-meta", "/from-header"} {
+			if _, ok := hits.Load(p); ok == respect {
+				t.Errorf("nofollow=%v: %s requested=%v", respect, p, ok)
+			}
+		}
+		if respect && (sum.Stats.Skipped != 3 || !logged.Load()) {
+			t.Errorf("Skipped = %d, logged = %v; want 3 nofollow links counted and logged", sum.Stats.Skipped, logged.Load())
+		}
+		if !respect && sum.Stats.Skipped != 0 {
+			t.Errorf("Without RespectNoFollow nothing must be skipped, got %d", sum.Stats.Skipped)
+		}
+	}
+
+	links, _ := (&HTMLParser{NoFollow: true}).Parse([]byte(`<a href="/a" rel="NoFollow">a</a><a href="/b">b</a>`), "https://example.com/")
+	if len(links) != 1 || links[0] != "https://example.com/b" {
+		t.Errorf("Parse with NoFollow = %v", links)
+	}
+}
+
+func TestFileHooks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/missing">m</a></body></html>`)
+		case "/a":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>a</body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	started := map[string]bool{}
+	done := map[string]string{}
+	failed := map[string]error{}
+	_, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 3, MaxDepth: 1, Retries: 1, OutputDir: t.TempDir()},
+		OnStart: func(j *Job) {
+			j.OnFileStart = func(u string) {
+				mu.Lock()
+				defer mu.Unlock()
+				started[u] = true
+			}
+			j.OnFileDone = func(u, path string, size int64, contentType string) {
+				// Хук может дергать задачу: мьютексы в этот момент свободны
+				j.Snapshot()
+				mu.Lock()
+				defer mu.Unlock()
+				if size <= 0 || !strings.HasPrefix(contentType, "text/html") {
+					t.Errorf("OnFileDone(%s): size %d, type %q", u, size, contentType)
+				}
+				done[u] = path
+			}
+			j.OnFileError = func(u string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[u] = err
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/", "/a", "/missing"} {
+		if !started[srv.URL+p] {
+			t.Errorf("OnFileStart not called for %s", p)
+		}
+	}
+	if len(done) != 2 || done[srv.URL+"/a"] == "" {
+		t.Errorf("OnFileDone = %v, want / and /a with paths", done)
+	}
+	if len(failed) != 1 || failed[srv.URL+"/missing"] == nil {
+		t.Errorf("OnFileError = %v, want /missing", failed)
+	}
+}
+
+// scriptStripper — пример своего обработчика: вырезает <script> из страниц
+type scriptStripper struct{}
+
+var scriptTagRegex = regexp.MustCompile(`(?is)<script\b.*?</script>`)
+
+func (scriptStripper) Name() string  { return "strip-scripts" }
+func (scriptStripper) Priority() int { return 5 }
+func (scriptStripper) Handle(content []byte, meta FileMetadata) ([]byte, error) {
+	if !strings.Contains(meta.ContentType, "text/html") {
+		return content, nil
+	}
+	return scriptTagRegex.ReplaceAll(content, nil), nil
+}
+
+// lineParser — пример своего парсера: text/plain со ссылкой в каждой строке
+type lineParser struct{}
+
+func (lineParser) CanParse(ct string) bool { return strings.HasPrefix(ct, "text/plain") }
+func (lineParser) Parse(content []byte, baseURL string) ([]string, error) {
+	return resolveRawLinks(strings.Fields(string(content)), baseURL), nil
+}
+
+func TestRegisterParserAndHandler(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var hits sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Store(r.URL.Path, true)
+		switch r.URL.Path {
+		case "/list.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "/from-list\n")
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><script>track()</script></head><body><a href="/list.txt">l</a></body></html>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>page</body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sum, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir},
+		OnStart: func(j *Job) {
+			j.RegisterHandler(scriptStripper{})
+			j.RegisterParser(lineParser{})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hits.Load("/from-list"); !ok {
+		t.Error("Links found by the registered parser must be crawled")
+	}
+	var page []byte
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == "index.html" {
+			page, _ = os.ReadFile(p)
+		}
+		return nil
+	})
+	if len(page) == 0 || strings.Contains(string(page), "<script") || !strings.Contains(string(page), "list.txt") {
+		t.Errorf("Page must be saved with scripts stripped and links rewritten:\n%s", page)
+	}
+
+	state, err := LoadJobState(sum.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{LinkRewriterName, "strip-scripts"}; !reflect.DeepEqual(state.Handlers, want) {
+		t.Errorf("State handlers = %v, want %v", state.Handlers, want)
+	}
+
+	// Resume без обработчика предупреждает, с ним — нет
+	for _, register := range []bool{false, true} {
+		var warned atomic.Bool
+		_, err := Resume(context.Background(), sum.StateFile, ResumeOptions{
+			OnStart: func(j *Job) {
+				if register {
+					j.RegisterHandler(scriptStripper{})
+				}
+			},
+			OnEvent: func(msg string) {
+				if strings.Contains(msg, `"strip-scripts"`) {
+					warned.Store(true)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if warned.Load() == register {
+			t.Errorf("registered=%v: warned=%v", register, warned.Load())
+		}
+	}
+}
+
+func TestEquivalentURLSpellings(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Написания одной страницы: один ключ visited и один путь на диске
+	groups := map[string][]string{
+		"index.html": {
+			"http://example.com",
+			"http://example.com/",
+			"http://EXAMPLE.com/",
+			"http://example.com:80/",
+			"http://example.com/index.html",
+			"http://example.com/INDEX.HTM",
+			"http://example.com/./",
+			"http://example.com/.",
+			"http://example.com/a/../",
+			"http://example.com//index.html",
+			"http://example.com/?",
+			"http://example.com/#top",
+			"http://example.com/%69ndex.html",
+		},
+		"docs/index.html": {
+			"http://example.com/docs",
+			"http://example.com/docs/",
+			"http://example.com/docs/index.html",
+			"http://example.com/docs/./",
+			"http://example.com/docs/sub/..",
+			"http://example.com/x/../docs",
+			"http://example.com//docs/",
+			"http://Example.com/docs/Index.Html",
+			"http://example.com/d%6Fcs#part",
+		},
+	}
+	for wantPath, spellings := range groups {
+		j := &Job{visited: make(map[string]bool)}
+		for i, raw := range spellings {
+			key, err := j.canonicalURL(raw)
+			if err != nil {
+				t.Fatalf("%s: %v", raw, err)
+			}
+			u, _ := url.Parse(key)
+			if got := DiskPath(u, "text/html"); got != wantPath {
+				t.Errorf("%s → %s: disk path %q, want %q", raw, key, got, wantPath)
+			}
+			if seen := j.visitedLocked(key); seen != (i > 0) {
+				t.Errorf("%s → %s: visited = %v after %d spellings", raw, key, seen, i)
+			}
+			j.visited[key] = true
+		}
+	}
+
+	for raw, want := range map[string]string{
+		"http://example.com/myindex.html":       "http://example.com/myindex.html",
+		"http://example.com/docs/index.html/x":  "http://example.com/docs/index.html/x",
+		"https://example.com:443/a/./b/../c.js": "https://example.com/a/c.js",
+		"http://example.com:8080/":              "http://example.com:8080/",
+	} {
+		if got, _ := NormalizeURL(raw); got != want {
+			t.Errorf("NormalizeURL(%s) = %s, want %s", raw, got, want)
+		}
+	}
+
+	// В обходе: каждая страница скачивается один раз, как ни сослаться
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="./">1</a><a href=".">2</a><a href="/">3</a><a href="/index.html">4</a>`+
+			`<a href="/docs">5</a><a href="/docs/">6</a><a href="/docs/index.html">7</a><a href="/docs/./">8</a></body></html>`)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var started []string
+	dir := t.TempDir()
+	if _, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 3, Retries: 1, OutputDir: dir},
+		OnStart: func(j *Job) {
+			j.OnFileStart = func(u string) {
+				mu.Lock()
+				defer mu.Unlock()
+				started = append(started, u)
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 2 {
+		t.Errorf("Crawled %v, want root and docs once each", started)
+	}
+	var saved []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == "index.html" {
+			rel, _ := filepath.Rel(dir, p)
+			saved = append(saved, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(saved) != 2 {
+		t.Errorf("Saved pages = %v, want root and docs only", saved)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, route := range []bool{true, false} {
+		var hits sync.Map
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Store(r.URL.Path, true)
+			switch r.URL.Path {
+			case "/":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body><a href="/404">e</a><a href="/missing">m</a></body></html>`)
+			case "/404":
+				if !route {
+					http.NotFound(w, r)
+					return
+				}
+				fallthrough
+			case "/missing":
+				w.Header().Set("Content-Type", "text/html")
+				if r.URL.Path == "/missing" {
+					w.WriteHeader(http.StatusNotFound)
+				}
+				fmt.Fprint(w, `<html><body><img src="/oops.png"><a href="/from-error">home</a></body></html>`)
+			case "/oops.png":
+				w.Header().Set("Content-Type", "image/png")
+				fmt.Fprint(w, "png")
+			default:
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><body>page</body></html>`)
+			}
+		}))
+
+		dir := t.TempDir()
+		sum, err := Run(context.Background(), RunOptions{
+			URL:    srv.URL + "/",
+			Config: Config{Workers: 1, MaxDepth: 3, Retries: 1, OutputDir: dir},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, _ := url.Parse(srv.URL)
+		site := filepath.Join(dir, u.Host)
+		page, err := os.ReadFile(filepath.Join(site, ErrorPageName))
+		if err != nil || !strings.Contains(string(page), "oops.png") {
+			t.Errorf("route=%v: error page must be saved as %s: %v", route, ErrorPageName, err)
+		}
+		if _, err := os.Stat(filepath.Join(site, "404")); err == nil {
+			t.Errorf("route=%v: no 404/ directory expected", route)
+		}
+		if index, _ := os.ReadFile(filepath.Join(site, "index.html")); route && !strings.Contains(string(index), `href="./404.html"`) {
+			t.Errorf("Link to /404 must lead to the saved error page:\n%s", index)
+		}
+		if _, ok := hits.Load("/oops.png"); !ok {
+			t.Errorf("route=%v: assets of the error page must be downloaded", route)
+		}
+		if _, ok := hits.Load("/from-error"); ok {
+			t.Errorf("route=%v: links of the error page must not be crawled", route)
+		}
+		var broken []string
+		for _, b := range sum.BrokenLinks {
+			broken = append(broken, strings.TrimPrefix(b.URL, srv.URL))
+		}
+		if !route && len(broken) != 2 || route && (len(broken) != 1 || broken[0] != "/missing") {
+			t.Errorf("route=%v: broken links = %v", route, broken)
+		}
+		if entry, _ := EntryPath(site); entry == ErrorPageName {
+			t.Errorf("route=%v: error page must not be the entry page", route)
+		}
+	}
+}
+
+// recordingLogger запоминает строки по уровням
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines map[string][]string
+}
+
+func (l *recordingLogger) add(level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lines == nil {
+		l.lines = map[string][]string{}
+	}
+	l.lines[level] = append(l.lines[level], fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debug(format string, args ...any) { l.add(LogDebug, format, args...) }
+func (l *recordingLogger) Info(format string, args ...any)  { l.add(LogInfo, format, args...) }
+func (l *recordingLogger) Warn(format string, args ...any)  { l.add(LogWarn, format, args...) }
+func (l *recordingLogger) Error(format string, args ...any) { l.add(LogError, format, args...) }
+
+func (l *recordingLogger) has(level, substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines[level] {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/broken">b</a></body></html>`)
+		case "/a":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/">home</a></body></html>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	// Свой Logger получает все уровни, подробности по запросам — в Debug
+	rec := &recordingLogger{}
+	_, err := Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, DeferredRetries: -1, OutputDir: t.TempDir(), Logger: rec},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.has(LogDebug, "🔗 Rewrote link") || !rec.has(LogDebug, "SUCCESS: Downloaded "+srv.URL+"/a") {
+		t.Errorf("Per-link messages must go to Debug, got %v", rec.lines[LogDebug])
+	}
+	if !rec.has(LogWarn, "HTTP error status 500 for "+srv.URL+"/broken") {
+		t.Errorf("HTTP errors must go to Warn, got %v", rec.lines[LogWarn])
+	}
+	if !rec.has(LogInfo, "New job started") {
+		t.Errorf("Job messages must go to Info, got %v", rec.lines[LogInfo])
+	}
+	// Найденные ссылки — одной строкой на страницу
+	if !rec.has(LogInfo, "Found 2 links (2 new) on "+srv.URL+"/") || !rec.has(LogInfo, "Found 1 links (0 new) on "+srv.URL+"/a") {
+		t.Errorf("Want one link summary per page, got %v", rec.lines[LogInfo])
+	}
+	for level := range rec.lines {
+		if rec.has(level, "NormalizeURL") || rec.has(level, "Resolved RAW link") {
+			t.Errorf("NormalizeURL must not log, got %v", rec.lines[level])
+		}
+	}
+
+	// По умолчанию — файл в OutputDir, строки ниже LogLevel не пишутся
+	out := t.TempDir()
+	_, err = Run(context.Background(), RunOptions{
+		URL:    srv.URL + "/",
+		Config: Config{Workers: 2, MaxDepth: 2, Retries: 1, DeferredRetries: -1, OutputDir: out, LogLevel: LogWarn},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, LogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "WARN HTTP error status 500") {
+		t.Errorf("Warnings missing from %s:\n%s", LogFileName, data)
+	}
+	if strings.Contains(string(data), "INFO") || strings.Contains(string(data), "DEBUG") {
+		t.Errorf("Lines below warn written to %s:\n%s", LogFileName, data)
+	}
+
+	if _, err := NewJob(srv.URL+"/", Config{OutputDir: t.TempDir(), LogLevel: "verbose"}); err == nil {
+		t.Error("Unknown log level must be rejected")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LogFileName)
+	os.WriteFile(path, []byte("old\n"), 0644)
+	r := &rotatingFile{path: path}
+
+	// Файл открывается один раз, размер считается с уже записанного
+	r.write("one\n")
+	f := r.f
+	r.write("two\n")
+	if f == nil || r.f != f || r.size != 12 {
+		t.Fatalf("File must stay open with size tracked, got f=%v size=%d", r.f, r.size)
+	}
+
+	// Строка сверх logRotateSize уводит файл в .1 и открывает новый
+	big := strings.Repeat("x", logRotateSize-5) + "\n"
+	r.write(big)
+	if r.f == f || r.size != int64(len(big)) {
+		t.Errorf("Rotation must reopen the file, size=%d", r.size)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "old\none\ntwo\n" {
+		t.Errorf("Rotated file = %q", data)
+	}
+	r.f.Close()
+}
+
+func TestSyntheticSiteGolden(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := sitetest.Serve(t, sitetest.Synthetic())
+	out := t.TempDir()
+	_, err := Run(context.Background(), RunOptions{
+		URL: srv.URL + "/",
+		Config: Config{Workers: 1, MaxDepth: 5, Retries: 1, DeferredRetries: -1, OutputDir: out,
+			Deterministic: true, Seed: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Раскладка и переписанные ссылки — байт в байт как в эталоне
+	sitetest.Golden(t, filepath.Join(out, strings.TrimPrefix(srv.URL, "http://")), filepath.Join("testdata", "golden", "synthetic"))
+}
+
+func TestVerifySite(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html": `<html><head><link rel="stylesheet" href="css/site.css">
+<style>body { background: url('/img/missing-bg.png') }</style></head><body>
+<a href="docs/">Docs</a> <a href="#top">Top</a> <a href="mailto:a@example.com">Mail</a>
+<a href="https://external.example.org/">External</a> <a href="//cdn.example.org/a.js">CDN</a>
+<img src="img/logo.png" srcset="img/logo.png 1x, img/logo@2x.png 2x">
+<a href="../../etc/passwd">Escape</a>
+</body></html>`,
+		"css/site.css":     `@import "theme.css"; body { background: url(../img/logo.png) } p { background: url("/img/gone.png") }`,
+		"css/theme.css":    `h1 { color: red }`,
+		"img/logo.png":     "png",
+		"docs/index.html":  `<a href="../index.html">Home</a> <a href="/guide/">Guide</a>`,
+		".meta/index.html": `<a href="nowhere.html">Skipped</a>`,
+		"guide/readme.txt": "no index",
+		"assets/app.js":    `fetch("/missing.json")`,
+		"docs/legacy.php":  `<meta http-equiv="refresh" content="0; url=moved.html">`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := VerifySite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VerifyPage{
+		{Page: "css/site.css", Problems: []VerifyProblem{{Ref: "/img/gone.png", Target: "img/gone.png", Reason: VerifyMissing}}},
+		{Page: "docs/index.html", Problems: []VerifyProblem{{Ref: "/guide/", Target: "guide/index.html", Reason: VerifyMissing}}},
+		{Page: "docs/legacy.php", Problems: []VerifyProblem{{Ref: "moved.html", Target: "docs/moved.html", Reason: VerifyMissing}}},
+		{Page: "index.html", Problems: []VerifyProblem{
+			{Ref: "/img/missing-bg.png", Target: "img/missing-bg.png", Reason: VerifyMissing},
+			{Ref: "img/logo@2x.png", Target: "img/logo@2x.png", Reason: VerifyMissing},
+			{Ref: "../../etc/passwd", Target: "../../etc/passwd", Reason: VerifyOutside},
+		}},
+	}
+	if !reflect.DeepEqual(report.Pages, want) {
+		t.Errorf("Pages = %+v, want %+v", report.Pages, want)
+	}
+	if report.Files != 5 || report.External != 2 || report.Problems() != 6 {
+		t.Errorf("Files=%d External=%d Problems=%d, want 5, 2, 6", report.Files, report.External, report.Problems())
+	}
+
+	// В скачанном эталонном сайте не работает только ссылка на 404
+	// (сохранена как 404.html); ссылка на редирект ведёт в new/
+	report, err = VerifySite(filepath.Join("testdata", "golden", "synthetic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []VerifyPage{{Page: "index.html", Problems: []VerifyProblem{
+		{Ref: "./missing/", Target: "missing", Reason: VerifyMissing},
+	}}}
+	if !reflect.DeepEqual(report.Pages, want) {
+		t.Errorf("Golden site pages = %+v, want %+v", report.Pages, want)
+	}
+
+	if _, err := VerifySite(filepath.Join(dir, "img", "logo.png")); err == nil {
+		t.Error("A file must be rejected as site directory")
+	}
+}
+
+func TestRunBatchSiteTurnKeepsSlotFree(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Первый сайт отвечает, только когда второй уже качается
+	otherStarted := make(chan struct{})
+	var once sync.Once
+	var starved atomic.Bool
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-otherStarted:
+		case <-time.After(3 * time.Second):
+			starved.Store(true)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>first</body></html>`)
+	}))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(otherStarted) })
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>second</body></html>`)
+	}))
+	defer second.Close()
+
+	// Второй корень первого сайта ждёт очереди, не занимая второй слот
+	results := RunBatch(context.Background(), BatchOptions{
+		URLs:     []string{first.URL + "/one/", first.URL + "/two/", second.URL + "/"},
+		Config:   Config{Workers: 2, MaxDepth: 1, Retries: 1, DeferredRetries: -1, OutputDir: t.TempDir()},
+		Parallel: 2,
+	})
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.URL, res.Err)
+		}
+	}
+	if starved.Load() {
+		t.Error("A root waiting for its site must not hold a Parallel slot")
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Запросы ко всем сайтам пакета: одновременно не больше Workers
+	var inFlight, maxInFlight int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="a.html">a</a><a href="b.html">b</a><a href="c.html">c</a></body></html>`)
+	})
+	first := httptest.NewServer(handler)
+	defer first.Close()
+	second := httptest.NewServer(handler)
+	defer second.Close()
+
+	list, err := ReadURLList(strings.NewReader("# разделы и сайты\n" + first.URL + "/docs/\n\n  " +
+		first.URL + "/blog/\n" + second.URL + "/\n" + first.URL + "/docs/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 {
+		t.Fatalf("ReadURLList = %v, want 4 URLs", list)
+	}
+	if _, err := ReadURLList(strings.NewReader("example.com\n")); err == nil {
+		t.Error("A line without scheme must be rejected")
+	}
+
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var done []string
+	results := RunBatch(context.Background(), BatchOptions{
+		URLs:     list,
+		Config:   Config{Workers: 2, MaxDepth: 2, Retries: 1, DeferredRetries: -1, OutputDir: dir},
+		Parallel: 2,
+		OnDone: func(res BatchResult) {
+			mu.Lock()
+			done = append(done, res.URL)
+			mu.Unlock()
+		},
+	})
+
+	if len(results) != 4 || len(done) != 4 {
+		t.Fatalf("Expected 4 results and 4 OnDone calls, got %d and %d", len(results), len(done))
+	}
+	for i, res := range results[:3] {
+		if res.URL != list[i] || res.Err != nil {
+			t.Errorf("Result %d = %s, %v", i, res.URL, res.Err)
+			continue
+		}
+		if res.Summary.Stats.TotalFiles < 4 {
+			t.Errorf("%s: %d files, want the root page and a, b, c", res.URL, res.Summary.Stats.TotalFiles)
+		}
+		// Каждая задача продолжается resume по своему URL
+		if state, err := FindJobState(dir, res.URL); err != nil || !strings.HasPrefix(filepath.Base(state), res.Summary.JobID) {
+			t.Errorf("%s: no state file of its own: %s, %v", res.URL, state, err)
+		}
+	}
+	if results[0].Summary.JobID == results[1].Summary.JobID {
+		t.Error("Sections of one site must be separate jobs")
+	}
+	if !errors.Is(results[3].Err, ErrJobActive) {
+		t.Errorf("Repeated root must be rejected, got %v", results[3].Err)
+	}
+	if m := atomic.LoadInt64(&maxInFlight); m > 2 {
+		t.Errorf("Up to %d requests at once, want the shared budget of 2", m)
+	}
+
+	// Строки лога помечены хостом задачи
+	data, err := os.ReadFile(filepath.Join(dir, LogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, srv := range []*httptest.Server{first, second} {
+		if tag := "[" + strings.TrimPrefix(srv.URL, "http://") + "] "; !strings.Contains(string(data), tag) {
+			t.Errorf("No lines tagged %s in %s", tag, LogFileName)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// newCookieJar — общая банка cookie всех воркеров: сессия, выданная
+// первым ответом (например, после редиректа с логина), живёт весь обход
+func newCookieJar() http.CookieJar {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil
+	}
+	return jar
+}
+
+// fileCookie — cookie из cookies.txt и хост, от имени которого её класть в банку
+type fileCookie struct {
+	host   string
+	cookie *http.Cookie
+}
+
+// parseCookiesTxt разбирает cookies.txt в формате Netscape (экспорт из
+// браузера, curl, yt-dlp). Строки с ошибками пропускаются и попадают
+// в warnings — из-за одной битой строки задача не падает.
+func parseCookiesTxt(data string, now time.Time) (cookies []fileCookie, warnings []string) {
+	sc := bufio.NewScanner(strings.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		f := strings.Split(line, "\t")
+		if len(f) != 7 {
+			warnings = append(warnings, fmt.Sprintf("строка %d: ожидалось 7 полей через TAB, получено %d", n, len(f)))
+			continue
+		}
+		domain, name := strings.TrimSpace(f[0]), strings.TrimSpace(f[5])
+		expires, err := strconv.ParseInt(strings.TrimSpace(f[4]), 10, 64)
+		if domain == "" || name == "" || err != nil {
+			warnings = append(warnings, fmt.Sprintf("строка %d: неверный домен, имя или срок", n))
+			continue
+		}
+
+		c := &http.Cookie{
+			Name:     name,
+			Value:    f[6],
+			Path:     f[2],
+			Secure:   strings.EqualFold(f[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+			if c.Expires.Before(now) {
+				continue // Просроченная — браузер её уже не отправляет
+			}
+		}
+		// TRUE — cookie для домена и поддоменов, FALSE — только для хоста
+		if strings.EqualFold(f[1], "TRUE") {
+			c.Domain = domain
+		}
+		cookies = append(cookies, fileCookie{host: strings.TrimPrefix(domain, "."), cookie: c})
+	}
+	return cookies, warnings
+}
+
+// cookieURL — адрес, от имени которого cookie кладётся в банку
+func cookieURL(host string, secure bool) *url.URL {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: "/"}
+}
+
+// loadCookies наполняет банку загрузчика: Cookie-заголовки из Config.Cookies
+// относятся к хосту задачи, cookies.txt — к своим доменам
+func (j *Job) loadCookies() {
+	jar := j.Downloader.client.Jar
+	if jar == nil || (len(j.Config.Cookies) == 0 && j.Config.CookieFile == "") {
+		return
+	}
+	loaded := 0
+
+	if root, err := url.Parse(j.RootURL); err == nil {
+		for i, raw := range j.Config.Cookies {
+			cookies, err := http.ParseCookie(raw)
+			if err != nil {
+				// Значение в лог не пишем: это секрет сессии
+				j.sendLog(fmt.Sprintf("[Warn] Cookie #%d пропущен: %v", i+1, err), false)
+				continue
+			}
+			for _, c := range cookies {
+				jar.SetCookies(cookieURL(root.Host, root.Scheme == "https"), []*http.Cookie{c})
+				loaded++
+			}
+		}
+	}
+
+	if j.Config.CookieFile != "" {
+		data, err := os.ReadFile(j.Config.CookieFile)
+		if err != nil {
+			j.sendLog(fmt.Sprintf("[Warn] Не удалось прочитать cookies.txt: %v", err), false)
+		}
+		cookies, warnings := parseCookiesTxt(string(data), time.Now())
+		for _, w := range warnings {
+			j.sendLog(fmt.Sprintf("[Warn] %s: %s", j.Config.CookieFile, w), false)
+		}
+		for _, c := range cookies {
+			jar.SetCookies(cookieURL(c.host, c.cookie.Secure), []*http.Cookie{c.cookie})
+			loaded++
+		}
+	}
+
+	if loaded > 0 {
+		j.sendLog(fmt.Sprintf("🍪 Загружено cookie: %d", loaded), false)
+	}
+}
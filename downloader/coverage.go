@@ -0,0 +1,295 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	CoverageExtension = ".coverage.json"
+
+	// DefaultSitemapBudget — сколько sitemap (вместе с вложенными в индекс) читаем
+	DefaultSitemapBudget = 50
+
+	// ReasonNotLinked — страницы из sitemap нет ни в одной скачанной странице
+	ReasonNotLinked = "not linked from crawled pages"
+
+	sitemapMaxSize = 50 << 20 // Лимит протокола sitemap — 50 МБ
+	sitemapTimeout = 2 * time.Minute
+)
+
+// CoverageReport — сверка скачанных страниц с sitemap сайта
+type CoverageReport struct {
+	RootURL     string       `json:"rootUrl"`
+	Sitemaps    []string     `json:"sitemaps"`   // Прочитанные sitemap
+	Listed      int          `json:"listed"`     // Страниц в sitemap
+	Downloaded  int          `json:"downloaded"` // Из них скачано
+	Percent     float64      `json:"percent"`
+	Missed      []MissedPage `json:"missed"`   // В sitemap, но не скачаны
+	Unlisted    []string     `json:"unlisted"` // Скачаны, но нет в sitemap
+	GeneratedAt time.Time    `json:"generatedAt"`
+}
+
+// MissedPage — страница из sitemap, которой нет в копии
+type MissedPage struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason,omitempty"` // Почему пропущена, если известно
+}
+
+// sitemapDoc подходит и для <urlset>, и для <sitemapindex>
+type sitemapDoc struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// fetchSitemaps читает sitemap (по умолчанию /sitemap.xml) и вложенные
+// в индексы. Возвращает прочитанные sitemap и все URL из них.
+func fetchSitemaps(ctx context.Context, cfg Config, root *url.URL, declared []string) (sitemaps, locs []string) {
+	queue := append([]string{}, declared...)
+	if len(queue) == 0 {
+		queue = []string{root.Scheme + "://" + root.Host + "/sitemap.xml"}
+	}
+	probe := NewProber(cfg, DefaultSitemapBudget)
+	probe.siteHost = newSiteHosts(root.Host, cfg).contains
+	seen := make(map[string]bool)
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		resp, err := probe.Get(ctx, s)
+		if errors.Is(err, ErrProbeBudget) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		var doc sitemapDoc
+		if resp.StatusCode == http.StatusOK {
+			err = xml.NewDecoder(io.LimitReader(resp.Body, sitemapMaxSize)).Decode(&doc)
+		} else {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+
+		sitemaps = append(sitemaps, s)
+		for _, sm := range doc.Sitemaps {
+			queue = append(queue, strings.TrimSpace(sm.Loc))
+		}
+		for _, u := range doc.URLs {
+			locs = append(locs, strings.TrimSpace(u.Loc))
+		}
+	}
+	return sitemaps, locs
+}
+
+// coverageKey — ключ для сверки: /about и /about/, http и https — одна страница
+func (j *Job) coverageKey(u string) string {
+	normalized, err := NormalizeURL(u)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(j.scheme.canonical(normalized), "/")
+}
+
+// noteSkip запоминает, почему URL не скачан (для отчёта о покрытии)
+func (j *Job) noteSkip(urlStr, reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.noteSkipLocked(urlStr, reason)
+}
+
+func (j *Job) noteSkipLocked(urlStr, reason string) {
+	if j.skipReasons == nil {
+		j.skipReasons = make(map[string]string)
+	}
+	j.skipReasons[urlStr] = reason
+}
+
+// missReason — причина, по которой страница из sitemap не попала в копию
+func (j *Job) missReason(u string) string {
+	normalized, err := NormalizeURL(u)
+	if err != nil {
+		return ""
+	}
+	normalized = j.scheme.canonical(normalized)
+
+	j.mu.Lock()
+	j.foldPanics()
+	reason, known := j.skipReasons[normalized]
+	if !known {
+		reason, known = j.skipReasons[slashVariant(normalized)]
+	}
+	visited := j.visited[normalized] || j.visited[slashVariant(normalized)]
+	j.mu.Unlock()
+
+	switch {
+	case known:
+		return reason
+	case !j.Filter.ShouldDownload(normalized):
+		return j.Filter.FilterReason(normalized)
+	case !visited:
+		return ReasonNotLinked
+	}
+	return ""
+}
+
+// buildCoverage сверяет манифест с sitemap; nil — sitemap не найден или пуст
+func (j *Job) buildCoverage() *CoverageReport {
+	root, err := url.Parse(j.RootURL)
+	if err != nil {
+		return nil
+	}
+	parent := j.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, sitemapTimeout)
+	defer cancel()
+
+	robots := j.robots
+	if robots == nil {
+		// Sitemap: из robots.txt нужен и без RespectRobots
+		robots, _ = fetchRobots(ctx, j.Config, root)
+	}
+	sitemaps, locs := fetchSitemaps(ctx, j.Config, root, robots.sitemaps)
+	if len(sitemaps) == 0 {
+		return nil
+	}
+
+	entries, err := LoadManifest(j.manifestFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil
+	}
+	downloaded := make(map[string]bool)
+	var pages []string
+	for _, e := range entries {
+		downloaded[j.coverageKey(e.URL)] = true
+		if e.AliasOf == "" && strings.Contains(e.ContentType, "text/html") {
+			pages = append(pages, e.URL)
+		}
+	}
+
+	report := &CoverageReport{
+		RootURL:     j.RootURL,
+		Sitemaps:    sitemaps,
+		Missed:      []MissedPage{},
+		Unlisted:    []string{},
+		GeneratedAt: j.now(),
+	}
+	listed := make(map[string]bool)
+	for _, loc := range locs {
+		u, err := url.Parse(loc)
+		if err != nil || u.Host != root.Host {
+			continue
+		}
+		key := j.coverageKey(loc)
+		if key == "" || listed[key] {
+			continue
+		}
+		listed[key] = true
+		report.Listed++
+		if downloaded[key] {
+			report.Downloaded++
+			continue
+		}
+		report.Missed = append(report.Missed, MissedPage{URL: j.scheme.canonical(loc), Reason: j.missReason(loc)})
+	}
+	if report.Listed == 0 {
+		return nil
+	}
+	report.Percent = float64(report.Downloaded) * 100 / float64(report.Listed)
+
+	for _, p := range pages {
+		if !listed[j.coverageKey(p)] {
+			report.Unlisted = append(report.Unlisted, p)
+		}
+	}
+	return report
+}
+
+// CoverageFile — путь к отчёту о покрытии sitemap
+func (j *Job) CoverageFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + CoverageExtension
+}
+
+// checkCoverage — шаг после обхода: сверка с sitemap и запись отчёта
+func (j *Job) checkCoverage() {
+	report := j.buildCoverage()
+	if report == nil {
+		return
+	}
+	j.coverage = report
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(j.CoverageFile(), data, 0644)
+	}
+	if err != nil {
+		j.sendLog(fmt.Sprintf("[Error] Не удалось записать отчёт о покрытии: %v", err), false)
+	}
+	j.sendLog(fmt.Sprintf("🗺 Покрытие sitemap: %.1f%% (%d из %d), пропущено %d, нет в sitemap %d",
+		report.Percent, report.Downloaded, report.Listed, len(report.Missed), len(report.Unlisted)), false)
+}
+
+// LoadCoverage читает отчёт о покрытии (<id>.coverage.json)
+func LoadCoverage(path string) (*CoverageReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report CoverageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// queueTargets ставит в очередь указанные URL (точечная докачка,
+// например пропущенных страниц из отчёта о покрытии). Ссылки с них не
+// обходятся; очередь прерванного обхода, если она была, идёт как обычно.
+// Уже посещённые URL скачиваются заново.
+func (j *Job) queueTargets(targets []string) {
+	queued := make(map[string]bool)
+	for _, t := range targets {
+		normalized, err := j.canonicalURL(t)
+		if err != nil {
+			continue
+		}
+		if u, err := url.Parse(normalized); err != nil || u.Host != j.scheme.host || queued[normalized] {
+			continue
+		}
+		queued[normalized] = true
+
+		j.mu.Lock()
+		j.visited[normalized] = true
+		j.trackDepth(normalized, 0)
+		j.unskipNoFollow(normalized)
+		j.mu.Unlock()
+
+		j.activeWG.Add(1)
+		j.enqueue(normalized, 0)
+	}
+	j.targets = queued
+	j.logger().Info("🎯 Точечная докачка: %d URL", len(queued))
+}
@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Дедупликация по содержимому (Config.DedupeContent). Один и тот же ассет
+// часто доступен по нескольким URL (/logo.png и /img/logo.png?v=2): вторая
+// копия не пишется, URL записывается алиасом первого файла, и ссылки на оба
+// адреса ведут в него. Страницы, переписанные до того, как повтор
+// обнаружился, уже ссылаются на путь второго URL — там остаётся жёсткая
+// ссылка на первый файл, места она не занимает. Страницы не
+// дедуплицируются: одинаковые байты по разным URL у них бывают законно
+// (пустые заглушки, страницы-редиректы).
+
+// dedupes — дедуплицировать ли файл этого типа
+func (j *Job) dedupes(urlStr, contentType string) bool {
+	return j.Config.DedupeContent && fileCategory(urlStr, contentType) != FileHTML
+}
+
+// duplicateOf — URL и путь уже сохранённого файла с тем же хешем
+func (j *Job) duplicateOf(hash string) (string, SavedPath, bool) {
+	j.mu.Lock()
+	first, ok := j.hashes[hash]
+	j.mu.Unlock()
+	if !ok {
+		return "", SavedPath{}, false
+	}
+	sp, ok := j.saved.lookup(first)
+	return first, sp, ok
+}
+
+// canAlias — можно ли заменить файл relPath (от папки хоста) алиасом sp.
+// В CSS и JS относительные ссылки (url(img/bg.png), import "./util.js")
+// считаются от самого файла: одинаковые байты в разных папках ведут на
+// разные ресурсы, поэтому такие файлы объединяются только в одной папке.
+func (j *Job) canAlias(urlStr, contentType, relPath string, sp SavedPath) bool {
+	switch fileCategory(urlStr, contentType) {
+	case FileCSS, FileJS:
+		return path.Dir(j.externalPrefix(urlStr)+relPath) == path.Dir(sp.Path)
+	}
+	return true
+}
+
+// rememberContent запоминает первый URL с этим содержимым
+func (j *Job) rememberContent(hash, urlStr string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.hashes[hash]; !ok {
+		j.hashes[hash] = urlStr
+	}
+}
+
+// linkDuplicate заменяет только что записанную копию жёсткой ссылкой на
+// первый файл (firstPath — от папки сайта, как в реестре). Не вышло
+// (FAT, другой диск) — копия остаётся: битая ссылка хуже лишних байт.
+func (j *Job) linkDuplicate(fullPath, firstPath string) {
+	siteDir, err := hostDir(j.Config.OutputDir, j.scheme.host)
+	if err != nil {
+		return
+	}
+	first := filepath.Join(siteDir, filepath.FromSlash(firstPath))
+	fi, err1 := os.Stat(fullPath)
+	ei, err2 := os.Stat(first)
+	if err1 != nil || err2 != nil || os.SameFile(fi, ei) {
+		return
+	}
+	// Как в общем кеше: через rename, чтобы файл не пропадал ни на миг
+	tmp := fullPath + ".link"
+	os.Remove(tmp)
+	if err := os.Link(first, tmp); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// recordDuplicate делает urlStr алиасом уже сохранённого first
+func (j *Job) recordDuplicate(urlStr, first string, sp SavedPath, contentType, hash string, size int64, depth int) {
+	j.saved.record(urlStr, sp.Path)
+	j.appendManifest(ManifestEntry{
+		URL:         urlStr,
+		Path:        sp.Path,
+		Strategy:    sp.Strategy,
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hash,
+		Depth:       depth,
+		AliasOf:     first,
+		SavedAt:     j.now(),
+	})
+	atomic.AddInt64(&j.stats.Deduplicated, 1)
+	atomic.AddInt64(&j.stats.DownloadedBytes, size)
+	j.sendLog(fmt.Sprintf("[Dedup] %s is identical to %s", urlStr, first), false)
+}
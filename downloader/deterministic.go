@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Детерминированный режим (Config.Deterministic) — только для тестов
+// и записи/воспроизведения обхода. Джиттер повторов берётся из RNG
+// с Config.Seed, ссылки страницы ставятся в очередь в отсортированном
+// порядке, время в манифесте и статистике — из Config.Clock (без него —
+// фиксированная deterministicEpoch). Выключенный режим ничего не меняет:
+// глобальный rand, порядок из разметки и time.Now, как раньше.
+
+// deterministicEpoch — «текущее время» детерминированного режима без Clock
+var deterministicEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// now — текущее время для манифеста и статистики
+func (c Config) now() time.Time {
+	switch {
+	case c.Clock != nil:
+		return c.Clock()
+	case c.Deterministic:
+		return deterministicEpoch
+	}
+	return time.Now()
+}
+
+func (j *Job) now() time.Time {
+	return j.Config.now()
+}
+
+// newRetryRand — RNG джиттера повторов; nil — глобальный rand
+func newRetryRand(c Config) *rand.Rand {
+	if !c.Deterministic {
+		return nil
+	}
+	return rand.New(rand.NewSource(c.Seed))
+}
+
+// retryPause — пауза перед n-м повтором: полный джиттер, случайная
+// в [0, retryCeiling(Delay, n)]
+func (d *Downloader) retryPause(n int) time.Duration {
+	ceiling := int64(retryCeiling(d.delay, n))
+	if d.rng == nil {
+		return time.Duration(rand.Int63n(ceiling + 1))
+	}
+	d.rngMu.Lock()
+	defer d.rngMu.Unlock()
+	return time.Duration(d.rng.Int63n(ceiling + 1))
+}
+
+// queueOrder — ссылки страницы в порядке постановки в очередь
+func (j *Job) queueOrder(links []string) []string {
+	if !j.Config.Deterministic {
+		return links
+	}
+	sorted := append([]string(nil), links...)
+	sort.Strings(sorted)
+	return sorted
+}
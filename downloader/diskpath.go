@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DiskPath — единственное правило, по которому URL превращается в путь
+// внутри каталога хоста (через "/"). Им пользуются сохранение, переписывание
+// ссылок и манифест; contentType пустой, если ответ ещё не получен.
+//
+//	/                -> index.html
+//	/docs/, /docs    -> docs/index.html (страница без расширения — папка)
+//	/app.js          -> app.js (имя с расширением сохраняется как есть)
+//	/app.css?lang=ru -> app.css@lang=ru.css (query ассета — в имени, см. queries.go)
+//	/api/data        -> api/data, если ответ не HTML
+//	/a:b/con         -> a%3Ab/con_/index.html (имена, недопустимые в Windows, см. sanitize.go)
+func DiskPath(u *url.URL, contentType string) string {
+	return SanitizePath(diskPath(u, contentType))
+}
+
+func diskPath(u *url.URL, contentType string) string {
+	p := path.Clean("/" + u.Path)
+	if p == "/" {
+		return "index.html"
+	}
+	// Убираем начальный слэш, чтобы filepath.Join не считал путь абсолютным
+	p = strings.TrimPrefix(p, "/")
+
+	// Есть точка в последнем сегменте и нет слэша в конце — это файл
+	if !strings.HasSuffix(u.Path, "/") && strings.Contains(path.Base(p), ".") {
+		if queryInName(u) {
+			return withQuery(p, u.RawQuery)
+		}
+		return p
+	}
+
+	// Не-HTML ответ по "красивому" URL (/api/users) сохраняем как файл,
+	// а не как users/index.html — иначе путь API станет непригодным
+	if contentType != "" && !strings.Contains(contentType, "text/html") && !strings.HasSuffix(u.Path, "/") {
+		return p
+	}
+
+	// /page.php/ — та же страница, что и /page.php, но уже как HTML
+	if strings.HasSuffix(strings.ToLower(p), ".php") {
+		return p[:len(p)-len(".php")] + ".html"
+	}
+	return path.Join(p, "index.html")
+}
+
+// savePath — DiskPath (с именем вложения filename, если оно есть) с учётом
+// того, что уже лежит на диске: если на месте
+// файла папка (сначала скачан /about/, потом /about), страница идёт в её
+// index.html, а ассет — рядом под conflictPath. Так же путь выбирает saveFile.
+func savePath(siteDir string, u *url.URL, contentType, filename string) string {
+	rel := namedDiskPath(u, contentType, filename)
+	if siteDir == "" {
+		return rel
+	}
+	if fi, err := os.Stat(filepath.Join(siteDir, filepath.FromSlash(rel))); err == nil && fi.IsDir() {
+		return conflictPath(rel)
+	}
+	return rel
+}
+
+// EntryPath возвращает стартовую страницу сайта из манифестов, лежащих рядом
+// с папкой сайта: путь, под которым сохранён URL глубины 0. Для папки
+// обработанной копии (<host>_processed) .php-страница ищется как .html.
+func EntryPath(siteDir string) (string, bool) {
+	host := strings.TrimSuffix(filepath.Base(siteDir), "_processed")
+	manifests, _ := filepath.Glob(filepath.Join(filepath.Dir(siteDir), "*"+ManifestExtension))
+	for _, m := range manifests {
+		entries, err := LoadManifest(m)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.Depth != 0 || e.AliasOf != "" || e.Path == "" || e.Path == ErrorPageName {
+				continue
+			}
+			if u, err := url.Parse(e.URL); err != nil || u.Host != host {
+				continue
+			}
+			candidates := []string{e.Path}
+			if strings.HasSuffix(e.Path, ".php") {
+				candidates = append(candidates, strings.TrimSuffix(e.Path, ".php")+".html")
+			}
+			for _, rel := range candidates {
+				if fi, err := os.Stat(filepath.Join(siteDir, filepath.FromSlash(rel))); err == nil && !fi.IsDir() {
+					return rel, true
+				}
+			}
+		}
+	}
+	return "", false
+}
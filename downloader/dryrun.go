@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Пробный прогон (Config.DryRun): обход идёт как обычно — страницы и CSS
+// скачиваются и разбираются ради ссылок, — но на диск ничего не пишется,
+// кроме отчёта <id>.dryrun.json: что было бы скачано (с размерами) и
+// какие ссылки отброшены фильтром и почему. Ассетам, в которых ссылок не
+// ищут, хватает HEAD: размер берётся из Content-Length. Состояние прошлых
+// запусков не загружается и не сохраняется.
+
+// DryRunExtension — отчёт пробного прогона рядом с файлами состояния
+const DryRunExtension = ".dryrun.json"
+
+// dryRunProbeBudget — HEAD-запросов на пробный прогон: по одному на
+// ассет, лимит Prober'а тут не нужен
+const dryRunProbeBudget = 1 << 30
+
+// DryRunFile — URL, который скачала бы настоящая загрузка
+type DryRunFile struct {
+	URL         string `json:"url"`
+	ContentType string `json:"contentType,omitempty"`
+	Type        string `json:"type"` // Категория: html, css, js, image, font, video, other
+	Size        int64  `json:"size"` // -1 — сервер не сообщил размер
+	Depth       int    `json:"depth"`
+}
+
+// DryRunFiltered — ссылка, которую обход не стал бы качать
+type DryRunFiltered struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"` // FilterReason
+}
+
+// DryRunTotal — итог по одной категории файлов
+type DryRunTotal struct {
+	Files       int64 `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	UnknownSize int64 `json:"unknownSize,omitempty"` // Файлов без известного размера
+}
+
+// DryRunReport — отчёт пробного прогона; списки отсортированы по URL
+type DryRunReport struct {
+	RootURL    string                 `json:"rootUrl"`
+	TotalFiles int64                  `json:"totalFiles"`
+	TotalBytes int64                  `json:"totalBytes"`
+	ByType     map[string]DryRunTotal `json:"byType"`
+	Files      []DryRunFile           `json:"files"`
+	Filtered   []DryRunFiltered       `json:"filtered"`
+}
+
+// DryRunFile — путь к отчёту пробного прогона задачи
+func (j *Job) DryRunFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + DryRunExtension
+}
+
+// dryRunLog копит отчёт, пока воркеры обходят сайт
+type dryRunLog struct {
+	mu       sync.Mutex
+	files    map[string]DryRunFile
+	filtered map[string]string
+}
+
+func newDryRunLog() *dryRunLog {
+	return &dryRunLog{files: make(map[string]DryRunFile), filtered: make(map[string]string)}
+}
+
+func (l *dryRunLog) file(f DryRunFile) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[f.URL] = f
+}
+
+// filter запоминает первую причину, по которой URL отброшен
+func (l *dryRunLog) filter(u, reason string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.filtered[u]; !ok {
+		l.filtered[u] = reason
+	}
+}
+
+// report собирает отчёт; URL, который в итоге скачан, из отброшенных убирается
+func (l *dryRunLog) report(root string) DryRunReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := DryRunReport{RootURL: root, ByType: make(map[string]DryRunTotal)}
+	for _, f := range l.files {
+		r.Files = append(r.Files, f)
+		t := r.ByType[f.Type]
+		t.Files++
+		if f.Size < 0 {
+			t.UnknownSize++
+		} else {
+			t.Bytes += f.Size
+			r.TotalBytes += f.Size
+		}
+		r.ByType[f.Type] = t
+	}
+	r.TotalFiles = int64(len(r.Files))
+	for u, reason := range l.filtered {
+		if _, ok := l.files[u]; !ok {
+			r.Filtered = append(r.Filtered, DryRunFiltered{URL: u, Reason: reason})
+		}
+	}
+	sort.Slice(r.Files, func(a, b int) bool { return r.Files[a].URL < r.Files[b].URL })
+	sort.Slice(r.Filtered, func(a, b int) bool { return r.Filtered[a].URL < r.Filtered[b].URL })
+	return r
+}
+
+// dryRunHeadOnly — URL, которому в пробном прогоне хватит HEAD: ассет,
+// в котором ссылок не ищут
+func (j *Job) dryRunHeadOnly(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || !isAssetPath(strings.ToLower(parsed.Path)) {
+		return false
+	}
+	switch strings.ToLower(path.Ext(parsed.Path)) {
+	case ".css", ".svg":
+		return false
+	case ".js", ".mjs", ".json", ".map":
+		return !j.Config.ParseJavaScript
+	}
+	return true
+}
+
+// dryRunHead узнаёт размер ассета HEAD-запросом. false — сервер HEAD не
+// ответил как следует, URL пойдёт обычным GET.
+func (j *Job) dryRunHead(workerID int, urlStr string, depth int) bool {
+	resp, err := j.prober.Head(j.ctx, urlStr)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return false
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if limit := j.Downloader.sizes.limitFor(contentType); resp.ContentLength > limit {
+		reason := fmt.Sprintf("%v: %d bytes", ErrTooLarge, resp.ContentLength)
+		j.sendLog(fmt.Sprintf("[Skip] Too large: %s (%s)", urlStr, reason), false)
+		atomic.AddInt64(&j.stats.Skipped, 1)
+		j.dryRun.filter(urlStr, reason)
+		return true
+	}
+	j.dryRunOnly(workerID, urlStr, urlStr, nil, resp.ContentLength, contentType, resp.Header, depth)
+	return true
+}
+
+// dryRunOnly завершает URL в пробном прогоне: вместо сохранения — строка
+// отчёта, статистика и события как обычно, ссылки обходятся
+func (j *Job) dryRunOnly(workerID int, requestedURL, urlStr string, content []byte, size int64, contentType string, header http.Header, depth int) {
+	category := fileCategory(urlStr, contentType)
+	j.dryRun.file(DryRunFile{URL: urlStr, ContentType: contentType, Type: category, Size: size, Depth: depth})
+	j.noteCompleted(requestedURL, urlStr)
+	atomic.AddInt64(&j.stats.TotalFiles, 1)
+	recovered := j.deferred.deferred(requestedURL)
+	if recovered {
+		atomic.AddInt64(&j.stats.Recovered, 1)
+	}
+	atomic.AddInt64(&j.stats.DownloadedBytes, int64(len(content)))
+	j.mu.Lock()
+	j.stats.FileTypes[category]++
+	j.mu.Unlock()
+	j.sendLog(fmt.Sprintf("[DryRun] Would save: %s", urlStr), false)
+	j.fileDone(FileResult{
+		URL:         urlStr,
+		ContentType: contentType,
+		Size:        size,
+		Depth:       depth,
+		Recovered:   recovered,
+	})
+
+	if content != nil && depth < j.Config.MaxDepth {
+		j.workers.phase(workerID, PhaseParsing)
+		j.queueLinks(j.parseLinks(content, contentType, urlStr, header), depth, urlStr)
+	}
+}
+
+// finishDryRun пишет отчёт и сводку в лог
+func (j *Job) finishDryRun() {
+	r := j.dryRun.report(j.RootURL)
+	j.dryRunReport = &r
+
+	var types []string
+	for _, t := range fileTypeOrder {
+		if total, ok := r.ByType[t.key]; ok {
+			types = append(types, fmt.Sprintf("%s: %d (%.1f MB)", t.label, total.Files, megabytes(total.Bytes)))
+		}
+	}
+	j.sendLog(fmt.Sprintf("🧪 Пробный прогон: ~%d файлов, ~%.1f MB; отброшено ссылок: %d",
+		r.TotalFiles, megabytes(r.TotalBytes), len(r.Filtered)), false)
+	if len(types) > 0 {
+		j.sendLog("📊 По типам: "+strings.Join(types, ", "), false)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(j.DryRunFile()), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(j.DryRunFile(), data, 0644)
+	}
+	if err != nil {
+		j.logger().Error("Не удалось записать отчёт пробного прогона: %v", err)
+		return
+	}
+	j.sendLog("📝 Отчёт: "+j.DryRunFile(), false)
+}
+
+func megabytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
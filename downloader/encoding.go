@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Сжатие ответов. Accept-Encoding выставляем сами, поэтому net/http
+// больше не распаковывает gzip за нас: тело распаковывает decodeBody
+// по Content-Encoding — и то, что мы просили, и то, что сервер прислал
+// сжатым без спроса. Лимиты размера считаются по распакованным байтам.
+
+// acceptEncoding — сжатия, которые умеет decodeBody
+const acceptEncoding = "gzip, deflate, br"
+
+// ErrUnsupportedEncoding — Content-Encoding, который не распаковать (zstd и т.п.)
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// gzipMagic — начало gzip-потока: по нему узнаём сжатый файл без Content-Encoding
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// contentEncodings — сжатия из Content-Encoding в порядке применения; identity не в счёт
+func contentEncodings(h http.Header) []string {
+	var encs []string
+	for _, v := range h.Values("Content-Encoding") {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e != "" && e != "identity" {
+				encs = append(encs, e)
+			}
+		}
+	}
+	return encs
+}
+
+// decodeBody оборачивает тело распаковщиками: сжатия снимаются в
+// порядке, обратном применению
+func decodeBody(body io.Reader, encodings []string) (io.Reader, error) {
+	r := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encodings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s body: %w", encodings[i], err)
+		}
+	}
+	return r, nil
+}
+
+// newDeflateReader — "deflate" по RFC это zlib, но часть серверов шлёт
+// голый deflate без заголовка; различаем по первым двум байтам
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// gunzipStray распаковывает текст, сжатый gzip без Content-Encoding
+// (page.html.gz, отданный как text/html). ok=false — содержимое не gzip
+// или не распаковалось в пределах limit.
+func gunzipStray(content []byte, limit int64) ([]byte, bool) {
+	if !bytes.HasPrefix(content, gzipMagic) {
+		return nil, false
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, false
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil || int64(len(out)) > limit {
+		return nil, false
+	}
+	return out, true
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Страница ошибки сайта. Её можно встретить двумя путями: адрес вроде
+// /404 отвечает 200 и оформленной страницей, или сервер отвечает 404
+// с телом. В обоих случаях страница сохраняется один раз, как 404.html
+// в корне хоста — статический хостинг (Netlify, GitHub Pages) отдаёт
+// её на любой несуществующий адрес, — а не как 404/index.html. Ссылки
+// с неё не обходятся: качаются только картинки, CSS и скрипты самой
+// страницы. URL, ответивший 404, при этом остаётся битой ссылкой.
+
+// ErrorPageName — имя страницы ошибки в корне хоста
+const ErrorPageName = "404.html"
+
+// errorPageLimit — сколько байт тела 404 читаем ради страницы ошибки
+const errorPageLimit = 1 << 20
+
+// errorRoutes — пути, по которым сайты обычно держат страницу ошибки
+var errorRoutes = map[string]bool{
+	"/404": true, "/404.html": true, "/404.htm": true,
+	"/not-found": true, "/notfound": true, "/page-not-found": true,
+}
+
+// isErrorRoute — URL известного адреса страницы ошибки
+func isErrorRoute(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	p := strings.ToLower(path.Clean("/" + parsed.Path))
+	return errorRoutes[p]
+}
+
+// errorPageURL — URL, под которым страница ошибки хоста u сохраняется
+func errorPageURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/" + ErrorPageName}).String()
+}
+
+// readErrorPage читает тело ответа 404, если это HTML
+func readErrorPage(resp *http.Response) FetchResult {
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "text/html") {
+		return FetchResult{}
+	}
+	body, err := decodeBody(resp.Body, contentEncodings(resp.Header))
+	if err != nil {
+		return FetchResult{}
+	}
+	content, err := io.ReadAll(io.LimitReader(body, errorPageLimit))
+	if err != nil || len(content) == 0 {
+		return FetchResult{}
+	}
+	return FetchResult{
+		Content:     content,
+		ContentType: contentType,
+		FinalURL:    resp.Request.URL.String(),
+		Status:      resp.StatusCode,
+		Header:      resp.Header,
+	}
+}
+
+// saveErrorPage сохраняет тело 404 со страницы urlStr как страницу
+// ошибки хоста — первое за задачу и только у хоста задачи
+func (j *Job) saveErrorPage(urlStr string, res FetchResult, depth int) {
+	if len(res.Content) == 0 || j.dryRun != nil || !j.Config.writesTree() || j.foreignHost(urlStr) {
+		return
+	}
+	target := errorPageURL(urlStr)
+	if !j.errorPageTaken.CompareAndSwap(false, true) {
+		return
+	}
+	if _, ok := j.saved.lookup(target); ok {
+		return
+	}
+
+	content, contentType := res.Content, res.ContentType
+	if converted, from := HTMLToUTF8(content, contentType); from != "" {
+		content, contentType = converted, mediaType(contentType)+"; charset=utf-8"
+	}
+	hash := ContentHash(content)
+	meta := FileMetadata{URL: target, ContentType: contentType, Hash: hash, Depth: depth}
+	modified := content
+	for _, handler := range j.sortedHandlers() {
+		if m, err := handler.Handle(modified, meta); err == nil {
+			modified = m
+		}
+	}
+	relPath, _, err := saveFile(j.Config.OutputDir, target, modified, contentType)
+	if err != nil {
+		j.sendLog(fmt.Sprintf("[Error] Save failed for %s: %v", target, err), false)
+		return
+	}
+	saved := j.saved.record(target, relPath)
+	j.appendManifest(ManifestEntry{
+		URL:         target,
+		Path:        relPath,
+		Strategy:    saved.Strategy,
+		ContentType: contentType,
+		Size:        int64(len(modified)),
+		Hash:        hash,
+		Depth:       depth,
+		SavedAt:     j.now(),
+	})
+	j.sendLog(fmt.Sprintf("[Info] Страница 404 сайта (%s) сохранена как %s", urlStr, relPath), false)
+
+	if depth < j.Config.MaxDepth {
+		j.queueLinks(pageResources(content, urlStr), depth, urlStr)
+	}
+}
@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JobPhase — этап задачи в целом (не путать с фазой отдельного воркера)
+type JobPhase string
+
+const (
+	// Новые URL ещё находятся: общее число растёт, ETA ненадёжен
+	JobDiscovering JobPhase = "discovery"
+	// Новых URL нет DiscoveryQuiet — осталось докачать очередь
+	JobDownloading JobPhase = "downloading"
+)
+
+const (
+	DefaultDiscoveryQuiet = 10 * time.Second
+
+	// ETA считаем, когда находка новых URL медленнее этой доли скорости
+	// скачивания — очередь уже заметно убывает
+	discoverySlowRatio = 0.2
+	// Сглаживание скоростей (EWMA)
+	rateSmoothing = 0.3
+)
+
+// PhaseListener — необязательное расширение ProgressListener: смена этапа задачи
+type PhaseListener interface {
+	OnPhase(JobPhase)
+}
+
+// progressTracker ведёт двухфазную модель прогресса: скорость обнаружения
+// и скорость скачивания считаются отдельно, ETA — по остатку очереди
+// и сглаженной скорости скачивания. Скорость в байтах тоже сглаженная,
+// а не среднее с начала задачи: после паузы или медленного старта она
+// быстро догоняет текущую.
+type progressTracker struct {
+	mu    sync.Mutex
+	quiet time.Duration
+	phase JobPhase
+
+	discovered    int64
+	completed     int64
+	lastDiscovery time.Time
+
+	lastTick      time.Time
+	prevDisc      int64
+	prevDone      int64
+	prevBytes     int64
+	discoveryRate float64 // URL в секунду
+	downloadRate  float64
+	byteRate      float64 // Байт в секунду
+}
+
+// newProgressTracker: initial — URL уже в очереди, bytes — уже скачано
+// (при resume), чтобы первый тик не принял их за скорость
+func newProgressTracker(quiet time.Duration, initial, bytes int64) *progressTracker {
+	if quiet <= 0 {
+		quiet = DefaultDiscoveryQuiet
+	}
+	now := time.Now()
+	return &progressTracker{
+		quiet:         quiet,
+		phase:         JobDiscovering,
+		discovered:    initial,
+		prevDisc:      initial,
+		prevBytes:     bytes,
+		lastDiscovery: now,
+		lastTick:      now,
+	}
+}
+
+// discover — найден новый URL
+func (t *progressTracker) discover() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.discovered++
+	t.lastDiscovery = time.Now()
+	t.mu.Unlock()
+}
+
+// requeue — URL снова в очереди (отложенный повтор), это не новая находка
+func (t *progressTracker) requeue(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.discovered += int64(n)
+	t.prevDisc += int64(n)
+	t.mu.Unlock()
+}
+
+// complete — воркер закончил с URL (успешно или нет)
+func (t *progressTracker) complete() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.completed++
+	t.mu.Unlock()
+}
+
+// tick обновляет скорости и заполняет поля прогресса в snap
+// (snap.Bytes уже заполнен — по нему считается Speed).
+// changed — только что закончилось обнаружение (событие шлётся один раз).
+func (t *progressTracker) tick(now time.Time, snap *Snapshot) (changed bool) {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if dt := now.Sub(t.lastTick).Seconds(); dt > 0 {
+		t.discoveryRate = smooth(t.discoveryRate, float64(t.discovered-t.prevDisc)/dt)
+		t.downloadRate = smooth(t.downloadRate, float64(t.completed-t.prevDone)/dt)
+		t.byteRate = smooth(t.byteRate, float64(snap.Bytes-t.prevBytes)/dt)
+		t.prevDisc, t.prevDone, t.prevBytes, t.lastTick = t.discovered, t.completed, snap.Bytes, now
+	}
+
+	if t.phase == JobDiscovering && now.Sub(t.lastDiscovery) >= t.quiet {
+		t.phase = JobDownloading
+		changed = true
+	}
+
+	remaining := t.discovered - t.completed
+	if remaining < 0 {
+		remaining = 0
+	}
+	var eta time.Duration
+	slowed := t.phase == JobDownloading || t.discoveryRate < t.downloadRate*discoverySlowRatio
+	if slowed && t.downloadRate > 0 {
+		eta = time.Duration(float64(remaining) / t.downloadRate * float64(time.Second))
+	}
+
+	snap.Phase = t.phase
+	snap.Discovered = t.discovered
+	snap.Completed = t.completed
+	snap.DiscoveryRate = t.discoveryRate
+	snap.DownloadRate = t.downloadRate
+	snap.Speed = t.byteRate
+	snap.ETA = eta
+	return changed
+}
+
+// hold — тик на паузе: время паузы не считается ни в скорости, ни в тишине
+// обнаружения, а snap получает фазу JobPaused без ETA
+func (t *progressTracker) hold(now time.Time, snap *Snapshot) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastDiscovery = t.lastDiscovery.Add(now.Sub(t.lastTick))
+	t.prevDisc, t.prevDone, t.prevBytes, t.lastTick = t.discovered, t.completed, snap.Bytes, now
+
+	snap.Phase = JobPaused
+	snap.Speed = 0
+	snap.Discovered = t.discovered
+	snap.Completed = t.completed
+}
+
+// currentPhase — этап без учёта паузы
+func (t *progressTracker) currentPhase() JobPhase {
+	if t == nil {
+		return JobDiscovering
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.phase
+}
+
+func smooth(prev, sample float64) float64 {
+	if prev == 0 {
+		return sample
+	}
+	return prev + rateSmoothing*(sample-prev)
+}
+
+// FormatETA — строка прогресса для лога и CLI: этап, обработано/найдено и ETA
+func FormatETA(s Snapshot) string {
+	eta := "ETA: —"
+	if s.ETA > 0 {
+		eta = "ETA: " + s.ETA.Round(time.Second).String()
+	}
+	phase := "обход"
+	switch s.Phase {
+	case JobDownloading:
+		phase = "докачка"
+	case JobPaused:
+		phase = "пауза"
+	}
+	return fmt.Sprintf("%s %d/%d | %s", phase, s.Completed, s.Discovered, eta)
+}
@@ -0,0 +1,447 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	eventBufferSize  = 1000
+	listenerLogLimit = 1000 // Сколько строк лога может ждать у медленного подписчика
+)
+
+// FileResult — файл скачан и сохранён
+type FileResult struct {
+	URL         string
+	Path        string // Относительно OutputDir; пусто, если ответ только в WARC-архиве
+	ContentType string
+	Size        int64
+	Depth       int
+	Recovered   bool // Скачан после отложенного повтора
+}
+
+// Snapshot — срез прогресса, отправляется раз в секунду
+type Snapshot struct {
+	Files    int64
+	Bytes    int64
+	Failed   int64
+	Skipped  int64
+	Speed    float64 // Байт в секунду
+	Queued   int
+	Overflow int
+	Elapsed  time.Duration
+
+	// Двухфазная модель: пока идёт обнаружение, Discovered растёт и ETA
+	// считается только если находки заметно медленнее скачивания.
+	// ETA == 0 — оценки пока нет.
+	Phase         JobPhase
+	Discovered    int64
+	Completed     int64
+	DiscoveryRate float64 // Новых URL в секунду
+	DownloadRate  float64 // Обработанных URL в секунду
+	ETA           time.Duration
+
+	// Message — та же сводка, что уходит строкой в лог; может быть пустой
+	Message string
+}
+
+// JobProgress — прогресс для Job.Progress: числа вместо строк лога,
+// разбирать «Файлов: …» больше не нужно
+type JobProgress struct {
+	TotalFiles      int64
+	DownloadedBytes int64
+	QueueLength     int // В памяти и в файле переполнения
+	Failed          int64
+	Speed           float64       // Байт в секунду
+	ETA             time.Duration // 0 — оценки пока нет
+	Message         string
+}
+
+// Progress переводит снимок в JobProgress
+func (s Snapshot) Progress() JobProgress {
+	return JobProgress{
+		TotalFiles:      s.Files,
+		DownloadedBytes: s.Bytes,
+		QueueLength:     s.Queued + s.Overflow,
+		Failed:          s.Failed,
+		Speed:           s.Speed,
+		ETA:             s.ETA,
+		Message:         s.Message,
+	}
+}
+
+// ErrorEvent — URL не скачан. Err оборачивает ErrTooLarge, ErrHostDown и т.п.
+type ErrorEvent struct {
+	URL      string
+	Err      error
+	Retrying bool // Отложен и будет повторён в этом же запуске
+}
+
+// ProgressListener получает события задачи. Вызовы одного слушателя идут
+// по очереди из его собственной горутины, так что медленный слушатель
+// не задерживает остальных: непрочитанные OnProgress схлопываются
+// до последнего, а OnFileDone, OnError и OnComplete не теряются.
+type ProgressListener interface {
+	OnFileDone(FileResult)
+	OnProgress(Snapshot)
+	OnError(ErrorEvent)
+	OnComplete(Summary)
+}
+
+// LogListener — необязательное расширение ProgressListener: сырые строки лога,
+// те же, что приходят в Job.Events
+type LogListener interface {
+	OnLog(msg string)
+}
+
+type eventKind int
+
+const (
+	eventLog eventKind = iota
+	eventFile
+	eventProgress
+	eventError
+	eventComplete
+	eventPhase
+	eventBackoff
+	eventBudget
+)
+
+type event struct {
+	kind    eventKind
+	msg     string
+	file    FileResult
+	snap    Snapshot
+	err     ErrorEvent
+	sum     Summary
+	phase   JobPhase
+	backoff BackoffEvent
+	budget  BudgetEvent
+}
+
+// listenerQueue — почтовый ящик одного подписчика со своей горутиной доставки
+type listenerQueue struct {
+	l      ProgressListener
+	onDone func()
+
+	mu       sync.Mutex
+	queue    []*event
+	progress *event // Ещё не доставленный OnProgress — новый снимок заменяет его
+	logs     int
+	closed   bool
+	wake     chan struct{}
+}
+
+func newListenerQueue(l ProgressListener) *listenerQueue {
+	return &listenerQueue{l: l, wake: make(chan struct{}, 1)}
+}
+
+func (q *listenerQueue) push(ev *event) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	switch ev.kind {
+	case eventProgress:
+		if q.progress != nil {
+			q.progress.snap = ev.snap
+			q.mu.Unlock()
+			return
+		}
+		// Событие общее для всех подписчиков — схлопываем в своей копии
+		cp := *ev
+		ev = &cp
+		q.progress = ev
+	case eventLog:
+		if _, ok := q.l.(LogListener); !ok || q.logs >= listenerLogLimit {
+			q.mu.Unlock()
+			return
+		}
+		q.logs++
+	}
+	q.queue = append(q.queue, ev)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *listenerQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish — новых событий не будет; уже принятые доставляются
+func (q *listenerQueue) finish() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *listenerQueue) run() {
+	if q.onDone != nil {
+		defer q.onDone()
+	}
+	for {
+		q.mu.Lock()
+		for len(q.queue) == 0 && !q.closed {
+			q.mu.Unlock()
+			<-q.wake
+			q.mu.Lock()
+		}
+		if len(q.queue) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		ev := q.queue[0]
+		q.queue[0] = nil
+		q.queue = q.queue[1:]
+		if ev == q.progress {
+			q.progress = nil
+		}
+		if ev.kind == eventLog {
+			q.logs--
+		}
+		// Доставляем копию: после Unlock push обновляет снимок в q.progress
+		e := *ev
+		q.mu.Unlock()
+
+		q.deliver(&e)
+	}
+}
+
+func (q *listenerQueue) deliver(e *event) {
+	switch e.kind {
+	case eventLog:
+		q.l.(LogListener).OnLog(e.msg)
+	case eventFile:
+		q.l.OnFileDone(e.file)
+	case eventProgress:
+		q.l.OnProgress(e.snap)
+	case eventError:
+		q.l.OnError(e.err)
+	case eventComplete:
+		q.l.OnComplete(e.sum)
+	case eventPhase:
+		if pl, ok := q.l.(PhaseListener); ok {
+			pl.OnPhase(e.phase)
+		}
+	case eventBackoff:
+		if bl, ok := q.l.(BackoffListener); ok {
+			bl.OnBackoff(e.backoff)
+		}
+	case eventBudget:
+		if bl, ok := q.l.(BudgetListener); ok {
+			bl.OnBudgetReached(e.budget)
+		}
+	}
+}
+
+// channelListener — встроенный подписчик, который отдаёт лог в Job.Events.
+// Events читают не все: если буфер полон, самая старая строка уступает
+// место новой, как у progressChannel, и доставка не встаёт навсегда.
+type channelListener struct {
+	out chan string
+}
+
+func (c *channelListener) OnLog(msg string) {
+	for {
+		select {
+		case c.out <- msg:
+			return
+		default:
+		}
+		// Пишет только горутина доставки, так что место освободится
+		select {
+		case <-c.out:
+		default:
+		}
+	}
+}
+
+func (c *channelListener) OnFileDone(FileResult) {}
+func (c *channelListener) OnProgress(Snapshot)   {}
+func (c *channelListener) OnError(ErrorEvent)    {}
+func (c *channelListener) OnComplete(Summary)    {}
+
+// progressChannel — встроенный подписчик для Job.Progress. Канал на одно
+// значение: если прошлое никто не забрал, его заменяет свежее, так что
+// нечитаемый Progress ничего не задерживает.
+type progressChannel struct {
+	out chan JobProgress
+}
+
+func (c *progressChannel) OnProgress(s Snapshot) {
+	p := s.Progress()
+	for {
+		select {
+		case c.out <- p:
+			return
+		default:
+		}
+		// Пишет только горутина доставки, так что место освободится
+		select {
+		case <-c.out:
+		default:
+		}
+	}
+}
+
+func (c *progressChannel) OnFileDone(FileResult) {}
+func (c *progressChannel) OnError(ErrorEvent)    {}
+func (c *progressChannel) OnComplete(Summary)    {}
+
+// eventBus — единственный владелец событий задачи. Производители (воркеры,
+// репортер прогресса, Run) кладут события во входной буфер, одна горутина
+// раздаёт их подписчикам. Каналы Events и Progress — встроенные подписчики;
+// они закрываются ровно один раз, после того как закрыт вход и доставлен остаток.
+// Поздние отправки после close молча отбрасываются.
+type eventBus struct {
+	mu       sync.RWMutex
+	closed   bool
+	in       chan *event
+	out      chan string
+	progress chan JobProgress
+	done     chan struct{}
+
+	subsMu sync.Mutex
+	subs   []*listenerQueue
+	wait   sync.WaitGroup // Подписчики, доставку которым ждёт close
+}
+
+func newEventBus() *eventBus {
+	b := &eventBus{
+		in:       make(chan *event, eventBufferSize),
+		out:      make(chan string, listenerLogLimit),
+		progress: make(chan JobProgress, 1),
+		done:     make(chan struct{}),
+	}
+
+	// Events и Progress читают не всегда, поэтому close их не ждёт
+	events := newListenerQueue(&channelListener{out: b.out})
+	events.onDone = func() { close(b.out) }
+	progress := newListenerQueue(&progressChannel{out: b.progress})
+	progress.onDone = func() { close(b.progress) }
+	b.subs = append(b.subs, events, progress)
+	go events.run()
+	go progress.run()
+
+	go b.loop()
+	return b
+}
+
+func (b *eventBus) loop() {
+	defer close(b.done)
+	for ev := range b.in {
+		b.subsMu.Lock()
+		subs := append([]*listenerQueue(nil), b.subs...)
+		b.subsMu.Unlock()
+		for _, s := range subs {
+			s.push(ev)
+		}
+	}
+	b.subsMu.Lock()
+	for _, s := range b.subs {
+		s.finish()
+	}
+	b.subsMu.Unlock()
+}
+
+// subscribe добавляет подписчика; wait — close дожидается доставки ему
+func (b *eventBus) subscribe(l ProgressListener, wait bool) func() {
+	if b == nil || l == nil {
+		return func() {}
+	}
+	q := newListenerQueue(l)
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return func() {}
+	}
+	b.subsMu.Lock()
+	b.subs = append(b.subs, q)
+	b.subsMu.Unlock()
+	if wait {
+		b.wait.Add(1)
+		q.onDone = b.wait.Done
+	}
+	b.mu.RUnlock()
+	go q.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.subsMu.Lock()
+			for i, s := range b.subs {
+				if s == q {
+					b.subs = append(b.subs[:i], b.subs[i+1:]...)
+					break
+				}
+			}
+			b.subsMu.Unlock()
+			q.finish()
+		})
+	}
+}
+
+// send не блокируется: при переполненном буфере строка лога теряется
+func (b *eventBus) send(msg string) bool {
+	return b.publish(&event{kind: eventLog, msg: msg})
+}
+
+// publish кладёт событие во вход. Лог и прогресс при переполнении
+// отбрасываются, остальные события ждут места в буфере.
+func (b *eventBus) publish(ev *event) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false
+	}
+	if ev.kind == eventLog || ev.kind == eventProgress {
+		select {
+		case b.in <- ev:
+			return true
+		default:
+			return false
+		}
+	}
+	b.in <- ev
+	return true
+}
+
+// close закрывает вход и ждёт, пока подписчики получат остаток.
+// Events и Progress закрываются сразу после остатка: непрочитанное в них
+// остаётся в буфере. Повторные вызовы безопасны.
+func (b *eventBus) close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.in)
+	b.mu.Unlock()
+
+	<-b.done
+	b.wait.Wait()
+}
+
+// Subscribe подписывает слушателя на события задачи. Вызывать до Run
+// (например, из OnStart): Run вернётся только после того, как все события
+// доставлены подписчикам. Возвращает функцию отписки.
+func (j *Job) Subscribe(l ProgressListener) (unsubscribe func()) {
+	return j.events.subscribe(l, true)
+}
+
+func (j *Job) emit(ev *event) {
+	j.events.publish(ev)
+}
@@ -0,0 +1,90 @@
+package downloader_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sitemvp/downloader"
+)
+
+// fixtureSite — маленький сайт: главная, страница "о нас" и стили
+func fixtureSite() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/style.css"></head>
+<body><a href="/about/">About</a></body></html>`)
+	})
+	mux.HandleFunc("/about/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="/">Home</a></body></html>`)
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		fmt.Fprint(w, `body { color: black }`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func ExampleRun() {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	site := fixtureSite()
+	defer site.Close()
+
+	out, _ := os.MkdirTemp("", "sitemvp-example")
+	defer os.RemoveAll(out)
+
+	var events int
+	sum, err := downloader.Run(context.Background(), downloader.RunOptions{
+		URL: site.URL + "/",
+		Config: downloader.Config{
+			Workers:   2,
+			MaxDepth:  2,
+			Retries:   1,
+			OutputDir: out,
+		},
+		OnEvent: func(msg string) { events++ },
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+
+	// Сохранённые файлы внутри каталога хоста
+	host := strings.TrimPrefix(site.URL, "http://")
+	var files []string
+	filepath.Walk(filepath.Join(out, host), func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(filepath.Join(out, host), p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+
+	fmt.Println("canceled:", sum.Canceled)
+	fmt.Println("got events:", events > 0)
+	for _, f := range files {
+		fmt.Println(f)
+	}
+	// Output:
+	// canceled: false
+	// got events: true
+	// about/index.html
+	// index.html
+	// style.css
+}
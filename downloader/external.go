@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AssetMirrorMarker — файл в папке хоста, скачанного вместе с другим
+// сайтом (CDN, www-вариант, поддомен): это не отдельный сайт для библиотеки
+const AssetMirrorMarker = ".sitemvp-assets"
+
+// Ассеты с чужих хостов (Config.DownloadExternalAssets). Сайты часто берут
+// CSS, JS и шрифты с CDN, и без них копия открывается без стилей. Такие
+// файлы сохраняются рядом с папкой сайта — в OutputDir/<хост CDN>/...,
+// а в реестре путей и манифесте записываются от папки сайта:
+// "../cdn.example.net/css/app.css". Страницы чужих хостов не качаются.
+
+// externalAssets — с каких чужих хостов брать ассеты
+type externalAssets struct {
+	enabled bool
+	domains []string // Пусто — с любого хоста
+}
+
+func newExternalAssets(cfg Config) externalAssets {
+	e := externalAssets{enabled: cfg.DownloadExternalAssets}
+	for _, d := range cfg.ExtraDomains {
+		if d = strings.ToLower(strings.Trim(strings.TrimSpace(d), ".")); d != "" {
+			e.domains = append(e.domains, d)
+		}
+	}
+	return e
+}
+
+// listed — хост из ExtraDomains или его поддомен
+func (e externalAssets) listed(host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range e.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows — качать ли URL чужого хоста. По расширению видно не всё
+// (fonts.googleapis.com/css?family=...), поэтому с хостов из ExtraDomains
+// берём и URL без расширения ассета; если ответ окажется HTML-страницей,
+// processURL её не сохранит.
+func (e externalAssets) allows(u *url.URL) bool {
+	if !e.enabled {
+		return false
+	}
+	if len(e.domains) == 0 {
+		return isAssetPath(strings.ToLower(u.Path))
+	}
+	return e.listed(u.Hostname())
+}
+
+// externalPrefix — "../<хост>/" для файла чужого хоста, "" для файла сайта
+func (j *Job) externalPrefix(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Host == "" || u.Host == j.scheme.host {
+		return ""
+	}
+	return "../" + u.Host + "/"
+}
+
+// foreignHost — URL чужого хоста: не корень, не www-вариант и не поддомен сайта
+func (j *Job) foreignHost(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	return err == nil && u.Host != "" && !j.siteHost(u.Host)
+}
+
+// rewritesExternal — ссылку на другой хост переписываем на локальную копию,
+// если задача её скачает (или уже скачала): страницы и файлы хостов сайта,
+// с чужих хостов — только ассеты
+func (j *Job) rewritesExternal(u *url.URL) bool {
+	if key, err := NormalizeURL(u.String()); err == nil {
+		if _, ok := j.saved.lookup(key); ok {
+			return true
+		}
+	}
+	if j.siteHost(u.Host) {
+		return j.Filter.ShouldDownload(u.String())
+	}
+	return j.Config.DownloadExternalAssets && isAssetPath(strings.ToLower(u.Path)) && j.Filter.ShouldDownload(u.String())
+}
+
+// markAssetMirror помечает папку другого хоста, чтобы её не принимали за сайт
+func markAssetMirror(outputDir, host string) {
+	dir, err := hostDir(outputDir, host)
+	if err != nil {
+		return
+	}
+	marker := filepath.Join(dir, AssetMirrorMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return
+	}
+	os.WriteFile(marker, nil, 0644)
+}
+
+// IsAssetMirror — папка хоста, скачанного вместе с другим сайтом
+func IsAssetMirror(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, AssetMirrorMarker))
+	return err == nil
+}
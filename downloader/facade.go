@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RunOptions — параметры задачи для Run
+type RunOptions struct {
+	URL    string
+	Config Config
+
+	// Targets — точечная докачка: вместо обхода от URL скачать только эти
+	// страницы (например, Missed из отчёта о покрытии). Задача та же, что
+	// у URL: состояние и манифест дополняются.
+	Targets []string
+
+	// OnEvent получает каждое сообщение из Events. Вызывается из одной
+	// горутины; все вызовы завершаются до возврата из Run.
+	OnEvent func(msg string)
+
+	// OnStart вызывается перед запуском: через Job можно смотреть
+	// статистику, очередь и воркеров, пока задача идёт.
+	OnStart func(j *Job)
+}
+
+// ResumeOptions — параметры продолжения задачи из файла состояния
+type ResumeOptions struct {
+	// Override правит конфигурацию, сохранённую в состоянии (воркеры, задержка и т.п.)
+	Override func(cfg *Config)
+
+	// RetryFailed — снова поставить в очередь URL, упавшие в прошлых запусках
+	RetryFailed bool
+
+	OnEvent func(msg string)
+	OnStart func(j *Job)
+}
+
+// Summary — итог задачи
+type Summary struct {
+	JobID     string
+	RootURL   string
+	StateFile string
+	LogFile   string // Полный лог задачи
+	Stats     JobStats
+	TooLarge  []TooLargeFile
+	HostsDown []HostDownStat
+	Canceled  bool // Остановлена через ctx или по бюджету; состояние сохранено для Resume
+
+	// Budget — задача остановлена по MaxPages/MaxTotalBytes; nil — нет
+	Budget *BudgetEvent
+
+	// Coverage — сверка с sitemap; nil, если sitemap не найден или обход прерван
+	Coverage *CoverageReport
+
+	// BrokenLinks — URL, которые так и не скачались (см. brokenlinks.go)
+	BrokenLinks []BrokenLink
+
+	// DryRun — отчёт пробного прогона (Config.DryRun); nil — обычная загрузка
+	DryRun *DryRunReport
+}
+
+// Run скачивает сайт и блокируется до завершения или отмены ctx.
+// При отмене возвращает ctx.Err() вместе с частичным итогом.
+func Run(ctx context.Context, opts RunOptions) (Summary, error) {
+	if opts.URL == "" {
+		return Summary{}, fmt.Errorf("empty URL")
+	}
+	job, err := newJob(ctx, opts.URL, opts.Config, opts.Targets)
+	if err != nil {
+		return Summary{}, err
+	}
+	return runJob(ctx, job, opts.OnEvent, opts.OnStart)
+}
+
+// Resume продолжает задачу из файла состояния (<id>.state.json или журнала .state.jsonl)
+func Resume(ctx context.Context, stateFile string, opts ResumeOptions) (Summary, error) {
+	job, err := loadJob(ctx, stateFile, opts.Override)
+	if err != nil {
+		return Summary{}, err
+	}
+	if opts.RetryFailed {
+		if n := job.requeueFailed(); n > 0 {
+			job.sendLog(fmt.Sprintf("[Retry] Повтор URL, упавших в прошлых запусках: %d", n), false)
+		}
+	}
+	return runJob(ctx, job, opts.OnEvent, opts.OnStart)
+}
+
+// loadJob собирает Job из файла состояния со всей обвязкой, которую делает NewJob
+func loadJob(ctx context.Context, stateFile string, override func(cfg *Config)) (*Job, error) {
+	if strings.HasSuffix(stateFile, StateJournalExtension) {
+		stateFile = strings.TrimSuffix(stateFile, StateJournalExtension) + StateFileExtension
+	}
+
+	job := &Job{
+		ID:        strings.TrimSuffix(filepath.Base(stateFile), StateFileExtension),
+		stateFile: stateFile,
+		saved:     newSavedPaths(),
+		redirects: newRedirectMap(),
+	}
+	job.events = newEventBus()
+	job.Events = job.events.out
+	job.Progress = job.events.progress
+
+	if err := job.loadState(); err != nil {
+		job.events.close()
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+	if override != nil {
+		override(&job.Config)
+	}
+
+	job.ctx, job.cancel = context.WithCancel(ctx)
+	job.Downloader = NewDownloader(job.Config)
+	// Парсеры и обработчики — под итоговый конфиг и с логом задачи
+	job.setupContent()
+	job.workers = newWorkerTable(job.Config.Workers)
+	return job, nil
+}
+
+func runJob(ctx context.Context, job *Job, onEvent func(string), onStart func(*Job)) (Summary, error) {
+	// Вторая задача в ту же папку сайта не запускается; пробный прогон
+	// в папку сайта не пишет и ей не мешает
+	if !job.Config.DryRun {
+		lock, err := LockSiteOf(job.Config.OutputDir, job.RootURL, "job "+job.ID)
+		if err != nil {
+			job.events.close()
+			return Summary{}, err
+		}
+		defer lock.Unlock()
+	}
+
+	// Events читаем всегда: иначе владелец канала не сможет его закрыть
+	eventsDone := make(chan struct{})
+	go func() {
+		defer close(eventsDone)
+		for msg := range job.Events {
+			if onEvent != nil {
+				onEvent(msg)
+			}
+		}
+	}()
+
+	if onStart != nil {
+		onStart(job)
+	}
+	job.Run()
+	<-eventsDone
+
+	if job.runErr != nil {
+		return job.summary(false), job.runErr
+	}
+	return job.summary(ctx.Err() != nil), ctx.Err()
+}
+
+func (j *Job) summary(canceled bool) Summary {
+	return Summary{
+		JobID:     j.ID,
+		RootURL:   j.RootURL,
+		StateFile: j.stateFile,
+		LogFile:   j.LogFile(),
+		Stats:     j.GetStats(),
+		TooLarge:  j.Downloader.TooLargeFiles(),
+		HostsDown: j.Downloader.ShortCircuitedHosts(),
+		Canceled:  canceled || j.budgetReached() != nil,
+		Budget:    j.budgetReached(),
+		Coverage:  j.coverage,
+		DryRun:    j.dryRunReport,
+
+		BrokenLinks: j.FailedURLs(),
+	}
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Метаданные сохранённых файлов: для каждого файла — JSON в
+// <хост>/.meta/<путь файла>.json с тем, что ответил сервер и когда.
+// Пишутся при Config.SaveMetadata (архив: когда снята копия, какие
+// заголовки были у оригинала) и при Config.Incremental (ETag и ссылки
+// для условных запросов, см. incremental.go).
+
+// MetaDirName — папка метаданных файлов внутри папки хоста. Копия
+// .meta/<путь файла>.json лежит рядом с сайтом, но в результат
+// обработки и в экспорт не попадает.
+const MetaDirName = ".meta"
+
+// fileMeta — метаданные сохранённого файла
+type fileMeta struct {
+	URL          string            `json:"url"`
+	RequestedURL string            `json:"requestedUrl,omitempty"` // Исходный URL, если ответ пришёл после редиректа
+	Status       int               `json:"status,omitempty"`
+	ContentType  string            `json:"contentType"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"lastModified,omitempty"`
+	Size         int64             `json:"size"` // Байт в ответе сервера, до переписывания ссылок
+	Hash         string            `json:"hash"` // SHA-256 ответа
+	Depth        int               `json:"depth"`
+	Headers      map[string]string `json:"headers,omitempty"` // Заголовки ответа (SaveMetadata)
+	Links        []string          `json:"links,omitempty"`   // Ссылки из содержимого до переписывания
+	SavedAt      time.Time         `json:"savedAt"`
+}
+
+// IsMetaDir — служебная папка метаданных; обработка и экспорт её пропускают
+func IsMetaDir(name string) bool {
+	return name == MetaDirName
+}
+
+// metaFile — путь к метаданным файла rel (путь внутри папки хоста)
+func metaFile(outputDir, host, rel string) (string, error) {
+	dir, err := hostDir(outputDir, host)
+	if err != nil {
+		return "", err
+	}
+	return ContainedPath(dir, MetaDirName+"/"+rel+".json")
+}
+
+// keepsMeta — нужны ли задаче метаданные файлов
+func (j *Job) keepsMeta() bool {
+	return j.Config.SaveMetadata || j.Config.Incremental
+}
+
+// newFileMeta собирает метаданные ответа res на запрос requestedURL,
+// сохранённого как urlStr
+func (j *Job) newFileMeta(requestedURL, urlStr string, res FetchResult, contentType, hash string, depth int) fileMeta {
+	m := fileMeta{
+		URL:          urlStr,
+		Status:       res.Status,
+		ContentType:  contentType,
+		ETag:         res.ETag,
+		LastModified: res.Header.Get("Last-Modified"),
+		Size:         int64(len(res.Content)),
+		Hash:         hash,
+		Depth:        depth,
+		SavedAt:      j.now(),
+	}
+	if requestedURL != urlStr {
+		m.RequestedURL = requestedURL
+	}
+	if j.Config.SaveMetadata {
+		m.Headers = flattenHeader(res.Header)
+	}
+	return m
+}
+
+// flattenHeader — заголовки ответа одной строкой на имя
+func flattenHeader(h http.Header) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	flat := make(map[string]string, len(h))
+	for name, values := range h {
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+// writeFileMeta сохраняет метаданные рядом с файлом; ошибка не мешает обходу
+func (j *Job) writeFileMeta(urlStr, rel string, m fileMeta) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return
+	}
+	path, err := metaFile(j.Config.OutputDir, u.Host, rel)
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
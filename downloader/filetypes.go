@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Категории файлов для JobStats.FileTypes
+const (
+	FileHTML  = "html"
+	FileCSS   = "css"
+	FileJS    = "js"
+	FileImage = "image"
+	FileFont  = "font"
+	FileVideo = "video"
+	FileOther = "other"
+)
+
+// fileTypeOrder — порядок и подписи категорий в итоговой сводке
+var fileTypeOrder = []struct{ key, label string }{
+	{FileHTML, "HTML"},
+	{FileImage, "images"},
+	{FileCSS, "CSS"},
+	{FileJS, "JS"},
+	{FileFont, "fonts"},
+	{FileVideo, "video"},
+	{FileOther, "other"},
+}
+
+var extFileTypes = map[string]string{
+	".html": FileHTML, ".htm": FileHTML, ".xhtml": FileHTML,
+	".css": FileCSS,
+	".js":  FileJS, ".mjs": FileJS,
+	".png": FileImage, ".jpg": FileImage, ".jpeg": FileImage, ".gif": FileImage,
+	".webp": FileImage, ".svg": FileImage, ".ico": FileImage, ".avif": FileImage, ".bmp": FileImage,
+	".woff": FileFont, ".woff2": FileFont, ".ttf": FileFont, ".otf": FileFont, ".eot": FileFont,
+	".mp4": FileVideo, ".webm": FileVideo, ".ogv": FileVideo, ".mov": FileVideo, ".m4v": FileVideo,
+}
+
+// fileCategory относит сохранённый файл к категории: по Content-Type,
+// а если он общий (octet-stream, text/plain) или пустой — по расширению
+func fileCategory(urlStr, contentType string) string {
+	ct := mediaType(contentType)
+	switch {
+	case ct == "text/html" || ct == "application/xhtml+xml":
+		return FileHTML
+	case ct == "text/css":
+		return FileCSS
+	case strings.Contains(ct, "javascript") || strings.Contains(ct, "ecmascript"):
+		return FileJS
+	case strings.HasPrefix(ct, "image/"):
+		return FileImage
+	case strings.HasPrefix(ct, "font/") || strings.Contains(ct, "font-") || strings.Contains(ct, "woff"):
+		return FileFont
+	case strings.HasPrefix(ct, "video/"):
+		return FileVideo
+	}
+	if u, err := url.Parse(urlStr); err == nil {
+		if kind, ok := extFileTypes[strings.ToLower(path.Ext(u.Path))]; ok {
+			return kind
+		}
+	}
+	return FileOther
+}
+
+// FormatFileTypes — сводка по категориям: "HTML: 120, images: 540, CSS: 14"
+func FormatFileTypes(types map[string]int64) string {
+	var parts []string
+	for _, t := range fileTypeOrder {
+		if n := types[t.key]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", t.label, n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
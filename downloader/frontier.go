@@ -0,0 +1,228 @@
+package downloader
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Очередь обхода (frontier): URL ждут воркеров в куче, упорядоченной по
+// Config.Strategy, а диспетчер отдаёт их в канал pending без буфера —
+// воркер всегда берёт самый важный URL на момент, когда освободился. В
+// памяти держится до QueueSize URL, остальные ждут в файле переполнения
+// (см. queue.go) и встают в общий порядок, когда в куче есть место.
+
+// Стратегии обхода (Config.Strategy)
+const (
+	CrawlBFS         = "bfs"          // Сначала ближние к корню страницы, в порядке находки (по умолчанию)
+	CrawlDFS         = "dfs"          // Сначала самые глубокие и последние найденные
+	CrawlAssetsFirst = "assets-first" // Сначала ассеты (CSS, JS, картинки), затем страницы по глубине
+)
+
+// checkStrategy проверяет Config.Strategy; пустая строка — CrawlBFS
+func checkStrategy(strategy string) error {
+	switch strategy {
+	case "", CrawlBFS, CrawlDFS, CrawlAssetsFirst:
+		return nil
+	}
+	return fmt.Errorf("unknown crawl strategy %q (want %s, %s or %s)", strategy, CrawlBFS, CrawlDFS, CrawlAssetsFirst)
+}
+
+// frontierItem — URL в очереди; seq — порядок постановки
+type frontierItem struct {
+	url   string
+	depth int
+	asset bool
+	seq   int64
+}
+
+// strategyLess — порядок кучи для стратегии; неизвестная — как CrawlBFS
+func strategyLess(strategy string) func(a, b *frontierItem) bool {
+	switch strategy {
+	case CrawlDFS:
+		return func(a, b *frontierItem) bool {
+			if a.depth != b.depth {
+				return a.depth > b.depth
+			}
+			return a.seq > b.seq
+		}
+	case CrawlAssetsFirst:
+		bfs := strategyLess(CrawlBFS)
+		return func(a, b *frontierItem) bool {
+			if a.asset != b.asset {
+				return a.asset
+			}
+			return bfs(a, b)
+		}
+	}
+	return func(a, b *frontierItem) bool {
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		return a.seq < b.seq
+	}
+}
+
+// frontierHeap — container/heap над URL очереди
+type frontierHeap struct {
+	items []frontierItem
+	less  func(a, b *frontierItem) bool
+}
+
+func (h *frontierHeap) Len() int           { return len(h.items) }
+func (h *frontierHeap) Less(a, b int) bool { return h.less(&h.items[a], &h.items[b]) }
+func (h *frontierHeap) Swap(a, b int)      { h.items[a], h.items[b] = h.items[b], h.items[a] }
+func (h *frontierHeap) Push(x any)         { h.items = append(h.items, x.(frontierItem)) }
+func (h *frontierHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// frontier — куча URL, ждущих воркеров; безопасна для нескольких горутин
+type frontier struct {
+	mu    sync.Mutex
+	heap  frontierHeap
+	seq   int64
+	limit int // Сколько URL держать в памяти, дальше — файл переполнения
+
+	ready chan struct{} // Диспетчеру: в куче появился URL
+	room  chan struct{} // Фидеру: в куче освободилось место
+}
+
+func newFrontier(strategy string, limit int) *frontier {
+	return &frontier{
+		heap:  frontierHeap{less: strategyLess(strategy)},
+		limit: limit,
+		ready: make(chan struct{}, 1),
+		room:  make(chan struct{}, 1),
+	}
+}
+
+// order меняет стратегию (resume с другим --strategy) и перестраивает кучу
+func (f *frontier) order(strategy string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heap.less = strategyLess(strategy)
+	heap.Init(&f.heap)
+}
+
+// push ставит URL в очередь независимо от limit
+func (f *frontier) push(u string, depth int) {
+	f.mu.Lock()
+	f.seq++
+	heap.Push(&f.heap, frontierItem{url: u, depth: depth, asset: isAssetURL(u), seq: f.seq})
+	f.mu.Unlock()
+	wake(f.ready)
+}
+
+// tryPush ставит URL в очередь, если в памяти есть место
+func (f *frontier) tryPush(u string, depth int) bool {
+	f.mu.Lock()
+	full := f.heap.Len() >= f.limit
+	f.mu.Unlock()
+	if full {
+		return false
+	}
+	f.push(u, depth)
+	return true
+}
+
+// requeue возвращает взятый, но не отданный воркеру URL на его место,
+// не будя диспетчера
+func (f *frontier) requeue(it frontierItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	heap.Push(&f.heap, it)
+}
+
+// pop забирает самый важный URL
+func (f *frontier) pop() (frontierItem, bool) {
+	f.mu.Lock()
+	if f.heap.Len() == 0 {
+		f.mu.Unlock()
+		return frontierItem{}, false
+	}
+	it := heap.Pop(&f.heap).(frontierItem)
+	f.mu.Unlock()
+	wake(f.room)
+	return it, true
+}
+
+// hasRoom — можно ли вернуть в кучу ещё один URL с диска
+func (f *frontier) hasRoom() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.heap.Len() < f.limit
+}
+
+// Len — сколько URL ждёт в памяти
+func (f *frontier) Len() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.heap.Len()
+}
+
+// drain опустошает очередь и возвращает URL в порядке приоритета
+func (f *frontier) drain() []string {
+	var urls []string
+	for {
+		it, ok := f.pop()
+		if !ok {
+			return urls
+		}
+		urls = append(urls, it.url)
+	}
+}
+
+// wake будит ждущую горутину, не блокируясь
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// isAssetURL — ассет по расширению пути (см. assetExts)
+func isAssetURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && isAssetPath(strings.ToLower(parsed.Path))
+}
+
+// dispatch отдаёт воркерам URL из кучи по приоритету. Пока все воркеры
+// заняты, взятый URL может обогнать найденный позже, более важный —
+// тогда он возвращается в кучу и выбор повторяется.
+func (j *Job) dispatch() {
+	for {
+		it, ok := j.frontier.pop()
+		if !ok {
+			select {
+			case <-j.ctx.Done():
+				return
+			case <-j.frontier.ready:
+				continue
+			}
+		}
+		select {
+		case j.pending <- it.url:
+		case <-j.frontier.ready:
+			j.frontier.requeue(it)
+		case <-j.ctx.Done():
+			// Останется в куче: discardPending снимет его с activeWG
+			j.frontier.requeue(it)
+			return
+		}
+	}
+}
+
+// depthOf — глубина URL, который снова встаёт в очередь (с диска или повтором)
+func (j *Job) depthOf(u string) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.depths[u]
+}
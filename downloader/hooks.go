@@ -0,0 +1,26 @@
+package downloader
+
+// Хуки файлов Job.OnFileStart, OnFileDone и OnFileError — для тех, кто
+// встраивает пакет: вызываются синхронно из воркера, который качает
+// URL, поэтому при нескольких воркерах — одновременно из разных
+// горутин. Мьютексы задачи на время вызова не держатся, но пока хук
+// работает, воркер стоит: долгую работу стоит отдать своей горутине.
+// За OnFileStart не обязательно следует OnFileDone или OnFileError:
+// URL может оказаться пропущен (robots, чужая страница, не изменился).
+
+// fileDone — файл скачан: событие подписчикам и хук OnFileDone
+func (j *Job) fileDone(f FileResult) {
+	j.emit(&event{kind: eventFile, file: f})
+	if j.OnFileDone != nil {
+		j.OnFileDone(f.URL, f.Path, f.Size, f.ContentType)
+	}
+}
+
+// fileError — URL не скачан: событие подписчикам и хук OnFileError.
+// Отложенный повтор хук не получает — исход URL ещё впереди.
+func (j *Job) fileError(e ErrorEvent) {
+	j.emit(&event{kind: eventError, err: e})
+	if j.OnFileError != nil && !e.Retrying {
+		j.OnFileError(e.URL, e.Err)
+	}
+}
@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHostFailureThreshold = 5
+	DefaultHostCooldown         = 60 * time.Second
+)
+
+// ErrHostDown — хост помечен как недоступный, запрос не выполнялся
+var ErrHostDown = errors.New("host is down")
+
+// hostHealth отслеживает подряд идущие DNS/connect ошибки по каждому хосту.
+// После threshold ошибок хост считается "мёртвым" на cooldown, и все URL
+// этого хоста сразу завершаются с ErrHostDown вместо полного цикла ретраев.
+// После cooldown хост полуоткрыт: к нему идёт ровно один запрос-проба.
+type hostHealth struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  map[string]int
+	downUntil map[string]time.Time
+	probing   map[string]bool // Проба после cooldown ещё не ответила
+	affected  map[string]int64
+}
+
+func newHostHealth(threshold int, cooldown time.Duration) *hostHealth {
+	if threshold <= 0 {
+		threshold = DefaultHostFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultHostCooldown
+	}
+	return &hostHealth{
+		threshold: threshold,
+		cooldown:  cooldown,
+		failures:  make(map[string]int),
+		downUntil: make(map[string]time.Time),
+		probing:   make(map[string]bool),
+		affected:  make(map[string]int64),
+	}
+}
+
+// allow сообщает, можно ли обращаться к хосту. После истечения cooldown
+// пропускаем один запрос-пробу (probe): пока он не завершился, остальные
+// получают отказ, а при новой ошибке хост сразу снова помечается.
+// Пробу завершает endProbe.
+func (h *hostHealth) allow(host string) (ok, probe bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	until, down := h.downUntil[host]
+	if !down {
+		return true, false
+	}
+	if time.Now().After(until) && !h.probing[host] {
+		h.probing[host] = true
+		return true, true
+	}
+	h.affected[host]++
+	return false, false
+}
+
+// endProbe снимает пробу, если та кончилась без ответа fail/succeed
+// (отмена, таймаут): следующий запрос снова станет пробой.
+func (h *hostHealth) endProbe(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.probing, host)
+}
+
+// fail регистрирует жёсткую ошибку соединения и возвращает true,
+// если хост только что был помечен как недоступный.
+func (h *hostHealth) fail(host string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures[host]++
+	if h.failures[host] < h.threshold {
+		return false
+	}
+	delete(h.probing, host)
+	h.downUntil[host] = time.Now().Add(h.cooldown)
+	if _, ok := h.affected[host]; !ok {
+		h.affected[host] = 0
+	}
+	return true
+}
+
+func (h *hostHealth) succeed(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.failures, host)
+	delete(h.downUntil, host)
+	delete(h.probing, host)
+}
+
+// HostDownStat — хост, для которого сработало короткое замыкание
+type HostDownStat struct {
+	Host         string
+	AffectedURLs int64
+}
+
+func (h *hostHealth) summary() []HostDownStat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var res []HostDownStat
+	for host, n := range h.affected {
+		res = append(res, HostDownStat{Host: host, AffectedURLs: n})
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Host < res[k].Host })
+	return res
+}
+
+// isHardConnError — ошибка DNS или установки соединения (ретраи бессмысленны)
+func isHardConnError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return false
+}
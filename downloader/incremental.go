@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Инкрементальный режим (Config.Incremental): повторный обход в ту же
+// OutputDir не качает заново то, что не изменилось. Для URL, сохранённого
+// в прошлых запусках, запрос идёт с If-None-Match (ETag из метаданных)
+// и If-Modified-Since (mtime файла); на 304 файл остаётся как есть,
+// а в очередь идут ссылки, найденные на странице в прошлый раз.
+//
+// Ссылки берём из метаданных, а не из локальной копии: в ней они уже
+// переписаны на относительные пути и URL по ним не восстановить.
+
+// ErrNotModified — условный запрос: сервер ответил 304, локальная копия актуальна
+var ErrNotModified = errors.New("not modified")
+
+// Validators — условия запроса: ETag прошлого ответа и время локальной копии
+type Validators struct {
+	ETag          string
+	ModifiedSince time.Time
+}
+
+// storedCopy находит локальную копию URL из прошлых запусков и её
+// метаданные. ok=false — копии нет, URL качается как обычно.
+func (j *Job) storedCopy(urlStr string) (v Validators, m fileMeta, ok bool) {
+	sp, found := j.saved.lookup(urlStr)
+	if !found {
+		return v, m, false
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return v, m, false
+	}
+	// Путь в реестре — от папки сайта; у CDN он начинается с ../<хост>/
+	rel := strings.TrimPrefix(sp.Path, j.externalPrefix(urlStr))
+	dir, err := hostDir(j.Config.OutputDir, u.Host)
+	if err != nil {
+		return v, m, false
+	}
+	file, err := ContainedPath(dir, rel)
+	if err != nil {
+		return v, m, false
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return v, m, false
+	}
+	path, err := metaFile(j.Config.OutputDir, u.Host, rel)
+	if err != nil {
+		return v, m, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &m) != nil {
+		return v, m, false
+	}
+	return Validators{ETag: m.ETag, ModifiedSince: info.ModTime()}, m, true
+}
+
+// keepUnchanged — сервер ответил 304: файл не трогаем, ссылки страницы
+// берём из метаданных прошлого запуска
+func (j *Job) keepUnchanged(workerID int, urlStr string, depth int, m fileMeta) {
+	j.noteCompleted(urlStr)
+	atomic.AddInt64(&j.stats.Unchanged, 1)
+	j.sendLog(fmt.Sprintf("[Unchanged] %s", urlStr), false)
+	if depth < j.Config.MaxDepth {
+		j.workers.phase(workerID, PhaseParsing)
+		j.queueLinks(m.Links, depth, urlStr)
+	}
+}
+
+// previousRunFinished — прошлый запуск дошёл до конца (очередь пуста),
+// его состояния нет или оно не читается
+func (j *Job) previousRunFinished() bool {
+	state, _, err := readJobState(j.stateFile)
+	return err != nil || len(state.PendingURLs) == 0
+}
+
+// discardState удаляет снимок и журнал прошлого запуска, чтобы
+// инкрементальный обход прошёл сайт целиком, а не доделывал очередь
+func (j *Job) discardState() {
+	for _, path := range []string{j.stateFile, j.journalFile()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			j.logger().Warn("Не удалось удалить состояние %s: %v", path, err)
+		}
+	}
+}
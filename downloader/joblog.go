@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogExtension — полный лог задачи рядом с файлами состояния (<id>.log)
+const LogExtension = ".log"
+
+// jobLog дописывает все сообщения задачи в файл: GUI показывает только
+// хвост лога, а разбирать многочасовой обход удобнее по файлу.
+type jobLog struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func openJobLog(path string) (*jobLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jobLog{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (l *jobLog) write(msg string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	l.w.WriteString(time.Now().Format("2006-01-02 15:04:05 "))
+	l.w.WriteString(strings.TrimRight(msg, "\n"))
+	l.w.WriteByte('\n')
+}
+
+// Flush сбрасывает буфер на диск (вызывается на каждом чекпоинте)
+func (l *jobLog) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.w.Flush()
+}
+
+func (l *jobLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.w.Flush()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// LogFile — путь к полному логу задачи
+func (j *Job) LogFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + LogExtension
+}
@@ -0,0 +1,364 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StateJournalExtension     = ".state.jsonl"
+	ManifestExtension         = ".manifest.jsonl"
+	DefaultCheckpointInterval = 30 * time.Second
+	DefaultCheckpointPages    = 500
+)
+
+// ManifestEntry — одна строка манифеста: успешно сохранённый файл
+type ManifestEntry struct {
+	URL         string    `json:"url"`
+	Path        string    `json:"path"`
+	Strategy    string    `json:"strategy,omitempty"` // directory или file
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"hash"`
+	Depth       int       `json:"depth"`
+	SavedAt     time.Time `json:"savedAt"`
+
+	// AliasOf — URL, под которым файл сохранён; у записи-алиаса (/about
+	// при сохранённом /about/) своего содержимого нет
+	AliasOf string `json:"aliasOf,omitempty"`
+
+	// Scheme — канонический протокол хоста: ссылки и на http://, и на
+	// https:// вариант сохранены один раз, под URL с этим протоколом
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// manifestWriter дописывает записи в JSONL по мере сохранения файлов,
+// чтобы на многочасовых обходах ничего не терялось при падении.
+type manifestWriter struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+func openManifest(path string) (*manifestWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestWriter{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (m *manifestWriter) Append(e ManifestEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Flush сбрасывает буфер на диск (вызывается на каждом чекпоинте)
+func (m *manifestWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.w.Flush()
+}
+
+func (m *manifestWriter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
+
+// LoadManifest читает манифест. Принимает и JSONL, и старый формат
+// (один JSON-массив); при повторах URL побеждает последняя запись.
+func LoadManifest(path string) ([]ManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var entries []ManifestEntry
+		err := json.Unmarshal([]byte(trimmed), &entries)
+		return entries, err
+	}
+
+	index := make(map[string]int)
+	var entries []ManifestEntry
+	for _, line := range strings.Split(trimmed, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e ManifestEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			// Обрезанная последняя строка после краша — пропускаем
+			continue
+		}
+		if i, ok := index[e.URL]; ok {
+			entries[i] = e
+			continue
+		}
+		index[e.URL] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// compactManifest переписывает JSONL без дубликатов (в конце задачи)
+func compactManifest(path string) error {
+	entries, err := LoadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stateDelta — одна строка журнала состояния: только то, что изменилось
+// с прошлого чекпоинта. Очередь и статистика маленькие — пишем целиком.
+type stateDelta struct {
+	ID          string         `json:"id"`
+	RootURL     string         `json:"rootUrl"`
+	Config      Config         `json:"config"`
+	Depths      map[string]int `json:"depths"`
+	PendingURLs []string       `json:"pending"`
+	Stats       JobStats       `json:"stats"`
+	SavedAt     time.Time      `json:"savedAt"`
+
+	Redirects map[string]string `json:"redirects,omitempty"` // Новые с прошлой дельты
+
+	Version   int               `json:"version,omitempty"`
+	Completed []string          `json:"completed,omitempty"` // Сохранённые с прошлой дельты
+	Failed    map[string]string `json:"failed,omitempty"`    // Упавшие с прошлой дельты
+	Handlers  []string          `json:"handlers,omitempty"`  // Имена обработчиков (см. register.go)
+}
+
+func (j *Job) journalFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + StateJournalExtension
+}
+
+func (j *Job) manifestFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + ManifestExtension
+}
+
+// trackDepth записывает глубину URL и запоминает его для следующей дельты.
+// Вызывать под j.mu.
+func (j *Job) trackDepth(u string, depth int) {
+	j.depths[u] = depth
+	j.newDepths = append(j.newDepths, u)
+	j.progress.discover()
+}
+
+// pageDone считает обработанные URL и каждые CheckpointPages будит checkpointer:
+// на быстром сайте за 30 секунд успевают скачаться тысячи страниц
+func (j *Job) pageDone() {
+	every := int64(j.Config.CheckpointPages)
+	if every <= 0 {
+		every = DefaultCheckpointPages
+	}
+	if atomic.AddInt64(&j.processed, 1)%every == 0 {
+		select {
+		case j.checkpointDue <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// checkpoint дописывает в журнал только изменения с прошлого раза;
+// пробный прогон состояния не пишет
+func (j *Job) checkpoint() error {
+	if j.Config.DryRun {
+		return nil
+	}
+	if j.manifest != nil {
+		if err := j.manifest.Flush(); err != nil {
+			return err
+		}
+	}
+	j.logFile.Flush()
+
+	j.mu.Lock()
+	j.foldPanics()
+	delta := stateDelta{
+		ID:          j.ID,
+		RootURL:     j.RootURL,
+		Config:      j.Config,
+		Depths:      make(map[string]int, len(j.newDepths)),
+		PendingURLs: j.snapshotPending(),
+		Stats:       j.statsLocked(),
+		SavedAt:     j.now(),
+		Redirects:   j.redirects.delta(),
+		Version:     StateVersion,
+		Completed:   j.newCompleted,
+		Failed:      j.newFailed,
+		Handlers:    j.handlerNames(),
+	}
+	for _, u := range j.newDepths {
+		delta.Depths[u] = j.depths[u]
+	}
+	j.newDepths = nil
+	j.newCompleted, j.newFailed = nil, nil
+	data, err := json.Marshal(delta)
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.journalFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var (
+	// ErrNoJobState — у задачи нет ни снимка, ни журнала состояния
+	ErrNoJobState = errors.New("no state file")
+	// ErrCorruptJobState — снимок состояния не разбирается
+	ErrCorruptJobState = errors.New("state file corrupt")
+)
+
+// LoadJobState читает сохранённое состояние задачи: снимок .state.json
+// с применённым журналом .state.jsonl. path — любой из этих двух файлов.
+// Файлы не меняются: журнал сворачивает только resume.
+func LoadJobState(path string) (JobState, error) {
+	if strings.HasSuffix(path, StateJournalExtension) {
+		path = strings.TrimSuffix(path, StateJournalExtension) + StateFileExtension
+	}
+	state, _, err := readJobState(path)
+	return state, err
+}
+
+// readJobState — LoadJobState по пути снимка; hasJournal — был ли журнал,
+// который стоит свернуть в снимок
+func readJobState(stateFile string) (state JobState, hasJournal bool, err error) {
+	data, err := os.ReadFile(stateFile)
+	hasSnapshot := err == nil
+	if hasSnapshot {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return state, false, fmt.Errorf("%w: %s: %v", ErrCorruptJobState, stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return state, false, err
+	}
+
+	base := strings.TrimSuffix(stateFile, StateFileExtension)
+	hasJournal, jerr := replayJournal(base+StateJournalExtension, &state)
+	if jerr != nil {
+		return state, false, jerr
+	}
+	if !hasSnapshot && !hasJournal {
+		return state, false, fmt.Errorf("%w: %s", ErrNoJobState, stateFile)
+	}
+	migrateState(&state, base+ManifestExtension)
+	return state, hasJournal, nil
+}
+
+// replayJournal применяет дельты поверх загруженного снимка.
+// Возвращает false, если журнала нет.
+func replayJournal(journalFile string, state *JobState) (bool, error) {
+	f, err := os.Open(journalFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	if state.DepthMap == nil {
+		state.DepthMap = make(map[string]int)
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	for sc.Scan() {
+		var d stateDelta
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			// Недописанная строка после краша
+			continue
+		}
+		for u, depth := range d.Depths {
+			state.DepthMap[u] = depth
+		}
+		for from, to := range d.Redirects {
+			if state.Redirects == nil {
+				state.Redirects = make(map[string]string)
+			}
+			state.Redirects[from] = to
+		}
+		for u, e := range d.Failed {
+			if state.Failed == nil {
+				state.Failed = make(map[string]string)
+			}
+			state.Failed[u] = e
+		}
+		// Сохранённый после ошибки URL больше не упавший
+		for _, u := range d.Completed {
+			delete(state.Failed, u)
+		}
+		state.Completed = append(state.Completed, d.Completed...)
+		if d.Version > state.Version {
+			state.Version = d.Version
+		}
+		state.ID = d.ID
+		state.RootURL = d.RootURL
+		state.Config = d.Config
+		state.PendingURLs = d.PendingURLs
+		state.Stats = d.Stats
+		if d.Handlers != nil {
+			state.Handlers = d.Handlers
+		}
+	}
+	return true, sc.Err()
+}
+
+// compactState сворачивает снимок и журнал в один JSON и удаляет журнал
+func (j *Job) compactState(state JobState) error {
+	if err := writeStateFile(j.stateFile, state); err != nil {
+		return err
+	}
+	return os.Remove(j.journalFile())
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// JSParser ищет адреса в строковых литералах JavaScript и JSON: эндпоинты
+// fetch()/XHR, пути к картинкам и чанкам бандла. Берутся только литералы
+// сайта — от корня ("/api/items.json") или абсолютные на хост сайта;
+// относительные строки в JS слишком часто оказываются не путями.
+// Включается Config.ParseJavaScript: на больших бандлах обход разрастается.
+type JSParser struct {
+	hosts siteHosts
+}
+
+// newParsers — парсеры задачи; JSParser — только с Config.ParseJavaScript
+func newParsers(rootHost string, cfg Config) []ContentParser {
+	parsers := []ContentParser{&HTMLParser{NoFollow: cfg.RespectNoFollow}, &CSSParser{}, &SVGParser{}}
+	if cfg.ParseJavaScript {
+		parsers = append(parsers, &JSParser{hosts: newSiteHosts(rootHost, cfg)})
+	}
+	return parsers
+}
+
+var (
+	// Строки в "", '' и `` (экранированные кавычки внутри допустимы)
+	jsStringRegex = regexp.MustCompile(`"((?:[^"\\\n]|\\.)*)"|'((?:[^'\\\n]|\\.)*)'|` + "`([^`\\\\$]*)`")
+	// text/html, image/svg+xml — MIME-типы, а не пути
+	mimeTypeRegex = regexp.MustCompile(`^/?(?:application|audio|font|image|multipart|text|video)/[\w.+-]+$`)
+)
+
+func (p *JSParser) CanParse(ct string) bool {
+	ct = mediaType(ct)
+	return strings.Contains(ct, "javascript") || strings.Contains(ct, "ecmascript") ||
+		ct == "application/json" || strings.HasSuffix(ct, "+json")
+}
+
+func (p *JSParser) Parse(content []byte, baseURL string) ([]string, error) {
+	// Пути в скриптах с CDN указывают на CDN, а не на сайт
+	if base, err := url.Parse(baseURL); err != nil || !p.hosts.contains(base.Host) {
+		return nil, nil
+	}
+	var links []string
+	for _, m := range jsStringRegex.FindAllSubmatch(content, -1) {
+		lit := string(m[1]) + string(m[2]) + string(m[3])
+		// В JSON и минифицированном JS слэш часто экранирован: "\/img\/a.png"
+		lit = strings.ReplaceAll(lit, `\/`, "/")
+		if l, ok := p.candidate(lit); ok {
+			links = append(links, l)
+		}
+	}
+	return resolveRawLinks(links, baseURL), nil
+}
+
+// candidate отбирает литерал, похожий на адрес сайта, и отсеивает шум:
+// одиночные символы, MIME-типы, регулярные выражения и шаблоны
+func (p *JSParser) candidate(lit string) (string, bool) {
+	if len(lit) < 3 || len(lit) > 2048 || mimeTypeRegex.MatchString(lit) ||
+		strings.ContainsAny(lit, " \t\r\n\\<>{}()[]|^*$`'\"") {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(lit, "//"), strings.HasPrefix(lit, "http://"), strings.HasPrefix(lit, "https://"):
+		u, err := url.Parse(lit)
+		if err != nil || !p.hosts.contains(u.Host) {
+			return "", false
+		}
+		return lit, true
+	case strings.HasPrefix(lit, "/"):
+		// "/." и "/-" — обрывки выражений, а не пути
+		if c := lit[1]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return "", false
+		}
+		return lit, true
+	}
+	return "", false
+}
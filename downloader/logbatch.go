@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLogFlushInterval — как часто GUI получает новые строки лога
+const DefaultLogFlushInterval = 250 * time.Millisecond
+
+// LogBatcher копит строки лога для GUI и отдаёт их пачкой не чаще раза в
+// interval. Если строк больше max, старые отбрасываются и считаются в
+// dropped: полный лог задачи пишется в файл (Job.LogFile), а интерфейсу
+// на больших обходах нужен только хвост.
+type LogBatcher struct {
+	interval time.Duration
+	max      int
+	flush    func(lines []string, dropped int)
+
+	mu      sync.Mutex
+	pending []string
+	dropped int
+	timer   *time.Timer
+
+	flushMu sync.Mutex // Пачки уходят по порядку
+}
+
+func NewLogBatcher(interval time.Duration, max int, flush func(lines []string, dropped int)) *LogBatcher {
+	if interval <= 0 {
+		interval = DefaultLogFlushInterval
+	}
+	if max <= 0 {
+		max = 1
+	}
+	return &LogBatcher{interval: interval, max: max, flush: flush}
+}
+
+// Add добавляет строку; первая строка пачки запускает таймер отправки
+func (b *LogBatcher) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, line)
+	if over := len(b.pending) - b.max; over > 0 {
+		b.pending = b.pending[over:]
+		b.dropped += over
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.Flush)
+	}
+}
+
+// Flush сразу отдаёт накопленное (например, в конце задачи)
+func (b *LogBatcher) Flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	lines, dropped := b.pending, b.dropped
+	b.pending, b.dropped = nil, 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(lines) > 0 || dropped > 0 {
+		b.flush(lines, dropped)
+	}
+}
@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger — куда загрузчик пишет о своей работе. Свой задаётся в
+// Config.Logger (приложение так выводит предупреждения в GUI); по
+// умолчанию (DefaultLogger) строки пишутся в OutputDir/downloader.log
+// с ротацией и, с Config.LogStderr, в стандартный log. Debug — подробности
+// по каждой ссылке и запросу (редиректы, переписанные ссылки, ответы):
+// на больших сайтах это миллионы строк, поэтому по умолчанию они выключены.
+// NormalizeURL и разбор ссылок не пишут в лог ничего.
+type Logger interface {
+	Debug(format string, args ...any)
+	Info(format string, args ...any)
+	Warn(format string, args ...any)
+	Error(format string, args ...any)
+}
+
+// Уровни Config.LogLevel; пустая строка — LogInfo
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// LogFileName — лог загрузчика в папке загрузок
+const LogFileName = "downloader.log"
+
+const (
+	logRotateSize = 10 << 20 // Размер, после которого файл уходит в downloader.log.1
+	logBackups    = 3        // Сколько старых файлов хранить
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"": levelInfo, LogDebug: levelDebug, LogInfo: levelInfo, LogWarn: levelWarn, LogError: levelError,
+}
+
+var levelTags = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func checkLogLevel(level string) error {
+	if _, ok := logLevels[strings.ToLower(level)]; !ok {
+		return fmt.Errorf("unknown log level %q (want %s, %s, %s or %s)", level, LogDebug, LogInfo, LogWarn, LogError)
+	}
+	return nil
+}
+
+// fileLogger — Logger по умолчанию
+type fileLogger struct {
+	level  logLevel
+	file   *rotatingFile // nil — без файла (OutputDir не задан, пробный прогон)
+	stderr bool
+	prefix string // "[host] " из Config.LogPrefix
+}
+
+// DefaultLogger — стандартный Logger для конфигурации: файл LogFileName
+// в cfg.OutputDir и, с cfg.LogStderr, стандартный log. Задачи с одной
+// OutputDir пишут в один файл; пробный прогон файла не пишет.
+func DefaultLogger(cfg Config) Logger {
+	l := &fileLogger{level: logLevels[strings.ToLower(cfg.LogLevel)], stderr: cfg.LogStderr}
+	if cfg.LogPrefix != "" {
+		l.prefix = "[" + cfg.LogPrefix + "] "
+	}
+	if cfg.OutputDir != "" && !cfg.DryRun {
+		l.file = openRotatingFile(filepath.Join(cfg.OutputDir, LogFileName))
+	}
+	return l
+}
+
+// newLogger — Config.Logger, а без него DefaultLogger
+func newLogger(cfg Config) Logger {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+	return DefaultLogger(cfg)
+}
+
+func (l *fileLogger) Debug(format string, args ...any) { l.logf(levelDebug, format, args...) }
+func (l *fileLogger) Info(format string, args ...any)  { l.logf(levelInfo, format, args...) }
+func (l *fileLogger) Warn(format string, args ...any)  { l.logf(levelWarn, format, args...) }
+func (l *fileLogger) Error(format string, args ...any) { l.logf(levelError, format, args...) }
+
+func (l *fileLogger) logf(level logLevel, format string, args ...any) {
+	// Уровень проверяем до Sprintf: выключенный Debug ничего не стоит
+	if level < l.level {
+		return
+	}
+	msg := l.prefix + strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	l.file.write(time.Now().Format("2006-01-02 15:04:05 ") + levelTags[level] + " " + msg + "\n")
+	if l.stderr {
+		log.Println(msg)
+	}
+}
+
+// rotatingFile дописывает строки в файл; файл больше logRotateSize
+// переименовывается в .1 (старые сдвигаются до .logBackups). Файл держится
+// открытым, размер считается в памяти; заново он открывается только после
+// ротации или ошибки записи. Строки, записанные до появления папки,
+// теряются.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File // nil — ещё не открыт или открыть не вышло
+	size int64
+}
+
+// logFiles — логи по пути: задачи одной папки делят файл и его ротацию
+var (
+	logFilesMu sync.Mutex
+	logFiles   = map[string]*rotatingFile{}
+)
+
+func openRotatingFile(path string) *rotatingFile {
+	logFilesMu.Lock()
+	defer logFilesMu.Unlock()
+	if f, ok := logFiles[path]; ok {
+		return f
+	}
+	f := &rotatingFile{path: path}
+	logFiles[path] = f
+	return f
+}
+
+func (r *rotatingFile) write(line string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil && !r.open() {
+		return
+	}
+	if r.size+int64(len(line)) > logRotateSize {
+		r.rotate()
+		if !r.open() {
+			return
+		}
+	}
+	n, err := r.f.WriteString(line)
+	r.size += int64(n)
+	if err != nil {
+		// Папку убрали или диск отвалился: следующая строка откроет файл заново
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// open открывает файл на дописывание и берёт его текущий размер
+func (r *rotatingFile) open() bool {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false
+	}
+	r.f, r.size = f, info.Size()
+	return true
+}
+
+// rotate закрывает текущий файл и сдвигает старые
+func (r *rotatingFile) rotate() {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	for i := logBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	os.Rename(r.path, r.path+".1")
+}
+
+// logger — лог задачи. До NewDownloader (loadJob читает состояние раньше)
+// — по конфигурации задачи.
+func (j *Job) logger() Logger {
+	if j.Downloader != nil {
+		return j.Downloader.log
+	}
+	return newLogger(j.Config)
+}
@@ -0,0 +1,257 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRunningJobs — сколько задач Manager ведёт одновременно; остальные ждут в очереди
+const DefaultMaxRunningJobs = 2
+
+// ErrJobActive — задача для этого URL уже в очереди менеджера или идёт
+var ErrJobActive = errors.New("job already queued or running")
+
+// JobStatus — состояние задачи в Manager
+type JobStatus string
+
+const (
+	JobQueued   JobStatus = "queued"   // Ждёт свободного слота
+	JobRunning  JobStatus = "running"  // Обход идёт (или стоит на паузе, см. JobInfo.Paused)
+	JobStopping JobStatus = "stopping" // Остановлена, очередь сохраняется для resume
+)
+
+// JobInfo — задача менеджера для списка в GUI и control API
+type JobInfo struct {
+	ID     string    `json:"id"`
+	URL    string    `json:"url"`
+	Status JobStatus `json:"status"`
+	Paused bool      `json:"paused"`
+	Added  time.Time `json:"added"`
+	Files  int64     `json:"files"`
+	Bytes  int64     `json:"bytes"`
+	Failed int64     `json:"failed"`
+	Speed  float64   `json:"speed"` // Байт в секунду
+}
+
+// ManagerProgress — суммарный прогресс всех задач менеджера
+type ManagerProgress struct {
+	Running int     `json:"running"`
+	Queued  int     `json:"queued"`
+	Files   int64   `json:"files"`
+	Bytes   int64   `json:"bytes"`
+	Failed  int64   `json:"failed"`
+	Speed   float64 `json:"speed"`
+}
+
+// Manager ведёт несколько задач сразу: не больше maxRunning идут
+// одновременно, остальные ждут в порядке постановки. Задачи одного
+// сайта (разделы, пакет из GUI) идут друг за другом. Задача видна
+// в List, пока не завершится, затем забывается.
+type Manager struct {
+	mu    sync.Mutex
+	slots chan struct{}
+	sites siteTurns
+	jobs  map[string]*managedJob
+	seq   int
+}
+
+type managedJob struct {
+	id       string
+	url      string // Нормализованный URL — по нему ловим повторный запуск
+	seq      int
+	added    time.Time
+	cancel   context.CancelFunc
+	job      *Job // nil, пока задача в очереди
+	stopping bool
+}
+
+// NewManager создаёт менеджер; maxRunning <= 0 — DefaultMaxRunningJobs
+func NewManager(maxRunning int) *Manager {
+	if maxRunning <= 0 {
+		maxRunning = DefaultMaxRunningJobs
+	}
+	return &Manager{
+		slots: make(chan struct{}, maxRunning),
+		jobs:  make(map[string]*managedJob),
+	}
+}
+
+// Start ставит задачу в очередь и сразу возвращается. Если задача для
+// того же URL уже есть, возвращает её и ErrJobActive. onDone вызывается
+// после завершения; для задачи, остановленной в очереди, — с ошибкой контекста.
+func (m *Manager) Start(ctx context.Context, opts RunOptions, onDone func(Summary, error)) (JobInfo, error) {
+	if opts.URL == "" {
+		return JobInfo{}, fmt.Errorf("empty URL")
+	}
+	id := JobID(opts.URL)
+	key, _ := NormalizeURL(opts.URL)
+
+	m.mu.Lock()
+	if mj := m.findLocked(id, key); mj != nil {
+		info := mj.info()
+		m.mu.Unlock()
+		return info, ErrJobActive
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	m.seq++
+	mj := &managedJob{id: id, url: key, seq: m.seq, added: time.Now(), cancel: cancel}
+	m.jobs[id] = mj
+	info := mj.info()
+	m.mu.Unlock()
+
+	go m.run(ctx, mj, opts, onDone)
+	return info, nil
+}
+
+func (m *Manager) run(ctx context.Context, mj *managedJob, opts RunOptions, onDone func(Summary, error)) {
+	var sum Summary
+	var err error
+	defer func() {
+		mj.cancel()
+		m.mu.Lock()
+		delete(m.jobs, mj.id)
+		m.mu.Unlock()
+		if onDone != nil {
+			onDone(sum, err)
+		}
+	}()
+
+	// Пока идёт задача того же сайта, слот не занимаем
+	leave, ok := m.sites.enter(ctx, siteKey(opts.URL))
+	if !ok {
+		err = ctx.Err()
+		return
+	}
+	defer leave()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+	// Слот и отмена могли прийти одновременно
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	onStart := opts.OnStart
+	opts.OnStart = func(j *Job) {
+		m.mu.Lock()
+		mj.job = j
+		m.mu.Unlock()
+		if onStart != nil {
+			onStart(j)
+		}
+	}
+	sum, err = Run(ctx, opts)
+}
+
+// findLocked ищет задачу по ID или нормализованному URL. Вызывать под m.mu.
+func (m *Manager) findLocked(id, key string) *managedJob {
+	if mj, ok := m.jobs[id]; ok {
+		return mj
+	}
+	for _, mj := range m.jobs {
+		if key != "" && mj.url == key {
+			return mj
+		}
+	}
+	return nil
+}
+
+// Lookup находит задачу по URL, с которым её запускали
+func (m *Manager) Lookup(urlStr string) (JobInfo, bool) {
+	key, _ := NormalizeURL(urlStr)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mj := m.findLocked(JobID(urlStr), key)
+	if mj == nil {
+		return JobInfo{}, false
+	}
+	return mj.info(), true
+}
+
+// List возвращает задачи в порядке постановки
+func (m *Manager) List() []JobInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*managedJob, 0, len(m.jobs))
+	for _, mj := range m.jobs {
+		jobs = append(jobs, mj)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].seq < jobs[b].seq })
+	list := make([]JobInfo, len(jobs))
+	for i, mj := range jobs {
+		list[i] = mj.info()
+	}
+	return list
+}
+
+// Get возвращает запущенную задачу; nil — если её нет или она ещё в очереди
+func (m *Manager) Get(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mj, ok := m.jobs[id]; ok {
+		return mj.job
+	}
+	return nil
+}
+
+// Stop останавливает задачу: идущая сохраняет очередь для resume,
+// ждущая в очереди просто снимается. false — такой задачи нет.
+func (m *Manager) Stop(id string) bool {
+	m.mu.Lock()
+	mj, ok := m.jobs[id]
+	var job *Job
+	if ok {
+		mj.stopping = true
+		job = mj.job
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if job != nil {
+		job.Cancel()
+	}
+	mj.cancel()
+	return true
+}
+
+// Progress суммирует прогресс всех задач
+func (m *Manager) Progress() ManagerProgress {
+	var p ManagerProgress
+	for _, info := range m.List() {
+		if info.Status == JobQueued {
+			p.Queued++
+		} else {
+			p.Running++
+		}
+		p.Files += info.Files
+		p.Bytes += info.Bytes
+		p.Failed += info.Failed
+		p.Speed += info.Speed
+	}
+	return p
+}
+
+// info — снимок задачи. Вызывать под m.mu.
+func (mj *managedJob) info() JobInfo {
+	info := JobInfo{ID: mj.id, URL: mj.url, Status: JobQueued, Added: mj.added}
+	if mj.job != nil {
+		s := mj.job.Snapshot()
+		info.Status = JobRunning
+		info.Paused = mj.job.Paused()
+		info.Files, info.Bytes, info.Failed, info.Speed = s.TotalFiles, s.DownloadedBytes, s.Failed, s.Speed
+	}
+	if mj.stopping {
+		info.Status = JobStopping
+	}
+	return info
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+)
+
+// nofollow (Config.RespectNoFollow): обход не идёт по гиперссылкам
+// <a rel="nofollow">, а со страницы с <meta name="robots"
+// content="nofollow"> (или noindex,nofollow, none) либо с заголовком
+// X-Robots-Tag: nofollow — ни по одной. Картинки, CSS и скрипты самой
+// страницы качаются как обычно: без них копия не откроется. Ссылка
+// nofollow, на которую есть обычная ссылка с другой страницы, скачается.
+
+// ReasonNoFollow — почему ссылка не обходится
+const ReasonNoFollow = "nofollow"
+
+// relNoFollow — у ссылки rel="nofollow" (rel может перечислять несколько значений)
+func relNoFollow(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if a.Key == "rel" && hasRobotsDirective(strings.Fields(a.Val), "nofollow") {
+			return true
+		}
+	}
+	return false
+}
+
+// metaNoFollow — в документе есть <meta name="robots"> с nofollow
+func metaNoFollow(doc *html.Node) bool {
+	var found bool
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			var name, content string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case "name":
+					name = a.Val
+				case "content":
+					content = a.Val
+				}
+			}
+			if strings.EqualFold(strings.TrimSpace(name), "robots") && robotsNoFollow(content) {
+				found = true
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return found
+}
+
+// robotsTagNoFollow — X-Robots-Tag ответа запрещает ходить по ссылкам.
+// Значения для конкретного бота ("googlebot: nofollow") нас не касаются.
+func robotsTagNoFollow(header http.Header) bool {
+	for _, v := range header.Values("X-Robots-Tag") {
+		if agent, rest, ok := strings.Cut(v, ":"); ok && !strings.Contains(agent, ",") &&
+			!strings.EqualFold(strings.TrimSpace(agent), "unavailable_after") {
+			if strings.TrimSpace(agent) != "*" {
+				continue
+			}
+			v = rest
+		}
+		if robotsNoFollow(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// robotsNoFollow — в списке директив robots ("noindex, nofollow") есть nofollow или none
+func robotsNoFollow(content string) bool {
+	directives := strings.FieldsFunc(content, func(r rune) bool { return r == ',' || r == ' ' })
+	return hasRobotsDirective(directives, "nofollow") || hasRobotsDirective(directives, "none")
+}
+
+func hasRobotsDirective(directives []string, want string) bool {
+	for _, d := range directives {
+		if strings.EqualFold(strings.TrimSpace(d), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWith разбирает content парсером; HTMLParser с NoFollow отдаёт
+// ссылки nofollow отдельно — они учитываются как пропущенные
+func (j *Job) parseWith(parser ContentParser, content []byte, baseURL string, header http.Header) ([]string, error) {
+	hp, ok := parser.(*HTMLParser)
+	if !ok || !hp.NoFollow {
+		return parser.Parse(content, baseURL)
+	}
+	links, nofollow, err := hp.parse(content, baseURL, robotsTagNoFollow(header))
+	if err != nil {
+		return nil, err
+	}
+	if !j.targets[baseURL] {
+		j.skipNoFollow(nofollow)
+	}
+	return links, nil
+}
+
+// skipNoFollow учитывает ссылки nofollow в Skipped — по разу на URL и
+// только те, что обход иначе скачал бы
+func (j *Job) skipNoFollow(rawLinks []string) {
+	for _, rawLink := range rawLinks {
+		normalized, err := j.canonicalURL(rawLink)
+		if err != nil {
+			continue
+		}
+		if !j.Filter.ShouldDownload(normalized) {
+			continue
+		}
+
+		j.mu.Lock()
+		seen := j.visitedLocked(normalized) || j.skipReasons[normalized] == ReasonNoFollow
+		if !seen {
+			if j.skipReasons == nil {
+				j.skipReasons = make(map[string]string)
+			}
+			j.skipReasons[normalized] = ReasonNoFollow
+		}
+		j.mu.Unlock()
+		if seen {
+			continue
+		}
+		j.sendLog(fmt.Sprintf("[Skip] %s: %s", normalized, ReasonNoFollow), false)
+		atomic.AddInt64(&j.stats.Skipped, 1)
+		j.dryRun.filter(normalized, ReasonNoFollow)
+	}
+}
+
+// unskipNoFollow снимает пропуск nofollow с URL, на который нашлась
+// обычная ссылка: он скачается и пропущенным не считается. Вызывать под j.mu.
+func (j *Job) unskipNoFollow(normalized string) {
+	if j.skipReasons[normalized] != ReasonNoFollow {
+		return
+	}
+	delete(j.skipReasons, normalized)
+	atomic.AddInt64(&j.stats.Skipped, -1)
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// StateVersion — версия формата JobState. 1 (поле не заполнено) — без
+// Completed и Failed: при загрузке сохранённые URL берутся из манифеста.
+const StateVersion = 2
+
+// Исход обработки URL переживает resume: сохранённые не качаются заново,
+// даже если остались в очереди последнего чекпоинта (упали между
+// сохранением файла и чекпоинтом), а упавшие можно поставить в очередь
+// снова (ResumeOptions.RetryFailed).
+
+// noteCompleted отмечает URL сохранёнными (запрошенный и, после редиректа, финальный)
+func (j *Job) noteCompleted(urls ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.completed == nil {
+		j.completed = make(map[string]bool)
+	}
+	for _, u := range urls {
+		if j.completed[u] {
+			continue
+		}
+		j.completed[u] = true
+		j.newCompleted = append(j.newCompleted, u)
+		delete(j.failed, u)
+		delete(j.failures, u)
+		// Ссылающиеся страницы нужны битым ссылкам и relink до сохранения
+		delete(j.referrers, u)
+	}
+}
+
+// noteFailed запоминает последнюю ошибку URL
+func (j *Job) noteFailed(urlStr string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.noteFailedLocked(urlStr, err)
+}
+
+func (j *Job) noteFailedLocked(urlStr string, err error) {
+	if j.failed == nil {
+		j.failed = make(map[string]string)
+	}
+	if j.newFailed == nil {
+		j.newFailed = make(map[string]string)
+	}
+	if j.failures == nil {
+		j.failures = make(map[string]failureInfo)
+	}
+	j.failed[urlStr] = err.Error()
+	j.newFailed[urlStr] = err.Error()
+	j.failures[urlStr] = failureOf(err)
+}
+
+// completedList — сохранённые URL по порядку. Вызывать под j.mu.
+func (j *Job) completedList() []string {
+	urls := make([]string, 0, len(j.completed))
+	for u := range j.completed {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// failedCopy — копия ошибок для снимка. Вызывать под j.mu.
+func (j *Job) failedCopy() map[string]string {
+	j.foldPanics()
+	if len(j.failed) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(j.failed))
+	for u, e := range j.failed {
+		out[u] = e
+	}
+	return out
+}
+
+// migrateState поднимает состояние старой версии: сохранённые URL берутся
+// из манифеста задачи, ошибок прошлых запусков не восстановить
+func migrateState(state *JobState, manifestFile string) {
+	if state.Version >= StateVersion {
+		return
+	}
+	if len(state.Completed) == 0 {
+		entries, _ := LoadManifest(manifestFile)
+		for _, e := range entries {
+			state.Completed = append(state.Completed, e.URL)
+		}
+	}
+	state.Version = StateVersion
+}
+
+// requeueFailed ставит в очередь URL, упавшие в прошлых запусках.
+// Возвращает их число.
+func (j *Job) requeueFailed() int {
+	j.mu.Lock()
+	j.foldPanics()
+	var urls []string
+	for u := range j.failed {
+		if !j.completed[u] {
+			urls = append(urls, u)
+		}
+	}
+	j.mu.Unlock()
+	sort.Strings(urls)
+
+	for _, u := range urls {
+		j.activeWG.Add(1)
+		j.enqueue(u, j.depthOf(u))
+	}
+	n := len(urls)
+	// Повтор посчитается заново, если снова упадёт
+	if failed := atomic.AddInt64(&j.stats.Failed, -int64(n)); failed < 0 {
+		atomic.StoreInt64(&j.stats.Failed, 0)
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Один и тот же путь на сайтах пишут по-разному: /статьи, /%D1%81%D1%82...,
+// /%d1%81%d1%82..., /%7Euser и /~user. Ключом очереди и реестра служит одно
+// написание (canonicalPath), а на диске путь лежит раскодированным в UTF-8
+// (DiskPath берёт url.URL.Path), с заменой недопустимого — см. sanitize.go.
+// Ссылки на файлы собираются через url.URL и кодируются заново.
+
+// canonicalPath приводит экранированный путь к виду RFC 3986: незарезервированные
+// символы (буквы, цифры, -._~) раскодируются, остальные %XX пишутся заглавными,
+// не-ASCII и пробелы кодируются. Закодированные разделители (%2F, %2B)
+// остаются закодированными — для сервера это другой путь.
+func canonicalPath(escaped string) string {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c == '%' && i+2 < len(escaped) && isHex(escaped[i+1]) && isHex(escaped[i+2]) {
+			v := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+			if isUnreserved(v) {
+				b.WriteByte(v)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", v)
+			}
+			i += 2
+			continue
+		}
+		if c == '%' || c <= 0x20 || c >= 0x7f || strings.IndexByte("\"<>\\^`{|}", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
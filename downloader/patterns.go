@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Шаблоны Config.IncludePatterns / ExcludePatterns. По умолчанию это glob
+// по пути URL: * — любые символы внутри сегмента, ** — через сегменты,
+// ? — один символ ("/tag/*", "/docs/**", "**/*.pdf"); слэш в конце пути
+// не мешает: "/tag/*" ловит и /tag/go/. С префиксом "re:" —
+// регулярное выражение по пути вместе с query ("re:^/page/\d+$").
+//
+// Исключения действуют на все URL и важнее включений. Включения отбирают
+// страницы; ассеты (CSS, картинки, шрифты) качаются и вне их, иначе
+// отобранные страницы открывались бы без стилей. Пустой список включений
+// разрешает всё.
+
+// urlPattern — скомпилированный шаблон и его исходный текст для FilterReason
+type urlPattern struct {
+	raw   string
+	re    *regexp.Regexp
+	query bool // Сопоставлять с path?query (regexp), а не только с path
+}
+
+// compilePatterns компилирует шаблоны; ошибка называет неверный шаблон
+func compilePatterns(list []string) ([]urlPattern, error) {
+	var out []urlPattern
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(raw, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", raw, err)
+			}
+			out = append(out, urlPattern{raw: raw, re: re, query: true})
+			continue
+		}
+		out = append(out, urlPattern{raw: raw, re: regexp.MustCompile(globRegexp(raw))})
+	}
+	return out, nil
+}
+
+// ValidatePatterns проверяет шаблоны заранее, до запуска задачи
+func ValidatePatterns(list []string) error {
+	_, err := compilePatterns(list)
+	return err
+}
+
+// globRegexp переводит glob в регулярное выражение на весь путь.
+// Glob без ведущего "/" может начинаться на любой глубине.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.HasPrefix(glob, "/") {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			// "**/" совпадает и с пустым префиксом: /docs/**/x.html ловит /docs/x.html
+			if i+1 < len(glob) && glob[i+1] == '/' {
+				i++
+				b.WriteString("(?:.*/)?")
+			} else {
+				b.WriteString(".*")
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	if !strings.HasSuffix(glob, "/") {
+		// URL-каталог: /tag/go/ — тот же /tag/go
+		b.WriteString("/?")
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// matchPattern — первый совпавший шаблон списка
+func matchPattern(patterns []urlPattern, u *url.URL) (string, bool) {
+	for _, p := range patterns {
+		target := u.Path
+		if p.query {
+			target = u.RequestURI()
+		}
+		if p.re.MatchString(target) {
+			return p.raw, true
+		}
+	}
+	return "", false
+}
+
+// newURLFilter — фильтр задачи: домен, базовый путь и шаблоны из Config
+func newURLFilter(root *url.URL, cfg Config) (*DefaultURLFilter, error) {
+	include, err := compilePatterns(cfg.IncludePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("include %w", err)
+	}
+	exclude, err := compilePatterns(cfg.ExcludePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("exclude %w", err)
+	}
+	return &DefaultURLFilter{
+		domain:   root.Host,
+		basePath: root.Path,
+		include:  include,
+		exclude:  exclude,
+		external: newExternalAssets(cfg),
+		hosts:    newSiteHosts(root.Host, cfg),
+		blocked:  cfg.BlockedURLSubstrings,
+	}, nil
+}
@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// JobPaused — задача на паузе: воркеры не берут новые URL
+const JobPaused JobPhase = "paused"
+
+var (
+	ErrAlreadyPaused = errors.New("job is already paused")
+	ErrNotPaused     = errors.New("job is not paused")
+	ErrJobFinished   = errors.New("job is not running")
+)
+
+// pauseGate стоит между воркерами и очередью. Воркер входит в ворота перед
+// тем, как ждать URL, и выходит, когда URL обработан: так пауза знает,
+// сколько загрузок ещё в полёте, и не обрывает их. Нулевое значение готово
+// к работе.
+type pauseGate struct {
+	mu       sync.Mutex
+	paused   bool
+	inflight int
+	pausing  chan struct{} // Закрывается при постановке на паузу — будит ждущих URL
+	resumed  chan struct{} // Закрывается при снятии паузы
+	drained  chan struct{} // Закрывается, когда на паузе не осталось занятых воркеров
+}
+
+// enter ждёт снятия паузы и занимает место воркера. Возвращает канал,
+// который закроется при следующей паузе; false — ctx отменён.
+func (g *pauseGate) enter(ctx context.Context) (<-chan struct{}, bool) {
+	for {
+		g.mu.Lock()
+		if !g.paused {
+			g.inflight++
+			if g.pausing == nil {
+				g.pausing = make(chan struct{})
+			}
+			pausing := g.pausing
+			g.mu.Unlock()
+			return pausing, true
+		}
+		resumed := g.resumed
+		g.mu.Unlock()
+
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// leave освобождает место, занятое enter
+func (g *pauseGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inflight--
+	if g.inflight == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+}
+
+// pause закрывает ворота. Канал закроется, когда все взятые URL обработаны
+// (или паузу сняли раньше).
+func (g *pauseGate) pause() (<-chan struct{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return nil, false
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	if g.pausing != nil {
+		close(g.pausing)
+		g.pausing = nil
+	}
+	drained := make(chan struct{})
+	if g.inflight == 0 {
+		close(drained)
+	} else {
+		g.drained = drained
+	}
+	return drained, true
+}
+
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	if g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Pause останавливает выдачу новых URL воркерам. Загрузки, которые уже идут,
+// докачиваются и сохраняются: Pause ждёт их, затем пишет очередь в журнал
+// состояния и отправляет событие паузы. Если процесс завершится на паузе,
+// задачу можно продолжить через Resume(ctx, stateFile, ...).
+func (j *Job) Pause() error {
+	if j.ctx == nil || j.ctx.Err() != nil {
+		return ErrJobFinished
+	}
+	drained, ok := j.pause.pause()
+	if !ok {
+		return ErrAlreadyPaused
+	}
+	j.sendLog("⏸ Пауза: ждём завершения текущих загрузок...", false)
+
+	select {
+	case <-drained:
+	case <-j.ctx.Done():
+		return j.ctx.Err()
+	}
+	if !j.pause.isPaused() {
+		// Паузу сняли, пока докачивались текущие URL
+		return nil
+	}
+
+	if err := j.checkpoint(); err != nil {
+		j.sendLog(fmt.Sprintf("[Error] Не удалось сохранить очередь: %v", err), false)
+		return err
+	}
+	queued, overflow := j.QueueDepth()
+	j.sendLog(fmt.Sprintf("⏸ Задача на паузе, очередь сохранена (%d URL)", queued+overflow), false)
+	j.emit(&event{kind: eventPhase, phase: JobPaused})
+	return nil
+}
+
+// Resume снимает паузу. Очередь остаётся в памяти и файле переполнения,
+// а посещённые URL помнятся — уже скачанные файлы повторно не загружаются.
+func (j *Job) Resume() error {
+	// После бюджета ворота закрыты до конца задачи
+	if j.ctx == nil || j.ctx.Err() != nil || j.budgetReached() != nil {
+		return ErrJobFinished
+	}
+	if !j.pause.resume() {
+		return ErrNotPaused
+	}
+	j.sendLog("▶ Загрузка продолжена", false)
+	j.emit(&event{kind: eventPhase, phase: j.progress.currentPhase()})
+	return nil
+}
+
+// Paused — стоит ли задача на паузе
+func (j *Job) Paused() bool {
+	return j.pause.isPaused()
+}
@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultProbeTimeout = 10 * time.Second
+	DefaultProbeBudget  = 500
+)
+
+// ErrProbeBudget — у фичи закончился лимит проверочных запросов
+var ErrProbeBudget = errors.New("probe budget exhausted")
+
+// newTransport — общий транспорт для скачивания и проверочных запросов
+func newTransport(c Config) *http.Transport {
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		MaxIdleConns:    c.Workers * 2,
+		IdleConnTimeout: 30 * time.Second,
+	}
+}
+
+// setRequestHeaders выставляет те же заголовки, что и у основного обхода,
+// чтобы HEAD-проверки не блокировались там, где скачивание проходит;
+// ua — выбранный для запроса User-Agent (см. useragent.go). Config.Headers
+// (токены, Host) уходят только хостам сайта: siteHost решает, nil — всем.
+func setRequestHeaders(req *http.Request, c Config, ua string, siteHost func(string) bool) {
+	setBrowserHeaders(req, ua)
+
+	// Используем домен целевого URL в качестве Referer (более надежно)
+	req.Header.Set("Referer", req.URL.Scheme+"://"+req.URL.Host+"/")
+	req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7")
+
+	if siteHost != nil && !siteHost(req.URL.Host) {
+		return
+	}
+	for k, v := range c.Headers {
+		// Host в req.Header net/http игнорирует — он задаётся через req.Host
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+}
+
+// ParseHeader разбирает заголовок в виде "Name: value" (как у curl -H)
+func ParseHeader(line string) (name, value string, err error) {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("header %q: expected \"Name: value\"", line)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// ParseHeaders собирает Config.Headers из строк "Name: value"
+func ParseHeaders(lines []string) (map[string]string, error) {
+	headers := make(map[string]string, len(lines))
+	for _, line := range lines {
+		name, value, err := ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// Prober — клиент для HEAD/GET проверок (предпросмотр, оценка размера,
+// аудит ссылок). Собирается из того же Config, что и Downloader,
+// но с более коротким таймаутом и лимитом запросов на одну фичу.
+type Prober struct {
+	cfg    Config
+	client *http.Client
+	budget int64 // Осталось запросов
+
+	siteHost func(host string) bool // Кому уходят Config.Headers; nil — всем
+
+	mu   sync.Mutex
+	last time.Time // Время последнего запроса (для Delay)
+}
+
+// NewProber создаёт клиент с собственным лимитом запросов;
+// budget <= 0 — DefaultProbeBudget
+func NewProber(c Config, budget int) *Prober {
+	timeout := c.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	if budget <= 0 {
+		budget = DefaultProbeBudget
+	}
+	return &Prober{
+		cfg: c,
+		client: &http.Client{
+			Transport: newTransport(c),
+			Timeout:   timeout,
+		},
+		budget: int64(budget),
+	}
+}
+
+// Remaining — сколько запросов ещё можно сделать
+func (p *Prober) Remaining() int {
+	if n := atomic.LoadInt64(&p.budget); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+// Head выполняет HEAD-запрос; тело ответа закрывает вызывающий
+func (p *Prober) Head(ctx context.Context, rawURL string) (*http.Response, error) {
+	return p.do(ctx, http.MethodHead, rawURL)
+}
+
+// Get выполняет GET-запрос; тело ответа закрывает вызывающий
+func (p *Prober) Get(ctx context.Context, rawURL string) (*http.Response, error) {
+	return p.do(ctx, http.MethodGet, rawURL)
+}
+
+func (p *Prober) do(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	if atomic.AddInt64(&p.budget, -1) < 0 {
+		return nil, ErrProbeBudget
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, ErrInvalidURL
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req, p.cfg, p.cfg.userAgent(), p.siteHost)
+
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	return p.client.Do(req)
+}
+
+// wait выдерживает Delay между запросами, как и основной обход
+func (p *Prober) wait(ctx context.Context) error {
+	if p.cfg.Delay <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	next := p.last.Add(p.cfg.Delay)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	p.last = next
+	p.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(next)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Query у ассетов (Config.AssetQueries). /app.css?v=1.2.3 и /app.css?v=1.2.4 —
+// один файл с разными cache buster'ами: такие параметры убираются ещё до
+// очереди, и файл качается один раз. Значимый query (/font.css?family=Inter)
+// остаётся в URL, а DiskPath переносит его в имя файла:
+// font.css@family=Inter.css — варианты больше не затирают друг друга.
+// AssetQueryIgnore — прежнее поведение: query ассета отбрасывается целиком.
+
+// Политики Config.AssetQueries
+const (
+	AssetQueryEncode = "encode" // По умолчанию: cache buster'ы убрать, остальное — в имя файла
+	AssetQueryIgnore = "ignore" // Query ассетов не учитывать: один файл на путь
+)
+
+// DefaultCacheBusters — параметры, которые не меняют содержимое ассета
+var DefaultCacheBusters = []string{"v", "ver", "rev", "t", "hash"}
+
+// maxQueryName — длиннее query в имени файла заменяется хешем
+const maxQueryName = 80
+
+// assetQueries — политика задачи для query ассетов
+type assetQueries struct {
+	ignore  bool
+	busters map[string]bool
+}
+
+func newAssetQueries(cfg Config) assetQueries {
+	q := assetQueries{ignore: cfg.AssetQueries == AssetQueryIgnore, busters: make(map[string]bool)}
+	list := cfg.CacheBusters
+	if len(list) == 0 {
+		list = DefaultCacheBusters
+	}
+	for _, name := range list {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			q.busters[name] = true
+		}
+	}
+	return q
+}
+
+// apply убирает из query ассета cache buster'ы (или весь query в режиме
+// ignore). URL страниц не меняются.
+func (q assetQueries) apply(u *url.URL) *url.URL {
+	if u.RawQuery == "" || !isAssetPath(strings.ToLower(u.Path)) || (!q.ignore && len(q.busters) == 0) {
+		return u
+	}
+	out := *u
+	if q.ignore {
+		out.RawQuery = ""
+		return &out
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if key, err := url.QueryUnescape(name); err == nil && q.busters[strings.ToLower(key)] {
+			continue
+		}
+		if pair != "" {
+			kept = append(kept, pair)
+		}
+	}
+	out.RawQuery = strings.Join(kept, "&")
+	return &out
+}
+
+// canonical — то же для строки URL; неразобранный URL возвращается как есть
+func (q assetQueries) canonical(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+	if res := q.apply(u); res != u {
+		return res.String()
+	}
+	return urlStr
+}
+
+// queryInName — query ассета, который DiskPath переносит в имя файла
+func queryInName(u *url.URL) bool {
+	return u.RawQuery != "" && isAssetPath(strings.ToLower(u.Path))
+}
+
+// withQuery вставляет query в имя файла перед расширением:
+// app.css + v=2&lang=ru → app.css@v=2&lang=ru.css. Символы, запрещённые
+// в именах файлов, заменяются на "_", длинный query — хешем.
+func withQuery(p, rawQuery string) string {
+	q := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, rawQuery)
+	if len(q) > maxQueryName {
+		sum := sha256.Sum256([]byte(rawQuery))
+		q = hex.EncodeToString(sum[:8])
+	}
+	return p + "@" + q + path.Ext(p)
+}
@@ -0,0 +1,315 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultQueueSize  = 5000
+	OverflowExtension = ".overflow.txt"
+)
+
+var errNoOverflow = errors.New("overflow queue is not configured")
+
+// overflowQueue — FIFO на диске для URL, не поместившихся в очередь в памяти.
+// Файл открывается лениво при первом переполнении и обнуляется, когда опустеет.
+type overflowQueue struct {
+	mu    sync.Mutex
+	path  string
+	w     *os.File // Дозапись (O_APPEND)
+	r     *os.File
+	br    *bufio.Reader
+	count int // Строк в файле, ещё не взятых через next
+
+	head    string // Взят, но ещё не доставлен в очередь
+	hasHead bool
+
+	ready chan struct{} // Сигнал фидеру о новых записях
+
+	logger func() Logger // Лог задачи: Downloader может появиться позже очереди
+}
+
+func newOverflowQueue(path string, logger func() Logger) *overflowQueue {
+	return &overflowQueue{path: path, ready: make(chan struct{}, 1), logger: logger}
+}
+
+func (q *overflowQueue) push(u string) error {
+	if q == nil {
+		return errNoOverflow
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.w == nil {
+		w, err := os.OpenFile(q.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(q.path)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		q.w, q.r, q.br = w, r, bufio.NewReader(r)
+	}
+	if _, err := q.w.WriteString(u + "\n"); err != nil {
+		return err
+	}
+	q.count++
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next возвращает очередной URL, не удаляя его до вызова delivered:
+// так снимок состояния не теряет URL, который фидер держит в руках.
+func (q *overflowQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.hasHead {
+		return q.head, true
+	}
+	if q.count == 0 {
+		return "", false
+	}
+	line, err := q.br.ReadString('\n')
+	if err != nil {
+		q.logger().Error("Ошибка чтения файла переполнения: %v", err)
+		return "", false
+	}
+	q.count--
+	q.head, q.hasHead = strings.TrimSuffix(line, "\n"), true
+	return q.head, true
+}
+
+func (q *overflowQueue) delivered() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.head, q.hasHead = "", false
+	if q.count == 0 && q.w != nil {
+		// Всё прочитано — обнуляем файл, чтобы он не рос бесконечно
+		if err := q.w.Truncate(0); err == nil {
+			q.r.Seek(0, io.SeekStart)
+			q.br.Reset(q.r)
+		}
+	}
+}
+
+// Len — сколько URL ждёт на диске
+func (q *overflowQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.hasHead {
+		return q.count + 1
+	}
+	return q.count
+}
+
+// snapshot читает оставшиеся URL, не сдвигая позицию чтения
+func (q *overflowQueue) snapshot() []string {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var res []string
+	if q.hasHead {
+		res = append(res, q.head)
+	}
+	if q.count == 0 {
+		return res
+	}
+
+	pos, err := q.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return res
+	}
+	pos -= int64(q.br.Buffered())
+
+	f, err := os.Open(q.path)
+	if err != nil {
+		return res
+	}
+	defer f.Close()
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return res
+	}
+	sc := bufio.NewScanner(f)
+	for i := 0; i < q.count && sc.Scan(); i++ {
+		res = append(res, sc.Text())
+	}
+	return res
+}
+
+// close закрывает и удаляет файл; содержимое к этому моменту уже в снимке состояния
+func (q *overflowQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.w == nil {
+		return
+	}
+	q.w.Close()
+	q.r.Close()
+	q.w, q.r, q.br = nil, nil, nil
+	os.Remove(q.path)
+}
+
+func (j *Job) overflowFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + OverflowExtension
+}
+
+// pendingSet — URL, поставленные в очередь и ещё не обработанные: в куче,
+// в файле переполнения и у воркеров. Снимок очереди для состояния берётся
+// отсюда, а не вычерпыванием очереди, которое гонялось с воркерами; URL,
+// прерванный на середине, остаётся в снимке и вернётся при resume.
+// Один URL может стоять в очереди несколько раз (повтор), поэтому счётчик.
+type pendingSet struct {
+	mu    sync.Mutex
+	seq   int64
+	items map[string]*pendingItem
+}
+
+type pendingItem struct {
+	seq  int64 // Порядок первой постановки — в нём URL вернутся при resume
+	refs int
+}
+
+func newPendingSet() *pendingSet {
+	return &pendingSet{items: make(map[string]*pendingItem)}
+}
+
+func (s *pendingSet) add(u string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, ok := s.items[u]; ok {
+		it.refs++
+		return
+	}
+	s.seq++
+	s.items[u] = &pendingItem{seq: s.seq, refs: 1}
+}
+
+// done снимает одну постановку URL; последняя убирает его из очереди
+func (s *pendingSet) done(u string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, ok := s.items[u]; ok {
+		if it.refs--; it.refs <= 0 {
+			delete(s.items, u)
+		}
+	}
+}
+
+// snapshot возвращает URL в порядке постановки
+func (s *pendingSet) snapshot() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	urls := make([]string, 0, len(s.items))
+	for u := range s.items {
+		urls = append(urls, u)
+	}
+	sort.Slice(urls, func(a, b int) bool { return s.items[urls[a]].seq < s.items[urls[b]].seq })
+	s.mu.Unlock()
+	return urls
+}
+
+// initQueue создаёт очередь обхода (см. frontier.go), канал pending и
+// файл переполнения; пробный прогон на диск не пишет и обходится без него
+func (j *Job) initQueue() {
+	size := j.Config.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	j.pending = make(chan string)
+	j.frontier = newFrontier(j.Config.Strategy, size)
+	if !j.Config.DryRun {
+		j.overflow = newOverflowQueue(j.overflowFile(), j.logger)
+	}
+	j.queued = newPendingSet()
+}
+
+// enqueue ставит URL в очередь, не блокируя воркера: если в памяти уже
+// QueueSize URL, он уходит в файл переполнения. activeWG увеличивает вызывающий.
+func (j *Job) enqueue(u string, depth int) {
+	// Учитываем до постановки: воркер может взять и завершить URL сразу
+	j.queued.add(u)
+	if j.frontier.tryPush(u, depth) {
+		return
+	}
+	if err := j.overflow.push(u); err == nil {
+		return
+	} else if err != errNoOverflow {
+		j.logger().Error("Не удалось записать в файл переполнения: %v", err)
+	}
+	// Без файла переполнения очередь в памяти растёт без ограничения
+	j.frontier.push(u, depth)
+}
+
+// feeder возвращает URL из файла переполнения в очередь по мере освобождения места
+func (j *Job) feeder() {
+	if j.overflow == nil {
+		return
+	}
+	for {
+		u, ok := j.overflow.next()
+		if !ok {
+			select {
+			case <-j.ctx.Done():
+				return
+			case <-j.overflow.ready:
+				continue
+			}
+		}
+		if !j.frontier.hasRoom() {
+			select {
+			case <-j.ctx.Done():
+				return
+			case <-j.frontier.room:
+				continue
+			}
+		}
+		j.frontier.push(u, j.depthOf(u))
+		j.overflow.delivered()
+	}
+}
+
+// discardPending снимает с activeWG URL, оставшиеся в очереди после отмены.
+// Они уже записаны в состояние; без этого горутина, закрывающая pending,
+// навсегда зависла бы в ожидании activeWG. Вызывать после остановки
+// воркеров, диспетчера и фидера.
+func (j *Job) discardPending() {
+	for i := j.overflow.Len() + len(j.frontier.drain()); i > 0; i-- {
+		j.activeWG.Done()
+	}
+}
+
+// QueueDepth возвращает размер очереди в памяти и на диске
+func (j *Job) QueueDepth() (queued, overflow int) {
+	return j.frontier.Len(), j.overflow.Len()
+}
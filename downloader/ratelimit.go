@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter — общий для всех воркеров лимит трафика (токен-бакет
+// в байтах). Запас не больше секунды трафика, так что суммарная скорость
+// не превышает MaxBytesPerSecond даже короткими всплесками.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Байт в секунду
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter: rate <= 0 — без лимита (nil)
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// chunk — сколько байт читать за раз, чтобы не занимать лимит надолго
+func (l *bandwidthLimiter) chunk() int {
+	n := int(l.rate / 10)
+	if n < 512 {
+		n = 512
+	}
+	return n
+}
+
+// wait списывает n байт; если запаса нет — ждёт, пока он накопится
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttleAllowance — запас к таймауту на чтение тела размером size (см.
+// timeouts.go): с лимитом трафика оно идёт дольше, и обычный таймаут
+// обрывал бы его. Лимит общий на всех воркеров, так что при Workers
+// параллельных загрузках каждой достаётся лишь его доля.
+func throttleAllowance(c Config, size int64) time.Duration {
+	if c.MaxBytesPerSecond <= 0 || size <= 0 {
+		return 0
+	}
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return time.Duration(float64(size) * float64(workers) / float64(c.MaxBytesPerSecond) * float64(time.Second))
+}
+
+// throttledReader читает тело ответа не быстрее общего лимита
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if max := t.lim.chunk(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.lim.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttle оборачивает body лимитом; без лимита возвращает его как есть
+func (l *bandwidthLimiter) throttle(ctx context.Context, body io.Reader) io.Reader {
+	if l == nil {
+		return body
+	}
+	return &throttledReader{ctx: ctx, r: body, lim: l}
+}
@@ -0,0 +1,258 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Редиректы. Ответ на /old, переехавший на /new/, сохраняется по пути
+// /new/ — туда же, куда ведут ссылки других страниц, — а /old становится
+// алиасом этого файла. Карта исходный URL → финальный нужна переписыванию
+// ссылок (ссылка на /old ведёт в файл /new/, даже если /new/ ещё не скачан)
+// и сохраняется в состоянии задачи для продолжения.
+//
+// Запрос проходит не больше Config.MaxRedirects редиректов: петля
+// останавливается сразу ошибкой без повторов, а не крутится до лимита
+// http.Client на каждой попытке. Редирект на чужой хост по умолчанию не
+// выполняется: фильтр URL проверял исходный адрес, и без этого задача
+// скачала бы страницу другого сайта.
+
+// DefaultMaxRedirects — сколько редиректов подряд проходит запрос
+const DefaultMaxRedirects = 10
+
+// RedirectError — запрос остановлен на редиректе: цепочка длиннее
+// MaxRedirects или (Offsite) редирект ведёт с сайта. DownloadError с ней
+// окончательная.
+type RedirectError struct {
+	Hops    []string // Адреса, ответившие редиректом, от запрошенного
+	Target  string   // Куда вёл последний редирект
+	Offsite bool
+}
+
+func (e *RedirectError) Error() string {
+	if e.Offsite {
+		return "redirected off-site to " + e.Target
+	}
+	return fmt.Sprintf("too many redirects (%d): %s → %s", len(e.Hops), strings.Join(e.Hops, " → "), e.Target)
+}
+
+// maxRedirects — лимит из Config; < 0 — ни одного
+func (d *Downloader) maxRedirects() int {
+	switch {
+	case d.cfg.MaxRedirects == 0:
+		return DefaultMaxRedirects
+	case d.cfg.MaxRedirects < 0:
+		return 0
+	}
+	return d.cfg.MaxRedirects
+}
+
+// checkRedirect — CheckRedirect клиента: via — уже сделанные запросы,
+// каждый из них ответил редиректом
+func (d *Downloader) checkRedirect(r *http.Request, via []*http.Request) error {
+	d.log.Debug("Redirect: %s → %s", via[len(via)-1].URL, r.URL)
+	hops := func() []string {
+		hops := make([]string, len(via))
+		for i, v := range via {
+			hops[i] = v.URL.String()
+		}
+		return hops
+	}
+	if len(via) > d.maxRedirects() {
+		return &RedirectError{Hops: hops(), Target: r.URL.String()}
+	}
+	if !d.cfg.FollowExternalRedirects && d.offsiteRedirect(via[0].URL, r.URL) {
+		return &RedirectError{Hops: hops(), Target: r.URL.String(), Offsite: true}
+	}
+	// net/http переносит заголовки первого запроса на каждый шаг:
+	// Config.Headers не должны уйти на CDN вслед за редиректом
+	if d.siteHost != nil && !d.siteHost(r.URL.Host) {
+		for k := range d.cfg.Headers {
+			r.Header.Del(k)
+		}
+	}
+	return nil
+}
+
+// offsiteRedirect — редирект на to уводит с сайта. У задачи решает она
+// (Job.offsiteRedirect), иначе — другой хост, чем у запроса from (www.
+// и голый домен — один хост).
+func (d *Downloader) offsiteRedirect(from, to *url.URL) bool {
+	if d.offsite != nil {
+		return d.offsite(to)
+	}
+	return !newSiteHosts(from.Host, d.cfg).contains(to.Host)
+}
+
+// offsiteRedirect — хост не сайта задачи, и с него задача не взяла бы
+// этот URL (внешние ассеты, ExtraDomains)
+func (j *Job) offsiteRedirect(u *url.URL) bool {
+	return j.foreignHost(u.String()) && !j.rewritesExternal(u)
+}
+
+// redirectChain — адреса, ответившие редиректом, по порядку от запрошенного
+func redirectChain(resp *http.Response) []string {
+	var hops []string
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		hops = append([]string{r.Request.URL.String()}, hops...)
+	}
+	return hops
+}
+
+// redirectHop — ответ-редирект цепочки как пришёл; тело у него не читается
+type redirectHop struct {
+	url    string
+	status int
+	header http.Header
+}
+
+// redirectHops — ответы-редиректы, по порядку от запрошенного адреса
+func redirectHops(resp *http.Response) []redirectHop {
+	var hops []redirectHop
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		hops = append([]redirectHop{{url: r.Request.URL.String(), status: r.StatusCode, header: r.Header}}, hops...)
+	}
+	return hops
+}
+
+// redirectMap — исходный URL → финальный, оба нормализованные
+type redirectMap struct {
+	mu    sync.RWMutex
+	m     map[string]string
+	fresh []string // Записанные после последнего чекпоинта
+}
+
+func newRedirectMap() *redirectMap {
+	return &redirectMap{m: make(map[string]string)}
+}
+
+func (r *redirectMap) record(from, to string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m[from] == to {
+		return
+	}
+	r.m[from] = to
+	r.fresh = append(r.fresh, from)
+}
+
+// lookup — финальный URL после цепочки редиректов
+func (r *redirectMap) lookup(u string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	final, ok := r.m[u]
+	// Цепочка /a → /b → /c, записанная по шагам; цикл обрываем
+	for i := 0; ok && i < 10; i++ {
+		next, more := r.m[final]
+		if !more || next == u {
+			break
+		}
+		final = next
+	}
+	return final, ok
+}
+
+// snapshot — копия всей карты для снимка состояния
+func (r *redirectMap) snapshot() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fresh = nil
+	if len(r.m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(r.m))
+	for k, v := range r.m {
+		out[k] = v
+	}
+	return out
+}
+
+// delta — записи после прошлого чекпоинта, для журнала
+func (r *redirectMap) delta() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.fresh) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(r.fresh))
+	for _, k := range r.fresh {
+		out[k] = r.m[k]
+	}
+	r.fresh = nil
+	return out
+}
+
+// load добавляет записи из сохранённого состояния
+func (r *redirectMap) load(m map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range m {
+		r.m[k] = v
+	}
+}
+
+// followRedirect учитывает, что urlStr ответил с finalURL через адреса
+// hops. Возвращает URL, под которым сохранять ответ. save=false —
+// финальный URL уже сохранён: исходный только связывается с его файлом.
+// Редирект на чужой хост (с FollowExternalRedirects) не учитывается —
+// ответ сохраняется, как раньше, под исходным URL.
+func (j *Job) followRedirect(urlStr, finalURL string, hops []string, depth int) (target string, save bool) {
+	if finalURL == "" {
+		return urlStr, true
+	}
+	final, err := j.canonicalURL(finalURL)
+	if err != nil {
+		return urlStr, true
+	}
+	if final == urlStr || j.foreignHost(final) {
+		return urlStr, true
+	}
+
+	j.redirects.record(urlStr, final)
+	// Промежуточные адреса цепочки ведут туда же: ссылки на них — в тот же файл
+	chain := []string{urlStr}
+	for _, hop := range hops {
+		if h, err := j.canonicalURL(hop); err == nil && h != urlStr && h != final && !j.foreignHost(h) {
+			j.redirects.record(h, final)
+			chain = append(chain, h)
+		}
+	}
+	j.mu.Lock()
+	if !j.visitedLocked(final) {
+		j.visited[final] = true
+		j.trackDepth(final, depth)
+		j.unskipNoFollow(final)
+	}
+	for _, h := range chain[1:] {
+		j.visited[h] = true
+	}
+	j.mu.Unlock()
+	j.sendLog(fmt.Sprintf("[Info] Redirect: %s → %s", strings.Join(chain, " → "), final), false)
+
+	if sp, ok := j.saved.lookup(final); ok {
+		j.recordRedirectAlias(urlStr, final, sp.Path)
+		return final, false
+	}
+	return final, true
+}
+
+// recordRedirectAlias связывает исходный URL редиректа с файлом финального
+func (j *Job) recordRedirectAlias(from, final, relPath string) {
+	sp := j.saved.record(from, relPath)
+	j.appendManifest(ManifestEntry{URL: from, Path: relPath, Strategy: sp.Strategy, AliasOf: final, SavedAt: j.now()})
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// <meta http-equiv="refresh" content="0;url=/new-page/"> — редирект без
+// ответа 3xx. Цель ставится в очередь, а ссылка в content переписывается
+// на локальную копию, как href.
+
+// IsRefreshMeta — <meta http-equiv="refresh">; нужен и постобработке
+func IsRefreshMeta(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "meta" {
+		return false
+	}
+	for _, a := range n.Attr {
+		if a.Key == "http-equiv" && strings.EqualFold(strings.TrimSpace(a.Val), "refresh") {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitRefresh делит content тега refresh на части вокруг адреса:
+// "5; URL='/x/'" → "5; URL='", "/x/", "'". Разбор — как в браузере:
+// задержка, ";" или ",", необязательное "url=" в любом регистре и кавычки.
+// ok=false — адреса нет (content="30": просто перезагрузка страницы).
+func SplitRefresh(content string) (before, target, after string, ok bool) {
+	i := 0
+	skipSpace := func() {
+		for i < len(content) && isHTMLSpace(content[i]) {
+			i++
+		}
+	}
+	skipSpace()
+	for i < len(content) && (content[i] >= '0' && content[i] <= '9' || content[i] == '.') {
+		i++
+	}
+	skipSpace()
+	if i >= len(content) || (content[i] != ';' && content[i] != ',') {
+		return "", "", "", false
+	}
+	i++
+	skipSpace()
+	if i+3 <= len(content) && strings.EqualFold(content[i:i+3], "url") {
+		j := i
+		i += 3
+		skipSpace()
+		if i < len(content) && content[i] == '=' {
+			i++
+			skipSpace()
+		} else {
+			i = j // Адрес, который просто начинается с "url"
+		}
+	}
+	end := len(content)
+	if i < len(content) && (content[i] == '\'' || content[i] == '"') {
+		if k := strings.IndexByte(content[i+1:], content[i]); k >= 0 {
+			end = i + 1 + k
+		}
+		i++
+	} else {
+		for end > i && isHTMLSpace(content[end-1]) {
+			end--
+		}
+	}
+	if i >= end {
+		return "", "", "", false
+	}
+	return content[:i], content[i:end], content[end:], true
+}
+
+// refreshTarget — адрес из content тега refresh
+func refreshTarget(n *html.Node) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == "content" {
+			_, target, _, ok := SplitRefresh(a.Val)
+			return target, ok
+		}
+	}
+	return "", false
+}
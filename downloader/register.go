@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Свои парсеры и обработчики задачи. Стандартный набор собирает
+// setupContent — и в NewJob, и при загрузке состояния, — а
+// зарегистрированные через RegisterParser/RegisterHandler добавляются
+// к нему каждый раз, так что пересборка их не теряет. Сами обработчики
+// в состояние не сохраняются, только их имена (ContentHandler.Name):
+// при resume Run предупреждает, если обработчик прошлого запуска не
+// зарегистрирован заново.
+
+// LinkRewriterName — имя стандартного обработчика ссылок
+const LinkRewriterName = "link-rewriter"
+
+func (h *LinkRewriterHandlerV2) Name() string { return LinkRewriterName }
+
+// RegisterParser добавляет парсер ссылок. Свои парсеры проверяются раньше
+// стандартных, так что могут заменить их для своего Content-Type.
+// Вызывать до Run, например в RunOptions.OnStart.
+func (j *Job) RegisterParser(p ContentParser) {
+	j.customParsers = append(j.customParsers, p)
+	j.setupContent()
+}
+
+// RegisterHandler добавляет обработчик содержимого. Обработчик с именем
+// уже зарегистрированного (или стандартного) заменяет его. Вызывать до Run.
+func (j *Job) RegisterHandler(h ContentHandler) {
+	for i, old := range j.customHandlers {
+		if old.Name() == h.Name() {
+			j.customHandlers[i] = h
+			j.setupContent()
+			return
+		}
+	}
+	j.customHandlers = append(j.customHandlers, h)
+	j.setupContent()
+}
+
+// setupContent собирает парсеры и обработчики задачи: свои и стандартные
+func (j *Job) setupContent() {
+	var host string
+	if u, err := url.Parse(j.RootURL); err == nil {
+		host = u.Host
+	}
+	j.Parsers = append(append([]ContentParser(nil), j.customParsers...), newParsers(host, j.Config)...)
+
+	j.Handlers = nil
+	if !j.hasCustomHandler(LinkRewriterName) {
+		j.Handlers = append(j.Handlers, j.newLinkRewriter())
+	}
+	j.Handlers = append(j.Handlers, j.customHandlers...)
+}
+
+func (j *Job) hasCustomHandler(name string) bool {
+	for _, h := range j.customHandlers {
+		if h.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerNames — имена обработчиков для состояния
+func (j *Job) handlerNames() []string {
+	names := make([]string, 0, len(j.Handlers))
+	for _, h := range j.Handlers {
+		names = append(names, h.Name())
+	}
+	return names
+}
+
+// checkHandlers предупреждает об обработчиках прошлого запуска, которых
+// нет сейчас: файлы будут сохранены без их обработки
+func (j *Job) checkHandlers() {
+	current := make(map[string]bool, len(j.Handlers))
+	for _, h := range j.Handlers {
+		current[h.Name()] = true
+	}
+	for _, name := range j.savedHandlers {
+		if !current[name] {
+			j.sendLog(fmt.Sprintf("⚠️ Обработчик %q из прошлого запуска не зарегистрирован: файлы сохранятся без него", name), false)
+		}
+	}
+}
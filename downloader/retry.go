@@ -0,0 +1,246 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultDeferredRetries    = 2
+	DefaultDeferredRetryDelay = 30 * time.Second
+
+	// MinRetryBackoff — основа экспоненциальной паузы между попытками,
+	// если Delay меньше
+	MinRetryBackoff = 250 * time.Millisecond
+)
+
+// StatusError — сервер ответил кодом, отличным от 200
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string { return fmt.Sprintf("status %d", e.Code) }
+
+// DownloadError — URL не скачан. Status — последний код ответа (0 — до
+// ответа не дошло, причина в Err), Attempts — сколько запросов сделано.
+// errors.Is(err, ErrDownloadFailed) для неё истинно.
+type DownloadError struct {
+	URL      string
+	Status   int
+	Attempts int
+	Err      error
+}
+
+func (e *DownloadError) Error() string {
+	var reason string
+	switch {
+	case e.Status != 0:
+		reason = fmt.Sprintf("HTTP %d %s", e.Status, http.StatusText(e.Status))
+	case e.Err != nil:
+		reason = e.Err.Error()
+	default:
+		reason = "no attempts made"
+	}
+	if e.Permanent() {
+		return fmt.Sprintf("%s: %s (permanent, not retried)", e.URL, reason)
+	}
+	noun := "attempts"
+	if e.Attempts == 1 {
+		noun = "attempt"
+	}
+	var timeout *TimeoutError
+	if errors.As(e.Err, &timeout) {
+		return fmt.Sprintf("%s: %s (%d %s)", e.URL, reason, e.Attempts, noun)
+	}
+	return fmt.Sprintf("%s: %s after %d %s", e.URL, reason, e.Attempts, noun)
+}
+
+func (e *DownloadError) Unwrap() error { return e.Err }
+
+func (e *DownloadError) Is(target error) bool { return target == ErrDownloadFailed }
+
+// Permanent — повтор не поможет: сервер отказал (4xx, кроме 429) или
+// запрос остановлен на редиректе (RedirectError)
+func (e *DownloadError) Permanent() bool {
+	var redirect *RedirectError
+	return permanentStatus(e.Status) || errors.As(e.Err, &redirect)
+}
+
+// IsPermanent — err означает окончательный отказ сервера, см. DownloadError.Permanent
+func IsPermanent(err error) bool {
+	var de *DownloadError
+	return errors.As(err, &de) && de.Permanent()
+}
+
+// permanentStatus — 4xx, кроме 429 Too Many Requests: ответ не изменится
+// от повтора. 5xx, 429 и сетевые сбои повторяются.
+func permanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
+
+// retryCeiling — верхняя граница паузы перед n-м повтором:
+// max(Delay, MinRetryBackoff)·2^(n-1), но не больше MaxBackoff
+func retryCeiling(delay time.Duration, n int) time.Duration {
+	wait := delay
+	if wait < MinRetryBackoff {
+		wait = MinRetryBackoff
+	}
+	for i := 1; i < n && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxBackoff {
+		wait = MaxBackoff
+	}
+	return wait
+}
+
+// isTransientError — сбой, который имеет смысл повторить позже в этом же
+// запуске: таймаут, сброс соединения, обрыв ответа или 5xx
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+type deferredURL struct {
+	url string
+	due time.Time
+}
+
+// deferredQueue — URL с временными сбоями. Они возвращаются в очередь
+// после того, как основная очередь опустела, но не раньше due.
+type deferredQueue struct {
+	mu       sync.Mutex
+	limit    int
+	delay    time.Duration
+	waiting  []deferredURL
+	attempts map[string]int // Сколько раз URL откладывался
+}
+
+func newDeferredQueue(limit int, delay time.Duration) *deferredQueue {
+	if limit == 0 {
+		limit = DefaultDeferredRetries
+	}
+	if delay <= 0 {
+		delay = DefaultDeferredRetryDelay
+	}
+	return &deferredQueue{limit: limit, delay: delay, attempts: make(map[string]int)}
+}
+
+// add откладывает URL; false — попытки исчерпаны, сбой окончательный
+func (q *deferredQueue) add(u string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.attempts[u] >= q.limit {
+		return false
+	}
+	q.attempts[u]++
+	q.waiting = append(q.waiting, deferredURL{url: u, due: time.Now().Add(q.delay)})
+	return true
+}
+
+// deferred — URL уже откладывался (успех считается восстановлением)
+func (q *deferredQueue) deferred(u string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.attempts[u] > 0
+}
+
+// takeDue ждёт ближайший срок и забирает все созревшие URL.
+// Пустой результат — отложенных нет или ctx отменён.
+func (q *deferredQueue) takeDue(ctx context.Context) []string {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if len(q.waiting) == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	next := q.waiting[0].due
+	for _, d := range q.waiting[1:] {
+		if d.due.Before(next) {
+			next = d.due
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(next)):
+	case <-ctx.Done():
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var due []string
+	rest := q.waiting[:0]
+	for _, d := range q.waiting {
+		if d.due.After(now) {
+			rest = append(rest, d)
+		} else {
+			due = append(due, d.url)
+		}
+	}
+	q.waiting = rest
+	return due
+}
+
+// snapshot — отложенные URL для файла состояния
+func (q *deferredQueue) snapshot() []string {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]string, 0, len(q.waiting))
+	for _, d := range q.waiting {
+		out = append(out, d.url)
+	}
+	return out
+}
+
+// drainDeferred возвращает созревшие отложенные URL в очередь, пока они есть.
+// Возвращается, когда активных задач нет и откладывать больше нечего.
+func (j *Job) drainDeferred() {
+	for {
+		j.activeWG.Wait()
+		batch := j.deferred.takeDue(j.ctx)
+		// После отмены в очередь не ставим: её уже сняли с activeWG
+		if len(batch) == 0 || j.ctx.Err() != nil {
+			return
+		}
+		j.sendLog(fmt.Sprintf("[Retry] Повтор отложенных URL: %d", len(batch)), false)
+		j.progress.requeue(len(batch))
+		for _, u := range batch {
+			j.activeWG.Add(1)
+			j.enqueue(u, j.depthOf(u))
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReasonRobots — FilterReason для URL, закрытых robots.txt
+const ReasonRobots = "disallowed by robots.txt"
+
+// robotsMaxSize — больше robots.txt не читаем (Google обрезает на 500 KiB)
+const robotsMaxSize = 500 << 10
+
+// robotsRules — правила robots.txt, относящиеся к нашему User-Agent.
+// Пустые правила разрешают всё.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string // Sitemap: — общие для всех User-agent
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string // Путь с * и $, как в robots.txt
+}
+
+// robotsGroup — блок правил для одного или нескольких User-agent
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots разбирает robots.txt и выбирает группу для userAgent:
+// группу с самым длинным совпавшим названием, иначе группу "*"
+func parseRobots(data []byte, userAgent string) *robotsRules {
+	var groups []*robotsGroup
+	var cur *robotsGroup
+	var sitemaps []string
+	inAgents := false
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "sitemap":
+			if val != "" {
+				sitemaps = append(sitemaps, val)
+			}
+		case "user-agent":
+			// Подряд идущие User-agent относятся к одной группе
+			if !inAgents {
+				cur = &robotsGroup{}
+				groups = append(groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil || val == "" {
+				// Пустой Disallow ничего не запрещает
+				continue
+			}
+			cur.rules = append(cur.rules, robotsRule{allow: key == "allow", pattern: val})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if sec, err := strconv.ParseFloat(val, 64); err == nil && sec > 0 {
+				cur.crawlDelay = time.Duration(sec * float64(time.Second))
+			}
+		}
+	}
+
+	ua := strings.ToLower(userAgent)
+	var best *robotsGroup
+	bestLen := -1
+	for _, g := range groups {
+		for _, a := range g.agents {
+			n := -1
+			switch {
+			case a == "*":
+				n = 0
+			case a != "" && strings.Contains(ua, a):
+				n = len(a)
+			}
+			if n > bestLen {
+				best, bestLen = g, n
+			}
+		}
+	}
+	if best == nil {
+		return &robotsRules{sitemaps: sitemaps}
+	}
+	return &robotsRules{rules: best.rules, crawlDelay: best.crawlDelay, sitemaps: sitemaps}
+}
+
+// allowed проверяет путь (с query) по правилам: побеждает самое длинное
+// совпавшее правило, при равной длине — Allow
+func (r *robotsRules) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	allow, bestLen := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > bestLen || (n == bestLen && rule.allow) {
+			allow, bestLen = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch сопоставляет путь с шаблоном robots.txt: префикс,
+// * — любая последовательность, $ в конце — конец пути
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			// Последний кусок должен стоять в самом конце
+			return strings.HasSuffix(path[pos:], part)
+		}
+		j := strings.Index(path[pos:], part)
+		if j < 0 {
+			return false
+		}
+		pos += j + len(part)
+	}
+	return !anchored || pos == len(path)
+}
+
+// disallowAll — правила на случай, когда robots.txt отвечает 5xx:
+// сайт мог что-то закрыть, а прочитать это сейчас нельзя
+var disallowAll = robotsRules{rules: []robotsRule{{pattern: "/"}}}
+
+// fetchRobots скачивает robots.txt хоста. Нет файла (404 и прочие 4xx)
+// или хост не ответил — пустые правила: обход идёт как без RespectRobots.
+// Ошибка сервера (5xx) закрывает весь сайт до следующего запуска.
+func fetchRobots(ctx context.Context, cfg Config, root *url.URL) (*robotsRules, error) {
+	resp, err := NewProber(cfg, 1).Get(ctx, root.Scheme+"://"+root.Host+"/robots.txt")
+	if err != nil {
+		return &robotsRules{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		rules := disallowAll
+		return &rules, fmt.Errorf("robots.txt: status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}, fmt.Errorf("robots.txt: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxSize))
+	if err != nil {
+		return &robotsRules{}, err
+	}
+	return parseRobots(data, cfg.userAgent()), nil
+}
+
+// loadRobots один раз загружает robots.txt (при RespectRobots) и передаёт
+// правила фильтру, а Crawl-delay — загрузчику для хоста сайта
+func (j *Job) loadRobots() {
+	if !j.Config.RespectRobots {
+		return
+	}
+	parsed, err := url.Parse(j.RootURL)
+	if err != nil {
+		return
+	}
+	if j.robots == nil {
+		rules, err := fetchRobots(j.ctx, j.Config, parsed)
+		switch {
+		case err != nil && len(rules.rules) > 0:
+			j.sendLog(fmt.Sprintf("🤖 robots.txt недоступен (%v): сайт закрыт до следующего запуска", err), false)
+		case err != nil:
+			j.logger().Info("robots.txt не получен, ограничений нет: %v", err)
+		}
+		j.robots = rules
+	}
+	if f, ok := j.Filter.(*DefaultURLFilter); ok {
+		f.robots = j.robots
+	}
+	if j.Downloader != nil {
+		j.Downloader.setCrawlDelay(parsed.Host, j.robots.crawlDelay)
+	}
+}
+
+// robotsDisallowed — URL закрыт robots.txt (корень, очередь из состояния
+// и служебные файлы в очередь попадают мимо фильтра)
+func (j *Job) robotsDisallowed(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil || j.robots == nil || u.Path == "/robots.txt" {
+		return false
+	}
+	// Правила — только для хоста сайта, не для ассетов с CDN
+	if j.externalPrefix(urlStr) != "" {
+		return false
+	}
+	return !j.robots.allowed(u.RequestURI())
+}
+
+// skipRobots пишет в лог URL, закрытый robots.txt, — один раз на URL.
+// В visited он попадает только в памяти: в состояние идут лишь depths.
+func (j *Job) skipRobots(urlStr string) {
+	j.mu.Lock()
+	seen := j.visited[urlStr]
+	j.visited[urlStr] = true
+	j.mu.Unlock()
+	if !seen {
+		j.sendLog(fmt.Sprintf("[Skip] %s: %s", urlStr, ReasonRobots), false)
+		atomic.AddInt64(&j.stats.Skipped, 1)
+		j.noteSkip(urlStr, ReasonRobots)
+	}
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath — путь из URL выходит за пределы папки сайта
+var ErrUnsafePath = errors.New("unsafe path")
+
+// ContainedPath собирает base/rel и проверяет, что результат остался внутри base.
+// rel — путь со слэшами после декодирования URL. Отклоняются NUL-байты и
+// сегменты "..", в том числе разделённые обратным слэшем: на Windows
+// filepath.Join считает "\" разделителем, а браузеры — тоже слэшем.
+func ContainedPath(base, rel string) (string, error) {
+	if strings.ContainsRune(rel, 0) {
+		return "", fmt.Errorf("%w: NUL byte in %q", ErrUnsafePath, rel)
+	}
+	for _, seg := range strings.FieldsFunc(rel, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+		}
+	}
+
+	base = filepath.Clean(base)
+	full := filepath.Join(base, filepath.FromSlash(rel))
+	r, err := filepath.Rel(base, full)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) || filepath.IsAbs(r) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+	}
+	return full, nil
+}
+
+// hostDir — папка сайта внутри outputDir; хост должен быть одним сегментом пути
+func hostDir(outputDir, host string) (string, error) {
+	if host == "" || host == "." || host == ".." || strings.ContainsAny(host, "/\\\x00") {
+		return "", fmt.Errorf("%w: host %q", ErrUnsafePath, host)
+	}
+	return filepath.Join(outputDir, host), nil
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Имена, которые Windows не даёт создать: /a:b, /what?.html, /files/con,
+// /dir./x. Копия должна открываться на любой системе, поэтому DiskPath
+// приводит каждый сегмент пути к безопасному виду на всех платформах:
+//
+//	a:b.txt   -> a%3Ab.txt  (запрещённые символы и байты не из UTF-8 — %XX)
+//	notes.    -> notes%2E   (точки и пробелы в конце Windows отбрасывает)
+//	con, nul.txt -> con_, nul_.txt (зарезервированные имена устройств)
+//	сегмент длиннее maxSegmentBytes — обрезается и дополняется хешем
+//
+// Замена однозначна и повторно ничего не меняет, так что переписанные
+// ссылки и поиск файла в процессоре (SanitizePath) приходят к тому же имени.
+// NUL и обратный слэш не трогаем — их отвергает ContainedPath.
+
+// maxSegmentBytes — предел длины имени файла или папки (NTFS и ext4 — 255)
+const maxSegmentBytes = 200
+
+// windowsForbidden — символы, недопустимые в именах файлов Windows
+const windowsForbidden = `<>:"|?*`
+
+// reservedNames — имена устройств Windows; запрещены и с расширением (nul.txt)
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// SanitizePath приводит каждый сегмент пути (через "/") к имени, которое
+// можно создать на любой системе. Начальный и конечный слэши сохраняются.
+func SanitizePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = sanitizeSegment(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+func sanitizeSegment(seg string) string {
+	if seg == "" || seg == "." || seg == ".." {
+		return seg
+	}
+	var b strings.Builder
+	for i := 0; i < len(seg); {
+		r, size := utf8.DecodeRuneInString(seg[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// Байт не из UTF-8 (/%FF) — имя не создать ни в NTFS, ни в APFS
+			fmt.Fprintf(&b, "%%%02X", seg[i])
+		case (r > 0 && r < 0x20) || strings.ContainsRune(windowsForbidden, r):
+			fmt.Fprintf(&b, "%%%02X", r)
+		default:
+			b.WriteString(seg[i : i+size])
+		}
+		i += size
+	}
+	s := b.String()
+
+	// Точки и пробелы в конце имени Windows молча отбрасывает
+	trimmed := strings.TrimRight(s, ". ")
+	if trimmed != s {
+		var tail strings.Builder
+		for _, r := range s[len(trimmed):] {
+			fmt.Fprintf(&tail, "%%%02X", r)
+		}
+		s = trimmed + tail.String()
+	}
+
+	stem, rest, _ := strings.Cut(s, ".")
+	if reservedNames[strings.ToLower(strings.TrimRight(stem, " "))] {
+		s = stem + "_"
+		if rest != "" {
+			s += "." + rest
+		}
+	}
+
+	if len(s) > maxSegmentBytes {
+		s = truncateSegment(s)
+	}
+	return s
+}
+
+// truncateSegment укорачивает имя до maxSegmentBytes, сохраняя расширение;
+// хеш полного имени не даёт длинным именам с общим началом совпасть
+func truncateSegment(s string) string {
+	ext := path.Ext(s)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	suffix := "~" + hex.EncodeToString(sum[:8]) + ext
+	head := s[:maxSegmentBytes-len(suffix)]
+	for !utf8.ValidString(head) {
+		head = head[:len(head)-1]
+	}
+	return head + suffix
+}
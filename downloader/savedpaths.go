@@ -0,0 +1,337 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Стратегии сохранения, как они записываются в манифест
+const (
+	StrategyDirectory = "directory" // dir/index.html
+	StrategyFile      = "file"      // файл как есть
+)
+
+// SavedPath — куда и как на самом деле сохранён URL
+type SavedPath struct {
+	Strategy string
+	Path     string // Путь внутри каталога хоста, через "/"
+}
+
+// savedPaths — решение о сохранении, принятое один раз при скачивании.
+// Переписывание ссылок берёт путь отсюда, а не угадывает его заново.
+type savedPaths struct {
+	mu     sync.RWMutex
+	paths  map[string]SavedPath
+	byPath map[string][]string // Путь → URL, сохранённые по нему (первый — владелец)
+	claims map[string]string   // Путь → URL, занявший его до записи (см. claim)
+}
+
+func newSavedPaths() *savedPaths {
+	return &savedPaths{
+		paths:  make(map[string]SavedPath),
+		byPath: make(map[string][]string),
+		claims: make(map[string]string),
+	}
+}
+
+func (s *savedPaths) record(urlStr, relPath string) SavedPath {
+	sp := SavedPath{Strategy: strategyForPath(relPath), Path: relPath}
+	if s == nil {
+		return sp
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.paths[urlStr]; ok {
+		if old.Path == relPath {
+			s.paths[urlStr] = sp
+			return sp
+		}
+		s.unindex(urlStr, old.Path)
+	}
+	s.paths[urlStr] = sp
+	s.byPath[relPath] = append(s.byPath[relPath], urlStr)
+	return sp
+}
+
+// unindex убирает urlStr из списка пути relPath (под s.mu)
+func (s *savedPaths) unindex(urlStr, relPath string) {
+	urls := s.byPath[relPath]
+	for i, u := range urls {
+		if u == urlStr {
+			urls = append(urls[:i:i], urls[i+1:]...)
+			break
+		}
+	}
+	if len(urls) == 0 {
+		delete(s.byPath, relPath)
+	} else {
+		s.byPath[relPath] = urls
+	}
+}
+
+// repath переносит все URL, сохранённые в from, на путь to и возвращает их
+func (s *savedPaths) repath(from, to string) []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := s.byPath[from]
+	if len(moved) == 0 {
+		return nil
+	}
+	delete(s.byPath, from)
+	sp := SavedPath{Strategy: strategyForPath(to), Path: to}
+	for _, u := range moved {
+		s.paths[u] = sp
+	}
+	s.byPath[to] = append(s.byPath[to], moved...)
+	return append([]string(nil), moved...)
+}
+
+func (s *savedPaths) lookup(urlStr string) (SavedPath, bool) {
+	if s == nil {
+		return SavedPath{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sp, ok := s.paths[urlStr]
+	return sp, ok
+}
+
+// pages — сохранённые страницы сайта: URL → путь
+func (s *savedPaths) pages() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pages := make(map[string]string)
+	for u, sp := range s.paths {
+		if strings.HasSuffix(sp.Path, ".html") && !strings.HasPrefix(sp.Path, "../") {
+			pages[u] = sp.Path
+		}
+	}
+	return pages
+}
+
+// owner — URL, уже сохранённый по пути relPath или занявший его (под s.mu)
+func (s *savedPaths) owner(relPath string) (string, bool) {
+	if urls := s.byPath[relPath]; len(urls) > 0 {
+		return urls[0], true
+	}
+	u, ok := s.claims[relPath]
+	return u, ok
+}
+
+// claim занимает путь relPath за urlStr, если его не занял другой URL.
+// Проверка и захват под одним замком: два воркера с одинаковым именем
+// вложения не выберут один путь, пока ни один ещё не записан.
+func (s *savedPaths) claim(urlStr, relPath string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, ok := s.owner(relPath); ok {
+		return owner == urlStr
+	}
+	s.claims[relPath] = urlStr
+	return true
+}
+
+// strategyForPath определяет стратегию по фактическому пути сохранения
+func strategyForPath(relPath string) string {
+	if path.Base(relPath) == "index.html" {
+		return StrategyDirectory
+	}
+	return StrategyFile
+}
+
+// siteDirLocks — по замку на папку сайта: задачи с одной папкой
+// (повторный корень, пакет) делят и его
+var (
+	siteDirLocksMu sync.Mutex
+	siteDirLocks   = map[string]*sync.Mutex{}
+)
+
+// lockSiteDir занимает папку сайта на время выбора пути и записи
+// (saveFileAs); возвращает функцию, которая её освобождает
+func lockSiteDir(siteDir string) (unlock func()) {
+	key := filepath.Clean(siteDir)
+	siteDirLocksMu.Lock()
+	mu, ok := siteDirLocks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		siteDirLocks[key] = mu
+	}
+	siteDirLocksMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// fileConflictSuffix отличает ассет, уступивший имя папке (см. conflictPath)
+const fileConflictSuffix = "~file"
+
+// conflictPath — куда сохраняется файл rel, если его имя нужно папке.
+// Страница уходит внутрь неё: about → about/index.html. Ассет остаётся
+// рядом с суффиксом перед расширением, чтобы не потерять тип:
+// data.json → data~file.json.
+func conflictPath(rel string) string {
+	if !isAssetPath(strings.ToLower(rel)) {
+		return path.Join(rel, "index.html")
+	}
+	ext := path.Ext(rel)
+	return strings.TrimSuffix(rel, ext) + fileConflictSuffix + ext
+}
+
+// freeDirPath освобождает папки пути rel внутри siteDir: если одна из них
+// занята файлом (/about сохранён файлом, а теперь нужен about/...), файл
+// переносится по conflictPath. Возвращает перенесённый путь или "".
+func freeDirPath(siteDir, rel string) (string, error) {
+	dir := ""
+	for _, seg := range strings.Split(path.Dir(rel), "/") {
+		if seg == "." || seg == "" {
+			break
+		}
+		dir = path.Join(dir, seg)
+		full := filepath.Join(siteDir, filepath.FromSlash(dir))
+		fi, err := os.Stat(full)
+		if err != nil {
+			// Дальше по пути ничего нет — MkdirAll создаст
+			return "", nil
+		}
+		if fi.IsDir() {
+			continue
+		}
+
+		if to := conflictPath(dir); !strings.HasSuffix(to, "/index.html") {
+			if err := os.Rename(full, filepath.Join(siteDir, filepath.FromSlash(to))); err != nil {
+				return "", err
+			}
+			return dir, nil
+		}
+		tmp := full + ".moving"
+		if err := os.Rename(full, tmp); err != nil {
+			return "", err
+		}
+		if err := os.Mkdir(full, 0755); err != nil {
+			os.Rename(tmp, full)
+			return "", err
+		}
+		if err := os.Rename(tmp, filepath.Join(full, "index.html")); err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+	return "", nil
+}
+
+// slashVariant — тот же URL с косой чертой в конце пути или без неё
+func slashVariant(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Path == "" || u.Path == "/" {
+		return ""
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	} else {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+// recordLayoutFix учитывает разрешённый при сохранении конфликт файла и
+// папки: URL, чей файл перенесён внутрь папки, получают новый путь, а оба
+// варианта URL (со слэшем и без) — запись в реестре и манифесте, чтобы
+// ссылки на любой из них вели в папку. Ассету, уступившему имя папке,
+// второй вариант URL не нужен: по нему лежит папка. Страницы, записанные
+// до конфликта, переписываются на новый путь (см. relink).
+func (j *Job) recordLayoutFix(urlStr, relPath, movedFrom string) {
+	if movedFrom != "" {
+		movedTo := conflictPath(movedFrom)
+		// На перенесённый файл могли сослаться любые страницы, а не только
+		// те, что ещё помнит referrers: конфликт редок, просматриваем все
+		pages := j.saved.pages()
+		for _, u := range j.saved.repath(movedFrom, movedTo) {
+			j.appendManifest(ManifestEntry{URL: u, Path: movedTo, Strategy: strategyForPath(movedTo), SavedAt: j.now()})
+			if strategyForPath(movedTo) == StrategyDirectory {
+				j.recordAlias(u, movedTo)
+			}
+			j.relink(u, movedLinks(movedFrom, pages))
+		}
+		j.sendLog(fmt.Sprintf("[Info] %s moved to %s to make room for a folder", movedFrom, movedTo), false)
+	}
+	// Файл сразу лёг по conflictPath: ссылки до этого вели на обычный путь
+	if u, err := url.Parse(urlStr); err == nil {
+		prefix, guess := j.externalPrefix(urlStr), DiskPath(u, "")
+		if relPath == prefix+conflictPath(guess) {
+			j.relink(urlStr, movedLinks(prefix+guess, j.referrerPages(urlStr)))
+		}
+	}
+	if strategyForPath(relPath) == StrategyDirectory {
+		j.recordAlias(urlStr, relPath)
+	}
+}
+
+// recordAlias связывает второй вариант URL с тем же путём
+func (j *Job) recordAlias(urlStr, relPath string) {
+	alias := slashVariant(urlStr)
+	if alias == "" {
+		return
+	}
+	if sp, ok := j.saved.lookup(alias); ok && sp.Path == relPath {
+		return
+	}
+	j.saved.record(alias, relPath)
+	j.appendManifest(ManifestEntry{URL: alias, Path: relPath, Strategy: StrategyDirectory, AliasOf: urlStr, SavedAt: j.now()})
+}
+
+func (j *Job) appendManifest(e ManifestEntry) {
+	if j.manifest != nil {
+		e.Scheme = j.scheme.scheme
+		j.manifest.Append(e)
+	}
+}
+
+// newLinkRewriter создаёт обработчик ссылок, разделяющий реестр путей с задачей
+func (j *Job) newLinkRewriter() *LinkRewriterHandlerV2 {
+	h := &LinkRewriterHandlerV2{
+		outputDir: j.Config.OutputDir,
+		saved:     j.saved,
+		redirects: j.redirects,
+		queries:   j.queries,
+		scheme:    &j.scheme,
+		external:  j.rewritesExternal,
+		log:       j.logger(),
+	}
+	if j.Config.SinglePage {
+		h.local = j.singlePageLocal
+	}
+	return h
+}
+
+// loadSavedPaths восстанавливает реестр из манифеста при возобновлении
+func (j *Job) loadSavedPaths() {
+	entries, err := LoadManifest(j.manifestFile())
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range entries {
+		j.saved.record(e.URL, e.Path)
+		// Содержимое прошлых запусков — для дедупликации
+		if e.Hash != "" && e.AliasOf == "" && j.hashes != nil && fileCategory(e.URL, e.ContentType) != FileHTML {
+			if _, ok := j.hashes[e.Hash]; !ok {
+				j.hashes[e.Hash] = e.URL
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+)
+
+// schemeCanon приводит ссылки на хост задачи к одному протоколу. Сайт,
+// доступный и по http, и по https, иначе обходился бы дважды: visited
+// различает http://host/x и https://host/x, а файлы ложатся в одну папку
+// хоста и перезаписывают друг друга. Нулевое значение ничего не меняет.
+type schemeCanon struct {
+	host   string
+	scheme string
+}
+
+// detectScheme выбирает протокол для корня задачи: при http:// проверяет,
+// отвечает ли хост по https, и если да — предпочитает его
+func detectScheme(ctx context.Context, cfg Config, root *url.URL) schemeCanon {
+	c := schemeCanon{host: root.Host, scheme: root.Scheme}
+	if root.Scheme != "http" {
+		return c
+	}
+	resp, err := NewProber(cfg, 1).Head(ctx, "https://"+root.Host+"/")
+	if err != nil {
+		return c
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 500 {
+		c.scheme = "https"
+	}
+	return c
+}
+
+// canonical возвращает URL с каноническим протоколом, если он ведёт на хост задачи
+func (c schemeCanon) canonical(u string) string {
+	if c.scheme == "" {
+		return u
+	}
+	pu, err := url.Parse(u)
+	if err != nil || pu.Host != c.host || pu.Scheme == c.scheme ||
+		(pu.Scheme != "http" && pu.Scheme != "https") {
+		return u
+	}
+	pu.Scheme = c.scheme
+	return pu.String()
+}
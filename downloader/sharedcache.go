@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SharedCacheDirName — папка общего кеша внутри OutputDir
+const SharedCacheDirName = ".cache"
+
+// sharedCache — общее для всех сайтов хранилище по sha256 содержимого.
+// Одинаковые файлы (jQuery, Bootstrap с одного CDN) хранятся один раз,
+// а в папках сайтов лежат жёсткие ссылки на запись кеша.
+// Выключен по умолчанию: папку сайта с такими ссылками нельзя просто
+// скопировать на другой диск, не потеряв экономию.
+type sharedCache struct {
+	dir string
+	mu  sync.Mutex // Одна запись кеша создаётся одним воркером
+}
+
+func newSharedCache(c Config) *sharedCache {
+	if !c.SharedCache {
+		return nil
+	}
+	dir := c.SharedCacheDir
+	if dir == "" {
+		dir = filepath.Join(c.OutputDir, SharedCacheDirName)
+	}
+	return &sharedCache{dir: dir}
+}
+
+// adopt связывает сохранённый файл с записью кеша hash. Если запись уже есть,
+// файл заменяется ссылкой на неё и возвращается сэкономленный размер;
+// иначе файл сам становится записью кеша. При любой ошибке файл сайта
+// остаётся обычной копией.
+func (c *sharedCache) adopt(fullPath, hash string, size int64) (saved int64) {
+	if c == nil || hash == "" {
+		return 0
+	}
+	entry := filepath.Join(c.dir, hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(entry); err != nil {
+		if err := os.MkdirAll(c.dir, 0755); err != nil {
+			return 0
+		}
+		os.Link(fullPath, entry)
+		return 0
+	}
+
+	fi, err1 := os.Stat(fullPath)
+	ei, err2 := os.Stat(entry)
+	if err1 != nil || err2 != nil || os.SameFile(fi, ei) {
+		return 0
+	}
+
+	// Ссылку ставим через rename, чтобы файл сайта не пропадал ни на миг
+	tmp := fullPath + ".link"
+	os.Remove(tmp)
+	if err := os.Link(entry, tmp); err != nil {
+		return 0
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+		return 0
+	}
+	return size
+}
+
+// GCSharedCache удаляет записи кеша, на которые не ссылается ни один
+// манифест в outputDir. Запись из манифеста удалённого сайта не в счёт:
+// файл сайта должен ещё лежать на диске. Возвращает число удалённых
+// записей и освобождённые байты.
+func GCSharedCache(outputDir string) (removed int, freed int64, err error) {
+	dir := filepath.Join(outputDir, SharedCacheDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	manifests, err := filepath.Glob(filepath.Join(outputDir, "*"+ManifestExtension))
+	if err != nil {
+		return 0, 0, err
+	}
+	used := make(map[string]bool)
+	for _, m := range manifests {
+		list, err := LoadManifest(m)
+		if err != nil {
+			// Не смогли прочитать манифест — не рискуем чужими файлами
+			return 0, 0, err
+		}
+		for _, e := range list {
+			if used[e.Hash] {
+				continue
+			}
+			u, err := url.Parse(e.URL)
+			if err != nil || u.Host == "" {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, u.Host, filepath.FromSlash(e.Path))); err == nil {
+				used[e.Hash] = true
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || used[e.Name()] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if os.Remove(filepath.Join(dir, e.Name())) == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Одна страница (Config.SinglePage) — как «Сохранить страницу как» в
+// браузере: качается корневая страница и то, что нужно для её показа —
+// картинки, CSS, скрипты, шрифты, в том числе подключённые из CSS.
+// Со страницы берутся только ресурсы (см. resourceAttr); другие страницы
+// не качаются при любом MaxDepth, ссылки на них остаются абсолютными и
+// ведут на исходный сайт.
+
+// ReasonSinglePage — почему ссылка на страницу не обходится
+const ReasonSinglePage = "single page: links to other pages are not followed"
+
+// singlePageMaxDepth — глубина цепочки ассетов: страница → CSS →
+// @import → шрифт и т.п.
+const singlePageMaxDepth = 5
+
+// resourceRels — rel у <link>, который подключает ресурс страницы, а не
+// ссылается на другую страницу (canonical, next, alternate)
+var resourceRels = map[string]bool{
+	"stylesheet":                   true,
+	"icon":                         true,
+	"apple-touch-icon":             true,
+	"apple-touch-icon-precomposed": true,
+	"mask-icon":                    true,
+	"manifest":                     true,
+	"preload":                      true,
+	"modulepreload":                true,
+}
+
+// resourceAttr — атрибут key элемента n подключает ресурс страницы
+// (картинку, стиль, скрипт, шрифт), а не ведёт на другую страницу.
+// Решает тег, а не расширение: /image?id=5 в <img> — картинка, а
+// /docs/manual.pdf в <a> — переход.
+func resourceAttr(n *html.Node, key string) bool {
+	if lazyAttrs[key] {
+		return true
+	}
+	switch n.Data {
+	case "img", "script", "source":
+		return key == "src" || key == "srcset"
+	case "video":
+		return key == "src" || key == "poster"
+	case "audio":
+		return key == "src"
+	case "use", "image":
+		return key == "href"
+	case "link":
+		if key != "href" {
+			return false
+		}
+		for _, a := range n.Attr {
+			if a.Key != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(a.Val)) {
+				if resourceRels[rel] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pageResources — ресурсы HTML-страницы (см. resourceAttr), разрешённые
+// от базы документа; ссылки на другие страницы отбрасываются
+func pageResources(content []byte, baseURL string) []string {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil
+	}
+	var links []string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, a := range n.Attr {
+				if !resourceAttr(n, a.Key) {
+					continue
+				}
+				if srcsetAttr(a.Key) {
+					links = append(links, splitSrcset(a.Val)...)
+				} else {
+					links = append(links, a.Val)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return resolveRawLinks(links, documentBase(doc, baseURL))
+}
+
+// singlePageLocal — у ссылки будет локальная копия: она уже сохранена
+// или это ресурс страницы (resource), который задача скачает
+func (j *Job) singlePageLocal(u *url.URL, resource bool) bool {
+	if key, err := NormalizeURL(u.String()); err == nil {
+		if _, ok := j.saved.lookup(j.scheme.canonical(key)); ok {
+			return true
+		}
+	}
+	return resource && j.Filter.ShouldDownload(u.String())
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"net"
+	"strings"
+)
+
+// siteHosts — какие хосты считаются частью сайта. www.example.com и
+// example.com — всегда один сайт: ссылки между ними обычны, и раньше они
+// молча отбрасывались. С Config.IncludeSubdomains — ещё и любые поддомены
+// (blog.example.com, docs.example.com). Файлы других хостов сайта лежат
+// в папках своих хостов, рядом с папкой корня (см. external.go).
+type siteHosts struct {
+	root       string // Хост корня задачи, как в URL (с портом)
+	base       string // Имя без www. и порта: example.com
+	port       string
+	subdomains bool
+}
+
+func newSiteHosts(rootHost string, cfg Config) siteHosts {
+	name, port := splitHostPort(rootHost)
+	return siteHosts{
+		root:       rootHost,
+		base:       strings.TrimPrefix(name, "www."),
+		port:       port,
+		subdomains: cfg.IncludeSubdomains,
+	}
+}
+
+// contains — хост из той же группы; порт должен совпадать с портом корня
+func (s siteHosts) contains(host string) bool {
+	if host == s.root {
+		return true
+	}
+	name, port := splitHostPort(host)
+	if port != s.port || s.base == "" {
+		return false
+	}
+	if name == s.base || name == "www."+s.base {
+		return true
+	}
+	return s.subdomains && strings.HasSuffix(name, "."+s.base)
+}
+
+// splitHostPort — имя хоста в нижнем регистре и порт ("" — порт по умолчанию)
+func splitHostPort(host string) (name, port string) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return strings.ToLower(h), p
+	}
+	return strings.ToLower(host), ""
+}
+
+// siteHost — хост относится к сайту задачи (корень, www-вариант, поддомен)
+func (j *Job) siteHost(host string) bool {
+	return newSiteHosts(j.scheme.host, j.Config).contains(host)
+}
@@ -0,0 +1,220 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// SiteLockFileName — файл блокировки в папке сайта (downloads/<host>)
+	SiteLockFileName = ".sitecloner.lock"
+
+	// Живой владелец обновляет метку раз в siteLockRefresh; блокировка без
+	// обновления дольше SiteLockStaleAfter считается брошенной (PID мог
+	// достаться другому процессу или папка лежит на сетевом диске)
+	SiteLockStaleAfter = 5 * time.Minute
+	siteLockRefresh    = time.Minute
+)
+
+var ErrSiteInUse = errors.New("site is in use")
+
+// SiteInUseError — папку сайта держит другая задача
+type SiteInUseError struct {
+	Dir   string
+	Owner string
+	PID   int
+	Since time.Time
+}
+
+func (e *SiteInUseError) Error() string {
+	return fmt.Sprintf("site is in use by %s (pid %d since %s): %s",
+		e.Owner, e.PID, e.Since.Format(time.RFC3339), e.Dir)
+}
+
+func (e *SiteInUseError) Is(target error) bool { return target == ErrSiteInUse }
+
+// siteLockInfo — содержимое файла блокировки
+type siteLockInfo struct {
+	PID     int       `json:"pid"`
+	Owner   string    `json:"owner"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
+
+// SiteLock — рекомендательная блокировка папки сайта. Её берут задача
+// загрузки и обработчик, чтобы не писать в одно дерево одновременно.
+type SiteLock struct {
+	path string
+	info siteLockInfo
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// LockSite берёт блокировку dir от имени owner (например, "job <id>").
+// Брошенную блокировку (процесс владельца умер или метка давно не
+// обновлялась) забирает себе; живую — возвращает *SiteInUseError.
+func LockSite(dir, owner string) (*SiteLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, SiteLockFileName)
+	now := time.Now()
+	l := &SiteLock{
+		path: path,
+		info: siteLockInfo{PID: os.Getpid(), Owner: owner, Started: now, Updated: now},
+	}
+
+	// Вторая попытка — после снятия брошенной блокировки
+	for attempt := 0; attempt < 2; attempt++ {
+		err := l.create()
+		if err == nil {
+			l.stop = make(chan struct{})
+			l.done = make(chan struct{})
+			go l.refresher(l.stop)
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, rerr := readSiteLock(path)
+		if rerr != nil && !os.IsNotExist(rerr) {
+			// Файл недописан или испорчен: владелец падал посреди записи,
+			// живой владелец его уже перезаписал бы
+			if fi, serr := os.Stat(path); serr == nil && time.Since(fi.ModTime()) < SiteLockStaleAfter {
+				return nil, &SiteInUseError{Dir: dir, Owner: "unknown", Since: fi.ModTime()}
+			}
+		} else if rerr == nil && !held.stale(time.Now()) {
+			return nil, &SiteInUseError{Dir: dir, Owner: held.Owner, PID: held.PID, Since: held.Started}
+		}
+		os.Remove(path)
+	}
+	return nil, &SiteInUseError{Dir: dir, Owner: "unknown"}
+}
+
+// LockSiteOf — блокировка папки сайта rawURL внутри outputDir
+func LockSiteOf(outputDir, rawURL, owner string) (*SiteLock, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	dir, err := hostDir(outputDir, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return LockSite(dir, owner)
+}
+
+func (l *SiteLock) create() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(l.info)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+// refresher обновляет метку времени, пока блокировка держится
+func (l *SiteLock) refresher(stop <-chan struct{}) {
+	defer close(l.done)
+	t := time.NewTicker(siteLockRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			l.mu.Lock()
+			l.info.Updated = now
+			data, _ := json.Marshal(l.info)
+			l.mu.Unlock()
+			// Пишем поверх через rename: читатель не увидит половину файла
+			tmp := l.path + ".tmp"
+			if os.WriteFile(tmp, data, 0644) == nil {
+				os.Rename(tmp, l.path)
+			}
+		}
+	}
+}
+
+// Unlock снимает блокировку. Повторные вызовы и nil безопасны.
+func (l *SiteLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	stop := l.stop
+	l.stop = nil
+	l.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-l.done
+
+	// Чужую блокировку (нашу сочли брошенной и забрали) не трогаем
+	if held, err := readSiteLock(l.path); err == nil && (held.PID != l.info.PID || !held.Started.Equal(l.info.Started)) {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// IsSiteLockFile — служебный файл блокировки; в результат обработки его не копируют
+func IsSiteLockFile(name string) bool {
+	return name == SiteLockFileName || name == SiteLockFileName+".tmp"
+}
+
+func readSiteLock(path string) (siteLockInfo, error) {
+	var info siteLockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// stale — владелец не может держать блокировку: его процесса нет
+// или метка давно не обновлялась
+func (i siteLockInfo) stale(now time.Time) bool {
+	if now.Sub(i.Updated) > SiteLockStaleAfter {
+		return true
+	}
+	return !processAlive(i.PID)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// На Windows FindProcess уже открыл процесс — значит, он есть
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
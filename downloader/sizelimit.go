@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrTooLarge — файл превышает лимит размера и не скачивался (или чтение прервано)
+var ErrTooLarge = errors.New("file too large")
+
+// TooLargeFile — файл, пропущенный из-за лимита размера
+type TooLargeFile struct {
+	URL         string
+	ContentType string
+	Size        int64 // -1, если размер неизвестен (chunked и чтение прервано)
+	Limit       int64
+}
+
+// sizeRules — лимиты по типам: "video/" → 200MB и т.п.
+// Ищется самый длинный префикс Content-Type, иначе общий MaxFileSize.
+type sizeRules struct {
+	defaultLimit int64
+	byType       map[string]int64
+
+	mu       sync.Mutex
+	tooLarge []TooLargeFile
+}
+
+func newSizeRules(defaultLimit int64, byType map[string]int64) *sizeRules {
+	if defaultLimit <= 0 {
+		defaultLimit = DefaultMaxFileSize
+	}
+	return &sizeRules{defaultLimit: defaultLimit, byType: byType}
+}
+
+func (r *sizeRules) limitFor(contentType string) int64 {
+	ct := mediaType(contentType)
+	best, limit := -1, r.defaultLimit
+	for prefix, l := range r.byType {
+		if strings.HasPrefix(ct, strings.ToLower(prefix)) && len(prefix) > best {
+			best, limit = len(prefix), l
+		}
+	}
+	return limit
+}
+
+func (r *sizeRules) record(f TooLargeFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tooLarge = append(r.tooLarge, f)
+}
+
+func (r *sizeRules) list() []TooLargeFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := append([]TooLargeFile(nil), r.tooLarge...)
+	sort.Slice(res, func(i, k int) bool { return res[i].URL < res[k].URL })
+	return res
+}
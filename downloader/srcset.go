@@ -0,0 +1,66 @@
+package downloader
+
+import "strings"
+
+// lazyAttrs — атрибуты, в которых скрипты ленивой загрузки держат адрес
+// картинки до прокрутки (lazysizes, lozad, WordPress и т.п.)
+var lazyAttrs = map[string]bool{
+	"data-src":          true,
+	"data-lazy-src":     true,
+	"data-original":     true,
+	"data-bg":           true,
+	"data-background":   true,
+	"data-poster":       true,
+	"data-srcset":       true,
+	"data-lazy-srcset":  true,
+	"data-original-set": true,
+}
+
+// srcsetAttr — значение атрибута — список кандидатов, а не одна ссылка
+func srcsetAttr(name string) bool {
+	return strings.HasSuffix(name, "srcset") || name == "data-original-set"
+}
+
+// splitSrcset достаёт URL из srcset: "a.jpg 1x, b.jpg 2x" → [a.jpg b.jpg].
+// Как в спецификации HTML: URL идёт до пробела, запятая на его конце
+// завершает кандидата, дескрипторы тянутся до запятой вне скобок.
+func splitSrcset(v string) []string {
+	var urls []string
+	for i := 0; i < len(v); {
+		for i < len(v) && (isHTMLSpace(v[i]) || v[i] == ',') {
+			i++
+		}
+		start := i
+		for i < len(v) && !isHTMLSpace(v[i]) {
+			i++
+		}
+		raw := v[start:i]
+		if u := strings.TrimRight(raw, ","); u != "" {
+			urls = append(urls, u)
+		}
+		if strings.HasSuffix(raw, ",") {
+			continue // Кандидат без дескрипторов
+		}
+		for depth := 0; i < len(v); i++ {
+			switch v[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			case ',':
+				if depth == 0 {
+					i++
+					goto next
+				}
+			}
+		}
+	next:
+	}
+	return urls
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
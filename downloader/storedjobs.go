@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StoredJob — задача из файла состояния в OutputDir, для команды jobs
+type StoredJob struct {
+	ID        string    `json:"id"`
+	RootURL   string    `json:"rootUrl"`
+	Pages     int       `json:"pages"`   // Сохранено URL
+	Pending   int       `json:"pending"` // Осталось в очереди
+	Failed    int       `json:"failed"`
+	Modified  time.Time `json:"modified"` // Последняя запись снимка или журнала
+	Complete  bool      `json:"complete"` // Очередь пуста: resume нечего качать
+	StateFile string    `json:"stateFile"`
+	Error     string    `json:"error,omitempty"` // Файл не прочитался
+}
+
+// ListStoredJobs находит в dir файлы состояния (снимки и журналы) и
+// читает их. Свежие задачи идут первыми; битый файл попадает в список
+// с Error, а не прерывает обход.
+func ListStoredJobs(dir string) ([]StoredJob, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Снимок и журнал одной задачи дают одну строку
+	modified := make(map[string]time.Time)
+	for _, e := range entries {
+		name := e.Name()
+		var id string
+		switch {
+		case strings.HasSuffix(name, StateFileExtension):
+			id = strings.TrimSuffix(name, StateFileExtension)
+		case strings.HasSuffix(name, StateJournalExtension):
+			id = strings.TrimSuffix(name, StateJournalExtension)
+		default:
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		if info.ModTime().After(modified[id]) {
+			modified[id] = info.ModTime()
+		}
+	}
+
+	jobs := make([]StoredJob, 0, len(modified))
+	for id, mod := range modified {
+		stateFile := filepath.Join(dir, id+StateFileExtension)
+		job := StoredJob{ID: id, Modified: mod, StateFile: stateFile}
+		state, err := LoadJobState(stateFile)
+		if err != nil {
+			job.Error = err.Error()
+			jobs = append(jobs, job)
+			continue
+		}
+		if state.ID != "" {
+			job.ID = state.ID
+		}
+		job.RootURL = state.RootURL
+		aliases := manifestAliases(filepath.Join(dir, id+ManifestExtension))
+		for _, u := range state.Completed {
+			if !aliases[u] {
+				job.Pages++
+			}
+		}
+		job.Pending = len(state.PendingURLs)
+		job.Failed = len(state.Failed)
+		job.Complete = job.Pending == 0
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].Modified.Equal(jobs[b].Modified) {
+			return jobs[a].Modified.After(jobs[b].Modified)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+	return jobs, nil
+}
+
+// manifestAliases — URL записей-алиасов манифеста: редирект и его цель
+// сохранены одним файлом и в Pages считаются один раз
+func manifestAliases(manifestFile string) map[string]bool {
+	entries, _ := LoadManifest(manifestFile)
+	aliases := make(map[string]bool)
+	for _, e := range entries {
+		if e.AliasOf != "" {
+			aliases[e.URL] = true
+		}
+	}
+	return aliases
+}
+
+// IsJobURL — похож ли аргумент resume на корневой URL, а не на ID задачи
+func IsJobURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// FindJobState возвращает путь к снимку состояния задачи по её ID или по
+// URL, с которого её запускали. ID — хеш URL в том виде, в каком его
+// передали download, поэтому при промахе URL сверяется с RootURL
+// сохранённых задач без учёта протокола, слэша в конце и т.п.
+func FindJobState(dir, idOrURL string) (string, error) {
+	exists := func(id string) bool {
+		for _, ext := range []string{StateFileExtension, StateJournalExtension} {
+			if _, err := os.Stat(filepath.Join(dir, id+ext)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !IsJobURL(idOrURL) {
+		if !exists(idOrURL) {
+			return "", fmt.Errorf("%w for job %s in %s", ErrNoJobState, idOrURL, dir)
+		}
+		return filepath.Join(dir, idOrURL+StateFileExtension), nil
+	}
+
+	normalized, _ := NormalizeURL(idOrURL)
+	for _, id := range []string{JobID(idOrURL), JobID(normalized)} {
+		if exists(id) {
+			return filepath.Join(dir, id+StateFileExtension), nil
+		}
+	}
+	want := withoutScheme(normalized)
+	jobs, _ := ListStoredJobs(dir)
+	for _, j := range jobs {
+		if j.Error != "" || j.RootURL == "" {
+			continue
+		}
+		if root, _ := NormalizeURL(j.RootURL); withoutScheme(root) == want {
+			return j.StateFile, nil
+		}
+	}
+	return "", fmt.Errorf("%w for %s in %s", ErrNoJobState, idOrURL, dir)
+}
+
+// withoutScheme — URL без протокола: задачу по http:// ищем и среди https://
+func withoutScheme(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		return u[i+3:]
+	}
+	return u
+}
@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const svgContentType = "image/svg+xml"
+
+var (
+	cssURLRegex = regexp.MustCompile(`(?i)url\s*\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	// @import "theme.css"; — строка без url(); @import url(...) ловит cssURLRegex
+	cssImportRegex = regexp.MustCompile(`(?i)@import\s+(?:'([^']*)'|"([^"]*)")`)
+)
+
+// extractCSSURLs — сырые ссылки из url(...) и @import "..." в CSS.
+// Импортированные таблицы встают в очередь и разбираются тем же парсером,
+// так что цепочки импортов обходятся рекурсивно, а от циклов
+// (a.css → b.css → a.css) защищает visited.
+func extractCSSURLs(css []byte) []string {
+	var links []string
+	for _, m := range cssImportRegex.FindAllSubmatch(css, -1) {
+		if l := string(m[1]) + string(m[2]); l != "" {
+			links = append(links, l)
+		}
+	}
+	for _, m := range cssURLRegex.FindAllSubmatch(css, -1) {
+		if len(m[1]) > 0 {
+			links = append(links, string(m[1]))
+		}
+	}
+	return links
+}
+
+// isSVG — SVG по Content-Type или по расширению (серверы часто отдают
+// .svg как text/plain или application/octet-stream)
+func isSVG(urlStr, contentType string) bool {
+	if strings.Contains(contentType, svgContentType) {
+		return true
+	}
+	u, err := url.Parse(urlStr)
+	return err == nil && strings.EqualFold(path.Ext(u.Path), ".svg")
+}
+
+// SVGParser извлекает ссылки из SVG: href/xlink:href (<image>, <use>, <a>)
+// и url(...) во встроенных <style> и атрибутах. Ссылки на фрагменты
+// того же файла (#id) отбрасывает resolveRawLinks.
+type SVGParser struct{}
+
+func (p *SVGParser) CanParse(ct string) bool { return strings.Contains(ct, svgContentType) }
+
+func (p *SVGParser) Parse(content []byte, baseURL string) ([]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.Strict = false
+
+	var links []string
+	inStyle := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(links) == 0 {
+				return nil, ErrParseFailed
+			}
+			break // Берём то, что успели найти до битого места
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inStyle = t.Name.Local == "style"
+			for _, a := range t.Attr {
+				if a.Name.Local == "href" {
+					links = append(links, a.Value)
+				} else if strings.Contains(a.Value, "url(") {
+					links = append(links, extractCSSURLs([]byte(a.Value))...)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "style" {
+				inStyle = false
+			}
+		case xml.CharData:
+			if inStyle {
+				links = append(links, extractCSSURLs(t)...)
+			}
+		}
+	}
+	return resolveRawLinks(links, baseURL), nil
+}
@@ -0,0 +1,9 @@
+/*! app.bundle.js — webpack 5 */
+(()=>{"use strict";var e={812:(e,t,n)=>{n.d(t,{Z:()=>r});const r={apiBase:"/api/v1",endpoints:{items:"/api/v1/items.json",user:"https://example.com/api/v1/me"}}},
+417:(e,t,n)=>{const o=n(812);async function a(e){const t=await fetch("/api/v1/search?q="+encodeURIComponent(e),{headers:{"Content-Type":"application/json",Accept:"text/html"}});return t.json()}
+function i(){const e=new XMLHttpRequest;e.open("GET",'/data/menu.json'),e.setRequestHeader("X-Requested-With","XMLHttpRequest"),e.send()}
+const s=`/img/sprite.svg`,c=["/img/hero@2x.webp","/fonts/inter.woff2"],l="\/static\/chunks\/412.js";
+const d=/\/(page|post)\/\d+/g,u="/",p="/.",h="//cdn.other.net/lib.js",f="https://tracker.example.org/pixel.gif",g="//www.example.com/assets/logo.png";
+const m=e=>`/users/${e}/avatar.png`,v="a/b",y="M0 0h24v24H0z",w="image/svg+xml",b="/ ",x="/";
+document.querySelectorAll("a[href^='/']").forEach(e=>{e.dataset.route="/ignored path"});t.default={a,i,s,c,l,d,u,p,h,f,g,m,v,y,w,b,x}}};})();
+//# sourceMappingURL=app.bundle.js.map
@@ -0,0 +1,4 @@
+<html><head></head><body>
+<a href="./docs/">Docs</a>
+
+</body></html>
\ No newline at end of file
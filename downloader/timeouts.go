@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Таймауты запросов: у страниц короткий — зависшая страница не держит
+// воркера, у ассетов длинный — видео и архивы успевают докачаться. Тип
+// угадывается по расширению URL; без расширения URL считается страницей.
+// Таймаут — на одну попытку, а не на все повторы.
+
+const (
+	DefaultPageTimeout  = 20 * time.Second
+	DefaultAssetTimeout = 2 * time.Minute
+)
+
+// TimeoutError — попытка не уложилась в таймаут запроса; повторяется,
+// как другие временные сбои
+type TimeoutError struct {
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string { return fmt.Sprintf("timed out after %s", e.After) }
+
+// Timeout и Temporary — как у net.Error: таймаут — временный сбой
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// requestTimeout — таймаут попытки загрузки u: на соединение и заголовки.
+// С лимитом трафика чтение тела получает свой запас (см. attemptContext).
+func (d *Downloader) requestTimeout(u string) time.Duration {
+	timeout := d.cfg.PageTimeout
+	if timeout <= 0 {
+		timeout = DefaultPageTimeout
+	}
+	if parsed, err := url.Parse(u); err == nil && isPageAsset(parsed) {
+		timeout = d.cfg.AssetTimeout
+		if timeout <= 0 {
+			timeout = DefaultAssetTimeout
+		}
+	}
+	return timeout
+}
+
+// attemptContext — контекст одной попытки, отменяется через timeout.
+// Транспорт своих таймаутов не ставит, так что зависший сервер держит
+// воркера ровно timeout. extend после заголовков переносит срок: тело под
+// лимитом трафика читается дольше. false — срок уже вышел.
+func attemptContext(ctx context.Context, timeout time.Duration) (context.Context, func(time.Duration) bool, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(timeout, cancel)
+	extend := func(d time.Duration) bool {
+		if !timer.Stop() {
+			return false
+		}
+		timer.Reset(d)
+		return true
+	}
+	return reqCtx, extend, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// isPageAsset — URL ассета страницы, а не другой страницы. Только по
+// адресу: тега, из которого пришла ссылка, при запросе уже не видно
+func isPageAsset(u *url.URL) bool {
+	p := strings.ToLower(u.Path)
+	if isAssetPath(p) {
+		return true
+	}
+	kind, ok := extFileTypes[path.Ext(p)]
+	return ok && kind != FileHTML
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Ключ URL. Каждая ссылка перед проверкой visited проходит canonicalURL
+// ровно один раз: NormalizeURL (регистр хоста, порт по умолчанию,
+// кодирование пути, "." и "..", index.html), затем единый протокол
+// (scheme.go) и query ассетов (queries.go). Путь без расширения с
+// косой чертой в конце и без неё DiskPath кладёт в одну папку
+// (/docs и /docs/ → docs/index.html), поэтому и visited считает их
+// одним URL — см. visitedLocked.
+
+// indexFiles — имена, которые сервер отдаёт по адресу папки
+var indexFiles = []string{"index.html", "index.htm"}
+
+// canonicalHost приводит хост к нижнему регистру и убирает порт по умолчанию
+func canonicalHost(u *url.URL) {
+	host := strings.ToLower(u.Host)
+	if h, port, err := net.SplitHostPort(host); err == nil &&
+		(u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443") {
+		host = h
+		if strings.Contains(h, ":") {
+			host = "[" + h + "]" // IPv6
+		}
+	}
+	u.Host = host
+}
+
+// cleanDotSegments убирает из экранированного пути "." и ".." и
+// повторные слэши, как path.Clean в DiskPath, но сохраняет слэш в конце
+func cleanDotSegments(escaped string) string {
+	if escaped == "" {
+		return ""
+	}
+	dir := strings.HasSuffix(escaped, "/") || strings.HasSuffix(escaped, "/.") || strings.HasSuffix(escaped, "/..")
+	cleaned := path.Clean("/" + escaped)
+	if dir && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// trimIndexFile отрезает /index.html и /index.htm (в любом регистре) в
+// конце пути: /docs/index.html → /docs, /index.html → /. /myindex.html —
+// другой файл.
+func trimIndexFile(p string) string {
+	lower := strings.ToLower(p)
+	for _, name := range indexFiles {
+		if strings.HasSuffix(lower, "/"+name) {
+			if p = p[:len(p)-len(name)-1]; p == "" {
+				p = "/"
+			}
+			return p
+		}
+	}
+	return p
+}
+
+// canonicalURL — ключ URL для visited, очереди и реестра путей
+func (j *Job) canonicalURL(raw string) (string, error) {
+	normalized, err := NormalizeURL(raw)
+	if err != nil {
+		return "", err
+	}
+	// http://host/x и https://host/x — один URL
+	normalized = j.scheme.canonical(normalized)
+	// /app.css?v=1 и ?v=2 — один файл: cache buster'ы не в счёт
+	return j.queries.canonical(normalized), nil
+}
+
+// visitedLocked — URL уже в обходе, в том числе под вторым написанием
+// того же пути папки (со слэшем или без). mu держит вызывающий.
+func (j *Job) visitedLocked(u string) bool {
+	if j.visited[u] {
+		return true
+	}
+	return dirLike(u) && j.visited[slashVariant(u)]
+}
+
+// dirLike — путь без расширения в последнем сегменте: DiskPath кладёт
+// его страницу в папку при любом написании конца пути
+func dirLike(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return !strings.Contains(path.Base(strings.TrimSuffix(parsed.Path, "/")), ".")
+}
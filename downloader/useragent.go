@@ -0,0 +1,185 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// User-Agent и профили браузеров. Пресет (Config.UAPreset) — это UA
+// настоящего браузера вместе с заголовками, которые шлёт именно он:
+// Accept и у Chromium — client hints (Sec-CH-UA*). Firefox и Safari
+// client hints не шлют, и Firefox с подсказками Chrome выдавал бы бота.
+// Config.UserAgents — UA для ротации; в списке можно указать и имя
+// пресета. Свой UA получает стандартные заголовки без client hints.
+
+// Пресеты браузеров (Config.UAPreset, --ua-preset)
+const (
+	UAChrome       = "chrome-latest"
+	UAFirefox      = "firefox"
+	UAGooglebot    = "googlebot"
+	UAMobileSafari = "mobile-safari"
+)
+
+// Ротация Config.UserAgents
+const (
+	UARoundRobin = "round-robin" // Каждый запрос — следующий UA (по умолчанию)
+	UAPerHost    = "per-host"    // Хост всегда видит один и тот же UA
+)
+
+// defaultAccept — Accept для DefaultUserAgent и своих UA
+const defaultAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+// BrowserProfile — UA браузера и заголовки, которые он шлёт вместе с ним
+type BrowserProfile struct {
+	UserAgent string
+	Headers   map[string]string
+}
+
+var uaPresets = map[string]BrowserProfile{
+	UAChrome: {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+		Headers: map[string]string{
+			"Accept":             "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7",
+			"Sec-Ch-Ua":          `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			"Sec-Ch-Ua-Mobile":   "?0",
+			"Sec-Ch-Ua-Platform": `"Windows"`,
+		},
+	},
+	UAFirefox: {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		Headers: map[string]string{
+			"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		},
+	},
+	UAGooglebot: {
+		UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		Headers: map[string]string{
+			"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		},
+	},
+	UAMobileSafari: {
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1",
+		Headers: map[string]string{
+			"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		},
+	},
+}
+
+// UAPresets — имена пресетов по алфавиту (для выпадающих списков)
+func UAPresets() []string {
+	names := make([]string, 0, len(uaPresets))
+	for name := range uaPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UAPreset — профиль браузера по имени пресета
+func UAPreset(name string) (BrowserProfile, error) {
+	p, ok := uaPresets[name]
+	if !ok {
+		return BrowserProfile{}, fmt.Errorf("unknown user agent preset %q (want one of %v)", name, UAPresets())
+	}
+	return p, nil
+}
+
+// profileFor — заголовки, подходящие к UA: у пресета — его собственные
+func profileFor(ua string) map[string]string {
+	for _, p := range uaPresets {
+		if p.UserAgent == ua {
+			return p.Headers
+		}
+	}
+	return map[string]string{"Accept": defaultAccept}
+}
+
+// userAgents — UA задачи: Config.UserAgents (имена пресетов раскрываются),
+// иначе UA пресета, иначе Config.UserAgent, иначе DefaultUserAgent
+func (c Config) userAgents() ([]string, error) {
+	var agents []string
+	for _, ua := range c.UserAgents {
+		if p, ok := uaPresets[ua]; ok {
+			ua = p.UserAgent
+		}
+		if ua != "" {
+			agents = append(agents, ua)
+		}
+	}
+	if len(agents) > 0 {
+		return agents, nil
+	}
+	if c.UAPreset != "" {
+		p, err := UAPreset(c.UAPreset)
+		if err != nil {
+			return []string{DefaultUserAgent}, err
+		}
+		return []string{p.UserAgent}, nil
+	}
+	if c.UserAgent != "" {
+		return []string{c.UserAgent}, nil
+	}
+	return []string{DefaultUserAgent}, nil
+}
+
+// userAgent — основной UA задачи: для проверок, robots.txt и т.п.
+func (c Config) userAgent() string {
+	agents, _ := c.userAgents()
+	return agents[0]
+}
+
+// checkUserAgents проверяет пресет и режим ротации
+func checkUserAgents(c Config) error {
+	if _, err := c.userAgents(); err != nil {
+		return err
+	}
+	switch c.UARotation {
+	case "", UARoundRobin, UAPerHost:
+		return nil
+	}
+	return fmt.Errorf("unknown user agent rotation %q (want %s or %s)", c.UARotation, UARoundRobin, UAPerHost)
+}
+
+// uaPicker выбирает UA для запроса загрузчика
+type uaPicker struct {
+	mu      sync.Mutex
+	agents  []string
+	perHost bool
+	next    int
+	hosts   map[string]string // UAPerHost: UA, закреплённый за хостом
+}
+
+func newUAPicker(c Config) *uaPicker {
+	agents, _ := c.userAgents()
+	return &uaPicker{agents: agents, perHost: c.UARotation == UAPerHost, hosts: make(map[string]string)}
+}
+
+// pick — UA для запроса к host
+func (p *uaPicker) pick(host string) string {
+	if len(p.agents) == 1 {
+		return p.agents[0]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.perHost {
+		if ua, ok := p.hosts[host]; ok {
+			return ua
+		}
+	}
+	ua := p.agents[p.next%len(p.agents)]
+	p.next++
+	if p.perHost {
+		p.hosts[host] = ua
+	}
+	return ua
+}
+
+// setBrowserHeaders выставляет UA и заголовки его браузера
+func setBrowserHeaders(req *http.Request, ua string) {
+	req.Header.Set("User-Agent", ua)
+	for k, v := range profileFor(ua) {
+		req.Header.Set(k, v)
+	}
+}
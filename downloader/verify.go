@@ -0,0 +1,254 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Проверка копии без сети (команда verify): каждая локальная ссылка
+// HTML и CSS разрешается от файла, в котором стоит, — href/src/srcset,
+// poster, meta refresh, url() в style и CSS, @import, — а ссылки от
+// корня ("/css/a.css") — от папки сайта, как их отдаст сервер. Ссылка,
+// ведущая за пределы папки или на несуществующий файл, — проблема;
+// ссылки на другие сайты только считаются.
+
+// Почему ссылка не работает
+const (
+	VerifyMissing = "missing" // Файла нет
+	VerifyOutside = "outside" // Ведёт за пределы папки сайта
+)
+
+// VerifyProblem — одна нерабочая ссылка
+type VerifyProblem struct {
+	Ref    string `json:"ref"`    // Как записана в файле
+	Target string `json:"target"` // Куда ведёт, относительно папки сайта
+	Reason string `json:"reason"`
+}
+
+// VerifyPage — файл с нерабочими ссылками
+type VerifyPage struct {
+	Page     string          `json:"page"`
+	Problems []VerifyProblem `json:"problems"`
+}
+
+// VerifyReport — итог VerifySite
+type VerifyReport struct {
+	Dir      string       `json:"dir"`
+	Files    int          `json:"files"`    // Проверено HTML и CSS
+	Refs     int          `json:"refs"`     // Локальных ссылок
+	External int          `json:"external"` // Ссылок на другие сайты
+	Pages    []VerifyPage `json:"pages"`    // Только файлы с проблемами, по пути
+}
+
+// Problems — сколько всего нерабочих ссылок
+func (r VerifyReport) Problems() int {
+	n := 0
+	for _, p := range r.Pages {
+		n += len(p.Problems)
+	}
+	return n
+}
+
+// verifyExts — файлы, ссылки из которых проверяются; .php — страница
+// из необработанной загрузки
+var verifyExts = map[string]bool{".html": true, ".htm": true, ".xhtml": true, ".php": true, ".css": true}
+
+// VerifySite проверяет ссылки всех HTML и CSS файлов папки сайта.
+// Служебные папки (.meta, .cache) не проверяются.
+func VerifySite(dir string) (VerifyReport, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return VerifyReport{}, err
+	} else if !fi.IsDir() {
+		return VerifyReport{}, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	report := VerifyReport{Dir: dir, Pages: []VerifyPage{}}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if !verifyExts[ext] {
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		report.Files++
+
+		var refs []string
+		if ext == ".css" {
+			refs = cssRefStrings(string(content))
+		} else if refs, err = htmlRefs(content); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+
+		var problems []VerifyProblem
+		seen := make(map[string]bool)
+		for _, ref := range refs {
+			target, reason, local := verifyRef(root, p, ref)
+			if !local {
+				if reason == "" && target != "" {
+					report.External++
+				}
+				continue
+			}
+			report.Refs++
+			if reason != "" && !seen[ref] {
+				seen[ref] = true
+				problems = append(problems, VerifyProblem{Ref: ref, Target: target, Reason: reason})
+			}
+		}
+		if len(problems) > 0 {
+			rel, _ := filepath.Rel(root, p)
+			report.Pages = append(report.Pages, VerifyPage{Page: filepath.ToSlash(rel), Problems: problems})
+		}
+		return nil
+	})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	sort.Slice(report.Pages, func(a, b int) bool { return report.Pages[a].Page < report.Pages[b].Page })
+	return report, nil
+}
+
+// verifyRef разрешает ссылку ref из файла file. local=false — ссылка не
+// на файл сайта: якорь, data:, mailto: или другой сайт (тогда target —
+// её адрес). Для локальной target — путь от папки сайта через "/".
+func verifyRef(root, file, ref string) (target, reason string, local bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", "", false
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", false
+	}
+	if u.Host != "" || u.Scheme == "http" || u.Scheme == "https" {
+		return ref, "", false
+	}
+	if u.Scheme != "" || u.Path == "" {
+		return "", "", false
+	}
+
+	var abs string
+	if strings.HasPrefix(u.Path, "/") {
+		abs = filepath.Join(root, filepath.FromSlash(u.Path))
+	} else {
+		abs = filepath.Join(filepath.Dir(file), filepath.FromSlash(u.Path))
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(rel), VerifyOutside, true
+	}
+	target = filepath.ToSlash(rel)
+
+	fi, err := os.Stat(abs)
+	switch {
+	case err != nil:
+		return target, VerifyMissing, true
+	case fi.IsDir():
+		// Папка открывается своим index.html
+		if _, err := os.Stat(filepath.Join(abs, "index.html")); err != nil {
+			return target + "/index.html", VerifyMissing, true
+		}
+	}
+	return target, "", true
+}
+
+// htmlRefs — ссылки страницы, которые браузер загрузит или откроет
+func htmlRefs(content []byte) ([]string, error) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "style" && n.FirstChild != nil {
+				refs = append(refs, cssRefStrings(n.FirstChild.Data)...)
+			}
+			for _, a := range n.Attr {
+				name := strings.ToLower(a.Key)
+				if a.Namespace == "xlink" && name == "href" {
+					name = "href"
+				} else if a.Namespace != "" {
+					continue
+				}
+				switch {
+				case name == "href" && n.Data == "base":
+					// Адрес базы — не ссылка на файл
+				case name == "href" || name == "src" || name == "poster":
+					refs = append(refs, a.Val)
+				case name == "srcset":
+					refs = append(refs, splitSrcset(a.Val)...)
+				case name == "style":
+					refs = append(refs, cssRefStrings(a.Val)...)
+				case name == "content" && n.Data == "meta":
+					if _, target, _, ok := SplitRefresh(a.Val); ok && IsRefreshMeta(n) {
+						refs = append(refs, target)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return refs, nil
+}
+
+// cssRefRegex — url(...) с границами самой ссылки: в одинарных, двойных кавычках или без них
+var cssRefRegex = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^'"\)\s]+))\s*\)`)
+
+// CSSRefs — границы ссылок в CSS: url(...) и @import "...", по порядку.
+// Общие для обработки (она переписывает ссылки на месте) и проверки копии.
+func CSSRefs(content string) [][2]int {
+	var refs [][2]int
+	for _, re := range []*regexp.Regexp{cssRefRegex, cssImportRegex} {
+		for _, loc := range re.FindAllStringSubmatchIndex(content, -1) {
+			// Группы: ссылка в '', в "" или (у url()) без кавычек
+			for g := 1; 2*g < len(loc); g++ {
+				if loc[2*g] >= 0 {
+					if loc[2*g] < loc[2*g+1] {
+						refs = append(refs, [2]int{loc[2*g], loc[2*g+1]})
+					}
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(refs, func(a, b int) bool { return refs[a][0] < refs[b][0] })
+	return refs
+}
+
+// cssRefStrings — сами ссылки CSSRefs
+func cssRefStrings(content string) []string {
+	var refs []string
+	for _, r := range CSSRefs(content) {
+		refs = append(refs, content[r[0]:r[1]])
+	}
+	return refs
+}
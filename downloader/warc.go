@@ -0,0 +1,229 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WARC-архив (Config.Format): каждый ответ сервера — заголовки и тело как
+// пришли, до переписывания ссылок — дописывается записью response в
+// <OutputDir>/<id>.warc.gz. Каждая запись — отдельный gzip-member: так
+// файл читают pywb и replayweb.page и по нему можно искать по смещению.
+// При resume архив дописывается, в начале каждого запуска — запись warcinfo.
+
+// Форматы результата
+const (
+	FormatTree     = "tree"      // Папка с сайтом для локального просмотра (по умолчанию)
+	FormatWARC     = "warc"      // Только WARC-архив, без папки
+	FormatWARCTree = "warc+tree" // И архив, и папка
+)
+
+// WARCExtension — архив задачи рядом с файлами состояния (<id>.warc.gz)
+const WARCExtension = ".warc.gz"
+
+// warcVersion — первая строка каждой записи
+const warcVersion = "WARC/1.1"
+
+// checkFormat проверяет Config.Format; пустая строка — FormatTree
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatTree, FormatWARC, FormatWARCTree:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (want %s, %s or %s)", format, FormatTree, FormatWARC, FormatWARCTree)
+}
+
+// writesWARC — пишет ли задача WARC-архив
+func (c Config) writesWARC() bool {
+	return c.Format == FormatWARC || c.Format == FormatWARCTree
+}
+
+// writesTree — сохраняет ли задача файлы сайта в папку
+func (c Config) writesTree() bool {
+	return c.Format != FormatWARC
+}
+
+// WARCFile — путь к WARC-архиву задачи
+func (j *Job) WARCFile() string {
+	return strings.TrimSuffix(j.stateFile, StateFileExtension) + WARCExtension
+}
+
+// warcField — поле заголовка записи; порядок полей сохраняется
+type warcField struct {
+	name, value string
+}
+
+// warcWriter дописывает записи в архив; безопасен для нескольких воркеров
+type warcWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	zw  *gzip.Writer
+	ids io.Reader // Источник случайных байт для WARC-Record-ID
+	now func() time.Time
+}
+
+// openWARC открывает архив на дозапись и пишет запись warcinfo
+func openWARC(path string, cfg Config) (*warcWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &warcWriter{f: f, zw: gzip.NewWriter(io.Discard), ids: rand.Reader, now: cfg.now}
+	if cfg.Deterministic {
+		w.ids = mathrand.New(mathrand.NewSource(cfg.Seed))
+	}
+	info := "software: sitecloner\r\nformat: WARC File Format 1.1\r\n"
+	if err := w.write([]warcField{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Filename", filepath.Base(path)},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// writeResponse дописывает ответ сервера на запрос targetURI
+func (w *warcWriter) writeResponse(targetURI string, res FetchResult) error {
+	if w == nil {
+		return nil
+	}
+	return w.write([]warcField{
+		{"WARC-Type", "response"},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Payload-Digest", warcDigest(res.Content)},
+		{"Content-Type", "application/http; msgtype=response"},
+	}, httpResponseBlock(res.Status, res.Header, res.Content))
+}
+
+// archiveRedirects дописывает в архив ответы-редиректы hops: без них
+// старый адрес в архиве не открылся бы
+func (j *Job) archiveRedirects(hops []redirectHop) {
+	for _, h := range hops {
+		if err := j.warc.writeResponse(h.url, FetchResult{Status: h.status, Header: h.header}); err != nil {
+			j.sendLog(fmt.Sprintf("[Error] WARC write failed for %s: %v", h.url, err), false)
+		}
+	}
+}
+
+// write добавляет к полям WARC-Record-ID и WARC-Date и пишет запись
+// отдельным gzip-member
+func (w *warcWriter) write(fields []warcField, block []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	id, err := newRecordID(w.ids)
+	if err != nil {
+		return err
+	}
+	head := []warcField{fields[0], {"WARC-Record-ID", id}, {"WARC-Date", w.now().UTC().Format(time.RFC3339)}}
+	record := formatWARCRecord(append(head, fields[1:]...), block)
+
+	w.zw.Reset(w.f)
+	if _, err := w.zw.Write(record); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func (w *warcWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// formatWARCRecord собирает запись: версия, поля, Content-Length, пустая
+// строка, блок и два CRLF в конце
+func formatWARCRecord(fields []warcField, block []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(warcVersion + "\r\n")
+	for _, f := range fields {
+		b.WriteString(f.name + ": " + f.value + "\r\n")
+	}
+	b.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+	return b.Bytes()
+}
+
+// httpResponseBlock восстанавливает HTTP-ответ: строка статуса, заголовки
+// (в порядке имён) и тело. Go уже снял chunked, сжатие снял decodeBody,
+// поэтому Transfer-Encoding и Content-Encoding в заголовках нет.
+func httpResponseBlock(status int, header http.Header, body []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// warcDigest — WARC-Payload-Digest в принятом у архивов виде: sha1 в base32
+func warcDigest(payload []byte) string {
+	sum := sha1.Sum(payload)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordID — случайный UUID версии 4 в виде <urn:uuid:...>
+func newRecordID(r io.Reader) (string, error) {
+	var u [16]byte
+	if _, err := io.ReadFull(r, u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// archiveOnly завершает URL в режиме FormatWARC: ответ уже в архиве,
+// файл не пишется, а статистика, события и обход ссылок — как обычно
+func (j *Job) archiveOnly(workerID int, requestedURL, urlStr string, content []byte, contentType string, header http.Header, depth int) {
+	j.noteCompleted(requestedURL, urlStr)
+	atomic.AddInt64(&j.stats.TotalFiles, 1)
+	recovered := j.deferred.deferred(requestedURL)
+	if recovered {
+		atomic.AddInt64(&j.stats.Recovered, 1)
+	}
+	atomic.AddInt64(&j.stats.DownloadedBytes, int64(len(content)))
+	j.mu.Lock()
+	j.stats.FileTypes[fileCategory(urlStr, contentType)]++
+	j.mu.Unlock()
+	j.sendLog(fmt.Sprintf("[Done] Archived: %s", urlStr), false)
+	j.fileDone(FileResult{
+		URL:         urlStr,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Depth:       depth,
+		Recovered:   recovered,
+	})
+
+	if depth < j.Config.MaxDepth {
+		j.workers.phase(workerID, PhaseParsing)
+		j.queueLinks(j.parseLinks(content, contentType, urlStr, header), depth, urlStr)
+	}
+}
@@ -0,0 +1,194 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultStuckThreshold = 60 * time.Second
+
+// ErrWorkerPanic — обработка URL закончилась паникой (см. processURLSafe)
+var ErrWorkerPanic = errors.New("worker panic")
+
+// Фазы обработки URL воркером
+const (
+	PhaseIdle        = "idle"
+	PhaseDownloading = "downloading"
+	PhaseParsing     = "parsing"
+	PhaseSaving      = "saving"
+)
+
+// WorkerState — что делает воркер прямо сейчас
+type WorkerState struct {
+	ID      int           `json:"id"`
+	URL     string        `json:"url"`
+	Phase   string        `json:"phase"`
+	Since   time.Time     `json:"since"`   // Когда начат текущий URL
+	Elapsed time.Duration `json:"elapsed"` // Сколько времени висит на текущем URL
+}
+
+// workerTable — живая таблица состояний воркеров
+type workerTable struct {
+	mu      sync.Mutex
+	states  []WorkerState
+	flagged map[string]bool // URL, о которых уже сообщили как о зависших
+}
+
+func newWorkerTable(n int) *workerTable {
+	t := &workerTable{
+		states:  make([]WorkerState, n),
+		flagged: make(map[string]bool),
+	}
+	for i := range t.states {
+		t.states[i] = WorkerState{ID: i, Phase: PhaseIdle}
+	}
+	return t
+}
+
+// start — воркер взял новый URL
+func (t *workerTable) start(id int, u string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.states[id] = WorkerState{ID: id, URL: u, Phase: PhaseDownloading, Since: time.Now()}
+}
+
+// phase — смена фазы без сброса времени
+func (t *workerTable) phase(id int, phase string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.states[id].Phase = phase
+}
+
+func (t *workerTable) idle(id int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.flagged, t.states[id].URL)
+	t.states[id] = WorkerState{ID: id, Phase: PhaseIdle}
+}
+
+func (t *workerTable) snapshot() []WorkerState {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]WorkerState, len(t.states))
+	now := time.Now()
+	for i, s := range t.states {
+		if s.Phase != PhaseIdle {
+			s.Elapsed = now.Sub(s.Since).Round(time.Second)
+		}
+		res[i] = s
+	}
+	return res
+}
+
+// stuck возвращает воркеров, висящих на одном URL дольше threshold.
+// Каждый URL возвращается только один раз.
+func (t *workerTable) stuck(threshold time.Duration) []WorkerState {
+	if t == nil {
+		return nil
+	}
+	var res []WorkerState
+	for _, s := range t.snapshot() {
+		if s.Phase == PhaseIdle || s.Elapsed < threshold {
+			continue
+		}
+		t.mu.Lock()
+		seen := t.flagged[s.URL]
+		t.flagged[s.URL] = true
+		t.mu.Unlock()
+		if !seen {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// WorkerStatus возвращает текущее состояние всех воркеров
+func (j *Job) WorkerStatus() []WorkerState {
+	return j.workers.snapshot()
+}
+
+// FormatWorkerTable рисует таблицу воркеров для терминала
+func FormatWorkerTable(states []WorkerState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-3s %-12s %-8s %s\n", "#", "PHASE", "ELAPSED", "URL")
+	for _, s := range states {
+		elapsed := "-"
+		if s.Phase != PhaseIdle {
+			elapsed = s.Elapsed.String()
+		}
+		fmt.Fprintf(&b, "%-3d %-12s %-8s %s\n", s.ID, s.Phase, elapsed, s.URL)
+	}
+	return b.String()
+}
+
+// processURLSafe — processURL, который переживает панику: URL считается
+// упавшим, стек уходит в лог задачи, воркер берёт следующий URL. Без
+// этого горутина воркера умерла бы, не сняв URL с activeWG, и задача
+// зависла бы без единой ошибки.
+func (j *Job) processURLSafe(workerID int, urlStr string) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err := fmt.Errorf("%w: %v", ErrWorkerPanic, r)
+		j.sendLog(fmt.Sprintf("💥 [Panic] Worker %d crashed on %s: %v (URL skipped, crawl continues)", workerID, urlStr, r), false)
+		j.sendLog(string(debug.Stack()), true)
+		atomic.AddInt64(&j.stats.Failed, 1)
+		j.panics.add(urlStr, err)
+		j.fileError(ErrorEvent{URL: urlStr, Err: err})
+	}()
+	j.processURL(workerID, urlStr)
+}
+
+// panicOutcomes — URL, упавшие с паникой. Паника могла случиться между
+// j.mu.Lock() и Unlock(), поэтому восстановление j.mu не берёт: исход
+// ложится сюда под собственным замком, а в failed и skipReasons его
+// переносит foldPanics при следующем чтении под j.mu.
+type panicOutcomes struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+func (p *panicOutcomes) add(urlStr string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.errs == nil {
+		p.errs = make(map[string]error)
+	}
+	p.errs[urlStr] = err
+}
+
+func (p *panicOutcomes) take() map[string]error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := p.errs
+	p.errs = nil
+	return errs
+}
+
+// foldPanics переносит исходы паник в отчёты задачи. Вызывать под j.mu.
+func (j *Job) foldPanics() {
+	for u, err := range j.panics.take() {
+		j.noteSkipLocked(u, err.Error())
+		j.noteFailedLocked(u, err)
+	}
+}
@@ -6,7 +6,17 @@ import React, {
   useMemo,
 } from "react";
 // @ts-ignore
-import { DownloadSite } from "../../wailsjs/go/main/App";
+import {
+  DownloadSite,
+  GetWorkerStatus,
+  ListJobs,
+  PauseDownload,
+  RecrawlURLs,
+  ResumeDownload,
+  StopDownload,
+  StopJob,
+  UserAgentPresets,
+} from "../../wailsjs/go/main/App";
 // @ts-ignore
 import { EventsOn } from "../../wailsjs/runtime";
 import { useTranslation } from "../i18n";
@@ -39,14 +49,223 @@ const LogEntry = React.memo(({ log }: { log: string }) => {
   );
 });
 
+const phaseColor: Record<string, string> = {
+  downloading: "text-neon-cyan",
+  parsing: "text-yellow-400",
+  saving: "text-green-400",
+  idle: "text-gray-600",
+};
+
+// Debug panel: live worker table, polled while a download is running
+const WorkerPanel = ({ url }: { url: string }) => {
+  const [workers, setWorkers] = useState<any[]>([]);
+
+  useEffect(() => {
+    const poll = async () => {
+      try {
+        setWorkers((await GetWorkerStatus(url)) || []);
+      } catch {
+        setWorkers([]);
+      }
+    };
+    poll();
+    const id = setInterval(poll, 2000);
+    return () => clearInterval(id);
+  }, [url]);
+
+  if (workers.length === 0) return null;
+
+  return (
+    <div className="mt-4 max-h-40 overflow-y-auto scrollbar-custom font-mono text-[11px]">
+      {workers.map((w) => {
+        const seconds = Math.round((w.elapsed || 0) / 1e9);
+        return (
+          <div key={w.id} className="flex gap-3 px-1 py-0.5">
+            <span className="text-gray-500 w-6">#{w.id}</span>
+            <span className={`w-24 ${phaseColor[w.phase] || "text-gray-400"}`}>
+              {w.phase}
+            </span>
+            <span className={`w-12 ${seconds >= 60 ? "text-red-400" : "text-gray-400"}`}>
+              {w.phase === "idle" ? "-" : `${seconds}s`}
+            </span>
+            <span className="text-gray-300 truncate">{w.url}</span>
+          </div>
+        );
+      })}
+    </div>
+  );
+};
+
+// Sitemap coverage after a finished crawl: pages listed in the sitemap
+// that were not downloaded, with the reason when the crawler knows it
+const CoveragePanel = ({
+  coverage,
+  onRecrawl,
+  disabled,
+}: {
+  coverage: any;
+  onRecrawl: (urls: string[]) => void;
+  disabled: boolean;
+}) => {
+  const { t } = useTranslation();
+  const missed: any[] = coverage.missed || [];
+
+  return (
+    <div className="bg-graphite-800/40 backdrop-blur-md rounded-2xl p-5 border border-white/5 animate-toast-in">
+      <div className="flex justify-between items-center mb-3">
+        <div>
+          <p className="text-neon-cyan text-[10px] font-black uppercase tracking-[0.2em] mb-1">
+            {t("sitemap_coverage")}
+          </p>
+          <p className="text-white font-mono text-sm">
+            {coverage.percent.toFixed(1)}% · {coverage.downloaded}/{coverage.listed}
+          </p>
+        </div>
+        {missed.length > 0 && (
+          <button
+            onClick={() => onRecrawl(missed.map((m) => m.url))}
+            disabled={disabled}
+            className="px-3 py-1 rounded-lg border border-neon-cyan/30 text-xs font-bold text-neon-cyan hover:border-neon-cyan/60 transition-all disabled:opacity-40 disabled:cursor-not-allowed"
+          >
+            ⬇ {t("download_missed")} ({missed.length})
+          </button>
+        )}
+      </div>
+      {missed.length > 0 && (
+        <div className="max-h-40 overflow-y-auto scrollbar-custom font-mono text-[11px]">
+          {missed.map((m) => (
+            <div key={m.url} className="flex gap-3 px-1 py-0.5">
+              <span className="text-gray-300 truncate flex-1">{m.url}</span>
+              <span className="text-gray-500 truncate max-w-[40%]">{m.reason || "—"}</span>
+            </div>
+          ))}
+        </div>
+      )}
+    </div>
+  );
+};
+
+// parseHeaders turns "Name: value" lines into a header map; blank and
+// malformed lines are ignored (the backend validates names again)
+const parseHeaders = (text: string) => {
+  const headers: { [key: string]: string } = {};
+  for (const line of text.split("\n")) {
+    const i = line.indexOf(":");
+    if (i > 0) headers[line.slice(0, i).trim()] = line.slice(i + 1).trim();
+  }
+  return headers;
+};
+
+// parseLines splits a textarea into trimmed non-empty lines
+const parseLines = (text: string) =>
+  text
+    .split("\n")
+    .map((l) => l.trim())
+    .filter(Boolean);
+
+// formatEta renders seconds as m:ss or h:mm:ss
+const formatEta = (secs: number) => {
+  const h = Math.floor(secs / 3600);
+  const m = Math.floor((secs % 3600) / 60);
+  const s = String(secs % 60).padStart(2, "0");
+  return h > 0 ? `${h}:${String(m).padStart(2, "0")}:${s}` : `${m}:${s}`;
+};
+
+// formatSpeed renders bytes per second as KB/s or MB/s
+const formatSpeed = (bps: number) =>
+  bps >= 1 << 20
+    ? `${(bps / (1 << 20)).toFixed(1)} MB/s`
+    : `${(bps / 1024).toFixed(0)} KB/s`;
+
+const jobStatusKey: Record<string, "job_queued" | "job_running" | "job_stopping"> = {
+  queued: "job_queued",
+  running: "job_running",
+  stopping: "job_stopping",
+};
+
+// Jobs panel: every queued and running download, polled from the job
+// manager; the backend runs two at a time and queues the rest
+const JobsPanel = () => {
+  const { t } = useTranslation();
+  const [jobs, setJobs] = useState<any[]>([]);
+
+  useEffect(() => {
+    const poll = async () => {
+      try {
+        setJobs((await ListJobs()) || []);
+      } catch {
+        setJobs([]);
+      }
+    };
+    poll();
+    const id = setInterval(poll, 1000);
+    return () => clearInterval(id);
+  }, []);
+
+  if (jobs.length === 0) return null;
+
+  return (
+    <div className="bg-graphite-800/40 backdrop-blur-md rounded-2xl p-5 border border-white/5">
+      <p className="text-neon-cyan text-[10px] font-black uppercase tracking-[0.2em] mb-2">
+        {t("jobs")}
+      </p>
+      {jobs.map((j) => (
+        <div key={j.id} className="flex items-center gap-3 py-1 font-mono text-[11px]">
+          <span className="w-20 text-gray-400">
+            {t(j.paused ? "job_paused" : jobStatusKey[j.status] || "job_running")}
+          </span>
+          <span className="flex-1 text-gray-300 truncate">{j.url}</span>
+          <span className="text-gray-500">
+            {j.files} · {formatSpeed(j.speed || 0)}
+          </span>
+          <button
+            onClick={() => StopJob(j.id)}
+            disabled={j.status === "stopping"}
+            className="px-2 py-0.5 rounded border border-red-500/30 text-red-400 hover:border-red-400/60 disabled:opacity-40"
+          >
+            ⏹ {t("stop")}
+          </button>
+        </div>
+      ))}
+    </div>
+  );
+};
+
 const DownloadView = () => {
   const { t } = useTranslation();
   const { isDownloading, setIsDownloading, downloadLogs, setDownloadLogs } =
     useApp();
   const [url, setUrl] = useState("");
-  const [progress, setProgress] = useState({ current: 0, total: 0 });
+  const [headersText, setHeadersText] = useState("");
+  const [includeText, setIncludeText] = useState("");
+  const [excludeText, setExcludeText] = useState("");
+  const [blockText, setBlockText] = useState("");
+  const [externalAssets, setExternalAssets] = useState(false);
+  const [includeSubdomains, setIncludeSubdomains] = useState(false);
+  const [parseJavaScript, setParseJavaScript] = useState(false);
+  const [singlePage, setSinglePage] = useState(false);
+  const [uaPreset, setUAPreset] = useState("");
+  const [uaPresets, setUAPresets] = useState<string[]>([]);
+  const [extraDomainsText, setExtraDomainsText] = useState("");
+  const [progress, setProgress] = useState({
+    current: 0,
+    total: 0,
+    phase: "discovery",
+    eta: 0,
+    speed: 0,
+  });
+  const [pausing, setPausing] = useState(false);
+  const [coverage, setCoverage] = useState<any>(null);
+  const [backoff, setBackoff] = useState<any>(null);
+  const [budget, setBudget] = useState<any>(null);
   const logEndRef = useRef<HTMLDivElement>(null);
 
+  useEffect(() => {
+    UserAgentPresets()
+      .then((names: string[]) => setUAPresets(names || []))
+      .catch(() => setUAPresets([]));
+  }, []);
+
   useEffect(() => {
     if (logEndRef.current)
       logEndRef.current.scrollIntoView({ behavior: "smooth" });
@@ -55,11 +274,36 @@ const DownloadView = () => {
   useEffect(() => {
     const minTime = setTimeout(() => {}, 0);
     const clProgress = EventsOn("download:progress", (data: any) => {
-      setProgress({ current: data.current, total: data.total });
+      setProgress({
+        current: data.current,
+        total: data.total,
+        phase: data.phase,
+        eta: data.eta,
+        speed: data.speed,
+      });
+    });
+    const clPhase = EventsOn("download:phase", (phase: string) => {
+      setProgress((p) => ({ ...p, phase }));
+    });
+    const clCoverage = EventsOn("download:coverage", (report: any) => {
+      setCoverage(report);
+    });
+    const clBackoff = EventsOn("download:backoff", (data: any) => {
+      setBackoff({ ...data, until: Date.now() + data.wait });
+    });
+    const clBudget = EventsOn("download:budget", (data: any) => {
+      setBudget(data);
     });
     const clDone = EventsOn("download:done", () => {
       setIsDownloading(false);
-      setProgress({ current: 0, total: 0 });
+      setPausing(false);
+      setProgress({
+        current: 0,
+        total: 0,
+        phase: "discovery",
+        eta: 0,
+        speed: 0,
+      });
     });
 
     // Handle tab switching
@@ -67,7 +311,13 @@ const DownloadView = () => {
       if (document.visibilityState === "visible") {
         // Refresh progress when tab becomes visible
         EventsOn("download:progress", (data: any) => {
-          setProgress({ current: data.current, total: data.total });
+          setProgress({
+            current: data.current,
+            total: data.total,
+            phase: data.phase,
+            eta: data.eta,
+            speed: data.speed,
+          });
         });
       }
     };
@@ -76,6 +326,10 @@ const DownloadView = () => {
 
     return () => {
       clProgress();
+      clPhase();
+      clCoverage();
+      clBackoff();
+      clBudget();
       clDone();
       document.removeEventListener("visibilitychange", handleVisibilityChange);
     };
@@ -89,13 +343,84 @@ const DownloadView = () => {
     [progress],
   );
 
+  // Pause waits for in-flight downloads to be saved, so the button stays
+  // disabled until the backend confirms
+  const handlePauseToggle = useCallback(async () => {
+    setPausing(true);
+    try {
+      const res =
+        progress.phase === "paused"
+          ? await ResumeDownload(url)
+          : await PauseDownload(url);
+      if (res && res.startsWith("Error")) {
+        setDownloadLogs((prev) => [...prev, `[System] ${res}`]);
+      }
+    } finally {
+      setPausing(false);
+    }
+  }, [url, progress.phase, setDownloadLogs]);
+
+  // Stop keeps the queue for resume; the panel closes on download:done
+  const handleStop = useCallback(async () => {
+    setPausing(true);
+    const res = await StopDownload(url);
+    if (res && res.startsWith("Error")) {
+      setDownloadLogs((prev) => [...prev, `[System] ${res}`]);
+      setPausing(false);
+    }
+  }, [url, setDownloadLogs]);
+
+  // "Download these too": a targeted re-crawl of the missed sitemap pages
+  const handleRecrawl = useCallback(
+    async (urls: string[]) => {
+      if (!coverage) return;
+      setDownloadLogs((prev) => [...prev, `> ${t("download_missed")}: ${urls.length}`]);
+      setIsDownloading(true);
+      setCoverage(null);
+      setProgress({
+        current: 0,
+        total: 0,
+        phase: "downloading",
+        eta: 0,
+        speed: 0,
+      });
+      const res = await RecrawlURLs(coverage.rootUrl, urls);
+      if (res && res.startsWith("Error")) {
+        setDownloadLogs((prev) => [...prev, `[System] ${res}`]);
+        setIsDownloading(false);
+      }
+    },
+    [coverage, t, setDownloadLogs, setIsDownloading],
+  );
+
   const handleDownload = useCallback(async () => {
-    if (!url) return;
+    // Several URLs separated by spaces are downloaded as one batch
+    const urls = url.split(/[\s,]+/).filter(Boolean);
+    if (urls.length === 0) return;
+    setCoverage(null);
+    setBudget(null);
     setDownloadLogs([`> Инициализация захвата: ${url}`]);
     setIsDownloading(true);
-    setProgress({ current: 0, total: 0 });
+    setProgress({
+      current: 0,
+      total: 0,
+      phase: "discovery",
+      eta: 0,
+      speed: 0,
+    });
     try {
-      const res = await DownloadSite(url, "downloads");
+      const res = await DownloadSite(urls, "downloads", {
+        headers: parseHeaders(headersText),
+        include: parseLines(includeText),
+        exclude: parseLines(excludeText),
+        block: parseLines(blockText),
+        externalAssets,
+        extraDomains: parseLines(extraDomainsText),
+        includeSubdomains,
+        parseJavaScript,
+        singlePage,
+        uaPreset,
+      });
       if (res && res.startsWith("Error")) {
         setDownloadLogs((prev) => [...prev, `[System] ${res}`]);
         setIsDownloading(false);
@@ -104,7 +429,21 @@ const DownloadView = () => {
       setDownloadLogs((prev) => [...prev, `[Bridge Error] ${err}`]);
       setIsDownloading(false);
     }
-  }, [url, setDownloadLogs, setIsDownloading]);
+  }, [
+    url,
+    headersText,
+    includeText,
+    excludeText,
+    blockText,
+    externalAssets,
+    extraDomainsText,
+    includeSubdomains,
+    parseJavaScript,
+    singlePage,
+    uaPreset,
+    setDownloadLogs,
+    setIsDownloading,
+  ]);
 
   return (
     <div className="flex flex-col h-full gap-6 animate-fade-in">
@@ -122,26 +461,123 @@ const DownloadView = () => {
             onKeyDown={(e) => e.key === "Enter" && handleDownload()}
             className="flex-1 bg-black/40 border border-white/10 rounded-xl px-4 py-3 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 focus:ring-1 focus:ring-neon-cyan/50 transition-all font-mono"
           />
+          {/* Stays enabled while downloading: further jobs wait in the manager's queue */}
           <button
             onClick={handleDownload}
-            disabled={isDownloading}
-            className={`px-8 py-3 rounded-xl font-bold transition-all shadow-lg flex items-center gap-2 ${
-              isDownloading
-                ? "bg-gray-700/50 text-gray-400 cursor-not-allowed border border-white/5"
-                : "bg-gradient-to-r from-neon-cyan to-blue-600 hover:shadow-neon-cyan/25 hover:scale-105 active:scale-95 text-white"
-            }`}
+            className="px-8 py-3 rounded-xl font-bold transition-all shadow-lg flex items-center gap-2 bg-gradient-to-r from-neon-cyan to-blue-600 hover:shadow-neon-cyan/25 hover:scale-105 active:scale-95 text-white"
           >
-            {isDownloading ? (
-              <>
-                <span className="animate-spin">⚙️</span> {t("processing")}
-              </>
-            ) : (
-              <>🚀 {t("start")}</>
-            )}
+            {isDownloading && <span className="animate-spin">⚙️</span>}
+            🚀 {t("start")}
           </button>
         </div>
+        <details className="mt-3 text-xs text-gray-400">
+          <summary className="cursor-pointer select-none">
+            {t("crawl_options")}
+          </summary>
+          <p className="mt-2">{t("request_headers")}</p>
+          <textarea
+            value={headersText}
+            onChange={(e) => setHeadersText(e.target.value)}
+            placeholder={"Authorization: Bearer …\nAccept-Language: en-US"}
+            rows={3}
+            className="mt-2 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+          />
+          <label className="block mt-2">
+            {t("ua_preset")}
+            <select
+              value={uaPreset}
+              onChange={(e) => setUAPreset(e.target.value)}
+              className="mt-1 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+            >
+              <option value="">{t("ua_default")}</option>
+              {uaPresets.map((name) => (
+                <option key={name} value={name}>
+                  {name}
+                </option>
+              ))}
+            </select>
+          </label>
+          <div className="grid grid-cols-2 gap-3 mt-2">
+            <label>
+              {t("include_patterns")}
+              <textarea
+                value={includeText}
+                onChange={(e) => setIncludeText(e.target.value)}
+                placeholder={"/docs/**"}
+                rows={2}
+                className="mt-1 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+              />
+            </label>
+            <label>
+              {t("exclude_patterns")}
+              <textarea
+                value={excludeText}
+                onChange={(e) => setExcludeText(e.target.value)}
+                placeholder={"/tag/*\nre:\\?page=\\d+"}
+                rows={2}
+                className="mt-1 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+              />
+            </label>
+          </div>
+          <label className="block mt-2">
+            {t("block_substrings")}
+            <textarea
+              value={blockText}
+              onChange={(e) => setBlockText(e.target.value)}
+              placeholder={"yoomoney\nt.me/"}
+              rows={2}
+              className="mt-1 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+            />
+          </label>
+          <label className="mt-3 flex items-center gap-2">
+            <input
+              type="checkbox"
+              checked={singlePage}
+              onChange={(e) => setSinglePage(e.target.checked)}
+            />
+            {t("single_page")}
+          </label>
+          <label className="mt-2 flex items-center gap-2">
+            <input
+              type="checkbox"
+              checked={includeSubdomains}
+              onChange={(e) => setIncludeSubdomains(e.target.checked)}
+            />
+            {t("include_subdomains")}
+          </label>
+          <label className="mt-2 flex items-center gap-2">
+            <input
+              type="checkbox"
+              checked={parseJavaScript}
+              onChange={(e) => setParseJavaScript(e.target.checked)}
+            />
+            {t("parse_javascript")}
+          </label>
+          <label className="mt-2 flex items-center gap-2">
+            <input
+              type="checkbox"
+              checked={externalAssets}
+              onChange={(e) => setExternalAssets(e.target.checked)}
+            />
+            {t("external_assets")}
+          </label>
+          {externalAssets && (
+            <label className="block mt-2">
+              {t("extra_domains")}
+              <textarea
+                value={extraDomainsText}
+                onChange={(e) => setExtraDomainsText(e.target.value)}
+                placeholder={"cdn.example.net\nfonts.googleapis.com"}
+                rows={2}
+                className="mt-1 w-full bg-black/40 border border-white/10 rounded-xl px-4 py-2 text-white placeholder-gray-600 focus:outline-none focus:border-neon-cyan/50 font-mono text-xs"
+              />
+            </label>
+          )}
+        </details>
       </div>
 
+      <JobsPanel />
+
       {/* Progress Section */}
       {isDownloading && (
         <div className="bg-graphite-800/40 backdrop-blur-md rounded-2xl p-5 border border-neon-cyan/20 animate-toast-in">
@@ -156,9 +592,51 @@ const DownloadView = () => {
               </p>
             </div>
             <div className="text-right">
-              <span className="text-neon-cyan font-mono text-2xl font-black">
-                {downloadPercent}%
-              </span>
+              <div className="flex items-center justify-end gap-3">
+                <button
+                  onClick={handlePauseToggle}
+                  disabled={pausing}
+                  className="px-3 py-1 rounded-lg border border-white/10 text-xs font-bold text-gray-300 hover:text-white hover:border-neon-cyan/50 transition-all disabled:opacity-40 disabled:cursor-not-allowed"
+                >
+                  {progress.phase === "paused" ? `▶ ${t("resume")}` : `⏸ ${t("pause")}`}
+                </button>
+                <button
+                  onClick={handleStop}
+                  disabled={pausing}
+                  className="px-3 py-1 rounded-lg border border-red-500/30 text-xs font-bold text-red-400 hover:text-red-300 hover:border-red-400/60 transition-all disabled:opacity-40 disabled:cursor-not-allowed"
+                >
+                  ⏹ {t("stop")}
+                </button>
+                <span className="text-neon-cyan font-mono text-2xl font-black">
+                  {downloadPercent}%
+                </span>
+              </div>
+              <p className="text-gray-400 font-mono text-[10px] uppercase tracking-widest">
+                {progress.phase === "paused"
+                  ? t("phase_paused")
+                  : progress.phase === "downloading"
+                    ? t("phase_downloading")
+                    : t("phase_discovery")}{" "}
+                {progress.current}/{progress.total} ·{" "}
+                {progress.eta >= 60
+                  ? t("eta_remaining").replace(
+                      "{n}",
+                      String(Math.round(progress.eta / 60)),
+                    )
+                  : progress.eta > 0
+                    ? `ETA ${formatEta(progress.eta)}`
+                    : "ETA —"}
+                {progress.speed > 0 && ` · ${formatSpeed(progress.speed)}`}
+              </p>
+              {backoff && backoff.until > Date.now() && (
+                <p className="text-amber-400 font-mono text-[10px] tracking-widest">
+                  ⏳{" "}
+                  {t("backoff_notice")
+                    .replace("{host}", backoff.host)
+                    .replace("{status}", String(backoff.status))
+                    .replace("{s}", String(Math.ceil(backoff.wait / 1000)))}
+                </p>
+              )}
             </div>
           </div>
           <div className="h-2 w-full bg-black/60 rounded-full overflow-hidden p-[1px] border border-white/5">
@@ -169,9 +647,38 @@ const DownloadView = () => {
               <div className="absolute inset-0 bg-[linear-gradient(90deg,transparent_0%,rgba(255,255,255,0.2)_50%,transparent_100%)] animate-shimmer"></div>
             </div>
           </div>
+          <WorkerPanel url={url} />
         </div>
       )}
 
+      {!isDownloading && budget && (
+        <p className="text-amber-400 font-mono text-xs px-1">
+          🛑{" "}
+          {(budget.limit === "pages"
+            ? t("budget_pages")
+            : t("budget_bytes")
+          )
+            .replace(
+              "{n}",
+              String(budget.limit === "pages" ? budget.pages : budget.bytes),
+            )
+            .replace(
+              "{max}",
+              String(
+                budget.limit === "pages" ? budget.maxPages : budget.maxBytes,
+              ),
+            )}
+        </p>
+      )}
+
+      {!isDownloading && coverage && (
+        <CoveragePanel
+          coverage={coverage}
+          onRecrawl={handleRecrawl}
+          disabled={isDownloading}
+        />
+      )}
+
       {/* Terminal Section */}
       <div className="flex-1 bg-black/90 rounded-2xl border border-white/10 p-4 font-mono text-sm overflow-hidden flex flex-col shadow-2xl relative group">
         <div className="absolute top-0 left-0 right-0 h-10 bg-white/5 flex items-center px-4 gap-2 border-b border-white/5 select-none z-10 transition-colors group-hover:bg-white/10">
@@ -8,12 +8,14 @@ import React, {
 // @ts-ignore
 import {
   GetDownloads,
+  RefreshLibrary,
   OpenFolder,
   LaunchSite,
   StopServer,
   AdaptPaths,
   DeleteSite,
   AnalyzeScripts,
+  ExportSite,
 } from "../../wailsjs/go/main/App";
 // @ts-ignore
 import { EventsOn } from "../../wailsjs/runtime";
@@ -26,6 +28,7 @@ interface Site {
   domain?: string;
   icon?: string;
   entryPath?: string;
+  scanning?: boolean;
 }
 
 interface Progress {
@@ -40,6 +43,13 @@ const normalizePath = (p: string | undefined | null) => {
   return p.replace(/\\/g, "/").toLowerCase().trim();
 };
 
+// Размер файла для списка скриптов
+const formatSize = (n: number) => {
+  if (n < 1024) return `${n} B`;
+  if (n < 1024 * 1024) return `${(n / 1024).toFixed(1)} KB`;
+  return `${(n / 1024 / 1024).toFixed(1)} MB`;
+};
+
 const SiteCard = React.memo(
   ({
     site,
@@ -48,11 +58,13 @@ const SiteCard = React.memo(
     isAdapting,
     isAnalyzing,
     isRunning,
+    exportProgress,
     t,
     onLaunch,
     onStop,
     onAnalyze,
     onAdapt,
+    onExport,
     onOpenFolder,
     onDelete,
   }: any) => {
@@ -61,6 +73,9 @@ const SiteCard = React.memo(
     const percent = progress
       ? Math.min(Math.round((progress.current / progress.total) * 100), 100)
       : 0;
+    const exportPercent = exportProgress?.total
+      ? Math.round((exportProgress.current / exportProgress.total) * 100)
+      : 0;
 
     return (
       <div
@@ -87,6 +102,16 @@ const SiteCard = React.memo(
               </button>
             </>
           )}
+          {isProcessed && (
+            <button
+              disabled={!!exportProgress}
+              title={t("export_zip")}
+              onClick={() => onExport(site.path)}
+              className="w-8 h-8 flex items-center justify-center bg-white/5 hover:bg-white/20 rounded-lg transition-all"
+            >
+              📦
+            </button>
+          )}
           <button
             onClick={() => onOpenFolder(site.path)}
             className="w-8 h-8 flex items-center justify-center bg-white/5 hover:bg-white/20 rounded-lg transition-all"
@@ -106,6 +131,8 @@ const SiteCard = React.memo(
           <div className="w-14 h-14 rounded-2xl bg-gradient-to-br from-white/5 to-white/10 flex items-center justify-center text-2xl border border-white/5 group-hover:border-neon-cyan/30 shrink-0 transition-colors">
             {site.icon ? (
               <img src={site.icon} alt="" className="w-8 h-8 object-contain" />
+            ) : site.scanning ? (
+              <span className="animate-pulse opacity-50">🌐</span>
             ) : (
               "🌐"
             )}
@@ -140,6 +167,22 @@ const SiteCard = React.memo(
           </div>
         )}
 
+        {/* Progress Bar for ZIP export */}
+        {exportProgress && (
+          <div className="mb-6 animate-fade-in">
+            <div className="flex justify-between text-[10px] font-mono text-neon-cyan mb-2 tracking-tighter">
+              <span className="uppercase">{t("exporting")}</span>
+              <span>{exportPercent}%</span>
+            </div>
+            <div className="h-1.5 w-full bg-black/40 rounded-full overflow-hidden border border-white/5">
+              <div
+                className="h-full bg-neon-cyan shadow-[0_0_10px_#00ffff] transition-all duration-500"
+                style={{ width: `${exportPercent}%` }}
+              ></div>
+            </div>
+          </div>
+        )}
+
         {/* Action Button */}
         <button
           disabled={isAdapting}
@@ -199,8 +242,10 @@ const LibraryGrid = () => {
   const [isAnalyzingMap, setIsAnalyzingMap] = useState<Record<string, boolean>>(
     {},
   );
+  const [exportMap, setExportMap] = useState<Record<string, Progress>>({});
 
-  const fetchSitesRef = useRef<(sl?: boolean) => Promise<void>>();
+  const fetchSitesRef =
+    useRef<(sl?: boolean, force?: boolean) => Promise<void>>();
 
   // Нормализуем текущий запущенный путь для сравнения
   const normalizedServingPath = useMemo(
@@ -209,10 +254,11 @@ const LibraryGrid = () => {
   );
 
   const fetchSites = useCallback(
-    async (showLoading = true) => {
+    async (showLoading = true, force = false) => {
       if (showLoading) setLoading(true);
       try {
-        const res = await GetDownloads();
+        // force drops the backend icon/entry cache (manual refresh)
+        const res = force ? await RefreshLibrary() : await GetDownloads();
         setSites(res || []);
       } catch (e) {
         addToast(t("fetch_failed"), "error");
@@ -230,6 +276,10 @@ const LibraryGrid = () => {
     const cleanupRefresh = EventsOn("library:refresh", () =>
       fetchSitesRef.current?.(false),
     );
+    // Background scan finished for one folder
+    const cleanupItem = EventsOn("library:item-updated", () =>
+      fetchSitesRef.current?.(false),
+    );
     const cleanupProgress = EventsOn("adaptation:progress", (data: any) => {
       const p = normalizePath(data.path);
       setIsAnalyzingMap((prev) => ({ ...prev, [p]: false }));
@@ -242,6 +292,21 @@ const LibraryGrid = () => {
         },
       }));
     });
+    const cleanupExport = EventsOn("export:progress", (data: any) => {
+      const p = normalizePath(data.path);
+      setExportMap((prev) =>
+        prev[p]
+          ? {
+              ...prev,
+              [p]: {
+                current: data.current,
+                total: data.total,
+                completed: data.current >= data.total,
+              },
+            }
+          : prev,
+      );
+    });
     const cleanupAnalyzing = EventsOn("adaptation:analyzing", (p: string) =>
       setIsAnalyzingMap((prev) => ({ ...prev, [normalizePath(p)]: true })),
     );
@@ -265,7 +330,9 @@ const LibraryGrid = () => {
 
     return () => {
       cleanupRefresh();
+      cleanupItem();
       cleanupProgress();
+      cleanupExport();
       cleanupAnalyzing();
       cleanupStart();
       cleanupDone();
@@ -300,7 +367,9 @@ const LibraryGrid = () => {
         message: `${t("adapt_info")} (${name})`,
         type: "info",
         confirmLabel: t("confirm"),
-        onConfirm: () => AdaptPaths(path, []),
+        presetPicker: true,
+        onConfirm: (_, processing) =>
+          AdaptPaths(path, [], processing?.preset ?? "", processing?.overrides ?? {}),
       });
     },
     [t, showModal],
@@ -315,13 +384,25 @@ const LibraryGrid = () => {
           title: `🔬 ${name}`,
           message: "Select scripts to remove:",
           type: "selection",
-          options: scripts?.map((s: string) => ({
-            id: s,
-            label: s.split("/").pop() || s,
+          options: scripts?.map((s: any) => ({
+            id: s.id,
+            label:
+              (s.tracker ? `📡 ${s.tracker}: ` : "") +
+              (s.inline ? `<script> ${s.preview || ""}` : s.src.split("/").pop() || s.src),
+            detail: [
+              s.inline ? "inline" : s.external ? "external" : s.exists ? "local" : "missing",
+              s.size ? formatSize(s.size) : "",
+              `${s.pages} page${s.pages === 1 ? "" : "s"}`,
+              s.inline ? s.hash : s.src,
+            ]
+              .filter(Boolean)
+              .join(" · "),
           })),
           confirmLabel: "Apply",
-          onConfirm: (selected) => {
-            if (selected) AdaptPaths(path, selected);
+          presetPicker: true,
+          onConfirm: (selected, processing) => {
+            if (selected)
+              AdaptPaths(path, selected, processing?.preset ?? "", processing?.overrides ?? {});
           },
         });
       } catch {
@@ -331,6 +412,40 @@ const LibraryGrid = () => {
     [addToast, showModal],
   );
 
+  const handleExport = useCallback(
+    async (path: string) => {
+      const p = normalizePath(path);
+      setExportMap((prev) => ({
+        ...prev,
+        [p]: { current: 0, total: 0, completed: false },
+      }));
+      try {
+        const res: string = await ExportSite(path);
+        if (res.startsWith("Error")) {
+          addToast(res, "error");
+          return;
+        }
+        // The binding returns the archive path: offer to reveal its folder
+        showModal({
+          title: t("export_done"),
+          message: res,
+          type: "info",
+          confirmLabel: t("reveal_in_folder"),
+          onConfirm: () => OpenFolder(res.replace(/[\\/][^\\/]*$/, "")),
+        });
+      } catch {
+        addToast("Error", "error");
+      } finally {
+        setExportMap((prev) => {
+          const n = { ...prev };
+          delete n[p];
+          return n;
+        });
+      }
+    },
+    [t, addToast, showModal],
+  );
+
   const handleDelete = useCallback(
     (path: string, name: string) => {
       showModal({
@@ -365,7 +480,7 @@ const LibraryGrid = () => {
       <div className="flex items-center justify-between mb-8">
         <h2 className="text-3xl font-extrabold text-white">{t("library")}</h2>
         <button
-          onClick={() => fetchSites()}
+          onClick={() => fetchSites(true, true)}
           className="p-2 bg-white/5 rounded-xl hover:bg-neon-cyan/20"
         >
           🔄
@@ -394,11 +509,13 @@ const LibraryGrid = () => {
                 isAdapting={!!isAdaptingMap[sitePath]}
                 isAnalyzing={!!isAnalyzingMap[sitePath]}
                 isRunning={isRunning}
+                exportProgress={exportMap[sitePath]}
                 t={t}
                 onLaunch={handleLaunch}
                 onStop={handleStop}
                 onAnalyze={handleAnalyze}
                 onAdapt={handleAdaptTrigger}
+                onExport={handleExport}
                 onOpenFolder={handleOpenFolder}
                 onDelete={handleDelete}
               />
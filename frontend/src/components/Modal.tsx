@@ -1,16 +1,21 @@
 import React, { useState, useEffect } from 'react';
 import { useApp } from '../context/AppContext';
 import { useTranslation } from '../i18n';
+import PresetPicker, { ProcessingChoice, defaultProcessing } from './PresetPicker';
 
 const Modal = React.memo(() => {
     const { modal, hideModal } = useApp();
     const { t } = useTranslation();
     const [selectedItems, setSelectedItems] = useState<string[]>([]);
+    const [processing, setProcessing] = useState<ProcessingChoice>(defaultProcessing);
 
     useEffect(() => {
         if (modal?.type === 'selection') {
             setSelectedItems([]);
         }
+        if (modal?.presetPicker) {
+            setProcessing(defaultProcessing());
+        }
     }, [modal]);
 
     if (!modal) return null;
@@ -30,7 +35,7 @@ const Modal = React.memo(() => {
                 onClick={hideModal}
             ></div>
 
-            <div className={`relative w-full ${isSelection ? 'max-w-2xl' : 'max-w-md'} bg-graphite-800/80 backdrop-blur-2xl border border-white/10 rounded-[32px] p-8 shadow-[0_30px_60px_rgba(0,0,0,0.6)] animate-modal-in overflow-hidden group`}>
+            <div className={`relative w-full ${isSelection ? 'max-w-2xl' : modal.presetPicker ? 'max-w-lg' : 'max-w-md'} bg-graphite-800/80 backdrop-blur-2xl border border-white/10 rounded-[32px] p-8 shadow-[0_30px_60px_rgba(0,0,0,0.6)] animate-modal-in overflow-hidden group`}>
                 <div className={`absolute -top-24 -right-24 w-48 h-48 rounded-full blur-[80px] opacity-20 pointer-events-none ${modal.type === 'danger' ? 'bg-red-500' : 'bg-neon-cyan'
                     }`}></div>
 
@@ -46,6 +51,10 @@ const Modal = React.memo(() => {
                     {modal.message}
                 </p>
 
+                {modal.presetPicker && (
+                    <PresetPicker value={processing} onChange={setProcessing} />
+                )}
+
                 {isSelection && modal.options && (
                     <div className="max-h-[40vh] overflow-y-auto mb-8 pr-2 space-y-2 scrollbar-custom">
                         {modal.options.map(opt => (
@@ -70,7 +79,7 @@ const Modal = React.memo(() => {
                                 />
                                 <div className="flex flex-col min-w-0">
                                     <span className="font-medium text-white truncate">{opt.label}</span>
-                                    <span className="text-[10px] text-gray-500 font-mono truncate">{opt.id}</span>
+                                    <span className="text-[10px] text-gray-500 font-mono truncate">{opt.detail || opt.id}</span>
                                 </div>
                             </label>
                         ))}
@@ -85,7 +94,7 @@ const Modal = React.memo(() => {
                         {modal.cancelLabel || t('cancel')}
                     </button>
                     <button
-                        onClick={() => { modal.onConfirm(isSelection ? selectedItems : undefined); hideModal(); }}
+                        onClick={() => { modal.onConfirm(isSelection ? selectedItems : undefined, modal.presetPicker ? processing : undefined); hideModal(); }}
                         className={`flex-1 px-6 py-4 rounded-2xl font-bold text-white transition-all shadow-xl active:scale-95 ${modal.type === 'danger' ? 'bg-red-500 hover:bg-red-600 shadow-red-500/20' : 'bg-neon-cyan hover:bg-neon-cyan/80 shadow-neon-cyan/20'
                             }`}
                     >
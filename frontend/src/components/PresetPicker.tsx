@@ -0,0 +1,117 @@
+import React from 'react';
+import { useTranslation, i18n } from '../i18n';
+import { GetPresets } from '../../wailsjs/go/main/App';
+import { proccesor } from '../../wailsjs/go/models';
+
+// Boolean options of a processing preset, in the order they are shown
+export const presetFlags = [
+    'keepExternal',
+    'removeMissing',
+    'convertPhp',
+    'stripCsp',
+    'placeholders',
+    'minify',
+    'upgradeHttp',
+    'verifyUpgrades',
+    'protocolRelative',
+    'stripAssetQueries',
+    'generateIndexes',
+    'stripHandlers',
+] as const;
+
+export type PresetFlag = typeof presetFlags[number];
+
+export const presetFlagLabel = (flag: PresetFlag) => `preset_${flag}` as keyof typeof i18n.en;
+
+export const linkStyles = ['relative', 'absolute'] as const;
+
+// What AdaptPaths gets: a preset name ('' = default) and overrides on top of it
+export interface ProcessingChoice {
+    preset: string;
+    overrides: proccesor.PresetOverrides;
+}
+
+export const defaultProcessing = (): ProcessingChoice => ({ preset: '', overrides: {} });
+
+const selectClass = 'w-full bg-black/40 border border-white/10 rounded-xl px-3 py-2 text-white text-sm focus:border-neon-cyan/50 focus:outline-none';
+
+const PresetPicker = React.memo(({ value, onChange }: { value: ProcessingChoice; onChange: (v: ProcessingChoice) => void }) => {
+    const { t } = useTranslation();
+    const [presets, setPresets] = React.useState<proccesor.ProcessingPreset[]>([]);
+    const [showOverrides, setShowOverrides] = React.useState(false);
+
+    React.useEffect(() => {
+        GetPresets().then((list) => setPresets(list || [])).catch(() => {});
+    }, []);
+
+    // Empty value keeps the preset's own setting
+    const setOverride = (key: keyof proccesor.PresetOverrides, raw: string) => {
+        const overrides: any = { ...value.overrides };
+        if (raw === '') delete overrides[key];
+        else overrides[key] = key === 'linkStyle' ? raw : raw === 'on';
+        onChange({ ...value, overrides });
+    };
+    const overrideValue = (key: PresetFlag) => {
+        const v = value.overrides[key];
+        return v === undefined ? '' : v ? 'on' : 'off';
+    };
+
+    return (
+        <div className="mb-6 space-y-3">
+            <div>
+                <label className="block text-gray-400 text-sm mb-2">{t('preset')}</label>
+                <select
+                    value={value.preset}
+                    onChange={(e) => onChange({ ...value, preset: e.target.value })}
+                    className={selectClass}
+                >
+                    <option value="">{t('preset_default')}</option>
+                    {presets.map((p) => (
+                        <option key={p.name} value={p.name}>{p.name}</option>
+                    ))}
+                </select>
+            </div>
+
+            <button
+                type="button"
+                onClick={() => setShowOverrides((v) => !v)}
+                className="text-xs text-neon-cyan hover:underline"
+            >
+                {showOverrides ? '▾' : '▸'} {t('preset_overrides')}
+                {Object.keys(value.overrides).length > 0 && ` (${Object.keys(value.overrides).length})`}
+            </button>
+
+            {showOverrides && (
+                <div className="grid grid-cols-2 gap-2 max-h-[30vh] overflow-y-auto pr-2 scrollbar-custom">
+                    <label className="text-gray-400 text-xs self-center">{t('preset_linkStyle')}</label>
+                    <select
+                        value={value.overrides.linkStyle ?? ''}
+                        onChange={(e) => setOverride('linkStyle', e.target.value)}
+                        className={selectClass}
+                    >
+                        <option value="">{t('preset_keep')}</option>
+                        {linkStyles.map((s) => (
+                            <option key={s} value={s}>{s}</option>
+                        ))}
+                    </select>
+                    {presetFlags.map((flag) => (
+                        <React.Fragment key={flag}>
+                            <label className="text-gray-400 text-xs self-center">{t(presetFlagLabel(flag))}</label>
+                            <select
+                                value={overrideValue(flag)}
+                                onChange={(e) => setOverride(flag, e.target.value)}
+                                className={selectClass}
+                            >
+                                <option value="">{t('preset_keep')}</option>
+                                <option value="on">{t('enabled')}</option>
+                                <option value="off">{t('disabled')}</option>
+                            </select>
+                        </React.Fragment>
+                    ))}
+                </div>
+            )}
+        </div>
+    );
+});
+
+export default PresetPicker;
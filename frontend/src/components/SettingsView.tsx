@@ -1,6 +1,9 @@
 import React from 'react';
 import { useTranslation } from '../i18n';
 import { useApp } from '../context/AppContext';
+import { GetControlAPI, SetControlAPI, RegenerateControlToken, GetPresets, GetCustomPresets, SavePreset, DeletePreset } from '../../wailsjs/go/main/App';
+import { main, proccesor } from '../../wailsjs/go/models';
+import { presetFlags, presetFlagLabel, linkStyles } from './PresetPicker';
 
 const SettingsView = React.memo(() => {
     const { t, lang, setLang } = useTranslation();
@@ -16,6 +19,74 @@ const SettingsView = React.memo(() => {
         addToast(newLang === 'en' ? 'Language changed to English' : 'Язык изменен на Русский', 'info');
     }, [setLang, addToast]);
 
+    const [control, setControl] = React.useState<main.ControlAPIStatus | null>(null);
+
+    React.useEffect(() => {
+        GetControlAPI().then(setControl).catch(() => {});
+    }, []);
+
+    const applyControl = React.useCallback((status: main.ControlAPIStatus) => {
+        setControl(status);
+        if (status.error) addToast(`${t('error')}: ${status.error}`, 'error');
+    }, [addToast, t]);
+
+    const handleControlToggle = React.useCallback(() => {
+        SetControlAPI(!control?.enabled).then(applyControl);
+    }, [control, applyControl]);
+
+    const handleRegenerateToken = React.useCallback(() => {
+        RegenerateControlToken().then((status) => {
+            applyControl(status);
+            if (!status.error) addToast(t('control_token_regenerated'), 'success');
+        });
+    }, [applyControl, addToast, t]);
+
+    // Processing presets: built-in ones are read-only, custom ones live in settings.json
+    const [presets, setPresets] = React.useState<proccesor.ProcessingPreset[]>([]);
+    const [customNames, setCustomNames] = React.useState<string[]>([]);
+    const [draft, setDraft] = React.useState<proccesor.ProcessingPreset | null>(null);
+
+    const loadPresets = React.useCallback(() => {
+        Promise.all([GetPresets(), GetCustomPresets()]).then(([all, custom]) => {
+            setPresets(all || []);
+            setCustomNames((custom || []).map((p) => p.name));
+        }).catch(() => {});
+    }, []);
+
+    React.useEffect(() => {
+        loadPresets();
+    }, [loadPresets]);
+
+    const handleNewPreset = React.useCallback((base?: proccesor.ProcessingPreset) => {
+        setDraft(proccesor.ProcessingPreset.createFrom({
+            linkStyle: 'relative',
+            upgradeHosts: [],
+            keepQueryParams: [],
+            ...base,
+            name: base ? `${base.name} (${t('preset_copy')})` : '',
+        }));
+    }, [t]);
+
+    const handleSavePreset = React.useCallback(() => {
+        if (!draft) return;
+        SavePreset(draft).then((res) => {
+            if (res !== 'Saved') {
+                addToast(res, 'error');
+                return;
+            }
+            addToast(`${t('preset_saved')}: ${draft.name}`, 'success');
+            setDraft(null);
+            loadPresets();
+        });
+    }, [draft, addToast, loadPresets, t]);
+
+    const handleDeletePreset = React.useCallback((name: string) => {
+        DeletePreset(name).then(() => {
+            addToast(`${t('preset_deleted')}: ${name}`, 'info');
+            loadPresets();
+        });
+    }, [addToast, loadPresets, t]);
+
     return (
         <div className="h-full flex flex-col gap-6 overflow-y-auto pr-4 scrollbar-custom">
             {/* Appearance */}
@@ -114,6 +185,166 @@ const SettingsView = React.memo(() => {
                 </div>
             </div>
 
+            {/* Processing presets */}
+            <div className="bg-graphite-800/40 backdrop-blur-md rounded-2xl p-6 border border-white/5 shadow-xl">
+                <div className="flex items-center justify-between mb-6 border-b border-white/5 pb-4">
+                    <h2 className="text-xl font-bold text-white">{t('presets')}</h2>
+                    <button
+                        onClick={() => handleNewPreset()}
+                        className="px-4 py-2 rounded-xl border border-white/10 text-gray-300 text-sm hover:bg-white/5 hover:border-white/20 transition-all"
+                    >
+                        + {t('preset_new')}
+                    </button>
+                </div>
+
+                <div className="space-y-2">
+                    {presets.map((p) => {
+                        const custom = customNames.includes(p.name);
+                        return (
+                            <div key={p.name} className="flex items-center justify-between gap-4 px-4 py-3 rounded-xl bg-white/5 border border-white/5">
+                                <div className="min-w-0">
+                                    <span className="text-white font-medium">{p.name}</span>
+                                    <span className="ml-3 text-gray-500 text-xs font-mono">{p.linkStyle}</span>
+                                    {!custom && <span className="ml-3 text-[10px] uppercase text-gray-500">{t('preset_builtin')}</span>}
+                                </div>
+                                <div className="flex gap-2 shrink-0">
+                                    <button
+                                        title={t('preset_copy')}
+                                        onClick={() => handleNewPreset(p)}
+                                        className="w-8 h-8 flex items-center justify-center bg-white/5 hover:bg-white/20 rounded-lg transition-all"
+                                    >
+                                        ⧉
+                                    </button>
+                                    {custom && (
+                                        <>
+                                            <button
+                                                title={t('preset_edit')}
+                                                onClick={() => setDraft(proccesor.ProcessingPreset.createFrom(p))}
+                                                className="w-8 h-8 flex items-center justify-center bg-white/5 hover:bg-white/20 rounded-lg transition-all"
+                                            >
+                                                ✏️
+                                            </button>
+                                            <button
+                                                title={t('delete')}
+                                                onClick={() => handleDeletePreset(p.name)}
+                                                className="w-8 h-8 flex items-center justify-center bg-red-500/10 hover:bg-red-500 text-red-500 hover:text-white rounded-lg transition-all"
+                                            >
+                                                🗑️
+                                            </button>
+                                        </>
+                                    )}
+                                </div>
+                            </div>
+                        );
+                    })}
+                </div>
+
+                {draft && (
+                    <div className="mt-6 space-y-4 border-t border-white/5 pt-6">
+                        <div>
+                            <label className="block text-gray-400 text-sm mb-2">{t('preset_name')}</label>
+                            <input
+                                type="text"
+                                value={draft.name}
+                                onChange={(e) => setDraft(proccesor.ProcessingPreset.createFrom({ ...draft, name: e.target.value }))}
+                                className="w-full bg-black/40 border border-white/10 rounded-xl px-4 py-3 text-white text-sm focus:border-neon-cyan/50 focus:outline-none focus:ring-1 focus:ring-neon-cyan/20 transition-all"
+                            />
+                        </div>
+                        <div>
+                            <label className="block text-gray-400 text-sm mb-2">{t('preset_linkStyle')}</label>
+                            <select
+                                value={draft.linkStyle}
+                                onChange={(e) => setDraft(proccesor.ProcessingPreset.createFrom({ ...draft, linkStyle: e.target.value }))}
+                                className="w-full bg-black/40 border border-white/10 rounded-xl px-4 py-3 text-white text-sm focus:border-neon-cyan/50 focus:outline-none"
+                            >
+                                {linkStyles.map((s) => (
+                                    <option key={s} value={s}>{s}</option>
+                                ))}
+                            </select>
+                        </div>
+                        <div className="grid grid-cols-1 md:grid-cols-2 gap-2">
+                            {presetFlags.map((flag) => (
+                                <label key={flag} className="flex items-center gap-3 text-gray-300 text-sm cursor-pointer">
+                                    <input
+                                        type="checkbox"
+                                        checked={!!draft[flag]}
+                                        onChange={(e) => setDraft(proccesor.ProcessingPreset.createFrom({ ...draft, [flag]: e.target.checked }))}
+                                        className="accent-neon-cyan"
+                                    />
+                                    {t(presetFlagLabel(flag))}
+                                </label>
+                            ))}
+                        </div>
+                        <div className="flex gap-3">
+                            <button
+                                onClick={() => setDraft(null)}
+                                className="flex-1 py-3 rounded-xl border border-white/10 text-gray-300 hover:bg-white/5 transition-all"
+                            >
+                                {t('cancel')}
+                            </button>
+                            <button
+                                disabled={!draft.name.trim()}
+                                onClick={handleSavePreset}
+                                className="flex-1 py-3 rounded-xl font-bold bg-neon-cyan/10 border border-neon-cyan text-neon-cyan hover:bg-neon-cyan hover:text-white transition-all disabled:opacity-40"
+                            >
+                                {t('save')}
+                            </button>
+                        </div>
+                    </div>
+                )}
+            </div>
+
+            {/* Control API */}
+            <div className="bg-graphite-800/40 backdrop-blur-md rounded-2xl p-6 border border-white/5 shadow-xl">
+                <h2 className="text-xl font-bold mb-6 text-white border-b border-white/5 pb-4">{t('control_api')}</h2>
+
+                <div className="space-y-6">
+                    <div className="flex items-center justify-between gap-4">
+                        <p className="text-gray-400 text-sm">{t('control_api_info')}</p>
+                        <button
+                            onClick={handleControlToggle}
+                            className={`shrink-0 px-4 py-2 rounded-xl font-bold border transition-all ${control?.enabled ? 'bg-neon-cyan/10 border-neon-cyan text-neon-cyan' : 'bg-transparent border-white/10 text-gray-400 hover:bg-white/5 hover:border-white/20'}`}
+                        >
+                            {control?.enabled ? t('enabled') : t('disabled')}
+                        </button>
+                    </div>
+
+                    {control?.enabled && control.token && (
+                        <>
+                            <div>
+                                <label className="block text-gray-400 text-sm mb-2">{t('control_token')}</label>
+                                <div className="flex gap-2">
+                                    <input
+                                        type="text" readOnly
+                                        value={control.token}
+                                        className="flex-1 bg-black/40 border border-white/10 rounded-xl px-4 py-3 text-white font-mono text-sm focus:outline-none"
+                                    />
+                                    <button
+                                        onClick={handleRegenerateToken}
+                                        className="px-4 rounded-xl border border-white/10 text-gray-300 hover:bg-white/5 hover:border-white/20 transition-all"
+                                    >
+                                        {t('regenerate')}
+                                    </button>
+                                </div>
+                                <p className="text-gray-600 text-xs mt-2 font-mono">{control.url}</p>
+                            </div>
+
+                            <div>
+                                <label className="block text-gray-400 text-sm mb-2">{t('bookmarklet')}</label>
+                                <a
+                                    href={control.bookmarklet}
+                                    onClick={(e) => e.preventDefault()}
+                                    className="inline-block px-4 py-2 rounded-xl border border-neon-cyan/50 text-neon-cyan text-sm cursor-move"
+                                >
+                                    SiteCloner ⤓
+                                </a>
+                                <p className="text-gray-600 text-xs mt-2">{t('bookmarklet_info')}</p>
+                            </div>
+                        </>
+                    )}
+                </div>
+            </div>
+
             <div className="text-center text-gray-600 text-xs mt-4">
                 SiteCloner v2.1.0 • Built with Wails & Vite 7
             </div>
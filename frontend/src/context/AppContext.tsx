@@ -1,6 +1,7 @@
 import React, { createContext, useContext, useState, ReactNode, useEffect, useCallback, useMemo } from 'react';
 // @ts-ignore
 import { EventsOn } from "../../wailsjs/runtime";
+import type { ProcessingChoice } from '../components/PresetPicker';
 
 type Theme = 'graphite' | 'ocean' | 'matrix';
 type Lang = 'en' | 'ru';
@@ -22,9 +23,10 @@ interface ModalConfig {
     message: string;
     confirmLabel?: string;
     cancelLabel?: string;
-    onConfirm: (selected?: string[]) => void;
+    onConfirm: (selected?: string[], processing?: ProcessingChoice) => void;
     type?: 'danger' | 'info' | 'selection';
-    options?: { id: string, label: string }[];
+    options?: { id: string, label: string, detail?: string }[];
+    presetPicker?: boolean; // Ask for a processing preset and overrides
 }
 
 interface AppContextType {
@@ -120,6 +122,15 @@ export const AppProvider = ({ children }: { children: ReactNode }) => {
             }
         });
 
+        // Пачки строк от загрузчика (не чаще нескольких раз в секунду)
+        const cleanupLogs = EventsOn("download:logs", (msgs: string[]) => {
+            if (!Array.isArray(msgs)) return;
+            logBuffer.push(...msgs);
+            if (!throttleTimer) {
+                throttleTimer = setTimeout(flushLogs, 50);
+            }
+        });
+
         const cleanupStart = EventsOn("download:start", () => {
             setIsDownloading(true);
             setDownloadLogs([]);
@@ -141,6 +152,7 @@ export const AppProvider = ({ children }: { children: ReactNode }) => {
 
         return () => {
             cleanupLog();
+            cleanupLogs();
             cleanupStart();
             cleanupDone();
             cleanupServerStarted();
@@ -8,10 +8,41 @@ export const i18n = {
         server: "Server",
         settings: "Settings",
         new_download: "New Download",
-        url_placeholder: "https://example.com",
+        url_placeholder: "https://example.com https://example.org/docs/",
+        crawl_options: "Crawl options",
+        request_headers: "Request headers",
+        ua_preset: "Browser profile (User-Agent and matching headers)",
+        ua_default: "Default",
+        include_patterns: "Only pages matching (one per line)",
+        exclude_patterns: "Skip URLs matching (one per line)",
+        block_substrings: "Never download URLs containing (one per line)",
+        single_page: "Only this page with its images, CSS and fonts",
+        include_subdomains: "Include subdomains (blog.example.com)",
+        parse_javascript: "Find URLs in JavaScript and JSON (may grow the crawl)",
+        external_assets: "Download CSS, JS, fonts and images from CDNs",
+        extra_domains: "Only from these hosts (one per line, empty = any)",
         start: "Start",
         processing: "Processing...",
         waiting: "Waiting for commands...",
+        phase_discovery: "Discovering",
+        phase_downloading: "Downloading",
+        phase_paused: "Paused",
+        eta_remaining: "about {n} min remaining",
+        backoff_notice: "{host} answered {status}, slowing down for {s}s",
+        budget_pages:
+          "Stopped at the file limit ({n} of {max}); resume with a higher limit to continue",
+        budget_bytes:
+          "Stopped at the size limit ({n} of {max} bytes); resume with a higher limit to continue",
+        pause: "Pause",
+        resume: "Resume",
+        stop: "Stop",
+        jobs: "Jobs",
+        job_queued: "queued",
+        job_running: "running",
+        job_stopping: "stopping",
+        job_paused: "paused",
+        sitemap_coverage: "Sitemap coverage",
+        download_missed: "Download these too",
         terminal: "TERMINAL",
         worker_pool: "worker-pool",
         version: "Version",
@@ -47,9 +78,48 @@ export const i18n = {
         delete: "Delete",
         delete_confirm: "Are you sure you want to delete this site?",
         deleted: "Site deleted successfully",
+        export_zip: "Export as ZIP",
+        exporting: "Packing archive...",
+        export_done: "Archive ready",
+        reveal_in_folder: "Reveal in folder",
         cancel: "Cancel",
         confirm: "Confirm",
-        system: "System"
+        preset: "Processing preset",
+        preset_default: "Default (Audit copy)",
+        preset_overrides: "Override preset options",
+        preset_keep: "As in preset",
+        preset_linkStyle: "Link style",
+        preset_keepExternal: "Keep external links",
+        preset_removeMissing: "Remove links to missing files",
+        preset_convertPhp: "Convert .php pages to .html",
+        preset_stripCsp: "Strip Content-Security-Policy",
+        preset_placeholders: "Placeholders for missing images",
+        preset_minify: "Minify HTML, CSS and JS",
+        preset_upgradeHttp: "Upgrade http:// links to https://",
+        preset_verifyUpgrades: "Check hosts before upgrading",
+        preset_protocolRelative: "Write upgraded links as //host",
+        preset_stripAssetQueries: "Strip cache busters (?v=) from local assets",
+        preset_generateIndexes: "Create index pages for folders without one",
+        preset_stripHandlers: "Remove on* handlers still pointing at the site",
+        presets: "Processing Presets",
+        preset_builtin: "built-in",
+        preset_new: "New preset",
+        preset_copy: "copy",
+        preset_edit: "Edit",
+        preset_name: "Preset name",
+        preset_saved: "Preset saved",
+        preset_deleted: "Preset deleted",
+        save: "Save",
+        system: "System",
+        control_api: "Browser Integration",
+        control_api_info: "Local API on 127.0.0.1 for the bookmarklet and extensions. Requests need the token in the Authorization header.",
+        enabled: "Enabled",
+        disabled: "Disabled",
+        control_token: "Access Token",
+        regenerate: "Regenerate",
+        control_token_regenerated: "Token regenerated, update your bookmarklet",
+        bookmarklet: "Bookmarklet",
+        bookmarklet_info: "Drag the button to the bookmarks bar and click it on any page to clone it. It only starts downloads and works for 24 hours or until the app restarts."
     },
     ru: {
         download: "Загрузка",
@@ -57,10 +127,41 @@ export const i18n = {
         server: "Сервер",
         settings: "Настройки",
         new_download: "Новая загрузка",
-        url_placeholder: "https://example.com",
+        url_placeholder: "https://example.com https://example.org/docs/",
+        crawl_options: "Параметры обхода",
+        request_headers: "Заголовки запросов",
+        ua_preset: "Профиль браузера (User-Agent и его заголовки)",
+        ua_default: "По умолчанию",
+        include_patterns: "Только страницы по шаблону (по одному в строке)",
+        exclude_patterns: "Пропускать URL по шаблону (по одному в строке)",
+        block_substrings: "Не скачивать URL, содержащие (по одному в строке)",
+        single_page: "Только эта страница с картинками, CSS и шрифтами",
+        include_subdomains: "Включая поддомены (blog.example.com)",
+        parse_javascript: "Искать ссылки в JavaScript и JSON (обход может вырасти)",
+        external_assets: "Качать CSS, JS, шрифты и картинки с CDN",
+        extra_domains: "Только с этих хостов (по одному в строке, пусто — с любых)",
         start: "Запуск",
         processing: "Загрузка...",
         waiting: "Ожидание задач...",
+        phase_discovery: "Обход",
+        phase_downloading: "Докачка",
+        phase_paused: "Пауза",
+        eta_remaining: "осталось примерно {n} мин",
+        backoff_notice: "{host} ответил {status}, пауза {s} с",
+        budget_pages:
+          "Остановлено по лимиту файлов ({n} из {max}); продолжите с большим лимитом",
+        budget_bytes:
+          "Остановлено по лимиту объёма ({n} из {max} байт); продолжите с большим лимитом",
+        pause: "Пауза",
+        resume: "Продолжить",
+        stop: "Стоп",
+        jobs: "Задачи",
+        job_queued: "в очереди",
+        job_running: "идёт",
+        job_stopping: "остановка",
+        job_paused: "пауза",
+        sitemap_coverage: "Покрытие sitemap",
+        download_missed: "Скачать и эти",
         terminal: "ТЕРМИНАЛ",
         worker_pool: "поток-пул",
         version: "Версия",
@@ -96,9 +197,48 @@ export const i18n = {
         delete: "Удалить",
         delete_confirm: "Вы уверены, что хотите удалить этот сайт?",
         deleted: "Сайт успешно удален",
+        export_zip: "Экспорт в ZIP",
+        exporting: "Упаковка архива...",
+        export_done: "Архив готов",
+        reveal_in_folder: "Показать в папке",
         cancel: "Отмена",
         confirm: "Да",
-        system: "Система"
+        preset: "Пресет обработки",
+        preset_default: "По умолчанию (Audit copy)",
+        preset_overrides: "Изменить параметры пресета",
+        preset_keep: "Как в пресете",
+        preset_linkStyle: "Вид ссылок",
+        preset_keepExternal: "Оставлять внешние ссылки",
+        preset_removeMissing: "Убирать ссылки на отсутствующие файлы",
+        preset_convertPhp: "Переименовывать .php в .html",
+        preset_stripCsp: "Удалять Content-Security-Policy",
+        preset_placeholders: "Заглушки вместо отсутствующих картинок",
+        preset_minify: "Минифицировать HTML, CSS и JS",
+        preset_upgradeHttp: "Переводить ссылки http:// на https://",
+        preset_verifyUpgrades: "Проверять хосты перед переводом",
+        preset_protocolRelative: "Записывать обновлённые ссылки как //host",
+        preset_stripAssetQueries: "Убирать cache busters (?v=) у локальной статики",
+        preset_generateIndexes: "Создавать index.html в папках без своей страницы",
+        preset_stripHandlers: "Удалять on*-обработчики со ссылками на сайт",
+        presets: "Пресеты обработки",
+        preset_builtin: "встроенный",
+        preset_new: "Новый пресет",
+        preset_copy: "копия",
+        preset_edit: "Изменить",
+        preset_name: "Название пресета",
+        preset_saved: "Пресет сохранён",
+        preset_deleted: "Пресет удалён",
+        save: "Сохранить",
+        system: "Система",
+        control_api: "Интеграция с браузером",
+        control_api_info: "Локальный API на 127.0.0.1 для букмарклета и расширений. Запросы требуют токен в заголовке Authorization.",
+        enabled: "Включено",
+        disabled: "Выключено",
+        control_token: "Токен доступа",
+        regenerate: "Сменить",
+        control_token_regenerated: "Токен обновлён, обновите букмарклет",
+        bookmarklet: "Букмарклет",
+        bookmarklet_info: "Перетащите кнопку на панель закладок и нажмите её на любой странице, чтобы скачать её. Она только запускает загрузки и работает 24 часа или до перезапуска приложения."
     }
 };
 
@@ -1,23 +1,68 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {main} from '../models';
+import {downloader} from '../models';
+import {proccesor} from '../models';
+import {server} from '../models';
 
-export function AdaptPaths(arg1:string,arg2:Array<string>):Promise<string>;
+export function AdaptPaths(arg1:string,arg2:Array<string>,arg3:string,arg4:proccesor.PresetOverrides):Promise<string>;
 
-export function AnalyzeScripts(arg1:string):Promise<Array<string>>;
+export function AnalyzeScripts(arg1:string):Promise<Array<proccesor.ScriptInfo>>;
+
+export function DeletePreset(arg1:string):Promise<string>;
 
 export function DeleteSite(arg1:string):Promise<string>;
 
-export function DownloadSite(arg1:string,arg2:string):Promise<string>;
+export function DownloadSite(arg1:Array<string>,arg2:string,arg3:main.DownloadOptions):Promise<string>;
+
+export function ExportDockerBundle(arg1:string,arg2:string):Promise<string>;
+
+export function ExportSite(arg1:string):Promise<string>;
+
+export function GetControlAPI():Promise<main.ControlAPIStatus>;
+
+export function GetCustomPresets():Promise<Array<proccesor.ProcessingPreset>>;
 
 export function GetDownloads():Promise<Array<main.SiteMeta>>;
 
+export function GetOutboundLinks(arg1:string):Promise<Array<proccesor.OutboundLink>>;
+
+export function GetPresets():Promise<Array<proccesor.ProcessingPreset>>;
+
+export function GetServerStatus():Promise<server.Health>;
+
+export function GetWorkerStatus(arg1:string):Promise<Array<downloader.WorkerState>>;
+
 export function LaunchSite(arg1:string):Promise<string>;
 
+export function ListJobs():Promise<Array<downloader.JobInfo>>;
+
 export function OpenFolder(arg1:string):Promise<void>;
 
+export function PauseDownload(arg1:string):Promise<string>;
+
+export function RecrawlURLs(arg1:string,arg2:Array<string>):Promise<string>;
+
+export function RefreshLibrary():Promise<Array<main.SiteMeta>>;
+
+export function RegenerateControlToken():Promise<main.ControlAPIStatus>;
+
+export function ResumeDownload(arg1:string):Promise<string>;
+
+export function SavePreset(arg1:proccesor.ProcessingPreset):Promise<string>;
+
 export function SelectFolder():Promise<string>;
 
+export function SetControlAPI(arg1:boolean):Promise<main.ControlAPIStatus>;
+
 export function StartServer(arg1:string,arg2:string):Promise<string>;
 
+export function StopDownload(arg1:string):Promise<string>;
+
+export function StopJob(arg1:string):Promise<string>;
+
 export function StopServer():Promise<string>;
+
+export function UserAgentPresets():Promise<Array<string>>;
+
+export function VerifySite(arg1:string):Promise<downloader.VerifyReport>;
@@ -2,42 +2,126 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
-export function AdaptPaths(arg1, arg2) {
-  return window['go']['main']['App']['AdaptPaths'](arg1, arg2);
+export function AdaptPaths(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['AdaptPaths'](arg1, arg2, arg3, arg4);
 }
 
 export function AnalyzeScripts(arg1) {
   return window['go']['main']['App']['AnalyzeScripts'](arg1);
 }
 
+export function DeletePreset(arg1) {
+  return window['go']['main']['App']['DeletePreset'](arg1);
+}
+
 export function DeleteSite(arg1) {
   return window['go']['main']['App']['DeleteSite'](arg1);
 }
 
-export function DownloadSite(arg1, arg2) {
-  return window['go']['main']['App']['DownloadSite'](arg1, arg2);
+export function DownloadSite(arg1, arg2, arg3) {
+  return window['go']['main']['App']['DownloadSite'](arg1, arg2, arg3);
+}
+
+export function ExportDockerBundle(arg1, arg2) {
+  return window['go']['main']['App']['ExportDockerBundle'](arg1, arg2);
+}
+
+export function ExportSite(arg1) {
+  return window['go']['main']['App']['ExportSite'](arg1);
+}
+
+export function GetControlAPI() {
+  return window['go']['main']['App']['GetControlAPI']();
+}
+
+export function GetCustomPresets() {
+  return window['go']['main']['App']['GetCustomPresets']();
 }
 
 export function GetDownloads() {
   return window['go']['main']['App']['GetDownloads']();
 }
 
+export function GetOutboundLinks(arg1) {
+  return window['go']['main']['App']['GetOutboundLinks'](arg1);
+}
+
+export function GetPresets() {
+  return window['go']['main']['App']['GetPresets']();
+}
+
+export function GetServerStatus() {
+  return window['go']['main']['App']['GetServerStatus']();
+}
+
+export function GetWorkerStatus(arg1) {
+  return window['go']['main']['App']['GetWorkerStatus'](arg1);
+}
+
 export function LaunchSite(arg1) {
   return window['go']['main']['App']['LaunchSite'](arg1);
 }
 
+export function ListJobs() {
+  return window['go']['main']['App']['ListJobs']();
+}
+
 export function OpenFolder(arg1) {
   return window['go']['main']['App']['OpenFolder'](arg1);
 }
 
+export function PauseDownload(arg1) {
+  return window['go']['main']['App']['PauseDownload'](arg1);
+}
+
+export function RecrawlURLs(arg1, arg2) {
+  return window['go']['main']['App']['RecrawlURLs'](arg1, arg2);
+}
+
+export function RefreshLibrary() {
+  return window['go']['main']['App']['RefreshLibrary']();
+}
+
+export function RegenerateControlToken() {
+  return window['go']['main']['App']['RegenerateControlToken']();
+}
+
+export function ResumeDownload(arg1) {
+  return window['go']['main']['App']['ResumeDownload'](arg1);
+}
+
+export function SavePreset(arg1) {
+  return window['go']['main']['App']['SavePreset'](arg1);
+}
+
 export function SelectFolder() {
   return window['go']['main']['App']['SelectFolder']();
 }
 
+export function SetControlAPI(arg1) {
+  return window['go']['main']['App']['SetControlAPI'](arg1);
+}
+
 export function StartServer(arg1, arg2) {
   return window['go']['main']['App']['StartServer'](arg1, arg2);
 }
 
+export function StopDownload(arg1) {
+  return window['go']['main']['App']['StopDownload'](arg1);
+}
+
+export function StopJob(arg1) {
+  return window['go']['main']['App']['StopJob'](arg1);
+}
+
 export function StopServer() {
   return window['go']['main']['App']['StopServer']();
 }
+
+export function UserAgentPresets() {
+  return window['go']['main']['App']['UserAgentPresets']();
+}
+
+export function VerifySite(arg1) {
+  return window['go']['main']['App']['VerifySite'](arg1);
+}
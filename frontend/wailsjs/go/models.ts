@@ -1,11 +1,241 @@
+export namespace downloader {
+	
+	export class JobInfo {
+	    id: string;
+	    url: string;
+	    status: string;
+	    paused: boolean;
+	    // Go type: time
+	    added: any;
+	    files: number;
+	    bytes: number;
+	    failed: number;
+	    speed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new JobInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.url = source["url"];
+	        this.status = source["status"];
+	        this.paused = source["paused"];
+	        this.added = this.convertValues(source["added"], null);
+	        this.files = source["files"];
+	        this.bytes = source["bytes"];
+	        this.failed = source["failed"];
+	        this.speed = source["speed"];
+	    }
+	
+	convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class VerifyProblem {
+	    ref: string;
+	    target: string;
+	    reason: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new VerifyProblem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ref = source["ref"];
+	        this.target = source["target"];
+	        this.reason = source["reason"];
+	    }
+	}
+	export class VerifyPage {
+	    page: string;
+	    problems: VerifyProblem[];
+	
+	    static createFrom(source: any = {}) {
+	        return new VerifyPage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.page = source["page"];
+	        this.problems = this.convertValues(source["problems"], VerifyProblem);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class VerifyReport {
+	    dir: string;
+	    files: number;
+	    refs: number;
+	    external: number;
+	    pages: VerifyPage[];
+	
+	    static createFrom(source: any = {}) {
+	        return new VerifyReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.dir = source["dir"];
+	        this.files = source["files"];
+	        this.refs = source["refs"];
+	        this.external = source["external"];
+	        this.pages = this.convertValues(source["pages"], VerifyPage);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class WorkerState {
+	    id: number;
+	    url: string;
+	    phase: string;
+	    // Go type: time
+	    since: any;
+	    elapsed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkerState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.url = source["url"];
+	        this.phase = source["phase"];
+	        this.since = this.convertValues(source["since"], null);
+	        this.elapsed = source["elapsed"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
 export namespace main {
 	
+	export class ControlAPIStatus {
+	    enabled: boolean;
+	    running: boolean;
+	    url: string;
+	    token: string;
+	    bookmarklet: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ControlAPIStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.running = source["running"];
+	        this.url = source["url"];
+	        this.token = source["token"];
+	        this.bookmarklet = source["bookmarklet"];
+	        this.error = source["error"];
+	    }
+	}
+	export class DownloadOptions {
+	    headers: Record<string, string>;
+	    include: string[];
+	    exclude: string[];
+	    block: string[];
+	    externalAssets: boolean;
+	    extraDomains: string[];
+	    includeSubdomains: boolean;
+	    parseJavaScript: boolean;
+	    singlePage: boolean;
+	    uaPreset: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.headers = source["headers"];
+	        this.include = source["include"];
+	        this.exclude = source["exclude"];
+	        this.block = source["block"];
+	        this.externalAssets = source["externalAssets"];
+	        this.extraDomains = source["extraDomains"];
+	        this.includeSubdomains = source["includeSubdomains"];
+	        this.parseJavaScript = source["parseJavaScript"];
+	        this.singlePage = source["singlePage"];
+	        this.uaPreset = source["uaPreset"];
+	    }
+	}
 	export class SiteMeta {
 	    name: string;
 	    path: string;
 	    icon: string;
 	    domain: string;
 	    entryPath: string;
+	    scanning: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new SiteMeta(source);
@@ -18,8 +248,195 @@ export namespace main {
 	        this.icon = source["icon"];
 	        this.domain = source["domain"];
 	        this.entryPath = source["entryPath"];
+	        this.scanning = source["scanning"];
+	    }
+	}
+
+}
+
+
+export namespace proccesor {
+	
+	export class OutboundLink {
+	    source: string;
+	    href: string;
+	    text: string;
+	    rel: string;
+	    attr?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new OutboundLink(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.source = source["source"];
+	        this.href = source["href"];
+	        this.text = source["text"];
+	        this.rel = source["rel"];
+	        this.attr = source["attr"];
+	    }
+	}
+	export class PresetOverrides {
+	    linkStyle?: string;
+	    keepExternal?: boolean;
+	    removeMissing?: boolean;
+	    convertPhp?: boolean;
+	    stripCsp?: boolean;
+	    placeholders?: boolean;
+	    minify?: boolean;
+	    upgradeHttp?: boolean;
+	    upgradeHosts?: string[];
+	    verifyUpgrades?: boolean;
+	    protocolRelative?: boolean;
+	    stripAssetQueries?: boolean;
+	    keepQueryParams?: string[];
+	    generateIndexes?: boolean;
+	    stripHandlers?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new PresetOverrides(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.linkStyle = source["linkStyle"];
+	        this.keepExternal = source["keepExternal"];
+	        this.removeMissing = source["removeMissing"];
+	        this.convertPhp = source["convertPhp"];
+	        this.stripCsp = source["stripCsp"];
+	        this.placeholders = source["placeholders"];
+	        this.minify = source["minify"];
+	        this.upgradeHttp = source["upgradeHttp"];
+	        this.upgradeHosts = source["upgradeHosts"];
+	        this.verifyUpgrades = source["verifyUpgrades"];
+	        this.protocolRelative = source["protocolRelative"];
+	        this.stripAssetQueries = source["stripAssetQueries"];
+	        this.keepQueryParams = source["keepQueryParams"];
+	        this.generateIndexes = source["generateIndexes"];
+	        this.stripHandlers = source["stripHandlers"];
+	    }
+	}
+	export class ProcessingPreset {
+	    name: string;
+	    linkStyle: string;
+	    keepExternal: boolean;
+	    removeMissing: boolean;
+	    convertPhp: boolean;
+	    stripCsp: boolean;
+	    placeholders: boolean;
+	    minify: boolean;
+	    upgradeHttp: boolean;
+	    upgradeHosts: string[];
+	    verifyUpgrades: boolean;
+	    protocolRelative: boolean;
+	    stripAssetQueries: boolean;
+	    keepQueryParams: string[];
+	    generateIndexes: boolean;
+	    stripHandlers: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ProcessingPreset(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.linkStyle = source["linkStyle"];
+	        this.keepExternal = source["keepExternal"];
+	        this.removeMissing = source["removeMissing"];
+	        this.convertPhp = source["convertPhp"];
+	        this.stripCsp = source["stripCsp"];
+	        this.placeholders = source["placeholders"];
+	        this.minify = source["minify"];
+	        this.upgradeHttp = source["upgradeHttp"];
+	        this.upgradeHosts = source["upgradeHosts"];
+	        this.verifyUpgrades = source["verifyUpgrades"];
+	        this.protocolRelative = source["protocolRelative"];
+	        this.stripAssetQueries = source["stripAssetQueries"];
+	        this.keepQueryParams = source["keepQueryParams"];
+	        this.generateIndexes = source["generateIndexes"];
+	        this.stripHandlers = source["stripHandlers"];
 	    }
 	}
+	export class ScriptInfo {
+	    id: string;
+	    src?: string;
+	    inline: boolean;
+	    external: boolean;
+	    exists: boolean;
+	    size: number;
+	    pages: number;
+	    type?: string;
+	    hash?: string;
+	    preview?: string;
+	    tracker?: string;
+
+	    static createFrom(source: any = {}) {
+	        return new ScriptInfo(source);
+	    }
+
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.src = source["src"];
+	        this.inline = source["inline"];
+	        this.external = source["external"];
+	        this.exists = source["exists"];
+	        this.size = source["size"];
+	        this.pages = source["pages"];
+	        this.type = source["type"];
+	        this.hash = source["hash"];
+	        this.preview = source["preview"];
+	        this.tracker = source["tracker"];
+	    }
+	}
+
+}
+
+export namespace server {
+	
+	export class Health {
+	    servingPath: string;
+	    // Go type: time
+	    startedAt: any;
+	    requests: number;
+	    errors404: number;
+	    basePath: string;
+	    authEnabled: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Health(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.servingPath = source["servingPath"];
+	        this.startedAt = this.convertValues(source["startedAt"], null);
+	        this.requests = source["requests"];
+	        this.errors404 = source["errors404"];
+	        this.basePath = source["basePath"];
+	        this.authEnabled = source["authEnabled"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 
 }
 
@@ -8,6 +8,7 @@ go 1.23.0
 
 require (
 	fyne.io/fyne/v2 v2.7.2
+	github.com/andybalholm/brotli v1.2.0
 	github.com/spf13/cobra v1.10.2
 	github.com/spf13/viper v1.21.0
 	github.com/wailsapp/wails/v2 v2.11.0
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2/data/binding"
+
+	"sitemvp/downloader"
+)
+
+const (
+	logTailEntries = 1000      // Сообщений в окне лога
+	logTailBytes   = 256 << 10 // И не больше стольких байт
+)
+
+// logTail показывает в binding только хвост лога. Сообщения копятся через
+// LogBatcher, и binding обновляется несколько раз в секунду, а не на каждую
+// строку: Get+конкатенация+Set на 100k файлов — O(n²) и зависание окна.
+type logTail struct {
+	out   binding.String
+	batch *downloader.LogBatcher
+
+	mu      sync.Mutex
+	entries []string
+	size    int
+}
+
+func newLogTail(out binding.String, initial string) *logTail {
+	t := &logTail{out: out}
+	t.batch = downloader.NewLogBatcher(downloader.DefaultLogFlushInterval, logTailEntries, t.show)
+	t.Reset(initial)
+	return t
+}
+
+// Append добавляет сообщение как есть (перевод строки — забота вызывающего)
+func (t *logTail) Append(msg string) {
+	t.batch.Add(msg)
+}
+
+// Reset очищает лог и показывает msg сразу
+func (t *logTail) Reset(msg string) {
+	t.batch.Flush()
+	t.mu.Lock()
+	t.entries, t.size = nil, 0
+	t.mu.Unlock()
+	t.show([]string{msg}, 0)
+}
+
+// Flush сразу показывает накопленное
+func (t *logTail) Flush() {
+	t.batch.Flush()
+}
+
+func (t *logTail) show(msgs []string, dropped int) {
+	t.mu.Lock()
+	if dropped > 0 {
+		t.push(fmt.Sprintf("… %d log lines skipped\n", dropped))
+	}
+	for _, m := range msgs {
+		t.push(m)
+	}
+	text := strings.Join(t.entries, "")
+	t.mu.Unlock()
+	t.out.Set(text)
+}
+
+// push добавляет сообщение и отрезает старые сверх лимитов. Вызывать под t.mu.
+func (t *logTail) push(msg string) {
+	t.entries = append(t.entries, msg)
+	t.size += len(msg)
+	drop := 0
+	for len(t.entries)-drop > logTailEntries || (t.size > logTailBytes && len(t.entries)-drop > 1) {
+		t.size -= len(t.entries[drop])
+		drop++
+	}
+	if drop > 0 {
+		t.entries = t.entries[drop:]
+	}
+}
@@ -1,20 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image/color"
-	"io"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sitemvp/downloader"
 	proccesor "sitemvp/processor"
+	"sitemvp/server"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"net/http"
-
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
@@ -197,6 +199,38 @@ func (p *AnimatedProgress) SetProgress(value float64, detail string) {
 	p.percentBind.Set(fmt.Sprintf("%.0f%%", value*100))
 } // здесь проблема с потоком и все ломается даже в системе слетает все из
 
+// downloadListener выводит события загрузки в лог и карточку прогресса
+type downloadListener struct {
+	log      *logTail
+	progress *AnimatedProgress
+}
+
+func (l *downloadListener) OnLog(msg string) {
+	l.log.Append(msg + "\n")
+}
+
+func (l *downloadListener) OnProgress(s downloader.Snapshot) {
+	value := 0.0
+	if s.Discovered > 0 {
+		value = float64(s.Completed) / float64(s.Discovered)
+	}
+	l.progress.SetProgress(value, fmt.Sprintf("%.2f KB/s | %s", s.Speed/1024, downloader.FormatETA(s)))
+}
+
+func (l *downloadListener) OnPhase(phase downloader.JobPhase) {
+	l.OnLog(fmt.Sprintf("🔎 Phase: %s", phase))
+}
+
+func (l *downloadListener) OnFileDone(downloader.FileResult) {}
+
+func (l *downloadListener) OnError(downloader.ErrorEvent) {}
+
+func (l *downloadListener) OnComplete(sum downloader.Summary) {
+	l.log.Append(fmt.Sprintf("📄 Full log: %s\n", sum.LogFile))
+	l.log.Flush()
+	l.progress.SetProgress(1.0, "Complete!")
+}
+
 func main() {
 	log.Println("🚀 Starting Site Cloner MVP...")
 
@@ -212,10 +246,10 @@ func main() {
 	var currentHost string
 
 	downloadLogBinding := binding.NewString()
-	downloadLogBinding.Set("Ready to download...\n")
+	downloadLog := newLogTail(downloadLogBinding, "Ready to download...\n")
 
 	procLogBinding := binding.NewString()
-	procLogBinding.Set("Ready to process...\n")
+	procLog := newLogTail(procLogBinding, "Ready to process...\n")
 
 	// GLOBAL BINDINGS (Declared early for visibility)
 	showSuccessDialog := binding.NewString()
@@ -265,6 +299,10 @@ func main() {
 	dirEntry.SetText(outputDir)
 	dirEntry.OnChanged = func(s string) { outputDir = s }
 
+	// Browser profile: the preset also sets matching Accept and client hints
+	uaSelect := widget.NewSelect(append([]string{"default"}, downloader.UAPresets()...), nil)
+	uaSelect.SetSelected("default")
+
 	btnBrowse := widget.NewButtonWithIcon("Browse", theme.FolderOpenIcon(), func() {
 		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
 			if uri != nil {
@@ -285,6 +323,42 @@ func main() {
 
 	var isDownloading bool
 	var downloadBtn *widget.Button
+
+	// Пауза не обрывает текущие загрузки: Pause ждёт их, поэтому зовём не из UI
+	var currentJob atomic.Pointer[downloader.Job]
+	pauseBtn := widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), nil)
+	pauseBtn.Disable()
+	setPauseLabel := func(paused bool) {
+		if paused {
+			pauseBtn.SetText("Resume")
+			pauseBtn.SetIcon(theme.MediaPlayIcon())
+		} else {
+			pauseBtn.SetText("Pause")
+			pauseBtn.SetIcon(theme.MediaPauseIcon())
+		}
+	}
+	pauseBtn.OnTapped = func() {
+		job := currentJob.Load()
+		if job == nil {
+			return
+		}
+		pauseBtn.Disable()
+		go func() {
+			var err error
+			if job.Paused() {
+				err = job.Resume()
+			} else {
+				err = job.Pause()
+			}
+			if err != nil && currentJob.Load() == job {
+				dialog.ShowError(err, window)
+			}
+			if currentJob.Load() == job {
+				setPauseLabel(job.Paused())
+				pauseBtn.Enable()
+			}
+		}()
+	}
 	downloadBtn = widget.NewButtonWithIcon("🚀 Start Download", theme.DownloadIcon(), func() {
 		if isDownloading {
 			dialog.ShowInformation("Busy", "Download in progress", window)
@@ -308,47 +382,40 @@ func main() {
 			MaxFileSize: downloader.DefaultMaxFileSize,
 			UserAgent:   downloader.DefaultUserAgent,
 		}
-
-		job, err := downloader.NewJob(urlEntry.Text, cfg)
-		if err != nil {
-			downloadLogBinding.Set(fmt.Sprintf("❌ Error: %v\n", err))
-			downloadLogBinding.Set(fmt.Sprintf("❌ Error: %v\n", err))
-			isDownloadingBinding.Set(false)
-			return
+		if uaSelect.Selected != "default" {
+			cfg.UAPreset = uaSelect.Selected
 		}
 
-		downloadLogBinding.Set(fmt.Sprintf("📡 Starting: %s\n\n", urlEntry.Text))
+		downloadLog.Reset(fmt.Sprintf("📡 Starting: %s\n\n", urlEntry.Text))
 		progressCard.SetProgress(0, "Init...")
 
 		go func() {
-			logCh := make(chan string, 100)
-
-			go func() {
-				for msg := range job.Events {
-					logCh <- msg
-				}
-				close(logCh)
-			}()
-
-			go func() {
-				for msg := range logCh {
-					currentLog, _ := downloadLogBinding.Get()
-					downloadLogBinding.Set(currentLog + msg + "\n")
-
-					if strings.Contains(msg, "Файлов:") {
-						progressCard.SetProgress(0.6, msg)
-					} else if strings.Contains(msg, "completed") {
-						progressCard.SetProgress(1.0, "Done!")
-					}
+			// Run возвращается, когда подписчик получил все события
+			_, err := downloader.Run(context.Background(), downloader.RunOptions{
+				URL:    urlEntry.Text,
+				Config: cfg,
+				OnStart: func(job *downloader.Job) {
+					job.Subscribe(&downloadListener{log: downloadLog, progress: progressCard})
+					currentJob.Store(job)
+					setPauseLabel(false)
+					pauseBtn.Enable()
+				},
+			})
+			currentJob.Store(nil)
+			setPauseLabel(false)
+			pauseBtn.Disable()
+			if err != nil {
+				downloadLog.Append(fmt.Sprintf("❌ Error: %v\n", err))
+				downloadLog.Flush()
+				if errors.Is(err, downloader.ErrSiteInUse) {
+					dialog.ShowError(err, window)
 				}
-			}()
-
-			job.Run()
-
-			time.Sleep(200 * time.Millisecond) // Подождем чтобы все логи обновились
+				isDownloadingBinding.Set(false)
+				return
+			}
 
-			currentLog, _ := downloadLogBinding.Get()
-			downloadLogBinding.Set(currentLog + "\n✅ Finished!\n")
+			downloadLog.Append("\n✅ Finished!\n")
+			downloadLog.Flush()
 			progressCard.SetProgress(1.0, "Complete!")
 
 			isDownloadingBinding.Set(false)
@@ -364,8 +431,10 @@ func main() {
 		urlEntry,
 		widget.NewLabelWithStyle("📁 Output Output", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		container.NewBorder(nil, nil, nil, btnBrowse, dirEntry),
+		widget.NewLabelWithStyle("🧭 Browser", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		uaSelect,
 		layout.NewSpacer(),
-		downloadBtn,
+		container.NewBorder(nil, nil, nil, pauseBtn, downloadBtn),
 		progressCard,
 	)
 
@@ -421,15 +490,14 @@ func main() {
 
 		isProcessingBinding.Set(true)
 
-		procLogBinding.Set("🔄 Initializing...\n")
+		procLog.Reset("🔄 Initializing...\n")
 		procProgress.SetProgress(0, "Starting...")
 
 		go func() {
 			p := proccesor.NewProcessor(host)
 
 			p.OnLog = func(msg string) {
-				currentLog, _ := procLogBinding.Get()
-				procLogBinding.Set(currentLog + msg)
+				procLog.Append(msg)
 
 				if strings.Contains(msg, "[START]") {
 					procProgress.SetProgress(0.1, "Processing...")
@@ -439,29 +507,26 @@ func main() {
 			}
 
 			scripts := p.AnalyzeScripts(sourceDir)
-			currentLog, _ := procLogBinding.Get()
-			procLogBinding.Set(currentLog + fmt.Sprintf("\n📊 Scripts: %d\n\n", len(scripts)))
+			procLog.Append(fmt.Sprintf("\n📊 Scripts: %d\n\n", len(scripts)))
 			procProgress.SetProgress(0.3, fmt.Sprintf("%d scripts", len(scripts)))
 
 			// 1. Prepare output path
 			processedDirName := filepath.Base(sourceDir) + "_processed"
 			outputPath := filepath.Join(filepath.Dir(sourceDir), processedDirName)
 
-			// 2. Copy directory
-			procLogBinding.Set(currentLog + fmt.Sprintf("\n📂 Copying to %s...\n", processedDirName))
-			if err := copyDir(sourceDir, outputPath); err != nil {
-				dialog.ShowError(fmt.Errorf("Failed to copy directory: %v", err), window)
+			// 2. Process into outputPath: unchanged assets become hard links
+			// to the source instead of full copies
+			procLog.Append(fmt.Sprintf("\n📂 Writing to %s...\n", processedDirName))
+			if err := p.ProcessContext(context.Background(), sourceDir, nil); err != nil {
+				dialog.ShowError(fmt.Errorf("Processing failed: %v", err), window)
 				isProcessingBinding.Set(false)
 				return
 			}
 
-			// 3. Process the COPIED directory
-			p.Process(outputPath, nil)
-
-			time.Sleep(200 * time.Millisecond)
-
-			currentLog, _ = procLogBinding.Get()
-			procLogBinding.Set(currentLog + "\n✅ Complete!\n")
+			// ProcessContext is synchronous, so every OnLog call has already
+			// happened; Flush shows the tail without waiting for the batch timer
+			procLog.Append("\n✅ Complete!\n")
+			procLog.Flush()
 
 			isProcessingBinding.Set(false)
 
@@ -500,7 +565,7 @@ func main() {
 	serverStatus.Set("Stopped")
 	serverStatusLabel := widget.NewLabelWithData(serverStatus)
 
-	var server *http.Server
+	srv := server.New()
 	var isServerRunning bool
 	var serverBtn *widget.Button
 
@@ -517,24 +582,39 @@ func main() {
 	serverScroll.SetMinSize(fyne.NewSize(0, 150))
 
 	openBrowserBtn := widget.NewButtonWithIcon("Open in Browser", theme.GridIcon(), func() {
-		u, _ := url.Parse("http://localhost:" + serverPortEntry.Text)
+		status := srv.Status()
+		if !status.Running {
+			return
+		}
+		u, _ := url.Parse(status.URL)
 		fyne.CurrentApp().OpenURL(u)
 	})
 	openBrowserBtn.Disable()
 
-	// Logic Implementation
-	ctrlStopServer = func() {
-		if server != nil {
-			server.Close()
-			server = nil
-		}
+	setServerStopped := func() {
 		isServerRunning = false
 		serverBtn.SetText("Start Server")
 		serverBtn.SetIcon(theme.MediaPlayIcon())
 		serverBtn.Importance = widget.HighImportance
-		serverStatus.Set("Stopped")
 		openBrowserBtn.Disable()
-		serverLogBinding.Set("Server stopped.\n")
+	}
+
+	srv.OnError = func(err error, prev server.Status) {
+		fyne.Do(func() {
+			setServerStopped()
+			serverStatus.Set("Error: " + err.Error())
+		})
+	}
+
+	// Logic Implementation
+	ctrlStopServer = func() {
+		if _, err := srv.Stop(); err != nil && err != server.ErrNotRunning {
+			serverLogBinding.Set("Server force-stopped.\n")
+		} else {
+			serverLogBinding.Set("Server stopped.\n")
+		}
+		setServerStopped()
+		serverStatus.Set("Stopped")
 	}
 
 	ctrlStartServer = func(dir string) {
@@ -542,7 +622,10 @@ func main() {
 			ctrlStopServer()
 		}
 
-		port := serverPortEntry.Text
+		port, err := strconv.Atoi(serverPortEntry.Text)
+		if err != nil {
+			port = server.DefaultPort
+		}
 		if dir == "" {
 			dir = serverDirEntry.Text
 		} else {
@@ -554,29 +637,29 @@ func main() {
 			return
 		}
 
-		server = &http.Server{Addr: ":" + port, Handler: http.FileServer(http.Dir(dir))}
+		status, err := srv.Start(server.StartOptions{Dir: dir, Port: port})
+		if err != nil {
+			serverStatus.Set("Error: " + err.Error())
+			dialog.ShowError(err, window)
+			return
+		}
+
 		isServerRunning = true
 		serverBtn.SetText("Stop Server")
 		serverBtn.SetIcon(theme.MediaStopIcon())
 		serverBtn.Importance = widget.DangerImportance
-		serverStatus.Set("Running on http://localhost:" + port)
+		serverStatus.Set("Running on " + status.URL)
 		openBrowserBtn.Enable()
 
 		currentLog, _ := serverLogBinding.Get()
-		serverLogBinding.Set(currentLog + fmt.Sprintf("🚀 Server started on port %s\nServing: %s\n", port, dir))
-
-		go func() {
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				isServerRunning = false
-				serverStatus.Set("Error: " + err.Error())
-				serverBtn.SetText("Start Server")
-				serverBtn.Importance = widget.HighImportance
-				openBrowserBtn.Disable()
-			}
-		}()
+		if status.Port != port {
+			currentLog += fmt.Sprintf("⚠️ Port %d is busy, using %d\n", port, status.Port)
+			serverPortEntry.SetText(strconv.Itoa(status.Port))
+		}
+		serverLogBinding.Set(currentLog + fmt.Sprintf("🚀 Server started on port %d\nServing: %s\n", status.Port, dir))
 
 		// Auto-open browser
-		u, _ := url.Parse("http://localhost:" + port)
+		u, _ := url.Parse(status.URL)
 		fyne.CurrentApp().OpenURL(u)
 	}
 
@@ -759,64 +842,3 @@ func main() {
 
 	log.Println("👋 Goodbye!")
 }
-
-// Helper function to copy a directory
-func copyDir(src string, dst string) error {
-	src = filepath.Clean(src)
-	dst = filepath.Clean(dst)
-
-	si, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	if !si.IsDir() {
-		return fmt.Errorf("source is not a directory")
-	}
-
-	err = os.MkdirAll(dst, si.Mode())
-	if err != nil {
-		return err
-	}
-
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return err
-	}
-
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			err = copyDir(srcPath, dstPath)
-			if err != nil {
-				return err
-			}
-		} else {
-			// Copy file
-			if err = copyFile(srcPath, dstPath); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err = io.Copy(out, in); err != nil {
-		return err
-	}
-	return out.Sync()
-}
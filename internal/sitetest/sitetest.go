@@ -0,0 +1,195 @@
+// Package sitetest — синтетический сайт для интеграционных тестов
+// загрузчика и обработки: httptest-сервер с вложенными страницами,
+// ассетами, php-ссылками, редиректами, 404 и внешними ссылками, и
+// сравнение получившейся папки с эталоном (golden) байт в байт.
+//
+// Эталоны обновляются флагом -update:
+//
+//	go test ./downloader ./processor -run Golden -update
+package sitetest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files of sitetest")
+
+// SiteURL в теле и Location страницы заменяется адресом сервера
+const SiteURL = "$SITE"
+
+// Page — ответ сайта на один путь (с query, если он есть в ссылке)
+type Page struct {
+	Status      int // 0 — 200
+	ContentType string
+	Location    string // Для редиректов
+	Body        string
+}
+
+// Synthetic — маленький сайт со всем, на чём обход уже ломался:
+// вложенные страницы, CSS с url(), картинки, ссылки вида catalog.php?page=2,
+// абсолютные ссылки на свой хост, редирект, битая ссылка с HTML-страницей
+// 404 и ссылки на чужой хост, которые должны остаться как есть.
+func Synthetic() map[string]Page {
+	return map[string]Page{
+		"/": {ContentType: "text/html", Body: `<!DOCTYPE html>
+<html><head><title>Home</title><link rel="stylesheet" href="/css/site.css"></head>
+<body>
+<img src="/img/logo.png" alt="logo">
+<a href="/docs/">Docs</a>
+<a href="` + SiteURL + `/about">About</a>
+<a href="/catalog.php?page=2">Page 2</a>
+<a href="/old">Moved</a>
+<a href="/missing">Missing</a>
+<a href="https://external.example.org/page">External</a>
+</body></html>
+`},
+		"/css/site.css": {ContentType: "text/css", Body: `body { background: url("../img/bg.png") }
+`},
+		"/img/logo.png": {ContentType: "image/png", Body: "\x89PNG\r\n\x1a\nlogo"},
+		"/img/bg.png":   {ContentType: "image/png", Body: "\x89PNG\r\n\x1a\nbg"},
+		"/docs/": {ContentType: "text/html", Body: `<html><body>
+<a href="/">Home</a>
+<a href="guide/intro.html">Intro</a>
+<a href="../css/site.css">CSS</a>
+</body></html>
+`},
+		"/docs/guide/intro.html": {ContentType: "text/html", Body: `<html><body>
+<a href="../">Docs</a>
+<img src="/img/logo.png">
+</body></html>
+`},
+		"/about": {ContentType: "text/html", Body: `<html><body>
+<a href="` + SiteURL + `/">Home</a>
+</body></html>
+`},
+		"/catalog.php?page=2": {ContentType: "text/html", Body: `<html><body>
+<a href="/docs/">Docs</a>
+</body></html>
+`},
+		"/old": {Status: http.StatusMovedPermanently, Location: "/new/"},
+		"/new/": {ContentType: "text/html", Body: `<html><body>new home of /old</body></html>
+`},
+		"/missing": {Status: http.StatusNotFound, ContentType: "text/html", Body: `<html><body>
+<h1>Not found</h1><img src="/img/logo.png">
+</body></html>
+`},
+	}
+}
+
+// Serve поднимает сайт из pages; остальные пути отвечают 404 text/plain.
+// Сервер закрывается в конце теста.
+func Serve(t testing.TB, pages map[string]Page) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if r.URL.RawQuery != "" {
+			key += "?" + r.URL.RawQuery
+		}
+		page, ok := pages[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if page.Location != "" {
+			w.Header().Set("Location", strings.ReplaceAll(page.Location, SiteURL, srv.URL))
+		}
+		if page.ContentType != "" {
+			w.Header().Set("Content-Type", page.ContentType)
+		}
+		if page.Status != 0 {
+			w.WriteHeader(page.Status)
+		}
+		fmt.Fprint(w, strings.ReplaceAll(page.Body, SiteURL, srv.URL))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Tree читает все файлы папки: путь через "/" → содержимое.
+// Файлы из skip (пути через "/") пропускаются.
+func Tree(t testing.TB, dir string, skip ...string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, s := range skip {
+			if rel == s {
+				return nil
+			}
+		}
+		data, err := os.ReadFile(p)
+		files[rel] = data
+		return err
+	})
+	if err != nil {
+		t.Fatalf("sitetest: read %s: %v", dir, err)
+	}
+	return files
+}
+
+// Golden сравнивает папку dir с эталоном golden: тот же набор файлов,
+// то же содержимое байт в байт. skip — файлы, которые меняются от
+// запуска к запуску (время, пути временных папок). С -update эталон
+// переписывается.
+func Golden(t testing.TB, dir, golden string, skip ...string) {
+	t.Helper()
+	got := Tree(t, dir, skip...)
+	if *update {
+		if err := os.RemoveAll(golden); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range got {
+			path := filepath.Join(golden, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+
+	want := Tree(t, golden)
+	for _, name := range sortedNames(want) {
+		data, ok := got[name]
+		switch {
+		case !ok:
+			t.Errorf("%s: missing (golden %s)", name, golden)
+		case !bytes.Equal(data, want[name]):
+			t.Errorf("%s differs from golden:\n--- got\n%s\n--- want\n%s", name, data, want[name])
+		}
+	}
+	for _, name := range sortedNames(got) {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s: unexpected file (not in golden %s)", name, golden)
+		}
+	}
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// libraryCacheFile persists scanned icons and entry paths between runs;
+// it lives next to settings.json (see configPath)
+const libraryCacheFile = "library-cache.json"
+
+// defaultScanConcurrency is used when settings don't specify scanConcurrency
+const defaultScanConcurrency = 4
+
+// siteDetails is the expensive part of SiteMeta, computed by walking the folder
+type siteDetails struct {
+	Icon      string    `json:"icon"`
+	EntryPath string    `json:"entryPath"`
+	ModTime   time.Time `json:"modTime"` // Folder mtime the details were computed for
+}
+
+// libraryCache serves site details instantly and recomputes them in the
+// background when a folder is new or its mtime changed
+type libraryCache struct {
+	file     string
+	scan     func(path string) siteDetails
+	onUpdate func(path string, d siteDetails)
+	sem      chan struct{}
+
+	mu       sync.Mutex
+	entries  map[string]siteDetails
+	scanning map[string]bool
+}
+
+func newLibraryCache(file string, concurrency int, scan func(path string) siteDetails) *libraryCache {
+	if concurrency <= 0 {
+		concurrency = defaultScanConcurrency
+	}
+	c := &libraryCache{
+		file:     file,
+		scan:     scan,
+		sem:      make(chan struct{}, concurrency),
+		entries:  make(map[string]siteDetails),
+		scanning: make(map[string]bool),
+	}
+	if data, err := os.ReadFile(file); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	return c
+}
+
+// get returns cached details and whether they are current for mtime.
+// On a miss it schedules a background scan and returns the stale entry, if any.
+func (c *libraryCache) get(path string, mtime time.Time) (siteDetails, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.entries[path]
+	if ok && d.ModTime.Equal(mtime) {
+		return d, true
+	}
+	if !c.scanning[path] {
+		c.scanning[path] = true
+		go c.refresh(path, mtime)
+	}
+	return d, false
+}
+
+func (c *libraryCache) refresh(path string, mtime time.Time) {
+	c.sem <- struct{}{}
+	d := c.scan(path)
+	<-c.sem
+	d.ModTime = mtime
+
+	c.mu.Lock()
+	c.entries[path] = d
+	delete(c.scanning, path)
+	c.saveLocked()
+	c.mu.Unlock()
+
+	if c.onUpdate != nil {
+		c.onUpdate(path, d)
+	}
+}
+
+// invalidate drops all cached details; the next get rescans every folder
+func (c *libraryCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]siteDetails)
+	c.saveLocked()
+}
+
+// forget removes a deleted folder from the cache
+func (c *libraryCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+	c.saveLocked()
+}
+
+func (c *libraryCache) saveLocked() {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0755); err != nil {
+		return
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		os.Rename(tmp, c.file)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLibraryCacheHitMiss(t *testing.T) {
+	dir := t.TempDir()
+	site := filepath.Join(dir, "example.com")
+	os.MkdirAll(site, 0755)
+	cacheFile := filepath.Join(dir, "config", settingsDirName, libraryCacheFile) // Папки ещё нет
+
+	var scans int32
+	updated := make(chan siteDetails, 10)
+	newCache := func() *libraryCache {
+		c := newLibraryCache(cacheFile, 2, func(path string) siteDetails {
+			atomic.AddInt32(&scans, 1)
+			return siteDetails{EntryPath: "index.html"}
+		})
+		c.onUpdate = func(path string, d siteDetails) { updated <- d }
+		return c
+	}
+	wait := func() siteDetails {
+		select {
+		case d := <-updated:
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("background scan did not finish")
+			return siteDetails{}
+		}
+	}
+
+	c := newCache()
+	mtime := time.Unix(1700000000, 0)
+	os.Chtimes(site, mtime, mtime)
+
+	// Первый запрос — промах, скан в фоне
+	if _, fresh := c.get(site, mtime); fresh {
+		t.Fatal("Expected miss on empty cache")
+	}
+	if d := wait(); d.EntryPath != "index.html" {
+		t.Errorf("Unexpected details: %+v", d)
+	}
+
+	// Тот же mtime — попадание без повторного скана
+	if d, fresh := c.get(site, mtime); !fresh || d.EntryPath != "index.html" {
+		t.Errorf("Expected hit, got fresh=%v %+v", fresh, d)
+	}
+
+	// Кэш переживает перезапуск
+	if _, fresh := newCache().get(site, mtime); !fresh {
+		t.Errorf("Expected hit from persisted cache")
+	}
+
+	// Папка изменилась — промах, но старые данные отдаются сразу
+	changed := mtime.Add(time.Minute)
+	os.Chtimes(site, changed, changed)
+	if d, fresh := c.get(site, changed); fresh || d.EntryPath != "index.html" {
+		t.Errorf("Expected stale miss after mtime change, got fresh=%v %+v", fresh, d)
+	}
+	wait()
+
+	// Ручное обновление сбрасывает всё
+	c.invalidate()
+	if _, fresh := c.get(site, changed); fresh {
+		t.Errorf("Expected miss after invalidate")
+	}
+	wait()
+
+	if n := atomic.LoadInt32(&scans); n != 3 {
+		t.Errorf("Expected 3 scans, got %d", n)
+	}
+}
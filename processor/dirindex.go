@@ -0,0 +1,237 @@
+package proccesor
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// GeneratedIndexMeta — значение <meta name="generator"> у созданных индексов,
+// чтобы их можно было отличить от скачанных страниц
+const GeneratedIndexMeta = "sitecloner-dirindex"
+
+// dirEntry — папка исходного сайта при поиске разделов без index.html
+type dirEntry struct {
+	index   string   // Имя скачанной index-страницы, "" — её нет
+	pages   []string // Страницы прямо в папке, кроме index
+	subdirs []string
+}
+
+// findIndexlessDirs находит папки (относительно sourceDir, со слэшами), где
+// есть скачанные страницы — свои или во вложенных папках, — но нет index.html.
+// Без индекса переход на уровень вверх в локальной копии даёт 404.
+func (p *Processor) findIndexlessDirs(sourceDir string) {
+	dirs := map[string]*dirEntry{}
+	filepath.WalkDir(sourceDir, func(fpath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(sourceDir, fpath)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			dirs[rel] = &dirEntry{}
+			if rel != "." {
+				parent := dirs[path.Dir(rel)]
+				parent.subdirs = append(parent.subdirs, d.Name())
+			}
+			return nil
+		}
+		if !isPageFile(d.Name()) {
+			return nil
+		}
+		dir := dirs[path.Dir(rel)]
+		if isIndexPage(d.Name()) {
+			dir.index = d.Name()
+		} else {
+			dir.pages = append(dir.pages, d.Name())
+		}
+		return nil
+	})
+
+	missing := map[string]bool{}
+	var hasPages func(rel string) bool
+	hasPages = func(rel string) bool {
+		dir := dirs[rel]
+		found := dir.index != "" || len(dir.pages) > 0
+		for _, sub := range dir.subdirs {
+			// Обходим все вложенные папки: индекс может понадобиться и глубже
+			if hasPages(path.Join(rel, sub)) {
+				found = true
+			}
+		}
+		if found && dir.index == "" {
+			missing[rel] = true
+		}
+		return found
+	}
+	if _, ok := dirs["."]; ok {
+		hasPages(".")
+	}
+	p.indexDirs = missing
+	p.indexTree = dirs
+}
+
+func isPageFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm", ".php":
+		return true
+	}
+	return false
+}
+
+func isIndexPage(name string) bool {
+	switch strings.ToLower(name) {
+	case "index.html", "index.htm", "index.php":
+		return true
+	}
+	return false
+}
+
+// hasGeneratedIndex — для папки rel (относительно исходника) будет создан индекс
+func (p *Processor) hasGeneratedIndex(rel string) bool {
+	return p.indexDirs[path.Clean(filepath.ToSlash(rel))]
+}
+
+// indexLink — строка списка в созданном индексе
+type indexLink struct {
+	Href  string
+	Title string
+	Dir   bool
+}
+
+var dirIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="generator" content="{{.Generator}}">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of {{.Path}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:48rem;margin:2rem auto;padding:0 1rem;color:#222}
+h1{font-size:1.4rem;word-break:break-all}
+ul{list-style:none;padding:0}
+li{padding:.4rem 0;border-bottom:1px solid #eee}
+a{color:#0b62c4;text-decoration:none}
+a:hover{text-decoration:underline}
+.note{margin-top:2rem;padding:.6rem .8rem;background:#fff8e1;border:1px solid #f0d98c;border-radius:4px;font-size:.85rem;color:#6b5900}
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{if .Parent}}<li><a href="../index.html">..</a></li>
+{{end}}{{range .Links}}<li><a href="{{.Href}}">{{.Title}}{{if .Dir}}/{{end}}</a></li>
+{{end}}</ul>
+<p class="note">This page was generated by SiteCloner: the original section page was not downloaded.</p>
+</body>
+</html>
+`))
+
+// writeDirIndexes создаёт index.html в папках результата, найденных findIndexlessDirs
+func (p *Processor) writeDirIndexes(sourceDir string) error {
+	rels := make([]string, 0, len(p.indexDirs))
+	for rel := range p.indexDirs {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		dir := p.indexTree[rel]
+		var links []indexLink
+		for _, sub := range dir.subdirs {
+			subRel := path.Join(rel, sub)
+			sd := p.indexTree[subRel]
+			switch {
+			case sd.index != "":
+				src := filepath.Join(sourceDir, filepath.FromSlash(subRel), sd.index)
+				links = append(links, indexLink{Href: sub + "/" + p.outputName(sd.index), Title: titleOf(src, sub), Dir: true})
+			case p.indexDirs[subRel]:
+				links = append(links, indexLink{Href: sub + "/index.html", Title: sub, Dir: true})
+			}
+		}
+		for _, page := range dir.pages {
+			src := filepath.Join(sourceDir, filepath.FromSlash(rel), page)
+			links = append(links, indexLink{Href: p.outputName(page), Title: titleOf(src, page)})
+		}
+		sort.SliceStable(links, func(i, j int) bool {
+			if links[i].Dir != links[j].Dir {
+				return links[i].Dir
+			}
+			return strings.ToLower(links[i].Title) < strings.ToLower(links[j].Title)
+		})
+
+		outDir := filepath.Join(p.cfg.OutputDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(filepath.Join(outDir, "index.html"))
+		if err != nil {
+			return err
+		}
+		err = dirIndexTemplate.Execute(f, map[string]interface{}{
+			"Generator": GeneratedIndexMeta,
+			"Path":      path.Clean("/" + rel + "/"),
+			"Parent":    rel != ".",
+			"Links":     links,
+		})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("index for %s: %w", rel, err)
+		}
+		atomic.AddInt64(&p.Stats.IndexesGenerated, 1)
+	}
+	return nil
+}
+
+// outputName — имя страницы в результате: .php становится .html, если не KeepPHP
+func (p *Processor) outputName(name string) string {
+	if strings.HasSuffix(name, ".php") && !p.cfg.KeepPHP {
+		return strings.TrimSuffix(name, ".php") + ".html"
+	}
+	return name
+}
+
+// titleOf читает <title> страницы; без него — fallback
+func titleOf(file, fallback string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	z := html.NewTokenizer(io.LimitReader(f, 256<<10))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return fallback
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Body {
+				return fallback
+			}
+			if atom.Lookup(name) != atom.Title {
+				continue
+			}
+			if z.Next() == html.TextToken {
+				if t := strings.Join(strings.Fields(html.UnescapeString(string(z.Text()))), " "); t != "" {
+					return t
+				}
+			}
+			return fallback
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package proccesor
+
+import (
+	"path/filepath"
+
+	"sitemvp/downloader"
+)
+
+// absoluteLinks — ссылки из currentFile пишутся от корня сайта: так
+// задано LinkStyle или это страница 404. Хостинг отдаёт 404.html по
+// любому несуществующему адресу, и относительные ../ из корня там
+// вели бы не туда.
+func (p *Processor) absoluteLinks(currentFile string) bool {
+	if p.cfg.LinkStyle == LinkStyleAbsolute {
+		return true
+	}
+	rel, err := filepath.Rel(p.cfg.Dir, currentFile)
+	return err == nil && filepath.ToSlash(rel) == downloader.ErrorPageName
+}
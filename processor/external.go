@@ -0,0 +1,181 @@
+package proccesor
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"sitemvp/downloader"
+)
+
+// ExternalDirName — папка результата с копиями ассетов CDN
+const ExternalDirName = "_external"
+
+// Загрузчик с DownloadExternalAssets кладёт ассеты CDN рядом с папкой
+// сайта (<downloads>/<хост CDN>/...) и пишет на них ссылки вида
+// "../cdn.example.net/app.css". В результат обработки такие файлы
+// копируются в _external/<хост>/..., чтобы сайт был самодостаточным:
+// его можно выложить или отдать сервером из одной папки. Абсолютные
+// ссылки на CDN, для которых есть локальная копия, переписываются туда же.
+
+// externalAssets — файлы CDN, на которые ссылается сайт
+type externalAssets struct {
+	mu    sync.Mutex
+	files map[string]string // <хост>/<путь> → исходный файл
+}
+
+func newExternalAssets() *externalAssets {
+	return &externalAssets{files: make(map[string]string)}
+}
+
+func (e *externalAssets) add(rel, src string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.files[rel]; ok {
+		return false
+	}
+	e.files[rel] = src
+	return true
+}
+
+// mirrorRoot — папка загрузок, в которой рядом с сайтом лежат хосты CDN
+func (p *Processor) mirrorRoot() string {
+	return filepath.Dir(filepath.Clean(p.cfg.Dir))
+}
+
+// externalAsset находит локальную копию ассета чужого хоста для ссылки
+// из currentFile. Возвращает путь в результате от корня:
+// /_external/<хост>/<путь>.
+func (p *Processor) externalAsset(currentFile string, u *url.URL) (string, bool) {
+	if p.external == nil || p.cfg.Dir == "" {
+		return "", false
+	}
+	var host, rel string
+	switch {
+	case u.Host != "":
+		// https://cdn.example.net/app.css и //cdn.example.net/app.css
+		if u.Host == p.cfg.OriginalHost || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+			return "", false
+		}
+		host, rel = u.Host, downloader.DiskPath(u, "")
+	case u.Scheme == "" && u.Path != "" && !strings.HasPrefix(u.Path, "/"):
+		// ../cdn.example.net/app.css — ссылка, которую записал загрузчик
+		target := filepath.Join(filepath.Dir(currentFile), filepath.FromSlash(u.Path))
+		fromRoot, err := filepath.Rel(p.mirrorRoot(), target)
+		if err != nil {
+			return "", false
+		}
+		var ok bool
+		host, rel, ok = strings.Cut(filepath.ToSlash(fromRoot), "/")
+		if !ok || host == ".." || host == filepath.Base(filepath.Clean(p.cfg.Dir)) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	hostDir := filepath.Join(p.mirrorRoot(), host)
+	if !downloader.IsAssetMirror(hostDir) {
+		return "", false
+	}
+	src, err := downloader.ContainedPath(hostDir, rel)
+	if err != nil {
+		return "", false
+	}
+	if fi, err := os.Stat(src); err != nil || fi.IsDir() {
+		if u.Host == "" || u.RawQuery == "" {
+			return "", false
+		}
+		// Cache buster (?v=3) загрузчик из имени убирает — ищем файл без query
+		rel = downloader.DiskPath(&url.URL{Path: u.Path}, "")
+		if src, err = downloader.ContainedPath(hostDir, rel); err != nil {
+			return "", false
+		}
+		if fi, err := os.Stat(src); err != nil || fi.IsDir() {
+			return "", false
+		}
+	}
+	if p.external.add(host+"/"+rel, src) && strings.HasSuffix(strings.ToLower(rel), ".css") {
+		p.collectCSSAssets(host, rel, src)
+	}
+	return "/" + path.Join(ExternalDirName, host, rel), true
+}
+
+// collectCSSAssets добавляет файлы, на которые ссылается CSS с CDN
+// (шрифты, картинки, импортированные таблицы): в копии они должны лежать
+// там же относительно него. Повторно файл не разбирается — циклы
+// импортов обрываются на external.add.
+func (p *Processor) collectCSSAssets(host, rel, src string) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return
+	}
+	hostDir := filepath.Join(p.mirrorRoot(), host)
+	content := string(data)
+	for _, ref := range downloader.CSSRefs(content) {
+		u, err := url.Parse(strings.TrimSpace(content[ref[0]:ref[1]]))
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			continue
+		}
+		ref := u.Path
+		if !strings.HasPrefix(ref, "/") {
+			ref = path.Join(path.Dir(rel), ref)
+		}
+		ref = strings.TrimPrefix(path.Clean("/"+ref), "/")
+		file, err := downloader.ContainedPath(hostDir, ref)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(file); err != nil || fi.IsDir() {
+			continue
+		}
+		if p.external.add(host+"/"+ref, file) && strings.HasSuffix(strings.ToLower(ref), ".css") {
+			p.collectCSSAssets(host, ref, file)
+		}
+	}
+}
+
+// localLink — ссылка из currentFile на путь результата от корня
+func (p *Processor) localLink(currentFile string, u *url.URL, target string) string {
+	local := *u
+	local.RawQuery, local.ForceQuery = p.assetQuery(u, target)
+	if p.absoluteLinks(currentFile) {
+		return formatResult(&local, target)
+	}
+	relBase, _ := filepath.Rel(p.cfg.Dir, filepath.Dir(currentFile))
+	rel, err := filepath.Rel(relBase, strings.TrimPrefix(target, "/"))
+	if err != nil {
+		return formatResult(&local, target)
+	}
+	return formatResult(&local, filepath.ToSlash(rel))
+}
+
+// copyExternalAssets кладёт в результат файлы CDN, на которые ссылается сайт
+func (p *Processor) copyExternalAssets() error {
+	if p.external == nil {
+		return nil
+	}
+	p.external.mu.Lock()
+	defer p.external.mu.Unlock()
+	for rel, src := range p.external.files {
+		dst, err := downloader.ContainedPath(p.cfg.OutputDir, path.Join(ExternalDirName, rel))
+		if err != nil {
+			atomic.AddInt64(&p.Stats.UnsafePaths, 1)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := p.linkOrCopy(src, dst); err != nil {
+			return err
+		}
+	}
+	if n := len(p.external.files); n > 0 {
+		p.log("[INFO] Ассетов CDN скопировано в %s: %d\n", ExternalDirName, n)
+	}
+	return nil
+}
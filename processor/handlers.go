@@ -0,0 +1,128 @@
+package proccesor
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+)
+
+// Inline-обработчики (onclick="location.href='https://example.com/buy'").
+// Обычное переписывание атрибутов и скриптов их не трогает, поэтому клик
+// в копии уводил на живой сайт. Переписываем только ссылки, которые стоят
+// в коде отдельной строкой в кавычках; склейку ('…/p/' + id), шаблоны
+// и ссылки внутри длинных строк безопасно переписать нельзя.
+
+// handlerLiteralRegex — строка в одинарных или двойных кавычках без экранирования
+var handlerLiteralRegex = regexp.MustCompile(`'([^'\\\n]*)'|"([^"\\\n]*)"`)
+
+// isEventHandler — атрибут on*: onclick, onsubmit и т.п.
+func isEventHandler(name string) bool {
+	name = strings.ToLower(name)
+	return len(name) > 2 && strings.HasPrefix(name, "on")
+}
+
+// sameHostURLRegex находит абсолютные и протокол-относительные ссылки на свой хост
+func (p *Processor) sameHostURLRegex() *regexp.Regexp {
+	p.handlerOnce.Do(func() {
+		p.handlerRe = regexp.MustCompile(`(?:https?:)?//(?:www\.)?` + regexp.QuoteMeta(p.cfg.OriginalHost) +
+			`\b[^\s'"` + "`" + `<>()]*`)
+	})
+	return p.handlerRe
+}
+
+// rewriteHandler переписывает ссылки на свой хост в коде обработчика.
+// found — ссылки на хост вообще были; safe — после замены их не осталось.
+func (p *Processor) rewriteHandler(src, code string) (out string, found, safe bool) {
+	if p.cfg.OriginalHost == "" {
+		return code, false, true
+	}
+	re := p.sameHostURLRegex()
+	if !re.MatchString(code) {
+		return code, false, true
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range handlerLiteralRegex.FindAllStringSubmatchIndex(code, -1) {
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		literal := code[start:end]
+		loc := re.FindStringIndex(literal)
+		if loc == nil || loc[0] != 0 || loc[1] != len(literal) || concatenated(code, m[0], m[1]) {
+			continue
+		}
+		local, ok := p.resolveTargetPath(src, literal)
+		if !ok || local == literal {
+			continue
+		}
+		b.WriteString(code[last:start])
+		b.WriteString(local)
+		last = end
+	}
+	b.WriteString(code[last:])
+	out = b.String()
+	return out, true, !re.MatchString(out)
+}
+
+// concatenated — строка code[start:end] участвует в склейке через +
+func concatenated(code string, start, end int) bool {
+	before := strings.TrimRight(code[:start], " \t\r\n")
+	after := strings.TrimLeft(code[end:], " \t\r\n")
+	return strings.HasSuffix(before, "+") || strings.HasPrefix(after, "+")
+}
+
+// processHandlers обходит on*-атрибуты элемента. Что не удалось переписать,
+// убирается (StripUnsafeHandlers) или остаётся и попадает в отчёт о внешних
+// ссылках — там видно, какие клики всё ещё ведут на живой сайт.
+func (p *Processor) processHandlers(src string, n *html.Node) {
+	kept := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace != "" || !isEventHandler(a.Key) {
+			kept = append(kept, a)
+			continue
+		}
+		code, found, safe := p.rewriteHandler(src, a.Val)
+		switch {
+		case !found:
+		case safe:
+			a.Val = code
+			atomic.AddInt64(&p.Stats.HandlersRewritten, 1)
+		case p.cfg.StripUnsafeHandlers:
+			atomic.AddInt64(&p.Stats.HandlersStripped, 1)
+			if p.cfg.Verbose {
+				p.log("[WARN] Убран обработчик %s в %s: %s\n", a.Key, src, a.Val)
+			}
+			continue
+		default:
+			a.Val = code
+			atomic.AddInt64(&p.Stats.HandlersKept, 1)
+			p.collectHandlerLinks(src, n, a)
+		}
+		kept = append(kept, a)
+	}
+	n.Attr = kept
+}
+
+// collectHandlerLinks добавляет в отчёт ссылки на хост, оставшиеся в обработчике
+func (p *Processor) collectHandlerLinks(src string, n *html.Node, a html.Attribute) {
+	if p.outbound == nil {
+		return
+	}
+	source, err := filepath.Rel(p.cfg.Dir, src)
+	if err != nil {
+		source = src
+	}
+	for _, href := range p.sameHostURLRegex().FindAllString(a.Val, -1) {
+		p.outbound.add(OutboundLink{
+			Source: filepath.ToSlash(source),
+			Href:   href,
+			Text:   anchorText(n),
+			Attr:   strings.ToLower(a.Key),
+		})
+	}
+}
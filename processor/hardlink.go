@@ -0,0 +1,60 @@
+package proccesor
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// linkOrCopy кладёт в dst неизменённый файл src. Сначала пробуем жёсткую
+// ссылку (место на диске не удваивается), при ошибке — другой диск,
+// ФС без ссылок — обычное копирование.
+//
+// Исходник и результат потом безопасно удалять по отдельности: удаление
+// одной ссылки не трогает другую. Загрузчик пишет файлы через rename,
+// поэтому докачка не меняет содержимое обработанной копии.
+func (p *Processor) linkOrCopy(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	// Старый dst может сам быть ссылкой — не пишем через него в исходник
+	os.Remove(dst)
+	if err := os.Link(src, dst); err != nil {
+		return copyFile(src, dst)
+	}
+	if info, err := os.Stat(src); err == nil {
+		atomic.AddInt64(&p.Stats.FilesLinked, 1)
+		atomic.AddInt64(&p.Stats.BytesLinked, info.Size())
+	}
+	return nil
+}
+
+// replaceFile записывает изменённый файл новым файлом через rename, а не
+// поверх старого: dst прошлой обработки может быть жёсткой ссылкой на
+// исходник (linkOrCopy), и запись через неё испортила бы загрузку
+func replaceFile(dst string, data []byte) error {
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// formatBytes — размер для лога (1.5 MB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
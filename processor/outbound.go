@@ -0,0 +1,179 @@
+package proccesor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	OutboundCSVFile  = "outbound-links.csv"
+	OutboundJSONFile = "outbound-links.json"
+)
+
+// OutboundLink — внешняя ссылка со страницы сайта (для аудита контента)
+type OutboundLink struct {
+	Source string `json:"source"` // Страница относительно корня сайта
+	Href   string `json:"href"`   // Исходная ссылка, до любых переписываний
+	Text   string `json:"text"`
+	Rel    string `json:"rel"`            // nofollow, sponsored и т.п. как в оригинале
+	Attr   string `json:"attr,omitempty"` // on*-обработчик, если ссылка найдена в нём
+}
+
+// outboundCollector собирает внешние ссылки, без повторов по (source, href)
+type outboundCollector struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	links []OutboundLink
+}
+
+func newOutboundCollector() *outboundCollector {
+	return &outboundCollector{seen: make(map[string]bool)}
+}
+
+func (c *outboundCollector) add(l OutboundLink) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := l.Source + "\x00" + l.Href
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	c.links = append(c.links, l)
+}
+
+func (c *outboundCollector) list() []OutboundLink {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]OutboundLink{}, c.links...)
+}
+
+// isExternalLink — абсолютная http(s) или протокол-относительная ссылка на чужой хост
+func (p *Processor) isExternalLink(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && !strings.HasPrefix(raw, "//") {
+		return false
+	}
+	return !strings.Contains(u.Host, p.cfg.OriginalHost)
+}
+
+// collectOutbound запоминает внешнюю ссылку <a>. Вызывается до переписывания
+// атрибутов, поэтому отчёт не зависит от KeepExternal.
+func (p *Processor) collectOutbound(src string, n *html.Node) {
+	if p.outbound == nil {
+		return
+	}
+	var href, rel, title string
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "href":
+			href = a.Val
+		case "rel":
+			rel = a.Val
+		case "title":
+			title = a.Val
+		}
+	}
+	if href == "" || !p.isExternalLink(href) {
+		return
+	}
+
+	source, err := filepath.Rel(p.cfg.Dir, src)
+	if err != nil {
+		source = src
+	}
+	text := anchorText(n)
+	if text == "" {
+		text = title
+	}
+	p.outbound.add(OutboundLink{
+		Source: filepath.ToSlash(source),
+		Href:   strings.TrimSpace(href),
+		Text:   text,
+		Rel:    strings.Join(strings.Fields(rel), " "),
+	})
+}
+
+// anchorText — видимый текст ссылки; для ссылок-картинок берём alt
+func anchorText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		case n.Type == html.ElementNode && n.Data == "img":
+			for _, a := range n.Attr {
+				if a.Key == "alt" {
+					b.WriteString(a.Val)
+					b.WriteString(" ")
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// writeOutbound сохраняет отчёт в CSV и JSON рядом с результатом
+func (p *Processor) writeOutbound() error {
+	links := p.outbound.list()
+	if links == nil {
+		links = []OutboundLink{}
+	}
+
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(p.cfg.OutputDir, OutboundJSONFile), data, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(p.cfg.OutputDir, OutboundCSVFile))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"source", "href", "text", "rel", "attr"})
+	for _, l := range links {
+		w.Write([]string{l.Source, l.Href, l.Text, l.Rel, l.Attr})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadOutboundLinks читает отчёт о внешних ссылках из обработанной папки
+func ReadOutboundLinks(processedDir string) ([]OutboundLink, error) {
+	var links []OutboundLink
+	data, err := os.ReadFile(filepath.Join(processedDir, OutboundJSONFile))
+	if err != nil {
+		return links, err
+	}
+	err = json.Unmarshal(data, &links)
+	return links, err
+}
@@ -1,6 +1,8 @@
 package proccesor
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -11,10 +13,13 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/html"
+
+	"sitemvp/downloader"
 )
 
 type Config struct {
@@ -25,12 +30,50 @@ type Config struct {
 	Verbose         bool
 	Debug           bool
 	ScriptsToRemove []string
+
+	// Опции пресета (нулевые значения = поведение по умолчанию)
+	Preset        string
+	LinkStyle     string
+	StripExternal bool
+	RemoveMissing bool
+	KeepPHP       bool
+	StripCSP      bool
+	Placeholders  bool
+	Minify        bool
+
+	// Апгрейд http:// → https:// (свой хост + белый список внешних)
+	UpgradeHTTP      bool
+	UpgradeHosts     []string
+	VerifyUpgrades   bool
+	ProtocolRelative bool
+
+	// Убирать cache busters (?v=1.2.3) у ссылок на локальную статику;
+	// параметры из KeepQueryParams остаются всегда
+	StripAssetQueries bool
+	KeepQueryParams   []string
+
+	// Создавать index.html в разделах, скачанных без своей страницы
+	DirIndexes bool
+
+	// Убирать on*-обработчики, в которых ссылку на свой хост не удалось
+	// переписать на локальную (иначе они остаются и попадают в отчёт)
+	StripUnsafeHandlers bool
 }
 
 type Stats struct {
 	TotalFiles     int64
 	FilesProcessed int64
 	LinksRewritten int64
+	LinksUpgraded  int64
+	QueriesStripped int64
+	FilesLinked    int64 // Неизменённые файлы, поставленные жёсткой ссылкой
+	BytesLinked    int64 // Сколько места это сэкономило
+	UnsafePaths    int64 // Пропущены: путь выходил за пределы OutputDir
+	IndexesGenerated int64 // Созданные index.html для разделов без своей страницы
+	HandlersRewritten int64 // on*-обработчики, где ссылки на хост стали локальными
+	HandlersStripped  int64 // Убраны: ссылку на хост не удалось переписать
+	HandlersKept      int64 // Оставлены со ссылкой на живой сайт
+	Recoded           int64 // Страницы, перекодированные в UTF-8
 	StartTime      time.Time
 }
 
@@ -38,6 +81,20 @@ type Processor struct {
 	cfg   Config
 	Stats *Stats // Сделали публичным
 	OnLog func(string)
+
+	upgraderOnce sync.Once
+	httpsUp      *httpsUpgrader
+	probe        *downloader.Prober // Сетевые проверки с настройками загрузчика
+	sameHostOnce sync.Once
+	sameHostRe   *regexp.Regexp
+	handlerOnce  sync.Once
+	handlerRe    *regexp.Regexp
+
+	outbound *outboundCollector
+	external *externalAssets // Ассеты CDN, которые надо скопировать в результат
+
+	indexDirs map[string]bool      // Папки, которым будет создан index.html
+	indexTree map[string]*dirEntry // Структура исходника для этих индексов
 }
 
 func (p *Processor) log(format string, a ...interface{}) {
@@ -49,10 +106,6 @@ func (p *Processor) log(format string, a ...interface{}) {
 	}
 }
 
-var (
-	cssURLRegex = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^'"\)\s]+))\s*\)`)
-)
-
 const (
 	ColorReset  = "\033[0m"
 	ColorRed    = "\033[31m"
@@ -61,61 +114,15 @@ const (
 	ColorYellow = "\033[33m"
 )
 
-func (p *Processor) AnalyzeScripts(dir string) []string {
-    var scripts []string
-    seen := make(map[string]bool)
-
-    filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-        if err != nil || info.IsDir() {
-            return nil
-        }
-
-        ext := strings.ToLower(filepath.Ext(path))
-        if ext == ".html" || ext == ".php" || ext == ".htm" {
-            // ОТКРЫВАЕМ ФАЙЛ КАК ПОТОК
-            f, err := os.Open(path)
-            if err != nil {
-                return nil
-            }
-            defer f.Close() // Гарантированно закрываем дескриптор
-
-            doc, err := html.Parse(f)
-            if err != nil {
-                return nil
-            }
-
-            var findTags func(*html.Node)
-            findTags = func(n *html.Node) {
-                if n.Type == html.ElementNode && n.Data == "script" {
-                    for _, a := range n.Attr {
-                        if a.Key == "src" && a.Val != "" {
-                            if !seen[a.Val] {
-                                scripts = append(scripts, a.Val)
-                                seen[a.Val] = true
-                            }
-                        }
-                    }
-                }
-                for c := n.FirstChild; c != nil; c = c.NextSibling {
-                    findTags(c)
-                }
-            }
-            findTags(doc)
-        }
-        return nil
-    })
-    return scripts
-}
-
 // ЭТОТ МЕТОД НУЖЕН GUI
 func (p *Processor) Process(sourceDir string, scriptsToRemove []string) {
+	p.ProcessContext(context.Background(), sourceDir, scriptsToRemove)
+}
+
+func (p *Processor) process(ctx context.Context, sourceDir string, scriptsToRemove []string) error {
 	if p.Stats == nil {
 		p.Stats = &Stats{StartTime: time.Now()}
 	}
-	// Если OutputDir не задан (вызов из GUI), зададим дефолт
-	if p.cfg.OutputDir == "" {
-		p.cfg.OutputDir = filepath.Clean(sourceDir) + "_processed"
-	}
 	p.cfg.Dir = sourceDir
 
 	// Сохраняем паттерны для удаления
@@ -135,6 +142,9 @@ func (p *Processor) Process(sourceDir string, scriptsToRemove []string) {
 	// Pre-scan for progress
 	var total int64
 	filepath.WalkDir(sourceDir, func(_ string, d os.DirEntry, _ error) error {
+		if d.IsDir() && downloader.IsMetaDir(d.Name()) {
+			return filepath.SkipDir
+		}
 		if !d.IsDir() {
 			total++
 		}
@@ -145,8 +155,51 @@ func (p *Processor) Process(sourceDir string, scriptsToRemove []string) {
 	if len(scriptsToRemove) > 0 {
 		p.log("[INFO] Удаление скриптов: %d паттернов\n", len(scriptsToRemove))
 	}
-	p.walkAndProcess(sourceDir)
+	if p.cfg.Preset != "" {
+		p.log("[INFO] Пресет: %s\n", p.cfg.Preset)
+	}
+	p.outbound = newOutboundCollector()
+	p.external = newExternalAssets()
+	p.indexDirs, p.indexTree = nil, nil
+	if p.cfg.DirIndexes {
+		// До обхода: ссылки на эти папки не должны считаться битыми
+		p.findIndexlessDirs(sourceDir)
+	}
+	if err := p.walkAndProcess(ctx, sourceDir); err != nil {
+		return err
+	}
+	if err := p.writeDirIndexes(sourceDir); err != nil {
+		return err
+	}
+	if err := p.copyExternalAssets(); err != nil {
+		return err
+	}
+	if err := p.writeMarker(); err != nil {
+		p.log("[WARN] Не удалось записать %s: %v\n", MarkerFileName, err)
+	}
+	if err := p.writeOutbound(); err != nil {
+		p.log("[WARN] Не удалось записать %s: %v\n", OutboundJSONFile, err)
+	}
+	if p.cfg.UpgradeHTTP {
+		p.log("[INFO] Ссылок переведено на https: %d\n", atomic.LoadInt64(&p.Stats.LinksUpgraded))
+	}
+	if p.cfg.StripAssetQueries {
+		p.log("[INFO] Убрано cache busters: %d\n", atomic.LoadInt64(&p.Stats.QueriesStripped))
+	}
+	if rewritten, stripped, kept := atomic.LoadInt64(&p.Stats.HandlersRewritten), atomic.LoadInt64(&p.Stats.HandlersStripped), atomic.LoadInt64(&p.Stats.HandlersKept); rewritten+stripped+kept > 0 {
+		p.log("[INFO] Обработчики on* со ссылками на сайт: переписано %d, убрано %d, оставлено %d\n", rewritten, stripped, kept)
+	}
+	if n := atomic.LoadInt64(&p.Stats.IndexesGenerated); n > 0 {
+		p.log("[INFO] Создано индексов разделов: %d\n", n)
+	}
+	if n := atomic.LoadInt64(&p.Stats.Recoded); n > 0 {
+		p.log("[INFO] Страниц перекодировано в UTF-8: %d\n", n)
+	}
+	if linked := atomic.LoadInt64(&p.Stats.FilesLinked); linked > 0 {
+		p.log("[INFO] Жёстких ссылок на исходники: %d, сэкономлено %s\n", linked, formatBytes(atomic.LoadInt64(&p.Stats.BytesLinked)))
+	}
 	p.log("[DONE] Обработка завершена. Файлов: %d, Ссылок: %d\n", atomic.LoadInt64(&p.Stats.FilesProcessed), atomic.LoadInt64(&p.Stats.LinksRewritten))
+	return nil
 }
 
 // Вспомогательный метод для инициализации
@@ -194,7 +247,7 @@ func main() {
 		fmt.Printf("%s[START]%s Обработка: %s -> %s\n", ColorCyan, ColorReset, p.cfg.Dir, p.cfg.OutputDir)
 	}
 
-	p.walkAndProcess(p.cfg.Dir)
+	p.walkAndProcess(context.Background(), p.cfg.Dir)
 	p.printStats()
 }
 
@@ -207,8 +260,21 @@ func (p *Processor) resolveTargetPath(currentFile, rawURL string) (string, bool)
 		return orig, false
 	}
 
+	// 0. Ассеты CDN, скачанные рядом с сайтом, — на их копию в _external
+	if local, ok := p.externalAsset(currentFile, u); ok {
+		return p.localLink(currentFile, u, local), true
+	}
+
 	// 1. Пропускаем внешку и якоря
 	isMyHost := u.Host == "" || strings.Contains(u.Host, p.cfg.OriginalHost)
+	if !isMyHost && p.cfg.StripExternal && (u.Scheme == "http" || u.Scheme == "https" || strings.HasPrefix(trimmedURL, "//")) {
+		return "#", true
+	}
+	if !isMyHost {
+		if upgraded, ok := p.upgradeURL(u); ok {
+			return upgraded, true
+		}
+	}
 	if !isMyHost || strings.HasPrefix(trimmedURL, "data:") ||
 		strings.HasPrefix(trimmedURL, "mailto:") || strings.HasPrefix(trimmedURL, "#") {
 		return orig, true
@@ -220,7 +286,8 @@ func (p *Processor) resolveTargetPath(currentFile, rawURL string) (string, bool)
 	}
 
 	// 3. ПОДГОТОВКА ПУТЕЙ
-	targetPath := u.Path
+	// Имена на диске загрузчик приводит к допустимым в Windows (a:b → a%3Ab)
+	targetPath := downloader.SanitizePath(u.Path)
 	pureName := strings.TrimPrefix(targetPath, "/")
 
 	// Определяем контекст текущей папки относительно корня проекта
@@ -267,11 +334,11 @@ func (p *Processor) resolveTargetPath(currentFile, rawURL string) (string, bool)
 			if _, err := os.Stat(fullPathOnDisk + ".html"); err == nil {
 				finalPath = cleanPath + ".html"
 			} else {
-				// Если ничего не нашли, предполагаем структуру папки (красивая ссылка)
-				finalPath = path.Join(cleanPath, "index.html")
+				// Если ничего не нашли — туда, куда загрузчик сохраняет такой URL
+				finalPath = "/" + downloader.DiskPath(&url.URL{Path: cleanPath}, "")
 			}
-		} else if ext == ".php" {
-			finalPath = pathWithoutExt + ".html"
+		} else if ext == ".php" && !p.cfg.KeepPHP {
+			finalPath = strings.TrimSuffix(cleanPath, ext) + ".html"
 		}
 	}
 
@@ -280,6 +347,15 @@ func (p *Processor) resolveTargetPath(currentFile, rawURL string) (string, bool)
 		finalPath = strings.TrimSuffix(finalPath, "/index.html")
 	}
 
+	// Query у статики — по опции StripAssetQueries
+	local := *u
+	local.RawQuery, local.ForceQuery = p.assetQuery(u, finalPath)
+
+	// Для хостинга от корня (и на странице 404) оставляем абсолютный путь
+	if p.absoluteLinks(currentFile) {
+		return formatResult(&local, path.Clean("/"+finalPath)), true
+	}
+
 	// 8. ПРЕВРАЩАЕМ В ОТНОСИТЕЛЬНЫЙ ПУТЬ
 	// Мы знаем relBase (путь текущей папки от корня) и finalPath (цель от корня)
 	finalRelPath, err := filepath.Rel(relBaseSlash, strings.TrimPrefix(finalPath, "/"))
@@ -294,30 +370,45 @@ func (p *Processor) resolveTargetPath(currentFile, rawURL string) (string, bool)
 		p.log("[FIX] %s -> %s\n", orig, finalRelPath)
 	}
 
-	return formatResult(u, finalRelPath), true
+	return formatResult(&local, finalRelPath), true
 }
 
+// formatResult собирает ссылку из пути на диске: путь кодируется заново
+// (пробел — %20, a%3Ab.txt — a%253Ab.txt, кириллица — %D1%81...)
 func formatResult(u *url.URL, cleanPath string) string {
-	res := cleanPath
-	if u.RawQuery != "" {
+	res := (&url.URL{Path: cleanPath}).EscapedPath()
+	// Пустой "?" тоже сохраняем: на нём держится хак для IE (font.eot?#iefix)
+	if u.RawQuery != "" || u.ForceQuery {
 		res += "?" + u.RawQuery
 	}
 	if u.Fragment != "" {
-		res += "#" + u.Fragment
+		res += "#" + u.EscapedFragment()
 	}
 	return res
 }
 
-func (p *Processor) walkAndProcess(sourceDir string) {
-	filepath.Walk(sourceDir, func(fpath string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+func (p *Processor) walkAndProcess(ctx context.Context, sourceDir string) error {
+	return filepath.Walk(sourceDir, func(fpath string, info os.FileInfo, err error) error {
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		}
+		// Метаданные инкрементального обхода в результат не попадают
+		if err == nil && info.IsDir() && downloader.IsMetaDir(info.Name()) {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() || downloader.IsSiteLockFile(info.Name()) {
 			return nil
 		}
 
 		rel, _ := filepath.Rel(sourceDir, fpath)
-		outPath := filepath.Join(p.cfg.OutputDir, rel)
+		outPath, cerr := downloader.ContainedPath(p.cfg.OutputDir, filepath.ToSlash(rel))
+		if cerr != nil {
+			p.log("[WARN] Пропущен файл вне папки сайта: %v\n", cerr)
+			atomic.AddInt64(&p.Stats.UnsafePaths, 1)
+			return nil
+		}
 
-		if strings.HasSuffix(fpath, ".php") {
+		if strings.HasSuffix(fpath, ".php") && !p.cfg.KeepPHP {
 			outPath = strings.TrimSuffix(outPath, ".php") + ".html"
 		}
 
@@ -330,8 +421,10 @@ func (p *Processor) walkAndProcess(sourceDir string) {
 			_, perr = p.processHTML(fpath, outPath)
 		} else if ext == ".css" {
 			_, perr = p.processCSS(fpath, outPath)
+		} else if ext == ".svg" {
+			_, perr = p.processSVG(fpath, outPath)
 		} else {
-			perr = copyFile(fpath, outPath)
+			perr = p.linkOrCopy(fpath, outPath)
 		}
 
 		atomic.AddInt64(&p.Stats.FilesProcessed, 1)
@@ -340,23 +433,41 @@ func (p *Processor) walkAndProcess(sourceDir string) {
 }
 
 func (p *Processor) processHTML(src, dst string) (bool, error) {
-    // 1. Открываем исходный файл
-    fIn, err := os.Open(src)
+    // 1. Читаем исходный файл
+    data, err := os.ReadFile(src)
     if err != nil {
         return false, err
     }
-    defer fIn.Close()
 
-    // 2. Парсим напрямую из файла
-    doc, err := html.Parse(fIn)
+    // Страницу в другой кодировке парсер прочёл бы как UTF-8
+    if converted, from := downloader.HTMLToUTF8(data, ""); from != "" {
+        atomic.AddInt64(&p.Stats.Recoded, 1)
+        data = converted
+    }
+
+    // 2. Парсим
+    doc, err := html.Parse(bytes.NewReader(data))
     if err != nil {
         return false, err
     }
 
-    // Рекурсивная функция обработки (ссылки и удаление скриптов)
-    var transform func(*html.Node)
-    transform = func(n *html.Node) {
+    // Рекурсивная функция обработки (ссылки и удаление скриптов).
+    // base — действующий xml:base (XHTML), пустой, если его нет.
+    var transform func(n *html.Node, base string)
+    transform = func(n *html.Node, base string) {
+        // Оставшиеся http://<хост> внутри inline-скриптов и стилей
+        if n.Type == html.TextNode && n.Parent != nil && (n.Parent.Data == "script" || n.Parent.Data == "style") {
+            n.Data = p.upgradeText(n.Data)
+        }
+        // <?xml ...?> сохраняем как был, а не комментарием
+        if isProcessingInstruction(n) {
+            p.keepProcessingInstruction(src, n)
+        }
         if n.Type == html.ElementNode {
+            if b, ok := xmlBase(n); ok {
+                base = resolveXMLBase(base, b)
+            }
+
             // Логика удаления скриптов
             if n.Data == "script" && len(p.cfg.ScriptsToRemove) > 0 {
                 srcAttr := ""
@@ -364,7 +475,7 @@ func (p *Processor) processHTML(src, dst string) (bool, error) {
                     if a.Key == "src" { srcAttr = a.Val }
                 }
                 for _, pattern := range p.cfg.ScriptsToRemove {
-                    if strings.Contains(srcAttr, pattern) || (srcAttr == "" && pattern == "inline") {
+                    if scriptMatches(pattern, srcAttr, n) {
                         n.Type = html.CommentNode
                         n.Data = " [Removed Script] "
                         n.Attr = nil
@@ -373,32 +484,80 @@ func (p *Processor) processHTML(src, dst string) (bool, error) {
                 }
             }
 
+            // Content-Security-Policy ломает локальный просмотр
+            if n.Data == "meta" && p.cfg.StripCSP && isCSPMeta(n) {
+                n.Type = html.CommentNode
+                n.Data = " [Removed CSP] "
+                n.Attr = nil
+                return
+            }
+
+            // Внешние ссылки для отчёта — до того, как атрибуты будут переписаны
+            if n.Data == "a" {
+                p.collectOutbound(src, n)
+            }
+
             // Логика исправления ссылок
             for i, a := range n.Attr {
-                if isLinkAttr(n.Data, a.Key) || (a.Key == "content" && isMetaURL(n)) {
-                    newURL, ok := p.resolveTargetPath(src, a.Val)
+                // xlink:href в SVG — та же ссылка, что и href
+                name := linkAttrName(a)
+                if name == "style" {
+                    n.Attr[i].Val = p.upgradeText(a.Val)
+                    continue
+                }
+                // Редирект через refresh: адрес внутри content, задержка остаётся
+                if name == "content" && downloader.IsRefreshMeta(n) {
+                    before, target, after, ok := downloader.SplitRefresh(a.Val)
+                    if !ok {
+                        continue
+                    }
+                    if newURL, ok := p.resolveTargetPath(src, resolveXMLBase(base, target)); ok && newURL != target {
+                        n.Attr[i].Val = before + newURL + after
+                        atomic.AddInt64(&p.Stats.LinksRewritten, 1)
+                    }
+                    continue
+                }
+                if isLinkAttr(n.Data, name) || (name == "content" && isMetaURL(n)) {
+                    val := a.Val
+                    if name != "srcset" {
+                        val = resolveXMLBase(base, val)
+                    }
+                    newURL, ok := p.resolveTargetPath(src, val)
+                    if ok && (name == "href" || name == "src") && p.isMissing(src, newURL) {
+                        if p.cfg.Placeholders && n.Data == "img" && name == "src" {
+                            newURL = placeholderImage
+                        } else if p.cfg.RemoveMissing && name == "href" {
+                            newURL = "#"
+                        }
+                    }
+                    if !ok && val != a.Val {
+                        // Ссылку не переписали, но база из xml:base уже применена
+                        newURL, ok = val, true
+                    }
                     if ok && newURL != a.Val {
                         n.Attr[i].Val = newURL
                         atomic.AddInt64(&p.Stats.LinksRewritten, 1)
                     }
                 }
             }
+            p.processHandlers(src, n)
         }
         for c := n.FirstChild; c != nil; c = c.NextSibling {
-            transform(c)
+            transform(c, base)
         }
     }
-    transform(doc)
+    transform(doc, "")
+
+    if p.cfg.Minify {
+        minifyNode(doc)
+    }
 
     // 3. Сохраняем результат
-    fOut, err := os.Create(dst)
-    if err != nil {
+    var out bytes.Buffer
+    if err := html.Render(&out, doc); err != nil {
         return false, err
     }
-    defer fOut.Close()
-
-    err = html.Render(fOut, doc)
-    return true, err
+    return true, replaceFile(dst, out.Bytes())
 }
 
 func (p *Processor) processCSS(src, dst string) (bool, error) {
@@ -406,36 +565,131 @@ func (p *Processor) processCSS(src, dst string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	content := string(b)
-	newContent := cssURLRegex.ReplaceAllStringFunc(content, func(m string) string {
-		match := cssURLRegex.FindStringSubmatch(m)
-		if len(match) < 2 {
-			return m
-		}
-		raw := ""
-		if match[1] != "" {
-			raw = match[1]
-		} else if match[2] != "" {
-			raw = match[2]
-		} else if match[3] != "" {
-			raw = match[3]
-		}
-		if raw == "" {
-			return m
+	newContent := p.rewriteCSSURLs(src, string(b))
+	newContent = p.upgradeText(newContent)
+	if newContent == string(b) {
+		return false, p.linkOrCopy(src, dst)
+	}
+	return true, replaceFile(dst, []byte(newContent))
+}
+
+// rewriteCSSURLs переписывает url(...) и @import "..." относительно файла src.
+// Меняется только сама ссылка: кавычки, пробелы, format(), local()
+// и порядок кандидатов в src остаются байт в байт.
+func (p *Processor) rewriteCSSURLs(src, content string) string {
+	var b strings.Builder
+	last := 0
+	for _, ref := range downloader.CSSRefs(content) {
+		start, end := ref[0], ref[1]
+		if start < last {
+			continue // url() внутри строки @import — уже переписан
 		}
+		raw := content[start:end]
 		newURL, ok := p.resolveTargetPath(src, raw)
-		if ok {
-			return strings.Replace(m, raw, newURL, 1)
+		if !ok || newURL == raw {
+			continue
 		}
-		return m
-	})
-	return true, ioutil.WriteFile(dst, []byte(newContent), 0644)
+		b.WriteString(content[last:start])
+		b.WriteString(newURL)
+		last = end
+	}
+	if last == 0 {
+		return content
+	}
+	b.WriteString(content[last:])
+	return b.String()
 }
 
 func isLinkAttr(tag, attr string) bool {
 	return attr == "href" || attr == "src" || attr == "srcset" || attr == "action"
 }
 
+// placeholderImage — прозрачный GIF 1x1 вместо отсутствующих картинок
+const placeholderImage = "data:image/gif;base64,R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+
+// isMissing проверяет, что локальная ссылка (уже переписанная) указывает
+// на файл, которого нет в исходной папке.
+func (p *Processor) isMissing(currentFile, link string) bool {
+	if !p.cfg.RemoveMissing && !p.cfg.Placeholders {
+		return false
+	}
+	if link == "" || strings.Contains(link, "://") || strings.HasPrefix(link, "//") ||
+		strings.HasPrefix(link, "#") || strings.HasPrefix(link, "data:") ||
+		strings.HasPrefix(link, "mailto:") || strings.HasPrefix(link, "tel:") ||
+		strings.HasPrefix(link, "javascript:") {
+		return false
+	}
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		link = link[:i]
+	}
+	if decoded, err := url.PathUnescape(link); err == nil {
+		link = decoded
+	}
+
+	var diskPath string
+	if strings.HasPrefix(link, "/") {
+		diskPath = filepath.Join(p.cfg.Dir, filepath.FromSlash(link))
+	} else {
+		diskPath = filepath.Join(filepath.Dir(currentFile), filepath.FromSlash(link))
+	}
+	if _, err := os.Stat(diskPath); err == nil {
+		return false
+	}
+	// Копию ассета CDN процессор положит в _external сам
+	if rel, err := filepath.Rel(p.cfg.Dir, diskPath); err == nil && strings.HasPrefix(filepath.ToSlash(rel), ExternalDirName+"/") {
+		return false
+	}
+	// Индекс раздела создаст сам процессор
+	if filepath.Base(diskPath) == "index.html" {
+		if rel, err := filepath.Rel(p.cfg.Dir, filepath.Dir(diskPath)); err == nil && p.hasGeneratedIndex(rel) {
+			return false
+		}
+	}
+	// Страница могла быть сохранена как .php и будет переименована
+	if strings.HasSuffix(diskPath, ".html") {
+		if _, err := os.Stat(strings.TrimSuffix(diskPath, ".html") + ".php"); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func isCSPMeta(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if a.Key == "http-equiv" && strings.EqualFold(a.Val, "content-security-policy") {
+			return true
+		}
+	}
+	return false
+}
+
+// minifyNode удаляет комментарии и пустые текстовые узлы (кроме pre/textarea/script/style)
+func minifyNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" && !keepsWhitespace(n):
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && !keepsWhitespace(c):
+			minifyNode(c)
+		}
+		c = next
+	}
+}
+
+func keepsWhitespace(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "pre", "textarea", "script", "style":
+		return true
+	}
+	return preservesSpace(n)
+}
+
 func isMetaURL(n *html.Node) bool {
 	for _, a := range n.Attr {
 		if (a.Key == "property" || a.Key == "name") &&
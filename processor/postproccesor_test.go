@@ -1,8 +1,21 @@
 package proccesor
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+
+	"sitemvp/downloader"
+	"sitemvp/internal/sitetest"
 )
 
 func TestResolveTargetPath(t *testing.T) {
@@ -123,3 +136,891 @@ func setupTestData() {
 	os.WriteFile("testdata/study/beginning/index.html", []byte(""), 0644)
 	os.WriteFile("testdata/study/advanced/index.html", []byte(""), 0644)
 }
+
+func TestUpgradeHTTP(t *testing.T) {
+	p := &Processor{
+		cfg: Config{
+			Dir:          "testdata",
+			OriginalHost: "gopedia.ru",
+			UpgradeHTTP:  true,
+			UpgradeHosts: []string{"cdn.example.com"},
+		},
+		Stats: &Stats{},
+	}
+
+	result, _ := p.resolveTargetPath("testdata/index.html", "http://cdn.example.com/lib.js")
+	if result != "https://cdn.example.com/lib.js" {
+		t.Errorf("Whitelisted host not upgraded: %s", result)
+	}
+
+	result, _ = p.resolveTargetPath("testdata/index.html", "http://other.com/lib.js")
+	if result != "http://other.com/lib.js" {
+		t.Errorf("Non-whitelisted host must stay untouched: %s", result)
+	}
+
+	text := p.upgradeText(`fetch("http://gopedia.ru/api")`)
+	if text != `fetch("https://gopedia.ru/api")` {
+		t.Errorf("Same host not upgraded in text: %s", text)
+	}
+
+	p.cfg.ProtocolRelative = true
+	text = p.upgradeText(`url(http://gopedia.ru/bg.png)`)
+	if text != `url(//gopedia.ru/bg.png)` {
+		t.Errorf("Protocol-relative output expected: %s", text)
+	}
+
+	if p.Stats.LinksUpgraded != 3 {
+		t.Errorf("Expected 3 upgraded links, got %d", p.Stats.LinksUpgraded)
+	}
+}
+
+func TestUpgradeVerifiedByManifest(t *testing.T) {
+	out := t.TempDir()
+	site := filepath.Join(out, "example.com")
+	os.MkdirAll(site, 0755)
+	manifest := `{"url":"https://cdn.invalid/lib.js","path":"../cdn.invalid/lib.js"}` + "\n"
+	os.WriteFile(filepath.Join(out, "abcd1234"+downloader.ManifestExtension), []byte(manifest), 0644)
+
+	// Хост из манифеста подтверждён без сети; остальные проверяются HEAD,
+	// а до .invalid он не дойдёт
+	u := newHTTPSUpgrader([]string{"cdn.invalid", "other.invalid"}, true, nil, site)
+	if !u.allowed("CDN.invalid") {
+		t.Error("Host fetched over https must be upgraded without a probe")
+	}
+	if u.allowed("other.invalid") {
+		t.Error("Host unknown to the manifest falls back to HEAD")
+	}
+}
+
+func TestProcessSVG(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "img"), 0755)
+	os.MkdirAll(filepath.Join(dir, "art"), 0755)
+
+	sprite := filepath.Join(dir, "icons.svg")
+	os.WriteFile(sprite, []byte(`<svg xmlns:xlink="http://www.w3.org/1999/xlink">
+<style><![CDATA[.bg { fill: url(/img/pattern.png); }]]></style>
+<symbol id="home"><path d="M0 0"/></symbol>
+<symbol id="logo"><use xlink:href="#home"/><image href="/img/logo.png"/></symbol>
+</svg>`), 0644)
+
+	illustration := filepath.Join(dir, "art", "scene.svg")
+	os.WriteFile(illustration, []byte(`<svg xmlns:xlink="http://www.w3.org/1999/xlink">
+<image xlink:href='/img/photo.jpg'/><rect style="fill: url(#shade)"/>
+</svg>`), 0644)
+
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	out := filepath.Join(t.TempDir(), "out.svg")
+	if _, err := p.processSVG(sprite, out); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(out)
+	for _, want := range []string{`xlink:href="#home"`, `href="img/logo.png"`, `url(img/pattern.png)`, `<![CDATA[`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Sprite output missing %q:\n%s", want, b)
+		}
+	}
+
+	if _, err := p.processSVG(illustration, out); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(out)
+	for _, want := range []string{`xlink:href='../img/photo.jpg'`, `url(#shade)`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Illustration output missing %q:\n%s", want, b)
+		}
+	}
+}
+
+// Реальные @font-face из популярных библиотек: ссылки относительно css/,
+// поэтому после обработки файл должен остаться байт в байт
+var fontFaceCorpus = []string{
+	// Font Awesome 4.7
+	`@font-face {
+  font-family: 'FontAwesome';
+  src: url('../fonts/fontawesome-webfont.eot?v=4.7.0');
+  src: url('../fonts/fontawesome-webfont.eot?#iefix&v=4.7.0') format('embedded-opentype'), url('../fonts/fontawesome-webfont.woff2?v=4.7.0') format('woff2'), url('../fonts/fontawesome-webfont.woff?v=4.7.0') format('woff'), url('../fonts/fontawesome-webfont.ttf?v=4.7.0') format('truetype'), url('../fonts/fontawesome-webfont.svg?v=4.7.0#fontawesomeregular') format('svg');
+  font-weight: normal;
+  font-style: normal;
+}`,
+	// Bootstrap 3 glyphicons
+	`@font-face{font-family:'Glyphicons Halflings';src:url(../fonts/glyphicons-halflings-regular.eot);src:url(../fonts/glyphicons-halflings-regular.eot?#iefix) format('embedded-opentype'),url(../fonts/glyphicons-halflings-regular.woff2) format('woff2'),url(../fonts/glyphicons-halflings-regular.svg#glyphicons_halflingsregular) format('svg')}`,
+	// Google Fonts с unicode-range и local()
+	`/* cyrillic */
+@font-face {
+  font-family: 'Roboto';
+  font-style: normal;
+  font-weight: 400;
+  font-display: swap;
+  src: local("Roboto"), local('Roboto-Regular'), url( "../fonts/roboto-cyrillic.woff2" ) format("woff2");
+  unicode-range: U+0301, U+0400-045F, U+0490-0491, U+04B0-04B1, U+2116;
+}`,
+	// Внешний CDN не трогаем
+	`@font-face { font-family: Inter; src: url(https://rsms.me/inter/font-files/Inter-Regular.woff2?v=3.19) format("woff2"); }`,
+}
+
+func TestRewriteCSSPreservesFontFace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "css", "style.css")
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	for i, css := range fontFaceCorpus {
+		if got := p.rewriteCSSURLs(src, css); got != css {
+			t.Errorf("Corpus #%d changed:\nwant: %s\ngot:  %s", i, css, got)
+		}
+	}
+
+	// Абсолютные пути меняются только внутри url(), всё вокруг — как было
+	in := `src: url("/fonts/a.eot?#iefix") format("embedded-opentype"),url(/fonts/a.woff2?v=1) format("woff2"), local(a);`
+	want := `src: url("../fonts/a.eot?#iefix") format("embedded-opentype"),url(../fonts/a.woff2?v=1) format("woff2"), local(a);`
+	if got := p.rewriteCSSURLs(src, in); got != want {
+		t.Errorf("\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestRewriteCSSImports(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "css", "style.css")
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	in := `@import "/css/theme.css"; @import '/print.css' print; @import url("/css/reset.css"); @import "theme.css";`
+	want := `@import "theme.css"; @import '../print.css' print; @import url("reset.css"); @import "theme.css";`
+	if got := p.rewriteCSSURLs(src, in); got != want {
+		t.Errorf("\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestOutboundLinksReport(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<html><body>
+<a href="https://partner.com/deal" rel="sponsored  nofollow">Best <b>deal</b></a>
+<a href="https://partner.com/deal">again</a>
+<a href="//cdn.other.org/x"><img src="/logo.png" alt="Other logo"></a>
+<a href="https://example.com/about">internal</a>
+<a href="/contacts">internal</a>
+</body></html>`), 0644)
+
+	for _, strip := range []bool{false, true} {
+		out := filepath.Join(t.TempDir(), "out")
+		p := NewProcessor("example.com")
+		p.cfg.OutputDir = out
+		p.cfg.StripExternal = strip
+		p.Process(src, nil)
+
+		links, err := ReadOutboundLinks(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []OutboundLink{
+			{Source: "index.html", Href: "https://partner.com/deal", Text: "Best deal", Rel: "sponsored nofollow"},
+			{Source: "index.html", Href: "//cdn.other.org/x", Text: "Other logo"},
+		}
+		if len(links) != len(want) {
+			t.Fatalf("strip=%v: expected %d links, got %+v", strip, len(want), links)
+		}
+		for i := range want {
+			if links[i] != want[i] {
+				t.Errorf("strip=%v: link %d: expected %+v, got %+v", strip, i, want[i], links[i])
+			}
+		}
+		if _, err := os.Stat(filepath.Join(out, OutboundCSVFile)); err != nil {
+			t.Errorf("CSV report missing: %v", err)
+		}
+	}
+}
+
+func TestStripAssetQueries(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.html")
+	p := &Processor{
+		cfg: Config{
+			Dir:               dir,
+			OriginalHost:      "example.com",
+			StripAssetQueries: true,
+			KeepQueryParams:   []string{"family"},
+		},
+		Stats: &Stats{},
+	}
+
+	cases := []struct{ in, want string }{
+		{"/css/app.css?v=1.2.3", "css/app.css"},
+		{"/js/app.js?ver=5&family=Roboto#top", "js/app.js?family=Roboto#top"},
+		{"/fonts/a.eot?#iefix", "fonts/a.eot?#iefix"},
+		{"/img/logo.png", "img/logo.png"},
+		{"/search.html?q=go", "search.html?q=go"},
+	}
+	for _, c := range cases {
+		if got, _ := p.resolveTargetPath(page, c.in); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.in, c.want, got)
+		}
+	}
+	if p.Stats.QueriesStripped != 2 {
+		t.Errorf("Expected 2 stripped queries, got %d", p.Stats.QueriesStripped)
+	}
+
+	p.cfg.StripAssetQueries = false
+	if got, _ := p.resolveTargetPath(page, "/css/app.css?v=1"); got != "css/app.css?v=1" {
+		t.Errorf("Query must stay when option is off: %s", got)
+	}
+}
+
+func TestResolveSanitizedNames(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.html")
+	// Так загрузчик сохраняет /files/a:b.css и /con/notes.txt
+	for _, rel := range []string{"files/a%3Ab.css", "con_/notes.txt"} {
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte("x"), 0644)
+	}
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	cases := []struct{ in, want string }{
+		{"/files/a:b.css", "files/a%253Ab.css"},
+		{"https://example.com/files/a%3Ab.css#x", "files/a%253Ab.css#x"},
+		{"files/a%253Ab.css", "files/a%253Ab.css"}, // Ссылка, уже переписанная загрузчиком
+		{"/con/notes.txt", "con_/notes.txt"},
+	}
+	for _, c := range cases {
+		if got, _ := p.resolveTargetPath(page, c.in); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.in, c.want, got)
+		}
+	}
+}
+
+func TestResolveEncodedPaths(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.html")
+	// Загрузчик хранит пути раскодированными
+	for _, rel := range []string{"статьи/обзор/index.html", "my page/index.html", "c++/a+b.css"} {
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte("x"), 0644)
+	}
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com", RemoveMissing: true}, Stats: &Stats{}}
+
+	article := "%D1%81%D1%82%D0%B0%D1%82%D1%8C%D0%B8/%D0%BE%D0%B1%D0%B7%D0%BE%D1%80/index.html"
+	cases := []struct{ in, want string }{
+		{"/статьи/обзор", article},
+		{"/%d1%81%d1%82%d0%b0%d1%82%d1%8c%d0%b8/%d0%be%d0%b1%d0%b7%d0%be%d1%80/", article},
+		{"/my%20page/", "my%20page/index.html"},
+		{"/my page", "my%20page/index.html"},
+		{"/c++/a+b.css", "c++/a+b.css"},
+		{"/c%2B%2B/a%2Bb.css", "c++/a+b.css"},
+	}
+	for _, c := range cases {
+		got, _ := p.resolveTargetPath(page, c.in)
+		if got != c.want {
+			t.Errorf("%s: want %s, got %s", c.in, c.want, got)
+		}
+		// Ссылка, которую записал процессор, находит файл
+		if p.isMissing(page, got) {
+			t.Errorf("%s: rewritten link %s reported missing", c.in, got)
+		}
+	}
+}
+
+// killAfter — контекст, который "умирает" после n проверок Err()
+type killAfter struct {
+	context.Context
+	n int
+}
+
+func (k *killAfter) Err() error {
+	if k.n--; k.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestProcessKeepsOutputOnFailure(t *testing.T) {
+	src := t.TempDir()
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(src, fmt.Sprintf("page%02d.html", i)), []byte(`<a href="/">home</a>`), 0644)
+	}
+	out := filepath.Join(t.TempDir(), "site_processed")
+
+	p := NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), src, nil); err != nil {
+		t.Fatalf("First run failed: %v", err)
+	}
+	good, _ := os.ReadFile(filepath.Join(out, "page00.html"))
+	before, _ := os.ReadDir(out)
+
+	os.WriteFile(filepath.Join(src, "page00.html"), []byte(`<a href="/changed">x</a>`), 0644)
+	p = NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	ctx := &killAfter{Context: context.Background(), n: 5}
+	if err := p.ProcessContext(ctx, src, nil); err == nil {
+		t.Fatal("Expected error from killed run")
+	}
+
+	entries, _ := os.ReadDir(out)
+	if len(entries) != len(before) {
+		t.Errorf("Old output damaged: %d entries, want %d", len(entries), len(before))
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "page00.html")); string(got) != string(good) {
+		t.Errorf("Old output changed:\nwant: %s\ngot:  %s", good, got)
+	}
+	for _, suffix := range []string{TempDirSuffix, OldDirSuffix} {
+		if _, err := os.Stat(out + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s must be cleaned up", out+suffix)
+		}
+	}
+
+	p = NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), src, nil); err != nil {
+		t.Fatalf("Third run failed: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "page00.html")); string(got) == string(good) {
+		t.Error("Successful run must replace the output")
+	}
+}
+
+func TestUnchangedFilesAreHardLinked(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "css"), 0755)
+	os.MkdirAll(filepath.Join(src, "img"), 0755)
+	logo := make([]byte, 4096)
+	os.WriteFile(filepath.Join(src, "img", "logo.png"), logo, 0644)
+	os.WriteFile(filepath.Join(src, "css", "plain.css"), []byte(`body{color:red}`), 0644)
+	os.WriteFile(filepath.Join(src, "css", "site.css"), []byte(`body{background:url(/img/logo.png)}`), 0644)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<img src="/img/logo.png">`), 0644)
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	same := func(rel string) bool {
+		a, errA := os.Stat(filepath.Join(src, rel))
+		b, errB := os.Stat(filepath.Join(out, rel))
+		return errA == nil && errB == nil && os.SameFile(a, b)
+	}
+	if !same("img/logo.png") || !same("css/plain.css") {
+		t.Error("Unchanged files must be hard links to the source")
+	}
+	if same("css/site.css") || same("index.html") {
+		t.Error("Rewritten files must not share the source inode")
+	}
+	if want := int64(len(logo) + len(`body{color:red}`)); p.Stats.BytesLinked != want {
+		t.Errorf("Expected %d bytes saved, got %d", want, p.Stats.BytesLinked)
+	}
+
+	// Повторная обработка пишет изменённый файл заново, а не через ссылку
+	// прошлого запуска в исходник
+	os.WriteFile(filepath.Join(src, "page.svg"), []byte(`<svg><image href="/img/logo.png"/></svg>`), 0644)
+	for _, c := range []struct {
+		process func(src, dst string) (bool, error)
+		from    string
+	}{{p.processCSS, "css/site.css"}, {p.processHTML, "index.html"}, {p.processSVG, "page.svg"}} {
+		dst := filepath.Join(out, "css", "plain.css")
+		os.Remove(dst)
+		if err := os.Link(filepath.Join(src, "css", "plain.css"), dst); err != nil {
+			t.Skipf("no hard links: %v", err)
+		}
+		if changed, err := c.process(filepath.Join(src, filepath.FromSlash(c.from)), dst); err != nil || !changed {
+			t.Fatalf("%s: changed %v, %v", c.from, changed, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(src, "css", "plain.css")); string(data) != `body{color:red}` {
+			t.Fatalf("%s written through a hard link into the source: %q", c.from, data)
+		}
+	}
+
+	// Удаление результата не трогает исходник
+	os.RemoveAll(out)
+	if data, err := os.ReadFile(filepath.Join(src, "img", "logo.png")); err != nil || len(data) != len(logo) {
+		t.Errorf("Source damaged after deleting output: %v", err)
+	}
+}
+
+func TestProcessRespectsSiteLock(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<a href="/">home</a>`), 0644)
+	out := filepath.Join(t.TempDir(), "out")
+
+	lock, err := downloader.LockSite(src, "job 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), src, nil); !errors.Is(err, downloader.ErrSiteInUse) {
+		t.Fatalf("Expected site in use error, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("Locked site must not be processed")
+	}
+
+	lock.Unlock()
+	if err := p.ProcessContext(context.Background(), src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, downloader.SiteLockFileName)); !os.IsNotExist(err) {
+		t.Error("Lock file must not be copied into the output")
+	}
+	if _, err := os.Stat(filepath.Join(src, downloader.SiteLockFileName)); !os.IsNotExist(err) {
+		t.Error("Processor must release the lock")
+	}
+}
+
+func TestGeneratedDirIndexes(t *testing.T) {
+	src := t.TempDir()
+	write := func(rel, body string) {
+		full := filepath.Join(src, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte(body), 0644)
+	}
+	write("index.html", `<a href="/blog/">Blog</a>`)
+	write("blog/post-1/index.html", `<title>First &amp; best</title><a href="../">up</a>`)
+	write("blog/post-2/index.html", `<html><head><title> Second
+		post </title></head></html>`)
+	write("blog/archive.php", `<p>no title</p>`)
+	write("docs/guide/index.html", `<title>Guide</title>`)
+	write("img/logo.png", "png")
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor("example.com")
+	p.ApplyPreset(ProcessingPreset{LinkStyle: LinkStyleRelative, ConvertPHP: true, RemoveMissing: true, GenerateIndexes: true})
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	blog, err := os.ReadFile(filepath.Join(out, "blog", "index.html"))
+	if err != nil {
+		t.Fatal("Expected generated index for /blog/")
+	}
+	for _, want := range []string{
+		GeneratedIndexMeta,
+		`href="post-1/index.html">First &amp; best/</a>`,
+		`href="post-2/index.html">Second post/</a>`,
+		`href="archive.html">archive.php</a>`,
+		`href="../index.html"`,
+		"generated by SiteCloner",
+	} {
+		if !strings.Contains(string(blog), want) {
+			t.Errorf("Generated index lacks %q:\n%s", want, blog)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "docs", "index.html")); err != nil {
+		t.Error("Expected index for /docs/ with pages only in subfolders")
+	}
+	for _, rel := range []string{"index.html", "img/index.html", "blog/post-1/index.html"} {
+		data, _ := os.ReadFile(filepath.Join(out, filepath.FromSlash(rel)))
+		if strings.Contains(string(data), GeneratedIndexMeta) {
+			t.Errorf("%s must not be generated", rel)
+		}
+	}
+	if p.Stats.IndexesGenerated != 2 {
+		t.Errorf("Expected 2 generated indexes, got %d", p.Stats.IndexesGenerated)
+	}
+
+	// Ссылка вверх на раздел больше не считается битой
+	post, _ := os.ReadFile(filepath.Join(out, "blog", "post-1", "index.html"))
+	if !strings.Contains(string(post), `href="../index.html"`) {
+		t.Errorf("Link to the section must be kept:\n%s", post)
+	}
+	marker, err := ReadMarker(out)
+	if err != nil || strings.Join(marker.GeneratedIndexes, ",") != "blog,docs" || !marker.Preset.GenerateIndexes {
+		t.Errorf("Unexpected marker: %+v (err %v)", marker, err)
+	}
+}
+
+func TestSlashVariantsResolveAfterProcessing(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// /about отдаётся как текст, /about/ — как страница: на диске им нужны файл и папка с одним именем
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/about":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "About us")
+		case "/about/", "/about/team/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/">home</a></body></html>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a id="a" href="/about">a</a><a id="b" href="/about/">b</a><a href="/about/team/">c</a></body></html>`)
+		}
+	}))
+	defer srv.Close()
+
+	dl := t.TempDir()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if _, err := downloader.Run(context.Background(), downloader.RunOptions{
+		URL:    srv.URL + "/",
+		Config: downloader.Config{Workers: 2, MaxDepth: 2, Retries: 1, OutputDir: dl},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor(host)
+	p.ApplyPreset(ProcessingPreset{LinkStyle: LinkStyleRelative, ConvertPHP: true, RemoveMissing: true})
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), filepath.Join(dl, host), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	index, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	for _, id := range []string{"a", "b"} {
+		m := regexp.MustCompile(`id="` + id + `" href="([^"]*)"`).FindStringSubmatch(string(index))
+		if m == nil {
+			t.Fatalf("Link %s not found in:\n%s", id, index)
+		}
+		target := filepath.Join(out, filepath.FromSlash(m[1]))
+		if fi, err := os.Stat(target); err != nil || fi.IsDir() {
+			t.Errorf("Link %s -> %q does not resolve to a file", id, m[1])
+		}
+	}
+}
+
+func TestAnalyzeScripts(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "js"), 0755)
+	os.MkdirAll(filepath.Join(src, "blog"), 0755)
+	os.WriteFile(filepath.Join(src, "js", "app.js"), []byte(`console.log(1)`), 0644)
+	page := `<html><head>
+<script src="/js/app.js"></script>
+<script src="https://www.googletagmanager.com/gtag/js?id=G-1"></script>
+<script>
+  window.dataLayer = window.dataLayer || []; gtag('config', 'G-1');
+</script>
+</head><body><script src="/js/gone.js"></script></body></html>`
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(page), 0644)
+	// Тот же inline-блок с другими отступами — тот же ID
+	os.WriteFile(filepath.Join(src, "blog", "post.html"), []byte(`<script src="../js/app.js"></script>
+<script>window.dataLayer = window.dataLayer || []; gtag('config', 'G-1');</script>`), 0644)
+
+	scripts := NewProcessor("example.com").AnalyzeScripts(src)
+	byID := map[string]ScriptInfo{}
+	for _, s := range scripts {
+		byID[s.ID] = s
+	}
+
+	inlineID := InlineScriptID(`window.dataLayer = window.dataLayer || []; gtag('config', 'G-1');`)
+	inline, ok := byID[inlineID]
+	if !ok || !inline.Inline || inline.Pages != 2 || inline.Tracker != "Google Analytics" || inline.Preview == "" {
+		t.Errorf("Inline script: %+v", inline)
+	}
+	if app := byID["/js/app.js"]; !app.Exists || app.Size != int64(len(`console.log(1)`)) || app.External || app.Pages != 1 {
+		t.Errorf("Local script: %+v", app)
+	}
+	// Относительный путь к тому же файлу — отдельная запись, но тоже найден
+	if rel := byID["../js/app.js"]; !rel.Exists {
+		t.Errorf("Relative script not resolved: %+v", rel)
+	}
+	if gtm := byID["https://www.googletagmanager.com/gtag/js?id=G-1"]; !gtm.External || gtm.Exists || gtm.Tracker != "Google Tag Manager" {
+		t.Errorf("External script: %+v", gtm)
+	}
+	if gone := byID["/js/gone.js"]; gone.Exists || gone.External {
+		t.Errorf("Missing script: %+v", gone)
+	}
+	if len(scripts) != 5 || scripts[0].Pages != 2 {
+		t.Errorf("Expected 5 scripts, most used first, got %+v", scripts)
+	}
+}
+
+func TestRemoveInlineScriptByID(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<html><head>
+<script>var keep = 1;</script>
+<script>ym(123, "init");</script>
+<script src="/js/helper.js"></script>
+</head><body></body></html>`), 0644)
+
+	run := func(patterns ...string) string {
+		out := filepath.Join(t.TempDir(), "out")
+		p := NewProcessor("example.com")
+		p.cfg.OutputDir = out
+		if err := p.ProcessContext(context.Background(), src, patterns); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(filepath.Join(out, "index.html"))
+		return string(data)
+	}
+
+	html := run(InlineScriptID(`ym(123, "init");`))
+	if strings.Contains(html, "ym(123") || !strings.Contains(html, "var keep = 1") || !strings.Contains(html, "helper.js") {
+		t.Errorf("Only the targeted inline block must be removed:\n%s", html)
+	}
+
+	html = run("inline")
+	if strings.Contains(html, "ym(123") || strings.Contains(html, "var keep") {
+		t.Errorf("\"inline\" must remove every inline script:\n%s", html)
+	}
+	if !strings.Contains(html, "helper.js") {
+		t.Errorf("\"inline\" must keep scripts with src:\n%s", html)
+	}
+}
+
+// xhtmlFixture — страница старого сайта: XML-декларация, xml:lang,
+// самозакрывающиеся теги, встроенный SVG с xlink:href и xml:base
+const xhtmlFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<?xml-stylesheet type="text/css" href="/css/print.css"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="ru" xmlns:xlink="http://www.w3.org/1999/xlink">
+<head>
+<title>XHTML</title>
+<link rel="stylesheet" type="text/css" href="/css/site.css" />
+</head>
+<body>
+<p>Старый сайт<br />на XHTML</p>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 20" width="100" height="20">
+<defs><linearGradient id="shade"><stop offset="0" stop-color="#fff" /></linearGradient></defs>
+<use xlink:href="/img/icons.svg#logo" />
+<a xlink:href="/about.html"><image xlink:href="/img/photo.png" width="10" height="10" /></a>
+<text x="0" y="15" xml:space="preserve"><tspan>Site</tspan> <tspan>Cloner</tspan></text>
+</svg>
+<div xml:base="/docs/">
+<img src="diagram.png" alt="diagram" />
+<a href="#top">наверх</a>
+</div>
+</body>
+</html>
+`
+
+func TestProcessXHTMLWithInlineSVG(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"css/print.css", "css/site.css", "img/icons.svg", "img/photo.png", "docs/diagram.png", "about.html"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755)
+		os.WriteFile(filepath.Join(dir, f), []byte("x"), 0644)
+	}
+	src := filepath.Join(dir, "index.html")
+	os.WriteFile(src, []byte(xhtmlFixture), 0644)
+
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com", Minify: true}, Stats: &Stats{}}
+	out := filepath.Join(t.TempDir(), "out.html")
+	if _, err := p.processHTML(src, out); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(out)
+	got := string(b)
+
+	if !strings.HasPrefix(got, `<?xml version="1.0" encoding="UTF-8"?><?xml-stylesheet type="text/css" href="css/print.css"?>`) {
+		t.Errorf("XML declaration must stay a processing instruction:\n%s", got)
+	}
+	for _, want := range []string{
+		`xml:lang="ru"`,
+		`xmlns:xlink="http://www.w3.org/1999/xlink"`,
+		`viewBox="0 0 100 20"`,
+		`<use xlink:href="img/icons.svg#logo">`,
+		`<a xlink:href="about.html">`,
+		`<image xlink:href="img/photo.png"`,
+		`xml:space="preserve"><tspan>Site</tspan> <tspan>Cloner</tspan>`,
+		`href="css/site.css"`,
+		// Ссылки под xml:base приведены к его адресу, сам атрибут снят
+		`<div><img src="docs/diagram.png"`,
+		`<a href="#top">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<!--?xml") || strings.Contains(got, "xml:base") {
+		t.Errorf("XHTML markup corrupted:\n%s", got)
+	}
+}
+
+func TestEventHandlerLinks(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "buy"), 0755)
+	os.WriteFile(filepath.Join(src, "buy", "index.html"), []byte(`<html></html>`), 0644)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<html><body>
+<button onclick="location.href='https://example.com/buy/'">Buy</button>
+<button onclick="go('https://example.com/p/' + id)">Item</button>
+<button onClick="alert('hi')">Hi</button>
+</body></html>`), 0644)
+
+	for _, strip := range []bool{false, true} {
+		out := filepath.Join(t.TempDir(), "out")
+		p := NewProcessor("example.com")
+		p.cfg.OutputDir = out
+		p.cfg.StripUnsafeHandlers = strip
+		p.Process(src, nil)
+		b, _ := os.ReadFile(filepath.Join(out, "index.html"))
+		got := string(b)
+
+		if !strings.Contains(got, `onclick="location.href=&#39;buy/index.html&#39;"`) {
+			t.Errorf("strip=%v: same-host literal not rewritten:\n%s", strip, got)
+		}
+		if !strings.Contains(got, `onclick="alert(&#39;hi&#39;)"`) {
+			t.Errorf("strip=%v: unrelated handler changed:\n%s", strip, got)
+		}
+		// Склейку переписать нельзя: убираем или оставляем как есть
+		if concat := strings.Contains(got, `https://example.com/p/`); concat == strip {
+			t.Errorf("strip=%v: concatenated handler kept=%v:\n%s", strip, concat, got)
+		}
+
+		wantKept, wantStripped := int64(1), int64(0)
+		if strip {
+			wantKept, wantStripped = 0, 1
+		}
+		if p.Stats.HandlersRewritten != 1 || p.Stats.HandlersKept != wantKept || p.Stats.HandlersStripped != wantStripped {
+			t.Errorf("strip=%v: unexpected stats %+v", strip, p.Stats)
+		}
+
+		links, _ := ReadOutboundLinks(out)
+		if strip && len(links) != 0 {
+			t.Errorf("stripped handlers must not be reported: %+v", links)
+		}
+		want := OutboundLink{Source: "index.html", Href: "https://example.com/p/", Text: "Item", Attr: "onclick"}
+		if !strip && (len(links) != 1 || links[0] != want) {
+			t.Errorf("expected %+v in report, got %+v", want, links)
+		}
+	}
+}
+
+func TestMetaRefreshRewritten(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "new-page"), 0755)
+	os.WriteFile(filepath.Join(src, "new-page", "index.html"), []byte(`<html></html>`), 0644)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<html><head>`+
+		`<meta http-equiv="refresh" content="5; URL=https://example.com/new-page/">`+
+		`<meta http-equiv="refresh" content="30"></head></html>`), 0644)
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	p.Process(src, nil)
+	b, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	got := string(b)
+	for _, want := range []string{`content="5; URL=new-page/index.html"`, `content="30"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSameHostEitherScheme(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "docs"), 0755)
+	os.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte(`<html></html>`), 0644)
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	page := filepath.Join(dir, "index.html")
+	for _, raw := range []string{"http://example.com/docs/", "https://example.com/docs/", "//example.com/docs/"} {
+		if got, ok := p.resolveTargetPath(page, raw); !ok || got != "docs/index.html" {
+			t.Errorf("%s: expected docs/index.html, got %q", raw, got)
+		}
+	}
+}
+
+func TestExternalAssetsCopied(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, body string) {
+		full := filepath.Join(root, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte(body), 0644)
+	}
+	// Так раскладывает загрузчик с DownloadExternalAssets
+	write("example.com/index.html", `<link rel="stylesheet" href="../cdn.example.net/css/app.css">`+
+		`<script src="https://cdn.example.net/js/app.js?v=2"></script>`+
+		`<a href="https://cdn.example.net/about">about</a>`)
+	write("example.com/blog/post.html", `<img src="//cdn.example.net/img/logo.png">`)
+	write("cdn.example.net/"+downloader.AssetMirrorMarker, "")
+	write("cdn.example.net/css/app.css", `@font-face { src: url("../fonts/f.woff2"); }`)
+	write("cdn.example.net/fonts/f.woff2", "wOF2")
+	write("cdn.example.net/js/app.js", "console.log(1)")
+	write("cdn.example.net/img/logo.png", "png")
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor("example.com")
+	p.ApplyPreset(ProcessingPreset{LinkStyle: LinkStyleRelative, RemoveMissing: true})
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), filepath.Join(root, "example.com"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	index, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	for _, want := range []string{
+		`href="_external/cdn.example.net/css/app.css"`,
+		`src="_external/cdn.example.net/js/app.js?v=2"`,
+		`href="#">about`, // Внешняя страница убирается пресетом, копии ассетов — нет
+	} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("index.html lacks %q:\n%s", want, index)
+		}
+	}
+	post, _ := os.ReadFile(filepath.Join(out, "blog", "post.html"))
+	if !strings.Contains(string(post), `src="../_external/cdn.example.net/img/logo.png"`) {
+		t.Errorf("Protocol-relative CDN image not rewritten:\n%s", post)
+	}
+	// Шрифт из CSS с CDN копируется вместе с ним
+	for _, rel := range []string{"css/app.css", "fonts/f.woff2", "js/app.js", "img/logo.png"} {
+		if _, err := os.Stat(filepath.Join(out, ExternalDirName, "cdn.example.net", filepath.FromSlash(rel))); err != nil {
+			t.Errorf("%s not copied to %s: %v", rel, ExternalDirName, err)
+		}
+	}
+}
+
+func TestLegacyCharsetConverted(t *testing.T) {
+	src := t.TempDir()
+	// "Привет" в windows-1251: страница, скачанная без перекодировки
+	os.WriteFile(filepath.Join(src, "index.html"), []byte(`<html><head><meta charset="windows-1251">`+
+		"<title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head><body><a href=\"https://example.com/\">home</a></body></html>"), 0644)
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor("example.com")
+	p.cfg.OutputDir = out
+	p.Process(src, nil)
+	b, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	got := string(b)
+	if !strings.Contains(got, "<title>Привет</title>") || !strings.Contains(got, `<meta charset="utf-8"/>`) {
+		t.Errorf("Page not converted to UTF-8:\n%s", got)
+	}
+	if p.Stats.Recoded != 1 {
+		t.Errorf("Recoded = %d, want 1", p.Stats.Recoded)
+	}
+}
+
+func TestErrorPageLinksFromRoot(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "css", "site.css"), []byte("x"), 0644)
+	p := &Processor{cfg: Config{Dir: dir, OriginalHost: "example.com"}, Stats: &Stats{}}
+
+	for _, c := range []struct{ file, want string }{
+		{"404.html", "/css/site.css"},
+		{"index.html", "css/site.css"},
+	} {
+		if got, _ := p.resolveTargetPath(filepath.Join(dir, c.file), "css/site.css"); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.file, c.want, got)
+		}
+	}
+}
+
+func TestSyntheticSiteProcessedGolden(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := sitetest.Serve(t, sitetest.Synthetic())
+	dl := t.TempDir()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if _, err := downloader.Run(context.Background(), downloader.RunOptions{
+		URL: srv.URL + "/",
+		Config: downloader.Config{Workers: 1, MaxDepth: 5, Retries: 1, DeferredRetries: -1, OutputDir: dl,
+			Deterministic: true, Seed: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewProcessor(host)
+	p.ApplyPreset(BuiltinPresets[0])
+	p.cfg.OutputDir = out
+	if err := p.ProcessContext(context.Background(), filepath.Join(dl, host), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Эталон лежит у sitetest: processor/testdata пересоздаёт TestResolveTargetPath.
+	// В маркере — время обработки и временные пути.
+	sitetest.Golden(t, out, filepath.Join("..", "internal", "sitetest", "testdata", "processed"), MarkerFileName)
+}
@@ -0,0 +1,259 @@
+package proccesor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LinkStyleRelative = "relative" // ../page/index.html — работает из file://
+	LinkStyleAbsolute = "absolute" // /page/index.html — для хостинга от корня
+
+	// DefaultPresetName совпадает с прежним поведением процессора
+	DefaultPresetName = "Audit copy"
+
+	// MarkerFileName — файл в папке результата с параметрами обработки
+	MarkerFileName = ".sitemvp.json"
+)
+
+// ProcessingPreset — именованный набор опций обработки
+type ProcessingPreset struct {
+	Name          string `json:"name"`
+	LinkStyle     string `json:"linkStyle"`
+	KeepExternal  bool   `json:"keepExternal"`
+	RemoveMissing bool   `json:"removeMissing"`
+	ConvertPHP    bool   `json:"convertPhp"`
+	StripCSP      bool   `json:"stripCsp"`
+	Placeholders  bool   `json:"placeholders"`
+	Minify        bool   `json:"minify"`
+
+	UpgradeHTTP      bool     `json:"upgradeHttp"`
+	UpgradeHosts     []string `json:"upgradeHosts"`
+	VerifyUpgrades   bool     `json:"verifyUpgrades"`
+	ProtocolRelative bool     `json:"protocolRelative"`
+
+	StripAssetQueries bool     `json:"stripAssetQueries"`
+	KeepQueryParams   []string `json:"keepQueryParams"`
+
+	GenerateIndexes bool `json:"generateIndexes"`
+
+	StripHandlers bool `json:"stripHandlers"`
+}
+
+// PresetOverrides — точечные изменения поверх пресета (nil = не менять)
+type PresetOverrides struct {
+	LinkStyle     *string `json:"linkStyle,omitempty"`
+	KeepExternal  *bool   `json:"keepExternal,omitempty"`
+	RemoveMissing *bool   `json:"removeMissing,omitempty"`
+	ConvertPHP    *bool   `json:"convertPhp,omitempty"`
+	StripCSP      *bool   `json:"stripCsp,omitempty"`
+	Placeholders  *bool   `json:"placeholders,omitempty"`
+	Minify        *bool   `json:"minify,omitempty"`
+
+	UpgradeHTTP      *bool    `json:"upgradeHttp,omitempty"`
+	UpgradeHosts     []string `json:"upgradeHosts,omitempty"`
+	VerifyUpgrades   *bool    `json:"verifyUpgrades,omitempty"`
+	ProtocolRelative *bool    `json:"protocolRelative,omitempty"`
+
+	StripAssetQueries *bool    `json:"stripAssetQueries,omitempty"`
+	KeepQueryParams   []string `json:"keepQueryParams,omitempty"`
+
+	GenerateIndexes *bool `json:"generateIndexes,omitempty"`
+
+	StripHandlers *bool `json:"stripHandlers,omitempty"`
+}
+
+// BuiltinPresets — встроенные пресеты
+var BuiltinPresets = []ProcessingPreset{
+	{
+		Name:          "Offline archive",
+		LinkStyle:     LinkStyleRelative,
+		KeepExternal:  false,
+		RemoveMissing: true,
+		ConvertPHP:    true,
+		StripCSP:      true,
+		Placeholders:  true,
+
+		StripAssetQueries: true,
+		GenerateIndexes:   true,
+		StripHandlers:     true,
+	},
+	{
+		Name:         "Re-host",
+		LinkStyle:    LinkStyleAbsolute,
+		KeepExternal: true,
+		ConvertPHP:   true,
+		StripCSP:     true,
+		Minify:       true,
+		UpgradeHTTP:  true,
+	},
+	{
+		Name:         "Audit copy",
+		LinkStyle:    LinkStyleRelative,
+		KeepExternal: true,
+		ConvertPHP:   true,
+	},
+}
+
+// FindPreset ищет пресет по имени среди встроенных и пользовательских
+func FindPreset(name string, custom []ProcessingPreset) (ProcessingPreset, bool) {
+	for _, p := range custom {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	for _, p := range BuiltinPresets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ProcessingPreset{}, false
+}
+
+// WithOverrides возвращает копию пресета с применёнными изменениями
+func (p ProcessingPreset) WithOverrides(o PresetOverrides) ProcessingPreset {
+	if o.LinkStyle != nil {
+		p.LinkStyle = *o.LinkStyle
+	}
+	if o.KeepExternal != nil {
+		p.KeepExternal = *o.KeepExternal
+	}
+	if o.RemoveMissing != nil {
+		p.RemoveMissing = *o.RemoveMissing
+	}
+	if o.ConvertPHP != nil {
+		p.ConvertPHP = *o.ConvertPHP
+	}
+	if o.StripCSP != nil {
+		p.StripCSP = *o.StripCSP
+	}
+	if o.Placeholders != nil {
+		p.Placeholders = *o.Placeholders
+	}
+	if o.Minify != nil {
+		p.Minify = *o.Minify
+	}
+	if o.UpgradeHTTP != nil {
+		p.UpgradeHTTP = *o.UpgradeHTTP
+	}
+	if o.UpgradeHosts != nil {
+		p.UpgradeHosts = o.UpgradeHosts
+	}
+	if o.VerifyUpgrades != nil {
+		p.VerifyUpgrades = *o.VerifyUpgrades
+	}
+	if o.ProtocolRelative != nil {
+		p.ProtocolRelative = *o.ProtocolRelative
+	}
+	if o.StripAssetQueries != nil {
+		p.StripAssetQueries = *o.StripAssetQueries
+	}
+	if o.KeepQueryParams != nil {
+		p.KeepQueryParams = o.KeepQueryParams
+	}
+	if o.GenerateIndexes != nil {
+		p.GenerateIndexes = *o.GenerateIndexes
+	}
+	if o.StripHandlers != nil {
+		p.StripHandlers = *o.StripHandlers
+	}
+	return p
+}
+
+// ApplyPreset переносит опции пресета в Config процессора
+func (p *Processor) ApplyPreset(preset ProcessingPreset) {
+	p.cfg.Preset = preset.Name
+	p.cfg.LinkStyle = preset.LinkStyle
+	p.cfg.StripExternal = !preset.KeepExternal
+	p.cfg.RemoveMissing = preset.RemoveMissing
+	p.cfg.KeepPHP = !preset.ConvertPHP
+	p.cfg.StripCSP = preset.StripCSP
+	p.cfg.Placeholders = preset.Placeholders
+	p.cfg.Minify = preset.Minify
+	p.cfg.UpgradeHTTP = preset.UpgradeHTTP
+	p.cfg.UpgradeHosts = preset.UpgradeHosts
+	p.cfg.VerifyUpgrades = preset.VerifyUpgrades
+	p.cfg.ProtocolRelative = preset.ProtocolRelative
+	p.cfg.StripAssetQueries = preset.StripAssetQueries
+	p.cfg.KeepQueryParams = preset.KeepQueryParams
+	p.cfg.DirIndexes = preset.GenerateIndexes
+	p.cfg.StripUnsafeHandlers = preset.StripHandlers
+}
+
+// preset восстанавливает пресет из текущего Config (для marker-файла)
+func (p *Processor) preset() ProcessingPreset {
+	return ProcessingPreset{
+		Name:          p.cfg.Preset,
+		LinkStyle:     p.cfg.LinkStyle,
+		KeepExternal:  !p.cfg.StripExternal,
+		RemoveMissing: p.cfg.RemoveMissing,
+		ConvertPHP:    !p.cfg.KeepPHP,
+		StripCSP:      p.cfg.StripCSP,
+		Placeholders:  p.cfg.Placeholders,
+		Minify:        p.cfg.Minify,
+
+		UpgradeHTTP:      p.cfg.UpgradeHTTP,
+		UpgradeHosts:     p.cfg.UpgradeHosts,
+		VerifyUpgrades:   p.cfg.VerifyUpgrades,
+		ProtocolRelative: p.cfg.ProtocolRelative,
+
+		StripAssetQueries: p.cfg.StripAssetQueries,
+		KeepQueryParams:   p.cfg.KeepQueryParams,
+
+		GenerateIndexes: p.cfg.DirIndexes,
+
+		StripHandlers: p.cfg.StripUnsafeHandlers,
+	}
+}
+
+// ProcessMarker — содержимое MarkerFileName
+type ProcessMarker struct {
+	Source      string           `json:"source"`
+	Host        string           `json:"host"`
+	Preset      ProcessingPreset `json:"preset"`
+	ProcessedAt time.Time        `json:"processedAt"`
+
+	LinksUpgraded   int64 `json:"linksUpgraded"`
+	QueriesStripped int64 `json:"queriesStripped"`
+	BytesLinked     int64 `json:"bytesLinked"`
+
+	// GeneratedIndexes — папки, где index.html создан процессором
+	GeneratedIndexes []string `json:"generatedIndexes,omitempty"`
+}
+
+func (p *Processor) writeMarker() error {
+	marker := ProcessMarker{
+		Source:      p.cfg.Dir,
+		Host:        p.cfg.OriginalHost,
+		Preset:      p.preset(),
+		ProcessedAt: time.Now(),
+
+		LinksUpgraded:   atomic.LoadInt64(&p.Stats.LinksUpgraded),
+		QueriesStripped: atomic.LoadInt64(&p.Stats.QueriesStripped),
+		BytesLinked:     atomic.LoadInt64(&p.Stats.BytesLinked),
+	}
+	for rel := range p.indexDirs {
+		marker.GeneratedIndexes = append(marker.GeneratedIndexes, rel)
+	}
+	sort.Strings(marker.GeneratedIndexes)
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(p.cfg.OutputDir, MarkerFileName), data, 0644)
+}
+
+// ReadMarker читает параметры, с которыми была обработана папка
+func ReadMarker(processedDir string) (ProcessMarker, error) {
+	var marker ProcessMarker
+	data, err := os.ReadFile(filepath.Join(processedDir, MarkerFileName))
+	if err != nil {
+		return marker, err
+	}
+	err = json.Unmarshal(data, &marker)
+	return marker, err
+}
@@ -0,0 +1,64 @@
+package proccesor
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// staticAssetExts — расширения статики, у которой query — это cache buster
+var staticAssetExts = map[string]bool{
+	".css": true, ".js": true, ".mjs": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".ico": true, ".webp": true, ".avif": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp4": true, ".webm": true, ".mp3": true, ".ogg": true, ".wav": true,
+	".pdf": true,
+}
+
+// isStaticAsset — цель ссылки локальный статический файл, а не страница
+func isStaticAsset(target string) bool {
+	return staticAssetExts[strings.ToLower(path.Ext(target))]
+}
+
+// assetQuery возвращает query для локальной ссылки: при StripAssetQueries
+// у статики остаются только параметры из KeepQueryParams.
+// Пустой "?" (хак font.eot?#iefix) не трогаем.
+func (p *Processor) assetQuery(u *url.URL, target string) (string, bool) {
+	if u.RawQuery == "" {
+		return "", u.ForceQuery
+	}
+	if !p.cfg.StripAssetQueries || !isStaticAsset(target) {
+		return u.RawQuery, true
+	}
+
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name = pair[:i]
+		}
+		if key, err := url.QueryUnescape(name); err == nil && p.keepQueryParam(key) {
+			kept = append(kept, pair)
+		}
+	}
+	if len(kept) == len(strings.Split(u.RawQuery, "&")) {
+		return u.RawQuery, true
+	}
+
+	atomic.AddInt64(&p.Stats.QueriesStripped, 1)
+	if len(kept) == 0 {
+		return "", false
+	}
+	return strings.Join(kept, "&"), true
+}
+
+func (p *Processor) keepQueryParam(name string) bool {
+	for _, k := range p.cfg.KeepQueryParams {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
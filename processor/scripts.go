@@ -0,0 +1,224 @@
+package proccesor
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"sitemvp/downloader"
+)
+
+// InlineScriptPrefix — начало ID inline-скрипта ("inline:<hash>"). Сам
+// "inline" в ScriptsToRemove по-прежнему удаляет все inline-скрипты.
+const InlineScriptPrefix = "inline:"
+
+// inlineHashLen — сколько символов sha256 в ID inline-скрипта
+const inlineHashLen = 12
+
+// ScriptInfo — скрипт сайта для экрана выбора удаляемых скриптов
+type ScriptInfo struct {
+	ID       string `json:"id"`            // Что передавать в ScriptsToRemove
+	Src      string `json:"src,omitempty"` // Как в разметке; пусто у inline
+	Inline   bool   `json:"inline"`
+	External bool   `json:"external"`          // Чужой хост, локальной копии не бывает
+	Exists   bool   `json:"exists"`            // Файл есть в скачанной копии
+	Size     int64  `json:"size"`              // Байт на диске или длина inline-кода
+	Pages    int    `json:"pages"`             // Сколько страниц его подключают
+	Type     string `json:"type,omitempty"`    // Атрибут type, если задан
+	Hash     string `json:"hash,omitempty"`    // Только у inline
+	Preview  string `json:"preview,omitempty"` // Начало inline-кода
+	Tracker  string `json:"tracker,omitempty"` // Имя счётчика из Trackers
+}
+
+// Tracker — известный счётчик/аналитика и признаки его скрипта
+type Tracker struct {
+	Name     string
+	Patterns []string // Подстроки src или inline-кода
+}
+
+// Trackers — список для пометки скриптов аналитики в AnalyzeScripts
+var Trackers = []Tracker{
+	{Name: "Google Analytics", Patterns: []string{"google-analytics.com", "gtag(", "ga('create'"}},
+	{Name: "Google Tag Manager", Patterns: []string{"googletagmanager.com"}},
+	{Name: "Yandex.Metrika", Patterns: []string{"mc.yandex.ru", "yandex.ru/metrika"}},
+	{Name: "Facebook Pixel", Patterns: []string{"connect.facebook.net", "fbq("}},
+	{Name: "Hotjar", Patterns: []string{"static.hotjar.com", "hotjar.com"}},
+	{Name: "Microsoft Clarity", Patterns: []string{"clarity.ms"}},
+	{Name: "LiveInternet", Patterns: []string{"counter.yadro.ru", "liveinternet.ru"}},
+	{Name: "Top.Mail.Ru", Patterns: []string{"top-fwz1.mail.ru", "top.mail.ru"}},
+	{Name: "Matomo", Patterns: []string{"matomo.js", "piwik.js"}},
+}
+
+// classifyTracker возвращает имя счётчика, на который похож скрипт, или ""
+func classifyTracker(src, code string) string {
+	s := strings.ToLower(src)
+	if s == "" {
+		s = strings.ToLower(code)
+	}
+	for _, t := range Trackers {
+		for _, pattern := range t.Patterns {
+			if strings.Contains(s, pattern) {
+				return t.Name
+			}
+		}
+	}
+	return ""
+}
+
+// InlineScriptID — стабильный ID inline-скрипта: хэш кода без крайних пробелов
+func InlineScriptID(code string) string {
+	return InlineScriptPrefix + downloader.ContentHash([]byte(strings.TrimSpace(code)))[:inlineHashLen]
+}
+
+// scriptText — код inline-скрипта, как он есть в разметке
+func scriptText(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	}
+	return b.String()
+}
+
+// scriptMatches решает, подходит ли скрипт под шаблон из ScriptsToRemove:
+// "inline" — любой inline, "inline:<hash>" — конкретный блок,
+// остальное — подстрока src.
+func scriptMatches(pattern, src string, n *html.Node) bool {
+	switch {
+	case pattern == "":
+		return false
+	case src != "":
+		return strings.Contains(src, pattern)
+	case pattern == "inline":
+		return true
+	case strings.HasPrefix(pattern, InlineScriptPrefix):
+		return InlineScriptID(scriptText(n)) == pattern
+	}
+	return false
+}
+
+// AnalyzeScripts собирает все скрипты страниц сайта: внешние и локальные по
+// src, inline — по хэшу кода. Сначала те, что подключены на большем числе страниц.
+func (p *Processor) AnalyzeScripts(dir string) []ScriptInfo {
+	byID := map[string]*ScriptInfo{}
+	pages := map[string]map[string]bool{}
+	var order []string
+
+	add := func(page string, s ScriptInfo) {
+		if byID[s.ID] == nil {
+			byID[s.ID] = &s
+			pages[s.ID] = map[string]bool{}
+			order = append(order, s.ID)
+		}
+		pages[s.ID][page] = true
+	}
+
+	filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if fpath != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isPageFile(info.Name()) {
+			return nil
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			return nil
+		}
+		doc, err := html.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil
+		}
+
+		var find func(*html.Node)
+		find = func(n *html.Node) {
+			if n.Type == html.ElementNode && n.Data == "script" {
+				add(fpath, p.scriptInfo(dir, fpath, n))
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				find(c)
+			}
+		}
+		find(doc)
+		return nil
+	})
+
+	scripts := make([]ScriptInfo, 0, len(order))
+	for _, id := range order {
+		s := byID[id]
+		s.Pages = len(pages[id])
+		scripts = append(scripts, *s)
+	}
+	sort.SliceStable(scripts, func(i, j int) bool {
+		return scripts[i].Pages > scripts[j].Pages
+	})
+	return scripts
+}
+
+// scriptInfo описывает один <script> страницы page
+func (p *Processor) scriptInfo(dir, page string, n *html.Node) ScriptInfo {
+	var s ScriptInfo
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "src":
+			s.Src = strings.TrimSpace(a.Val)
+		case "type":
+			s.Type = a.Val
+		}
+	}
+
+	if s.Src == "" {
+		code := scriptText(n)
+		s.ID = InlineScriptID(code)
+		s.Inline = true
+		s.Exists = true
+		s.Size = int64(len(code))
+		s.Hash = strings.TrimPrefix(s.ID, InlineScriptPrefix)
+		s.Preview = scriptPreview(code)
+		s.Tracker = classifyTracker("", code)
+		return s
+	}
+
+	s.ID = s.Src
+	s.Tracker = classifyTracker(s.Src, "")
+	if p.isExternalLink(s.Src) {
+		s.External = true
+		return s
+	}
+	u, err := url.Parse(s.Src)
+	if err != nil || u.Path == "" || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+		return s
+	}
+	var diskPath string
+	if strings.HasPrefix(u.Path, "/") {
+		diskPath = filepath.Join(dir, filepath.FromSlash(u.Path))
+	} else {
+		diskPath = filepath.Join(filepath.Dir(page), filepath.FromSlash(u.Path))
+	}
+	if fi, err := os.Stat(diskPath); err == nil && !fi.IsDir() {
+		s.Exists = true
+		s.Size = fi.Size()
+	}
+	return s
+}
+
+// scriptPreview — первые символы кода одной строкой
+func scriptPreview(code string) string {
+	const max = 80
+	preview := strings.Join(strings.Fields(code), " ")
+	if r := []rune(preview); len(r) > max {
+		preview = string(r[:max]) + "…"
+	}
+	return preview
+}
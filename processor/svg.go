@@ -0,0 +1,52 @@
+package proccesor
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// svgHrefRegex — href и xlink:href в разметке SVG (<image>, <use>, <a>)
+var svgHrefRegex = regexp.MustCompile(`(\s(?:xlink:)?href\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// processSVG переписывает ссылки в SVG-файле. Файл правится как текст,
+// а не через encoding/xml: так не теряются префиксы пространств имён и CDATA.
+// Ссылки на фрагменты (#id) остаются как есть.
+func (p *Processor) processSVG(src, dst string) (bool, error) {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+
+	content := p.rewriteHrefs(src, string(b))
+
+	// url(...) во встроенных <style> и в атрибутах style/fill
+	content = p.rewriteCSSURLs(src, content)
+	content = p.upgradeText(content)
+	if content == string(b) {
+		return false, p.linkOrCopy(src, dst)
+	}
+	return true, replaceFile(dst, []byte(content))
+}
+
+// rewriteHrefs переписывает href и xlink:href в тексте разметки,
+// не трогая кавычки и остальной текст
+func (p *Processor) rewriteHrefs(src, content string) string {
+	return svgHrefRegex.ReplaceAllStringFunc(content, func(m string) string {
+		match := svgHrefRegex.FindStringSubmatch(m)
+		raw, quote := match[2], `"`
+		if match[3] != "" {
+			raw, quote = match[3], `'`
+		}
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			return m
+		}
+		newURL, ok := p.resolveTargetPath(src, raw)
+		if !ok || newURL == raw {
+			return m
+		}
+		atomic.AddInt64(&p.Stats.LinksRewritten, 1)
+		return match[1] + quote + newURL + quote
+	})
+}
@@ -0,0 +1,88 @@
+package proccesor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sitemvp/downloader"
+)
+
+const (
+	// Суффиксы служебных папок при замене результата
+	TempDirSuffix = ".tmp"
+	OldDirSuffix  = ".old"
+)
+
+// IsScratchDir — временная папка обработки, в библиотеке её не показываем
+func IsScratchDir(name string) bool {
+	return strings.HasSuffix(name, TempDirSuffix) || strings.HasSuffix(name, OldDirSuffix)
+}
+
+// ProcessContext обрабатывает сайт во временную папку OutputDir+".tmp" и только
+// при успехе подменяет ею OutputDir. При ошибке или отмене прежний результат
+// остаётся нетронутым, а временная папка удаляется. На время обработки
+// sourceDir блокируется (downloader.LockSite): занятый сайт не трогаем.
+func (p *Processor) ProcessContext(ctx context.Context, sourceDir string, scriptsToRemove []string) error {
+	// Если OutputDir не задан (вызов из GUI), зададим дефолт
+	if p.cfg.OutputDir == "" {
+		p.cfg.OutputDir = filepath.Clean(sourceDir) + "_processed"
+	}
+	// Пока идёт загрузка в sourceDir, обрабатывать его нельзя
+	if _, err := os.Stat(sourceDir); err != nil {
+		return err
+	}
+	lock, err := downloader.LockSite(sourceDir, "processor")
+	if err != nil {
+		p.log("[ERROR] %v\n", err)
+		return err
+	}
+	defer lock.Unlock()
+
+	finalDir := p.cfg.OutputDir
+	tmpDir := finalDir + TempDirSuffix
+
+	os.RemoveAll(tmpDir)
+	p.cfg.OutputDir = tmpDir
+	err = p.process(ctx, sourceDir, scriptsToRemove)
+	p.cfg.OutputDir = finalDir
+
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		p.log("[ERROR] Обработка прервана, прежний результат сохранён: %v\n", err)
+		return err
+	}
+	if err := swapDir(tmpDir, finalDir); err != nil {
+		os.RemoveAll(tmpDir)
+		p.log("[ERROR] Не удалось заменить %s: %v\n", finalDir, err)
+		return err
+	}
+	return nil
+}
+
+// swapDir ставит tmp на место dst: старую папку сначала переименовываем
+// в dst+".old", затем tmp в dst, и только потом удаляем старую
+func swapDir(tmp, dst string) error {
+	old := dst + OldDirSuffix
+	os.RemoveAll(old)
+
+	hadOld := false
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return fmt.Errorf("move old output away: %w", err)
+		}
+		hadOld = true
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if hadOld {
+			os.Rename(old, dst)
+		}
+		return fmt.Errorf("move new output in place: %w", err)
+	}
+	if hadOld {
+		os.RemoveAll(old)
+	}
+	return nil
+}
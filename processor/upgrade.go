@@ -0,0 +1,157 @@
+package proccesor
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"sitemvp/downloader"
+)
+
+// httpsUpgrader переписывает http:// ссылки на https://, чтобы обработанный
+// сайт не ловил mixed-content блокировки на HTTPS-хостинге.
+type httpsUpgrader struct {
+	hosts   map[string]bool // Разрешённые внешние хосты
+	verify  bool            // Проверять наличие https-варианта
+	probe   *downloader.Prober
+	siteDir string // Папка сайта: рядом лежат манифесты загрузки
+
+	mu    sync.Mutex
+	cache map[string]bool
+	known map[string]bool // Хосты, скачанные по https; nil — манифесты ещё не читали
+}
+
+func newHTTPSUpgrader(hosts []string, verify bool, probe *downloader.Prober, siteDir string) *httpsUpgrader {
+	if probe == nil {
+		probe = downloader.NewProber(downloader.Config{}, 0)
+	}
+	u := &httpsUpgrader{
+		hosts:   make(map[string]bool),
+		verify:  verify,
+		probe:   probe,
+		siteDir: siteDir,
+		cache:   make(map[string]bool),
+	}
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			u.hosts[h] = true
+		}
+	}
+	return u
+}
+
+// allowed — хост в белом списке и (при verify) отвечает по https. Сначала
+// смотрим манифесты загрузки: если с хоста уже что-то скачано по https,
+// сеть не нужна. HEAD — только для хостов, которых там нет.
+func (u *httpsUpgrader) allowed(host string) bool {
+	host = strings.ToLower(host)
+	if !u.hosts[host] {
+		return false
+	}
+	if !u.verify {
+		return true
+	}
+
+	u.mu.Lock()
+	if u.known == nil {
+		u.known = httpsHosts(u.siteDir)
+	}
+	ok, cached := u.cache[host]
+	if !cached && u.known[host] {
+		ok, cached = true, true
+	}
+	u.mu.Unlock()
+	if cached {
+		return ok
+	}
+
+	ok = false
+	if resp, err := u.probe.Head(context.Background(), "https://"+host+"/"); err == nil {
+		resp.Body.Close()
+		ok = resp.StatusCode < 500
+	}
+
+	u.mu.Lock()
+	u.cache[host] = ok
+	u.mu.Unlock()
+	return ok
+}
+
+// httpsHosts — хосты, с которых загрузчик что-то скачал по https, по
+// манифестам рядом с папкой сайта siteDir
+func httpsHosts(siteDir string) map[string]bool {
+	hosts := make(map[string]bool)
+	if siteDir == "" {
+		return hosts
+	}
+	manifests, _ := filepath.Glob(filepath.Join(filepath.Dir(siteDir), "*"+downloader.ManifestExtension))
+	for _, m := range manifests {
+		entries, err := downloader.LoadManifest(m)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if u, err := url.Parse(e.URL); err == nil && u.Scheme == "https" {
+				hosts[strings.ToLower(u.Host)] = true
+			}
+		}
+	}
+	return hosts
+}
+
+// upgradeURL возвращает https:// (или //) вариант внешней http:// ссылки
+func (p *Processor) upgradeURL(u *url.URL) (string, bool) {
+	if !p.cfg.UpgradeHTTP || u.Scheme != "http" || !p.upgrader().allowed(u.Host) {
+		return "", false
+	}
+	up := *u
+	up.Scheme = "https"
+	res := up.String()
+	if p.cfg.ProtocolRelative {
+		res = strings.TrimPrefix(res, "https:")
+	}
+	atomic.AddInt64(&p.Stats.LinksUpgraded, 1)
+	return res, true
+}
+
+func (p *Processor) upgrader() *httpsUpgrader {
+	p.upgraderOnce.Do(func() {
+		p.httpsUp = newHTTPSUpgrader(p.cfg.UpgradeHosts, p.cfg.VerifyUpgrades, p.probe, p.cfg.Dir)
+	})
+	return p.httpsUp
+}
+
+// SetProber задаёт клиент для сетевых проверок (UA, заголовки, прокси
+// и задержки те же, что у загрузчика). Вызывать до Process.
+func (p *Processor) SetProber(probe *downloader.Prober) {
+	p.probe = probe
+}
+
+// upgradeText заменяет оставшиеся http://<свой хост> в inline-скриптах и стилях.
+// Свой хост не проверяется по сети: его https-вариант и есть скачанная копия.
+func (p *Processor) upgradeText(text string) string {
+	if !p.cfg.UpgradeHTTP || p.cfg.OriginalHost == "" {
+		return text
+	}
+	re := p.sameHostHTTPRegex()
+	target := "https://"
+	if p.cfg.ProtocolRelative {
+		target = "//"
+	}
+	return re.ReplaceAllStringFunc(text, func(m string) string {
+		atomic.AddInt64(&p.Stats.LinksUpgraded, 1)
+		return target + strings.TrimPrefix(m, "http://")
+	})
+}
+
+func (p *Processor) sameHostHTTPRegex() *regexp.Regexp {
+	p.sameHostOnce.Do(func() {
+		p.sameHostRe = regexp.MustCompile(`http://(?:www\.)?` + regexp.QuoteMeta(p.cfg.OriginalHost) + `\b`)
+	})
+	return p.sameHostRe
+}
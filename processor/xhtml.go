@@ -0,0 +1,99 @@
+package proccesor
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// XHTML и встроенный SVG. Парсер golang.org/x/net/html раскладывает
+// атрибуты по-разному: внутри <svg>/<math> префикс уходит в Namespace
+// (xlink:href -> Namespace "xlink", Key "href"), в HTML-части остаётся
+// в Key ("xml:lang"). Рендер пишет их обратно как были, поэтому сравнивать
+// атрибуты надо по полному имени, а не по Key.
+
+// attrName — имя атрибута с префиксом, как в исходнике
+func attrName(a html.Attribute) string {
+	if a.Namespace != "" {
+		return a.Namespace + ":" + a.Key
+	}
+	return a.Key
+}
+
+// linkAttrName — имя атрибута для проверки на ссылку: xlink:href
+// переписывается так же, как href. Прочие атрибуты с префиксом
+// (xml:base, xmlns:xlink) ссылками не считаются.
+func linkAttrName(a html.Attribute) string {
+	switch name := attrName(a); {
+	case name == "xlink:href":
+		return "href"
+	case strings.Contains(name, ":"):
+		return ""
+	default:
+		return name
+	}
+}
+
+// xmlBase возвращает значение xml:base элемента и убирает атрибут
+func xmlBase(n *html.Node) (string, bool) {
+	for i, a := range n.Attr {
+		if attrName(a) == "xml:base" {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return strings.TrimSpace(a.Val), true
+		}
+	}
+	return "", false
+}
+
+// resolveXMLBase применяет действующий xml:base к ссылке. Браузеры xml:base
+// давно не поддерживают, поэтому ссылки под ним сразу приводятся к тому
+// адресу, на который он указывал, а сам атрибут из результата убирается —
+// иначе XML-читатель применил бы базу второй раз.
+func resolveXMLBase(base, link string) string {
+	if base == "" || link == "" || strings.HasPrefix(link, "#") ||
+		strings.HasPrefix(link, "/") || strings.HasPrefix(link, "data:") {
+		return link
+	}
+	if u, err := url.Parse(link); err != nil || u.Scheme != "" {
+		return link
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return link
+	}
+	if b.Scheme != "" || strings.HasPrefix(base, "/") {
+		r, _ := url.Parse(link)
+		return b.ResolveReference(r).String()
+	}
+	// Относительная база ("sub/") — относительно самого документа
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		return base[:i+1] + link
+	}
+	return link
+}
+
+// isProcessingInstruction — <?xml ...?> и <?xml-stylesheet ...?>: парсер HTML
+// превращает их в комментарии, а рендер записал бы <!--?xml ...?-->
+func isProcessingInstruction(n *html.Node) bool {
+	return n.Type == html.CommentNode && len(n.Data) >= 2 &&
+		strings.HasPrefix(n.Data, "?") && strings.HasSuffix(n.Data, "?")
+}
+
+// keepProcessingInstruction возвращает инструкцию в исходном виде;
+// href в xml-stylesheet переписывается как обычная ссылка
+func (p *Processor) keepProcessingInstruction(src string, n *html.Node) {
+	n.Type = html.RawNode
+	n.Data = "<" + p.rewriteHrefs(src, n.Data) + ">"
+}
+
+// preservesSpace — xml:space="preserve": пробелы внутри значимы (SVG <text>)
+func preservesSpace(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if attrName(a) == "xml:space" && a.Val == "preserve" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ExportOptions — что положить в Docker-бандл кроме сайта и nginx.conf
+type ExportOptions struct {
+	Compose bool // docker-compose.yml с пробросом порта
+	Port    int  // Порт хоста в docker-compose.yml; DefaultPort, если не задан
+}
+
+// nginxRoot — корень статики в образе nginx; сайт лежит в nginxRoot+BasePath
+const nginxRoot = "/usr/share/nginx/html"
+
+// nginxTemplate повторяет siteHandler: файл, папка (с редиректом на слэш и
+// листингом без index.html), <путь>.html, SPA-фолбэк для путей без
+// расширения, затем страница 404.
+var nginxTemplate = template.Must(template.New("nginx.conf").Parse(`# Generated by sitecloner. Mirrors the rules of the built-in preview server.
+server {
+    listen 80;
+    server_name _;
+    root {{.Root}};
+    index index.html;
+    autoindex on;
+    absolute_redirect off;
+{{- if ne .BasePath "/"}}
+
+    location = / {
+        return 302 {{.BasePath}};
+    }
+
+    location = {{.BaseNoSlash}} {
+        return 302 {{.BasePath}};
+    }
+
+    location / {
+        return 404;
+    }
+{{- end}}
+
+    location {{.BasePath}} {
+        if (-d $request_filename) {
+            rewrite [^/]$ $uri/ permanent;
+        }
+        try_files $uri $uri/ $uri.html =404;
+    }
+{{- if .SPAFallback}}
+
+    location ~ ^{{.BasePath}}(?:.*/)?[^./]+$ {
+        if (-d $request_filename) {
+            rewrite [^/]$ $uri/ permanent;
+        }
+        try_files $uri $uri/ $uri.html {{.BasePath}}index.html;
+    }
+{{- end}}
+{{- if .NotFoundPage}}
+
+    error_page 404 {{.BasePath}}{{.NotFoundPage}};
+{{- end}}
+}
+`))
+
+var dockerfileTemplate = template.Must(template.New("Dockerfile").Parse(`FROM nginx:alpine
+RUN rm -rf {{.Root}}/*
+COPY nginx.conf /etc/nginx/conf.d/default.conf
+COPY site/ {{.Root}}{{.BasePath}}
+EXPOSE 80
+`))
+
+var composeTemplate = template.Must(template.New("docker-compose.yml").Parse(`services:
+  site:
+    build: .
+    ports:
+      - "{{.Port}}:80"
+    restart: unless-stopped
+`))
+
+type bundleData struct {
+	SiteOptions
+	Root        string
+	BaseNoSlash string
+	Port        int
+}
+
+func newBundleData(site SiteOptions, port int) bundleData {
+	site = site.Normalize()
+	if port <= 0 {
+		port = DefaultPort
+	}
+	return bundleData{
+		SiteOptions: site,
+		Root:        nginxRoot,
+		BaseNoSlash: strings.TrimSuffix(site.BasePath, "/"),
+		Port:        port,
+	}
+}
+
+// NginxConfig возвращает nginx.conf с теми же правилами, что у встроенного сервера
+func NginxConfig(site SiteOptions) string {
+	var buf bytes.Buffer
+	nginxTemplate.Execute(&buf, newBundleData(site, 0))
+	return buf.String()
+}
+
+// ExportDockerBundle собирает в outDir самодостаточный бандл: site/ с копией
+// сайта, nginx.conf, Dockerfile и, по желанию, docker-compose.yml.
+// Правила отдачи берутся из настроек сайта (LoadSiteOptions).
+func ExportDockerBundle(siteDir, outDir string, opts ExportOptions) error {
+	if fi, err := os.Stat(siteDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("missing: %s", siteDir)
+	}
+	absSite, _ := filepath.Abs(siteDir)
+	absOut, _ := filepath.Abs(outDir)
+	if absOut == absSite || strings.HasPrefix(absOut, absSite+string(filepath.Separator)) {
+		return fmt.Errorf("export dir %s is inside the site", outDir)
+	}
+
+	siteOut := filepath.Join(outDir, "site")
+	if err := os.RemoveAll(siteOut); err != nil {
+		return err
+	}
+	if err := copySite(siteDir, siteOut); err != nil {
+		return fmt.Errorf("copy site: %w", err)
+	}
+
+	data := newBundleData(LoadSiteOptions(siteDir), opts.Port)
+	files := map[string]*template.Template{
+		"nginx.conf": nginxTemplate,
+		"Dockerfile": dockerfileTemplate,
+	}
+	if opts.Compose {
+		files["docker-compose.yml"] = composeTemplate
+	}
+	for name, tmpl := range files {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outDir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySite копирует сайт без служебных файлов (.sitecloner.lock, настроек
+// отдачи, скрытых папок)
+func copySite(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(p, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
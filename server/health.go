@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// StatusPath — служебный адрес состояния; не считается в статистике
+// и не попадает под логирование и авторизацию
+const StatusPath = "/__sitecloner/status"
+
+// Health — машиночитаемое состояние запущенного сервера
+type Health struct {
+	ServingPath string    `json:"servingPath"`
+	StartedAt   time.Time `json:"startedAt"`
+	Requests    int64     `json:"requests"`
+	Errors404   int64     `json:"errors404"`
+	BasePath    string    `json:"basePath"`
+	AuthEnabled bool      `json:"authEnabled"`
+}
+
+// servingHandler отдаёт файлы и считает запросы
+type servingHandler struct {
+	files     http.Handler
+	dir       string
+	basePath  string
+	startedAt time.Time
+
+	requests  int64
+	errors404 int64
+}
+
+func newServingHandler(dir string, opts SiteOptions) *servingHandler {
+	opts = opts.Normalize()
+	return &servingHandler{
+		files:     newHandler(dir, opts),
+		dir:       dir,
+		basePath:  opts.BasePath,
+		startedAt: time.Now(),
+	}
+}
+
+func (h *servingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == StatusPath {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(h.health())
+		return
+	}
+
+	atomic.AddInt64(&h.requests, 1)
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	h.files.ServeHTTP(rec, r)
+	if rec.status == http.StatusNotFound {
+		atomic.AddInt64(&h.errors404, 1)
+	}
+}
+
+func (h *servingHandler) health() Health {
+	return Health{
+		ServingPath: h.dir,
+		StartedAt:   h.startedAt,
+		Requests:    atomic.LoadInt64(&h.requests),
+		Errors404:   atomic.LoadInt64(&h.errors404),
+		BasePath:    h.basePath,
+	}
+}
+
+// statusRecorder запоминает код ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Health возвращает состояние запущенного сервера; false — сервер остановлен
+func (m *ManagedServer) Health() (Health, bool) {
+	m.mu.Lock()
+	h := m.handler
+	m.mu.Unlock()
+	if h == nil {
+		return Health{}, false
+	}
+	return h.health(), true
+}
+
+// WaitReady опрашивает StatusPath, пока сервер не начнёт отвечать
+func (m *ManagedServer) WaitReady(timeout time.Duration) error {
+	st := m.Status()
+	if !st.Running {
+		return ErrNotRunning
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, st.URL+StatusPath, nil)
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server at %s not ready after %s", st.URL, timeout)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package server — общий локальный статический сервер для Wails- и Fyne-GUI.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPort      = 8080
+	DefaultPortRange = 10
+	shutdownTimeout  = 2 * time.Second
+)
+
+var (
+	ErrNoFreePort = errors.New("no free ports available")
+	ErrNotRunning = errors.New("server is not running")
+)
+
+// StartOptions — параметры запуска сервера
+type StartOptions struct {
+	Dir       string
+	Port      int // Желаемый порт, если занят — берём следующий свободный
+	PortRange int // Сколько портов пробовать начиная с Port
+	// Site — правила отдачи; nil — прочитать из папки сайта (LoadSiteOptions)
+	Site *SiteOptions
+}
+
+// Status — текущее состояние сервера
+type Status struct {
+	Running bool   `json:"running"`
+	URL     string `json:"url"`
+	Port    int    `json:"port"`
+	Dir     string `json:"dir"`
+}
+
+// ManagedServer управляет жизненным циклом http.Server:
+// выбор порта, запуск, graceful shutdown и состояние.
+type ManagedServer struct {
+	// OnError вызывается, если сервер упал уже после запуска
+	OnError func(err error, prev Status)
+
+	mu      sync.Mutex
+	srv     *http.Server
+	handler *servingHandler
+	status  Status
+}
+
+// New создаёт остановленный сервер
+func New() *ManagedServer {
+	return &ManagedServer{}
+}
+
+// Start запускает сервер, предварительно останавливая предыдущий.
+// Возвращает фактическое состояние: порт может отличаться от запрошенного.
+func (m *ManagedServer) Start(opts StartOptions) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.srv != nil {
+		m.stopLocked()
+	}
+
+	if _, err := os.Stat(opts.Dir); err != nil {
+		return Status{}, fmt.Errorf("missing: %s", opts.Dir)
+	}
+
+	port := opts.Port
+	if port <= 0 {
+		port = DefaultPort
+	}
+	portRange := opts.PortRange
+	if portRange <= 0 {
+		portRange = DefaultPortRange
+	}
+
+	// Порт занимаем сразу и им же обслуживаем: между проверкой и запуском
+	// его не успеет перехватить другой процесс
+	ln, err := listenFree(port, portRange)
+	if err != nil {
+		return Status{}, err
+	}
+	actualPort := ln.Addr().(*net.TCPAddr).Port
+
+	site := LoadSiteOptions(opts.Dir)
+	if opts.Site != nil {
+		site = opts.Site.Normalize()
+	}
+	handler := newServingHandler(filepath.ToSlash(opts.Dir), site)
+	srv := &http.Server{
+		Addr:    ln.Addr().String(),
+		Handler: handler,
+	}
+	m.srv = srv
+	m.handler = handler
+	m.status = Status{
+		Running: true,
+		URL:     fmt.Sprintf("http://localhost:%d", actualPort),
+		Port:    actualPort,
+		Dir:     filepath.ToSlash(opts.Dir),
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			m.mu.Lock()
+			if m.srv != srv {
+				m.mu.Unlock()
+				return
+			}
+			prev := m.status
+			m.srv = nil
+			m.handler = nil
+			m.status = Status{}
+			m.mu.Unlock()
+
+			if m.OnError != nil {
+				m.OnError(err, prev)
+			}
+		}
+	}()
+
+	return m.status, nil
+}
+
+// Stop останавливает сервер. Возвращает состояние до остановки;
+// ошибка означает, что graceful shutdown не удался и сервер закрыт принудительно.
+func (m *ManagedServer) Stop() (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.srv == nil {
+		return Status{}, ErrNotRunning
+	}
+	return m.stopLocked()
+}
+
+func (m *ManagedServer) stopLocked() (Status, error) {
+	s := m.srv
+	prev := m.status
+	m.srv = nil
+	m.handler = nil
+	m.status = Status{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		s.Close()
+		return prev, err
+	}
+	return prev, nil
+}
+
+// Status возвращает текущее состояние сервера
+func (m *ManagedServer) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// newHandler отдаёт файлы сайта. Для папки с index.html — в том числе созданным
+// процессором для раздела без своей страницы — отдаётся он, а не список файлов.
+func newHandler(dir string, opts SiteOptions) http.Handler {
+	return &siteHandler{dir: dir, opts: opts.Normalize(), files: http.FileServer(http.Dir(dir))}
+}
+
+// listenFree открывает первый свободный порт в диапазоне [startPort, startPort+n)
+// и возвращает уже привязанный listener
+func listenFree(startPort, n int) (net.Listener, error) {
+	var lastErr error
+	for port := startPort; port < startPort+n; port++ {
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return ln, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("%w in %d-%d: %v", ErrNoFreePort, startPort, startPort+n-1, lastErr)
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644)
+
+	srv := httptest.NewServer(newServingHandler(dir, SiteOptions{}))
+	defer srv.Close()
+
+	for _, p := range []string{"/", "/missing.css"} {
+		resp, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + StatusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var h Health
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	// Сам запрос статуса не считается
+	if h.Requests != 2 || h.Errors404 != 1 || h.ServingPath != dir || h.StartedAt.IsZero() {
+		t.Errorf("Unexpected status: %+v", h)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	m := New()
+	if err := m.WaitReady(0); err != ErrNotRunning {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+
+	st, err := m.Start(StartOptions{Dir: t.TempDir(), Port: 18080, PortRange: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	if err := m.WaitReady(3 * time.Second); err != nil {
+		t.Fatalf("Server on port %d not ready: %v", st.Port, err)
+	}
+	if _, ok := m.Health(); !ok {
+		t.Errorf("Health must be available while running")
+	}
+}
+
+func TestDirectoryPrefersIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "blog", "post-1"), 0755)
+	os.MkdirAll(filepath.Join(dir, "raw"), 0755)
+	os.WriteFile(filepath.Join(dir, "blog", "index.html"), []byte(`<meta name="generator" content="sitecloner-dirindex">`), 0644)
+	os.WriteFile(filepath.Join(dir, "raw", "file.txt"), []byte("x"), 0644)
+
+	srv := httptest.NewServer(newServingHandler(dir, SiteOptions{}))
+	defer srv.Close()
+
+	get := func(p string) string {
+		resp, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if body := get("/blog/"); !strings.Contains(body, "sitecloner-dirindex") {
+		t.Errorf("Expected generated index for /blog/, got %q", body)
+	}
+	if body := get("/raw/"); !strings.Contains(body, "file.txt") {
+		t.Errorf("Folder without index must still be listed, got %q", body)
+	}
+}
+
+func TestStartSkipsBusyPort(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	preferred := busy.Addr().(*net.TCPAddr).Port
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("ok"), 0644)
+
+	m := New()
+	st, err := m.Start(StartOptions{Dir: dir, Port: preferred, PortRange: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if st.Port == preferred {
+		t.Fatalf("Busy port %d reported as bound", preferred)
+	}
+
+	// Порт уже занят сервером к моменту возврата Start — окна для гонки нет
+	if ln, err := net.Listen("tcp", ":"+strconv.Itoa(st.Port)); err == nil {
+		ln.Close()
+		t.Fatalf("Port %d must already be bound by the server", st.Port)
+	}
+	resp, err := http.Get(st.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("Unexpected response from fallback port: %q", body)
+	}
+
+	// Весь диапазон занят — ошибка ErrNoFreePort, а не общий сбой
+	if _, err := New().Start(StartOptions{Dir: dir, Port: preferred, PortRange: 1}); !errors.Is(err, ErrNoFreePort) {
+		t.Errorf("Expected ErrNoFreePort, got %v", err)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.conf")
+
+func TestNginxConfigGolden(t *testing.T) {
+	cases := map[string]SiteOptions{
+		"nginx_default.conf":  {},
+		"nginx_base_404.conf": {BasePath: "docs", NotFoundPage: "/404.html"},
+		"nginx_spa.conf":      {SPAFallback: true, NotFoundPage: "errors/404.html"},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := NginxConfig(opts)
+			golden := filepath.Join("testdata", name)
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("nginx.conf differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestSiteOptionsRules(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "blog"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(dir, "about.html"), []byte("about"), 0644)
+	os.WriteFile(filepath.Join(dir, "blog", "index.html"), []byte("blog"), 0644)
+	os.WriteFile(filepath.Join(dir, "404.html"), []byte("custom 404"), 0644)
+	SaveSiteOptions(dir, SiteOptions{BasePath: "/docs", NotFoundPage: "404.html", SPAFallback: true})
+
+	h := newServingHandler(dir, LoadSiteOptions(dir+"_processed"))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	cases := []struct {
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"/", http.StatusFound, "", "/docs/"},
+		{"/docs", http.StatusFound, "", "/docs/"},
+		{"/docs/", http.StatusOK, "home", ""},
+		{"/docs/about", http.StatusOK, "about", ""},
+		{"/docs/blog", http.StatusMovedPermanently, "", "blog/"},
+		{"/docs/blog/", http.StatusOK, "blog", ""},
+		{"/docs/app/route", http.StatusOK, "home", ""},
+		{"/docs/missing.css", http.StatusNotFound, "custom 404", ""},
+		{"/other/page", http.StatusNotFound, "custom 404", ""},
+	}
+	for _, c := range cases {
+		resp, err := client.Get(srv.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || (c.body != "" && string(body) != c.body) {
+			t.Errorf("%s: got %d %q, want %d %q", c.path, resp.StatusCode, body, c.status, c.body)
+		}
+		if c.location != "" && resp.Header.Get("Location") != c.location {
+			t.Errorf("%s: redirect to %q, want %q", c.path, resp.Header.Get("Location"), c.location)
+		}
+	}
+	if got := h.health().BasePath; got != "/docs/" {
+		t.Errorf("Health must report base path, got %q", got)
+	}
+}
+
+func TestExportDockerBundle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example.com_processed")
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".cache"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644)
+	os.WriteFile(filepath.Join(dir, ".sitecloner.lock"), []byte("{}"), 0644)
+	os.WriteFile(filepath.Join(dir, ".cache", "x"), []byte("x"), 0644)
+	SaveSiteOptions(dir, SiteOptions{BasePath: "/docs/"})
+
+	out := t.TempDir()
+	if err := ExportDockerBundle(dir, out, ExportOptions{Compose: true, Port: 9000}); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"site/index.html", "site/css/app.css", "nginx.conf", "Dockerfile", "docker-compose.yml"} {
+		if _, err := os.Stat(filepath.Join(out, f)); err != nil {
+			t.Errorf("Missing %s in bundle", f)
+		}
+	}
+	for _, f := range []string{"site/.sitecloner.lock", "site/" + SiteOptionsFile, "site/.cache"} {
+		if _, err := os.Stat(filepath.Join(out, f)); err == nil {
+			t.Errorf("Service file %s must not be exported", f)
+		}
+	}
+	conf, _ := os.ReadFile(filepath.Join(out, "nginx.conf"))
+	if string(conf) != NginxConfig(SiteOptions{BasePath: "/docs/"}) {
+		t.Errorf("nginx.conf must follow site options:\n%s", conf)
+	}
+	docker, _ := os.ReadFile(filepath.Join(out, "Dockerfile"))
+	if !strings.Contains(string(docker), "COPY site/ /usr/share/nginx/html/docs/") {
+		t.Errorf("Dockerfile must copy the site under the base path:\n%s", docker)
+	}
+	compose, _ := os.ReadFile(filepath.Join(out, "docker-compose.yml"))
+	if !strings.Contains(string(compose), `"9000:80"`) {
+		t.Errorf("Unexpected docker-compose.yml:\n%s", compose)
+	}
+
+	if err := ExportDockerBundle(dir, filepath.Join(dir, "bundle"), ExportOptions{}); err == nil {
+		t.Errorf("Export into the site itself must fail")
+	}
+}
+
+func TestExportZip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example.com_processed")
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".cache"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0600)
+	os.WriteFile(filepath.Join(dir, ".sitecloner.lock"), []byte("{}"), 0644)
+	os.WriteFile(filepath.Join(dir, ".cache", "x"), []byte("x"), 0644)
+	modified := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, "css", "app.css"), modified, modified)
+
+	out := t.TempDir()
+	var progress []string
+	first := filepath.Join(out, "site.zip")
+	err := ExportZip(dir, first, ZipOptions{Root: "example.com", OnProgress: func(done, total int, file string) {
+		progress = append(progress, strconv.Itoa(done)+"/"+strconv.Itoa(total)+" "+file)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(progress, ",") != "1/2 css/app.css,2/2 index.html" {
+		t.Errorf("Unexpected progress: %v", progress)
+	}
+
+	zr, err := zip.OpenReader(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "example.com/css/app.css" && !f.Modified.Equal(modified) {
+			t.Errorf("Modtime not preserved: %v", f.Modified)
+		}
+		if f.Name == "example.com/css/app.css" && f.Mode().Perm() != 0600 {
+			t.Errorf("Mode not preserved: %v", f.Mode())
+		}
+	}
+	if strings.Join(names, ",") != "example.com/css/app.css,example.com/index.html" {
+		t.Errorf("Unexpected entries: %v", names)
+	}
+
+	// Тот же сайт — те же байты
+	second := filepath.Join(out, "again.zip")
+	if err := ExportZip(dir, second, ZipOptions{Root: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := os.ReadFile(first)
+	b, _ := os.ReadFile(second)
+	if !bytes.Equal(a, b) {
+		t.Errorf("Archive is not deterministic")
+	}
+	if _, err := os.Stat(first + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary file left behind")
+	}
+
+	if err := ExportZip(dir, filepath.Join(dir, "site.zip"), ZipOptions{}); err == nil {
+		t.Errorf("Archive inside the site must fail")
+	}
+	if err := ExportZip(dir, filepath.Join(out, "bad.zip"), ZipOptions{Root: "../up"}); err == nil {
+		t.Errorf("Root escaping the archive must fail")
+	}
+}
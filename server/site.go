@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SiteOptionsFile — настройки отдачи сайта, лежат в папке скачанного сайта
+const SiteOptionsFile = ".sitecloner-serve.json"
+
+// SiteOptions — правила отдачи сайта. Их выполняет встроенный сервер, и их же
+// экспорт переводит в nginx.conf, чтобы копия вела себя одинаково.
+type SiteOptions struct {
+	// BasePath — префикс URL, под которым живёт сайт ("/docs/"); "/" по умолчанию
+	BasePath string `json:"basePath,omitempty"`
+	// NotFoundPage — страница для 404, путь от корня сайта ("404.html")
+	NotFoundPage string `json:"notFoundPage,omitempty"`
+	// SPAFallback — неизвестные пути без расширения отдают index.html
+	SPAFallback bool `json:"spaFallback,omitempty"`
+}
+
+// Normalize приводит BasePath к виду "/x/", а NotFoundPage — к пути без "/" в начале
+func (o SiteOptions) Normalize() SiteOptions {
+	base := path.Clean("/" + strings.TrimSpace(o.BasePath))
+	if base != "/" {
+		base += "/"
+	}
+	o.BasePath = base
+	if o.NotFoundPage != "" {
+		o.NotFoundPage = strings.TrimPrefix(path.Clean("/"+o.NotFoundPage), "/")
+	}
+	return o
+}
+
+// LoadSiteOptions читает настройки сайта. Для обработанной копии
+// (<host>_processed) они берутся из папки исходника.
+func LoadSiteOptions(dir string) SiteOptions {
+	var o SiteOptions
+	for _, d := range []string{dir, strings.TrimSuffix(filepath.Clean(dir), "_processed")} {
+		data, err := os.ReadFile(filepath.Join(d, SiteOptionsFile))
+		if err == nil && json.Unmarshal(data, &o) == nil {
+			break
+		}
+	}
+	return o.Normalize()
+}
+
+// SaveSiteOptions записывает настройки в папку сайта
+func SaveSiteOptions(dir string, o SiteOptions) error {
+	data, err := json.MarshalIndent(o.Normalize(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, SiteOptionsFile), data, 0644)
+}
+
+// siteHandler отдаёт файлы по правилам SiteOptions, в том же порядке, что и
+// сгенерированный nginx.conf: файл, папка, <путь>.html, SPA, страница 404.
+type siteHandler struct {
+	dir   string
+	opts  SiteOptions
+	files http.Handler
+}
+
+func (h *siteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	if base := h.opts.BasePath; base != "/" {
+		switch {
+		case p == "/" || p == strings.TrimSuffix(base, "/"):
+			http.Redirect(w, r, base, http.StatusFound)
+			return
+		case !strings.HasPrefix(p, base):
+			h.notFound(w, r)
+			return
+		}
+		p = "/" + strings.TrimPrefix(p, base)
+	}
+
+	clean := path.Clean(p)
+	if h.exists(clean, true) {
+		h.serve(w, r, p)
+		return
+	}
+	if path.Ext(clean) == "" && clean != "/" {
+		if h.exists(clean+".html", false) {
+			h.serve(w, r, clean+".html")
+			return
+		}
+		if h.opts.SPAFallback && h.exists("/index.html", false) {
+			h.serveFile(w, r, "/index.html", http.StatusOK)
+			return
+		}
+	}
+	h.notFound(w, r)
+}
+
+// exists — есть ли файл (или папка, если dirOK) по пути сайта
+func (h *siteHandler) exists(p string, dirOK bool) bool {
+	fi, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(p)))
+	return err == nil && (dirOK || !fi.IsDir())
+}
+
+// serve отдаёт путь p через FileServer: редирект папки на слэш, index.html, листинг
+func (h *siteHandler) serve(w http.ResponseWriter, r *http.Request, p string) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	h.files.ServeHTTP(w, r2)
+}
+
+func (h *siteHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	if page := h.opts.NotFoundPage; page != "" && h.exists("/"+page, false) {
+		h.serveFile(w, r, "/"+page, http.StatusNotFound)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile отдаёт файл сайта с заданным кодом, без редиректов FileServer
+func (h *siteHandler) serveFile(w http.ResponseWriter, r *http.Request, p string, status int) {
+	data, err := os.ReadFile(filepath.Join(h.dir, filepath.FromSlash(p)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ZipOptions — параметры ExportZip
+type ZipOptions struct {
+	// Root — папка верхнего уровня в архиве ("example.com/index.html");
+	// пусто — файлы сайта лежат в корне архива
+	Root string
+
+	// OnProgress вызывается после каждого упакованного файла
+	OnProgress func(done, total int, file string)
+}
+
+// ExportZip пакует сайт в один zip. Архив детерминирован: файлы идут
+// в порядке путей, пути — через "/", права и время изменения берутся
+// у файлов, поэтому один и тот же сайт даёт одинаковые байты. Файлы
+// читаются потоком, сайт целиком в память не попадает. Служебные файлы
+// пропускаются, как при экспорте в Docker.
+func ExportZip(siteDir, dest string, opts ZipOptions) error {
+	if fi, err := os.Stat(siteDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("missing: %s", siteDir)
+	}
+	absSite, _ := filepath.Abs(siteDir)
+	absDest, _ := filepath.Abs(dest)
+	if strings.HasPrefix(absDest, absSite+string(filepath.Separator)) {
+		return fmt.Errorf("archive %s is inside the site", dest)
+	}
+	root := strings.Trim(filepath.ToSlash(opts.Root), "/")
+	if root != "" && (path.Clean(root) != root || root == ".." || strings.HasPrefix(root, "../")) {
+		return fmt.Errorf("invalid archive root %q", opts.Root)
+	}
+
+	files, err := siteFiles(siteDir)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(dest); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// Недописанный архив не должен выглядеть готовым
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for i, rel := range files {
+		name := rel
+		if root != "" {
+			name = path.Join(root, rel)
+		}
+		if err := addZipFile(zw, filepath.Join(siteDir, filepath.FromSlash(rel)), name); err != nil {
+			zw.Close()
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(i+1, len(files), rel)
+		}
+	}
+	err = zw.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// siteFiles — файлы сайта для экспорта (пути через "/", по порядку)
+func siteFiles(siteDir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(siteDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(siteDir, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// addZipFile дописывает файл в архив под именем name
+func addZipFile(zw *zip.Writer, src, name string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+	hdr.Modified = info.ModTime().UTC()
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	proccesor "sitemvp/processor"
+	"sync"
+)
+
+// settingsFile stores user settings that must survive restarts
+const settingsFile = "settings.json"
+
+// settingsDirName is the app folder under the user config directory
+const settingsDirName = "sitemvp"
+
+// configPath resolves an app file under os.UserConfigDir, so the GUI
+// finds the same file however it was launched: a packaged app may run
+// from / or a read-only bundle. Without a config directory it falls back
+// to the working directory, where older versions kept its files.
+func configPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(dir, settingsDirName, name)
+}
+
+// settingsPath is where settings.json is read and saved
+func settingsPath() string {
+	return configPath(settingsFile)
+}
+
+// AppSettings is the persisted part of the application configuration
+type AppSettings struct {
+	CustomPresets []proccesor.ProcessingPreset `json:"customPresets"`
+
+	// ScanConcurrency limits parallel library folder scans (icons, entry paths)
+	ScanConcurrency int `json:"scanConcurrency,omitempty"`
+
+	// SharedCache stores identical files of different sites once under
+	// downloads/.cache and hard-links them into each site. Off by default:
+	// a site folder with such links is less portable.
+	SharedCache bool `json:"sharedCache,omitempty"`
+
+	// ControlAPI serves the bookmarklet API on 127.0.0.1:ControlPort.
+	// Off by default; every request must carry ControlToken.
+	ControlAPI   bool   `json:"controlApi,omitempty"`
+	ControlToken string `json:"controlToken,omitempty"`
+	ControlPort  int    `json:"controlPort,omitempty"`
+}
+
+var settingsMu sync.Mutex
+
+func loadSettings() AppSettings {
+	var s AppSettings
+	data, err := os.ReadFile(settingsPath())
+	if os.IsNotExist(err) {
+		// Not saved since the move: pick up the old file from the working directory
+		data, err = os.ReadFile(settingsFile)
+	}
+	if err != nil {
+		return s
+	}
+	json.Unmarshal(data, &s)
+	return s
+}
+
+func saveSettings(s AppSettings) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := settingsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// GetPresets returns built-in presets followed by the user's custom ones
+func (a *App) GetPresets() []proccesor.ProcessingPreset {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	presets := append([]proccesor.ProcessingPreset{}, proccesor.BuiltinPresets...)
+	return append(presets, loadSettings().CustomPresets...)
+}
+
+// GetCustomPresets returns only the user's presets, for the settings screen
+func (a *App) GetCustomPresets() []proccesor.ProcessingPreset {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return loadSettings().CustomPresets
+}
+
+// SavePreset creates or replaces a custom preset
+func (a *App) SavePreset(preset proccesor.ProcessingPreset) string {
+	if preset.Name == "" {
+		return "Error: preset name is empty"
+	}
+	for _, b := range proccesor.BuiltinPresets {
+		if b.Name == preset.Name {
+			return "Error: built-in presets cannot be changed"
+		}
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	s := loadSettings()
+	replaced := false
+	for i, p := range s.CustomPresets {
+		if p.Name == preset.Name {
+			s.CustomPresets[i] = preset
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.CustomPresets = append(s.CustomPresets, preset)
+	}
+	if err := saveSettings(s); err != nil {
+		return "Error: " + err.Error()
+	}
+	return "Saved"
+}
+
+// DeletePreset removes a custom preset
+func (a *App) DeletePreset(name string) string {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	s := loadSettings()
+	var kept []proccesor.ProcessingPreset
+	for _, p := range s.CustomPresets {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	s.CustomPresets = kept
+	if err := saveSettings(s); err != nil {
+		return "Error: " + err.Error()
+	}
+	return "Deleted"
+}
+
+// resolvePreset finds a preset by name (default: DefaultPresetName) and applies overrides
+func (a *App) resolvePreset(name string, overrides proccesor.PresetOverrides) (proccesor.ProcessingPreset, bool) {
+	if name == "" {
+		name = proccesor.DefaultPresetName
+	}
+	settingsMu.Lock()
+	custom := loadSettings().CustomPresets
+	settingsMu.Unlock()
+
+	preset, ok := proccesor.FindPreset(name, custom)
+	if !ok {
+		return preset, false
+	}
+	return preset.WithOverrides(overrides), true
+}
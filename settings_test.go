@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proccesor "sitemvp/processor"
+)
+
+func TestSettingsRoundTrip(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	t.Setenv("AppData", config)
+	// The working directory must not matter
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	want := AppSettings{
+		CustomPresets:   []proccesor.ProcessingPreset{{Name: "mine"}},
+		ScanConcurrency: 3,
+		SharedCache:     true,
+		ControlPort:     9000,
+	}
+	if err := saveSettings(want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(settingsFile); !os.IsNotExist(err) {
+		t.Errorf("settings.json written to the working directory: %v", err)
+	}
+	if _, err := os.Stat(settingsPath()); err != nil || !strings.HasPrefix(settingsPath(), config) {
+		t.Errorf("settings.json not under the config dir: %s %v", settingsPath(), err)
+	}
+	if cache := configPath(libraryCacheFile); filepath.Dir(cache) != filepath.Dir(settingsPath()) {
+		t.Errorf("Library cache %s must sit next to settings.json", cache)
+	}
+
+	os.Chdir(t.TempDir())
+	if got := loadSettings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loadSettings() = %+v, want %+v", got, want)
+	}
+	if got := (&App{}).GetCustomPresets(); !reflect.DeepEqual(got, want.CustomPresets) {
+		t.Errorf("GetCustomPresets() = %+v, want %+v", got, want.CustomPresets)
+	}
+}
+
+func TestSettingsFromWorkingDirectory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	// A file left by an older version is still read until the first save
+	os.WriteFile(settingsFile, []byte(`{"scanConcurrency":5}`), 0644)
+	if got := loadSettings(); got.ScanConcurrency != 5 {
+		t.Errorf("Old settings.json not read: %+v", got)
+	}
+}